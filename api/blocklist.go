@@ -0,0 +1,85 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/httpauth/auth"
+	"gitlab.com/lightmeter/controlcenter/httpmiddleware"
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	"gitlab.com/lightmeter/controlcenter/pkg/httperror"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+type blocklistHandler struct {
+	f core.Fetcher
+}
+
+// @Summary Export the IPs reported by insights as ones which should be blocked, one per line
+// @Produce plain
+// @Param from query string true "Initial date in the format 1999-12-23"
+// @Param to   query string true "Final date in the format 1999-12-23"
+// @Success 200 {string} string "desc"
+// @Failure 422 {string} string "desc"
+// @Router /api/v0/blocklist [get]
+func (h blocklistHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	interval := httpmiddleware.GetIntervalFromContext(r)
+
+	fetchedInsights, err := h.f.FetchInsights(r.Context(), core.FetchOptions{
+		Interval: interval,
+		OrderBy:  core.OrderByCreationAsc,
+	}, timeutil.RealClock{})
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+	}
+
+	ips := map[string]struct{}{}
+
+	for _, fi := range fetchedInsights {
+		provider, ok := fi.Content().(core.BlockedIPsProvider)
+		if !ok {
+			continue
+		}
+
+		for _, ip := range provider.BlockedIPs() {
+			// normalizes the addresses, and skips anything which cannot be used in a firewall
+			if parsed := net.ParseIP(ip); parsed != nil {
+				ips[parsed.String()] = struct{}{}
+			}
+		}
+	}
+
+	list := make([]string, 0, len(ips))
+
+	for ip := range ips {
+		list = append(list, ip)
+	}
+
+	sort.Strings(list)
+
+	w.Header()["Content-Type"] = []string{"text/plain"}
+
+	if len(list) == 0 {
+		return nil
+	}
+
+	if _, err := w.Write([]byte(strings.Join(list, "\n") + "\n")); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func HttpBlocklist(auth *auth.Authenticator, mux *http.ServeMux, timezone *time.Location, f core.Fetcher) {
+	mux.Handle("/api/v0/blocklist",
+		httpmiddleware.WithDefaultStack(auth, httpmiddleware.RequestWithInterval(timezone)).
+			WithEndpoint(blocklistHandler{f: f}))
+}
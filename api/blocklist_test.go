@@ -0,0 +1,84 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+	"gitlab.com/lightmeter/controlcenter/httpmiddleware"
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	mock_insights_fetcher "gitlab.com/lightmeter/controlcenter/insights/core/mock"
+)
+
+type blockedIPsContent struct {
+	content
+	ips []string
+}
+
+func (c blockedIPsContent) BlockedIPs() []string {
+	return c.ips
+}
+
+func TestBlocklist(t *testing.T) {
+	Convey("Test Blocklist", t, func() {
+		chain := httpmiddleware.New(httpmiddleware.RequestWithInterval(time.UTC))
+
+		ctrl := gomock.NewController(t)
+
+		defer ctrl.Finish()
+
+		f := mock_insights_fetcher.NewMockFetcher(ctrl)
+
+		s := httptest.NewServer(chain.WithEndpoint(blocklistHandler{f: f}))
+		defer s.Close()
+
+		expectedOptions := core.FetchOptions{
+			Interval: parseTimeInterval(`1999-01-01`, `1999-12-31`),
+			OrderBy:  core.OrderByCreationAsc,
+		}
+
+		Convey("Missing mandatory arguments", func() {
+			r, err := http.Get(s.URL)
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusUnprocessableEntity)
+		})
+
+		Convey("No insights", func() {
+			f.EXPECT().FetchInsights(gomock.Any(), expectedOptions, gomock.Any()).Return([]core.FetchedInsight{}, nil)
+
+			r, err := http.Get(fmt.Sprintf("%s?from=1999-01-01&to=1999-12-31", s.URL))
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+			body, err := ioutil.ReadAll(r.Body)
+			So(err, ShouldBeNil)
+			So(string(body), ShouldEqual, "")
+		})
+
+		Convey("IPs from many insights, sorted and without duplicates", func() {
+			f.EXPECT().FetchInsights(gomock.Any(), expectedOptions, gomock.Any()).Return([]core.FetchedInsight{
+				&fakeFetchedInsight{id: 1, contentType: "blockedips", content: blockedIPsContent{ips: []string{"55.44.33.22", "11.22.33.44"}}},
+				&fakeFetchedInsight{id: 2, contentType: "local_rbl_check", content: content{"content", "local_rbl_check"}},
+				&fakeFetchedInsight{id: 3, contentType: "directory_harvest", content: blockedIPsContent{ips: []string{"11.22.33.44", "2001:0db8::1", "invalid"}}},
+			}, nil)
+
+			r, err := http.Get(fmt.Sprintf("%s?from=1999-01-01&to=1999-12-31", s.URL))
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusOK)
+			So(r.Header.Get("Content-Type"), ShouldEqual, "text/plain")
+
+			body, err := ioutil.ReadAll(r.Body)
+			So(err, ShouldBeNil)
+			So(string(body), ShouldEqual, "11.22.33.44\n2001:db8::1\n55.44.33.22\n")
+		})
+	})
+}
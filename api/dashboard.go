@@ -6,6 +6,7 @@ package api
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
@@ -32,6 +33,8 @@ type countByStatusResult map[string]int
 // @Summary Count By Status
 // @Param from query string true "Initial date in the format 1999-12-23"
 // @Param to   query string true "Final date in the format 1999-12-23"
+// @Param compare query string false "Compare with a baseline period: previous or weeks"
+// @Param weeks query integer false "When comparing with weeks, how many weeks ago is the baseline period"
 // @Produce json
 // @Success 200 {object} countByStatusResult "desc"
 // @Failure 422 {string} string "desc"
@@ -39,39 +42,116 @@ type countByStatusResult map[string]int
 func (h countByStatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
 	interval := httpmiddleware.GetIntervalFromContext(r)
 
-	sent, err := h.dashboard.CountByStatus(r.Context(), parser.SentStatus, interval)
+	return serveWithOptionalComparison(w, r, interval, h.countByStatus, func(current, baseline countByStatusResult) map[string]dashboard.Delta {
+		result := map[string]dashboard.Delta{}
+
+		for k, v := range current {
+			result[k] = dashboard.NewDelta(float64(v), float64(baseline[k]))
+		}
+
+		return result
+	})
+}
+
+func (h countByStatusHandler) countByStatus(ctx context.Context, interval timeutil.TimeInterval) (countByStatusResult, error) {
+	sent, err := h.dashboard.CountByStatus(ctx, parser.SentStatus, interval)
 	if err != nil {
-		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, err)
+		return nil, httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, err)
 	}
 
-	deferred, err := h.dashboard.CountByStatus(r.Context(), parser.DeferredStatus, interval)
+	deferred, err := h.dashboard.CountByStatus(ctx, parser.DeferredStatus, interval)
 	if err != nil {
-		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, err)
+		return nil, httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, err)
 	}
 
-	bounced, err := h.dashboard.CountByStatus(r.Context(), parser.BouncedStatus, interval)
+	bounced, err := h.dashboard.CountByStatus(ctx, parser.BouncedStatus, interval)
 	if err != nil {
-		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, err)
+		return nil, httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, err)
 	}
 
-	return httputil.WriteJson(w, countByStatusResult{
+	return countByStatusResult{
 		"sent":     sent,
 		"deferred": deferred,
 		"bounced":  bounced,
-	}, http.StatusOK)
+	}, nil
 }
 
-func servePairsFromTimeInterval(
+// comparisonResult is returned instead of the plain result when a comparison is requested
+type comparisonResult[T any, C any] struct {
+	Current          T                     `json:"current"`
+	Baseline         T                     `json:"baseline"`
+	BaselineInterval timeutil.TimeInterval `json:"baseline_interval"`
+	Comparison       C                     `json:"comparison"`
+}
+
+// comparisonFromRequest returns nil if no comparison has been requested
+func comparisonFromRequest(r *http.Request) (*dashboard.Comparison, error) {
+	kind := r.Form.Get("compare")
+	if len(kind) == 0 {
+		return nil, nil
+	}
+
+	weeks := 0
+
+	if w := r.Form.Get("weeks"); len(w) > 0 {
+		var err error
+
+		if weeks, err = strconv.Atoi(w); err != nil {
+			return nil, httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+		}
+	}
+
+	comparison, err := dashboard.ParseComparison(kind, weeks)
+	if err != nil {
+		return nil, httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+	}
+
+	return &comparison, nil
+}
+
+// serveWithOptionalComparison writes the result of query on interval, also running it on the baseline
+// interval and comparing both results if the request asks for it
+func serveWithOptionalComparison[T any, C any](
 	w http.ResponseWriter,
 	r *http.Request,
-	f func(context.Context, timeutil.TimeInterval) (dashboard.Pairs, error),
-	interval timeutil.TimeInterval) error {
-	pairs, err := f(r.Context(), interval)
+	interval timeutil.TimeInterval,
+	query func(context.Context, timeutil.TimeInterval) (T, error),
+	compare func(current, baseline T) C) error {
+	comparison, err := comparisonFromRequest(r)
+	if err != nil {
+		return err
+	}
+
+	current, err := query(r.Context(), interval)
 	if err != nil {
 		return err
 	}
 
-	return httputil.WriteJson(w, pairs, http.StatusOK)
+	if comparison == nil {
+		return httputil.WriteJson(w, current, http.StatusOK)
+	}
+
+	baselineInterval := comparison.BaselineInterval(interval)
+
+	baseline, err := query(r.Context(), baselineInterval)
+	if err != nil {
+		return err
+	}
+
+	return httputil.WriteJson(w, comparisonResult[T, C]{
+		Current:          current,
+		Baseline:         baseline,
+		BaselineInterval: baselineInterval,
+		Comparison:       compare(current, baseline),
+	}, http.StatusOK)
+}
+
+func servePairsFromTimeInterval(
+	w http.ResponseWriter,
+	r *http.Request,
+	f func(context.Context, timeutil.TimeInterval) (dashboard.Pairs, error),
+	interval timeutil.TimeInterval) error {
+	return serveWithOptionalComparison(w, r, interval, f, dashboard.ComparePairs)
 }
 
 type topBusiestDomainsHandler handler
@@ -79,6 +159,8 @@ type topBusiestDomainsHandler handler
 // @Summary Top Busiest Domains
 // @Param from query string true "Initial date in the format 1999-12-23"
 // @Param to   query string true "Final date in the format 1999-12-23"
+// @Param compare query string false "Compare with a baseline period: previous or weeks"
+// @Param weeks query integer false "When comparing with weeks, how many weeks ago is the baseline period"
 // @Produce json
 // @Success 200 {object} dashboard.Pairs
 // @Failure 422 {string} string "desc"
@@ -93,6 +175,8 @@ type topBouncedDomainsHandler handler
 // @Summary Top Bounced Domains
 // @Param from query string true "Initial date in the format 1999-12-23"
 // @Param to   query string true "Final date in the format 1999-12-23"
+// @Param compare query string false "Compare with a baseline period: previous or weeks"
+// @Param weeks query integer false "When comparing with weeks, how many weeks ago is the baseline period"
 // @Produce json
 // @Success 200 {object} dashboard.Pairs
 // @Failure 422 {string} string "desc"
@@ -107,6 +191,8 @@ type topDeferredDomainsHandler handler
 // @Summary Top Deferred Domains
 // @Param from query string true "Initial date in the format 1999-12-23"
 // @Param to   query string true "Final date in the format 1999-12-23"
+// @Param compare query string false "Compare with a baseline period: previous or weeks"
+// @Param weeks query integer false "When comparing with weeks, how many weeks ago is the baseline period"
 // @Produce json
 // @Success 200 {object} dashboard.Pairs
 // @Failure 422 {string} string "desc"
@@ -121,6 +207,8 @@ type deliveryStatusHandler handler
 // @Summary Delivery Status
 // @Param from query string true "Initial date in the format 1999-12-23"
 // @Param to   query string true "Final date in the format 1999-12-23"
+// @Param compare query string false "Compare with a baseline period: previous or weeks"
+// @Param weeks query integer false "When comparing with weeks, how many weeks ago is the baseline period"
 // @Produce json
 // @Success 200 {object} dashboard.Pairs
 // @Failure 422 {string} string "desc"
@@ -137,6 +225,8 @@ type trafficBySenderOverTimeHandler struct {
 // @Summary Messages sent by mailbox over time
 // @Param from query string true "Initial date in the format 1999-12-23"
 // @Param to   query string true "Final date in the format 1999-12-23"
+// @Param compare query string false "Compare with a baseline period: previous or weeks"
+// @Param weeks query integer false "When comparing with weeks, how many weeks ago is the baseline period"
 // @Param granularity query integer 12 "Time granularity in hours"
 // @Produce json
 // @Success 200 {object} dashboard.MailTrafficPerSenderOverTimeResult
@@ -146,6 +236,8 @@ type trafficBySenderOverTimeHandler struct {
 // @Summary Messages bounced by mailbox over time
 // @Param from query string true "Initial date in the format 1999-12-23"
 // @Param to   query string true "Final date in the format 1999-12-23"
+// @Param compare query string false "Compare with a baseline period: previous or weeks"
+// @Param weeks query integer false "When comparing with weeks, how many weeks ago is the baseline period"
 // @Param granularity query integer 12 "Time granularity in hours"
 // @Produce json
 // @Success 200 {object} dashboard.MailTrafficPerSenderOverTimeResult
@@ -155,6 +247,8 @@ type trafficBySenderOverTimeHandler struct {
 // @Summary Messages deferred by mailbox over time
 // @Param from query string true "Initial date in the format 1999-12-23"
 // @Param to   query string true "Final date in the format 1999-12-23"
+// @Param compare query string false "Compare with a baseline period: previous or weeks"
+// @Param weeks query integer false "When comparing with weeks, how many weeks ago is the baseline period"
 // @Param granularity query integer 12 "Time granularity in hours"
 // @Produce json
 // @Success 200 {object} dashboard.MailTrafficPerSenderOverTimeResult
@@ -164,6 +258,8 @@ type trafficBySenderOverTimeHandler struct {
 // @Summary Messages expired by mailbox over time
 // @Param from query string true "Initial date in the format 1999-12-23"
 // @Param to   query string true "Final date in the format 1999-12-23"
+// @Param compare query string false "Compare with a baseline period: previous or weeks"
+// @Param weeks query integer false "When comparing with weeks, how many weeks ago is the baseline period"
 // @Param granularity query integer 12 "Time granularity in hours"
 // @Produce json
 // @Success 200 {object} dashboard.MailTrafficPerSenderOverTimeResult
@@ -173,6 +269,8 @@ type trafficBySenderOverTimeHandler struct {
 // @Summary Messages received by mailbox over time
 // @Param from query string true "Initial date in the format 1999-12-23"
 // @Param to   query string true "Final date in the format 1999-12-23"
+// @Param compare query string false "Compare with a baseline period: previous or weeks"
+// @Param weeks query integer false "When comparing with weeks, how many weeks ago is the baseline period"
 // @Param granularity query integer 12 "Time granularity in hours"
 // @Produce json
 // @Success 200 {object} dashboard.MailTrafficPerSenderOverTimeResult
@@ -182,6 +280,8 @@ type trafficBySenderOverTimeHandler struct {
 // @Summary Number of inbound replies
 // @Param from query string true "Initial date in the format 1999-12-23"
 // @Param to   query string true "Final date in the format 1999-12-23"
+// @Param compare query string false "Compare with a baseline period: previous or weeks"
+// @Param weeks query integer false "When comparing with weeks, how many weeks ago is the baseline period"
 // @Param granularity query integer 12 "Time granularity in hours"
 // @Produce json
 // @Success 200 {object} dashboard.MailTrafficPerSenderOverTimeResult
@@ -196,12 +296,111 @@ func (h trafficBySenderOverTimeHandler) ServeHTTP(w http.ResponseWriter, r *http
 		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
 	}
 
-	result, err := h.f(r.Context(), interval, granularity)
+	query := func(ctx context.Context, interval timeutil.TimeInterval) (dashboard.MailTrafficPerSenderOverTimeResult, error) {
+		return h.f(ctx, interval, granularity)
+	}
+
+	return serveWithOptionalComparison(w, r, interval, query, dashboard.CompareTrafficPerSender)
+}
+
+type deliveryDelayPercentilesHandler handler
+
+// @Summary Percentiles 50, 90 and 99 of each component of the delivery delay, in seconds, over time
+// @Param from query string true "Initial date in the format 1999-12-23"
+// @Param to   query string true "Final date in the format 1999-12-23"
+// @Param compare query string false "Compare with a baseline period: previous or weeks"
+// @Param weeks query integer false "When comparing with weeks, how many weeks ago is the baseline period"
+// @Param granularity query integer 12 "Time granularity in hours"
+// @Produce json
+// @Success 200 {object} dashboard.DelayPercentilesOverTimeResult
+// @Failure 422 {string} string "desc"
+// @Router /api/v0/deliveryDelayPercentiles [get]
+func (h deliveryDelayPercentilesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	interval := httpmiddleware.GetIntervalFromContext(r)
+
+	granularity, err := strconv.Atoi(r.Form.Get("granularity"))
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+	}
+
+	query := func(ctx context.Context, interval timeutil.TimeInterval) (dashboard.DelayPercentilesOverTimeResult, error) {
+		result, err := h.dashboard.DelayPercentilesOverTime(ctx, interval, granularity)
+		if err != nil && errors.Is(err, dashboard.ErrInvalidGranularity) {
+			return result, httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+		}
+
+		if err != nil {
+			return result, httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, err)
+		}
+
+		return result, nil
+	}
+
+	comparison, err := comparisonFromRequest(r)
 	if err != nil {
 		return err
 	}
 
-	return httputil.WriteJson(w, result, http.StatusOK)
+	compare := func(current, baseline dashboard.DelayPercentilesOverTimeResult) map[string]dashboard.PercentilesSeriesDelta {
+		// the times in the baseline are moved to the ones of the current period
+		offset := interval.From.Unix() - comparison.BaselineInterval(interval).From.Unix()
+
+		return dashboard.CompareDelayPercentilesOverTime(current, baseline, offset, granularity)
+	}
+
+	return serveWithOptionalComparison(w, r, interval, query, compare)
+}
+
+type deliveryDelayPercentilesByDomainHandler handler
+
+// @Summary Percentiles 50, 90 and 99 of each component of the delivery delay, in seconds, for the busiest recipient domains
+// @Param from query string true "Initial date in the format 1999-12-23"
+// @Param to   query string true "Final date in the format 1999-12-23"
+// @Param compare query string false "Compare with a baseline period: previous or weeks"
+// @Param weeks query integer false "When comparing with weeks, how many weeks ago is the baseline period"
+// @Produce json
+// @Success 200 {object} []dashboard.DomainDelayPercentiles
+// @Failure 422 {string} string "desc"
+// @Router /api/v0/deliveryDelayPercentilesByDomain [get]
+func (h deliveryDelayPercentilesByDomainHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	interval := httpmiddleware.GetIntervalFromContext(r)
+
+	query := func(ctx context.Context, interval timeutil.TimeInterval) ([]dashboard.DomainDelayPercentiles, error) {
+		result, err := h.dashboard.DelayPercentilesByDomain(ctx, interval)
+		if err != nil {
+			return nil, httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, err)
+		}
+
+		return result, nil
+	}
+
+	return serveWithOptionalComparison(w, r, interval, query, dashboard.CompareDelayPercentilesByDomain)
+}
+
+type volumeBySendingIPHandler handler
+
+// @Summary Number of outbound messages by local sending IP and delivery status, where the address is empty when unknown
+// @Param from query string true "Initial date in the format 1999-12-23"
+// @Param to   query string true "Final date in the format 1999-12-23"
+// @Param compare query string false "Compare with a baseline period: previous or weeks"
+// @Param weeks query integer false "When comparing with weeks, how many weeks ago is the baseline period"
+// @Produce json
+// @Success 200 {object} []dashboard.SendingIPVolume
+// @Failure 422 {string} string "desc"
+// @Router /api/v0/volumeBySendingIP [get]
+func (h volumeBySendingIPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	interval := httpmiddleware.GetIntervalFromContext(r)
+
+	query := func(ctx context.Context, interval timeutil.TimeInterval) ([]dashboard.SendingIPVolume, error) {
+		result, err := h.dashboard.VolumeBySendingIP(ctx, interval)
+		if err != nil {
+			return nil, httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, err)
+		}
+
+		return result, nil
+	}
+
+	return serveWithOptionalComparison(w, r, interval, query, dashboard.CompareVolumeBySendingIP)
 }
 
 type appVersionHandler struct{}
@@ -240,5 +439,8 @@ func HttpDashboard(auth *auth.Authenticator, mux *http.ServeMux, timezone *time.
 	mux.Handle("/api/v0/topBouncedDomains", authenticated.WithEndpoint(topBouncedDomainsHandler{d}))
 	mux.Handle("/api/v0/topDeferredDomains", authenticated.WithEndpoint(topDeferredDomainsHandler{d}))
 	mux.Handle("/api/v0/deliveryStatus", authenticated.WithEndpoint(deliveryStatusHandler{d}))
+	mux.Handle("/api/v0/deliveryDelayPercentiles", authenticated.WithEndpoint(deliveryDelayPercentilesHandler{d}))
+	mux.Handle("/api/v0/deliveryDelayPercentilesByDomain", authenticated.WithEndpoint(deliveryDelayPercentilesByDomainHandler{d}))
+	mux.Handle("/api/v0/volumeBySendingIP", authenticated.WithEndpoint(volumeBySendingIPHandler{d}))
 	mux.Handle("/api/v0/appVersion", unauthenticated.WithEndpoint(appVersionHandler{}))
 }
@@ -67,6 +67,49 @@ func TestDashboard(t *testing.T) {
 			expected := map[string]interface{}{"bounced": float64(2), "deferred": float64(3), "sent": float64(4)}
 			So(body, ShouldResemble, expected)
 		})
+
+		Convey("Invalid comparison", func() {
+			s := httptest.NewServer(chain.WithEndpoint((countByStatusHandler{dashboard: m})))
+
+			for _, q := range []string{"compare=yesterday", "compare=weeks", "compare=weeks&weeks=a"} {
+				r, err := http.Get(fmt.Sprintf("%s?from=1999-01-01&to=1999-12-31&%s", s.URL, q))
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusUnprocessableEntity)
+			}
+		})
+
+		Convey("Compare with the same period two weeks ago", func() {
+			interval, err := timeutil.ParseTimeInterval("2000-01-15", "2000-01-21", time.UTC)
+			So(err, ShouldBeNil)
+
+			baseline, err := timeutil.ParseTimeInterval("2000-01-01", "2000-01-07", time.UTC)
+			So(err, ShouldBeNil)
+
+			m.EXPECT().CountByStatus(gomock.Any(), parser.SentStatus, interval).Return(15, nil)
+			m.EXPECT().CountByStatus(gomock.Any(), parser.DeferredStatus, interval).Return(3, nil)
+			m.EXPECT().CountByStatus(gomock.Any(), parser.BouncedStatus, interval).Return(2, nil)
+			m.EXPECT().CountByStatus(gomock.Any(), parser.SentStatus, baseline).Return(10, nil)
+			m.EXPECT().CountByStatus(gomock.Any(), parser.DeferredStatus, baseline).Return(3, nil)
+			m.EXPECT().CountByStatus(gomock.Any(), parser.BouncedStatus, baseline).Return(0, nil)
+
+			s := httptest.NewServer(chain.WithEndpoint((countByStatusHandler{dashboard: m})))
+			r, err := http.Get(fmt.Sprintf("%s?from=2000-01-15&to=2000-01-21&compare=weeks&weeks=2", s.URL))
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+			var body comparisonResult[countByStatusResult, map[string]dashboard.Delta]
+			So(json.NewDecoder(r.Body).Decode(&body), ShouldBeNil)
+
+			So(body.Current, ShouldResemble, countByStatusResult{"sent": 15, "deferred": 3, "bounced": 2})
+			So(body.Baseline, ShouldResemble, countByStatusResult{"sent": 10, "deferred": 3, "bounced": 0})
+			So(body.BaselineInterval.From.Unix(), ShouldEqual, baseline.From.Unix())
+			So(body.BaselineInterval.To.Unix(), ShouldEqual, baseline.To.Unix())
+			So(body.Comparison, ShouldResemble, map[string]dashboard.Delta{
+				"sent":     dashboard.NewDelta(15, 10),
+				"deferred": dashboard.NewDelta(3, 3),
+				"bounced":  dashboard.NewDelta(2, 0),
+			})
+		})
 	})
 
 	Convey("DeliveryStatus", t, func() {
@@ -100,6 +143,30 @@ func TestDashboard(t *testing.T) {
 			So(body, ShouldResemble, expected)
 		})
 
+		Convey("Compare with the previous period", func() {
+			m.EXPECT().DeliveryStatus(gomock.Any(), timeutil.TimeInterval{
+				From: testutil.MustParseTime(`2000-01-01 00:00:00 +0000`),
+				To:   testutil.MustParseTime(`2000-01-02 23:59:59 +0000`),
+			}).Return(dashboard.Pairs{dashboard.Pair{Key: "sent", Value: 9}}, nil)
+
+			m.EXPECT().DeliveryStatus(gomock.Any(), timeutil.TimeInterval{
+				From: testutil.MustParseTime(`1999-12-30 00:00:00 +0000`),
+				To:   testutil.MustParseTime(`1999-12-31 23:59:59 +0000`),
+			}).Return(dashboard.Pairs{dashboard.Pair{Key: "sent", Value: 6}, dashboard.Pair{Key: "bounced", Value: 1}}, nil)
+
+			r, err := http.Get(fmt.Sprintf("%s?from=2000-01-01&to=2000-01-02&compare=previous", s.URL))
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+			var body comparisonResult[interface{}, []dashboard.PairDelta]
+			So(json.NewDecoder(r.Body).Decode(&body), ShouldBeNil)
+
+			So(body.Comparison, ShouldResemble, []dashboard.PairDelta{
+				{Key: "sent", Delta: dashboard.NewDelta(9, 6)},
+				{Key: "bounced", Delta: dashboard.NewDelta(0, 1)},
+			})
+		})
+
 		Convey("Internal error", func() {
 			m.EXPECT().DeliveryStatus(gomock.Any(), timeutil.TimeInterval{
 				From: testutil.MustParseTime(`2000-01-01 00:00:00 +0000`),
@@ -113,5 +180,91 @@ func TestDashboard(t *testing.T) {
 		})
 	})
 
+	Convey("DeliveryDelayPercentiles", t, func() {
+		s := httptest.NewServer(chain.WithEndpoint(deliveryDelayPercentilesHandler{dashboard: m}))
+
+		interval := timeutil.TimeInterval{
+			From: testutil.MustParseTime(`2000-01-01 00:00:00 +0000`),
+			To:   testutil.MustParseTime(`2000-01-02 23:59:59 +0000`),
+		}
+
+		Convey("Missing granularity", func() {
+			r, err := http.Get(fmt.Sprintf("%s?from=2000-01-01&to=2000-01-02", s.URL))
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusUnprocessableEntity)
+		})
+
+		Convey("Invalid granularity", func() {
+			m.EXPECT().DelayPercentilesOverTime(gomock.Any(), interval, 0).Return(dashboard.DelayPercentilesOverTimeResult{}, dashboard.ErrInvalidGranularity)
+
+			r, err := http.Get(fmt.Sprintf("%s?from=2000-01-01&to=2000-01-02&granularity=0", s.URL))
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusUnprocessableEntity)
+		})
+
+		Convey("Success", func() {
+			m.EXPECT().DelayPercentilesOverTime(gomock.Any(), interval, 24).Return(dashboard.DelayPercentilesOverTimeResult{
+				Times:  []int64{946684800},
+				Counts: []int64{3},
+				Values: map[string]dashboard.PercentilesSeries{
+					dashboard.DelayTotal: {P50: []float64{1.5}, P90: []float64{3}, P99: []float64{3}},
+				},
+			}, nil)
+
+			r, err := http.Get(fmt.Sprintf("%s?from=2000-01-01&to=2000-01-02&granularity=24", s.URL))
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+			var body dashboard.DelayPercentilesOverTimeResult
+			So(json.NewDecoder(r.Body).Decode(&body), ShouldBeNil)
+			So(body.Counts, ShouldResemble, []int64{3})
+			So(body.Values[dashboard.DelayTotal].P90, ShouldResemble, []float64{3})
+		})
+	})
+
+	Convey("DeliveryDelayPercentilesByDomain", t, func() {
+		s := httptest.NewServer(chain.WithEndpoint(deliveryDelayPercentilesByDomainHandler{dashboard: m}))
+
+		m.EXPECT().DelayPercentilesByDomain(gomock.Any(), timeutil.TimeInterval{
+			From: testutil.MustParseTime(`2000-01-01 00:00:00 +0000`),
+			To:   testutil.MustParseTime(`2000-01-02 23:59:59 +0000`),
+		}).Return([]dashboard.DomainDelayPercentiles{
+			{Domain: "example.com", Count: 2, Delays: dashboard.DelayPercentiles{dashboard.DelaySMTP: {P50: 1, P90: 2, P99: 2}}},
+		}, nil)
+
+		r, err := http.Get(fmt.Sprintf("%s?from=2000-01-01&to=2000-01-02", s.URL))
+		So(err, ShouldBeNil)
+		So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+		var body []dashboard.DomainDelayPercentiles
+		So(json.NewDecoder(r.Body).Decode(&body), ShouldBeNil)
+		So(body, ShouldResemble, []dashboard.DomainDelayPercentiles{
+			{Domain: "example.com", Count: 2, Delays: dashboard.DelayPercentiles{dashboard.DelaySMTP: {P50: 1, P90: 2, P99: 2}}},
+		})
+	})
+
+	Convey("VolumeBySendingIP", t, func() {
+		s := httptest.NewServer(chain.WithEndpoint(volumeBySendingIPHandler{dashboard: m}))
+
+		m.EXPECT().VolumeBySendingIP(gomock.Any(), timeutil.TimeInterval{
+			From: testutil.MustParseTime(`2000-01-01 00:00:00 +0000`),
+			To:   testutil.MustParseTime(`2000-01-02 23:59:59 +0000`),
+		}).Return([]dashboard.SendingIPVolume{
+			{Address: "127.0.0.2", Total: 3, Sent: 2, Bounced: 1},
+			{Address: "", Total: 1, Deferred: 1},
+		}, nil)
+
+		r, err := http.Get(fmt.Sprintf("%s?from=2000-01-01&to=2000-01-02", s.URL))
+		So(err, ShouldBeNil)
+		So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+		var body []dashboard.SendingIPVolume
+		So(json.NewDecoder(r.Body).Decode(&body), ShouldBeNil)
+		So(body, ShouldResemble, []dashboard.SendingIPVolume{
+			{Address: "127.0.0.2", Total: 3, Sent: 2, Bounced: 1},
+			{Address: "", Total: 1, Deferred: 1},
+		})
+	})
+
 	ctrl.Finish()
 }
@@ -7,15 +7,16 @@ package api
 import (
 	"encoding/csv"
 	"errors"
+	"fmt"
 	"gitlab.com/lightmeter/controlcenter/detective"
+	"gitlab.com/lightmeter/controlcenter/detective/enduser"
 	"gitlab.com/lightmeter/controlcenter/detective/escalator"
 	"gitlab.com/lightmeter/controlcenter/httpauth/auth"
 	httpauth "gitlab.com/lightmeter/controlcenter/httpauth/auth"
 	"gitlab.com/lightmeter/controlcenter/httpmiddleware"
 	"gitlab.com/lightmeter/controlcenter/metadata"
+	"gitlab.com/lightmeter/controlcenter/pkg/ctxlogger"
 	"gitlab.com/lightmeter/controlcenter/pkg/httperror"
-	detectivesettings "gitlab.com/lightmeter/controlcenter/settings/detective"
-	"gitlab.com/lightmeter/controlcenter/util/emailutil"
 	"gitlab.com/lightmeter/controlcenter/util/errorutil"
 	"gitlab.com/lightmeter/controlcenter/util/httputil"
 	"gitlab.com/lightmeter/controlcenter/util/timeutil"
@@ -38,70 +39,162 @@ func someID(r *http.Request) string {
 	return strings.TrimSpace(r.Form.Get("some_id"))
 }
 
-func checkQueryParameters(r *http.Request, isAuthenticated bool) error {
-	err := r.ParseForm()
-	if err != nil {
-		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, errorutil.Wrap(err))
+func sizeFilter(r *http.Request, name string) (*int64, error) {
+	value := strings.TrimSpace(r.Form.Get(name))
+	if len(value) == 0 {
+		return nil, nil
 	}
 
-	// Parameters mail_from and mail_to can be partial (even void), but only for authenticated users
-	paramOk := func(value string, isAuthenticated bool) int {
-		switch {
-		case len(value) == 0 && isAuthenticated:
-			return http.StatusOK
-		case len(value) == 0 && !isAuthenticated:
-			return http.StatusUnauthorized
-		}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("Invalid %s: %w", name, detective.ErrInvalidFilter)
+	}
 
-		_, _, partial, err := emailutil.SplitPartial(value)
-		if err != nil {
-			return http.StatusUnprocessableEntity
-		}
+	return &size, nil
+}
+
+// searchFilters builds the optional filters for a search. All of them can be empty
+func searchFilters(r *http.Request) (detective.Filters, error) {
+	filters := detective.Filters{
+		Relay:     strings.TrimSpace(r.Form.Get("relay")),
+		DSNPrefix: strings.TrimSpace(r.Form.Get("dsn")),
+	}
 
-		if partial && !isAuthenticated {
-			return http.StatusUnauthorized
+	if clientIP := strings.TrimSpace(r.Form.Get("client_ip")); len(clientIP) > 0 {
+		network, err := detective.ParseClientIP(clientIP)
+		if err != nil {
+			return detective.Filters{}, fmt.Errorf("Invalid client_ip: %w", detective.ErrInvalidFilter)
 		}
 
-		return http.StatusOK
+		filters.ClientIP = network
 	}
 
-	isSomeIdSearch := len(someID(r)) > 0
+	var err error
 
-	if fromOk := paramOk(r.Form.Get("mail_from"), isAuthenticated); fromOk != http.StatusOK && !isSomeIdSearch {
-		return httperror.NewHTTPStatusCodeError(fromOk, errors.New("Partial from or to parameter not allowed"))
+	if filters.MinSize, err = sizeFilter(r, "min_size"); err != nil {
+		return detective.Filters{}, err
 	}
 
-	if toOk := paramOk(r.Form.Get("mail_to"), isAuthenticated); toOk != http.StatusOK && !isSomeIdSearch {
-		return httperror.NewHTTPStatusCodeError(toOk, errors.New("Partial from or to parameter not allowed"))
+	if filters.MaxSize, err = sizeFilter(r, "max_size"); err != nil {
+		return detective.Filters{}, err
+	}
+
+	return filters, nil
+}
+
+func checkQueryParameters(r *http.Request, isAuthenticated bool, endUser string) error {
+	err := r.ParseForm()
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, errorutil.Wrap(err))
 	}
 
 	if r.Form.Get("csv") == "true" && !isAuthenticated {
 		return httperror.NewHTTPStatusCodeError(http.StatusUnauthorized, errors.New("CSV export not allowed"))
 	}
 
+	if isAuthenticated {
+		// Parameters mail_from and mail_to can be partial (even void) or patterns
+		for _, param := range []string{"mail_from", "mail_to"} {
+			if value := r.Form.Get(param); len(value) > 0 {
+				if _, err := detective.ParseAddressPattern(value); err != nil {
+					return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+				}
+			}
+		}
+
+		return nil
+	}
+
+	// End users can only search for messages they've sent or received,
+	// so one of the addresses must be the one they verified, and the other, if any, a full address
+	isOwnAddress := false
+
+	for _, param := range []string{"mail_from", "mail_to"} {
+		value := strings.TrimSpace(r.Form.Get(param))
+		if len(value) == 0 {
+			continue
+		}
+
+		if detective.IsPattern(value) {
+			return httperror.NewHTTPStatusCodeError(http.StatusUnauthorized, errors.New("Partial from or to parameter not allowed"))
+		}
+
+		address, err := enduser.NormalizeAddress(value)
+		if err != nil {
+			return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+		}
+
+		if address == endUser {
+			isOwnAddress = true
+		}
+	}
+
+	if !isOwnAddress {
+		return httperror.NewHTTPStatusCodeError(http.StatusForbidden, errors.New("Only messages sent to or by the verified address are available"))
+	}
+
 	return nil
 }
 
-func requireDetectiveAuth(auth *httpauth.Authenticator, settingsReader metadata.Reader) httpmiddleware.Middleware {
+func requireDetectiveAuth(auth *httpauth.Authenticator, settingsReader metadata.Reader, verifier *enduser.Verifier) httpmiddleware.Middleware {
 	return func(h httpmiddleware.CustomHTTPHandler) httpmiddleware.CustomHTTPHandler {
 		return httpmiddleware.CustomHTTPHandler(func(w http.ResponseWriter, r *http.Request) error {
 			/* The detective handler can be accessed if authenticated
-			 * or if the 'open to end users' setting is enabled.
+			 * or, if the 'open to end users' setting is enabled,
+			 * by end users who verified their email address.
 			 */
 			isAuthenticated := isUserAuthenticated(auth, r)
 
-			settings := detectivesettings.Settings{}
-			err := settingsReader.RetrieveJson(r.Context(), detectivesettings.SettingsKey, &settings)
-			if err != nil && !errors.Is(err, metadata.ErrNoSuchKey) {
-				return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+			var endUser string
+
+			if !isAuthenticated {
+				enabled, err := endUsersEnabled(r, settingsReader)
+				if err != nil {
+					return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, err)
+				}
+
+				address, verified := endUserAddress(auth, r)
+
+				if !enabled || !verified {
+					return httperror.NewHTTPStatusCodeError(http.StatusUnauthorized, httpauth.ErrUnauthenticated)
+				}
+
+				endUser = address
 			}
 
-			if err := checkQueryParameters(r, isAuthenticated); err != nil {
+			if err := checkQueryParameters(r, isAuthenticated, endUser); err != nil {
 				return err // NOTE: already an XHTTPError
 			}
 
-			if !isAuthenticated && !settings.EndUsersEnabled {
-				return httperror.NewHTTPStatusCodeError(http.StatusUnauthorized, httpauth.ErrUnauthenticated)
+			if !isAuthenticated && !verifier.AllowLookup(endUser) {
+				return httperror.NewHTTPStatusCodeError(http.StatusTooManyRequests, enduser.ErrTooManyRequests)
+			}
+
+			logLookup(auth, r, endUser)
+
+			if err := h.ServeHTTP(w, r); err != nil {
+				return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+			}
+
+			return nil
+		})
+	}
+}
+
+// requireDetectiveEnabled allows access to the parts of the detective that tell nothing about the messages
+// to anyone while it's open to end users, even before they verify their address
+func requireDetectiveEnabled(auth *httpauth.Authenticator, settingsReader metadata.Reader) httpmiddleware.Middleware {
+	return func(h httpmiddleware.CustomHTTPHandler) httpmiddleware.CustomHTTPHandler {
+		return httpmiddleware.CustomHTTPHandler(func(w http.ResponseWriter, r *http.Request) error {
+			if !isUserAuthenticated(auth, r) {
+				enabled, err := endUsersEnabled(r, settingsReader)
+				if err != nil {
+					return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, err)
+				}
+
+				if !enabled {
+					return httperror.NewHTTPStatusCodeError(http.StatusUnauthorized, httpauth.ErrUnauthenticated)
+				}
 			}
 
 			if err := h.ServeHTTP(w, r); err != nil {
@@ -113,6 +206,28 @@ func requireDetectiveAuth(auth *httpauth.Authenticator, settingsReader metadata.
 	}
 }
 
+// logLookup leaves an audit trail of who searched for what
+func logLookup(auth *httpauth.Authenticator, r *http.Request, endUser string) {
+	event := ctxlogger.GetCtxLogger(r.Context()).Info().
+		Str("endpoint", r.URL.Path).
+		Str("mail_from", r.Form.Get("mail_from")).
+		Str("mail_to", r.Form.Get("mail_to")).
+		Str("some_id", someID(r)).
+		Str("from", r.Form.Get("from")).
+		Str("to", r.Form.Get("to"))
+
+	if len(endUser) > 0 {
+		event.Str("end_user", endUser).Msg("Message detective lookup")
+		return
+	}
+
+	if sessionData, err := httpauth.GetSessionData(auth, r); err == nil {
+		event = event.Str("user", sessionData.Email)
+	}
+
+	event.Msg("Message detective lookup")
+}
+
 type detectiveHandler struct {
 	//nolint:structcheck
 	detective detective.Detective
@@ -128,6 +243,11 @@ type Interval string
 // @Param timestamp_to   query string true "Final timestamp in the format 1999-12-23 14:00:00"
 // @Param status         query string true "A status to filter messages (-1: all, 0: sent... see smtp.go)"
 // @Param someID         query string true "A queue name or message ID to filter results -- empty: don't filter"
+// @Param client_ip      query string false "Client IP address or network in CIDR notation (203.0.113.0/24) the message was received from"
+// @Param relay          query string false "Next relay hostname, supporting * and ? wildcards. Also matches its subdomains"
+// @Param dsn            query string false "DSN prefix, as 5.1 or 4.7.1"
+// @Param min_size       query string false "Minimum message size, in bytes"
+// @Param max_size       query string false "Maximum message size, in bytes"
 // @Param page           query string true "Page number to return results"
 // @Param csv            query string false "if present and =true, generates CSV instead of json (export)"
 // @Produce json, text/csv
@@ -154,11 +274,16 @@ func (h checkMessageDeliveryHandler) ServeHTTP(w http.ResponseWriter, r *http.Re
 		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
 	}
 
+	filters, err := searchFilters(r)
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+	}
+
 	if r.Form.Get("csv") == "true" {
-		return h.exportCSV(w, r, interval, status)
+		return h.exportCSV(w, r, interval, status, filters)
 	}
 
-	messages, err := h.detective.CheckMessageDelivery(r.Context(), r.Form.Get("mail_from"), r.Form.Get("mail_to"), interval, status, someID(r), page, detective.ResultsPerPage)
+	messages, err := h.detective.CheckMessageDelivery(r.Context(), r.Form.Get("mail_from"), r.Form.Get("mail_to"), interval, status, someID(r), filters, page, detective.ResultsPerPage)
 
 	if err != nil {
 		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
@@ -167,11 +292,11 @@ func (h checkMessageDeliveryHandler) ServeHTTP(w http.ResponseWriter, r *http.Re
 	return httputil.WriteJson(w, messages, http.StatusOK)
 }
 
-func (h checkMessageDeliveryHandler) exportCSV(w http.ResponseWriter, r *http.Request, interval timeutil.TimeInterval, status int) error {
+func (h checkMessageDeliveryHandler) exportCSV(w http.ResponseWriter, r *http.Request, interval timeutil.TimeInterval, status int, filters detective.Filters) error {
 	page := 1
 	limit := 10000
 
-	messages, err := h.detective.CheckMessageDelivery(r.Context(), r.Form.Get("mail_from"), r.Form.Get("mail_to"), interval, status, someID(r), page, limit)
+	messages, err := h.detective.CheckMessageDelivery(r.Context(), r.Form.Get("mail_from"), r.Form.Get("mail_to"), interval, status, someID(r), filters, page, limit)
 	if err != nil {
 		return err
 	}
@@ -221,16 +346,67 @@ func (h oldestAvailableTimeHandler) ServeHTTP(w http.ResponseWriter, r *http.Req
 	return httputil.WriteJson(w, OldestAvailableTimeResponse{Time: &time}, http.StatusOK)
 }
 
-func HttpDetective(auth *auth.Authenticator, mux *http.ServeMux, timezone *time.Location, detective detective.Detective, escalator escalator.Requester, settingsReader metadata.Reader, isBehindReverseProxy bool) {
+func HttpDetective(auth *auth.Authenticator, mux *http.ServeMux, timezone *time.Location, detective detective.Detective, escalator escalator.Requester, verifier *enduser.Verifier, settingsReader metadata.Reader, isBehindReverseProxy bool) {
 	publicIfEnabled := httpmiddleware.New(
 		httpmiddleware.RequestWithRateLimit(10*time.Minute, 50, isBehindReverseProxy, httpmiddleware.BlockQuery),
 		httpmiddleware.RequestWithTimeout(httpmiddleware.DefaultTimeout),
-		requireDetectiveAuth(auth, settingsReader),
+		requireDetectiveEnabled(auth, settingsReader),
 	)
 
-	mux.Handle("/api/v0/checkMessageDeliveryStatus", publicIfEnabled.WithEndpoint(checkMessageDeliveryHandler{detective}))
-	mux.Handle("/api/v0/escalateMessage", publicIfEnabled.WithEndpoint(detectiveEscalatorHandler{requester: escalator, detective: detective}))
+	verifiedIfPublic := httpmiddleware.New(
+		httpmiddleware.RequestWithRateLimit(10*time.Minute, 50, isBehindReverseProxy, httpmiddleware.BlockQuery),
+		httpmiddleware.RequestWithTimeout(httpmiddleware.DefaultTimeout),
+		requireDetectiveAuth(auth, settingsReader, verifier),
+	)
+
+	endUsers := endUserHandler{auth: auth, verifier: verifier, settingsReader: settingsReader}
+
+	publicAndRateLimited := httpmiddleware.WithDefaultStackWithoutAuth(
+		httpmiddleware.RequestWithRateLimit(10*time.Minute, 10, isBehindReverseProxy, httpmiddleware.BlockQuery),
+	)
+
+	mux.Handle("/api/v0/requestEndUserVerification", publicAndRateLimited.WithEndpoint(requestEndUserVerificationHandler(endUsers)))
+	mux.Handle("/api/v0/verifyEndUser", publicAndRateLimited.WithEndpoint(verifyEndUserHandler(endUsers)))
+	mux.Handle("/api/v0/endUserInfo", httpmiddleware.WithDefaultStackWithoutAuth().WithEndpoint(endUserInfoHandler(endUsers)))
+
+	mux.Handle("/api/v0/checkMessageDeliveryStatus", verifiedIfPublic.WithEndpoint(checkMessageDeliveryHandler{detective}))
+	mux.Handle("/api/v0/escalateMessage", verifiedIfPublic.WithEndpoint(detectiveEscalatorHandler{requester: escalator, detective: detective}))
 	mux.Handle("/api/v0/oldestAvailableTimeForMessageDetective", publicIfEnabled.WithEndpoint(oldestAvailableTimeHandler{detective: detective}))
+
+	// conversations might include messages from other people, so they are not available to end users
+	mux.Handle("/api/v0/messageThread", httpmiddleware.WithDefaultStack(auth, httpmiddleware.RequestWithTimeout(httpmiddleware.DefaultTimeout)).
+		WithEndpoint(messageThreadHandler{detective: detective}))
+}
+
+type messageThreadHandler detectiveHandler
+
+// @Summary Conversation a message is part of, built from the In-Reply-To and References headers
+// @Param some_id query string true "Queue name or message ID of any of the messages in the conversation, as returned by checkMessageDeliveryStatus"
+// @Produce json
+// @Success 200 {object} detective.Thread "desc"
+// @Failure 404 {string} string "desc"
+// @Failure 422 {string} string "desc"
+// @Router /api/v0/messageThread [get]
+func (h messageThreadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	if r.ParseForm() != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, errors.New("Wrong Input"))
+	}
+
+	id := someID(r)
+	if len(id) == 0 {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, errors.New("Missing some_id"))
+	}
+
+	thread, err := h.detective.Thread(r.Context(), id)
+	if err != nil && errors.Is(err, detective.ErrNoSuchMessage) {
+		return httperror.NewHTTPStatusCodeError(http.StatusNotFound, err)
+	}
+
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+	}
+
+	return httputil.WriteJson(w, thread, http.StatusOK)
 }
 
 type detectiveEscalatorHandler struct {
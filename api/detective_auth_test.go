@@ -6,16 +6,19 @@ package api
 
 import (
 	"context"
+	"io"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
 	. "github.com/smartystreets/goconvey/convey"
 	"gitlab.com/lightmeter/controlcenter/detective"
+	"gitlab.com/lightmeter/controlcenter/detective/enduser"
 	mock_detective "gitlab.com/lightmeter/controlcenter/detective/mock"
 	"gitlab.com/lightmeter/controlcenter/httpauth"
 	"gitlab.com/lightmeter/controlcenter/httpauth/auth"
@@ -23,6 +26,7 @@ import (
 	"gitlab.com/lightmeter/controlcenter/metadata"
 	"gitlab.com/lightmeter/controlcenter/pkg/runner"
 	detectivesettings "gitlab.com/lightmeter/controlcenter/settings/detective"
+	"gitlab.com/lightmeter/controlcenter/settings/globalsettings"
 	"gitlab.com/lightmeter/controlcenter/util/testutil"
 )
 
@@ -36,7 +40,16 @@ func buildCookieClient() *http.Client {
 	return &http.Client{Jar: jar}
 }
 
-func buildTestEnv(t *testing.T) (*httptest.Server, *mock_detective.MockDetective, *metadata.AsyncWriter, func()) {
+type fakeLinkSender struct {
+	links map[string]string
+}
+
+func (s *fakeLinkSender) SendVerificationLink(ctx context.Context, address, link string) error {
+	s.links[address] = link
+	return nil
+}
+
+func buildTestEnv(t *testing.T) (*httptest.Server, *mock_detective.MockDetective, *metadata.AsyncWriter, *fakeLinkSender, func()) {
 	ctrl := gomock.NewController(t)
 
 	dir, clearDir := testutil.TempDir(t)
@@ -65,13 +78,18 @@ func buildTestEnv(t *testing.T) (*httptest.Server, *mock_detective.MockDetective
 	settingsWriter := writeRunner.Writer()
 	settingsReader := handler.Reader
 
-	HttpDetective(auth, mux, time.UTC, detective, &fakeEscalateRequester{}, settingsReader, true)
+	sender := &fakeLinkSender{links: map[string]string{}}
+
+	HttpDetective(auth, mux, time.UTC, detective, &fakeEscalateRequester{}, enduser.New(sender), settingsReader, true)
 
 	httpauth.HttpAuthenticator(mux, auth, settingsReader, true)
 
 	s := httptest.NewServer(mux)
 
-	return s, detective, settingsWriter, func() {
+	err = settingsWriter.StoreJsonSync(context.Background(), globalsettings.SettingsKey, globalsettings.Settings{PublicURL: s.URL})
+	So(err, ShouldBeNil)
+
+	return s, detective, settingsWriter, sender, func() {
 		cancel()
 		So(done(), ShouldBeNil)
 		removeDB()
@@ -98,12 +116,12 @@ func TestDetectiveAuth(t *testing.T) {
 
 		c := buildCookieClient()
 
-		s, d, settingsWriter, clear := buildTestEnv(t)
+		s, d, settingsWriter, sender, clear := buildTestEnv(t)
 		defer clear()
 
 		expect := func(d *mock_detective.MockDetective) {
 			d.EXPECT().
-				CheckMessageDelivery(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+				CheckMessageDelivery(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
 				Return(&detective.MessagesPage{}, nil)
 		}
 
@@ -162,66 +180,190 @@ func TestDetectiveAuth(t *testing.T) {
 			})
 		})
 
+		verify := func(address string) *http.Response {
+			r, err := c.PostForm(s.URL+"/api/v0/requestEndUserVerification", url.Values{"email": {address}})
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+			link, ok := sender.links[address]
+			So(ok, ShouldBeTrue)
+			So(link, ShouldStartWith, s.URL+"/api/v0/verifyEndUser?token=")
+
+			// opening the link only asks for confirmation
+			r, err = c.Get(link)
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+			token := link[strings.Index(link, "=")+1:]
+
+			r, err = c.PostForm(s.URL+"/api/v0/verifyEndUser", url.Values{"token": {token}})
+			So(err, ShouldBeNil)
+
+			return r
+		}
+
+		// the verification redirects to the public URL, which is not served by the tests
+		c.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+
+		enableEndUsers := func() {
+			settings := detectivesettings.Settings{}
+			settings.EndUsersEnabled = true
+			detectivesettings.SetSettings(context.Background(), settingsWriter, settings)
+		}
+
 		Convey("Detective API only accessible to end-users if setting is enabled", func() {
 			r, err := c.Get(s.URL + detectiveURL)
 			So(err, ShouldBeNil)
 			So(r.StatusCode, ShouldEqual, http.StatusUnauthorized)
 
-			Convey("Once we enable the setting, detective API is accessible to end-users", func() {
-				settings := detectivesettings.Settings{}
-				settings.EndUsersEnabled = true
-				detectivesettings.SetSettings(context.Background(), settingsWriter, settings)
+			r, err = c.PostForm(s.URL+"/api/v0/requestEndUserVerification", url.Values{"email": {"a@b.c"}})
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+			Convey("Once we enable the setting, end-users must verify their address", func() {
+				enableEndUsers()
 
-				expect(d)
 				r, err := c.Get(s.URL + detectiveURL)
 				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+				r, err = c.PostForm(s.URL+"/api/v0/requestEndUserVerification", url.Values{"email": {"not an address"}})
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusUnprocessableEntity)
+
+				r, err = c.PostForm(s.URL+"/api/v0/verifyEndUser", url.Values{"token": {"invalid"}})
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+				r = verify("a@b.c")
+				So(r.StatusCode, ShouldEqual, http.StatusSeeOther)
+				So(r.Header.Get("Location"), ShouldEqual, s.URL+"/#/detective")
+
+				r, err = c.Get(s.URL + "/api/v0/endUserInfo")
+				So(err, ShouldBeNil)
 				So(r.StatusCode, ShouldEqual, http.StatusOK)
 
-				Convey("Partial searches unavailable to unauthenticated users", func() {
-					r, err := c.Get(s.URL + detectiveURLPartialMailFrom)
-					So(err, ShouldBeNil)
-					So(r.StatusCode, ShouldEqual, http.StatusUnauthorized)
+				Convey("Verification links can be used only once", func() {
+					link := sender.links["a@b.c"]
 
-					r, err = c.Get(s.URL + detectiveURLPartialMailTo)
+					r, err := c.PostForm(s.URL+"/api/v0/verifyEndUser", url.Values{"token": {link[strings.Index(link, "=")+1:]}})
 					So(err, ShouldBeNil)
 					So(r.StatusCode, ShouldEqual, http.StatusUnauthorized)
+				})
 
-					r, err = c.Get(s.URL + detectiveURLEmptyMailFrom)
+				Convey("Verified end-users can search for their own messages", func() {
+					expect(d)
+					r, err := c.Get(s.URL + detectiveURL)
 					So(err, ShouldBeNil)
-					So(r.StatusCode, ShouldEqual, http.StatusUnauthorized)
+					So(r.StatusCode, ShouldEqual, http.StatusOK)
 
+					expect(d)
 					r, err = c.Get(s.URL + detectiveURLEmptyMailTo)
 					So(err, ShouldBeNil)
-					So(r.StatusCode, ShouldEqual, http.StatusUnauthorized)
-				})
+					So(r.StatusCode, ShouldEqual, http.StatusOK)
 
-				Convey("Some ID search (no mailfrom/to) available to unauthenticated users", func() {
 					expect(d)
-					r, err = c.Get(s.URL + detectiveURLSomeID)
+					r, err = c.Get(s.URL + "/api/v0/checkMessageDeliveryStatus?mail_from=A@B.C&from=2020-01-01&to=2020-12-31&status=-1&some_id=1A2B3C4D&page=1")
 					So(err, ShouldBeNil)
 					So(r.StatusCode, ShouldEqual, http.StatusOK)
 				})
 
-				Convey("Empty some ID search unavailable to unauthenticated users", func() {
-					r, err = c.Get(s.URL + detectiveURLSomeIDEmpty)
+				Convey("Partial searches unavailable to end-users", func() {
+					r, err := c.Get(s.URL + detectiveURLPartialMailFrom)
 					So(err, ShouldBeNil)
 					So(r.StatusCode, ShouldEqual, http.StatusUnauthorized)
 
-					r, err = c.Get(s.URL + detectiveURLSomeIDWhitespaceOnly)
+					r, err = c.Get(s.URL + detectiveURLPartialMailTo)
 					So(err, ShouldBeNil)
 					So(r.StatusCode, ShouldEqual, http.StatusUnauthorized)
 				})
+
+				Convey("Messages from other people unavailable to end-users", func() {
+					for _, u := range []string{
+						detectiveURLEmptyMailFrom,
+						detectiveURLSomeID,
+						detectiveURLSomeIDEmpty,
+						detectiveURLSomeIDWhitespaceOnly,
+						"/api/v0/checkMessageDeliveryStatus?mail_from=x@y.z&mail_to=d@e.f&from=2020-01-01&to=2020-12-31&status=-1&some_id=&page=1",
+					} {
+						r, err := c.Get(s.URL + u)
+						So(err, ShouldBeNil)
+						So(r.StatusCode, ShouldEqual, http.StatusForbidden)
+					}
+				})
 			})
 		})
 
+		Convey("Oldest available time accessible to end-users before they verify their address", func() {
+			oldestURL := s.URL + "/api/v0/oldestAvailableTimeForMessageDetective"
+
+			r, err := c.Get(oldestURL)
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+			enableEndUsers()
+
+			d.EXPECT().OldestAvailableTime(gomock.Any()).Return(time.Time{}, detective.ErrNoAvailableLogs)
+
+			r, err = c.Get(oldestURL)
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("Opening a verification link does not use it up", func() {
+			enableEndUsers()
+
+			r, err := c.PostForm(s.URL+"/api/v0/requestEndUserVerification", url.Values{"email": {"a@b.c"}})
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+			// as done by mail servers scanning the links in the messages
+			for i := 0; i < 2; i++ {
+				r, err := http.Get(sender.links["a@b.c"])
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+				body, err := io.ReadAll(r.Body)
+				So(err, ShouldBeNil)
+				So(string(body), ShouldContainSubstring, `<form method="post" action="/api/v0/verifyEndUser">`)
+			}
+
+			r, err = c.Get(s.URL + "/api/v0/endUserInfo")
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+			link := sender.links["a@b.c"]
+
+			r, err = c.PostForm(s.URL+"/api/v0/verifyEndUser", url.Values{"token": {link[strings.Index(link, "=")+1:]}})
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusSeeOther)
+		})
+
+		Convey("Verification emails to an address are limited", func() {
+			enableEndUsers()
+
+			for i := 0; i < enduser.MaxVerificationRequests; i++ {
+				r, err := c.PostForm(s.URL+"/api/v0/requestEndUserVerification", url.Values{"email": {"a@b.c"}})
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusOK)
+			}
+
+			r, err := c.PostForm(s.URL+"/api/v0/requestEndUserVerification", url.Values{"email": {"a@b.c"}})
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusTooManyRequests)
+		})
+
 		Convey("CSV export only available to authenticated users", func() {
 			r, err := c.Get(s.URL + detectiveURL)
 			So(err, ShouldBeNil)
 			So(r.StatusCode, ShouldEqual, http.StatusUnauthorized)
 
-			settings := detectivesettings.Settings{}
-			settings.EndUsersEnabled = true
-			detectivesettings.SetSettings(context.Background(), settingsWriter, settings)
+			enableEndUsers()
+
+			r = verify("a@b.c")
+			So(r.StatusCode, ShouldEqual, http.StatusSeeOther)
 
 			// end-user can now access regular search…
 			expect(d)
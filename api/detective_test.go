@@ -57,14 +57,14 @@ func TestDetectiveCheckMessageDeliveryHandler(t *testing.T) {
 		emptyResult := detective.MessagesPage{}
 
 		Convey("No Sender", func() {
-			m.EXPECT().CheckMessageDelivery(gomock.Any(), "", "user2@example.org", interval, -1, "", 1, limit).Return(&emptyResult, emailutil.ErrInvalidEmail)
+			m.EXPECT().CheckMessageDelivery(gomock.Any(), "", "user2@example.org", interval, -1, "", detective.Filters{}, 1, limit).Return(&emptyResult, emailutil.ErrInvalidEmail)
 			r, err := http.Get(fmt.Sprintf("%s?from=1999-01-01&to=1999-12-31&mail_to=user2@example.org&status=-1&some_id=&page=1", s.URL))
 			So(err, ShouldBeNil)
 			So(r.StatusCode, ShouldEqual, http.StatusUnprocessableEntity)
 		})
 
 		Convey("No Recipient", func() {
-			m.EXPECT().CheckMessageDelivery(gomock.Any(), "user1@example.org", "", interval, -1, "", 1, limit).Return(&emptyResult, emailutil.ErrInvalidEmail)
+			m.EXPECT().CheckMessageDelivery(gomock.Any(), "user1@example.org", "", interval, -1, "", detective.Filters{}, 1, limit).Return(&emptyResult, emailutil.ErrInvalidEmail)
 			r, err := http.Get(fmt.Sprintf("%s?from=1999-01-01&to=1999-12-31&mail_from=user1@example.org&status=-1&some_id=&page=1", s.URL))
 			So(err, ShouldBeNil)
 			So(r.StatusCode, ShouldEqual, http.StatusUnprocessableEntity)
@@ -83,6 +83,28 @@ func TestDetectiveCheckMessageDeliveryHandler(t *testing.T) {
 			So(r.StatusCode, ShouldEqual, http.StatusUnprocessableEntity)
 		})
 
+		Convey("Invalid filters", func() {
+			for _, filters := range []string{"client_ip=1.2.3", "client_ip=1.2.3.4/33", "min_size=-1", "max_size=abc"} {
+				r, err := http.Get(fmt.Sprintf("%s?from=1999-01-01&to=1999-12-31&mail_from=user1@example.org&mail_to=user2@example.org&status=-1&some_id=&page=1&%s", s.URL, filters))
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusUnprocessableEntity)
+			}
+		})
+
+		Convey("Search with filters", func() {
+			clientIP, err := detective.ParseClientIP("203.0.113.0/24")
+			So(err, ShouldBeNil)
+
+			minSize, maxSize := int64(100), int64(2000)
+
+			filters := detective.Filters{ClientIP: clientIP, Relay: "gmail.com", DSNPrefix: "5.1", MinSize: &minSize, MaxSize: &maxSize}
+
+			m.EXPECT().CheckMessageDelivery(gomock.Any(), "user1@example.org", "user2@example.org", interval, -1, "", filters, 1, limit).Return(&emptyResult, nil)
+			r, err := http.Get(fmt.Sprintf("%s?from=1999-01-01&to=1999-12-31&mail_from=user1@example.org&mail_to=user2@example.org&status=-1&some_id=&page=1&client_ip=203.0.113.0/24&relay=gmail.com&dsn=5.1&min_size=100&max_size=2000", s.URL))
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusOK)
+		})
+
 		Convey("Success", func() {
 			messages := detective.MessagesPage{
 				PageNumber:   1,
@@ -109,7 +131,7 @@ func TestDetectiveCheckMessageDeliveryHandler(t *testing.T) {
 				},
 			}
 
-			m.EXPECT().CheckMessageDelivery(gomock.Any(), "user1@example.org", "user2@example.org", interval, -1, "", 1, limit).Return(&messages, nil)
+			m.EXPECT().CheckMessageDelivery(gomock.Any(), "user1@example.org", "user2@example.org", interval, -1, "", detective.Filters{}, 1, limit).Return(&messages, nil)
 
 			r, err := http.Get(fmt.Sprintf("%s?from=1999-01-01&to=1999-12-31&mail_from=user1@example.org&mail_to=user2@example.org&status=-1&some_id=&page=1", s.URL))
 			So(err, ShouldBeNil)
@@ -165,6 +187,61 @@ func TestDetectiveOldestAvailableTime(t *testing.T) {
 	})
 }
 
+func TestDetectiveMessageThread(t *testing.T) {
+	Convey("MessageThread", t, func() {
+		ctrl := gomock.NewController(t)
+
+		defer ctrl.Finish()
+
+		m := mock_detective.NewMockDetective(ctrl)
+
+		s := httptest.NewServer(httpmiddleware.New().WithEndpoint(messageThreadHandler{detective: m}))
+
+		Convey("No message", func() {
+			r, err := http.Get(s.URL)
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusUnprocessableEntity)
+		})
+
+		Convey("Unknown message", func() {
+			m.EXPECT().Thread(gomock.Any(), "AAAAAA").Return(nil, detective.ErrNoSuchMessage)
+
+			r, err := http.Get(s.URL + "?some_id=AAAAAA")
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("Conversation", func() {
+			thread := &detective.Thread{
+				Size: 2,
+				Roots: []*detective.ThreadMessage{
+					{
+						MessageID: "original@example.com",
+						Messages:  detective.Messages{{Queue: "AAAAAA", MessageID: "original@example.com", Entries: []detective.MessageDelivery{}}},
+						Replies: []*detective.ThreadMessage{
+							{
+								MessageID: "reply@example.com",
+								Messages:  detective.Messages{{Queue: "BBBBBB", MessageID: "reply@example.com", Entries: []detective.MessageDelivery{}}},
+								Replies:   []*detective.ThreadMessage{},
+							},
+						},
+					},
+				},
+			}
+
+			m.EXPECT().Thread(gomock.Any(), "BBBBBB").Return(thread, nil)
+
+			r, err := http.Get(s.URL + "?some_id=BBBBBB")
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+			var body detective.Thread
+			So(json.NewDecoder(r.Body).Decode(&body), ShouldBeNil)
+			So(&body, ShouldResemble, thread)
+		})
+	})
+}
+
 type fakeEscalateRequester struct {
 	requests []escalator.Request
 }
@@ -183,7 +260,7 @@ func TestEscalation(t *testing.T) {
 		s := httptest.NewServer(httpmiddleware.New().WithEndpoint(detectiveEscalatorHandler{requester: e, detective: d}))
 
 		Convey("No message escalated", func() {
-			d.EXPECT().CheckMessageDelivery(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(&detective.MessagesPage{}, nil)
+			d.EXPECT().CheckMessageDelivery(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(&detective.MessagesPage{}, nil)
 
 			r, err := http.PostForm(s.URL, url.Values{
 				"from":      []string{"2000-01-01"},
@@ -198,7 +275,7 @@ func TestEscalation(t *testing.T) {
 		})
 
 		Convey("Internal error if detective check fails", func() {
-			d.EXPECT().CheckMessageDelivery(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(&detective.MessagesPage{}, errors.New(`Some error`))
+			d.EXPECT().CheckMessageDelivery(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(&detective.MessagesPage{}, errors.New(`Some error`))
 
 			r, err := http.PostForm(s.URL, url.Values{
 				"from":      []string{"2000-01-01"},
@@ -226,7 +303,7 @@ func TestEscalation(t *testing.T) {
 		})
 
 		Convey("Escalate issue", func() {
-			d.EXPECT().CheckMessageDelivery(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(
+			d.EXPECT().CheckMessageDelivery(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(
 				&detective.MessagesPage{
 					PageNumber:   1,
 					FirstPage:    1,
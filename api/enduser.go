@@ -0,0 +1,238 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/detective/enduser"
+	httpauth "gitlab.com/lightmeter/controlcenter/httpauth/auth"
+	"gitlab.com/lightmeter/controlcenter/metadata"
+	"gitlab.com/lightmeter/controlcenter/pkg/ctxlogger"
+	"gitlab.com/lightmeter/controlcenter/pkg/httperror"
+	detectivesettings "gitlab.com/lightmeter/controlcenter/settings/detective"
+	"gitlab.com/lightmeter/controlcenter/settings/globalsettings"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/httputil"
+)
+
+// End users have their own session, independent from the one of the users of the web interface
+const endUserSessionName = "controlcenter_enduser"
+
+const endUserSessionDuration = time.Hour * 24
+
+// endUserAddress returns the address verified by the end user, if any
+func endUserAddress(auth *httpauth.Authenticator, r *http.Request) (string, bool) {
+	session, err := auth.Store.Get(r, endUserSessionName)
+	if err != nil {
+		return "", false
+	}
+
+	address, ok := session.Values["address"].(string)
+
+	return address, ok && len(address) > 0
+}
+
+func endUsersEnabled(r *http.Request, settingsReader metadata.Reader) (bool, error) {
+	settings := detectivesettings.Settings{}
+
+	err := settingsReader.RetrieveJson(r.Context(), detectivesettings.SettingsKey, &settings)
+	if err != nil && !errors.Is(err, metadata.ErrNoSuchKey) {
+		return false, errorutil.Wrap(err)
+	}
+
+	return settings.EndUsersEnabled, nil
+}
+
+func publicURL(r *http.Request, settingsReader metadata.Reader) (string, error) {
+	settings, err := globalsettings.GetSettings(r.Context(), settingsReader)
+	if err != nil && !errors.Is(err, metadata.ErrNoSuchKey) {
+		return "", errorutil.Wrap(err)
+	}
+
+	if settings == nil || len(settings.PublicURL) == 0 {
+		return "", errors.New("Public URL is not configured")
+	}
+
+	return strings.TrimSuffix(settings.PublicURL, "/") + "/", nil
+}
+
+type endUserHandler struct {
+	auth           *httpauth.Authenticator
+	verifier       *enduser.Verifier
+	settingsReader metadata.Reader
+}
+
+func (h endUserHandler) checkEnabled(r *http.Request) error {
+	enabled, err := endUsersEnabled(r, h.settingsReader)
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, err)
+	}
+
+	if !enabled {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnauthorized, errors.New("Message detective is not open to end users"))
+	}
+
+	return nil
+}
+
+type requestEndUserVerificationHandler endUserHandler
+
+// @Summary Email an end user a one-time link that verifies they own an address, giving them access to the detective
+// @Param email query string true "Address to be verified"
+// @Success 200 {string} string "desc"
+// @Failure 422 {string} string "desc"
+// @Failure 429 {string} string "desc"
+// @Router /api/v0/requestEndUserVerification [post]
+func (h requestEndUserVerificationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return httperror.NewHTTPStatusCodeError(http.StatusMethodNotAllowed, fmt.Errorf("Error http method mismatch: %v", r.Method))
+	}
+
+	if err := endUserHandler(h).checkEnabled(r); err != nil {
+		return err
+	}
+
+	if r.ParseForm() != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, errors.New("Wrong Input"))
+	}
+
+	baseURL, err := publicURL(r, h.settingsReader)
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, err)
+	}
+
+	err = h.verifier.RequestVerification(r.Context(), r.Form.Get("email"), baseURL+"api/v0/verifyEndUser?token=")
+
+	switch {
+	case err == nil:
+		return httputil.WriteJson(w, "ok", http.StatusOK)
+	case errors.Is(err, enduser.ErrInvalidAddress):
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+	case errors.Is(err, enduser.ErrTooManyRequests):
+		return httperror.NewHTTPStatusCodeError(http.StatusTooManyRequests, err)
+	case errors.Is(err, enduser.ErrEmailNotConfigured):
+		return httperror.NewHTTPStatusCodeError(http.StatusServiceUnavailable, err)
+	default:
+		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+	}
+}
+
+type verifyEndUserHandler endUserHandler
+
+// Opening the link only shows a page asking for confirmation, as mail servers often open the links
+// in the messages they receive, looking for malicious content, which would use up the token
+var verifyEndUserPage = template.Must(template.New("verifyEndUser").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Lightmeter ControlCenter</title>
+</head>
+<body>
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="token" value="{{.Token}}">
+<p>Confirm your email address to search for the messages you have sent or received.</p>
+<button type="submit">Confirm</button>
+</form>
+</body>
+</html>
+`))
+
+// @Summary Verify an end user from the link emailed to them. GET asks for confirmation, and POST verifies them, redirecting them to the detective
+// @Param token query string true "Verification token"
+// @Success 200 {string} string "desc"
+// @Success 303 {string} string "desc"
+// @Failure 401 {string} string "desc"
+// @Router /api/v0/verifyEndUser [get]
+// @Router /api/v0/verifyEndUser [post]
+func (h verifyEndUserHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	if err := endUserHandler(h).checkEnabled(r); err != nil {
+		return err
+	}
+
+	if r.ParseForm() != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, errors.New("Wrong Input"))
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		return h.confirm(w, r)
+	case http.MethodPost:
+		return h.verify(w, r)
+	}
+
+	return httperror.NewHTTPStatusCodeError(http.StatusMethodNotAllowed, fmt.Errorf("Error http method mismatch: %v", r.Method))
+}
+
+func (h verifyEndUserHandler) confirm(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	// the token is not checked, as that would tell anyone whether it's valid
+	err := verifyEndUserPage.Execute(w, struct {
+		Action string
+		Token  string
+	}{Action: r.URL.Path, Token: r.Form.Get("token")})
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+	}
+
+	return nil
+}
+
+func (h verifyEndUserHandler) verify(w http.ResponseWriter, r *http.Request) error {
+	address, err := h.verifier.Verify(r.Form.Get("token"))
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnauthorized, err)
+	}
+
+	session, err := h.auth.Store.New(r, endUserSessionName)
+	if err != nil {
+		ctxlogger.LogErrorf(r.Context(), errorutil.Wrap(err), "creating new end user session")
+	}
+
+	session.Options.MaxAge = int(endUserSessionDuration.Seconds())
+	session.Values["address"] = address
+
+	if err := session.Save(r, w); err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, fmt.Errorf("saving end user session: %w", err))
+	}
+
+	ctxlogger.GetCtxLogger(r.Context()).Info().Str("end_user", address).Msg("End user verified")
+
+	baseURL, err := publicURL(r, h.settingsReader)
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, err)
+	}
+
+	http.Redirect(w, r, baseURL+"#/detective", http.StatusSeeOther)
+
+	return nil
+}
+
+type endUserInfoHandler endUserHandler
+
+type endUserInfo struct {
+	Email string `json:"email"`
+}
+
+// @Summary Address verified by the end user
+// @Produce json
+// @Success 200 {object} endUserInfo "desc"
+// @Failure 401 {string} string "desc"
+// @Router /api/v0/endUserInfo [get]
+func (h endUserInfoHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	address, ok := endUserAddress(h.auth, r)
+	if !ok {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnauthorized, httpauth.ErrUnauthenticated)
+	}
+
+	return httputil.WriteJson(w, endUserInfo{Email: address}, http.StatusOK)
+}
@@ -0,0 +1,54 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"gitlab.com/lightmeter/controlcenter/erasure"
+	"gitlab.com/lightmeter/controlcenter/httpauth/auth"
+	"gitlab.com/lightmeter/controlcenter/httpmiddleware"
+	"gitlab.com/lightmeter/controlcenter/pkg/httperror"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/httputil"
+)
+
+type eraseDataHandler struct {
+	eraser erasure.Eraser
+}
+
+// @Summary Remove or anonymise all data about an e-mail address or domain
+// @Param target query string true "E-mail address (user@example.com) or domain (example.com)"
+// @Produce json
+// @Success 200 {object} erasure.Report "Audit report of what has been erased"
+// @Failure 422 {string} string "desc"
+// @Router /api/v0/eraseData [post]
+func (h eraseDataHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return httperror.NewHTTPStatusCodeError(http.StatusMethodNotAllowed, fmt.Errorf("Error http method mismatch: %v", r.Method))
+	}
+
+	if r.ParseForm() != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, errors.New("Wrong Input"))
+	}
+
+	target, err := erasure.ParseTarget(r.Form.Get("target"))
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+	}
+
+	report, err := h.eraser.EraseData(r.Context(), target)
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+	}
+
+	return httputil.WriteJson(w, report, http.StatusOK)
+}
+
+func HttpDataErasure(auth *auth.Authenticator, mux *http.ServeMux, eraser erasure.Eraser) {
+	mux.Handle("/api/v0/eraseData", httpmiddleware.WithDefaultStack(auth).WithEndpoint(eraseDataHandler{eraser: eraser}))
+}
@@ -0,0 +1,205 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	httpauth "gitlab.com/lightmeter/controlcenter/httpauth/auth"
+	"gitlab.com/lightmeter/controlcenter/httpmiddleware"
+	"gitlab.com/lightmeter/controlcenter/insights/detectiveescalation"
+	"gitlab.com/lightmeter/controlcenter/pkg/httperror"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/httputil"
+)
+
+type escalationsHandler struct {
+	auth    *httpauth.Authenticator
+	manager detectiveescalation.TicketManager
+}
+
+func ticketError(err error) error {
+	if errors.Is(err, detectiveescalation.ErrNoSuchTicket) {
+		return httperror.NewHTTPStatusCodeError(http.StatusNotFound, err)
+	}
+
+	if errors.Is(err, detectiveescalation.ErrInvalidTicketUpdate) {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+	}
+
+	return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+}
+
+func ticketID(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(r.Form.Get("id"), 10, 64)
+	if err != nil {
+		return 0, httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+	}
+
+	return id, nil
+}
+
+type listEscalationsHandler escalationsHandler
+
+// @Summary List the escalation tickets, the most recent first
+// @Param status query string false "Only tickets with such status: open, investigating or resolved"
+// @Produce json
+// @Success 200 {object} []detectiveescalation.Ticket "desc"
+// @Failure 422 {string} string "desc"
+// @Router /api/v0/escalations [get]
+func (h listEscalationsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	if r.ParseForm() != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, errors.New("Wrong Input"))
+	}
+
+	var status *detectiveescalation.TicketStatus
+
+	if s := r.Form.Get("status"); len(s) > 0 {
+		parsed, err := detectiveescalation.ParseTicketStatus(s)
+		if err != nil {
+			return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+		}
+
+		status = &parsed
+	}
+
+	tickets, err := h.manager.EscalationTickets(r.Context(), status)
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+	}
+
+	return httputil.WriteJson(w, tickets, http.StatusOK)
+}
+
+type escalationHandler escalationsHandler
+
+// @Summary Get an escalation ticket, with its comments
+// @Param id query integer true "Ticket id"
+// @Produce json
+// @Success 200 {object} detectiveescalation.Ticket "desc"
+// @Failure 404 {string} string "desc"
+// @Router /api/v0/escalation [get]
+func (h escalationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	if r.ParseForm() != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, errors.New("Wrong Input"))
+	}
+
+	id, err := ticketID(r)
+	if err != nil {
+		return err
+	}
+
+	ticket, err := h.manager.EscalationTicket(r.Context(), id)
+	if err != nil {
+		return ticketError(err)
+	}
+
+	return httputil.WriteJson(w, ticket, http.StatusOK)
+}
+
+type updateEscalationHandler escalationsHandler
+
+// @Summary Change the status or assignee of an escalation ticket
+// @Param id       query integer true "Ticket id"
+// @Param status   query string false "New status: open, investigating or resolved"
+// @Param assignee query string false "New assignee. Empty to unassign"
+// @Success 200 {string} string "desc"
+// @Failure 404 {string} string "desc"
+// @Failure 422 {string} string "desc"
+// @Router /api/v0/updateEscalation [post]
+func (h updateEscalationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return httperror.NewHTTPStatusCodeError(http.StatusMethodNotAllowed, fmt.Errorf("Error http method mismatch: %v", r.Method))
+	}
+
+	if r.ParseForm() != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, errors.New("Wrong Input"))
+	}
+
+	id, err := ticketID(r)
+	if err != nil {
+		return err
+	}
+
+	var update detectiveescalation.TicketUpdate
+
+	if _, ok := r.Form["status"]; ok {
+		status, err := detectiveescalation.ParseTicketStatus(r.Form.Get("status"))
+		if err != nil {
+			return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+		}
+
+		update.Status = &status
+	}
+
+	if _, ok := r.Form["assignee"]; ok {
+		assignee := r.Form.Get("assignee")
+		update.Assignee = &assignee
+	}
+
+	if update.Status == nil && update.Assignee == nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, detectiveescalation.ErrInvalidTicketUpdate)
+	}
+
+	if err := h.manager.UpdateEscalationTicket(r.Context(), id, update); err != nil {
+		return ticketError(err)
+	}
+
+	return httputil.WriteJson(w, "ok", http.StatusOK)
+}
+
+type commentEscalationHandler escalationsHandler
+
+// @Summary Add an internal comment to an escalation ticket, authored by the current user
+// @Param id      query integer true "Ticket id"
+// @Param comment query string true "Comment text"
+// @Success 200 {string} string "desc"
+// @Failure 404 {string} string "desc"
+// @Failure 422 {string} string "desc"
+// @Router /api/v0/commentEscalation [post]
+func (h commentEscalationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return httperror.NewHTTPStatusCodeError(http.StatusMethodNotAllowed, fmt.Errorf("Error http method mismatch: %v", r.Method))
+	}
+
+	sessionData, err := httpauth.GetSessionData(h.auth, r)
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnauthorized, errorutil.Wrap(err))
+	}
+
+	if r.ParseForm() != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, errors.New("Wrong Input"))
+	}
+
+	id, err := ticketID(r)
+	if err != nil {
+		return err
+	}
+
+	author := sessionData.Name
+	if len(author) == 0 {
+		author = sessionData.Email
+	}
+
+	if err := h.manager.CommentEscalationTicket(r.Context(), id, author, r.Form.Get("comment")); err != nil {
+		return ticketError(err)
+	}
+
+	return httputil.WriteJson(w, "ok", http.StatusOK)
+}
+
+func HttpEscalations(auth *httpauth.Authenticator, mux *http.ServeMux, manager detectiveescalation.TicketManager) {
+	handler := escalationsHandler{auth: auth, manager: manager}
+
+	stack := httpmiddleware.WithDefaultStack(auth, httpmiddleware.RequestWithTimeout(httpmiddleware.DefaultTimeout))
+
+	mux.Handle("/api/v0/escalations", stack.WithEndpoint(listEscalationsHandler(handler)))
+	mux.Handle("/api/v0/escalation", stack.WithEndpoint(escalationHandler(handler)))
+	mux.Handle("/api/v0/updateEscalation", stack.WithEndpoint(updateEscalationHandler(handler)))
+	mux.Handle("/api/v0/commentEscalation", stack.WithEndpoint(commentEscalationHandler(handler)))
+}
@@ -0,0 +1,201 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/sessions"
+	. "github.com/smartystreets/goconvey/convey"
+	"gitlab.com/lightmeter/controlcenter/httpauth"
+	"gitlab.com/lightmeter/controlcenter/httpauth/auth"
+	"gitlab.com/lightmeter/controlcenter/insights/detectiveescalation"
+	"gitlab.com/lightmeter/controlcenter/metadata"
+	"gitlab.com/lightmeter/controlcenter/util/testutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type fakeTicketManager struct {
+	tickets []detectiveescalation.Ticket
+}
+
+func (m *fakeTicketManager) find(id int64) (*detectiveescalation.Ticket, error) {
+	for i := range m.tickets {
+		if m.tickets[i].ID == id {
+			return &m.tickets[i], nil
+		}
+	}
+
+	return nil, detectiveescalation.ErrNoSuchTicket
+}
+
+func (m *fakeTicketManager) EscalationTickets(ctx context.Context, status *detectiveescalation.TicketStatus) ([]detectiveescalation.Ticket, error) {
+	tickets := []detectiveescalation.Ticket{}
+
+	for _, t := range m.tickets {
+		if status == nil || *status == t.Status {
+			tickets = append(tickets, t)
+		}
+	}
+
+	return tickets, nil
+}
+
+func (m *fakeTicketManager) EscalationTicket(ctx context.Context, id int64) (detectiveescalation.Ticket, error) {
+	t, err := m.find(id)
+	if err != nil {
+		return detectiveescalation.Ticket{}, err
+	}
+
+	return *t, nil
+}
+
+func (m *fakeTicketManager) UpdateEscalationTicket(ctx context.Context, id int64, update detectiveescalation.TicketUpdate) error {
+	t, err := m.find(id)
+	if err != nil {
+		return err
+	}
+
+	if update.Status != nil {
+		t.Status = *update.Status
+	}
+
+	if update.Assignee != nil {
+		t.Assignee = *update.Assignee
+	}
+
+	return nil
+}
+
+func (m *fakeTicketManager) CommentEscalationTicket(ctx context.Context, id int64, author, content string) error {
+	t, err := m.find(id)
+	if err != nil {
+		return err
+	}
+
+	if len(strings.TrimSpace(content)) == 0 {
+		return fmt.Errorf("Empty comment: %w", detectiveescalation.ErrInvalidTicketUpdate)
+	}
+
+	t.Comments = append(t.Comments, detectiveescalation.Comment{ID: int64(len(t.Comments) + 1), Author: author, Content: content})
+
+	return nil
+}
+
+func TestEscalations(t *testing.T) {
+	Convey("Escalation tickets", t, func() {
+		registrar := &auth.FakeRegistrar{
+			Email:      "alice@example.com",
+			Name:       "Alice",
+			Password:   "super_secret",
+			SessionKey: []byte("AAAAAAAAAAAAAAAA"),
+		}
+
+		authenticator := &auth.Authenticator{
+			Registrar: registrar,
+			Store:     sessions.NewCookieStore([]byte("secret-key")),
+		}
+
+		settingdDB, removeDB := testutil.TempDBConnectionMigrated(t, "master")
+		defer removeDB()
+
+		handler, err := metadata.NewHandler(settingdDB)
+		So(err, ShouldBeNil)
+
+		manager := &fakeTicketManager{tickets: []detectiveescalation.Ticket{
+			{ID: 1, Status: detectiveescalation.OpenStatus, Sender: "sender@example.com", Recipient: "recipient@example.com", Comments: []detectiveescalation.Comment{}},
+			{ID: 2, Status: detectiveescalation.ResolvedStatus, Sender: "sender@example.com", Recipient: "other@example.com", Comments: []detectiveescalation.Comment{}},
+		}}
+
+		mux := http.NewServeMux()
+		HttpEscalations(authenticator, mux, manager)
+
+		httpauth.HttpAuthenticator(mux, authenticator, handler.Reader, true)
+
+		s := httptest.NewServer(mux)
+
+		httpClient := buildCookieClient()
+
+		Convey("Unauthorized access", func() {
+			r, err := httpClient.Get(s.URL + "/api/v0/escalations")
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+			r, err = httpClient.PostForm(s.URL+"/api/v0/updateEscalation", url.Values{"id": {"1"}, "status": {"resolved"}})
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusUnauthorized)
+		})
+
+		Convey("Authorized access", func() {
+			r, err := httpClient.PostForm(s.URL+"/login", url.Values{"email": {"alice@example.com"}, "password": {"super_secret"}})
+			So(r.StatusCode, ShouldEqual, http.StatusOK)
+			So(err, ShouldBeNil)
+
+			Convey("List tickets by status", func() {
+				r, err := httpClient.Get(s.URL + "/api/v0/escalations?status=open")
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+				var tickets []detectiveescalation.Ticket
+				So(json.NewDecoder(r.Body).Decode(&tickets), ShouldBeNil)
+				So(len(tickets), ShouldEqual, 1)
+				So(tickets[0].ID, ShouldEqual, 1)
+
+				r, err = httpClient.Get(s.URL + "/api/v0/escalations?status=closed")
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusUnprocessableEntity)
+			})
+
+			Convey("Invalid updates", func() {
+				for _, values := range []url.Values{
+					{"id": {"1"}},
+					{"id": {"1"}, "status": {"closed"}},
+					{"status": {"resolved"}},
+				} {
+					r, err := httpClient.PostForm(s.URL+"/api/v0/updateEscalation", values)
+					So(err, ShouldBeNil)
+					So(r.StatusCode, ShouldEqual, http.StatusUnprocessableEntity)
+				}
+
+				r, err := httpClient.PostForm(s.URL+"/api/v0/updateEscalation", url.Values{"id": {"42"}, "status": {"resolved"}})
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusNotFound)
+
+				r, err = httpClient.PostForm(s.URL+"/api/v0/commentEscalation", url.Values{"id": {"1"}, "comment": {" "}})
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusUnprocessableEntity)
+			})
+
+			Convey("Update and comment a ticket", func() {
+				r, err := httpClient.PostForm(s.URL+"/api/v0/updateEscalation", url.Values{"id": {"1"}, "status": {"investigating"}, "assignee": {"Bob"}})
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+				r, err = httpClient.PostForm(s.URL+"/api/v0/commentEscalation", url.Values{"id": {"1"}, "comment": {"Asked the recipient's admin"}})
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+				r, err = httpClient.Get(s.URL + "/api/v0/escalation?id=1")
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+				var ticket detectiveescalation.Ticket
+				So(json.NewDecoder(r.Body).Decode(&ticket), ShouldBeNil)
+				So(ticket.Status, ShouldEqual, detectiveescalation.InvestigatingStatus)
+				So(ticket.Assignee, ShouldEqual, "Bob")
+				So(ticket.Comments, ShouldResemble, []detectiveescalation.Comment{{ID: 1, Author: "Alice", Content: "Asked the recipient's admin"}})
+
+				r, err = httpClient.Get(s.URL + "/api/v0/escalation?id=42")
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusNotFound)
+			})
+		})
+	})
+}
@@ -85,6 +85,7 @@ func (h fetchInsightsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 			Content:       fi.Content(),
 			UserRating:    fi.UserRating(),
 			UserRatingOld: fi.UserRatingOld(),
+			Acknowledged:  fi.Acknowledged(),
 		}
 
 		if recommendationHelpLinkProvider, ok := fi.Content().(core.RecommendationHelpLinkProvider); ok {
@@ -107,6 +108,7 @@ type fetchedInsight struct {
 	HelpLink      string      `json:"help_link,omitempty"`
 	UserRating    *int        `json:"user_rating"`
 	UserRatingOld bool        `json:"user_rating_old"`
+	Acknowledged  bool        `json:"acknowledged"`
 }
 
 type fetchInsightsResult []fetchedInsight
@@ -0,0 +1,218 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/httpauth/auth"
+	"gitlab.com/lightmeter/controlcenter/httpmiddleware"
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	"gitlab.com/lightmeter/controlcenter/pkg/httperror"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/httputil"
+)
+
+type insightSuppressionsHandler struct {
+	manager core.SuppressionManager
+}
+
+type suppressionResult struct {
+	ID int64 `json:"id"`
+}
+
+func parseSuppressionForm(r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return httperror.NewHTTPStatusCodeError(http.StatusMethodNotAllowed, fmt.Errorf("Error http method mismatch: %v", r.Method))
+	}
+
+	if r.ParseForm() != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, errors.New("Wrong Input"))
+	}
+
+	return nil
+}
+
+func suppressionID(r *http.Request, key string) (int64, error) {
+	id, err := strconv.ParseInt(r.Form.Get(key), 10, 64)
+	if err != nil {
+		return 0, httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+	}
+
+	return id, nil
+}
+
+func suppressionError(err error) error {
+	if errors.Is(err, core.ErrInvalidSuppression) {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+	}
+
+	if errors.Is(err, core.ErrNoSuchInsight) || errors.Is(err, core.ErrNoSuchSuppression) {
+		return httperror.NewHTTPStatusCodeError(http.StatusNotFound, err)
+	}
+
+	return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+}
+
+type listInsightSuppressionsHandler insightSuppressionsHandler
+
+// @Summary List the mute rules, snoozed and acknowledged insights which have not expired yet
+// @Produce json
+// @Success 200 {object} []core.Suppression "desc"
+// @Router /api/v0/insightSuppressions [get]
+func (h listInsightSuppressionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	suppressions, err := h.manager.InsightSuppressions(r.Context())
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+	}
+
+	return httputil.WriteJson(w, suppressions, http.StatusOK)
+}
+
+type muteInsightsHandler insightSuppressionsHandler
+
+// @Summary Mute the insights generated from now on of some content type, optionally matching some parameters
+// @Param content_type query string true "Insight content type, as message_rbl"
+// @Param param        query string false "A parameter the insights must have, as host=Yahoo. Can be repeated"
+// @Param until        query string false "When the rule expires, in RFC3339 format. Never if not set"
+// @Produce json
+// @Success 200 {object} suppressionResult "desc"
+// @Failure 422 {string} string "desc"
+// @Router /api/v0/muteInsights [post]
+func (h muteInsightsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	if err := parseSuppressionForm(r); err != nil {
+		return err
+	}
+
+	params := map[string]string{}
+
+	for _, p := range r.Form["param"] {
+		// values might contain `=`, but keys don't
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 || len(kv[0]) == 0 {
+			return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, fmt.Errorf("Invalid param: %v", p))
+		}
+
+		params[kv[0]] = kv[1]
+	}
+
+	var until *time.Time
+
+	if s := r.Form.Get("until"); len(s) > 0 {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+		}
+
+		until = &t
+	}
+
+	id, err := h.manager.MuteInsights(r.Context(), r.Form.Get("content_type"), params, until)
+	if err != nil {
+		return suppressionError(err)
+	}
+
+	return httputil.WriteJson(w, suppressionResult{ID: id}, http.StatusOK)
+}
+
+type snoozeInsightHandler insightSuppressionsHandler
+
+// @Summary Hide an insight, and the similar ones generated in the meanwhile, until some time
+// @Param id    query integer true "Insight id"
+// @Param until query string true "When the insight shows up again, in RFC3339 format"
+// @Produce json
+// @Success 200 {object} suppressionResult "desc"
+// @Failure 404 {string} string "desc"
+// @Failure 422 {string} string "desc"
+// @Router /api/v0/snoozeInsight [post]
+func (h snoozeInsightHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	if err := parseSuppressionForm(r); err != nil {
+		return err
+	}
+
+	insightID, err := suppressionID(r, "id")
+	if err != nil {
+		return err
+	}
+
+	until, err := time.Parse(time.RFC3339, r.Form.Get("until"))
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+	}
+
+	id, err := h.manager.SnoozeInsight(r.Context(), insightID, until)
+	if err != nil {
+		return suppressionError(err)
+	}
+
+	return httputil.WriteJson(w, suppressionResult{ID: id}, http.StatusOK)
+}
+
+type acknowledgeInsightHandler insightSuppressionsHandler
+
+// @Summary Keep an insight visible, but stop notifying about it and the similar ones generated afterwards
+// @Param id query integer true "Insight id"
+// @Produce json
+// @Success 200 {object} suppressionResult "desc"
+// @Failure 404 {string} string "desc"
+// @Router /api/v0/acknowledgeInsight [post]
+func (h acknowledgeInsightHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	if err := parseSuppressionForm(r); err != nil {
+		return err
+	}
+
+	insightID, err := suppressionID(r, "id")
+	if err != nil {
+		return err
+	}
+
+	id, err := h.manager.AcknowledgeInsight(r.Context(), insightID)
+	if err != nil {
+		return suppressionError(err)
+	}
+
+	return httputil.WriteJson(w, suppressionResult{ID: id}, http.StatusOK)
+}
+
+type deleteInsightSuppressionHandler insightSuppressionsHandler
+
+// @Summary Delete a mute rule, snooze or acknowledgement, making the insights it hid visible again
+// @Param id query integer true "Suppression id"
+// @Success 200 {string} string "desc"
+// @Failure 404 {string} string "desc"
+// @Router /api/v0/deleteInsightSuppression [post]
+func (h deleteInsightSuppressionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	if err := parseSuppressionForm(r); err != nil {
+		return err
+	}
+
+	id, err := suppressionID(r, "id")
+	if err != nil {
+		return err
+	}
+
+	if err := h.manager.DeleteInsightSuppression(r.Context(), id); err != nil {
+		return suppressionError(err)
+	}
+
+	return httputil.WriteJson(w, "ok", http.StatusOK)
+}
+
+func HttpInsightSuppressions(auth *auth.Authenticator, mux *http.ServeMux, manager core.SuppressionManager) {
+	handler := insightSuppressionsHandler{manager: manager}
+
+	stack := httpmiddleware.WithDefaultStack(auth, httpmiddleware.RequestWithTimeout(httpmiddleware.DefaultTimeout))
+
+	mux.Handle("/api/v0/insightSuppressions", stack.WithEndpoint(listInsightSuppressionsHandler(handler)))
+	mux.Handle("/api/v0/muteInsights", stack.WithEndpoint(muteInsightsHandler(handler)))
+	mux.Handle("/api/v0/snoozeInsight", stack.WithEndpoint(snoozeInsightHandler(handler)))
+	mux.Handle("/api/v0/acknowledgeInsight", stack.WithEndpoint(acknowledgeInsightHandler(handler)))
+	mux.Handle("/api/v0/deleteInsightSuppression", stack.WithEndpoint(deleteInsightSuppressionHandler(handler)))
+}
@@ -0,0 +1,190 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gitlab.com/lightmeter/controlcenter/httpmiddleware"
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+)
+
+type fakeSuppressionManager struct {
+	suppressions []core.Suppression
+}
+
+func (m *fakeSuppressionManager) add(s core.Suppression) int64 {
+	s.ID = int64(len(m.suppressions) + 1)
+	m.suppressions = append(m.suppressions, s)
+	return s.ID
+}
+
+func (m *fakeSuppressionManager) MuteInsights(ctx context.Context, contentType string, params map[string]string, until *time.Time) (int64, error) {
+	if contentType != "message_rbl" {
+		return 0, fmt.Errorf("Unknown content type: %w", core.ErrInvalidSuppression)
+	}
+
+	return m.add(core.Suppression{Kind: core.MuteSuppression, ContentType: contentType, Params: params, Until: until}), nil
+}
+
+func (m *fakeSuppressionManager) SnoozeInsight(ctx context.Context, id int64, until time.Time) (int64, error) {
+	if id != 42 {
+		return 0, core.ErrNoSuchInsight
+	}
+
+	return m.add(core.Suppression{Kind: core.SnoozeSuppression, ContentType: "message_rbl", Until: &until, InsightID: id}), nil
+}
+
+func (m *fakeSuppressionManager) AcknowledgeInsight(ctx context.Context, id int64) (int64, error) {
+	if id != 42 {
+		return 0, core.ErrNoSuchInsight
+	}
+
+	return m.add(core.Suppression{Kind: core.AcknowledgeSuppression, ContentType: "message_rbl", InsightID: id}), nil
+}
+
+func (m *fakeSuppressionManager) DeleteInsightSuppression(ctx context.Context, id int64) error {
+	for i, s := range m.suppressions {
+		if s.ID == id {
+			m.suppressions = append(m.suppressions[:i], m.suppressions[i+1:]...)
+			return nil
+		}
+	}
+
+	return core.ErrNoSuchSuppression
+}
+
+func (m *fakeSuppressionManager) InsightSuppressions(ctx context.Context) ([]core.Suppression, error) {
+	return m.suppressions, nil
+}
+
+func TestInsightSuppressions(t *testing.T) {
+	Convey("Insight suppressions", t, func() {
+		m := &fakeSuppressionManager{}
+
+		handler := insightSuppressionsHandler{manager: m}
+
+		chain := httpmiddleware.New()
+
+		mux := http.NewServeMux()
+		mux.Handle("/insightSuppressions", chain.WithEndpoint(listInsightSuppressionsHandler(handler)))
+		mux.Handle("/muteInsights", chain.WithEndpoint(muteInsightsHandler(handler)))
+		mux.Handle("/snoozeInsight", chain.WithEndpoint(snoozeInsightHandler(handler)))
+		mux.Handle("/acknowledgeInsight", chain.WithEndpoint(acknowledgeInsightHandler(handler)))
+		mux.Handle("/deleteInsightSuppression", chain.WithEndpoint(deleteInsightSuppressionHandler(handler)))
+
+		s := httptest.NewServer(mux)
+		defer s.Close()
+
+		post := func(path string, values url.Values) (int, map[string]interface{}) {
+			r, err := http.PostForm(s.URL+path, values)
+			So(err, ShouldBeNil)
+
+			defer r.Body.Close()
+
+			if r.StatusCode != http.StatusOK {
+				return r.StatusCode, nil
+			}
+
+			var body map[string]interface{}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+
+			return r.StatusCode, body
+		}
+
+		list := func() []interface{} {
+			r, err := http.Get(s.URL + "/insightSuppressions")
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+			defer r.Body.Close()
+
+			var body []interface{}
+			So(json.NewDecoder(r.Body).Decode(&body), ShouldBeNil)
+
+			return body
+		}
+
+		Convey("Only POST is allowed on changes", func() {
+			r, err := http.Get(s.URL + "/muteInsights?content_type=message_rbl")
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+
+		Convey("Mute insights", func() {
+			code, body := post("/muteInsights", url.Values{
+				"content_type": {"message_rbl"},
+				"param":        {"host=Yahoo", "address=1.2.3.4"},
+				"until":        {"2021-01-01T10:00:00Z"},
+			})
+
+			So(code, ShouldEqual, http.StatusOK)
+			So(body, ShouldResemble, map[string]interface{}{"id": float64(1)})
+
+			So(list(), ShouldResemble, []interface{}{
+				map[string]interface{}{
+					"id":           float64(1),
+					"kind":         "mute",
+					"content_type": "message_rbl",
+					"params":       map[string]interface{}{"host": "Yahoo", "address": "1.2.3.4"},
+					"created_at":   "0001-01-01T00:00:00Z",
+					"until":        "2021-01-01T10:00:00Z",
+				},
+			})
+
+			Convey("And delete the rule", func() {
+				code, _ := post("/deleteInsightSuppression", url.Values{"id": {"1"}})
+				So(code, ShouldEqual, http.StatusOK)
+				So(list(), ShouldBeEmpty)
+
+				code, _ = post("/deleteInsightSuppression", url.Values{"id": {"1"}})
+				So(code, ShouldEqual, http.StatusNotFound)
+			})
+		})
+
+		Convey("Invalid mute rules", func() {
+			code, _ := post("/muteInsights", url.Values{"content_type": {"unknown"}})
+			So(code, ShouldEqual, http.StatusUnprocessableEntity)
+
+			code, _ = post("/muteInsights", url.Values{"content_type": {"message_rbl"}, "param": {"no_value"}})
+			So(code, ShouldEqual, http.StatusUnprocessableEntity)
+
+			code, _ = post("/muteInsights", url.Values{"content_type": {"message_rbl"}, "until": {"tomorrow"}})
+			So(code, ShouldEqual, http.StatusUnprocessableEntity)
+		})
+
+		Convey("Snooze insight", func() {
+			code, body := post("/snoozeInsight", url.Values{"id": {"42"}, "until": {"2021-01-01T10:00:00Z"}})
+			So(code, ShouldEqual, http.StatusOK)
+			So(body, ShouldResemble, map[string]interface{}{"id": float64(1)})
+
+			code, _ = post("/snoozeInsight", url.Values{"id": {"42"}})
+			So(code, ShouldEqual, http.StatusUnprocessableEntity)
+
+			code, _ = post("/snoozeInsight", url.Values{"id": {"43"}, "until": {"2021-01-01T10:00:00Z"}})
+			So(code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("Acknowledge insight", func() {
+			code, body := post("/acknowledgeInsight", url.Values{"id": {"42"}})
+			So(code, ShouldEqual, http.StatusOK)
+			So(body, ShouldResemble, map[string]interface{}{"id": float64(1)})
+
+			code, _ = post("/acknowledgeInsight", url.Values{"id": {"not_a_number"}})
+			So(code, ShouldEqual, http.StatusUnprocessableEntity)
+
+			code, _ = post("/acknowledgeInsight", url.Values{"id": {"43"}})
+			So(code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
@@ -31,6 +31,7 @@ type fakeFetchedInsight struct {
 	content       core.Content
 	userRating    *int
 	userRatingOld bool
+	acknowledged  bool
 }
 
 func (f *fakeFetchedInsight) ID() int {
@@ -65,6 +66,10 @@ func (f *fakeFetchedInsight) UserRatingOld() bool {
 	return f.userRatingOld
 }
 
+func (f *fakeFetchedInsight) Acknowledged() bool {
+	return f.acknowledged
+}
+
 type content struct {
 	V           string `json:"v"`
 	ContentType string `json:"content_type"`
@@ -193,6 +198,7 @@ func TestInsights(t *testing.T) {
 					"help_link":       "https://kb.lightemter.io/KB0002",
 					"user_rating":     nil,
 					"user_rating_old": true,
+					"acknowledged":    false,
 				},
 				map[string]interface{}{
 					"category":        "local",
@@ -204,6 +210,7 @@ func TestInsights(t *testing.T) {
 					"help_link":       "https://kb.lightemter.io/KB0001",
 					"user_rating":     nil,
 					"user_rating_old": true,
+					"acknowledged":    false,
 				},
 			})
 		})
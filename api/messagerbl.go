@@ -0,0 +1,213 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gitlab.com/lightmeter/controlcenter/httpauth/auth"
+	"gitlab.com/lightmeter/controlcenter/httpmiddleware"
+	"gitlab.com/lightmeter/controlcenter/messagerbl"
+	"gitlab.com/lightmeter/controlcenter/pkg/httperror"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/httputil"
+)
+
+type messageRBLMatchersHandler struct {
+	manager messagerbl.Manager
+}
+
+func parseMatchersForm(r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return httperror.NewHTTPStatusCodeError(http.StatusMethodNotAllowed, fmt.Errorf("Error http method mismatch: %v", r.Method))
+	}
+
+	if r.ParseForm() != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, errors.New("Wrong Input"))
+	}
+
+	return nil
+}
+
+func matcherDefinitionFromForm(r *http.Request) messagerbl.MatcherDefinition {
+	return messagerbl.MatcherDefinition{
+		ID:      strings.TrimSpace(r.Form.Get("id")),
+		Host:    strings.TrimSpace(r.Form.Get("host")),
+		DSN:     strings.TrimSpace(r.Form.Get("dsn")),
+		Pattern: r.Form.Get("pattern"),
+		Samples: r.Form["sample"],
+		Notes:   r.Form.Get("notes"),
+	}
+}
+
+func matchersError(err error) error {
+	if errors.Is(err, messagerbl.ErrInvalidMatcher) {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+	}
+
+	if errors.Is(err, messagerbl.ErrNoSuchMatcher) {
+		return httperror.NewHTTPStatusCodeError(http.StatusNotFound, err)
+	}
+
+	return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+}
+
+type listMessageRBLMatchersHandler messageRBLMatchersHandler
+
+// @Summary List the built-in and custom matchers used to detect that a host blocked the sender IP
+// @Produce json
+// @Success 200 {object} messagerbl.MatchersList "desc"
+// @Router /api/v0/messageRBLMatchers [get]
+func (h listMessageRBLMatchersHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	list, err := h.manager.Matchers(r.Context())
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+	}
+
+	return httputil.WriteJson(w, list, http.StatusOK)
+}
+
+type addMessageRBLMatcherHandler messageRBLMatchersHandler
+
+// @Summary Add a custom matcher, or replace the custom matcher with the same id
+// @Param id      query string true "Matcher id"
+// @Param host    query string true "Name of the host which blocks the sender IP, as Google"
+// @Param dsn     query string false "DSN of the failed deliveries. Any if not set"
+// @Param pattern query string true "Regular expression matching the message returned by the host"
+// @Param sample  query string false "A log line the matcher must match. Can be repeated"
+// @Param notes   query string false "Free text notes"
+// @Success 200 {string} string "desc"
+// @Failure 422 {string} string "desc"
+// @Router /api/v0/addMessageRBLMatcher [post]
+func (h addMessageRBLMatcherHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	if err := parseMatchersForm(r); err != nil {
+		return err
+	}
+
+	if err := h.manager.AddMatcher(r.Context(), matcherDefinitionFromForm(r)); err != nil {
+		return matchersError(err)
+	}
+
+	return httputil.WriteJson(w, "ok", http.StatusOK)
+}
+
+type deleteMessageRBLMatcherHandler messageRBLMatchersHandler
+
+// @Summary Delete a custom matcher
+// @Param id query string true "Matcher id"
+// @Success 200 {string} string "desc"
+// @Failure 404 {string} string "desc"
+// @Router /api/v0/deleteMessageRBLMatcher [post]
+func (h deleteMessageRBLMatcherHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	if err := parseMatchersForm(r); err != nil {
+		return err
+	}
+
+	if err := h.manager.DeleteMatcher(r.Context(), r.Form.Get("id")); err != nil {
+		return matchersError(err)
+	}
+
+	return httputil.WriteJson(w, "ok", http.StatusOK)
+}
+
+type enableMessageRBLMatcherHandler messageRBLMatchersHandler
+
+// @Summary Enable or disable a built-in or custom matcher
+// @Param id      query string true "Matcher id"
+// @Param enabled query boolean true "Whether the matcher is used"
+// @Success 200 {string} string "desc"
+// @Failure 404 {string} string "desc"
+// @Failure 422 {string} string "desc"
+// @Router /api/v0/enableMessageRBLMatcher [post]
+func (h enableMessageRBLMatcherHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	if err := parseMatchersForm(r); err != nil {
+		return err
+	}
+
+	enabled, err := strconv.ParseBool(r.Form.Get("enabled"))
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+	}
+
+	if err := h.manager.EnableMatcher(r.Context(), r.Form.Get("id"), enabled); err != nil {
+		return matchersError(err)
+	}
+
+	return httputil.WriteJson(w, "ok", http.StatusOK)
+}
+
+type testMessageRBLMatcherHandler messageRBLMatchersHandler
+
+// @Summary Tell which log lines a matcher, not yet added, matches
+// @Param host    query string true "Name of the host which blocks the sender IP, as Google"
+// @Param dsn     query string false "DSN of the failed deliveries. Any if not set"
+// @Param pattern query string true "Regular expression matching the message returned by the host"
+// @Param line    query string true "A log line of a failed delivery. Can be repeated"
+// @Produce json
+// @Success 200 {object} []bool "desc"
+// @Failure 422 {string} string "desc"
+// @Router /api/v0/testMessageRBLMatcher [post]
+func (h testMessageRBLMatcherHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	if err := parseMatchersForm(r); err != nil {
+		return err
+	}
+
+	d := matcherDefinitionFromForm(r)
+
+	// the id is irrelevant for testing
+	if len(d.ID) == 0 {
+		d.ID = "test"
+	}
+
+	results, err := messagerbl.TestMatcher(d, r.Form["line"])
+	if err != nil {
+		return matchersError(err)
+	}
+
+	return httputil.WriteJson(w, results, http.StatusOK)
+}
+
+type updateBuiltInMessageRBLMatchersHandler messageRBLMatchersHandler
+
+// @Summary Replace the built-in matchers by a newer version of them, in the same format as the shipped messagerbl/matchers.json
+// @Param matchers query string true "JSON object with version and matchers"
+// @Success 200 {string} string "desc"
+// @Failure 422 {string} string "desc"
+// @Router /api/v0/updateBuiltInMessageRBLMatchers [post]
+func (h updateBuiltInMessageRBLMatchersHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	if err := parseMatchersForm(r); err != nil {
+		return err
+	}
+
+	var b messagerbl.BuiltInMatchers
+
+	if err := json.Unmarshal([]byte(r.Form.Get("matchers")), &b); err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+	}
+
+	if err := h.manager.UpdateBuiltInMatchers(r.Context(), b); err != nil {
+		return matchersError(err)
+	}
+
+	return httputil.WriteJson(w, "ok", http.StatusOK)
+}
+
+func HttpMessageRBLMatchers(auth *auth.Authenticator, mux *http.ServeMux, manager messagerbl.Manager) {
+	handler := messageRBLMatchersHandler{manager: manager}
+
+	stack := httpmiddleware.WithDefaultStack(auth, httpmiddleware.RequestWithTimeout(httpmiddleware.DefaultTimeout))
+
+	mux.Handle("/api/v0/messageRBLMatchers", stack.WithEndpoint(listMessageRBLMatchersHandler(handler)))
+	mux.Handle("/api/v0/addMessageRBLMatcher", stack.WithEndpoint(addMessageRBLMatcherHandler(handler)))
+	mux.Handle("/api/v0/deleteMessageRBLMatcher", stack.WithEndpoint(deleteMessageRBLMatcherHandler(handler)))
+	mux.Handle("/api/v0/enableMessageRBLMatcher", stack.WithEndpoint(enableMessageRBLMatcherHandler(handler)))
+	mux.Handle("/api/v0/testMessageRBLMatcher", stack.WithEndpoint(testMessageRBLMatcherHandler(handler)))
+	mux.Handle("/api/v0/updateBuiltInMessageRBLMatchers", stack.WithEndpoint(updateBuiltInMessageRBLMatchersHandler(handler)))
+}
@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"gitlab.com/lightmeter/controlcenter/httpmiddleware"
+	"gitlab.com/lightmeter/controlcenter/pkg/ctxlogger"
+	"gitlab.com/lightmeter/controlcenter/pkg/httperror"
+	"gitlab.com/lightmeter/controlcenter/pkg/metrics"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+// MetricsOptions restricts the access to the metrics. A request is accepted if it either
+// has the token, as "Authorization: Bearer <token>", or comes from one of the networks.
+// The networks are compared with the address of the connection, as the headers set
+// by a reverse proxy could be forged by anyone.
+type MetricsOptions struct {
+	Token           string
+	AllowedNetworks []*net.IPNet
+}
+
+func (o MetricsOptions) enabled() bool {
+	return len(o.Token) > 0 || len(o.AllowedNetworks) > 0
+}
+
+var errMetricsForbidden = errors.New("Access to the metrics is not allowed")
+
+func (o MetricsOptions) allow(r *http.Request) bool {
+	if len(o.Token) > 0 {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		if subtle.ConstantTimeCompare([]byte(token), []byte(o.Token)) == 1 {
+			return true
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range o.AllowedNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type metricsHandler struct {
+	options  MetricsOptions
+	registry *metrics.Registry
+	collect  func(context.Context) error
+}
+
+// @Summary Metrics in the Prometheus text format
+// @Produce plain
+// @Success 200 {string} string "desc"
+// @Failure 403 {string} string "desc"
+// @Router /metrics [get]
+func (h metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	if !h.options.allow(r) {
+		return httperror.NewHTTPStatusCodeError(http.StatusForbidden, errMetricsForbidden)
+	}
+
+	// the other metrics are still useful if some of them cannot be collected
+	if err := h.collect(r.Context()); err != nil {
+		ctxlogger.LogErrorf(r.Context(), errorutil.Wrap(err), "collecting metrics")
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	if err := h.registry.Write(w); err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, err)
+	}
+
+	return nil
+}
+
+// HttpMetrics exposes the metrics on /metrics, unless no access restriction is configured,
+// as they reveal information about the traffic
+func HttpMetrics(mux *http.ServeMux, options MetricsOptions, collect func(context.Context) error) {
+	if !options.enabled() {
+		return
+	}
+
+	mux.Handle("/metrics", httpmiddleware.WithDefaultStackWithoutAuth().
+		WithEndpoint(metricsHandler{options: options, registry: metrics.Default, collect: collect}))
+}
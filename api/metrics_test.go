@@ -0,0 +1,124 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"context"
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	Convey("Metrics endpoint", t, func() {
+		collected := 0
+
+		collect := func(context.Context) error {
+			collected++
+			return nil
+		}
+
+		get := func(url, token string) *http.Response {
+			req, err := http.NewRequest(http.MethodGet, url+"/metrics", nil)
+			So(err, ShouldBeNil)
+
+			if len(token) > 0 {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+
+			r, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+
+			return r
+		}
+
+		Convey("Disabled unless restricted", func() {
+			mux := http.NewServeMux()
+			HttpMetrics(mux, MetricsOptions{}, collect)
+
+			s := httptest.NewServer(mux)
+			defer s.Close()
+
+			So(get(s.URL, "").StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("Restricted by token", func() {
+			mux := http.NewServeMux()
+			HttpMetrics(mux, MetricsOptions{Token: "secret"}, collect)
+
+			s := httptest.NewServer(mux)
+			defer s.Close()
+
+			So(get(s.URL, "").StatusCode, ShouldEqual, http.StatusForbidden)
+			So(get(s.URL, "wrong").StatusCode, ShouldEqual, http.StatusForbidden)
+			So(collected, ShouldEqual, 0)
+
+			r := get(s.URL, "secret")
+			So(r.StatusCode, ShouldEqual, http.StatusOK)
+			So(r.Header.Get("Content-Type"), ShouldStartWith, "text/plain")
+			So(collected, ShouldEqual, 1)
+
+			body, err := io.ReadAll(r.Body)
+			So(err, ShouldBeNil)
+			So(string(body), ShouldContainSubstring, "# TYPE lightmeter_notifications_total counter")
+		})
+
+		Convey("Restricted by network", func() {
+			_, local, err := net.ParseCIDR("127.0.0.0/8")
+			So(err, ShouldBeNil)
+
+			_, other, err := net.ParseCIDR("10.0.0.0/8")
+			So(err, ShouldBeNil)
+
+			Convey("Allowed network", func() {
+				mux := http.NewServeMux()
+				HttpMetrics(mux, MetricsOptions{AllowedNetworks: []*net.IPNet{other, local}}, collect)
+
+				s := httptest.NewServer(mux)
+				defer s.Close()
+
+				So(get(s.URL, "").StatusCode, ShouldEqual, http.StatusOK)
+			})
+
+			Convey("Forbidden network, and reverse proxy headers are ignored", func() {
+				mux := http.NewServeMux()
+				HttpMetrics(mux, MetricsOptions{AllowedNetworks: []*net.IPNet{other}}, collect)
+
+				s := httptest.NewServer(mux)
+				defer s.Close()
+
+				req, err := http.NewRequest(http.MethodGet, s.URL+"/metrics", nil)
+				So(err, ShouldBeNil)
+				req.Header.Set("X-Forwarded-For", "10.0.0.1")
+
+				r, err := http.DefaultClient.Do(req)
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusForbidden)
+			})
+		})
+
+		Convey("Metrics are exposed even if some cannot be collected", func() {
+			mux := http.NewServeMux()
+			HttpMetrics(mux, MetricsOptions{Token: "secret"}, func(context.Context) error {
+				return errors.New("Some error")
+			})
+
+			s := httptest.NewServer(mux)
+			defer s.Close()
+
+			r := get(s.URL, "secret")
+			So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+			body, err := io.ReadAll(r.Body)
+			So(err, ShouldBeNil)
+			So(strings.Contains(string(body), "lightmeter_notifications_total"), ShouldBeTrue)
+		})
+	})
+}
@@ -16,6 +16,7 @@ import (
 
 	"gitlab.com/lightmeter/controlcenter/httpauth/auth"
 	"gitlab.com/lightmeter/controlcenter/httpmiddleware"
+	"gitlab.com/lightmeter/controlcenter/pkg/httperror"
 	"gitlab.com/lightmeter/controlcenter/rawlogsdb"
 	"gitlab.com/lightmeter/controlcenter/util/errorutil"
 	"gitlab.com/lightmeter/controlcenter/util/httputil"
@@ -160,10 +161,89 @@ func (h countRawLogLinesHandler) ServeHTTP(w http.ResponseWriter, r *http.Reques
 	return httputil.WriteJson(w, logLinesCounterResult{Count: count}, http.StatusOK)
 }
 
+type searchRawLogLinesHandler fetchLogsHandler
+
+// @Summary Search for log lines in time interval, with surrounding context lines
+// @Param from query string true "Initial date in the format 1999-12-23"
+// @Param to   query string true "Final date in the format 1999-12-23"
+// @Param query query string true "What to search for"
+// @Param mode query string false "How to search: substring (default, case insensitive), token (all the words, case insensitive) or regex"
+// @Param context query integer false "Number of lines before and after each match to return. Default 0, max 10"
+// @Param pageSize query integer true "Max number of matches to return, up to 1000"
+// @Param cursor query integer true "Cursor received from the previously fetched page. 0 if first page"
+// @Produce json
+// @Success 200 {object} rawlogsdb.SearchResult "desc"
+// @Failure 422 {string} string "desc"
+// @Router /api/v0/searchRawLogsInTimeInterval [get]
+func (h searchRawLogLinesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	interval := httpmiddleware.GetIntervalFromContext(r)
+
+	options, err := func() (rawlogsdb.SearchOptions, error) {
+		options := rawlogsdb.SearchOptions{
+			Mode:  rawlogsdb.SubstringSearch,
+			Query: r.Form.Get("query"),
+		}
+
+		if mode := r.Form.Get("mode"); len(mode) > 0 {
+			options.Mode = rawlogsdb.SearchMode(mode)
+		}
+
+		var err error
+
+		if contextLines := r.Form.Get("context"); len(contextLines) > 0 {
+			if options.ContextLines, err = strconv.Atoi(contextLines); err != nil {
+				return rawlogsdb.SearchOptions{}, errors.New(`Invalid number of context lines`)
+			}
+		}
+
+		if options.PageSize, err = strconv.Atoi(r.Form.Get("pageSize")); err != nil {
+			return rawlogsdb.SearchOptions{}, errors.New(`Invalid page size`)
+		}
+
+		if options.Cursor, err = strconv.ParseInt(r.Form.Get("cursor"), 10, 64); err != nil {
+			return rawlogsdb.SearchOptions{}, errors.New(`Invalid cursor`)
+		}
+
+		return options, nil
+	}()
+
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+	}
+
+	result, err := h.accessor.SearchLogsInInterval(r.Context(), interval, options)
+	if err != nil && errors.Is(err, rawlogsdb.ErrInvalidSearch) {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+	}
+
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return httputil.WriteJson(w, result, http.StatusOK)
+}
+
+type unsupportedLogLinesHandler fetchLogsHandler
+
+// @Summary Log lines which could not be parsed, by process and daemon, grouped by signature, with some samples
+// @Produce json
+// @Success 200 {object} rawlogsdb.UnsupportedLinesReport "desc"
+// @Router /api/v0/unsupportedLogLines [get]
+func (h unsupportedLogLinesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	report, err := h.accessor.UnsupportedLinesReport(r.Context())
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return httputil.WriteJson(w, report, http.StatusOK)
+}
+
 func HttpRawLogs(auth *auth.Authenticator, mux *http.ServeMux, timezone *time.Location, accessor rawlogsdb.Accessor) {
 	authenticated := httpmiddleware.WithDefaultStack(auth, httpmiddleware.RequestWithInterval(timezone))
 
 	mux.Handle("/api/v0/fetchLogLinesInTimeInterval", authenticated.WithEndpoint(fetchPagedLogLinesHandler{accessor}))
 	mux.Handle("/api/v0/fetchRawLogsInTimeInterval", authenticated.WithEndpoint(fetchRawLogLinesToWriterHandler{accessor}))
 	mux.Handle("/api/v0/countRawLogLinesInTimeInterval", authenticated.WithEndpoint(countRawLogLinesHandler{accessor}))
+	mux.Handle("/api/v0/searchRawLogsInTimeInterval", authenticated.WithEndpoint(searchRawLogLinesHandler{accessor}))
+	mux.Handle("/api/v0/unsupportedLogLines", httpmiddleware.WithDefaultStack(auth).WithEndpoint(unsupportedLogLinesHandler{accessor}))
 }
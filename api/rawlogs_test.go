@@ -51,6 +51,48 @@ func (f *fakeRawLogsFetcher) FetchLogLine(context.Context, time.Time, postfix.Su
 	return "", nil
 }
 
+func (f *fakeRawLogsFetcher) SearchLogsInInterval(ctx context.Context, interval timeutil.TimeInterval, options rawlogsdb.SearchOptions) (rawlogsdb.SearchResult, error) {
+	if options.Mode == rawlogsdb.RegexSearch && options.Query == "(" {
+		return rawlogsdb.SearchResult{}, rawlogsdb.ErrInvalidSearch
+	}
+
+	return rawlogsdb.SearchResult{
+		Cursor: 43,
+		Matches: []rawlogsdb.SearchMatch{
+			{
+				ContentRow: rawlogsdb.ContentRow{Timestamp: 36, Content: `log line 2 ` + options.Query},
+				Before:     []rawlogsdb.ContentRow{{Timestamp: 35, Content: `log line 1`}},
+				After:      []rawlogsdb.ContentRow{},
+			},
+		},
+	}, nil
+}
+
+func (f *fakeRawLogsFetcher) UnsupportedLinesReport(context.Context) (rawlogsdb.UnsupportedLinesReport, error) {
+	return rawlogsdb.UnsupportedLinesReport{
+		Total:       10,
+		Unsupported: 2,
+		Groups: []rawlogsdb.UnsupportedLinesGroup{
+			{
+				Process:     "postfix",
+				Daemon:      "smtpd",
+				Since:       time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC),
+				Total:       5,
+				Unsupported: 2,
+				Signatures: []rawlogsdb.UnsupportedLineSignature{
+					{
+						Signature: "some new line <n>",
+						Count:     2,
+						FirstSeen: time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC),
+						LastSeen:  time.Date(2020, time.January, 2, 0, 0, 0, 0, time.UTC),
+						Samples:   []string{"some new line 1", "some new line 2"},
+					},
+				},
+			},
+		},
+	}, nil
+}
+
 func TestFetchingRawLogs(t *testing.T) {
 	Convey("Fetch Raw Logs", t, func() {
 		registrar := &auth.FakeRegistrar{
@@ -105,6 +147,20 @@ func TestFetchingRawLogs(t *testing.T) {
 				So(count.Count, ShouldEqual, 42)
 			})
 
+			Convey("Unsupported log lines report", func() {
+				r, err := httpClient.Get(s.URL + "/api/v0/unsupportedLogLines")
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+				report := rawlogsdb.UnsupportedLinesReport{}
+				err = json.NewDecoder(r.Body).Decode(&report)
+				So(err, ShouldBeNil)
+
+				expected, err := fetcher.UnsupportedLinesReport(context.Background())
+				So(err, ShouldBeNil)
+				So(report, ShouldResemble, expected)
+			})
+
 			Convey("Fetching paginated log lines", func() {
 				r, err := httpClient.Get(s.URL + "/api/v0/fetchLogLinesInTimeInterval?from=2000-01-01&to=4000-01-01&cursor=0&pageSize=10")
 				So(err, ShouldBeNil)
@@ -125,6 +181,42 @@ func TestFetchingRawLogs(t *testing.T) {
 				})
 			})
 
+			Convey("Searching log lines", func() {
+				r, err := httpClient.Get(s.URL + "/api/v0/searchRawLogsInTimeInterval?from=2000-01-01&to=4000-01-01&query=smtp&context=1&cursor=0&pageSize=10")
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusOK)
+				So(r.Header.Get("Content-Type"), ShouldEqual, "application/json")
+
+				result := rawlogsdb.SearchResult{}
+
+				err = json.NewDecoder(r.Body).Decode(&result)
+				So(err, ShouldBeNil)
+
+				So(result, ShouldResemble, rawlogsdb.SearchResult{
+					Cursor: 43,
+					Matches: []rawlogsdb.SearchMatch{
+						{
+							ContentRow: rawlogsdb.ContentRow{Timestamp: 36, Content: `log line 2 smtp`},
+							Before:     []rawlogsdb.ContentRow{{Timestamp: 35, Content: `log line 1`}},
+							After:      []rawlogsdb.ContentRow{},
+						},
+					},
+				})
+
+				Convey("Invalid searches", func() {
+					for _, params := range []string{
+						"query=smtp&context=a&cursor=0&pageSize=10",
+						"query=smtp&cursor=0&pageSize=a",
+						"query=smtp&cursor=a&pageSize=10",
+						"query=(&mode=regex&cursor=0&pageSize=10",
+					} {
+						r, err := httpClient.Get(s.URL + "/api/v0/searchRawLogsInTimeInterval?from=2000-01-01&to=4000-01-01&" + params)
+						So(err, ShouldBeNil)
+						So(r.StatusCode, ShouldEqual, http.StatusUnprocessableEntity)
+					}
+				})
+			})
+
 			Convey("Fetching raw logs", func() {
 				Convey("Fetch plain logs", func() {
 					r, err := httpClient.Get(s.URL + "/api/v0/fetchRawLogsInTimeInterval?from=2000-01-01&to=4000-01-01&format=plain")
@@ -0,0 +1,136 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/httpauth/auth"
+	"gitlab.com/lightmeter/controlcenter/httpmiddleware"
+	localrblinsight "gitlab.com/lightmeter/controlcenter/insights/localrbl"
+	"gitlab.com/lightmeter/controlcenter/pkg/httperror"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/httputil"
+)
+
+// Maximum interval for the reminders about delisting requests, 30 days
+const maxDelistingRemindIntervalInHours = 24 * 30
+
+type rblHistoryHandler struct {
+	manager localrblinsight.HistoryManager
+}
+
+func parseDelistingForm(r *http.Request) (int64, error) {
+	if err := parseSuppressionForm(r); err != nil {
+		return 0, err
+	}
+
+	id, err := strconv.ParseInt(r.Form.Get("id"), 10, 64)
+	if err != nil {
+		return 0, httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+	}
+
+	return id, nil
+}
+
+func delistingError(err error) error {
+	if errors.Is(err, localrblinsight.ErrNoSuchListing) {
+		return httperror.NewHTTPStatusCodeError(http.StatusNotFound, err)
+	}
+
+	if errors.Is(err, localrblinsight.ErrAlreadyDelisted) || errors.Is(err, localrblinsight.ErrNotChecked) {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+	}
+
+	return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+}
+
+type listRBLHistoryHandler rblHistoryHandler
+
+// @Summary For each local IP and RBL which ever listed it, when it was listed and delisted, and how long delisting took
+// @Produce json
+// @Success 200 {object} []localrblinsight.Timeline "desc"
+// @Router /api/v0/rblListingHistory [get]
+func (h listRBLHistoryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	timelines, err := h.manager.RBLListingHistory(r.Context())
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+	}
+
+	return httputil.WriteJson(w, timelines, http.StatusOK)
+}
+
+type requestRBLDelistingHandler rblHistoryHandler
+
+// @Summary Record that the removal of an IP from a RBL was requested, to be reminded if it's still listed after a while
+// @Param id           query integer true "Listing id"
+// @Param remind_after query integer false "Hours after which to remind if it's still listed, 48 by default"
+// @Param notes        query string false "Notes about the request, as a ticket number"
+// @Success 200 {string} string "desc"
+// @Failure 404 {string} string "desc"
+// @Failure 422 {string} string "desc"
+// @Router /api/v0/requestRBLDelisting [post]
+func (h requestRBLDelistingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	id, err := parseDelistingForm(r)
+	if err != nil {
+		return err
+	}
+
+	var remindInterval time.Duration
+
+	if s := r.Form.Get("remind_after"); len(s) > 0 {
+		hours, err := strconv.Atoi(s)
+		if err != nil {
+			return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+		}
+
+		if hours < 1 || hours > maxDelistingRemindIntervalInHours {
+			return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity,
+				fmt.Errorf("Invalid reminder interval out of [1-%d] hours", maxDelistingRemindIntervalInHours))
+		}
+
+		remindInterval = time.Duration(hours) * time.Hour
+	}
+
+	if err := h.manager.RequestRBLDelisting(r.Context(), id, remindInterval, r.Form.Get("notes")); err != nil {
+		return delistingError(err)
+	}
+
+	return httputil.WriteJson(w, "ok", http.StatusOK)
+}
+
+type cancelRBLDelistingHandler rblHistoryHandler
+
+// @Summary Forget about the removal request of a listing, stopping its reminders
+// @Param id query integer true "Listing id"
+// @Success 200 {string} string "desc"
+// @Failure 404 {string} string "desc"
+// @Router /api/v0/cancelRBLDelisting [post]
+func (h cancelRBLDelistingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	id, err := parseDelistingForm(r)
+	if err != nil {
+		return err
+	}
+
+	if err := h.manager.CancelRBLDelisting(r.Context(), id); err != nil {
+		return delistingError(err)
+	}
+
+	return httputil.WriteJson(w, "ok", http.StatusOK)
+}
+
+func HttpRBLHistory(auth *auth.Authenticator, mux *http.ServeMux, manager localrblinsight.HistoryManager) {
+	handler := rblHistoryHandler{manager: manager}
+
+	stack := httpmiddleware.WithDefaultStack(auth, httpmiddleware.RequestWithTimeout(httpmiddleware.DefaultTimeout))
+
+	mux.Handle("/api/v0/rblListingHistory", stack.WithEndpoint(listRBLHistoryHandler(handler)))
+	mux.Handle("/api/v0/requestRBLDelisting", stack.WithEndpoint(requestRBLDelistingHandler(handler)))
+	mux.Handle("/api/v0/cancelRBLDelisting", stack.WithEndpoint(cancelRBLDelistingHandler(handler)))
+}
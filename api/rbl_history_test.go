@@ -0,0 +1,159 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gitlab.com/lightmeter/controlcenter/httpmiddleware"
+	localrblinsight "gitlab.com/lightmeter/controlcenter/insights/localrbl"
+)
+
+type fakeRBLHistoryManager struct {
+	timelines      []localrblinsight.Timeline
+	remindInterval time.Duration
+	notes          string
+	requested      bool
+}
+
+func (m *fakeRBLHistoryManager) RBLListingHistory(context.Context) ([]localrblinsight.Timeline, error) {
+	return m.timelines, nil
+}
+
+func (m *fakeRBLHistoryManager) RequestRBLDelisting(ctx context.Context, id int64, remindInterval time.Duration, notes string) error {
+	switch id {
+	case 1:
+		m.requested = true
+		m.remindInterval = remindInterval
+		m.notes = notes
+		return nil
+	case 2:
+		return localrblinsight.ErrAlreadyDelisted
+	}
+
+	return localrblinsight.ErrNoSuchListing
+}
+
+func (m *fakeRBLHistoryManager) CancelRBLDelisting(ctx context.Context, id int64) error {
+	if id != 1 {
+		return localrblinsight.ErrNoSuchListing
+	}
+
+	m.requested = false
+
+	return nil
+}
+
+func TestRBLHistory(t *testing.T) {
+	Convey("RBL listing history", t, func() {
+		listedAt := time.Date(2021, time.January, 1, 10, 0, 0, 0, time.UTC)
+
+		m := &fakeRBLHistoryManager{timelines: []localrblinsight.Timeline{
+			{
+				Address:     net.ParseIP("11.22.33.44"),
+				RBL:         "rbl1",
+				Listed:      true,
+				TimesListed: 1,
+				Listings:    []localrblinsight.Listing{{ID: 1, Text: "Listed", ListedAt: listedAt, LastSeenAt: listedAt}},
+			},
+		}}
+
+		handler := rblHistoryHandler{manager: m}
+
+		chain := httpmiddleware.New()
+
+		mux := http.NewServeMux()
+		mux.Handle("/rblListingHistory", chain.WithEndpoint(listRBLHistoryHandler(handler)))
+		mux.Handle("/requestRBLDelisting", chain.WithEndpoint(requestRBLDelistingHandler(handler)))
+		mux.Handle("/cancelRBLDelisting", chain.WithEndpoint(cancelRBLDelistingHandler(handler)))
+
+		s := httptest.NewServer(mux)
+		defer s.Close()
+
+		post := func(path string, values url.Values) int {
+			r, err := http.PostForm(s.URL+path, values)
+			So(err, ShouldBeNil)
+
+			defer r.Body.Close()
+
+			return r.StatusCode
+		}
+
+		Convey("List history", func() {
+			r, err := http.Get(s.URL + "/rblListingHistory")
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+			defer r.Body.Close()
+
+			var body []interface{}
+			So(json.NewDecoder(r.Body).Decode(&body), ShouldBeNil)
+
+			So(body, ShouldResemble, []interface{}{
+				map[string]interface{}{
+					"address":                "11.22.33.44",
+					"rbl":                    "rbl1",
+					"listed":                 true,
+					"last_checked_at":        nil,
+					"times_listed":           float64(1),
+					"average_time_to_delist": nil,
+					"listings": []interface{}{
+						map[string]interface{}{
+							"id":             float64(1),
+							"text":           "Listed",
+							"listed_at":      "2021-01-01T10:00:00Z",
+							"last_seen_at":   "2021-01-01T10:00:00Z",
+							"delisted_at":    nil,
+							"time_to_delist": nil,
+							"unchecked_at":   nil,
+							"delisting":      nil,
+						},
+					},
+				},
+			})
+		})
+
+		Convey("Only POST is allowed on changes", func() {
+			r, err := http.Get(s.URL + "/requestRBLDelisting?id=1")
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+
+		Convey("Request delisting", func() {
+			So(post("/requestRBLDelisting", url.Values{"id": {"1"}, "remind_after": {"24"}, "notes": {"Ticket 123"}}), ShouldEqual, http.StatusOK)
+			So(m.requested, ShouldBeTrue)
+			So(m.remindInterval, ShouldEqual, time.Hour*24)
+			So(m.notes, ShouldEqual, "Ticket 123")
+
+			Convey("And cancel it", func() {
+				So(post("/cancelRBLDelisting", url.Values{"id": {"1"}}), ShouldEqual, http.StatusOK)
+				So(m.requested, ShouldBeFalse)
+			})
+		})
+
+		Convey("Request delisting with the default reminder interval", func() {
+			So(post("/requestRBLDelisting", url.Values{"id": {"1"}}), ShouldEqual, http.StatusOK)
+			So(m.remindInterval, ShouldEqual, 0)
+		})
+
+		Convey("Invalid delisting requests", func() {
+			So(post("/requestRBLDelisting", url.Values{"id": {"not_a_number"}}), ShouldEqual, http.StatusUnprocessableEntity)
+			So(post("/requestRBLDelisting", url.Values{"id": {"1"}, "remind_after": {"0"}}), ShouldEqual, http.StatusUnprocessableEntity)
+			So(post("/requestRBLDelisting", url.Values{"id": {"1"}, "remind_after": {"721"}}), ShouldEqual, http.StatusUnprocessableEntity)
+			So(post("/requestRBLDelisting", url.Values{"id": {"2"}}), ShouldEqual, http.StatusUnprocessableEntity)
+			So(post("/requestRBLDelisting", url.Values{"id": {"3"}}), ShouldEqual, http.StatusNotFound)
+			So(post("/cancelRBLDelisting", url.Values{"id": {"3"}}), ShouldEqual, http.StatusNotFound)
+			So(m.requested, ShouldBeFalse)
+		})
+	})
+}
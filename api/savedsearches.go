@@ -0,0 +1,192 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	httpauth "gitlab.com/lightmeter/controlcenter/httpauth/auth"
+	"gitlab.com/lightmeter/controlcenter/httpmiddleware"
+	"gitlab.com/lightmeter/controlcenter/insights/savedsearch"
+	"gitlab.com/lightmeter/controlcenter/pkg/httperror"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/httputil"
+)
+
+type savedSearchesHandler struct {
+	auth    *httpauth.Authenticator
+	manager savedsearch.Manager
+}
+
+func (h savedSearchesHandler) userID(r *http.Request) (int, error) {
+	sessionData, err := httpauth.GetSessionData(h.auth, r)
+	if err != nil {
+		return 0, httperror.NewHTTPStatusCodeError(http.StatusUnauthorized, errorutil.Wrap(err))
+	}
+
+	return sessionData.ID, nil
+}
+
+type listSavedSearchesHandler savedSearchesHandler
+
+// @Summary List the detective searches saved by the current user
+// @Produce json
+// @Success 200 {object} []savedsearch.Search "desc"
+// @Router /api/v0/savedSearches [get]
+func (h listSavedSearchesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	userID, err := savedSearchesHandler(h).userID(r)
+	if err != nil {
+		return err
+	}
+
+	searches, err := h.manager.SavedSearches(r.Context(), userID)
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+	}
+
+	return httputil.WriteJson(w, searches, http.StatusOK)
+}
+
+func savedSearchQuery(r *http.Request) (savedsearch.Query, error) {
+	query := savedsearch.Query{
+		Sender:    strings.TrimSpace(r.Form.Get("mail_from")),
+		Recipient: strings.TrimSpace(r.Form.Get("mail_to")),
+		Status:    -1,
+		SomeID:    someID(r),
+		ClientIP:  strings.TrimSpace(r.Form.Get("client_ip")),
+		Relay:     strings.TrimSpace(r.Form.Get("relay")),
+		DSNPrefix: strings.TrimSpace(r.Form.Get("dsn")),
+	}
+
+	if status := r.Form.Get("status"); len(status) > 0 {
+		var err error
+		if query.Status, err = strconv.Atoi(status); err != nil {
+			return savedsearch.Query{}, fmt.Errorf("Invalid status: %w", savedsearch.ErrInvalidSearch)
+		}
+	}
+
+	var err error
+
+	if query.MinSize, err = sizeFilter(r, "min_size"); err != nil {
+		return savedsearch.Query{}, err
+	}
+
+	if query.MaxSize, err = sizeFilter(r, "max_size"); err != nil {
+		return savedsearch.Query{}, err
+	}
+
+	return query, nil
+}
+
+type saveSearchHandler savedSearchesHandler
+
+type saveSearchResult struct {
+	ID int64 `json:"id"`
+}
+
+// @Summary Save a detective search, to be executed periodically, creating an insight when new messages are found
+// @Param name         query string true "Name of the search"
+// @Param run_interval query integer true "How often, in seconds, the search runs. At least 300"
+// @Param mail_from    query string false "Sender email address, supporting * and ? wildcards"
+// @Param mail_to      query string false "Recipient email address, supporting * and ? wildcards"
+// @Param status       query string false "A status to filter messages (-1: all, the default, 0: sent... see smtp.go)"
+// @Param some_id      query string false "A queue name or message ID to filter results"
+// @Param client_ip    query string false "Client IP address or network in CIDR notation"
+// @Param relay        query string false "Next relay hostname, supporting * and ? wildcards"
+// @Param dsn          query string false "DSN prefix, as 5.1 or 4.7.1"
+// @Param min_size     query string false "Minimum message size, in bytes"
+// @Param max_size     query string false "Maximum message size, in bytes"
+// @Produce json
+// @Success 200 {object} saveSearchResult "desc"
+// @Failure 422 {string} string "desc"
+// @Router /api/v0/saveSearch [post]
+func (h saveSearchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return httperror.NewHTTPStatusCodeError(http.StatusMethodNotAllowed, fmt.Errorf("Error http method mismatch: %v", r.Method))
+	}
+
+	userID, err := savedSearchesHandler(h).userID(r)
+	if err != nil {
+		return err
+	}
+
+	if r.ParseForm() != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, errors.New("Wrong Input"))
+	}
+
+	runInterval, err := strconv.Atoi(r.Form.Get("run_interval"))
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+	}
+
+	query, err := savedSearchQuery(r)
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+	}
+
+	id, err := h.manager.SaveSearch(r.Context(), userID, r.Form.Get("name"), query, time.Duration(runInterval)*time.Second)
+	if err != nil && errors.Is(err, savedsearch.ErrInvalidSearch) {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+	}
+
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+	}
+
+	return httputil.WriteJson(w, saveSearchResult{ID: id}, http.StatusOK)
+}
+
+type deleteSavedSearchHandler savedSearchesHandler
+
+// @Summary Delete a saved detective search
+// @Param id query integer true "Saved search id"
+// @Success 200 {string} string "desc"
+// @Failure 404 {string} string "desc"
+// @Router /api/v0/deleteSavedSearch [post]
+func (h deleteSavedSearchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return httperror.NewHTTPStatusCodeError(http.StatusMethodNotAllowed, fmt.Errorf("Error http method mismatch: %v", r.Method))
+	}
+
+	userID, err := savedSearchesHandler(h).userID(r)
+	if err != nil {
+		return err
+	}
+
+	if r.ParseForm() != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, errors.New("Wrong Input"))
+	}
+
+	id, err := strconv.ParseInt(r.Form.Get("id"), 10, 64)
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, err)
+	}
+
+	err = h.manager.DeleteSavedSearch(r.Context(), userID, id)
+	if err != nil && errors.Is(err, savedsearch.ErrNoSuchSearch) {
+		return httperror.NewHTTPStatusCodeError(http.StatusNotFound, err)
+	}
+
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+	}
+
+	return httputil.WriteJson(w, "ok", http.StatusOK)
+}
+
+func HttpSavedSearches(auth *httpauth.Authenticator, mux *http.ServeMux, manager savedsearch.Manager) {
+	handler := savedSearchesHandler{auth: auth, manager: manager}
+
+	stack := httpmiddleware.WithDefaultStack(auth, httpmiddleware.RequestWithTimeout(httpmiddleware.DefaultTimeout))
+
+	mux.Handle("/api/v0/savedSearches", stack.WithEndpoint(listSavedSearchesHandler(handler)))
+	mux.Handle("/api/v0/saveSearch", stack.WithEndpoint(saveSearchHandler(handler)))
+	mux.Handle("/api/v0/deleteSavedSearch", stack.WithEndpoint(deleteSavedSearchHandler(handler)))
+}
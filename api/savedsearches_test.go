@@ -0,0 +1,181 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/sessions"
+	. "github.com/smartystreets/goconvey/convey"
+	"gitlab.com/lightmeter/controlcenter/httpauth"
+	"gitlab.com/lightmeter/controlcenter/httpauth/auth"
+	"gitlab.com/lightmeter/controlcenter/insights/savedsearch"
+	"gitlab.com/lightmeter/controlcenter/metadata"
+	"gitlab.com/lightmeter/controlcenter/util/testutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type fakeSavedSearch struct {
+	userID int
+	search savedsearch.Search
+}
+
+type fakeSavedSearchManager struct {
+	searches []fakeSavedSearch
+}
+
+func (m *fakeSavedSearchManager) SaveSearch(ctx context.Context, userID int, name string, query savedsearch.Query, runInterval time.Duration) (int64, error) {
+	if runInterval < savedsearch.MinRunInterval {
+		return 0, fmt.Errorf("Short run interval: %w", savedsearch.ErrInvalidSearch)
+	}
+
+	id := int64(len(m.searches) + 1)
+
+	m.searches = append(m.searches, fakeSavedSearch{userID: userID, search: savedsearch.Search{
+		ID:                 id,
+		Name:               name,
+		Query:              query,
+		RunInterval:        runInterval,
+		RunIntervalSeconds: int64(runInterval / time.Second),
+	}})
+
+	return id, nil
+}
+
+func (m *fakeSavedSearchManager) DeleteSavedSearch(ctx context.Context, userID int, id int64) error {
+	for i, s := range m.searches {
+		if s.userID == userID && s.search.ID == id {
+			m.searches = append(m.searches[:i], m.searches[i+1:]...)
+			return nil
+		}
+	}
+
+	return savedsearch.ErrNoSuchSearch
+}
+
+func (m *fakeSavedSearchManager) SavedSearches(ctx context.Context, userID int) ([]savedsearch.Search, error) {
+	searches := []savedsearch.Search{}
+
+	for _, s := range m.searches {
+		if s.userID == userID {
+			searches = append(searches, s.search)
+		}
+	}
+
+	return searches, nil
+}
+
+func TestSavedSearches(t *testing.T) {
+	Convey("Saved searches", t, func() {
+		registrar := &auth.FakeRegistrar{
+			Email:      "alice@example.com",
+			Password:   "super_secret",
+			SessionKey: []byte("AAAAAAAAAAAAAAAA"),
+		}
+
+		authenticator := &auth.Authenticator{
+			Registrar: registrar,
+			Store:     sessions.NewCookieStore([]byte("secret-key")),
+		}
+
+		settingdDB, removeDB := testutil.TempDBConnectionMigrated(t, "master")
+		defer removeDB()
+
+		handler, err := metadata.NewHandler(settingdDB)
+		So(err, ShouldBeNil)
+
+		manager := &fakeSavedSearchManager{}
+
+		mux := http.NewServeMux()
+		HttpSavedSearches(authenticator, mux, manager)
+
+		httpauth.HttpAuthenticator(mux, authenticator, handler.Reader, true)
+
+		s := httptest.NewServer(mux)
+
+		httpClient := buildCookieClient()
+
+		Convey("Unauthorized access", func() {
+			r, err := httpClient.Get(s.URL + "/api/v0/savedSearches")
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusUnauthorized)
+
+			r, err = httpClient.PostForm(s.URL+"/api/v0/saveSearch", url.Values{"name": {"Search"}, "run_interval": {"3600"}})
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusUnauthorized)
+		})
+
+		Convey("Authorized access", func() {
+			r, err := httpClient.PostForm(s.URL+"/login", url.Values{"email": {"alice@example.com"}, "password": {"super_secret"}})
+			So(r.StatusCode, ShouldEqual, http.StatusOK)
+			So(err, ShouldBeNil)
+
+			Convey("Invalid searches", func() {
+				for _, values := range []url.Values{
+					{"name": {"Search"}},
+					{"name": {"Search"}, "run_interval": {"60"}},
+					{"name": {"Search"}, "run_interval": {"3600"}, "status": {"sent"}},
+					{"name": {"Search"}, "run_interval": {"3600"}, "min_size": {"-1"}},
+				} {
+					r, err := httpClient.PostForm(s.URL+"/api/v0/saveSearch", values)
+					So(err, ShouldBeNil)
+					So(r.StatusCode, ShouldEqual, http.StatusUnprocessableEntity)
+				}
+			})
+
+			Convey("Save, list and delete searches", func() {
+				r, err := httpClient.PostForm(s.URL+"/api/v0/saveSearch", url.Values{
+					"name":         {"Billing bounces"},
+					"run_interval": {"3600"},
+					"mail_from":    {"billing@example.com"},
+					"status":       {"2"},
+					"dsn":          {"5.1"},
+					"max_size":     {"1000"},
+				})
+
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+				var result saveSearchResult
+				So(json.NewDecoder(r.Body).Decode(&result), ShouldBeNil)
+				So(result.ID, ShouldEqual, 1)
+
+				r, err = httpClient.Get(s.URL + "/api/v0/savedSearches")
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+				var searches []savedsearch.Search
+				So(json.NewDecoder(r.Body).Decode(&searches), ShouldBeNil)
+				So(len(searches), ShouldEqual, 1)
+
+				maxSize := int64(1000)
+
+				So(searches[0].Name, ShouldEqual, "Billing bounces")
+				So(searches[0].RunIntervalSeconds, ShouldEqual, 3600)
+				So(searches[0].Query, ShouldResemble, savedsearch.Query{
+					Sender:    "billing@example.com",
+					Status:    2,
+					DSNPrefix: "5.1",
+					MaxSize:   &maxSize,
+				})
+
+				r, err = httpClient.PostForm(s.URL+"/api/v0/deleteSavedSearch", url.Values{"id": {"42"}})
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusNotFound)
+
+				r, err = httpClient.PostForm(s.URL+"/api/v0/deleteSavedSearch", url.Values{"id": {"1"}})
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+				So(manager.searches, ShouldBeEmpty)
+			})
+		})
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"net"
 	"os"
 	"strings"
 	"time"
@@ -54,6 +55,10 @@ type Config struct {
 	ChangeUserInfoNewEmail string
 	ChangeUserInfoNewName  string
 
+	DataToErase string
+
+	ShowUnsupportedLogLines bool
+
 	// set it when control center is **NOT** behind a reverse proxy,
 	// being accessed directly, on plain HTTP (as on 2.0)
 	IKnowWhatIAmDoingNotUsingAReverseProxy bool
@@ -67,6 +72,10 @@ type Config struct {
 	DovecotConfigIsOld    bool
 
 	DataRetentionDuration time.Duration
+
+	// access to the /metrics endpoint, which is disabled unless one of them is set
+	MetricsToken           string
+	MetricsAllowedNetworks []*net.IPNet
 }
 
 func Parse(cmdlineArgs []string, lookupenv func(string) (string, bool)) (Config, error) {
@@ -128,6 +137,10 @@ func ParseWithErrorHandling(cmdlineArgs []string, lookupenv func(string) (string
 	fs.StringVar(&conf.ChangeUserInfoNewEmail, "new_email", "", "Update user email (depends on -email_reset)")
 	fs.StringVar(&conf.ChangeUserInfoNewName, "new_user_name", "", "Update user name (depends on -email_reset)")
 
+	fs.StringVar(&conf.DataToErase, "erase_data", "", "Remove or anonymise all data about an e-mail address or domain, printing an audit report (depends on -workspace)")
+
+	fs.BoolVar(&conf.ShowUnsupportedLogLines, "unsupported_log_lines_report", false, "Print a report of the log lines which could not be parsed, by process and daemon (depends on -workspace)")
+
 	fs.StringVar(&conf.Socket, "logs_socket",
 		envutil.LookupEnvOrString("LIGHTMETER_LOGS_SOCKET", "", lookupenv),
 		"Receive logs via a Socket. E.g. unix=/tmp/lightemter.sock or tcp=localhost:9999")
@@ -169,6 +182,14 @@ func ParseWithErrorHandling(cmdlineArgs []string, lookupenv func(string) (string
 
 	fs.StringVar(&unparsedDataRetentionDuration, "data_retention_duration", envutil.LookupEnvOrString("LIGHTMETER_DATA_RETENTION_DURATION", "90d", lookupenv), "How long data should be kept in the databases, to prevent them growing forever")
 
+	fs.StringVar(&conf.MetricsToken, "metrics_token", envutil.LookupEnvOrString("LIGHTMETER_METRICS_TOKEN", "", lookupenv),
+		"Enables the Prometheus metrics on /metrics, requiring the header \"Authorization: Bearer <token>\"")
+
+	var unparsedMetricsAllowedNetworks string
+
+	fs.StringVar(&unparsedMetricsAllowedNetworks, "metrics_allowed_networks", envutil.LookupEnvOrString("LIGHTMETER_METRICS_ALLOWED_NETWORKS", "", lookupenv),
+		`Enables the Prometheus metrics on /metrics for a comma separated list of IP addresses or networks. Example: "127.0.0.1,10.0.0.0/8"`)
+
 	fs.Usage = func() {
 		version.PrintVersion()
 		fmt.Fprintf(os.Stdout, "\n Example call: \n")
@@ -186,6 +207,11 @@ func ParseWithErrorHandling(cmdlineArgs []string, lookupenv func(string) (string
 		return conf, errorutil.Wrap(err)
 	}
 
+	conf.MetricsAllowedNetworks, err = parseNetworks(unparsedMetricsAllowedNetworks)
+	if err != nil {
+		return conf, errorutil.Wrap(err)
+	}
+
 	conf.DirsToWatch = buildDirsToWatch(dirsToWatch, dirsToWatchFromEnvironment)
 
 	conf.LogLevel, err = zerolog.ParseLevel(strings.ToLower(stringLogLevel))
@@ -224,3 +250,41 @@ func buildDirsToWatch(dirs dirs, dirsFromEnv []string) []string {
 
 	return nil
 }
+
+// parseNetworks parses a comma separated list of networks in the CIDR notation, or single IP addresses
+func parseNetworks(s string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+
+		if len(v) == 0 {
+			continue
+		}
+
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid IP address: %v", v)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				bits = 8 * net.IPv4len
+			}
+
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		networks = append(networks, n)
+	}
+
+	return networks, nil
+}
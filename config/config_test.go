@@ -187,6 +187,41 @@ func TestDataRetentionDurationSettings(t *testing.T) {
 	})
 }
 
+func TestMetricsSettings(t *testing.T) {
+	Convey("When not passed, metrics are disabled", t, func() {
+		c, err := ParseWithErrorHandling(noCmdline, noEnv.fakeLookupenv, flag.ContinueOnError)
+		So(err, ShouldBeNil)
+		So(c.MetricsToken, ShouldEqual, "")
+		So(c.MetricsAllowedNetworks, ShouldBeEmpty)
+	})
+
+	Convey("Obtain from command line", t, func() {
+		c, err := ParseWithErrorHandling([]string{"-metrics_token", "secret", "-metrics_allowed_networks", "127.0.0.1, 10.0.0.0/8,::1"}, noEnv.fakeLookupenv, flag.ContinueOnError)
+		So(err, ShouldBeNil)
+		So(c.MetricsToken, ShouldEqual, "secret")
+		So(len(c.MetricsAllowedNetworks), ShouldEqual, 3)
+		So(c.MetricsAllowedNetworks[0].String(), ShouldEqual, "127.0.0.1/32")
+		So(c.MetricsAllowedNetworks[1].String(), ShouldEqual, "10.0.0.0/8")
+		So(c.MetricsAllowedNetworks[2].String(), ShouldEqual, "::1/128")
+	})
+
+	Convey("Obtain from environment", t, func() {
+		env := fakeEnv{"LIGHTMETER_METRICS_TOKEN": "secret", "LIGHTMETER_METRICS_ALLOWED_NETWORKS": "192.168.0.0/16"}
+		c, err := ParseWithErrorHandling(noCmdline, env.fakeLookupenv, flag.ContinueOnError)
+		So(err, ShouldBeNil)
+		So(c.MetricsToken, ShouldEqual, "secret")
+		So(c.MetricsAllowedNetworks[0].String(), ShouldEqual, "192.168.0.0/16")
+	})
+
+	Convey("Fail on invalid networks", t, func() {
+		_, err := ParseWithErrorHandling([]string{"-metrics_allowed_networks", "localhost"}, noEnv.fakeLookupenv, flag.ContinueOnError)
+		So(err, ShouldNotBeNil)
+
+		_, err = ParseWithErrorHandling([]string{"-metrics_allowed_networks", "10.0.0.0/42"}, noEnv.fakeLookupenv, flag.ContinueOnError)
+		So(err, ShouldNotBeNil)
+	})
+}
+
 func TestWatchDir(t *testing.T) {
 	Convey("When not passed, get an empty array", t, func() {
 		c, err := ParseWithErrorHandling(noCmdline, noEnv.fakeLookupenv, flag.ContinueOnError)
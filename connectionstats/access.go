@@ -32,6 +32,7 @@ type Accessor struct {
 const (
 	countQuery = iota
 	retrieveQuery
+	recipientProbesQuery
 )
 
 func NewAccessor(pool *dbconn.RoPool) (*Accessor, error) {
@@ -60,6 +61,22 @@ order by
 			return errorutil.Wrap(err)
 		}
 
+		if err := conn.PrepareStmt(`
+select
+	ip, count(distinct lower(recipient)) as recipients, count(*)
+from
+	rejected_recipients
+where
+	rejection_ts between ? and ?
+group by
+	ip
+having
+	recipients >= ?
+order by
+	recipients desc, ip`, recipientProbesQuery); err != nil {
+			return errorutil.Wrap(err)
+		}
+
 		return nil
 	}); err != nil {
 		return nil, errorutil.Wrap(err)
@@ -186,3 +203,45 @@ func statusFromStats(command Command, success, total int) string {
 
 	return "failed"
 }
+
+// RecipientProbes describes a client which tried to deliver to recipients which do not exist
+type RecipientProbes struct {
+	IP         net.IP
+	Recipients int
+	Attempts   int
+}
+
+// FetchRecipientProbes returns the clients which tried to deliver to at least minRecipients
+// distinct non existent recipients in the interval, the ones which tried the most first
+func (a *Accessor) FetchRecipientProbes(ctx context.Context, interval timeutil.TimeInterval, minRecipients int) (probes []RecipientProbes, err error) {
+	conn, release, err := a.pool.AcquireContext(ctx)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer release()
+
+	//nolint:sqlclosecheck
+	rows, err := conn.GetStmt(recipientProbesQuery).QueryContext(ctx, interval.From.Unix(), interval.To.Unix(), minRecipients)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	for rows.Next() {
+		var p RecipientProbes
+
+		if err := rows.Scan(&p.IP, &p.Recipients, &p.Attempts); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		probes = append(probes, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return probes, nil
+}
@@ -0,0 +1,96 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package connectionstats
+
+import (
+	"context"
+	"database/sql"
+
+	"gitlab.com/lightmeter/controlcenter/erasure"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+func eraseConnectionsWhere(tx *sql.Tx, connectionsCount, commandsCount *int64, condition string, args ...interface{}) error {
+	// NOTE: condition comes from the callers in this file, never from user input
+	r, err := tx.Exec(`delete from commands where connection_id in (select id from connections where `+condition+`)`, args...)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	n, err := erasure.CountAffected(r)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	*commandsCount += n
+
+	if r, err = tx.Exec(`delete from connections where `+condition, args...); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if n, err = erasure.CountAffected(r); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	*connectionsCount += n
+
+	return nil
+}
+
+// EraseConnections removes the stats of the connections authenticated with target as username, and of the given connections.
+// Most connections have no e-mail address, so they must be obtained from somewhere else,
+// namely the deliveries sent by the erasure target. Only the connections used by the target are removed,
+// and not all the ones from the same IPs, as they might be shared by many people.
+func (s *Stats) EraseConnections(ctx context.Context, target erasure.Target, connections []erasure.Connection) (erasure.Entries, error) {
+	return erasure.Dispatch(ctx, s.Actions, func(tx *sql.Tx) (erasure.Entries, error) {
+		var connectionsCount, commandsCount int64
+
+		if target.IsDomain() {
+			if err := eraseConnectionsWhere(tx, &connectionsCount, &commandsCount, `sasl_username like ?`, "%@"+target.Domain); err != nil {
+				return nil, errorutil.Wrap(err)
+			}
+		} else {
+			if err := eraseConnectionsWhere(tx, &connectionsCount, &commandsCount, `sasl_username = ? collate nocase`, target.String()); err != nil {
+				return nil, errorutil.Wrap(err)
+			}
+		}
+
+		for _, c := range connections {
+			if err := eraseConnectionsWhere(tx, &connectionsCount, &commandsCount, `ip = ? and connection_ts = ?`, []byte(c.IP), c.Time.Unix()); err != nil {
+				return nil, errorutil.Wrap(err)
+			}
+		}
+
+		return erasure.Entries{
+			{Database: "connections", Table: "connections", Action: erasure.DeletedAction, Count: connectionsCount},
+			{Database: "connections", Table: "commands", Action: erasure.DeletedAction, Count: commandsCount},
+		}, nil
+	})
+}
+
+// EraseRejectedRecipients removes the rejected recipients matching target
+func (s *Stats) EraseRejectedRecipients(ctx context.Context, target erasure.Target) (erasure.Entries, error) {
+	return erasure.Dispatch(ctx, s.Actions, func(tx *sql.Tx) (erasure.Entries, error) {
+		r, err := func() (sql.Result, error) {
+			if target.IsDomain() {
+				return tx.Exec(`delete from rejected_recipients where recipient like ?`, "%@"+target.Domain)
+			}
+
+			return tx.Exec(`delete from rejected_recipients where recipient = ? collate nocase`, target.String())
+		}()
+		if err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		count, err := erasure.CountAffected(r)
+		if err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		return erasure.Entries{
+			{Database: "connections", Table: "rejected_recipients", Action: erasure.DeletedAction, Count: count},
+		}, nil
+	})
+}
@@ -0,0 +1,121 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package connectionstats
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gitlab.com/lightmeter/controlcenter/erasure"
+	"gitlab.com/lightmeter/controlcenter/pkg/runner"
+	"gitlab.com/lightmeter/controlcenter/util/postfixutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+func TestEraseConnections(t *testing.T) {
+	Convey("Erase connections", t, func() {
+		stats, _, pub, pool, closeConn := buildContext(t)
+		defer closeConn()
+
+		done, cancel := runner.Run(stats)
+
+		// two people behind the same IP, at the same time
+		postfixutil.ReadFromTestReader(strings.NewReader(`
+Sep  3 10:40:50 mail postfix/submission/smtpd[9708]: connect from unknown[1.2.3.4]
+Sep  3 10:40:52 mail postfix/submission/smtpd[9709]: connect from unknown[1.2.3.4]
+Sep  3 10:40:55 mail postfix/submission/smtpd[9708]: 4AA091855DA0: client=unknown[1.2.3.4], sasl_method=PLAIN, sasl_username=alice@example.com
+Sep  3 10:40:56 mail postfix/submission/smtpd[9710]: 5BB091855DA0: client=unknown[1.2.3.4], sasl_method=PLAIN, sasl_username=alice@example.com
+Sep  3 10:40:57 mail postfix/submission/smtpd[9708]: disconnect from unknown[1.2.3.4] ehlo=1 auth=1 mail=1 rcpt=1 data=1 quit=1 commands=6
+Sep  3 10:40:58 mail postfix/submission/smtpd[9709]: disconnect from unknown[1.2.3.4] ehlo=1 auth=1 mail=1 rcpt=1 data=1 quit=1 commands=6
+Sep  3 10:41:00 mail postfix/submission/smtpd[9710]: disconnect from unknown[1.2.3.4] ehlo=1 auth=1 mail=1 rcpt=1 data=1 quit=1 commands=6
+		`), pub, 2020, &timeutil.FakeClock{Time: timeutil.MustParseTime(`2020-10-15 00:00:00 +0000`)})
+
+		countConnections := func() (count int) {
+			conn, release := pool.Acquire()
+			defer release()
+
+			So(conn.QueryRow(`select count(*) from connections`).Scan(&count), ShouldBeNil)
+
+			return count
+		}
+
+		// wait until all the connections are stored
+		for i := 0; i < 100 && countConnections() < 3; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		So(countConnections(), ShouldEqual, 3)
+
+		connectionTimes := func() []int64 {
+			conn, release := pool.Acquire()
+			defer release()
+
+			rows, err := conn.Query(`select connection_ts from connections order by id`)
+			So(err, ShouldBeNil)
+
+			defer rows.Close()
+
+			times := []int64{}
+
+			for rows.Next() {
+				var ts int64
+				So(rows.Scan(&ts), ShouldBeNil)
+				times = append(times, ts)
+			}
+
+			So(rows.Err(), ShouldBeNil)
+
+			return times
+		}
+
+		// the connection with no connect line in the logs has no known start time
+		So(connectionTimes(), ShouldResemble, []int64{
+			timeutil.MustParseTime(`2020-09-03 10:40:50 +0000`).Unix(),
+			timeutil.MustParseTime(`2020-09-03 10:40:52 +0000`).Unix(),
+			0,
+		})
+
+		countAuthenticatedConnections := func() (count int) {
+			conn, release := pool.Acquire()
+			defer release()
+
+			So(conn.QueryRow(`select count(*) from connections where sasl_username = 'alice@example.com'`).Scan(&count), ShouldBeNil)
+
+			return count
+		}
+
+		// the username of a connection not seen is not known
+		So(countAuthenticatedConnections(), ShouldEqual, 1)
+
+		target, err := erasure.ParseTarget("Alice@Example.com")
+		So(err, ShouldBeNil)
+
+		// alice authenticated, and the connection at 10:40:52 was used by her without authenticating
+		entries, err := stats.EraseConnections(context.Background(), target, []erasure.Connection{
+			{IP: net.ParseIP("1.2.3.4"), Time: timeutil.MustParseTime(`2020-09-03 10:40:52 +0000`)},
+
+			// not stored
+			{IP: net.ParseIP("1.2.3.4"), Time: timeutil.MustParseTime(`2020-09-03 10:40:55 +0000`)},
+		})
+
+		So(err, ShouldBeNil)
+
+		So(entries, ShouldResemble, erasure.Entries{
+			{Database: "connections", Table: "connections", Action: erasure.DeletedAction, Count: 2},
+			{Database: "connections", Table: "commands", Action: erasure.DeletedAction, Count: 12},
+		})
+
+		cancel()
+		So(done(), ShouldBeNil)
+
+		// only the connections used by the target are removed
+		So(connectionTimes(), ShouldResemble, []int64{0})
+		So(countAuthenticatedConnections(), ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,45 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package migrations
+
+import (
+	"database/sql"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/migrator"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+func init() {
+	migrator.AddMigration("connections", "3_add_rejected_recipients.go", upAddRejectedRecipients, downAddRejectedRecipients)
+}
+
+func upAddRejectedRecipients(tx *sql.Tx) error {
+	// recipients rejected by smtpd as they do not exist, used to detect directory harvest attacks
+	sql := `
+	create table rejected_recipients(
+		id integer primary key,
+		rejection_ts integer not null,
+		ip blob not null,
+		recipient text not null
+	);
+
+	create index rejected_recipients_time_index on rejected_recipients(rejection_ts, ip);
+	`
+
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func downAddRejectedRecipients(tx *sql.Tx) error {
+	_, err := tx.Exec(`drop table rejected_recipients`)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package migrations
+
+import (
+	"database/sql"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/migrator"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+func init() {
+	migrator.AddMigration("connections", "4_add_connection_time.go", upAddConnectionTime, downAddConnectionTime)
+}
+
+func upAddConnectionTime(tx *sql.Tx) error {
+	// connection_ts is when the client connected, zero when unknown, as in the connections stored
+	// before this migration, or when the connection has not been seen.
+	// Together with the ip, it links the connection to the messages sent through it.
+	sql := `
+	alter table connections add column connection_ts integer not null default 0;
+
+	create index connection_ip_time_index on connections(ip, connection_ts);
+	`
+
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func downAddConnectionTime(tx *sql.Tx) error {
+	sql := `
+	drop index connection_ip_time_index;
+
+	alter table connections drop column connection_ts;
+	`
+
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package migrations
+
+import (
+	"database/sql"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/migrator"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+func init() {
+	migrator.AddMigration("connections", "5_add_sasl_username.go", upAddSASLUsername, downAddSASLUsername)
+}
+
+func upAddSASLUsername(tx *sql.Tx) error {
+	// sasl_username is the username the client authenticated with, null when unknown,
+	// as when the authentication failed or no message has been sent.
+	sql := `
+	alter table connections add column sasl_username text;
+
+	create index connection_sasl_username_index on connections(sasl_username);
+	`
+
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func downAddSASLUsername(tx *sql.Tx) error {
+	sql := `
+	drop index connection_sasl_username_index;
+
+	alter table connections drop column sasl_username;
+	`
+
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -23,6 +24,7 @@ import (
 // We keep store in a database all the basic statistics (number and type of smtp commands)
 // provided by Postfix on all connections that sent the AUTH command on the ports used by MUAs.
 // There is no need to to that on the port 25, as it's used by other MTAs only.
+// We also keep the recipients smtpd rejected as non existent, used to detect directory harvest attacks.
 
 type Protocol int
 
@@ -176,14 +178,87 @@ func buildCommand(s string) (Command, error) {
 
 type dbAction = dbrunner.Action
 
+// smtpdProcess identifies the smtpd process handling a connection, as each handles one at a time
+type smtpdProcess struct {
+	host string
+	pid  int
+}
+
+// connections not disconnected after this are forgotten, as their disconnection is probably not in the logs
+const maxConnectionDuration = time.Hour
+
+// smtpdConnection is what is known about the connection handled by a smtpd process
+type smtpdConnection struct {
+	// when the client connected
+	time time.Time
+
+	// empty if the client has not authenticated
+	saslUsername string
+}
+
 type publisher struct {
 	actions chan<- dbAction
+
+	// the connection currently handled by each process
+	connections map[smtpdProcess]smtpdConnection
 }
 
-func buildSmtpAction(record postfix.Record, payload parser.SmtpdDisconnect) dbAction {
+// connected returns the connection handled by the process that logged r, forgetting it,
+// or the zero value if the connection was not seen
+func (pub *publisher) connected(r postfix.Record) smtpdConnection {
+	process := smtpdProcess{host: r.Header.Host, pid: r.Header.PID}
+
+	c := pub.connections[process]
+
+	delete(pub.connections, process)
+
+	return c
+}
+
+func (pub *publisher) connect(r postfix.Record) {
+	for k, c := range pub.connections {
+		if r.Time.Sub(c.time) > maxConnectionDuration {
+			delete(pub.connections, k)
+		}
+	}
+
+	pub.connections[smtpdProcess{host: r.Header.Host, pid: r.Header.PID}] = smtpdConnection{time: r.Time}
+}
+
+// authenticated stores the username the client of the connection handled by the process that logged r authenticated with.
+// It's ignored if the connection was not seen.
+func (pub *publisher) authenticated(r postfix.Record, username string) {
+	process := smtpdProcess{host: r.Header.Host, pid: r.Header.PID}
+
+	c, ok := pub.connections[process]
+	if !ok {
+		return
+	}
+
+	c.saslUsername = username
+	pub.connections[process] = c
+}
+
+func nilIfEmpty(s string) interface{} {
+	if len(s) == 0 {
+		return nil
+	}
+
+	return s
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.Unix()
+}
+
+func buildSmtpAction(record postfix.Record, payload parser.SmtpdDisconnect, connection smtpdConnection) dbAction {
 	return func(tx *sql.Tx, stmts dbconn.TxPreparedStmts) error {
 		//nolint:sqlclosecheck
-		r, err := stmts.Get(insertDisconnectKey).Exec(record.Time.Unix(), payload.IP, ProtocolSMTP)
+		r, err := stmts.Get(insertDisconnectKey).Exec(record.Time.Unix(), payload.IP, ProtocolSMTP, unixOrZero(connection.time), nilIfEmpty(connection.saslUsername))
 		if err != nil {
 			return errorutil.Wrap(err, record.Location)
 		}
@@ -226,7 +301,7 @@ func buildDovecotCommand(p parser.DovecotAuthFailed) Command {
 func buildDovecotAction(record postfix.Record, payload parser.DovecotAuthFailed) dbAction {
 	return func(tx *sql.Tx, stmts dbconn.TxPreparedStmts) error {
 		//nolint:sqlclosecheck
-		r, err := stmts.Get(insertDisconnectKey).Exec(record.Time.Unix(), payload.IP, ProtocolIMAP)
+		r, err := stmts.Get(insertDisconnectKey).Exec(record.Time.Unix(), payload.IP, ProtocolIMAP, 0, nil)
 		if err != nil {
 			return errorutil.Wrap(err, record.Location)
 		}
@@ -245,19 +320,43 @@ func buildDovecotAction(record postfix.Record, payload parser.DovecotAuthFailed)
 	}
 }
 
+func buildRejectedRecipientAction(record postfix.Record, payload parser.SmtpdReject) dbAction {
+	return func(tx *sql.Tx, stmts dbconn.TxPreparedStmts) error {
+		//nolint:sqlclosecheck
+		if _, err := stmts.Get(insertRejectedRecipientKey).Exec(record.Time.Unix(), payload.IP, payload.Recipient); err != nil {
+			return errorutil.Wrap(err, record.Location)
+		}
+
+		return nil
+	}
+}
+
+// isUnknownRecipient checks whether a recipient was rejected as it does not exist,
+// as in `RCPT from host[1.2.3.4]: 550 5.1.1 <a@b.c>: Recipient address rejected: User unknown in virtual mailbox table`
+func isUnknownRecipient(p parser.SmtpdReject) bool {
+	if p.IP == nil || len(p.Recipient) == 0 || !strings.HasPrefix(p.ExtraMessage, "RCPT from ") {
+		return false
+	}
+
+	return p.DSN == "5.1.1" || strings.Contains(p.ExtraMessage, "User unknown")
+}
+
 const (
 	insertDisconnectKey = iota
 	insertCommandStatKey
+	insertRejectedRecipientKey
 	selectOldLogsKey
 	deleteCommandsByConnectionIdKey
 	deleteConnectionsByIdKey
+	deleteOldRejectedRecipientsKey
 
 	lastStmtKey
 )
 
 var stmtsText = map[int]string{
-	insertDisconnectKey:  `insert into connections(disconnection_ts, ip, protocol) values(?, ?, ?)`,
-	insertCommandStatKey: `insert into commands(connection_id, cmd, success, total) values(?, ?, ?, ?)`,
+	insertDisconnectKey:        `insert into connections(disconnection_ts, ip, protocol, connection_ts, sasl_username) values(?, ?, ?, ?, ?)`,
+	insertCommandStatKey:       `insert into commands(connection_id, cmd, success, total) values(?, ?, ?, ?)`,
+	insertRejectedRecipientKey: `insert into rejected_recipients(rejection_ts, ip, recipient) values(?, ?, ?)`,
 	selectOldLogsKey: `with time_cut as (
 		select
 			(disconnection_ts - ?) as v
@@ -274,18 +373,42 @@ var stmtsText = map[int]string{
 	limit ?`,
 	deleteCommandsByConnectionIdKey: `delete from commands where connection_id = ?`,
 	deleteConnectionsByIdKey:        `delete from connections where id = ?`,
+	deleteOldRejectedRecipientsKey: `with time_cut as (
+		select
+			(rejection_ts - ?) as v
+		from
+			rejected_recipients
+		order by
+			rejection_ts desc limit 1
+	)
+	delete from rejected_recipients where id in (
+		select
+			rejected_recipients.id
+		from
+			rejected_recipients join time_cut
+				on rejected_recipients.rejection_ts < time_cut.v
+		limit ?
+	)`,
 }
 
 func (pub *publisher) Publish(r postfix.Record) {
 	switch p := r.Payload.(type) {
+	case parser.SmtpdConnect:
+		pub.connect(r)
+	case parser.SmtpdMailAccepted:
+		if len(p.SASLUsername) > 0 {
+			pub.authenticated(r, p.SASLUsername)
+		}
 	case parser.SmtpdDisconnect:
+		connection := pub.connected(r)
+
 		if p.IP == nil {
 			return
 		}
 
 		// NOTE: we want to store statistics of connections that tried, either successfully or not, to authenticate
 		if _, ok := p.Stats[commandAsString(AuthCommand)]; ok {
-			pub.actions <- buildSmtpAction(r, p)
+			pub.actions <- buildSmtpAction(r, p, connection)
 		}
 	case parser.DovecotAuthFailed:
 		failed := p.Reason == parser.DovecotAuthFailedReasonUnknownUser ||
@@ -295,6 +418,10 @@ func (pub *publisher) Publish(r postfix.Record) {
 		if failed {
 			pub.actions <- buildDovecotAction(r, p)
 		}
+	case parser.SmtpdReject:
+		if isUnknownRecipient(p) {
+			pub.actions <- buildRejectedRecipientAction(r, p)
+		}
 	}
 }
 
@@ -323,13 +450,13 @@ func New(connPair *dbconn.PooledPair, options Options) (*Stats, error) {
 
 	return &Stats{
 		conn:    connPair,
-		Runner:  dbrunner.New(500*time.Millisecond, 4096, connPair.RwConn, stmts, cleaningFrequency, makeCleanAction(options.RetentionDuration, cleaningBatchSize)),
+		Runner:  dbrunner.New("connections", 500*time.Millisecond, 4096, connPair.RwConn, stmts, cleaningFrequency, makeCleanAction(options.RetentionDuration, cleaningBatchSize)),
 		Closers: closers.New(stmts),
 	}, nil
 }
 
 func (s *Stats) Publisher() postfix.Publisher {
-	return &publisher{actions: s.Actions}
+	return &publisher{actions: s.Actions, connections: map[smtpdProcess]smtpdConnection{}}
 }
 
 func (s *Stats) MostRecentLogTime() (time.Time, error) {
@@ -385,6 +512,11 @@ func makeCleanAction(maxAge time.Duration, batchSize int) dbrunner.Action {
 			return errorutil.Wrap(err)
 		}
 
+		//nolint:sqlclosecheck
+		if _, err := stmts.Get(deleteOldRejectedRecipientsKey).Exec(maxAge/time.Second, batchSize); err != nil {
+			return errorutil.Wrap(err)
+		}
+
 		return nil
 	}
 }
@@ -299,3 +299,52 @@ Dec 30 10:40:57 mail postfix/smtpd[4567]: disconnect from example.com[1.2.3.4] e
 		So(connectionsCount, ShouldEqual, 3)
 	})
 }
+
+func TestRecipientProbes(t *testing.T) {
+	Convey("Recipient probes", t, func() {
+		stats, accessor, pub, _, closeConn := buildContext(t)
+		defer closeConn()
+
+		done, cancel := runner.Run(stats)
+
+		postfixutil.ReadFromTestReader(strings.NewReader(`
+Sep  3 10:40:00 mx postfix/smtpd[1036]: NOQUEUE: reject: RCPT from h-5ab1[11.22.33.44]: 550 5.1.1 <alice@example.com>: Recipient address rejected: User unknown in virtual mailbox table; from=<spam@spammer.com> to=<alice@example.com> proto=ESMTP helo=<h-5ab1>
+Sep  3 10:40:01 mx postfix/smtpd[1036]: NOQUEUE: reject: RCPT from h-5ab1[11.22.33.44]: 550 5.1.1 <bob@example.com>: Recipient address rejected: User unknown in virtual mailbox table; from=<spam@spammer.com> to=<bob@example.com> proto=ESMTP helo=<h-5ab1>
+Sep  3 10:40:02 mx postfix/smtpd[1036]: NOQUEUE: reject: RCPT from h-5ab1[11.22.33.44]: 550 5.1.1 <Bob@example.com>: Recipient address rejected: User unknown in virtual mailbox table; from=<spam@spammer.com> to=<Bob@example.com> proto=ESMTP helo=<h-5ab1>
+Sep  3 10:40:03 mx postfix/smtpd[1036]: NOQUEUE: reject: RCPT from h-5ab1[11.22.33.44]: 550 5.1.1 <carol@example.com>: Recipient address rejected: User unknown in virtual mailbox table; from=<spam@spammer.com> to=<carol@example.com> proto=ESMTP helo=<h-5ab1>
+Sep  3 10:41:00 mx postfix/smtpd[1040]: NOQUEUE: reject: RCPT from other[55.66.77.88]: 550 5.1.1 <alice@example.com>: Recipient address rejected: User unknown in virtual mailbox table; from=<a@b.com> to=<alice@example.com> proto=ESMTP helo=<other>
+Sep  3 10:42:00 mx postfix/smtpd[1041]: NOQUEUE: reject: RCPT from another[99.88.77.66]: 554 5.7.1 <dave@example.com>: Relay access denied; from=<a@b.com> to=<dave@example.com> proto=ESMTP helo=<another>
+Sep  3 10:42:01 mx postfix/smtpd[1041]: NOQUEUE: reject: RCPT from another[99.88.77.66]: 554 5.7.1 <erin@example.com>: Relay access denied; from=<a@b.com> to=<erin@example.com> proto=ESMTP helo=<another>
+Sep  5 10:40:00 mx postfix/smtpd[1036]: NOQUEUE: reject: RCPT from h-5ab1[11.22.33.44]: 550 5.1.1 <frank@example.com>: Recipient address rejected: User unknown in virtual mailbox table; from=<spam@spammer.com> to=<frank@example.com> proto=ESMTP helo=<h-5ab1>
+		`), pub, 2020, &timeutil.FakeClock{Time: timeutil.MustParseTime(`2020-10-01 00:00:00 +0000`)})
+
+		cancel()
+		So(done(), ShouldBeNil)
+
+		interval := timeutil.TimeInterval{
+			From: timeutil.MustParseTime(`2020-09-03 00:00:00 +0000`),
+			To:   timeutil.MustParseTime(`2020-09-04 00:00:00 +0000`),
+		}
+
+		Convey("Only unknown recipients are taken into account, ignoring case", func() {
+			probes, err := accessor.FetchRecipientProbes(context.Background(), interval, 1)
+			So(err, ShouldBeNil)
+			So(probes, ShouldHaveLength, 2)
+
+			So(probes[0].IP.String(), ShouldEqual, "11.22.33.44")
+			So(probes[0].Recipients, ShouldEqual, 3)
+			So(probes[0].Attempts, ShouldEqual, 4)
+
+			So(probes[1].IP.String(), ShouldEqual, "55.66.77.88")
+			So(probes[1].Recipients, ShouldEqual, 1)
+			So(probes[1].Attempts, ShouldEqual, 1)
+		})
+
+		Convey("Clients which probed too few recipients are ignored", func() {
+			probes, err := accessor.FetchRecipientProbes(context.Background(), interval, 2)
+			So(err, ShouldBeNil)
+			So(probes, ShouldHaveLength, 1)
+			So(probes[0].IP.String(), ShouldEqual, "11.22.33.44")
+		})
+	})
+}
@@ -0,0 +1,293 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package dashboard
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+var ErrInvalidComparison = errors.New(`Invalid comparison`)
+
+// MaxComparisonWeeks is how far in the past the baseline of a comparison can be
+const MaxComparisonWeeks = 52
+
+type ComparisonKind int
+
+const (
+	// CompareWithPreviousPeriod uses as baseline the period of same length right before the requested one
+	CompareWithPreviousPeriod ComparisonKind = iota
+
+	// CompareWithWeeksAgo uses as baseline the requested period, N weeks ago
+	CompareWithWeeksAgo
+)
+
+type Comparison struct {
+	Kind  ComparisonKind
+	Weeks int
+}
+
+// ParseComparison parses kind as "previous" or "weeks", where only the latter uses weeks
+func ParseComparison(kind string, weeks int) (Comparison, error) {
+	switch kind {
+	case "previous":
+		return Comparison{Kind: CompareWithPreviousPeriod}, nil
+	case "weeks":
+		if weeks < 1 || weeks > MaxComparisonWeeks {
+			return Comparison{}, fmt.Errorf("%w: weeks should be between 1 and %v", ErrInvalidComparison, MaxComparisonWeeks)
+		}
+
+		return Comparison{Kind: CompareWithWeeksAgo, Weeks: weeks}, nil
+	}
+
+	return Comparison{}, fmt.Errorf("%w: unknown comparison %q", ErrInvalidComparison, kind)
+}
+
+// BaselineInterval returns the interval the values in interval are compared with
+func (c Comparison) BaselineInterval(interval timeutil.TimeInterval) timeutil.TimeInterval {
+	if c.Kind == CompareWithWeeksAgo {
+		return timeutil.TimeInterval{
+			From: interval.From.AddDate(0, 0, -7*c.Weeks),
+			To:   interval.To.AddDate(0, 0, -7*c.Weeks),
+		}
+	}
+
+	// the end of the interval is inclusive, as in 1999-12-23 23:59:59
+	length := interval.To.Sub(interval.From) + time.Second
+
+	return timeutil.TimeInterval{
+		From: interval.From.Add(-length),
+		To:   interval.To.Add(-length),
+	}
+}
+
+// Delta compares a value with its baseline
+type Delta struct {
+	Value    float64 `json:"value"`
+	Baseline float64 `json:"baseline"`
+	Delta    float64 `json:"delta"`
+
+	// Percentage of change relative to the baseline, nil when the baseline is zero
+	Percentage *float64 `json:"percentage"`
+}
+
+func NewDelta(value, baseline float64) Delta {
+	d := Delta{Value: value, Baseline: baseline, Delta: value - baseline}
+
+	if baseline != 0 {
+		p := d.Delta * 100 / baseline
+		d.Percentage = &p
+	}
+
+	return d
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	}
+
+	return 0
+}
+
+type PairDelta struct {
+	Key interface{} `json:"key"`
+	Delta
+}
+
+// ComparePairs compares pairs by key, keeping the order of the current ones,
+// followed by the keys only found in the baseline
+func ComparePairs(current, baseline Pairs) []PairDelta {
+	baselineValues := map[interface{}]interface{}{}
+
+	for _, p := range baseline {
+		baselineValues[p.Key] = p.Value
+	}
+
+	result := make([]PairDelta, 0, len(current))
+
+	seen := map[interface{}]struct{}{}
+
+	for _, p := range current {
+		seen[p.Key] = struct{}{}
+		result = append(result, PairDelta{Key: p.Key, Delta: NewDelta(toFloat(p.Value), toFloat(baselineValues[p.Key]))})
+	}
+
+	for _, p := range baseline {
+		if _, ok := seen[p.Key]; !ok {
+			result = append(result, PairDelta{Key: p.Key, Delta: NewDelta(0, toFloat(p.Value))})
+		}
+	}
+
+	return result
+}
+
+// CompareTrafficPerSender compares the total number of messages of each mailbox in both periods
+func CompareTrafficPerSender(current, baseline MailTrafficPerSenderOverTimeResult) map[string]Delta {
+	sum := func(values []int64) (s int64) {
+		for _, v := range values {
+			s += v
+		}
+
+		return s
+	}
+
+	result := map[string]Delta{}
+
+	for k, v := range current.Values {
+		result[k] = NewDelta(float64(sum(v)), float64(sum(baseline.Values[k])))
+	}
+
+	for k, v := range baseline.Values {
+		if _, ok := current.Values[k]; !ok {
+			result[k] = NewDelta(0, float64(sum(v)))
+		}
+	}
+
+	return result
+}
+
+type PercentilesDelta struct {
+	P50 Delta `json:"p50"`
+	P90 Delta `json:"p90"`
+	P99 Delta `json:"p99"`
+}
+
+func comparePercentiles(current, baseline Percentiles) PercentilesDelta {
+	return PercentilesDelta{
+		P50: NewDelta(current.P50, baseline.P50),
+		P90: NewDelta(current.P90, baseline.P90),
+		P99: NewDelta(current.P99, baseline.P99),
+	}
+}
+
+type PercentilesSeriesDelta struct {
+	P50 []Delta `json:"p50"`
+	P90 []Delta `json:"p90"`
+	P99 []Delta `json:"p99"`
+}
+
+// CompareDelayPercentilesOverTime compares each time of current with the time at the same position in the baseline period,
+// which starts offset seconds earlier. As the times are rounded to the granularity, the offset is also rounded.
+func CompareDelayPercentilesOverTime(current, baseline DelayPercentilesOverTimeResult, offset int64, granularityInHour int) map[string]PercentilesSeriesDelta {
+	granularity := int64(granularityInHour) * int64(time.Hour/time.Second)
+
+	baselineIndexes := map[int64]int{}
+
+	if granularity > 0 {
+		offset -= offset % granularity
+	}
+
+	for i, t := range baseline.Times {
+		baselineIndexes[t+offset] = i
+	}
+
+	valueAt := func(series []float64, i int, ok bool) float64 {
+		if !ok {
+			return 0
+		}
+
+		return series[i]
+	}
+
+	result := map[string]PercentilesSeriesDelta{}
+
+	for _, c := range delayComponents {
+		var (
+			cur  = current.Values[c]
+			base = baseline.Values[c]
+			d    = PercentilesSeriesDelta{P50: []Delta{}, P90: []Delta{}, P99: []Delta{}}
+		)
+
+		for i, t := range current.Times {
+			j, ok := baselineIndexes[t]
+			d.P50 = append(d.P50, NewDelta(cur.P50[i], valueAt(base.P50, j, ok)))
+			d.P90 = append(d.P90, NewDelta(cur.P90[i], valueAt(base.P90, j, ok)))
+			d.P99 = append(d.P99, NewDelta(cur.P99[i], valueAt(base.P99, j, ok)))
+		}
+
+		result[c] = d
+	}
+
+	return result
+}
+
+type DomainDelayPercentilesDelta struct {
+	Domain string                      `json:"domain"`
+	Count  Delta                       `json:"count"`
+	Delays map[string]PercentilesDelta `json:"delays"`
+}
+
+// CompareDelayPercentilesByDomain compares the domains in current with the same ones in the baseline
+func CompareDelayPercentilesByDomain(current, baseline []DomainDelayPercentiles) []DomainDelayPercentilesDelta {
+	baselineByDomain := map[string]DomainDelayPercentiles{}
+
+	for _, d := range baseline {
+		baselineByDomain[d.Domain] = d
+	}
+
+	result := make([]DomainDelayPercentilesDelta, 0, len(current))
+
+	for _, d := range current {
+		b := baselineByDomain[d.Domain]
+
+		delays := map[string]PercentilesDelta{}
+
+		for _, c := range delayComponents {
+			delays[c] = comparePercentiles(d.Delays[c], b.Delays[c])
+		}
+
+		result = append(result, DomainDelayPercentilesDelta{
+			Domain: d.Domain,
+			Count:  NewDelta(float64(d.Count), float64(b.Count)),
+			Delays: delays,
+		})
+	}
+
+	return result
+}
+
+type SendingIPVolumeDelta struct {
+	Address  string `json:"address"`
+	Total    Delta  `json:"total"`
+	Sent     Delta  `json:"sent"`
+	Bounced  Delta  `json:"bounced"`
+	Deferred Delta  `json:"deferred"`
+	Expired  Delta  `json:"expired"`
+}
+
+// CompareVolumeBySendingIP compares the sending IPs in current with the same ones in the baseline
+func CompareVolumeBySendingIP(current, baseline []SendingIPVolume) []SendingIPVolumeDelta {
+	baselineByAddress := map[string]SendingIPVolume{}
+
+	for _, v := range baseline {
+		baselineByAddress[v.Address] = v
+	}
+
+	result := make([]SendingIPVolumeDelta, 0, len(current))
+
+	for _, v := range current {
+		b := baselineByAddress[v.Address]
+
+		result = append(result, SendingIPVolumeDelta{
+			Address:  v.Address,
+			Total:    NewDelta(float64(v.Total), float64(b.Total)),
+			Sent:     NewDelta(float64(v.Sent), float64(b.Sent)),
+			Bounced:  NewDelta(float64(v.Bounced), float64(b.Bounced)),
+			Deferred: NewDelta(float64(v.Deferred), float64(b.Deferred)),
+			Expired:  NewDelta(float64(v.Expired), float64(b.Expired)),
+		})
+	}
+
+	return result
+}
@@ -0,0 +1,131 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package dashboard
+
+import (
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+	"testing"
+	"time"
+)
+
+func percentage(v float64) *float64 {
+	return &v
+}
+
+func TestComparison(t *testing.T) {
+	Convey("Test Comparison", t, func() {
+		interval, err := timeutil.ParseTimeInterval("2020-01-08", "2020-01-14", time.UTC)
+		So(err, ShouldBeNil)
+
+		Convey("Parse", func() {
+			_, err := ParseComparison("yesterday", 0)
+			So(errors.Is(err, ErrInvalidComparison), ShouldBeTrue)
+
+			_, err = ParseComparison("weeks", 0)
+			So(errors.Is(err, ErrInvalidComparison), ShouldBeTrue)
+
+			_, err = ParseComparison("weeks", MaxComparisonWeeks+1)
+			So(errors.Is(err, ErrInvalidComparison), ShouldBeTrue)
+
+			c, err := ParseComparison("previous", 0)
+			So(err, ShouldBeNil)
+			So(c, ShouldResemble, Comparison{Kind: CompareWithPreviousPeriod})
+		})
+
+		Convey("Baseline of the previous period", func() {
+			c := Comparison{Kind: CompareWithPreviousPeriod}
+			So(c.BaselineInterval(interval), ShouldResemble, timeutil.TimeInterval{
+				From: timeutil.MustParseTime(`2020-01-01 00:00:00 +0000`),
+				To:   timeutil.MustParseTime(`2020-01-07 23:59:59 +0000`),
+			})
+		})
+
+		Convey("Baseline some weeks ago", func() {
+			c := Comparison{Kind: CompareWithWeeksAgo, Weeks: 3}
+			So(c.BaselineInterval(interval), ShouldResemble, timeutil.TimeInterval{
+				From: timeutil.MustParseTime(`2019-12-18 00:00:00 +0000`),
+				To:   timeutil.MustParseTime(`2019-12-24 23:59:59 +0000`),
+			})
+		})
+
+		Convey("Delta", func() {
+			So(NewDelta(15, 10), ShouldResemble, Delta{Value: 15, Baseline: 10, Delta: 5, Percentage: percentage(50)})
+			So(NewDelta(5, 10), ShouldResemble, Delta{Value: 5, Baseline: 10, Delta: -5, Percentage: percentage(-50)})
+			So(NewDelta(5, 0), ShouldResemble, Delta{Value: 5, Baseline: 0, Delta: 5})
+		})
+
+		Convey("Compare pairs", func() {
+			So(ComparePairs(
+				Pairs{{Key: "a.com", Value: 4}, {Key: "b.com", Value: 2}},
+				Pairs{{Key: "b.com", Value: 4}, {Key: "c.com", Value: 1}},
+			), ShouldResemble, []PairDelta{
+				{Key: "a.com", Delta: NewDelta(4, 0)},
+				{Key: "b.com", Delta: NewDelta(2, 4)},
+				{Key: "c.com", Delta: NewDelta(0, 1)},
+			})
+		})
+
+		Convey("Compare traffic per sender", func() {
+			So(CompareTrafficPerSender(
+				MailTrafficPerSenderOverTimeResult{Values: map[string][]int64{"a@a.com": {1, 2, 3}}},
+				MailTrafficPerSenderOverTimeResult{Values: map[string][]int64{"a@a.com": {4}, "b@b.com": {1, 1}}},
+			), ShouldResemble, map[string]Delta{
+				"a@a.com": NewDelta(6, 4),
+				"b@b.com": NewDelta(0, 2),
+			})
+		})
+
+		Convey("Compare delay percentiles over time", func() {
+			series := func(v ...float64) PercentilesSeries {
+				return PercentilesSeries{P50: v, P90: v, P99: v}
+			}
+
+			values := func(v ...float64) map[string]PercentilesSeries {
+				r := map[string]PercentilesSeries{}
+				for _, c := range delayComponents {
+					r[c] = series(v...)
+				}
+				return r
+			}
+
+			week := int64(7 * 24 * 3600)
+
+			current := DelayPercentilesOverTimeResult{Times: []int64{week, week + 3600}, Counts: []int64{1, 1}, Values: values(10, 20)}
+
+			// the baseline has no value on the first time
+			baseline := DelayPercentilesOverTimeResult{Times: []int64{3600}, Counts: []int64{1}, Values: values(5)}
+
+			result := CompareDelayPercentilesOverTime(current, baseline, week, 1)
+			So(result[DelaySMTP].P90, ShouldResemble, []Delta{NewDelta(10, 0), NewDelta(20, 5)})
+		})
+
+		Convey("Compare delay percentiles by domain", func() {
+			result := CompareDelayPercentilesByDomain(
+				[]DomainDelayPercentiles{{Domain: "a.com", Count: 2, Delays: DelayPercentiles{DelayTotal: {P50: 2, P90: 4, P99: 4}}}},
+				[]DomainDelayPercentiles{{Domain: "a.com", Count: 4, Delays: DelayPercentiles{DelayTotal: {P50: 1, P90: 2, P99: 8}}}},
+			)
+
+			So(len(result), ShouldEqual, 1)
+			So(result[0].Count, ShouldResemble, NewDelta(2, 4))
+			So(result[0].Delays[DelayTotal], ShouldResemble, PercentilesDelta{P50: NewDelta(2, 1), P90: NewDelta(4, 2), P99: NewDelta(4, 8)})
+		})
+
+		Convey("Compare volume by sending IP", func() {
+			result := CompareVolumeBySendingIP(
+				[]SendingIPVolume{{Address: "127.0.0.1", Total: 3, Sent: 2, Bounced: 1}, {Address: "", Total: 1, Deferred: 1}},
+				[]SendingIPVolume{{Address: "127.0.0.1", Total: 4, Sent: 4}},
+			)
+
+			So(len(result), ShouldEqual, 2)
+			So(result[0].Total, ShouldResemble, NewDelta(3, 4))
+			So(result[0].Sent, ShouldResemble, NewDelta(2, 4))
+			So(result[0].Bounced, ShouldResemble, NewDelta(1, 0))
+			So(result[1].Address, ShouldEqual, "")
+			So(result[1].Deferred, ShouldResemble, NewDelta(1, 0))
+		})
+	})
+}
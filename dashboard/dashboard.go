@@ -45,6 +45,11 @@ type Dashboard interface {
 	ExpiredMailsByMailbox(context.Context, timeutil.TimeInterval, int) (MailTrafficPerSenderOverTimeResult, error)
 	ReceivedMailsByMailbox(context.Context, timeutil.TimeInterval, int) (MailTrafficPerSenderOverTimeResult, error)
 	InboundRepliesByMailbox(context.Context, timeutil.TimeInterval, int) (MailTrafficPerSenderOverTimeResult, error)
+
+	DelayPercentilesOverTime(context.Context, timeutil.TimeInterval, int) (DelayPercentilesOverTimeResult, error)
+	DelayPercentilesByDomain(context.Context, timeutil.TimeInterval) ([]DomainDelayPercentiles, error)
+
+	VolumeBySendingIP(context.Context, timeutil.TimeInterval) ([]SendingIPVolume, error)
 }
 
 type sqlDashboard struct {
@@ -246,6 +251,14 @@ order  by t
 			return errorutil.Wrap(err)
 		}
 
+		if err := prepareDelayStatements(db); err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		if err := prepareSendingIPStatements(db); err != nil {
+			return errorutil.Wrap(err)
+		}
+
 		return nil
 	}
 
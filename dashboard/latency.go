@@ -0,0 +1,320 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package dashboard
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/dbconn"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+// The components of the time a message spends in postfix, as in the delays= field of the logs.
+// DelayTotal is the sum of all the others.
+const (
+	DelayTotal   = "delay"
+	DelaySMTPD   = "smtpd"
+	DelayCleanup = "cleanup"
+	DelayQmgr    = "qmgr"
+	DelaySMTP    = "smtp"
+)
+
+var ErrInvalidGranularity = errors.New(`Invalid granularity`)
+
+var delayComponents = []string{DelayTotal, DelaySMTPD, DelayCleanup, DelayQmgr, DelaySMTP}
+
+// MaxDomainsWithDelays is how many recipient domains, the busiest ones, have their delays computed
+const MaxDomainsWithDelays = 20
+
+// Percentiles of a delay component, in seconds
+type Percentiles struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+}
+
+// DelayPercentiles has the percentiles for each delay component
+type DelayPercentiles map[string]Percentiles
+
+type PercentilesSeries struct {
+	P50 []float64 `json:"p50"`
+	P90 []float64 `json:"p90"`
+	P99 []float64 `json:"p99"`
+}
+
+type DelayPercentilesOverTimeResult struct {
+	Times []int64 `json:"times"`
+
+	// Counts is the number of delivery attempts on each time, as times with no attempts have all percentiles zero
+	Counts []int64 `json:"counts"`
+
+	// Values are indexed by the delay component
+	Values map[string]PercentilesSeries `json:"values"`
+}
+
+type DomainDelayPercentiles struct {
+	Domain string           `json:"domain"`
+	Count  int64            `json:"count"`
+	Delays DelayPercentiles `json:"delays"`
+}
+
+// the column of each delay component, in the same order as delayComponents
+var delayColumns = []string{"delay", "delay_smtpd", "delay_cleanup", "delay_qmgr", "delay_smtp"}
+
+// The percentiles are computed by the database, so the delays are never loaded into memory.
+// They use the nearest-rank method, where the rank, starting from one, of the percentile p of n values
+// is ceil(p * n / 100), computed with integers as (p * n + 99) / 100.
+// %[1]s is the key the deliveries are grouped by, %[2]s the delay column, %[3]s the joins and %[4]s
+// the restriction on the keys
+const delayPercentilesStmt = `
+with delays as (
+	select
+		%[1]s as key, %[2]s as value
+	from
+		deliveries %[3]s
+	where
+		delivery_ts between ? and ?` + directionQueryFragment + `
+),
+ranked as (
+	select
+		key, value,
+		row_number() over (partition by key order by value) as rank,
+		count(*) over (partition by key) as n
+	from
+		delays %[4]s
+)
+select
+	key, n, rank, value
+from
+	ranked
+where
+	rank in ((50 * n + 99) / 100, (90 * n + 99) / 100, (99 * n + 99) / 100)
+`
+
+func delayPercentilesOverTimeStmtKey(column string) string {
+	return "delayPercentilesOverTime_" + column
+}
+
+func delayPercentilesByDomainStmtKey(column string) string {
+	return "delayPercentilesByDomain_" + column
+}
+
+func prepareDelayStatements(db *dbconn.RoPooledConn) error {
+	for _, column := range delayColumns {
+		// the first argument is the granularity
+		overTime := fmt.Sprintf(delayPercentilesStmt, `delivery_ts - delivery_ts % ?`, column, ``, ``)
+
+		if err := db.PrepareStmt(overTime, delayPercentilesOverTimeStmtKey(column)); err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		// the last argument is how many domains, the busiest ones, are used
+		byDomain := fmt.Sprintf(delayPercentilesStmt,
+			`coalesce(nullif(lower(remote_domains.domain), ''), '<none>')`,
+			column,
+			`join remote_domains on deliveries.recipient_domain_part_id = remote_domains.id`,
+			`where key in (select key from delays group by key order by count(*) desc, key limit ?)`)
+
+		if err := db.PrepareStmt(byDomain, delayPercentilesByDomainStmtKey(column)); err != nil {
+			return errorutil.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// nearestRank is the rank, starting from one, of the percentile p, in percent, of n values
+func nearestRank(p, n int64) int64 {
+	return (p*n + 99) / 100
+}
+
+type keyedDelayPercentiles struct {
+	percentiles map[interface{}]DelayPercentiles
+
+	// how many deliveries each key has
+	counts map[interface{}]int64
+}
+
+// queryDelayPercentiles executes the statement of each delay component, obtained by stmtKey
+func queryDelayPercentiles(ctx context.Context, conn *dbconn.RoPooledConn, stmtKey func(string) string, args ...interface{}) (keyedDelayPercentiles, error) {
+	result := keyedDelayPercentiles{percentiles: map[interface{}]DelayPercentiles{}, counts: map[interface{}]int64{}}
+
+	for i, column := range delayColumns {
+		//nolint:sqlclosecheck
+		if err := queryDelayComponentPercentiles(ctx, conn.GetStmt(stmtKey(column)), delayComponents[i], result, args...); err != nil {
+			return keyedDelayPercentiles{}, errorutil.Wrap(err)
+		}
+	}
+
+	return result, nil
+}
+
+func queryDelayComponentPercentiles(ctx context.Context, stmt *sql.Stmt, component string, result keyedDelayPercentiles, args ...interface{}) (err error) {
+	//nolint:sqlclosecheck
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	for rows.Next() {
+		var (
+			key         interface{}
+			n, rank     int64
+			value       float64
+			percentiles Percentiles
+		)
+
+		if err := rows.Scan(&key, &n, &rank, &value); err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		if b, ok := key.([]byte); ok {
+			key = string(b)
+		}
+
+		result.counts[key] = n
+
+		delays, ok := result.percentiles[key]
+		if !ok {
+			delays = DelayPercentiles{}
+			result.percentiles[key] = delays
+		}
+
+		percentiles = delays[component]
+
+		// different percentiles can have the same rank, when there are few values
+		if rank == nearestRank(50, n) {
+			percentiles.P50 = value
+		}
+
+		if rank == nearestRank(90, n) {
+			percentiles.P90 = value
+		}
+
+		if rank == nearestRank(99, n) {
+			percentiles.P99 = value
+		}
+
+		delays[component] = percentiles
+	}
+
+	if err := rows.Err(); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func (d sqlDashboard) DelayPercentilesOverTime(ctx context.Context, interval timeutil.TimeInterval, granularityInHour int) (DelayPercentilesOverTimeResult, error) {
+	conn, release, err := d.pool.AcquireContext(ctx)
+	if err != nil {
+		return DelayPercentilesOverTimeResult{}, errorutil.Wrap(err)
+	}
+
+	defer release()
+
+	granularity := int64(granularityInHour) * int64(time.Hour/time.Second)
+
+	if granularity <= 0 {
+		return DelayPercentilesOverTimeResult{}, errorutil.Wrap(ErrInvalidGranularity)
+	}
+
+	byTime, err := queryDelayPercentiles(ctx, conn, delayPercentilesOverTimeStmtKey, granularity, interval.From.Unix(), interval.To.Unix())
+	if err != nil {
+		return DelayPercentilesOverTimeResult{}, errorutil.Wrap(err)
+	}
+
+	result := DelayPercentilesOverTimeResult{Times: []int64{}, Counts: []int64{}, Values: map[string]PercentilesSeries{}}
+
+	if len(byTime.counts) == 0 {
+		return result, nil
+	}
+
+	var (
+		minTime int64 = math.MaxInt64
+		maxTime int64 = math.MinInt64
+	)
+
+	for k := range byTime.counts {
+		//nolint:forcetypeassert
+		t := k.(int64)
+
+		if t < minTime {
+			minTime = t
+		}
+
+		if t > maxTime {
+			maxTime = t
+		}
+	}
+
+	// as in the volumes by sender, times with no deliveries are also returned
+	for t := minTime; t <= maxTime; t += granularity {
+		result.Times = append(result.Times, t)
+
+		var (
+			percentiles DelayPercentiles
+			count       int64
+		)
+
+		if c, ok := byTime.counts[t]; ok {
+			percentiles, count = byTime.percentiles[t], c
+		}
+
+		result.Counts = append(result.Counts, count)
+
+		for _, c := range delayComponents {
+			series := result.Values[c]
+			series.P50 = append(series.P50, percentiles[c].P50)
+			series.P90 = append(series.P90, percentiles[c].P90)
+			series.P99 = append(series.P99, percentiles[c].P99)
+			result.Values[c] = series
+		}
+	}
+
+	return result, nil
+}
+
+// DelayPercentilesByDomain returns the delays for the busiest recipient domains, the busiest first
+func (d sqlDashboard) DelayPercentilesByDomain(ctx context.Context, interval timeutil.TimeInterval) ([]DomainDelayPercentiles, error) {
+	conn, release, err := d.pool.AcquireContext(ctx)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer release()
+
+	byDomain, err := queryDelayPercentiles(ctx, conn, delayPercentilesByDomainStmtKey, interval.From.Unix(), interval.To.Unix(), MaxDomainsWithDelays)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	result := make([]DomainDelayPercentiles, 0, len(byDomain.counts))
+
+	for k, count := range byDomain.counts {
+		//nolint:forcetypeassert
+		domain := k.(string)
+		result = append(result, DomainDelayPercentiles{Domain: domain, Count: count, Delays: byDomain.percentiles[k]})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+
+		return result[i].Domain < result[j].Domain
+	})
+
+	return result, nil
+}
@@ -0,0 +1,105 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package dashboard
+
+import (
+	"context"
+	"database/sql"
+	"net"
+
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/dbconn"
+	parser "gitlab.com/lightmeter/controlcenter/pkg/postfix/logparser"
+	"gitlab.com/lightmeter/controlcenter/tracking"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+// SendingIPVolume is the number of outbound messages sent from a local IP, by delivery status.
+// Address is empty for the messages whose sending IP is unknown, as postfix logs it
+// only when the process name has it, as in postfix-1.2.3.4/smtp
+type SendingIPVolume struct {
+	Address  string `json:"address"`
+	Total    int64  `json:"total"`
+	Sent     int64  `json:"sent"`
+	Bounced  int64  `json:"bounced"`
+	Deferred int64  `json:"deferred"`
+	Expired  int64  `json:"expired"`
+}
+
+func prepareSendingIPStatements(db *dbconn.RoPooledConn) error {
+	if err := db.PrepareStmt(`
+	select
+		sending_ip,
+		count(*),
+		sum(status = @Sent),
+		sum(status = @Bounced),
+		sum(status = @Deferred),
+		sum(exists(
+			select *
+			from expired_queues eq
+			join delivery_queue dq on eq.queue_id = dq.queue_id
+			where delivery_id = d.id
+		))
+	from
+		deliveries d
+	where
+		delivery_ts between @start and @end and direction = @Outbound
+	group by
+		sending_ip
+	order by
+		count(*) desc, sending_ip
+	`, "volumeBySendingIP"); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func (d sqlDashboard) VolumeBySendingIP(ctx context.Context, interval timeutil.TimeInterval) (r []SendingIPVolume, err error) {
+	conn, release, err := d.pool.AcquireContext(ctx)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer release()
+
+	//nolint:sqlclosecheck
+	rows, err := conn.GetStmt("volumeBySendingIP").QueryContext(ctx, buildArgs(interval, []interface{}{
+		sql.Named("Outbound", tracking.MessageDirectionOutbound),
+		sql.Named("Sent", parser.SentStatus),
+		sql.Named("Bounced", parser.BouncedStatus),
+		sql.Named("Deferred", parser.DeferredStatus),
+	})...)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	r = []SendingIPVolume{}
+
+	for rows.Next() {
+		var (
+			ip []byte
+			v  SendingIPVolume
+		)
+
+		if err := rows.Scan(&ip, &v.Total, &v.Sent, &v.Bounced, &v.Deferred, &v.Expired); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		if ip != nil {
+			v.Address = net.IP(ip).String()
+		}
+
+		r = append(r, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return r, nil
+}
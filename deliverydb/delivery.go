@@ -5,9 +5,11 @@
 package deliverydb
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"net"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -107,8 +109,10 @@ insert into deliveries(
 	recipient_local_part,
 	client_hostname,
 	client_ip,
-	dsn)
-values(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`,
+	dsn,
+	sending_ip,
+	sasl_username)
+values(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`,
 	updateDeliveryWithRelay:         `update deliveries set next_relay_id = ? where id = ?`,
 	updateDeliveryWithOrigRecipient: `update deliveries set orig_recipient_domain_part_id = ? where id = ?`,
 	insertQueue:                     `insert into queues(name) values(?)`,
@@ -212,7 +216,7 @@ func New(connPair *dbconn.PooledPair, mapping *domainmapping.Mapper, options Opt
 		cleaningFrequency = time.Second * 30
 	)
 
-	runner := dbrunner.New(500*time.Millisecond, 1024*1000, connPair.RwConn, stmts, cleaningFrequency, makeCleanAction(options.RetentionDuration, cleaningBatchSize))
+	runner := dbrunner.New("logs", 500*time.Millisecond, 1024*1000, connPair.RwConn, stmts, cleaningFrequency, makeCleanAction(options.RetentionDuration, cleaningBatchSize))
 
 	return &DB{
 		connPair: connPair,
@@ -366,6 +370,8 @@ func insertMandatoryResultFields(tx *sql.Tx, stmts dbconn.TxPreparedStmts, tr tr
 		valueOrNil(tr[tracking.ConnectionClientHostnameKey]),
 		valueOrNil(tr[tracking.ConnectionClientIPKey]),
 		tr[tracking.ResultDSNKey].Text(),
+		valueOrNil(tr[tracking.ResultSendingIPKey]),
+		valueOrNil(tr[tracking.QueueSASLUsernameKey]),
 	)
 
 	if err != nil {
@@ -571,6 +577,52 @@ func (db *DB) HasLogs() bool {
 	return count > 0
 }
 
+// SendingIPs returns the local IPs used to send messages in the week before the most recent delivery
+func (db *DB) SendingIPs(ctx context.Context) (ips []net.IP, err error) {
+	conn, release, err := db.connPair.RoConnPool.AcquireContext(ctx)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer release()
+
+	rows, err := conn.QueryContext(ctx, `
+		with time_cut as (
+			select (delivery_ts - ?) as v from deliveries order by delivery_ts desc limit 1
+		)
+		select distinct
+			sending_ip
+		from
+			deliveries
+		where
+			delivery_ts >= (select v from time_cut) and direction = ? and sending_ip is not null
+		order by
+			sending_ip`, int64((time.Hour * 24 * 7).Seconds()), tracking.MessageDirectionOutbound)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	ips = []net.IP{}
+
+	for rows.Next() {
+		var ip net.IP
+
+		if err := rows.Scan(&ip); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		ips = append(ips, ip)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return ips, nil
+}
+
 func (db *DB) MostRecentLogTime() (time.Time, error) {
 	conn, release := db.connPair.RoConnPool.Acquire()
 
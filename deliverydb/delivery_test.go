@@ -7,6 +7,7 @@ package deliverydb
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net"
 	"path"
 	"testing"
@@ -399,6 +400,110 @@ func TestEntriesInsertion(t *testing.T) {
 					dashboard.Pair{Key: "another.de", Value: 1},
 				})
 			})
+
+			Convey("Delay percentiles", func() {
+				_, done, cancel, pub, d := buildWs()
+
+				withDelays := func(t time.Time, domain string, delay, smtp float64) tracking.Result {
+					r := fakeOutboundMessageWithRecipient(parser.SentStatus, t, "recipient", domain)
+					r[tracking.ResultDelayKey] = tracking.ResultEntryFloat64(delay)
+					r[tracking.ResultDelaySMTPKey] = tracking.ResultEntryFloat64(smtp)
+					return r
+				}
+
+				// first hour
+				for i := 1; i <= 10; i++ {
+					pub.Publish(withDelays(buildTime(2000, time.January, 1, 0, i, 0), "example.com", float64(i), float64(i)/2))
+				}
+
+				// nothing on the second hour, and one message on the third
+				pub.Publish(withDelays(buildTime(2000, time.January, 1, 2, 30, 0), "EXAMPLE.ORG", 42, 40))
+
+				// incoming messages are ignored
+				pub.Publish(fakeIncomingMessageWithRecipient(parser.SentStatus, buildTime(2000, time.January, 1, 0, 30, 0), "recipient", "example.org"))
+
+				cancel()
+				So(done(), ShouldBeNil)
+
+				interval := parseTimeInterval(`2000-01-01`, `2000-01-01`)
+
+				Convey("Invalid granularity", func() {
+					_, err := d.DelayPercentilesOverTime(dummyContext, interval, 0)
+					So(errors.Is(err, dashboard.ErrInvalidGranularity), ShouldBeTrue)
+				})
+
+				Convey("Over time", func() {
+					result, err := d.DelayPercentilesOverTime(dummyContext, interval, 1)
+					So(err, ShouldBeNil)
+
+					start := buildTime(2000, time.January, 1, 0, 0, 0).Unix()
+
+					So(result.Times, ShouldResemble, []int64{start, start + 3600, start + 7200})
+					So(result.Counts, ShouldResemble, []int64{10, 0, 1})
+					So(result.Values[dashboard.DelayTotal], ShouldResemble, dashboard.PercentilesSeries{
+						P50: []float64{5, 0, 42},
+						P90: []float64{9, 0, 42},
+						P99: []float64{10, 0, 42},
+					})
+					So(result.Values[dashboard.DelaySMTP], ShouldResemble, dashboard.PercentilesSeries{
+						P50: []float64{2.5, 0, 40},
+						P90: []float64{4.5, 0, 40},
+						P99: []float64{5, 0, 40},
+					})
+					So(result.Values[dashboard.DelayQmgr].P99, ShouldResemble, []float64{3, 0, 3})
+				})
+
+				Convey("Per recipient domain", func() {
+					result, err := d.DelayPercentilesByDomain(dummyContext, interval)
+					So(err, ShouldBeNil)
+					So(len(result), ShouldEqual, 2)
+
+					So(result[0].Domain, ShouldEqual, "example.com")
+					So(result[0].Count, ShouldEqual, 10)
+					So(result[0].Delays[dashboard.DelayTotal], ShouldResemble, dashboard.Percentiles{P50: 5, P90: 9, P99: 10})
+					So(result[0].Delays[dashboard.DelaySMTPD], ShouldResemble, dashboard.Percentiles{P50: 1, P90: 1, P99: 1})
+
+					So(result[1].Domain, ShouldEqual, "example.org")
+					So(result[1].Count, ShouldEqual, 1)
+					So(result[1].Delays[dashboard.DelaySMTP], ShouldResemble, dashboard.Percentiles{P50: 40, P90: 40, P99: 40})
+				})
+			})
+
+			Convey("Sending IPs", func() {
+				db, done, cancel, pub, d := buildWs()
+
+				withSendingIP := func(r tracking.Result, ip string) tracking.Result {
+					r[tracking.ResultSendingIPKey] = tracking.ResultEntryBlob(net.ParseIP(ip))
+					return r
+				}
+
+				// too old to be discovered, but still in the dashboard
+				pub.Publish(withSendingIP(smtpStatusRecord(parser.SentStatus, testutil.MustParseTime(`2000-01-01 10:00:00 +0000`)), "127.0.0.4"))
+
+				pub.Publish(withSendingIP(smtpStatusRecord(parser.SentStatus, testutil.MustParseTime(`2000-01-10 10:00:00 +0000`)), "127.0.0.2"))
+				pub.Publish(withSendingIP(smtpStatusRecord(parser.BouncedStatus, testutil.MustParseTime(`2000-01-10 10:00:01 +0000`)), "127.0.0.2"))
+				pub.Publish(withSendingIP(smtpStatusRecord(parser.DeferredStatus, testutil.MustParseTime(`2000-01-10 10:00:02 +0000`)), "2001:db8::1"))
+				pub.Publish(smtpStatusRecord(parser.SentStatus, testutil.MustParseTime(`2000-01-10 10:00:03 +0000`)))
+
+				// incoming messages are not sent by us
+				pub.Publish(withSendingIP(smtpStatusIncomingRecord(parser.SentStatus, testutil.MustParseTime(`2000-01-10 10:00:04 +0000`)), "127.0.0.3"))
+
+				cancel()
+				So(done(), ShouldBeNil)
+
+				ips, err := db.SendingIPs(dummyContext)
+				So(err, ShouldBeNil)
+				So(ips, ShouldResemble, []net.IP{net.ParseIP("127.0.0.2"), net.ParseIP("2001:db8::1")})
+
+				volumes, err := d.VolumeBySendingIP(dummyContext, parseTimeInterval("1999-12-01", "2000-01-30"))
+				So(err, ShouldBeNil)
+				So(volumes, ShouldResemble, []dashboard.SendingIPVolume{
+					{Address: "127.0.0.2", Total: 2, Sent: 1, Bounced: 1},
+					{Address: "", Total: 1, Sent: 1},
+					{Address: "127.0.0.4", Total: 1, Sent: 1},
+					{Address: "2001:db8::1", Total: 1, Deferred: 1},
+				})
+			})
 		})
 
 		Convey("Test Replied Message when the original message does not exist. Do not link message-ids", func() {
@@ -0,0 +1,306 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package deliverydb
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/erasure"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+const erasureDatabaseName = "logs"
+
+// The deliveries sent by clients authenticated with the target address as username are also erased,
+// even if using a different sender address.
+// NOTE: orig_recipient_local_part is currently never set, so for individual addresses
+// only the domain part of the original recipient can be checked.
+const selectDeliveriesToEraseByAddress = `
+with target_domains as (
+	select id from remote_domains where domain = @domain collate nocase
+)
+select
+	id, message_id, client_ip, conn_ts_begin,
+	(sender_domain_part_id in target_domains and sender_local_part = @local collate nocase) or coalesce(sasl_username = @address collate nocase, 0)
+from
+	deliveries
+where
+	(sender_domain_part_id in target_domains and sender_local_part = @local collate nocase)
+	or sasl_username = @address collate nocase
+	or (recipient_domain_part_id in target_domains and recipient_local_part = @local collate nocase)
+	or (orig_recipient_domain_part_id in target_domains and orig_recipient_local_part = @local collate nocase)
+`
+
+const selectDeliveriesToEraseByDomain = `
+with target_domains as (
+	select id from remote_domains where domain = @domain collate nocase
+)
+select
+	id, message_id, client_ip, conn_ts_begin, sender_domain_part_id in target_domains or coalesce(sasl_username like @username_pattern, 0)
+from
+	deliveries
+where
+	sender_domain_part_id in target_domains
+	or sasl_username like @username_pattern
+	or recipient_domain_part_id in target_domains
+	or orig_recipient_domain_part_id in target_domains
+`
+
+type deliveryToErase struct {
+	id        int64
+	messageId int64
+}
+
+type erasureCounters struct {
+	deliveries     int64
+	logLinesRefs   int64
+	deliveryQueues int64
+	queues         int64
+	messageIds     int64
+	replies        int64
+	domains        int64
+}
+
+func selectDeliveriesToErase(tx *sql.Tx, target erasure.Target) (deliveries []deliveryToErase, connections []erasure.Connection, err error) {
+	query := selectDeliveriesToEraseByAddress
+
+	if target.IsDomain() {
+		query = selectDeliveriesToEraseByDomain
+	}
+
+	rows, err := tx.Query(query,
+		sql.Named("domain", target.Domain),
+		sql.Named("local", target.LocalPart),
+		sql.Named("address", target.String()),
+		sql.Named("username_pattern", "%@"+target.Domain),
+	)
+	if err != nil {
+		return nil, nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	type connectionKey struct {
+		ip string
+		ts int64
+	}
+
+	seenConnections := map[connectionKey]struct{}{}
+
+	for rows.Next() {
+		var (
+			d           deliveryToErase
+			clientIP    []byte
+			connTs      sql.NullInt64
+			isTheSender bool
+		)
+
+		if err := rows.Scan(&d.id, &d.messageId, &clientIP, &connTs, &isTheSender); err != nil {
+			return nil, nil, errorutil.Wrap(err)
+		}
+
+		deliveries = append(deliveries, d)
+
+		if !isTheSender || len(clientIP) == 0 || !connTs.Valid {
+			continue
+		}
+
+		key := connectionKey{ip: string(clientIP), ts: connTs.Int64}
+
+		if _, ok := seenConnections[key]; ok {
+			continue
+		}
+
+		seenConnections[key] = struct{}{}
+
+		connections = append(connections, erasure.Connection{IP: net.IP(clientIP), Time: time.Unix(connTs.Int64, 0).In(time.UTC)})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, errorutil.Wrap(err)
+	}
+
+	return deliveries, connections, nil
+}
+
+func execAndCount(tx *sql.Tx, counter *int64, query string, args ...interface{}) error {
+	r, err := tx.Exec(query, args...)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	n, err := erasure.CountAffected(r)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	*counter += n
+
+	return nil
+}
+
+func eraseDeliveryQueue(tx *sql.Tx, deliveryId int64, counters *erasureCounters) error {
+	rows, err := tx.Query(`select queue_id from delivery_queue where delivery_id = ?`, deliveryId)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	queueIds := []int64{}
+
+	for rows.Next() {
+		var id int64
+
+		if err := rows.Scan(&id); err != nil {
+			_ = rows.Close()
+			return errorutil.Wrap(err)
+		}
+
+		queueIds = append(queueIds, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return errorutil.Wrap(err)
+	}
+
+	if err := rows.Close(); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if err := execAndCount(tx, &counters.deliveryQueues, `delete from delivery_queue where delivery_id = ?`, deliveryId); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	for _, queueId := range queueIds {
+		var stillInUse bool
+
+		if err := tx.QueryRow(`select exists(select 1 from delivery_queue where queue_id = ?)`, queueId).Scan(&stillInUse); err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		if stillInUse {
+			continue
+		}
+
+		if _, err := tx.Exec(`delete from expired_queues where queue_id = ?`, queueId); err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		if _, err := tx.Exec(`delete from queue_parenting where parent_queue_id = ? or child_queue_id = ?`, queueId, queueId); err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		if err := execAndCount(tx, &counters.queues, `delete from queues where id = ?`, queueId); err != nil {
+			return errorutil.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+func eraseMessageIdIfUnused(tx *sql.Tx, messageId int64, counters *erasureCounters) error {
+	var stillInUse bool
+
+	if err := tx.QueryRow(`select exists(select 1 from deliveries where message_id = ?)`, messageId).Scan(&stillInUse); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if stillInUse {
+		return nil
+	}
+
+	if err := execAndCount(tx, &counters.replies, `delete from messageids_replies where original_id = ? or reply_id = ?`, messageId, messageId); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if err := execAndCount(tx, &counters.messageIds, `delete from messageids where id = ?`, messageId); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func eraseDomainIfUnused(tx *sql.Tx, domain string, counters *erasureCounters) error {
+	return execAndCount(tx, &counters.domains, `
+	delete from remote_domains
+	where
+		domain = ? collate nocase
+		and not exists(select 1 from deliveries where
+			sender_domain_part_id = remote_domains.id
+			or recipient_domain_part_id = remote_domains.id
+			or orig_recipient_domain_part_id = remote_domains.id)`, domain)
+}
+
+func eraseDeliveries(tx *sql.Tx, target erasure.Target, deliveries []deliveryToErase) (erasure.Entries, error) {
+	counters := erasureCounters{}
+
+	for _, d := range deliveries {
+		if err := execAndCount(tx, &counters.logLinesRefs, `delete from log_lines_ref where delivery_id = ?`, d.id); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		if err := eraseDeliveryQueue(tx, d.id, &counters); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		if err := execAndCount(tx, &counters.deliveries, `delete from deliveries where id = ?`, d.id); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+	}
+
+	// done only after all the deliveries are gone, as many of them can share the same message-id
+	for _, d := range deliveries {
+		if err := eraseMessageIdIfUnused(tx, d.messageId, &counters); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+	}
+
+	if target.IsDomain() {
+		if err := eraseDomainIfUnused(tx, target.Domain, &counters); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+	}
+
+	entry := func(table string, count int64) erasure.Entry {
+		return erasure.Entry{Database: erasureDatabaseName, Table: table, Action: erasure.DeletedAction, Count: count}
+	}
+
+	return erasure.Entries{
+		entry("deliveries", counters.deliveries),
+		entry("log_lines_ref", counters.logLinesRefs),
+		entry("delivery_queue", counters.deliveryQueues),
+		entry("queues", counters.queues),
+		entry("messageids", counters.messageIds),
+		entry("messageids_replies", counters.replies),
+		entry("remote_domains", counters.domains),
+	}, nil
+}
+
+// Erase removes all deliveries where target is the sender or the recipient, and everything else referring to them.
+// It returns the connections used to send messages by target, as data about them is also personal information
+// and might be stored elsewhere.
+func (db *DB) Erase(ctx context.Context, target erasure.Target) (erasure.Entries, []erasure.Connection, error) {
+	var senderConnections []erasure.Connection
+
+	entries, err := erasure.Dispatch(ctx, db.Actions, func(tx *sql.Tx) (erasure.Entries, error) {
+		deliveries, connections, err := selectDeliveriesToErase(tx, target)
+		if err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		senderConnections = connections
+
+		return eraseDeliveries(tx, target, deliveries)
+	})
+
+	if err != nil {
+		return nil, nil, errorutil.Wrap(err)
+	}
+
+	return entries, senderConnections, nil
+}
@@ -0,0 +1,26 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package migrations
+
+import (
+	"database/sql"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/migrator"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+func init() {
+	migrator.AddMigration("logs", "10_client_ip_index.go", func(tx *sql.Tx) error {
+		// Used by the detective to search by client IP or network, which are ranges on the 16 bytes form of the IP
+		const sql = `create index deliveries_client_ip_index on deliveries(client_ip, delivery_ts)`
+
+		if _, err := tx.Exec(sql); err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		return nil
+	}, func(*sql.Tx) error {
+		return nil
+	})
+}
@@ -0,0 +1,26 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package migrations
+
+import (
+	"database/sql"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/migrator"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+func init() {
+	migrator.AddMigration("logs", "11_sending_ip.go", func(tx *sql.Tx) error {
+		// null when the local IP used to send the message is not known
+		const sql = `alter table deliveries add column sending_ip blob`
+
+		if _, err := tx.Exec(sql); err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		return nil
+	}, func(*sql.Tx) error {
+		return nil
+	})
+}
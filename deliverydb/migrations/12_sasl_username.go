@@ -0,0 +1,30 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package migrations
+
+import (
+	"database/sql"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/migrator"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+func init() {
+	migrator.AddMigration("logs", "12_sasl_username.go", func(tx *sql.Tx) error {
+		// null when the client has not authenticated
+		const sql = `alter table deliveries add column sasl_username text`
+
+		if _, err := tx.Exec(sql); err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		return nil
+	}, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`alter table deliveries drop column sasl_username`); err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,34 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package migrations
+
+import (
+	"database/sql"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/migrator"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+func init() {
+	migrator.AddMigration("logs", "9_search_indexes.go", func(tx *sql.Tx) error {
+		// Used by the detective for searches on whole domains and on local part prefixes.
+		// The local part ones are case-insensitive, so they can be used by `like` prefix searches.
+		const sql = `
+create index deliveries_sender_domain_index on deliveries(sender_domain_part_id, delivery_ts);
+
+create index deliveries_recipient_domain_index on deliveries(recipient_domain_part_id, delivery_ts);
+
+create index deliveries_sender_local_part_nocase_index on deliveries(sender_local_part collate nocase);
+
+create index deliveries_recipient_local_part_nocase_index on deliveries(recipient_local_part collate nocase);
+`
+		if _, err := tx.Exec(sql); err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		return nil
+	}, func(*sql.Tx) error {
+		return nil
+	})
+}
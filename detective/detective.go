@@ -9,7 +9,6 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"strconv"
 	"strings"
 	"time"
@@ -20,7 +19,6 @@ import (
 	parser "gitlab.com/lightmeter/controlcenter/pkg/postfix/logparser"
 	"gitlab.com/lightmeter/controlcenter/rawlogsdb"
 	"gitlab.com/lightmeter/controlcenter/tracking"
-	"gitlab.com/lightmeter/controlcenter/util/emailutil"
 	"gitlab.com/lightmeter/controlcenter/util/errorutil"
 	"gitlab.com/lightmeter/controlcenter/util/timeutil"
 )
@@ -28,8 +26,15 @@ import (
 const ResultsPerPage = 100
 
 type Detective interface {
-	CheckMessageDelivery(ctx context.Context, from, to string, interval timeutil.TimeInterval, status int, someID string, page int, limit int) (*MessagesPage, error)
+	CheckMessageDelivery(ctx context.Context, from, to string, interval timeutil.TimeInterval, status int, someID string, filters Filters, page int, limit int) (*MessagesPage, error)
 	OldestAvailableTime(context.Context) (time.Time, error)
+
+	// LastDeliveryID returns the id of the most recently stored delivery, or zero if there are none,
+	// allowing searches to be resumed by Filters.AfterDeliveryID
+	LastDeliveryID(context.Context) (int64, error)
+
+	// Thread returns the conversation a message, identified by its message-id or queue name, is part of
+	Thread(ctx context.Context, someID string) (*Thread, error)
 }
 
 type sqlDetective struct {
@@ -38,116 +43,11 @@ type sqlDetective struct {
 }
 
 const (
-	checkMessageDeliveryKey = iota
-	oldestAvailableTimeKey
+	oldestAvailableTimeKey = iota
 )
 
 func New(deliveriesConnPool *dbconn.RoPool, rawLogsAccessor rawlogsdb.Accessor) (Detective, error) {
 	setup := func(db *dbconn.RoPooledConn) error {
-		// TODO: this query is way too big, complex and difficult to read.
-		// It is potentially slower than needed, as it might compute non-needed cases.
-		// We should refactor the detective to have an individual query for each kind of search.
-		// There are many issues with this suggested approach, and the main one is that it requires extensive
-		// tests to ensure that each and every search option has a query implemented for it.
-		if err := db.PrepareStmt(`
-			with
-			sent_deliveries_filtered_by_condition(id, delivery_ts, status, dsn, queue_id, message_id, direction, returned, mailfrom, mailto, relay_id, is_reply) as (
-				select
-					d.id, d.delivery_ts, d.status, d.dsn, dq.queue_id, mid.value, d.direction, false,
-					sender_local_part    || '@' || sender_domain.domain    as mailfrom,
-					recipient_local_part || '@' || recipient_domain.domain as mailto,
-					d.next_relay_id,
-					(@status = @RepliedStatus)
-				from
-					deliveries d
-				join
-					remote_domains sender_domain    on sender_domain.id    = d.sender_domain_part_id
-				join
-					remote_domains recipient_domain on recipient_domain.id = d.recipient_domain_part_id
-				left join
-					next_relays relay on relay.id = d.next_relay_id
-				join
-					delivery_queue dq on dq.delivery_id = d.id
-				join
-					queues q on q.id = dq.queue_id
-				join
-					messageids mid on mid.id = d.message_id
-				where
-					(sender_local_part       = @sender_local_part    collate nocase or @sender_local_part = '') and
-					(sender_domain.domain    = @sender_domain        collate nocase or @sender_domain = '') and
-					(recipient_local_part    = @recipient_local_part collate nocase or @recipient_local_part = '') and
-					(recipient_domain.domain = @recipient_domain     collate nocase or @recipient_domain = '' or relay.hostname like @recipient_domain_like collate nocase) and
-					(delivery_ts between @start and @end) and
-					(
-						(status = @status and status not in (@ReceivedStatus, @RepliedStatus) and direction = @DirectionOutbound)  -- sent emails
-						or (@status = @ReceivedStatus and direction = @DirectionInbound)                                           -- received emails
-						or (
-							@status = @RepliedStatus
-								and direction = @DirectionInbound
-								and sender_local_part != '' -- bounce messages, where the sender is empty, are not replies!
-								and exists(select * from messageids_replies mr where mr.reply_id = d.message_id)
-						)
-						or @status = @NoStatus
-						or (@status = @ExpiredStatus and exists(select * from expired_queues where queue_id = q.id))
-					) and
-					(q.name = @someID or mid.value = @someID or @someID = '')
-			),
-			returned_deliveries(id, delivery_ts, status, dsn, queue_id, message_id, direction, returned, mailfrom, mailto, relay_id, is_reply) as (
-				select d.id, d.delivery_ts, d.status, d.dsn, sd.queue_id, mid.value, d.direction, true, mailfrom, mailto, d.next_relay_id, false
-				from
-					deliveries d
-				join
-					delivery_queue on delivery_queue.delivery_id = d.id
-				join
-					queue_parenting on delivery_queue.queue_id = queue_parenting.child_queue_id
-				join
-					queues qp on queue_parenting.parent_queue_id = qp.id
-				join
-					queues qc on queue_parenting.child_queue_id = qc.id
-				join
-					sent_deliveries_filtered_by_condition sd on qp.id = sd.queue_id
-				join
-					messageids mid on mid.id = d.message_id
-			),
-			deliveries_filtered_by_condition(id, delivery_ts, status, dsn, queue_id, message_id, direction, returned, mailfrom, mailto, relay_id, is_reply) as (
-				select id, delivery_ts, status, dsn, queue_id, message_id, direction, returned, mailfrom, mailto, relay_id, is_reply from sent_deliveries_filtered_by_condition
-				union
-				select id, delivery_ts, status, dsn, queue_id, message_id, direction, returned, mailfrom, mailto, relay_id, is_reply from returned_deliveries
-			),
-			queues_filtered_by_condition(delivery_id, queue_id, expired_ts, mailfrom, mailto) as (
-				select distinct deliveries_filtered_by_condition.id, delivery_queue.queue_id, expired_ts, mailfrom, mailto
-				from deliveries_filtered_by_condition
-				left join expired_queues eq on eq.queue_id = deliveries_filtered_by_condition.queue_id
-				join delivery_queue on delivery_queue.delivery_id = deliveries_filtered_by_condition.id
-			),
-			grouped_and_computed(log_refs, rn, total, delivery_ts, status, dsn, queue_id, message_id, queue, expired_ts, number_of_attempts, min_ts, max_ts, direction, returned, mailfrom, mailto, relay, is_reply) as (
-				select
-					json_group_array(distinct iif(ref.time is null, json_object('invalid', true), json_object('time', ref.time, 'checksum', ref.checksum))),
-					row_number() over (order by delivery_ts),
-					count() over () as total,
-					delivery_ts, status, dsn, d.queue_id, d.message_id, queues.name as queue, expired_ts,
-					count(distinct delivery_ts) as number_of_attempts, min(delivery_ts) as min_ts, max(delivery_ts) as max_ts,
-					d.direction as direction,
-					d.returned as returned,
-					d.mailfrom, json_group_array(distinct d.mailto),
-					json_group_array(distinct lm_host_domain_from_domain(coalesce(next_relays.hostname, 'local'))),
-					d.is_reply as is_reply
-				from deliveries_filtered_by_condition d
-				join queues on d.queue_id = queues.id
-				join queues_filtered_by_condition q on q.queue_id = d.queue_id 
-				left join next_relays on d.relay_id = next_relays.id
-				left join log_lines_ref ref on d.id = ref.delivery_id
-				group by d.queue_id, status, dsn
-			)
-			select total, status, dsn, queue, message_id, expired_ts, number_of_attempts, min_ts, max_ts, direction, returned, mailfrom, mailto, relay, log_refs, is_reply
-			from grouped_and_computed gac
-			order by delivery_ts, returned
-			limit @limit
-			offset @offset
-			`, checkMessageDeliveryKey); err != nil {
-			return errorutil.Wrap(err)
-		}
-
 		if err := db.PrepareStmt(`
 			with first_delivery_queue(delivery_id) as
 			(
@@ -176,7 +76,7 @@ func New(deliveriesConnPool *dbconn.RoPool, rawLogsAccessor rawlogsdb.Accessor)
 
 var ErrNoAvailableLogs = errors.New(`No available logs`)
 
-func (d *sqlDetective) CheckMessageDelivery(ctx context.Context, mailFrom string, mailTo string, interval timeutil.TimeInterval, status int, someID string, page int, limit int) (*MessagesPage, error) {
+func (d *sqlDetective) CheckMessageDelivery(ctx context.Context, mailFrom string, mailTo string, interval timeutil.TimeInterval, status int, someID string, filters Filters, page int, limit int) (*MessagesPage, error) {
 	conn, release, err := d.deliveriesConnPool.AcquireContext(ctx)
 	if err != nil {
 		return nil, errorutil.Wrap(err)
@@ -184,8 +84,18 @@ func (d *sqlDetective) CheckMessageDelivery(ctx context.Context, mailFrom string
 
 	defer release()
 
-	//nolint:sqlclosecheck
-	return checkMessageDelivery(ctx, d.rawLogsAccessor, conn.GetStmt(checkMessageDeliveryKey), mailFrom, mailTo, interval, status, someID, page, limit)
+	return checkMessageDelivery(ctx, d.rawLogsAccessor, conn, mailFrom, mailTo, interval, status, someID, filters, page, limit)
+}
+
+func (d *sqlDetective) Thread(ctx context.Context, someID string) (*Thread, error) {
+	conn, release, err := d.deliveriesConnPool.AcquireContext(ctx)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer release()
+
+	return buildThread(ctx, d.rawLogsAccessor, conn, someID)
 }
 
 func (d *sqlDetective) OldestAvailableTime(ctx context.Context) (time.Time, error) {
@@ -213,6 +123,23 @@ func (d *sqlDetective) OldestAvailableTime(ctx context.Context) (time.Time, erro
 	return time.Unix(ts, 0).In(time.UTC), nil
 }
 
+func (d *sqlDetective) LastDeliveryID(ctx context.Context) (int64, error) {
+	conn, release, err := d.deliveriesConnPool.AcquireContext(ctx)
+	if err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	defer release()
+
+	var id int64
+
+	if err := conn.QueryRowContext(ctx, `select coalesce(max(id), 0) from deliveries`).Scan(&id); err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	return id, nil
+}
+
 type QueueName = string
 
 type Message struct {
@@ -298,26 +225,13 @@ func parseLogRefs(ctx context.Context, rawLogsAccessor rawlogsdb.Accessor, conte
 
 // NOTE: we are checking rows.Err(), but the linter won't see that
 //nolint:gocognit
-func checkMessageDelivery(ctx context.Context, rawLogsAccessor rawlogsdb.Accessor, stmt *sql.Stmt, mailFrom string, mailTo string, interval timeutil.TimeInterval, status int, someID string, page int, limit int) (messagesPage *MessagesPage, err error) {
-	splitEmail := func(email string) (local, domain string, err error) {
-		if len(email) == 0 {
-			return "", "", nil
-		}
-
-		local, domain, _, err = emailutil.SplitPartial(email)
-		if err != nil {
-			return "", "", errorutil.Wrap(err)
-		}
-
-		return local, domain, nil
-	}
-
-	senderLocal, senderDomain, err := splitEmail(mailFrom)
+func checkMessageDelivery(ctx context.Context, rawLogsAccessor rawlogsdb.Accessor, conn *dbconn.RoPooledConn, mailFrom string, mailTo string, interval timeutil.TimeInterval, status int, someID string, filters Filters, page int, limit int) (messagesPage *MessagesPage, err error) {
+	sender, err := ParseAddressPattern(mailFrom)
 	if err != nil {
 		return nil, errorutil.Wrap(err)
 	}
 
-	recipientLocal, recipientDomain, err := splitEmail(mailTo)
+	recipient, err := ParseAddressPattern(mailTo)
 	if err != nil {
 		return nil, errorutil.Wrap(err)
 	}
@@ -328,26 +242,13 @@ func checkMessageDelivery(ctx context.Context, rawLogsAccessor rawlogsdb.Accesso
 		log.Debug().Msgf("Time to execute checkMessageDelivery: %v", time.Since(queryStart))
 	}()
 
-	//nolint:sqlclosecheck
-	rows, err := stmt.QueryContext(ctx,
-		sql.Named("start", interval.From.Unix()),
-		sql.Named("end", interval.To.Unix()),
-		sql.Named("status", status),
-		sql.Named("ReceivedStatus", parser.ReceivedStatus),
-		sql.Named("RepliedStatus", parser.RepliedStatus),
-		sql.Named("ExpiredStatus", parser.ExpiredStatus),
-		sql.Named("DirectionInbound", tracking.MessageDirectionIncoming),
-		sql.Named("NoStatus", -1),
-		sql.Named("DirectionOutbound", tracking.MessageDirectionOutbound),
-		sql.Named("sender_local_part", senderLocal),
-		sql.Named("sender_domain", senderDomain),
-		sql.Named("recipient_local_part", recipientLocal),
-		sql.Named("recipient_domain", recipientDomain),
-		sql.Named("recipient_domain_like", fmt.Sprintf("%%%s", recipientDomain)),
-		sql.Named("someID", someID),
-		sql.Named("limit", limit),
-		sql.Named("offset", (page-1)*limit),
-	)
+	if filters.MinSize != nil && filters.MaxSize != nil && *filters.MinSize > *filters.MaxSize {
+		return nil, ErrInvalidFilter
+	}
+
+	query, args := searchQuery(sender, recipient, interval, status, someID, filters, page, limit)
+
+	rows, err := conn.QueryContext(ctx, query, args...)
 
 	if err != nil {
 		return nil, errorutil.Wrap(err)
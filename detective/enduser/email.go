@@ -0,0 +1,57 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package enduser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gitlab.com/lightmeter/controlcenter/metadata"
+	"gitlab.com/lightmeter/controlcenter/notification/email"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+var ErrEmailNotConfigured = errors.New(`Email server is not configured`)
+
+const verificationMessage = `Hello,
+
+Someone, hopefully you, asked to check the delivery of the messages sent to or by %v.
+
+To do it, please open the following link in the next %v minutes:
+
+%v
+
+If you did not ask for it, you can safely ignore this message.`
+
+// EmailSender sends the verification links using the same server as the email notifications
+type EmailSender struct {
+	reader metadata.Reader
+	clock  timeutil.Clock
+}
+
+func NewEmailSender(reader metadata.Reader) *EmailSender {
+	return &EmailSender{reader: reader, clock: &timeutil.RealClock{}}
+}
+
+func (s *EmailSender) SendVerificationLink(ctx context.Context, address, link string) error {
+	settings, err := email.GetSettings(ctx, s.reader)
+	if err != nil && errors.Is(err, metadata.ErrNoSuchKey) {
+		return ErrEmailNotConfigured
+	}
+
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	body := fmt.Sprintf(verificationMessage, address, int(TokenDuration.Minutes()), link)
+
+	if err := email.SendMessage(*settings, address, "Verify your email address", body, s.clock.Now()); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,201 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+// Package enduser verifies the ownership of the addresses used by end users
+// of the message detective, by emailing them one-time links.
+//
+// The pending tokens and the rate limits are kept only in memory, and are lost on restart.
+// That invalidates the links not yet used, which can be requested again, and resets the limits,
+// which, being on the order of an hour, are not meaningfully weakened by that.
+package enduser
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+const (
+	// TokenDuration is for how long a verification link is valid
+	TokenDuration = 30 * time.Minute
+
+	// At most MaxVerificationRequests links are sent to an address in RequestsTimeFrame,
+	// preventing the detective from being used to flood someone's mailbox
+	MaxVerificationRequests = 3
+
+	// At most MaxLookups searches can be done by an end user in RequestsTimeFrame
+	MaxLookups = 100
+
+	RequestsTimeFrame = time.Hour
+)
+
+var (
+	ErrInvalidAddress  = errors.New(`Invalid email address`)
+	ErrInvalidToken    = errors.New(`Invalid or expired verification link`)
+	ErrTooManyRequests = errors.New(`Too many requests`)
+)
+
+// Sender delivers the verification link to an end user
+type Sender interface {
+	SendVerificationLink(ctx context.Context, address, link string) error
+}
+
+type pendingToken struct {
+	address string
+	expiry  time.Time
+}
+
+type counter struct {
+	startTs time.Time
+	count   int
+}
+
+type counters map[string]*counter
+
+// increment returns false if the limit for key has been reached
+func (c counters) increment(key string, limit int, now time.Time) bool {
+	for k, v := range c {
+		if now.Sub(v.startTs) > RequestsTimeFrame {
+			delete(c, k)
+		}
+	}
+
+	v, ok := c[key]
+	if !ok {
+		v = &counter{startTs: now}
+		c[key] = v
+	}
+
+	if v.count >= limit {
+		return false
+	}
+
+	v.count++
+
+	return true
+}
+
+type Verifier struct {
+	sender Sender
+	clock  timeutil.Clock
+
+	mutex    sync.Mutex
+	tokens   map[string]pendingToken
+	requests counters
+	lookups  counters
+}
+
+func New(sender Sender) *Verifier {
+	return NewWithCustomClock(sender, &timeutil.RealClock{})
+}
+
+func NewWithCustomClock(sender Sender, clock timeutil.Clock) *Verifier {
+	return &Verifier{
+		sender:   sender,
+		clock:    clock,
+		tokens:   map[string]pendingToken{},
+		requests: counters{},
+		lookups:  counters{},
+	}
+}
+
+// NormalizeAddress returns the address in the form used to compare it to the ones in the logs
+func NormalizeAddress(s string) (string, error) {
+	a, err := mail.ParseAddress(strings.TrimSpace(s))
+	if err != nil || a.Name != "" || !strings.Contains(a.Address, "@") {
+		return "", ErrInvalidAddress
+	}
+
+	return strings.ToLower(a.Address), nil
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", errorutil.Wrap(err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// RequestVerification sends address a link to verify it, which is linkPrefix followed by a random token
+func (v *Verifier) RequestVerification(ctx context.Context, address, linkPrefix string) error {
+	address, err := NormalizeAddress(address)
+	if err != nil {
+		return err
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	err = func() error {
+		v.mutex.Lock()
+		defer v.mutex.Unlock()
+
+		now := v.clock.Now()
+
+		if !v.requests.increment(address, MaxVerificationRequests, now) {
+			return fmt.Errorf("Verification of %v: %w", address, ErrTooManyRequests)
+		}
+
+		for t, p := range v.tokens {
+			if now.After(p.expiry) {
+				delete(v.tokens, t)
+			}
+		}
+
+		v.tokens[token] = pendingToken{address: address, expiry: now.Add(TokenDuration)}
+
+		return nil
+	}()
+
+	if err != nil {
+		return err
+	}
+
+	if err := v.sender.SendVerificationLink(ctx, address, linkPrefix+token); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+// Verify returns the address a token has been sent to. Each token can be used only once
+func (v *Verifier) Verify(token string) (string, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	p, ok := v.tokens[token]
+	if !ok {
+		return "", ErrInvalidToken
+	}
+
+	delete(v.tokens, token)
+
+	if v.clock.Now().After(p.expiry) {
+		return "", ErrInvalidToken
+	}
+
+	return p.address, nil
+}
+
+// AllowLookup returns false when the end user has done too many searches recently
+func (v *Verifier) AllowLookup(address string) bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	return v.lookups.increment(address, MaxLookups, v.clock.Now())
+}
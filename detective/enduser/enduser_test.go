@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package enduser
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gitlab.com/lightmeter/controlcenter/util/testutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+type fakeSender struct {
+	links map[string][]string
+}
+
+func (s *fakeSender) SendVerificationLink(ctx context.Context, address, link string) error {
+	s.links[address] = append(s.links[address], link)
+	return nil
+}
+
+func (s *fakeSender) lastToken(address string) string {
+	links := s.links[address]
+	So(links, ShouldNotBeEmpty)
+
+	return strings.TrimPrefix(links[len(links)-1], "https://example.com/verify?token=")
+}
+
+func TestVerifier(t *testing.T) {
+	Convey("Verifier", t, func() {
+		clock := &timeutil.FakeClock{Time: testutil.MustParseTime(`2000-01-01 00:00:00 +0000`)}
+		sender := &fakeSender{links: map[string][]string{}}
+		verifier := NewWithCustomClock(sender, clock)
+
+		request := func(address string) error {
+			return verifier.RequestVerification(context.Background(), address, "https://example.com/verify?token=")
+		}
+
+		Convey("Invalid addresses", func() {
+			for _, a := range []string{"", "alice", "Alice <alice@example.com>", "alice@example.com, bob@example.com"} {
+				So(errors.Is(request(a), ErrInvalidAddress), ShouldBeTrue)
+			}
+
+			So(sender.links, ShouldBeEmpty)
+		})
+
+		Convey("Tokens can be used only once", func() {
+			So(request(" Alice@Example.com "), ShouldBeNil)
+
+			token := sender.lastToken("alice@example.com")
+			So(len(token), ShouldEqual, 64)
+
+			address, err := verifier.Verify(token)
+			So(err, ShouldBeNil)
+			So(address, ShouldEqual, "alice@example.com")
+
+			_, err = verifier.Verify(token)
+			So(errors.Is(err, ErrInvalidToken), ShouldBeTrue)
+		})
+
+		Convey("Tokens expire", func() {
+			So(request("alice@example.com"), ShouldBeNil)
+
+			clock.Sleep(TokenDuration + time.Second)
+
+			_, err := verifier.Verify(sender.lastToken("alice@example.com"))
+			So(errors.Is(err, ErrInvalidToken), ShouldBeTrue)
+		})
+
+		Convey("Verification requests are limited", func() {
+			for i := 0; i < MaxVerificationRequests; i++ {
+				So(request("alice@example.com"), ShouldBeNil)
+			}
+
+			So(errors.Is(request("alice@example.com"), ErrTooManyRequests), ShouldBeTrue)
+
+			// other addresses are not affected
+			So(request("bob@example.com"), ShouldBeNil)
+
+			clock.Sleep(RequestsTimeFrame + time.Second)
+
+			So(request("alice@example.com"), ShouldBeNil)
+		})
+
+		Convey("Lookups are limited", func() {
+			for i := 0; i < MaxLookups; i++ {
+				So(verifier.AllowLookup("alice@example.com"), ShouldBeTrue)
+			}
+
+			So(verifier.AllowLookup("alice@example.com"), ShouldBeFalse)
+			So(verifier.AllowLookup("bob@example.com"), ShouldBeTrue)
+
+			clock.Sleep(RequestsTimeFrame + time.Second)
+
+			So(verifier.AllowLookup("alice@example.com"), ShouldBeTrue)
+		})
+	})
+}
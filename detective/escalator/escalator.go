@@ -16,6 +16,7 @@ import (
 type Request struct {
 	Sender    string                `json:"sender"`
 	Recipient string                `json:"recipient"`
+	SomeID    string                `json:"some_id"`
 	Interval  timeutil.TimeInterval `json:"time_interval"`
 	Messages  detective.Messages    `json:"messages"`
 }
@@ -54,19 +55,53 @@ func (e *escalator) Request(r Request) {
 	e.requests <- r
 }
 
-func TryToEscalateRequest(ctx context.Context, d detective.Detective, requester Requester, from, to string, interval timeutil.TimeInterval, someID string) error {
-	// FIXME: this call is only checking the first page, which is obviously wrong.
-	// It should instead browse through all, by iterating over all pages!
-	page := 1
+// maxPages limits how many results pages of a search are escalated,
+// as a search matching too many messages is not useful as an escalation anyway
+const maxPages = 10
+
+// allMessages fetches all the pages of results of a search, up to maxPages
+func allMessages(ctx context.Context, d detective.Detective, from, to string, interval timeutil.TimeInterval, someID string) (detective.Messages, error) {
+	all := detective.Messages{}
+
+	// position of each queue in all
+	indexes := map[detective.QueueName]int{}
+
+	for page := 1; page <= maxPages; page++ {
+		messages, err := d.CheckMessageDelivery(ctx, from, to, interval, -1, someID, detective.Filters{}, page, detective.ResultsPerPage)
+		if err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		// the deliveries of a queue might be split across pages
+		for _, m := range messages.Messages {
+			if i, ok := indexes[m.Queue]; ok {
+				all[i].Entries = append(all[i].Entries, m.Entries...)
+				continue
+			}
+
+			indexes[m.Queue] = len(all)
+			all = append(all, m)
+		}
 
-	messages, err := d.CheckMessageDelivery(ctx, from, to, interval, -1, someID, page, detective.ResultsPerPage)
+		if len(messages.Messages) == 0 || page >= messages.LastPage {
+			return all, nil
+		}
+	}
+
+	log.Warn().Msgf("Escalating only the first %v pages of results for sender: %v, recipient: %v and time interval: %v", maxPages, from, to, interval)
+
+	return all, nil
+}
+
+func TryToEscalateRequest(ctx context.Context, d detective.Detective, requester Requester, from, to string, interval timeutil.TimeInterval, someID string) error {
+	messages, err := allMessages(ctx, d, from, to, interval, someID)
 	if err != nil {
 		return errorutil.Wrap(err)
 	}
 
 	messagesToEscalate := detective.Messages{}
 
-	for _, groupedByQueue := range messages.Messages {
+	for _, groupedByQueue := range messages {
 		// NOTE: len(groupedByQueue) is always > 0, otherwise we have a bug!!!
 		// The final status is always the last element in the list, as before a `sent` or `expired`
 		// there might be many `deferred`
@@ -79,15 +114,16 @@ func TryToEscalateRequest(ctx context.Context, d detective.Detective, requester
 	}
 
 	if len(messagesToEscalate) == 0 {
-		log.Info().Msgf("Refused to escalate issue with sender: %v, recipient: %v and time interval: %v and %v results", from, to, interval, len(messages.Messages))
+		log.Info().Msgf("Refused to escalate issue with sender: %v, recipient: %v and time interval: %v and %v results", from, to, interval, len(messages))
 		return nil
 	}
 
-	log.Info().Msgf("Escalating issue with sender: %v, recipient: %v and time interval: %v and %v results", from, to, interval, len(messages.Messages))
+	log.Info().Msgf("Escalating issue with sender: %v, recipient: %v and time interval: %v and %v results", from, to, interval, len(messages))
 
 	requester.Request(Request{
 		Sender:    from,
 		Recipient: to,
+		SomeID:    someID,
 		Interval:  interval,
 		Messages:  messagesToEscalate,
 	})
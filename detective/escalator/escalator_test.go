@@ -35,7 +35,7 @@ func TestEscalation(t *testing.T) {
 		ctx := context.Background()
 
 		Convey("No detective results. Do not escalate", func() {
-			d.EXPECT().CheckMessageDelivery(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(&detective.MessagesPage{}, nil)
+			d.EXPECT().CheckMessageDelivery(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(&detective.MessagesPage{}, nil)
 			err := TryToEscalateRequest(ctx, d, requester, "sender@example.com", "recipient@example.com", mustParseTimeInterval("2000-01-01", "2000-01-01"), "")
 			So(err, ShouldBeNil)
 			So(len(requester.requests), ShouldEqual, 0)
@@ -44,7 +44,7 @@ func TestEscalation(t *testing.T) {
 		Convey("All messages were delived. Do not escalate", func() {
 			interval := mustParseTimeInterval("2000-01-01", "2000-01-01")
 
-			d.EXPECT().CheckMessageDelivery(gomock.Any(), "sender@example.com", "recipient@example.com", interval, gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(
+			d.EXPECT().CheckMessageDelivery(gomock.Any(), "sender@example.com", "recipient@example.com", interval, gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(
 				&detective.MessagesPage{
 					PageNumber:   1,
 					FirstPage:    1,
@@ -95,7 +95,7 @@ func TestEscalation(t *testing.T) {
 		Convey("Any of the messages was not delivered. Escalate one issue", func() {
 			interval := mustParseTimeInterval("2000-01-01", "2000-01-01")
 
-			d.EXPECT().CheckMessageDelivery(gomock.Any(), "sender@example.com", "recipient@example.com", interval, gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(
+			d.EXPECT().CheckMessageDelivery(gomock.Any(), "sender@example.com", "recipient@example.com", interval, gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(
 				&detective.MessagesPage{
 					PageNumber:   1,
 					FirstPage:    1,
@@ -169,7 +169,7 @@ func TestEscalation(t *testing.T) {
 		Convey("Escalate by queue name", func() {
 			interval := mustParseTimeInterval("2000-01-01", "2000-01-01")
 
-			d.EXPECT().CheckMessageDelivery(gomock.Any(), "", "", interval, gomock.Any(), "BBB", gomock.Any(), gomock.Any()).Return(
+			d.EXPECT().CheckMessageDelivery(gomock.Any(), "", "", interval, gomock.Any(), "BBB", gomock.Any(), gomock.Any(), gomock.Any()).Return(
 				&detective.MessagesPage{
 					PageNumber:   1,
 					FirstPage:    1,
@@ -197,6 +197,7 @@ func TestEscalation(t *testing.T) {
 				{
 					Sender:    "",
 					Recipient: "",
+					SomeID:    "BBB",
 					Interval:  interval,
 					Messages: detective.Messages{
 						detective.Message{
@@ -221,7 +222,7 @@ func TestEscalation(t *testing.T) {
 
 			interval := mustParseTimeInterval("2000-01-01", "2000-01-01")
 
-			d.EXPECT().CheckMessageDelivery(gomock.Any(), "sender@example.com", "recipient@example.com", interval, gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(
+			d.EXPECT().CheckMessageDelivery(gomock.Any(), "sender@example.com", "recipient@example.com", interval, gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(
 				&detective.MessagesPage{
 					PageNumber:   1,
 					FirstPage:    1,
@@ -355,5 +356,88 @@ func TestEscalation(t *testing.T) {
 				},
 			})
 		})
+
+		Convey("Messages from all the pages are escalated", func() {
+			interval := mustParseTimeInterval("2000-01-01", "2000-01-01")
+
+			delivery := func(status parser.SmtpStatus, t string) detective.MessageDelivery {
+				return detective.MessageDelivery{
+					NumberOfAttempts: 1,
+					TimeMin:          timeutil.MustParseTime(t),
+					TimeMax:          timeutil.MustParseTime(t),
+					Status:           detective.Status(status),
+					Dsn:              "4.0.0",
+				}
+			}
+
+			gomock.InOrder(
+				d.EXPECT().CheckMessageDelivery(gomock.Any(), "sender@example.com", "", interval, -1, "", detective.Filters{}, 1, detective.ResultsPerPage).Return(
+					&detective.MessagesPage{
+						PageNumber: 1, FirstPage: 1, LastPage: 2, TotalResults: 3,
+						Messages: detective.Messages{
+							{Queue: "AAAA", Entries: []detective.MessageDelivery{delivery(parser.SentStatus, `2000-01-01 09:00:00 +0000`)}},
+							{Queue: "BBBB", Entries: []detective.MessageDelivery{delivery(parser.DeferredStatus, `2000-01-01 10:00:00 +0000`)}},
+						},
+					}, nil),
+				d.EXPECT().CheckMessageDelivery(gomock.Any(), "sender@example.com", "", interval, -1, "", detective.Filters{}, 2, detective.ResultsPerPage).Return(
+					&detective.MessagesPage{
+						PageNumber: 2, FirstPage: 1, LastPage: 2, TotalResults: 3,
+						Messages: detective.Messages{
+							// the rest of the deliveries of the queue from the previous page
+							{Queue: "BBBB", Entries: []detective.MessageDelivery{delivery(parser.ExpiredStatus, `2000-01-01 11:00:00 +0000`)}},
+							{Queue: "CCCC", Entries: []detective.MessageDelivery{delivery(parser.BouncedStatus, `2000-01-01 12:00:00 +0000`)}},
+						},
+					}, nil),
+			)
+
+			err := TryToEscalateRequest(ctx, d, requester, "sender@example.com", "", interval, "")
+			So(err, ShouldBeNil)
+			So(requester.requests, ShouldResemble, []Request{
+				{
+					Sender:   "sender@example.com",
+					Interval: interval,
+					Messages: detective.Messages{
+						{Queue: "BBBB", Entries: []detective.MessageDelivery{
+							delivery(parser.DeferredStatus, `2000-01-01 10:00:00 +0000`),
+							delivery(parser.ExpiredStatus, `2000-01-01 11:00:00 +0000`),
+						}},
+						{Queue: "CCCC", Entries: []detective.MessageDelivery{delivery(parser.BouncedStatus, `2000-01-01 12:00:00 +0000`)}},
+					},
+				},
+			})
+		})
+
+		Convey("Only the first pages are escalated", func() {
+			interval := mustParseTimeInterval("2000-01-01", "2000-01-01")
+
+			page := func(queue detective.QueueName) *detective.MessagesPage {
+				return &detective.MessagesPage{
+					PageNumber: 1, FirstPage: 1, LastPage: 1000, TotalResults: 100000,
+					Messages: detective.Messages{
+						{Queue: queue, Entries: []detective.MessageDelivery{{
+							NumberOfAttempts: 1,
+							TimeMin:          timeutil.MustParseTime(`2000-01-01 09:00:00 +0000`),
+							TimeMax:          timeutil.MustParseTime(`2000-01-01 09:00:00 +0000`),
+							Status:           detective.Status(parser.BouncedStatus),
+							Dsn:              "5.0.0",
+						}}},
+					},
+				}
+			}
+
+			d.EXPECT().CheckMessageDelivery(gomock.Any(), "sender@example.com", "", interval, -1, "", detective.Filters{}, gomock.Any(), detective.ResultsPerPage).Return(page("AAAA"), nil).Times(maxPages - 1)
+			d.EXPECT().CheckMessageDelivery(gomock.Any(), "sender@example.com", "", interval, -1, "", detective.Filters{}, gomock.Any(), detective.ResultsPerPage).Return(page("BBBB"), nil).Times(1)
+
+			err := TryToEscalateRequest(ctx, d, requester, "sender@example.com", "", interval, "")
+			So(err, ShouldBeNil)
+			So(len(requester.requests), ShouldEqual, 1)
+
+			messages := requester.requests[0].Messages
+			So(len(messages), ShouldEqual, 2)
+			So(messages[0].Queue, ShouldEqual, "AAAA")
+			So(len(messages[0].Entries), ShouldEqual, maxPages-1)
+			So(messages[1].Queue, ShouldEqual, "BBBB")
+			So(len(messages[1].Entries), ShouldEqual, 1)
+		})
 	})
 }
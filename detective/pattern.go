@@ -0,0 +1,119 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package detective
+
+import (
+	"errors"
+	"strings"
+
+	"gitlab.com/lightmeter/controlcenter/util/emailutil"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+var ErrInvalidPattern = errors.New(`Invalid search pattern`)
+
+// AddressPattern is a case-insensitive search on sender or recipient addresses.
+//
+// It accepts the same forms as before (`user@example.com`, `@example.com` or `example.com`),
+// plus glob-style wildcards: `*` matches any sequence of characters and `?` a single one,
+// so `*@customer.com` and `newsletter-*@*` are valid patterns, and `news*@example.com` is a prefix search.
+// A domain starting with a dot, as in `user@.example.com` or `.example.com`,
+// matches the domain itself and all its subdomains.
+//
+// The fields are SQL LIKE patterns, using `\` as escape character.
+type AddressPattern struct {
+	LocalPart string
+	Domain    string
+
+	// Subdomains is nil when subdomains have not been requested
+	Subdomains *string
+
+	// HasWildcards is true if any wildcard or subdomain matching is used
+	HasWildcards bool
+}
+
+const likeEscape = `\`
+
+func globToLike(s string) (pattern string, hasWildcards bool) {
+	var b strings.Builder
+
+	for _, c := range s {
+		switch c {
+		case '*':
+			b.WriteRune('%')
+
+			hasWildcards = true
+		case '?':
+			b.WriteRune('_')
+
+			hasWildcards = true
+		case '%', '_', '\\':
+			b.WriteString(likeEscape)
+			b.WriteRune(c)
+		default:
+			b.WriteRune(c)
+		}
+	}
+
+	return b.String(), hasWildcards
+}
+
+// ParseAddressPattern parses a search on an address. An empty string matches any address.
+func ParseAddressPattern(s string) (AddressPattern, error) {
+	if len(s) == 0 {
+		return AddressPattern{LocalPart: "%", Domain: "%"}, nil
+	}
+
+	local, domain, _, err := emailutil.SplitPartial(s)
+	if err != nil {
+		return AddressPattern{}, errorutil.Wrap(err)
+	}
+
+	includeSubdomains := strings.HasPrefix(domain, ".")
+
+	if includeSubdomains {
+		domain = strings.TrimPrefix(domain, ".")
+	}
+
+	if len(domain) == 0 || strings.HasPrefix(domain, ".") {
+		return AddressPattern{}, ErrInvalidPattern
+	}
+
+	pattern := AddressPattern{LocalPart: "%"}
+
+	if len(local) > 0 {
+		l, hasWildcards := globToLike(local)
+		pattern.LocalPart = l
+		pattern.HasWildcards = hasWildcards
+	}
+
+	d, hasWildcards := globToLike(domain)
+	pattern.Domain = d
+	pattern.HasWildcards = pattern.HasWildcards || hasWildcards || includeSubdomains
+
+	if includeSubdomains {
+		subdomains := "%." + d
+		pattern.Subdomains = &subdomains
+	}
+
+	return pattern, nil
+}
+
+// IsPattern returns true if the search is on more than one address,
+// either by being partial or by using wildcards
+func IsPattern(s string) bool {
+	_, _, isPartial, err := emailutil.SplitPartial(s)
+	if err != nil {
+		return false
+	}
+
+	if isPartial {
+		return true
+	}
+
+	p, err := ParseAddressPattern(s)
+
+	return err == nil && p.HasWildcards
+}
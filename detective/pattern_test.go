@@ -0,0 +1,56 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package detective
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestAddressPattern(t *testing.T) {
+	Convey("Address patterns", t, func() {
+		Convey("Invalid", func() {
+			for _, s := range []string{"a@b@c", "user@", "user@.", "..example.com"} {
+				_, err := ParseAddressPattern(s)
+				So(err, ShouldNotBeNil)
+			}
+		})
+
+		Convey("Valid", func() {
+			for _, c := range []struct {
+				input    string
+				expected AddressPattern
+			}{
+				{"", AddressPattern{LocalPart: "%", Domain: "%"}},
+				{"user@example.com", AddressPattern{LocalPart: "user", Domain: "example.com"}},
+				{"example.com", AddressPattern{LocalPart: "%", Domain: "example.com"}},
+				{"@example.com", AddressPattern{LocalPart: "%", Domain: "example.com"}},
+				{"*@customer.com", AddressPattern{LocalPart: "%", Domain: "customer.com", HasWildcards: true}},
+				{"newsletter-*@*", AddressPattern{LocalPart: "newsletter-%", Domain: "%", HasWildcards: true}},
+				{"us?r@example.com", AddressPattern{LocalPart: "us_r", Domain: "example.com", HasWildcards: true}},
+				{"first_last%@ex\\ample.com", AddressPattern{LocalPart: `first\_last\%`, Domain: `ex\\ample.com`}},
+				{".example.com", AddressPattern{LocalPart: "%", Domain: "example.com", Subdomains: strPtr("%.example.com"), HasWildcards: true}},
+				{"user@.example.*", AddressPattern{LocalPart: "user", Domain: "example.%", Subdomains: strPtr("%.example.%"), HasWildcards: true}},
+			} {
+				p, err := ParseAddressPattern(c.input)
+				So(err, ShouldBeNil)
+				So(p, ShouldResemble, c.expected)
+			}
+		})
+
+		Convey("Is pattern", func() {
+			So(IsPattern("user@example.com"), ShouldBeFalse)
+			So(IsPattern("example.com"), ShouldBeTrue)
+			So(IsPattern("@example.com"), ShouldBeTrue)
+			So(IsPattern("*@example.com"), ShouldBeTrue)
+			So(IsPattern("user@.example.com"), ShouldBeTrue)
+		})
+	})
+}
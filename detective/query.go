@@ -0,0 +1,311 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package detective
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	parser "gitlab.com/lightmeter/controlcenter/pkg/postfix/logparser"
+	"gitlab.com/lightmeter/controlcenter/tracking"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+var ErrInvalidFilter = errors.New(`Invalid search filter`)
+
+// Filters are optional conditions to narrow a search down.
+// The zero value of each field means it should not be used as filter.
+type Filters struct {
+	// ClientIP is the network the message has been received from. A single IP address is a /32 or /128 network
+	ClientIP *net.IPNet
+
+	// Relay is a case-insensitive glob-style pattern on the next relay hostname, also matching its subdomains
+	Relay string
+
+	// DSNPrefix matches the start of the DSN, as `5.1` for `5.1.1`
+	DSNPrefix string
+
+	// MinSize and MaxSize are the bounds, inclusive, of the original message size, in bytes
+	MinSize *int64
+	MaxSize *int64
+
+	// AfterDeliveryID and UpToDeliveryID restrict the search to the deliveries stored after the one with the
+	// first id, up to the one with the second, inclusive. As ids grow as deliveries are stored, they allow a
+	// search to be resumed without missing deliveries stored late. Zero means no restriction
+	AfterDeliveryID int64
+	UpToDeliveryID  int64
+
+	// messageIDs restricts the search to the messages with the given ids in the messageids table,
+	// as used by conversations. It is not set by users
+	messageIDs []int64
+}
+
+// ParseClientIP parses either a single IP address or a network in the CIDR notation
+func ParseClientIP(s string) (*net.IPNet, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			bits = 8 * net.IPv4len
+			ip = ip.To4()
+		}
+
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return network, nil
+}
+
+// ipRange returns the first and last addresses of a network, in the 16 bytes form they are stored
+func ipRange(network *net.IPNet) (first, last net.IP) {
+	ip := network.IP.Mask(network.Mask)
+
+	first = make(net.IP, len(ip))
+	last = make(net.IP, len(ip))
+
+	for i := range ip {
+		first[i] = ip[i]
+		last[i] = ip[i] | ^network.Mask[i]
+	}
+
+	return first.To16(), last.To16()
+}
+
+// condition is a fragment of the clause selecting deliveries, with the arguments it depends on.
+// Deliveries are available as `d`, their queues as `q`, message ids as `mid`, relays as `relay`
+// and the sender and recipient domains as `sender_domain` and `recipient_domain`.
+type condition struct {
+	clause string
+	args   []interface{}
+}
+
+type conditions []condition
+
+func (c conditions) with(clause string, args ...interface{}) conditions {
+	return append(c, condition{clause: clause, args: args})
+}
+
+func (c conditions) withAddress(role string, p AddressPattern) conditions {
+	if p.LocalPart != "%" {
+		c = c.with(fmt.Sprintf(`d.%[1]s_local_part like @%[1]s_local_part escape '\'`, role),
+			sql.Named(role+"_local_part", p.LocalPart))
+	}
+
+	if p.Domain == "%" {
+		return c
+	}
+
+	domainClause := fmt.Sprintf(`%[1]s_domain.domain like @%[1]s_domain escape '\' or %[1]s_domain.domain like @%[1]s_subdomains escape '\'`, role)
+
+	// a recipient domain also matches the relay the message has been sent to, useful for hosted domains
+	if role == "recipient" {
+		domainClause += ` or relay.hostname like @recipient_domain_like escape '\'`
+	}
+
+	return c.with(domainClause,
+		sql.Named(role+"_domain", p.Domain),
+		sql.Named(role+"_subdomains", p.Subdomains),
+		sql.Named(role+"_domain_like", "%"+p.Domain))
+}
+
+func (c conditions) withStatus(status int) conditions {
+	args := []interface{}{
+		sql.Named("status", status),
+		sql.Named("DirectionInbound", tracking.MessageDirectionIncoming),
+		sql.Named("DirectionOutbound", tracking.MessageDirectionOutbound),
+	}
+
+	switch parser.SmtpStatus(status) {
+	case -1:
+		return c
+	case parser.ReceivedStatus:
+		return c.with(`d.direction = @DirectionInbound`, args...)
+	case parser.RepliedStatus:
+		return c.with(`d.direction = @DirectionInbound
+			and d.sender_local_part != '' -- bounce messages, where the sender is empty, are not replies!
+			and exists(select * from messageids_replies mr where mr.reply_id = d.message_id)`, args...)
+	case parser.ExpiredStatus:
+		return c.with(`(d.status = @status and d.direction = @DirectionOutbound)
+			or exists(select * from expired_queues where queue_id = q.id)`, args...)
+	default:
+		return c.with(`d.status = @status and d.direction = @DirectionOutbound`, args...)
+	}
+}
+
+func (c conditions) withSomeID(someID string) conditions {
+	if len(someID) == 0 {
+		return c
+	}
+
+	return c.with(`q.name = @someID or mid.value = @someID`, sql.Named("someID", someID))
+}
+
+func (c conditions) withFilters(f Filters) conditions {
+	if f.ClientIP != nil {
+		first, last := ipRange(f.ClientIP)
+		c = c.with(`length(d.client_ip) = 16 and d.client_ip between @client_ip_first and @client_ip_last`,
+			sql.Named("client_ip_first", []byte(first)),
+			sql.Named("client_ip_last", []byte(last)))
+	}
+
+	if len(f.Relay) > 0 {
+		relay, _ := globToLike(f.Relay)
+		c = c.with(`relay.hostname like @relay escape '\' or relay.hostname like @relay_subdomains escape '\'`,
+			sql.Named("relay", relay),
+			sql.Named("relay_subdomains", "%."+relay))
+	}
+
+	if len(f.DSNPrefix) > 0 {
+		dsn, _ := globToLike(f.DSNPrefix)
+		c = c.with(`d.dsn like @dsn_prefix escape '\'`, sql.Named("dsn_prefix", dsn+"%"))
+	}
+
+	if f.MinSize != nil {
+		c = c.with(`d.orig_msg_size >= @min_size`, sql.Named("min_size", *f.MinSize))
+	}
+
+	if f.MaxSize != nil {
+		c = c.with(`d.orig_msg_size <= @max_size`, sql.Named("max_size", *f.MaxSize))
+	}
+
+	if len(f.messageIDs) > 0 {
+		// not expected to fail, as it's a slice of integers
+		encodedIDs, _ := json.Marshal(f.messageIDs)
+		c = c.with(`d.message_id in (select value from json_each(@message_ids))`, sql.Named("message_ids", string(encodedIDs)))
+	}
+
+	if f.AfterDeliveryID > 0 {
+		c = c.with(`d.id > @after_delivery_id`, sql.Named("after_delivery_id", f.AfterDeliveryID))
+	}
+
+	if f.UpToDeliveryID > 0 {
+		c = c.with(`d.id <= @up_to_delivery_id`, sql.Named("up_to_delivery_id", f.UpToDeliveryID))
+	}
+
+	return c
+}
+
+func (c conditions) clauseAndArgs() (string, []interface{}) {
+	clauses := make([]string, 0, len(c))
+	args := []interface{}{}
+
+	for _, cond := range c {
+		clauses = append(clauses, "("+cond.clause+")")
+		args = append(args, cond.args...)
+	}
+
+	return strings.Join(clauses, " and\n"), args
+}
+
+// searchQuery builds the query for a search, composed by only the conditions needed by it.
+// The deliveries matching the conditions are then extended by the ones returned (bounced) to the sender,
+// and grouped by queue, status and dsn.
+func searchQuery(sender, recipient AddressPattern, interval timeutil.TimeInterval, status int, someID string, filters Filters, page, limit int) (string, []interface{}) {
+	where, args := conditions{}.
+		with(`d.delivery_ts between @start and @end`, sql.Named("start", interval.From.Unix()), sql.Named("end", interval.To.Unix())).
+		withAddress("sender", sender).
+		withAddress("recipient", recipient).
+		withStatus(status).
+		withSomeID(someID).
+		withFilters(filters).
+		clauseAndArgs()
+
+	args = append(args,
+		sql.Named("is_reply", status == int(parser.RepliedStatus)),
+		sql.Named("limit", limit),
+		sql.Named("offset", (page-1)*limit),
+	)
+
+	return fmt.Sprintf(searchQueryTemplate, where), args
+}
+
+const searchQueryTemplate = `
+with
+sent_deliveries_filtered_by_condition(id, delivery_ts, status, dsn, queue_id, message_id, direction, returned, mailfrom, mailto, relay_id, is_reply) as (
+	select
+		d.id, d.delivery_ts, d.status, d.dsn, dq.queue_id, mid.value, d.direction, false,
+		sender_local_part    || '@' || sender_domain.domain    as mailfrom,
+		recipient_local_part || '@' || recipient_domain.domain as mailto,
+		d.next_relay_id,
+		@is_reply
+	from
+		deliveries d
+	join
+		remote_domains sender_domain    on sender_domain.id    = d.sender_domain_part_id
+	join
+		remote_domains recipient_domain on recipient_domain.id = d.recipient_domain_part_id
+	left join
+		next_relays relay on relay.id = d.next_relay_id
+	join
+		delivery_queue dq on dq.delivery_id = d.id
+	join
+		queues q on q.id = dq.queue_id
+	join
+		messageids mid on mid.id = d.message_id
+	where
+		%s
+),
+returned_deliveries(id, delivery_ts, status, dsn, queue_id, message_id, direction, returned, mailfrom, mailto, relay_id, is_reply) as (
+	select d.id, d.delivery_ts, d.status, d.dsn, sd.queue_id, mid.value, d.direction, true, mailfrom, mailto, d.next_relay_id, false
+	from
+		deliveries d
+	join
+		delivery_queue on delivery_queue.delivery_id = d.id
+	join
+		queue_parenting on delivery_queue.queue_id = queue_parenting.child_queue_id
+	join
+		queues qp on queue_parenting.parent_queue_id = qp.id
+	join
+		queues qc on queue_parenting.child_queue_id = qc.id
+	join
+		sent_deliveries_filtered_by_condition sd on qp.id = sd.queue_id
+	join
+		messageids mid on mid.id = d.message_id
+),
+deliveries_filtered_by_condition(id, delivery_ts, status, dsn, queue_id, message_id, direction, returned, mailfrom, mailto, relay_id, is_reply) as (
+	select id, delivery_ts, status, dsn, queue_id, message_id, direction, returned, mailfrom, mailto, relay_id, is_reply from sent_deliveries_filtered_by_condition
+	union
+	select id, delivery_ts, status, dsn, queue_id, message_id, direction, returned, mailfrom, mailto, relay_id, is_reply from returned_deliveries
+),
+queues_filtered_by_condition(delivery_id, queue_id, expired_ts, mailfrom, mailto) as (
+	select distinct deliveries_filtered_by_condition.id, delivery_queue.queue_id, expired_ts, mailfrom, mailto
+	from deliveries_filtered_by_condition
+	left join expired_queues eq on eq.queue_id = deliveries_filtered_by_condition.queue_id
+	join delivery_queue on delivery_queue.delivery_id = deliveries_filtered_by_condition.id
+),
+grouped_and_computed(log_refs, rn, total, delivery_ts, status, dsn, queue_id, message_id, queue, expired_ts, number_of_attempts, min_ts, max_ts, direction, returned, mailfrom, mailto, relay, is_reply) as (
+	select
+		json_group_array(distinct iif(ref.time is null, json_object('invalid', true), json_object('time', ref.time, 'checksum', ref.checksum))),
+		row_number() over (order by delivery_ts),
+		count() over () as total,
+		delivery_ts, status, dsn, d.queue_id, d.message_id, queues.name as queue, expired_ts,
+		count(distinct delivery_ts) as number_of_attempts, min(delivery_ts) as min_ts, max(delivery_ts) as max_ts,
+		d.direction as direction,
+		d.returned as returned,
+		d.mailfrom, json_group_array(distinct d.mailto),
+		json_group_array(distinct lm_host_domain_from_domain(coalesce(next_relays.hostname, 'local'))),
+		d.is_reply as is_reply
+	from deliveries_filtered_by_condition d
+	join queues on d.queue_id = queues.id
+	join queues_filtered_by_condition q on q.queue_id = d.queue_id
+	left join next_relays on d.relay_id = next_relays.id
+	left join log_lines_ref ref on d.id = ref.delivery_id
+	group by d.queue_id, status, dsn
+)
+select total, status, dsn, queue, message_id, expired_ts, number_of_attempts, min_ts, max_ts, direction, returned, mailfrom, mailto, relay, log_refs, is_reply
+from grouped_and_computed gac
+order by delivery_ts, returned
+limit @limit
+offset @offset
+`
@@ -0,0 +1,43 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package detective
+
+import (
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClientIPRange(t *testing.T) {
+	Convey("Client IP ranges", t, func() {
+		Convey("Invalid", func() {
+			for _, s := range []string{"", "1.2.3", "1.2.3.4/33", "example.com"} {
+				_, err := ParseClientIP(s)
+				So(err, ShouldNotBeNil)
+			}
+		})
+
+		for _, c := range []struct {
+			input       string
+			first, last string
+		}{
+			{"203.0.113.5", "203.0.113.5", "203.0.113.5"},
+			{"203.0.113.5/24", "203.0.113.0", "203.0.113.255"},
+			{"10.0.0.0/8", "10.0.0.0", "10.255.255.255"},
+			{"2001:db8::1", "2001:db8::1", "2001:db8::1"},
+			{"2001:db8::/32", "2001:db8::", "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"},
+		} {
+			network, err := ParseClientIP(c.input)
+			So(err, ShouldBeNil)
+
+			first, last := ipRange(network)
+
+			// stored in the same form as the addresses in the database
+			So(first, ShouldResemble, net.ParseIP(c.first))
+			So(last, ShouldResemble, net.ParseIP(c.last))
+		}
+	})
+}
@@ -0,0 +1,340 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package detective
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/dbconn"
+	"gitlab.com/lightmeter/controlcenter/rawlogsdb"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+// MaxThreadSize limits how many messages are fetched for a conversation,
+// protecting us from arbitrarily long References headers
+const MaxThreadSize = 200
+
+var ErrNoSuchMessage = errors.New(`No such message`)
+
+// ThreadMessage is a message in a conversation, with all its deliveries, grouped by queue, and its replies
+type ThreadMessage struct {
+	MessageID string           `json:"message_id"`
+	Messages  Messages         `json:"messages"`
+	Replies   []*ThreadMessage `json:"replies"`
+}
+
+type Thread struct {
+	// Roots are the messages starting the conversation.
+	// There can be more than one when some of the messages in between are not known
+	Roots []*ThreadMessage `json:"roots"`
+
+	// Size is the number of messages in the conversation
+	Size int `json:"size"`
+
+	// Truncated is true when the conversation has more than MaxThreadSize messages
+	Truncated bool `json:"truncated"`
+}
+
+// all the deliveries of a message, regardless of when they happened
+var wholeTimeInterval = timeutil.TimeInterval{
+	From: time.Unix(0, 0).In(time.UTC),
+	To:   time.Date(9999, time.December, 31, 23, 59, 59, 0, time.UTC),
+}
+
+func findMessageIDRowID(ctx context.Context, conn *dbconn.RoPooledConn, someID string) (int64, error) {
+	var id int64
+
+	err := conn.QueryRowContext(ctx, `select id from messageids where value = ?`, someID).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, errorutil.Wrap(err)
+	}
+
+	// then try it as a queue name
+	err = conn.QueryRowContext(ctx, `
+		select
+			d.message_id
+		from
+			queues q
+		join
+			delivery_queue dq on dq.queue_id = q.id
+		join
+			deliveries d on d.id = dq.delivery_id
+		where
+			q.name = ?
+		limit 1`, someID).Scan(&id)
+
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNoSuchMessage
+	}
+
+	if err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	return id, nil
+}
+
+type replyLink struct {
+	original int64
+	reply    int64
+}
+
+// conversationLinks returns all the messages linked, directly or not, to the one with the given id,
+// with the links between them, using only two queries, regardless of how big the conversation is
+func conversationLinks(ctx context.Context, conn *dbconn.RoPooledConn, id int64) (nodes []int64, links []replyLink, truncated bool, err error) {
+	// the messages are visited in breadth-first order, so the closest ones are kept when the conversation is truncated
+	nodes, err = queryIDs(ctx, conn, `
+		with recursive conversation(id) as (
+			select ?
+			union
+			select r.original_id from messageids_replies r join conversation c on r.reply_id = c.id
+			union
+			select r.reply_id from messageids_replies r join conversation c on r.original_id = c.id
+			limit ?
+		)
+		select id from conversation`, id, MaxThreadSize+1)
+	if err != nil {
+		return nil, nil, false, errorutil.Wrap(err)
+	}
+
+	if len(nodes) > MaxThreadSize {
+		nodes, truncated = nodes[:MaxThreadSize], true
+	}
+
+	encodedNodes, err := json.Marshal(nodes)
+	if err != nil {
+		return nil, nil, false, errorutil.Wrap(err)
+	}
+
+	rows, err := conn.QueryContext(ctx, `
+		select
+			original_id, reply_id
+		from
+			messageids_replies
+		where
+			original_id in (select value from json_each(@nodes)) and reply_id in (select value from json_each(@nodes))`,
+		sql.Named("nodes", string(encodedNodes)))
+	if err != nil {
+		return nil, nil, false, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	for rows.Next() {
+		var l replyLink
+
+		if err := rows.Scan(&l.original, &l.reply); err != nil {
+			return nil, nil, false, errorutil.Wrap(err)
+		}
+
+		links = append(links, l)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, false, errorutil.Wrap(err)
+	}
+
+	return nodes, links, truncated, nil
+}
+
+func queryIDs(ctx context.Context, conn *dbconn.RoPooledConn, query string, args ...interface{}) (ids []int64, err error) {
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	for rows.Next() {
+		var id int64
+
+		if err := rows.Scan(&id); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return ids, nil
+}
+
+// parents finds out to which message each one is a direct reply to.
+// As the References header lists all the previous messages in a conversation,
+// a message is linked to all of them, and its parent is the most recent one, the farthest from the start.
+func parents(nodes []int64, links []replyLink) map[int64]int64 {
+	originals := map[int64][]int64{}
+
+	for _, l := range links {
+		if l.original != l.reply {
+			originals[l.reply] = append(originals[l.reply], l.original)
+		}
+	}
+
+	depths := map[int64]int{}
+	inProgress := map[int64]bool{}
+
+	var depth func(int64) int
+
+	depth = func(n int64) int {
+		if d, ok := depths[n]; ok {
+			return d
+		}
+
+		// broken References headers might cause loops
+		if inProgress[n] {
+			return 0
+		}
+
+		inProgress[n] = true
+
+		d := 0
+
+		for _, o := range originals[n] {
+			if od := depth(o) + 1; od > d {
+				d = od
+			}
+		}
+
+		inProgress[n] = false
+		depths[n] = d
+
+		return d
+	}
+
+	result := map[int64]int64{}
+
+	for _, n := range nodes {
+		candidates := originals[n]
+		if len(candidates) == 0 {
+			continue
+		}
+
+		// the ids are sorted to make the choice stable in case of ties
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i] < candidates[j] })
+
+		parent, parentDepth := int64(0), -1
+
+		for _, c := range candidates {
+			// the parent must come before in the conversation, which also prevents loops
+			if d := depth(c); d > parentDepth && d < depth(n) {
+				parent, parentDepth = c, d
+			}
+		}
+
+		if parentDepth >= 0 {
+			result[n] = parent
+		}
+	}
+
+	return result
+}
+
+func messageIDValues(ctx context.Context, conn *dbconn.RoPooledConn, ids []int64) (values map[int64]string, err error) {
+	encodedIDs, err := json.Marshal(ids)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	rows, err := conn.QueryContext(ctx, `select id, value from messageids where id in (select value from json_each(?))`, string(encodedIDs))
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	values = make(map[int64]string, len(ids))
+
+	for rows.Next() {
+		var (
+			id    int64
+			value string
+		)
+
+		if err := rows.Scan(&id, &value); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		values[id] = value
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return values, nil
+}
+
+func buildThread(ctx context.Context, rawLogsAccessor rawlogsdb.Accessor, conn *dbconn.RoPooledConn, someID string) (*Thread, error) {
+	id, err := findMessageIDRowID(ctx, conn, someID)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, links, truncated, err := conversationLinks(ctx, conn, id)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	// messages appear in the conversation in the order they were first seen
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+
+	values, err := messageIDValues(ctx, conn, nodes)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	messages := make(map[int64]*ThreadMessage, len(nodes))
+	byValue := make(map[string]*ThreadMessage, len(nodes))
+
+	for _, n := range nodes {
+		m := &ThreadMessage{MessageID: values[n], Messages: Messages{}, Replies: []*ThreadMessage{}}
+		messages[n] = m
+		byValue[m.MessageID] = m
+	}
+
+	// the deliveries of all the messages are fetched at once, and then split by message
+	page, err := checkMessageDelivery(ctx, rawLogsAccessor, conn, "", "", wholeTimeInterval, -1, "",
+		Filters{messageIDs: nodes}, 1, len(nodes)*ResultsPerPage)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	for _, message := range page.Messages {
+		// returned deliveries are grouped with the queue of the message they return
+		if m, ok := byValue[message.MessageID]; ok {
+			m.Messages = append(m.Messages, message)
+		}
+	}
+
+	parentOf := parents(nodes, links)
+
+	thread := &Thread{Roots: []*ThreadMessage{}, Size: len(nodes), Truncated: truncated}
+
+	for _, n := range nodes {
+		parent, ok := parentOf[n]
+		if !ok {
+			thread.Roots = append(thread.Roots, messages[n])
+			continue
+		}
+
+		messages[parent].Replies = append(messages[parent].Replies, messages[n])
+	}
+
+	return thread, nil
+}
@@ -0,0 +1,45 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package detective
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestThreadParents(t *testing.T) {
+	Convey("Thread parents", t, func() {
+		Convey("Replies are linked to all the previous messages", func() {
+			// 1 <- 2 <- 3 <- 4, and 1 <- 5, where 3 and 4 reference all their ancestors
+			links := []replyLink{
+				{1, 2},
+				{1, 3}, {2, 3},
+				{1, 4}, {2, 4}, {3, 4},
+				{1, 5},
+			}
+
+			So(parents([]int64{1, 2, 3, 4, 5}, links), ShouldResemble, map[int64]int64{2: 1, 3: 2, 4: 3, 5: 1})
+		})
+
+		Convey("Missing messages split the conversation", func() {
+			So(parents([]int64{1, 2, 3}, []replyLink{{1, 2}}), ShouldResemble, map[int64]int64{2: 1})
+		})
+
+		Convey("Loops do not hang", func() {
+			p := parents([]int64{1, 2, 3}, []replyLink{{1, 2}, {2, 3}, {3, 1}, {2, 2}})
+
+			// whatever the choice, there must be no loops
+			for n := range p {
+				visited := map[int64]bool{}
+
+				for current, ok := n, true; ok; current, ok = p[current] {
+					So(visited[current], ShouldBeFalse)
+					visited[current] = true
+				}
+			}
+		})
+	})
+}
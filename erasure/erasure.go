@@ -0,0 +1,277 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package erasure
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/dbconn"
+	"gitlab.com/lightmeter/controlcenter/pkg/dbrunner"
+	"gitlab.com/lightmeter/controlcenter/util/emailutil"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+// Here we implement the "right to erasure" of all data about an e-mail address or a whole domain.
+// Each subsystem owning data knows how to remove or anonymise its own content,
+// and this package contains only what they have in common.
+
+// Placeholder replaces the erased content when a row is anonymised instead of deleted
+const Placeholder = "redacted"
+
+var ErrInvalidTarget = errors.New(`Invalid erasure target. Expected an e-mail address or a domain`)
+
+// Target is what should be erased. If LocalPart is empty, it means the whole domain.
+type Target struct {
+	LocalPart string
+	Domain    string
+}
+
+func ParseTarget(s string) (Target, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	local, domain, isPartial, err := emailutil.SplitPartial(s)
+	if err != nil {
+		return Target{}, ErrInvalidTarget
+	}
+
+	// only a domain (example.com) or a full address (user@example.com) is allowed. "@example.com" is not.
+	if isPartial && strings.Contains(s, "@") {
+		return Target{}, ErrInvalidTarget
+	}
+
+	// a bare word is much more likely to be a mistake than a domain
+	if len(domain) == 0 || !strings.Contains(domain, ".") {
+		return Target{}, ErrInvalidTarget
+	}
+
+	return Target{LocalPart: local, Domain: domain}, nil
+}
+
+func (t Target) IsDomain() bool {
+	return len(t.LocalPart) == 0
+}
+
+func (t Target) String() string {
+	if t.IsDomain() {
+		return t.Domain
+	}
+
+	return t.LocalPart + "@" + t.Domain
+}
+
+// Matcher finds the target in a text, as it appears in the logs and
+// other free form content, like the JSON encoded insights.
+type Matcher struct {
+	// re matches the target preceded by a delimiter or the beginning of the text.
+	// Whether it's followed by a delimiter is checked apart, as there are no lookaheads in Go regexps,
+	// and consuming the delimiter would prevent an address right after it from being matched.
+	re *regexp.Regexp
+}
+
+const delimiters = `\s<>"'=,;:()\[\]`
+
+// Matcher builds a case insensitive matcher for target.
+// For a domain, it matches all the addresses in such domain.
+func (t Target) Matcher() Matcher {
+	if t.IsDomain() {
+		return Matcher{re: regexp.MustCompile(fmt.Sprintf(`(?i)(?:^|[%s])([^%s@]*@%s)`, delimiters, delimiters, regexp.QuoteMeta(t.Domain)))}
+	}
+
+	return Matcher{re: regexp.MustCompile(fmt.Sprintf(`(?i)(?:^|[%s])(%s@%s)`, delimiters, regexp.QuoteMeta(t.LocalPart), regexp.QuoteMeta(t.Domain)))}
+}
+
+// endsAddress checks whether the address ends on i, as it would otherwise be part of a longer one,
+// like user@example.com in user@example.com.br
+func endsAddress(s string, i int) bool {
+	if i == len(s) {
+		return true
+	}
+
+	c := s[i]
+
+	isPartOfAddress := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' || c == '.' || c == '-'
+
+	return !isPartOfAddress
+}
+
+// find returns the beginning and end of each occurrence of the target
+func (m Matcher) find(s string) [][2]int {
+	found := [][2]int{}
+
+	for _, match := range m.re.FindAllStringSubmatchIndex(s, -1) {
+		begin, end := match[2], match[3]
+
+		if endsAddress(s, end) {
+			found = append(found, [2]int{begin, end})
+		}
+	}
+
+	return found
+}
+
+func (m Matcher) Match(s string) bool {
+	return len(m.find(s)) > 0
+}
+
+// Anonymise replaces any occurrence of the target in the text by the placeholder
+func (m Matcher) Anonymise(s string) string {
+	found := m.find(s)
+
+	if len(found) == 0 {
+		return s
+	}
+
+	var b strings.Builder
+
+	last := 0
+
+	for _, f := range found {
+		b.WriteString(s[last:f[0]])
+		b.WriteString(Placeholder + "@" + Placeholder)
+		last = f[1]
+	}
+
+	b.WriteString(s[last:])
+
+	return b.String()
+}
+
+// LikePattern is a pattern to be used as a cheap pre-filter in a sql `like` expression,
+// before the more precise match by Matcher() is applied
+func (t Target) LikePattern() string {
+	if t.IsDomain() {
+		return "%@" + t.Domain + "%"
+	}
+
+	return "%" + t.LocalPart + "@" + t.Domain + "%"
+}
+
+// Connection identifies an SMTP connection through which the target has sent messages,
+// used to find data about the target which has no e-mail address, as the connection stats
+type Connection struct {
+	IP net.IP
+
+	// Time is when the client connected
+	Time time.Time
+}
+
+type Action string
+
+const (
+	DeletedAction    Action = "deleted"
+	AnonymisedAction Action = "anonymised"
+)
+
+// Entry describes what happened to the rows of a table
+type Entry struct {
+	Database string `json:"database"`
+	Table    string `json:"table"`
+	Action   Action `json:"action"`
+	Count    int64  `json:"count"`
+}
+
+type Entries []Entry
+
+// Report is the audit report of an erasure
+type Report struct {
+	Target  string    `json:"target"`
+	Time    time.Time `json:"time"`
+	Entries Entries   `json:"entries"`
+}
+
+func (r Report) TotalCount() int64 {
+	total := int64(0)
+
+	for _, e := range r.Entries {
+		total += e.Count
+	}
+
+	return total
+}
+
+// Eraser is implemented by anything able to erase all the data it has about a target
+type Eraser interface {
+	EraseData(context.Context, Target) (Report, error)
+}
+
+// Func performs the erasure in a transaction, returning what has been changed
+type Func func(*sql.Tx) (Entries, error)
+
+// InSavepoint executes f in a savepoint, so that, in case of failure, only the changes done by f are rolled back,
+// keeping the rest of the (possibly long lived) transaction untouched.
+func InSavepoint(tx *sql.Tx, f Func) (entries Entries, err error) {
+	if _, err := tx.Exec(`savepoint erasure`); err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	entries, err = f(tx)
+	if err != nil {
+		if _, rollbackErr := tx.Exec(`rollback to savepoint erasure`); rollbackErr != nil {
+			return nil, errorutil.Wrap(rollbackErr)
+		}
+	}
+
+	if _, releaseErr := tx.Exec(`release savepoint erasure`); releaseErr != nil && err == nil {
+		return nil, errorutil.Wrap(releaseErr)
+	}
+
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return entries, nil
+}
+
+type result struct {
+	entries Entries
+	err     error
+}
+
+// Dispatch executes f on the transaction of a database runner, waiting for it to finish.
+// A failed erasure does not stop the runner, being reported only to the caller.
+func Dispatch(ctx context.Context, actions chan<- dbrunner.Action, f Func) (Entries, error) {
+	resultChan := make(chan result, 1)
+
+	action := func(tx *sql.Tx, _ dbconn.TxPreparedStmts) error {
+		entries, err := InSavepoint(tx, f)
+		resultChan <- result{entries: entries, err: err}
+
+		return nil
+	}
+
+	select {
+	case actions <- action:
+	case <-ctx.Done():
+		return nil, errorutil.Wrap(ctx.Err())
+	}
+
+	select {
+	case r := <-resultChan:
+		if r.err != nil {
+			return nil, errorutil.Wrap(r.err)
+		}
+
+		return r.entries, nil
+	case <-ctx.Done():
+		return nil, errorutil.Wrap(ctx.Err())
+	}
+}
+
+// CountAffected returns the number of rows changed by an Exec() call
+func CountAffected(r sql.Result) (int64, error) {
+	n, err := r.RowsAffected()
+	if err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package erasure
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseTarget(t *testing.T) {
+	Convey("Parse target", t, func() {
+		Convey("Invalid targets", func() {
+			for _, s := range []string{"", "  ", "@example.com", "user@", "user", "a@b@example.com", "user@exa mple.com"} {
+				_, err := ParseTarget(s)
+				So(err, ShouldEqual, ErrInvalidTarget)
+			}
+		})
+
+		Convey("Address", func() {
+			target, err := ParseTarget(" User@Example.COM ")
+			So(err, ShouldBeNil)
+			So(target.IsDomain(), ShouldBeFalse)
+			So(target.String(), ShouldEqual, "user@example.com")
+			So(target.LikePattern(), ShouldEqual, "%user@example.com%")
+		})
+
+		Convey("Domain", func() {
+			target, err := ParseTarget("Example.com")
+			So(err, ShouldBeNil)
+			So(target.IsDomain(), ShouldBeTrue)
+			So(target.String(), ShouldEqual, "example.com")
+			So(target.LikePattern(), ShouldEqual, "%@example.com%")
+		})
+	})
+}
+
+func TestMatcher(t *testing.T) {
+	Convey("Matcher", t, func() {
+		Convey("Address", func() {
+			target, err := ParseTarget("user@example.com")
+			So(err, ShouldBeNil)
+
+			m := target.Matcher()
+
+			So(m.Match(`from=<User@example.com>, size=391`), ShouldBeTrue)
+			So(m.Match(`dovecot: imap(user@example.com)<9755>`), ShouldBeTrue)
+			So(m.Match(`sasl_username=user@example.com`), ShouldBeTrue)
+			So(m.Match(`from=<otheruser@example.com>`), ShouldBeFalse)
+			So(m.Match(`from=<user@example.com.br>`), ShouldBeFalse)
+			So(m.Match(`from=<user@sub.example.com>`), ShouldBeFalse)
+
+			So(m.Anonymise(`from=<user@example.com> to=<other@example.com>, sasl_username=user@example.com`),
+				ShouldEqual, `from=<redacted@redacted> to=<other@example.com>, sasl_username=redacted@redacted`)
+
+			Convey("Adjacent occurrences", func() {
+				So(m.Anonymise(`user@example.com,user@example.com`), ShouldEqual, `redacted@redacted,redacted@redacted`)
+				So(m.Anonymise(`["user@example.com","user@example.com"]`), ShouldEqual, `["redacted@redacted","redacted@redacted"]`)
+				So(m.Anonymise(`user@example.com.br,user@example.com`), ShouldEqual, `user@example.com.br,redacted@redacted`)
+				So(m.Match(`user@example.com.br,user@example.com`), ShouldBeTrue)
+			})
+		})
+
+		Convey("Domain", func() {
+			target, err := ParseTarget("example.com")
+			So(err, ShouldBeNil)
+
+			m := target.Matcher()
+
+			So(m.Match(`to=<someone@example.com>`), ShouldBeTrue)
+			So(m.Match(`to=<someone@example.com.br>`), ShouldBeFalse)
+			So(m.Match(`Message-ID: <abc@mail.example.com>`), ShouldBeFalse)
+
+			So(m.Anonymise(`from=<a@example.com> to=<b@example.com>, orig_to=<c@example.org>`),
+				ShouldEqual, `from=<redacted@redacted> to=<redacted@redacted>, orig_to=<c@example.org>`)
+
+			So(m.Anonymise(`a@example.com,b@example.com;c@example.com.br d@example.com`),
+				ShouldEqual, `redacted@redacted,redacted@redacted;c@example.com.br redacted@redacted`)
+		})
+	})
+}
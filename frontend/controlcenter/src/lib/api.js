@@ -86,6 +86,22 @@ export function submitNotificationsSettingsForm(data) {
     .catch(builderErrorHandler("settings"));
 }
 
+export function testNotificationsSettings(subsection, data) {
+  let formData = getFormData(data);
+  formData.append("action", "test");
+  formData.append("subsection", subsection);
+
+  return axios
+    .post(
+      BASE_URL + "settings?setting=notification",
+      new URLSearchParams(formData)
+    )
+    .then(function() {
+      newAlertSuccess(Vue.prototype.$gettext("Test notification sent"));
+    })
+    .catch(builderErrorHandler("settings_" + subsection + "_test"));
+}
+
 export function submitDetectiveSettingsForm(data, enabled) {
   let detectiveSettingsFormData = getFormData(data);
 
@@ -415,6 +431,12 @@ export function escalateMessage(
   return post;
 }
 
+export function fetchMessageThread(some_id) {
+  let get = getAPI("messageThread?some_id=" + encodeURIComponent(some_id));
+  get.catch(builderErrorHandler("detective_thread"));
+  return get;
+}
+
 export function oldestAvailableTimeForMessageDetective() {
   return getAPI("oldestAvailableTimeForMessageDetective");
 }
@@ -450,6 +472,64 @@ export function archiveInsight(id) {
     .catch(builderErrorHandler("insight_archive"));
 }
 
+/**** Insight suppressions ****/
+
+export function snoozeInsight(id, until) {
+  let formData = new FormData();
+  formData.append("id", id);
+  formData.append("until", until.toISOString());
+
+  return axios
+    .post(BASE_URL + "api/v0/snoozeInsight", new URLSearchParams(formData))
+    .catch(builderErrorHandler("insight_snooze"));
+}
+
+export function acknowledgeInsight(id) {
+  let formData = new FormData();
+  formData.append("id", id);
+
+  return axios
+    .post(BASE_URL + "api/v0/acknowledgeInsight", new URLSearchParams(formData))
+    .catch(builderErrorHandler("insight_acknowledge"));
+}
+
+export function muteInsights(contentType) {
+  let formData = new FormData();
+  formData.append("content_type", contentType);
+
+  return axios
+    .post(BASE_URL + "api/v0/muteInsights", new URLSearchParams(formData))
+    .catch(builderErrorHandler("insight_mute"));
+}
+
+/**** RBL listing history ****/
+
+export function getRBLListingHistory() {
+  return axios
+    .get(BASE_URL + "api/v0/rblListingHistory")
+    .catch(builderErrorHandler("rbl_listing_history"));
+}
+
+export function requestRBLDelisting(id, remindAfterHours, notes) {
+  let formData = new FormData();
+  formData.append("id", id);
+  formData.append("remind_after", remindAfterHours);
+  formData.append("notes", notes);
+
+  return axios
+    .post(BASE_URL + "api/v0/requestRBLDelisting", new URLSearchParams(formData))
+    .catch(builderErrorHandler("rbl_delisting_request"));
+}
+
+export function cancelRBLDelisting(id) {
+  let formData = new FormData();
+  formData.append("id", id);
+
+  return axios
+    .post(BASE_URL + "api/v0/cancelRBLDelisting", new URLSearchParams(formData))
+    .catch(builderErrorHandler("rbl_delisting_cancel"));
+}
+
 /**** Network Intelligence ****/
 
 export function getLatestSignals() {
@@ -5,6 +5,8 @@
 package httpsettings
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/imdario/mergo"
@@ -16,6 +18,7 @@ import (
 	"gitlab.com/lightmeter/controlcenter/notification"
 	"gitlab.com/lightmeter/controlcenter/notification/email"
 	"gitlab.com/lightmeter/controlcenter/notification/slack"
+	"gitlab.com/lightmeter/controlcenter/notification/webhook"
 	"gitlab.com/lightmeter/controlcenter/pkg/httperror"
 	"gitlab.com/lightmeter/controlcenter/po"
 	"gitlab.com/lightmeter/controlcenter/settings"
@@ -31,8 +34,15 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"unicode"
 )
 
+// DiscoveredIPsGetter returns the IPs used to send messages found in the logs
+type DiscoveredIPsGetter interface {
+	DiscoveredIPAddresses(context.Context) []net.IP
+}
+
 type Settings struct {
 	writer *metadata.AsyncWriter
 	reader metadata.Reader
@@ -40,6 +50,7 @@ type Settings struct {
 	initialSetupSettings *settings.InitialSetupSettings
 	notificationCenter   *notification.Center
 	insightsEngine       *insights.Engine
+	discoveredIPs        DiscoveredIPsGetter
 	handlers             map[string]func(http.ResponseWriter, *http.Request) error
 }
 
@@ -49,6 +60,7 @@ func NewSettings(
 	initialSetupSettings *settings.InitialSetupSettings,
 	notificationCenter *notification.Center,
 	insightsEngine *insights.Engine,
+	discoveredIPs DiscoveredIPsGetter,
 ) *Settings {
 	s := &Settings{
 		writer:               writer,
@@ -56,14 +68,16 @@ func NewSettings(
 		initialSetupSettings: initialSetupSettings,
 		notificationCenter:   notificationCenter,
 		insightsEngine:       insightsEngine,
+		discoveredIPs:        discoveredIPs,
 	}
 	s.handlers = map[string]func(http.ResponseWriter, *http.Request) error{
-		"initSetup":    s.InitialSetupHandler,
-		"notification": s.NotificationSettingsHandler,
-		"general":      s.GeneralSettingsHandler,
-		"walkthrough":  s.WalkthroughHandler,
-		"detective":    s.DetectiveHandler,
-		"insights":     s.InsightsHandler,
+		"initSetup":     s.InitialSetupHandler,
+		"notification":  s.NotificationSettingsHandler,
+		"general":       s.GeneralSettingsHandler,
+		"walkthrough":   s.WalkthroughHandler,
+		"detective":     s.DetectiveHandler,
+		"insights":      s.InsightsHandler,
+		"insightsRules": s.InsightsCustomRulesHandler,
 	}
 
 	return s
@@ -131,14 +145,16 @@ func (h *Settings) SettingsHandler(w http.ResponseWriter, r *http.Request) error
 	// TODO: this structure should somehow be dynamic and easily extensible for future new settings we add,
 	// also supporting optional settings
 	allCurrentSettings := struct {
-		SlackNotification slack.Settings            `json:"slack_notifications"`
-		EmailNotification email.Settings            `json:"email_notifications"`
-		Notification      notification.Settings     `json:"notifications"`
-		General           globalsettings.Settings   `json:"general"`
-		Walkthrough       walkthrough.Settings      `json:"walkthrough"`
-		Detective         detective.Settings        `json:"detective"`
-		Insights          insightsSettings.Settings `json:"insights"`
-		FeatureFlags      featureflags.Settings     `json:"feature_flags"`
+		SlackNotification   slack.Settings            `json:"slack_notifications"`
+		EmailNotification   email.Settings            `json:"email_notifications"`
+		WebhookNotification webhook.Settings          `json:"webhook_notifications"`
+		Notification        notification.Settings     `json:"notifications"`
+		General             globalsettings.Settings   `json:"general"`
+		Walkthrough         walkthrough.Settings      `json:"walkthrough"`
+		Detective           detective.Settings        `json:"detective"`
+		Insights            insightsSettings.Settings `json:"insights"`
+		FeatureFlags        featureflags.Settings     `json:"feature_flags"`
+		DiscoveredIPs       []string                  `json:"discovered_ips"`
 	}{}
 
 	ctx := r.Context()
@@ -153,6 +169,11 @@ func (h *Settings) SettingsHandler(w http.ResponseWriter, r *http.Request) error
 		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
 	}
 
+	webhookSettings, err := webhook.GetSettings(ctx, h.reader)
+	if err != nil && !errors.Is(err, metadata.ErrNoSuchKey) {
+		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+	}
+
 	notificationSettings, err := notification.GetSettings(ctx, h.reader)
 	if err != nil && !errors.Is(err, metadata.ErrNoSuchKey) {
 		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
@@ -198,6 +219,12 @@ func (h *Settings) SettingsHandler(w http.ResponseWriter, r *http.Request) error
 		allCurrentSettings.EmailNotification = *emailSettings
 	}
 
+	if webhookSettings != nil {
+		webhookSettings.Secret = nil
+		webhookSettings.Headers = redactedWebhookHeaders(webhookSettings.Headers)
+		allCurrentSettings.WebhookNotification = *webhookSettings
+	}
+
 	if globalSettings != nil {
 		allCurrentSettings.General = *globalSettings
 	}
@@ -218,6 +245,13 @@ func (h *Settings) SettingsHandler(w http.ResponseWriter, r *http.Request) error
 		allCurrentSettings.FeatureFlags = *featureFlagsSettings
 	}
 
+	// read only, so the user can see which addresses are checked besides the ones in the settings
+	allCurrentSettings.DiscoveredIPs = []string{}
+
+	for _, ip := range h.discoveredIPs.DiscoveredIPAddresses(ctx) {
+		allCurrentSettings.DiscoveredIPs = append(allCurrentSettings.DiscoveredIPs, ip.String())
+	}
+
 	return httputil.WriteJson(w, &allCurrentSettings, http.StatusOK)
 }
 
@@ -258,7 +292,10 @@ func (h *Settings) GeneralSettingsHandler(w http.ResponseWriter, r *http.Request
 	localIPRaw := r.Form.Get("postfix_public_ip")
 	appLanguage := r.Form.Get("app_language")
 
-	if appLanguage == "" && localIPRaw == "" {
+	// an empty list of IPs is a valid value, clearing the current ones
+	_, hasLocalIPs := r.Form["postfix_public_ips"]
+
+	if appLanguage == "" && localIPRaw == "" && !hasLocalIPs {
 		err := errorutil.Wrap(errors.New("values are missing"))
 		return httperror.NewHTTPStatusCodeError(http.StatusBadRequest, err)
 	}
@@ -270,6 +307,11 @@ func (h *Settings) GeneralSettingsHandler(w http.ResponseWriter, r *http.Request
 		}
 	}
 
+	localIPs, err := parseIPList(r.Form.Get("postfix_public_ips"))
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusBadRequest, errorutil.Wrap(err))
+	}
+
 	if appLanguage != "" && !po.IsLanguageSupported(appLanguage) {
 		err := errorutil.Wrap(fmt.Errorf("Error app language option is bad %v", appLanguage))
 		return httperror.NewHTTPStatusCodeError(http.StatusBadRequest, err)
@@ -297,6 +339,10 @@ func (h *Settings) GeneralSettingsHandler(w http.ResponseWriter, r *http.Request
 		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err, "Error handling settings"))
 	}
 
+	if hasLocalIPs {
+		s.LocalIPs = localIPs
+	}
+
 	if err := globalsettings.SetSettings(r.Context(), h.writer, s); err != nil {
 		if errors.Is(err, globalsettings.ErrPublicURLInvalid) || errors.Is(err, globalsettings.ErrPublicURLNoDNS) {
 			return httperror.NewHTTPStatusCodeError(http.StatusUnprocessableEntity, errorutil.Wrap(err))
@@ -502,6 +548,103 @@ func buildSlackSettingsFromForm(form url.Values) (slack.Settings, bool, error) {
 	}, true, nil
 }
 
+// parseWebhookHeaders parses one header per line, as "Name: value"
+func parseWebhookHeaders(s string) (map[string]string, error) {
+	headers := map[string]string{}
+
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+
+		if len(line) == 0 {
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("Invalid webhook header line: %v", line)
+		}
+
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	return headers, nil
+}
+
+func buildWebhookSettingsFromForm(form url.Values) (webhook.Settings, bool, error) {
+	if _, ok := form["webhook_urls"]; !ok {
+		return webhook.Settings{}, false, nil
+	}
+
+	enabled, err := strconv.ParseBool(form.Get("webhook_enabled"))
+	if err != nil {
+		return webhook.Settings{}, false, errorutil.Wrap(err)
+	}
+
+	headers, err := parseWebhookHeaders(form.Get("webhook_headers"))
+	if err != nil {
+		return webhook.Settings{}, false, errorutil.Wrap(err)
+	}
+
+	settings := webhook.Settings{
+		Enabled: enabled,
+		URLs:    strings.Fields(form.Get("webhook_urls")),
+		Headers: headers,
+	}
+
+	if secret := form.Get("webhook_secret"); len(secret) > 0 {
+		settings.Secret = stringutil.MakeSensitive(secret)
+	}
+
+	if err := webhook.CheckSettings(settings); err != nil {
+		return webhook.Settings{}, false, errorutil.Wrap(err)
+	}
+
+	return settings, true, nil
+}
+
+// redactedWebhookHeaderValue replaces the values of the headers sent back to the user,
+// as they might be used for authentication
+const redactedWebhookHeaderValue = "<redacted>"
+
+func redactedWebhookHeaders(headers map[string]string) map[string]string {
+	redacted := make(map[string]string, len(headers))
+
+	for name := range headers {
+		redacted[name] = redactedWebhookHeaderValue
+	}
+
+	return redacted
+}
+
+// keepWebhookSecrets uses the current secret if no new one has been set,
+// and the current values of the headers sent redacted or empty, as they are never sent back to the user
+func (h *Settings) keepWebhookSecrets(r *http.Request, settings *webhook.Settings) error {
+	current, err := webhook.GetSettings(r.Context(), h.reader)
+	if err != nil && errors.Is(err, metadata.ErrNoSuchKey) {
+		return nil
+	}
+
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if settings.Secret == nil {
+		settings.Secret = current.Secret
+	}
+
+	for name, value := range settings.Headers {
+		if value != redactedWebhookHeaderValue && len(value) > 0 {
+			continue
+		}
+
+		if currentValue, ok := current.Headers[name]; ok {
+			settings.Headers[name] = currentValue
+		}
+	}
+
+	return nil
+}
+
 func buildNotificationSettingsFromForm(form url.Values) (notification.Settings, bool, error) {
 	language := form.Get("notification_language")
 
@@ -528,6 +671,10 @@ func (h *Settings) NotificationSettingsHandler(w http.ResponseWriter, r *http.Re
 		return h.NotificationSettingsClear(w, r)
 	}
 
+	if r.Form.Get("action") == "test" {
+		return h.NotificationSettingsTest(w, r)
+	}
+
 	slackSettings, shouldSetSlack, err := buildSlackSettingsFromForm(r.Form)
 	if err != nil {
 		return httperror.NewHTTPStatusCodeError(http.StatusBadRequest, errorutil.Wrap(err))
@@ -538,6 +685,11 @@ func (h *Settings) NotificationSettingsHandler(w http.ResponseWriter, r *http.Re
 		return httperror.NewHTTPStatusCodeError(http.StatusBadRequest, errorutil.Wrap(err))
 	}
 
+	webhookSettings, shouldSetWebhook, err := buildWebhookSettingsFromForm(r.Form)
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusBadRequest, errorutil.Wrap(err))
+	}
+
 	notificationSettings, shouldSetNotification, err := buildNotificationSettingsFromForm(r.Form)
 	if err != nil {
 		return httperror.NewHTTPStatusCodeError(http.StatusBadRequest, errorutil.Wrap(err))
@@ -575,6 +727,12 @@ func (h *Settings) NotificationSettingsHandler(w http.ResponseWriter, r *http.Re
 		}
 	}
 
+	if shouldSetWebhook {
+		if err := h.setWebhookSettings(r, webhookSettings); err != nil {
+			return err
+		}
+	}
+
 	if shouldSetNotification {
 		if err := notification.SetSettings(r.Context(), h.writer, notificationSettings); err != nil {
 			return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
@@ -584,6 +742,61 @@ func (h *Settings) NotificationSettingsHandler(w http.ResponseWriter, r *http.Re
 	return nil
 }
 
+func (h *Settings) setWebhookSettings(r *http.Request, settings webhook.Settings) error {
+	if err := h.keepWebhookSecrets(r, &settings); err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+	}
+
+	// when enabling, ensure the endpoints can be reached
+	if settings.Enabled {
+		webhookNotifier, err := h.notificationCenter.Notifier(webhook.SettingsKey)
+		if err != nil {
+			return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+		}
+
+		if err := webhookNotifier.ValidateSettings(settings); err != nil {
+			return httperror.NewHTTPStatusCodeError(http.StatusBadRequest, errorutil.Wrap(err))
+		}
+	}
+
+	if err := webhook.SetSettings(r.Context(), h.writer, settings); err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+	}
+
+	return nil
+}
+
+// NotificationSettingsTest sends a test notification using the settings in the form, without storing them
+func (h *Settings) NotificationSettingsTest(w http.ResponseWriter, r *http.Request) error {
+	if r.Form.Get("subsection") != "webhook" {
+		return httperror.NewHTTPStatusCodeError(http.StatusBadRequest, errors.New("Unknown notification settings subsection"))
+	}
+
+	settings, ok, err := buildWebhookSettingsFromForm(r.Form)
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusBadRequest, errorutil.Wrap(err))
+	}
+
+	if !ok {
+		return httperror.NewHTTPStatusCodeError(http.StatusBadRequest, errors.New("Webhook settings are missing"))
+	}
+
+	if err := h.keepWebhookSecrets(r, &settings); err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+	}
+
+	webhookNotifier, err := h.notificationCenter.Notifier(webhook.SettingsKey)
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+	}
+
+	if err := webhookNotifier.ValidateSettings(settings); err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusBadRequest, errorutil.Wrap(err))
+	}
+
+	return nil
+}
+
 func (h *Settings) NotificationSettingsClear(w http.ResponseWriter, r *http.Request) error {
 	switch r.Form.Get("subsection") {
 	case "email":
@@ -598,6 +811,12 @@ func (h *Settings) NotificationSettingsClear(w http.ResponseWriter, r *http.Requ
 			return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, err)
 		}
 
+		return nil
+	case "webhook":
+		if err := webhook.SetSettings(r.Context(), h.writer, webhook.Settings{}); err != nil {
+			return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+		}
+
 		return nil
 	}
 
@@ -645,7 +864,11 @@ func (h *Settings) InsightsHandler(w http.ResponseWriter, r *http.Request) error
 		return httperror.NewHTTPStatusCodeError(http.StatusBadRequest, errorutil.Wrap(err))
 	}
 
-	settings := &insightsSettings.Settings{}
+	// the custom rules are set by their own handler, and must be kept
+	settings, err := insightsSettings.GetSettings(r.Context(), h.reader)
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+	}
 
 	if err := setBounceRateThreshold(r, settings); err != nil {
 		return err
@@ -655,6 +878,49 @@ func (h *Settings) InsightsHandler(w http.ResponseWriter, r *http.Request) error
 		return err
 	}
 
+	if err := setDomainBlocklistCheckInterval(r, settings); err != nil {
+		return err
+	}
+
+	if err := setStuckQueuesAges(r, settings); err != nil {
+		return err
+	}
+
+	if err := h.writer.StoreJsonSync(r.Context(), insightsSettings.SettingsKey, settings); err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+	}
+
+	if err := h.insightsEngine.UpdateCoreDetectorsFromSettings(); err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+	}
+
+	return nil
+}
+
+// InsightsCustomRulesHandler replaces all the custom insight rules by the ones
+// in the form value "rules", a JSON array
+func (h *Settings) InsightsCustomRulesHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := handleForm(w, r); err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusBadRequest, errorutil.Wrap(err))
+	}
+
+	rules := []insightsSettings.CustomRule{}
+
+	if err := json.Unmarshal([]byte(r.Form.Get("rules")), &rules); err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusBadRequest, errorutil.Wrap(err))
+	}
+
+	if err := insightsSettings.ValidateCustomRules(rules); err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusBadRequest, errorutil.Wrap(err))
+	}
+
+	settings, err := insightsSettings.GetSettings(r.Context(), h.reader)
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
+	}
+
+	settings.CustomRules = rules
+
 	if err := h.writer.StoreJsonSync(r.Context(), insightsSettings.SettingsKey, settings); err != nil {
 		return httperror.NewHTTPStatusCodeError(http.StatusInternalServerError, errorutil.Wrap(err))
 	}
@@ -705,3 +971,82 @@ func setMailInactivity(r *http.Request, settings *insightsSettings.Settings) htt
 
 	return nil
 }
+
+// parseIPList parses a list of IP addresses separated by commas or spaces
+func parseIPList(raw string) ([]globalsettings.IP, error) {
+	ips := []globalsettings.IP{}
+
+	for _, v := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return nil, fmt.Errorf("Invalid IP address: %v", v)
+		}
+
+		ips = append(ips, globalsettings.IP{IP: ip})
+	}
+
+	return ips, nil
+}
+
+// the check interval is optional, and kept unchanged when not in the form
+func setDomainBlocklistCheckInterval(r *http.Request, settings *insightsSettings.Settings) httperror.XHTTPError {
+	raw := r.Form.Get("domain_blocklist_check_interval")
+	if len(raw) == 0 {
+		return nil
+	}
+
+	interval, err := strconv.Atoi(raw)
+	if err != nil {
+		return httperror.NewHTTPStatusCodeError(http.StatusBadRequest, errors.New("Badly formatted number"))
+	}
+
+	if interval < 1 || interval > 168 {
+		return httperror.NewHTTPStatusCodeError(http.StatusBadRequest, errorutil.Wrap(errors.New("Invalid time value out of [1-168] interval")))
+	}
+
+	settings.DomainBlocklistCheckInterval = interval
+
+	return nil
+}
+
+// the ages are optional, and kept unchanged when not in the form
+func setStuckQueuesAges(r *http.Request, settings *insightsSettings.Settings) httperror.XHTTPError {
+	minAge := settings.StuckQueuesMinAge
+	maximalQueueLifetime := settings.StuckQueuesMaximalQueueLifetime
+
+	if raw := r.Form.Get("stuck_queues_min_age"); len(raw) > 0 {
+		v, err := strconv.Atoi(raw)
+		if err != nil {
+			return httperror.NewHTTPStatusCodeError(http.StatusBadRequest, errors.New("Badly formatted number"))
+		}
+
+		if v < 1 || v > 999 {
+			return httperror.NewHTTPStatusCodeError(http.StatusBadRequest, errorutil.Wrap(errors.New("Invalid time value out of [1-999] interval")))
+		}
+
+		minAge = v
+	}
+
+	if raw := r.Form.Get("stuck_queues_maximal_queue_lifetime"); len(raw) > 0 {
+		v, err := strconv.Atoi(raw)
+		if err != nil {
+			return httperror.NewHTTPStatusCodeError(http.StatusBadRequest, errors.New("Badly formatted number"))
+		}
+
+		if v < 1 || v > 100 {
+			return httperror.NewHTTPStatusCodeError(http.StatusBadRequest, errorutil.Wrap(errors.New("Invalid time value out of [1-100] interval")))
+		}
+
+		maximalQueueLifetime = v
+	}
+
+	// messages expire before they could be reported
+	if minAge > 0 && maximalQueueLifetime > 0 && minAge >= maximalQueueLifetime*24 {
+		return httperror.NewHTTPStatusCodeError(http.StatusBadRequest, errorutil.Wrap(errors.New("Messages must be reported before they expire")))
+	}
+
+	settings.StuckQueuesMinAge = minAge
+	settings.StuckQueuesMaximalQueueLifetime = maximalQueueLifetime
+
+	return nil
+}
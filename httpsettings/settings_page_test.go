@@ -106,6 +106,11 @@ func TestSettingsPage(t *testing.T) {
 				"walkthrough": map[string]interface{}{
 					"completed": false,
 				},
+				"webhook_notifications": map[string]interface{}{
+					"enabled": false,
+					"urls":    nil,
+					"headers": nil,
+				},
 				"feature_flags": map[string]interface{}{
 					"disable_v1_dashboard":  false,
 					"enable_v2_dashboard":   false,
@@ -113,6 +118,7 @@ func TestSettingsPage(t *testing.T) {
 					"disable_raw_logs":      false,
 					"enable_simple_view":    false,
 				},
+				"discovered_ips": []interface{}{"11.22.33.55", "2001:db8::1"},
 			}
 
 			So(body, ShouldResemble, expected)
@@ -197,6 +203,11 @@ func TestSettingsPage(t *testing.T) {
 				"walkthrough": map[string]interface{}{
 					"completed": false,
 				},
+				"webhook_notifications": map[string]interface{}{
+					"enabled": false,
+					"urls":    nil,
+					"headers": nil,
+				},
 				"feature_flags": map[string]interface{}{
 					"disable_v1_dashboard":  false,
 					"enable_v2_dashboard":   false,
@@ -204,6 +215,7 @@ func TestSettingsPage(t *testing.T) {
 					"disable_raw_logs":      false,
 					"enable_simple_view":    false,
 				},
+				"discovered_ips": []interface{}{"11.22.33.55", "2001:db8::1"},
 			}
 
 			So(body, ShouldResemble, expected)
@@ -264,6 +276,11 @@ func TestSettingsPage(t *testing.T) {
 				"walkthrough": map[string]interface{}{
 					"completed": false,
 				},
+				"webhook_notifications": map[string]interface{}{
+					"enabled": false,
+					"urls":    nil,
+					"headers": nil,
+				},
 				"feature_flags": map[string]interface{}{
 					"disable_v1_dashboard":  false,
 					"enable_v2_dashboard":   false,
@@ -271,6 +288,7 @@ func TestSettingsPage(t *testing.T) {
 					"disable_raw_logs":      false,
 					"enable_simple_view":    false,
 				},
+				"discovered_ips": []interface{}{"11.22.33.55", "2001:db8::1"},
 			}
 
 			So(body, ShouldResemble, expected)
@@ -378,6 +396,11 @@ func TestSettingsPage(t *testing.T) {
 					"walkthrough": map[string]interface{}{
 						"completed": false,
 					},
+					"webhook_notifications": map[string]interface{}{
+						"enabled": false,
+						"urls":    nil,
+						"headers": nil,
+					},
 					"feature_flags": map[string]interface{}{
 						"disable_v1_dashboard":  false,
 						"enable_v2_dashboard":   false,
@@ -385,6 +408,7 @@ func TestSettingsPage(t *testing.T) {
 						"disable_raw_logs":      false,
 						"enable_simple_view":    false,
 					},
+					"discovered_ips": []interface{}{"11.22.33.55", "2001:db8::1"},
 				}
 
 				So(body, ShouldResemble, expected)
@@ -6,6 +6,7 @@ package httpsettings
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"net"
 	"net/http"
@@ -25,6 +26,7 @@ import (
 	"gitlab.com/lightmeter/controlcenter/i18n/translator"
 	"gitlab.com/lightmeter/controlcenter/insights"
 	"gitlab.com/lightmeter/controlcenter/insights/core"
+	"gitlab.com/lightmeter/controlcenter/insights/customrules"
 	"gitlab.com/lightmeter/controlcenter/insights/highrate"
 	"gitlab.com/lightmeter/controlcenter/insights/mailinactivity"
 	"gitlab.com/lightmeter/controlcenter/lmsqlite3"
@@ -34,6 +36,7 @@ import (
 	notificationCore "gitlab.com/lightmeter/controlcenter/notification/core"
 	"gitlab.com/lightmeter/controlcenter/notification/email"
 	"gitlab.com/lightmeter/controlcenter/notification/slack"
+	"gitlab.com/lightmeter/controlcenter/notification/webhook"
 	"gitlab.com/lightmeter/controlcenter/pkg/runner"
 	"gitlab.com/lightmeter/controlcenter/settings"
 	"gitlab.com/lightmeter/controlcenter/settings/globalsettings"
@@ -61,6 +64,12 @@ func (f *fakeFetcher) FetchInsights(c context.Context, o core.FetchOptions, t ti
 	return nil, nil
 }
 
+type fakeDiscoveredIPs struct{}
+
+func (fakeDiscoveredIPs) DiscoveredIPAddresses(context.Context) []net.IP {
+	return []net.IP{net.ParseIP("11.22.33.55"), net.ParseIP("2001:db8::1")}
+}
+
 type fakeNotifier struct {
 }
 
@@ -119,10 +128,13 @@ func buildTestSetup(t *testing.T) (*Settings, *metadata.AsyncWriter, metadata.Re
 
 	emailNotifier := email.New(notification.PassPolicy, m.Reader)
 
+	webhookNotifier := webhook.New(notification.PassPolicy, m.Reader)
+
 	notifiers := map[string]notification.Notifier{
-		slack.SettingsKey: slackNotifier,
-		email.SettingsKey: emailNotifier,
-		"fake":            fakeNotifier,
+		slack.SettingsKey:   slackNotifier,
+		email.SettingsKey:   emailNotifier,
+		webhook.SettingsKey: webhookNotifier,
+		"fake":              fakeNotifier,
 	}
 
 	center := notification.New(m.Reader, translator.New(catalog.NewBuilder()), notification.PassPolicy, notifiers)
@@ -147,7 +159,7 @@ func buildTestSetup(t *testing.T) (*Settings, *metadata.AsyncWriter, metadata.Re
 	)
 	So(err, ShouldBeNil)
 
-	setup := NewSettings(writer, m.Reader, initialSetupSettings, center, insightsEngine)
+	setup := NewSettings(writer, m.Reader, initialSetupSettings, center, insightsEngine, fakeDiscoveredIPs{})
 
 	return setup, writer, m.Reader, center, fakeSlackPoster, insightsEngine, func() {
 		cancel()
@@ -293,6 +305,68 @@ func TestAppSettings(t *testing.T) {
 			So(settings.AppLanguage, ShouldEqual, "de")
 			So(settings.LocalIP.String(), ShouldEqual, "127.0.0.1")
 		})
+
+		Convey("Set many sending IPs", func() {
+			writer.StoreJson(globalsettings.SettingsKey, &globalsettings.Settings{
+				LocalIP:     globalsettings.IP{net.ParseIP(`127.0.0.1`)},
+				AppLanguage: "en",
+			}).Wait()
+
+			Convey("Invalid IP in the list", func() {
+				r, err := c.PostForm(s.URL+"?setting=general", url.Values{
+					"postfix_public_ips": {"127.0.0.2, 127.0.0.X"},
+				})
+
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusBadRequest)
+			})
+
+			r, err := c.PostForm(s.URL+"?setting=general", url.Values{
+				"postfix_public_ips": {"127.0.0.2, 2001:db8::1\n127.0.0.3"},
+			})
+
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+			settings := globalsettings.Settings{}
+			err = reader.RetrieveJson(context.Background(), globalsettings.SettingsKey, &settings)
+			So(err, ShouldBeNil)
+
+			So(settings.AppLanguage, ShouldEqual, "en")
+			So(settings.LocalIPAddresses(), ShouldResemble, []net.IP{
+				net.ParseIP("127.0.0.1"), net.ParseIP("127.0.0.2"), net.ParseIP("2001:db8::1"), net.ParseIP("127.0.0.3"),
+			})
+
+			Convey("Updating the language keeps the IPs", func() {
+				r, err := c.PostForm(s.URL+"?setting=general", url.Values{
+					"app_language": {"de"},
+				})
+
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+				settings := globalsettings.Settings{}
+				err = reader.RetrieveJson(context.Background(), globalsettings.SettingsKey, &settings)
+				So(err, ShouldBeNil)
+
+				So(len(settings.LocalIPs), ShouldEqual, 3)
+			})
+
+			Convey("An empty list clears the IPs", func() {
+				r, err := c.PostForm(s.URL+"?setting=general", url.Values{
+					"postfix_public_ips": {""},
+				})
+
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+				settings := globalsettings.Settings{}
+				err = reader.RetrieveJson(context.Background(), globalsettings.SettingsKey, &settings)
+				So(err, ShouldBeNil)
+
+				So(settings.LocalIPAddresses(), ShouldResemble, []net.IP{net.ParseIP("127.0.0.1")})
+			})
+		})
 	})
 
 	Convey("Clear general settings", t, func() {
@@ -538,6 +612,174 @@ func TestSlackNotifications(t *testing.T) {
 	})
 }
 
+func TestWebhookNotifications(t *testing.T) {
+	Convey("Webhook notifications", t, func() {
+		setup, _, reader, _, _, _, clear := buildTestSetup(t)
+		defer clear()
+
+		chain := httpmiddleware.New()
+		handler := chain.WithEndpoint(httpmiddleware.CustomHTTPHandler(setup.SettingsForward))
+
+		s := httptest.NewServer(handler)
+		defer s.Close()
+
+		settingsURL := s.URL + "?setting=notification"
+
+		received := []http.Header{}
+		receiverStatus := http.StatusOK
+
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = append(received, r.Header)
+			w.WriteHeader(receiverStatus)
+		}))
+
+		defer receiver.Close()
+
+		post := func(values url.Values) int {
+			r, err := http.PostForm(settingsURL, values)
+			So(err, ShouldBeNil)
+			return r.StatusCode
+		}
+
+		Convey("Enable webhook, sending a test notification", func() {
+			So(post(url.Values{
+				"webhook_enabled": {"true"},
+				"webhook_urls":    {receiver.URL + "/hook1\n" + receiver.URL + "/hook2"},
+				"webhook_secret":  {"super_secret"},
+				"webhook_headers": {"Authorization: Bearer some_token\n\nX-Team: mailops"},
+			}), ShouldEqual, http.StatusOK)
+
+			So(len(received), ShouldEqual, 2)
+			So(received[0].Get("Authorization"), ShouldEqual, "Bearer some_token")
+			So(received[0].Get("X-Team"), ShouldEqual, "mailops")
+			So(received[0].Get(webhook.SignatureHeader), ShouldNotBeEmpty)
+
+			settings, err := webhook.GetSettings(dummyContext, reader)
+			So(err, ShouldBeNil)
+			So(settings.Enabled, ShouldBeTrue)
+			So(settings.URLs, ShouldResemble, []string{receiver.URL + "/hook1", receiver.URL + "/hook2"})
+			So(*settings.Secret, ShouldEqual, "super_secret")
+			So(settings.Headers, ShouldResemble, map[string]string{"Authorization": "Bearer some_token", "X-Team": "mailops"})
+
+			Convey("The secret is kept if not set again", func() {
+				So(post(url.Values{
+					"webhook_enabled": {"false"},
+					"webhook_urls":    {receiver.URL + "/hook1"},
+				}), ShouldEqual, http.StatusOK)
+
+				// not tested when disabled
+				So(len(received), ShouldEqual, 2)
+
+				settings, err := webhook.GetSettings(dummyContext, reader)
+				So(err, ShouldBeNil)
+				So(settings.Enabled, ShouldBeFalse)
+				So(*settings.Secret, ShouldEqual, "super_secret")
+			})
+
+			Convey("Test without saving, using the stored secret", func() {
+				So(post(url.Values{
+					"action":          {"test"},
+					"subsection":      {"webhook"},
+					"webhook_enabled": {"false"},
+					"webhook_urls":    {receiver.URL + "/hook3"},
+				}), ShouldEqual, http.StatusOK)
+
+				So(len(received), ShouldEqual, 3)
+				So(received[2].Get(webhook.SignatureHeader), ShouldNotBeEmpty)
+
+				settings, err := webhook.GetSettings(dummyContext, reader)
+				So(err, ShouldBeNil)
+				So(len(settings.URLs), ShouldEqual, 2)
+			})
+
+			Convey("The secret is not exposed", func() {
+				r, err := http.Get(s.URL)
+				So(err, ShouldBeNil)
+
+				defer r.Body.Close()
+
+				body := struct {
+					Webhook map[string]interface{} `json:"webhook_notifications"`
+				}{}
+
+				So(json.NewDecoder(r.Body).Decode(&body), ShouldBeNil)
+				So(body.Webhook["enabled"], ShouldBeTrue)
+				So(body.Webhook, ShouldNotContainKey, "secret")
+
+				// only the names of the headers, as the values might be used for authentication
+				So(body.Webhook["headers"], ShouldResemble, map[string]interface{}{"Authorization": "<redacted>", "X-Team": "<redacted>"})
+			})
+
+			Convey("The header values are kept if sent redacted or empty", func() {
+				So(post(url.Values{
+					"webhook_enabled": {"true"},
+					"webhook_urls":    {receiver.URL + "/hook1"},
+					"webhook_headers": {"Authorization: <redacted>\nX-Team:\nX-New: new_value"},
+				}), ShouldEqual, http.StatusOK)
+
+				// the test notification uses the kept values
+				So(len(received), ShouldEqual, 3)
+				So(received[2].Get("Authorization"), ShouldEqual, "Bearer some_token")
+				So(received[2].Get("X-Team"), ShouldEqual, "mailops")
+
+				settings, err := webhook.GetSettings(dummyContext, reader)
+				So(err, ShouldBeNil)
+				So(settings.Headers, ShouldResemble, map[string]string{"Authorization": "Bearer some_token", "X-Team": "mailops", "X-New": "new_value"})
+
+				Convey("Headers not sent are removed", func() {
+					So(post(url.Values{
+						"webhook_enabled": {"false"},
+						"webhook_urls":    {receiver.URL + "/hook1"},
+						"webhook_headers": {"X-Team: <redacted>"},
+					}), ShouldEqual, http.StatusOK)
+
+					settings, err := webhook.GetSettings(dummyContext, reader)
+					So(err, ShouldBeNil)
+					So(settings.Headers, ShouldResemble, map[string]string{"X-Team": "mailops"})
+				})
+			})
+
+			Convey("Reset", func() {
+				So(post(url.Values{"action": {"clear"}, "subsection": {"webhook"}}), ShouldEqual, http.StatusOK)
+
+				settings, err := webhook.GetSettings(dummyContext, reader)
+				So(err, ShouldBeNil)
+				So(settings.Enabled, ShouldBeFalse)
+				So(settings.URLs, ShouldBeEmpty)
+				So(settings.Secret, ShouldBeNil)
+			})
+		})
+
+		Convey("Fails if the test notification fails", func() {
+			receiverStatus = http.StatusForbidden
+
+			So(post(url.Values{
+				"webhook_enabled": {"true"},
+				"webhook_urls":    {receiver.URL + "/hook"},
+			}), ShouldEqual, http.StatusBadRequest)
+
+			_, err := webhook.GetSettings(dummyContext, reader)
+			So(errors.Is(err, metadata.ErrNoSuchKey), ShouldBeTrue)
+
+			So(post(url.Values{
+				"action":          {"test"},
+				"subsection":      {"webhook"},
+				"webhook_enabled": {"true"},
+				"webhook_urls":    {receiver.URL + "/hook"},
+			}), ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Invalid settings", func() {
+			So(post(url.Values{"webhook_enabled": {"true"}, "webhook_urls": {""}}), ShouldEqual, http.StatusBadRequest)
+			So(post(url.Values{"webhook_enabled": {"maybe"}, "webhook_urls": {receiver.URL}}), ShouldEqual, http.StatusBadRequest)
+			So(post(url.Values{"webhook_enabled": {"false"}, "webhook_urls": {"ftp://example.com"}}), ShouldEqual, http.StatusBadRequest)
+			So(post(url.Values{"webhook_enabled": {"false"}, "webhook_urls": {receiver.URL}, "webhook_headers": {"no colon"}}), ShouldEqual, http.StatusBadRequest)
+			So(post(url.Values{"webhook_enabled": {"false"}, "webhook_urls": {receiver.URL}, "webhook_headers": {"Bad Name: value"}}), ShouldEqual, http.StatusBadRequest)
+			So(received, ShouldBeEmpty)
+		})
+	})
+}
+
 func TestEmailNotifications(t *testing.T) {
 	Convey("Email Notifications", t, func() {
 		setup, w, _, center, _, _, clear := buildTestSetup(t)
@@ -888,6 +1130,97 @@ func TestInsightsSettings(t *testing.T) {
 				})
 			})
 
+			Convey("Invalid option for domain_blocklist_check_interval", func() {
+				for _, v := range []string{"abc", "0", "169", "1.5"} {
+					r, err := c.PostForm(settingsURL, url.Values{
+						"bounce_rate_threshold":           {"50"},
+						"mail_inactivity_lookup_range":    {"12"},
+						"mail_inactivity_min_interval":    {"8"},
+						"domain_blocklist_check_interval": {v},
+					})
+					So(err, ShouldBeNil)
+					So(r.StatusCode, ShouldEqual, http.StatusBadRequest)
+				}
+			})
+
+			Convey("Set the domain blocklist check interval", func() {
+				r, err := c.PostForm(settingsURL, url.Values{
+					"bounce_rate_threshold":           {"53"},
+					"mail_inactivity_lookup_range":    {"12"},
+					"mail_inactivity_min_interval":    {"8"},
+					"domain_blocklist_check_interval": {"12"},
+				})
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+				i := &insightsSettings.Settings{}
+				So(reader.RetrieveJson(dummyContext, insightsSettings.SettingsKey, i), ShouldBeNil)
+				So(i.DomainBlocklistCheckInterval, ShouldEqual, 12)
+
+				// kept when not sent
+				r, err = c.PostForm(settingsURL, url.Values{
+					"bounce_rate_threshold":        {"53"},
+					"mail_inactivity_lookup_range": {"12"},
+					"mail_inactivity_min_interval": {"8"},
+				})
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+				So(reader.RetrieveJson(dummyContext, insightsSettings.SettingsKey, i), ShouldBeNil)
+				So(i.DomainBlocklistCheckInterval, ShouldEqual, 12)
+			})
+
+			Convey("Invalid options for the stuck queues ages", func() {
+				for _, v := range []url.Values{
+					{"stuck_queues_min_age": {"abc"}},
+					{"stuck_queues_min_age": {"0"}},
+					{"stuck_queues_min_age": {"1000"}},
+					{"stuck_queues_maximal_queue_lifetime": {"1.5"}},
+					{"stuck_queues_maximal_queue_lifetime": {"101"}},
+					// the messages would expire before being reported
+					{"stuck_queues_min_age": {"48"}, "stuck_queues_maximal_queue_lifetime": {"2"}},
+				} {
+					v.Set("bounce_rate_threshold", "50")
+					v.Set("mail_inactivity_lookup_range", "12")
+					v.Set("mail_inactivity_min_interval", "8")
+
+					r, err := c.PostForm(settingsURL, v)
+					So(err, ShouldBeNil)
+					So(r.StatusCode, ShouldEqual, http.StatusBadRequest)
+				}
+			})
+
+			Convey("Set the stuck queues ages", func() {
+				r, err := c.PostForm(settingsURL, url.Values{
+					"bounce_rate_threshold":               {"53"},
+					"mail_inactivity_lookup_range":        {"12"},
+					"mail_inactivity_min_interval":        {"8"},
+					"stuck_queues_min_age":                {"12"},
+					"stuck_queues_maximal_queue_lifetime": {"3"},
+				})
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+				i := &insightsSettings.Settings{}
+				So(reader.RetrieveJson(dummyContext, insightsSettings.SettingsKey, i), ShouldBeNil)
+				So(i.StuckQueuesMinAge, ShouldEqual, 12)
+				So(i.StuckQueuesMaximalQueueLifetime, ShouldEqual, 3)
+
+				// the stored lifetime is taken into account when only the age is sent
+				r, err = c.PostForm(settingsURL, url.Values{
+					"bounce_rate_threshold":        {"53"},
+					"mail_inactivity_lookup_range": {"12"},
+					"mail_inactivity_min_interval": {"8"},
+					"stuck_queues_min_age":         {"72"},
+				})
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusBadRequest)
+
+				So(reader.RetrieveJson(dummyContext, insightsSettings.SettingsKey, i), ShouldBeNil)
+				So(i.StuckQueuesMinAge, ShouldEqual, 12)
+				So(i.StuckQueuesMaximalQueueLifetime, ShouldEqual, 3)
+			})
+
 			Convey("Success", func() {
 				Convey("Set to 53%", func() {
 					r, err := c.PostForm(settingsURL, url.Values{
@@ -927,7 +1260,7 @@ func TestInsightsSettings(t *testing.T) {
 						}
 
 						detectors := insightsEngine.GetCoreDetectors()
-						So(len(detectors), ShouldEqual, 2)
+						So(len(detectors), ShouldEqual, 3)
 
 						for _, d := range detectors {
 							if hrd, ok := d.(*highrate.Detector); ok {
@@ -950,3 +1283,93 @@ func TestInsightsSettings(t *testing.T) {
 		})
 	})
 }
+
+func TestInsightsCustomRulesSettings(t *testing.T) {
+	Convey("Insights Custom Rules Settings", t, func() {
+		setup, _, reader, _, _, insightsEngine, clear := buildTestSetup(t)
+		defer clear()
+
+		chain := httpmiddleware.New()
+		handler := chain.WithEndpoint(httpmiddleware.CustomHTTPHandler(setup.SettingsForward))
+
+		c := &http.Client{}
+
+		s := httptest.NewServer(handler)
+
+		rulesURL := s.URL + "?setting=insightsRules"
+
+		Convey("Invalid rules are rejected", func() {
+			for _, rules := range []string{
+				`not json`,
+				`[{"name": "", "metric": "bounced", "operator": ">", "window": 10}]`,
+				`[{"name": "r", "metric": "unknown", "operator": ">", "window": 10}]`,
+				`[{"name": "r", "metric": "bounced", "target": "mailbox", "value": "example.com", "operator": ">", "window": 10}]`,
+				`[{"name": "r", "metric": "bounced", "operator": "!=", "window": 10}]`,
+				`[{"name": "r", "metric": "bounce_rate", "operator": ">", "threshold": 101, "window": 10}]`,
+				`[{"name": "r", "metric": "bounced", "operator": ">", "window": 0}]`,
+				`[{"name": "r", "metric": "bounced", "operator": ">", "window": 10, "priority": "urgent"}]`,
+				`[{"name": "r", "metric": "bounced", "operator": ">", "window": 10}, {"name": "r", "metric": "sent", "operator": "<", "window": 10}]`,
+			} {
+				r, err := c.PostForm(rulesURL, url.Values{"rules": {rules}})
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusBadRequest)
+			}
+		})
+
+		Convey("Rules are stored and kept when other insights settings change", func() {
+			r, err := c.PostForm(rulesURL, url.Values{"rules": {`[{"name": "Bounces", "metric": "bounced", "target": "domain", "value": "Example.com", "operator": ">", "threshold": 10, "window": 15, "cooldown": 60}]`}})
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+			r, err = c.PostForm(s.URL+"?setting=insights", url.Values{
+				"bounce_rate_threshold":        {"53"},
+				"mail_inactivity_lookup_range": {"12"},
+				"mail_inactivity_min_interval": {"8"},
+			})
+			So(err, ShouldBeNil)
+			So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+			expectedRules := []insightsSettings.CustomRule{
+				{
+					Name:      "Bounces",
+					Metric:    insightsSettings.BouncedMetric,
+					Target:    insightsSettings.DomainTarget,
+					Value:     "example.com",
+					Operator:  insightsSettings.GreaterThan,
+					Threshold: 10,
+					Window:    15,
+					Category:  insightsSettings.DefaultRuleCategory,
+					Priority:  insightsSettings.DefaultRulePriority,
+					Cooldown:  60,
+				},
+			}
+
+			i := &insightsSettings.Settings{}
+			So(reader.RetrieveJson(dummyContext, insightsSettings.SettingsKey, i), ShouldBeNil)
+			So(i.BounceRateThreshold, ShouldEqual, 53)
+			So(i.CustomRules, ShouldResemble, expectedRules)
+
+			found := 0
+
+			for _, d := range insightsEngine.GetCoreDetectors() {
+				if d, ok := d.(*customrules.Detector); ok {
+					So(d.Rules(), ShouldResemble, expectedRules)
+					found++
+				}
+			}
+
+			So(found, ShouldEqual, 1)
+
+			Convey("And removed", func() {
+				r, err := c.PostForm(rulesURL, url.Values{"rules": {`[]`}})
+				So(err, ShouldBeNil)
+				So(r.StatusCode, ShouldEqual, http.StatusOK)
+
+				i := &insightsSettings.Settings{}
+				So(reader.RetrieveJson(dummyContext, insightsSettings.SettingsKey, i), ShouldBeNil)
+				So(i.BounceRateThreshold, ShouldEqual, 53)
+				So(len(i.CustomRules), ShouldEqual, 0)
+			})
+		})
+	})
+}
@@ -80,6 +80,16 @@ func (c Content) HelpLink(urlContainer core.URLContainer) string {
 	return urlContainer.Get(ContentType)
 }
 
+func (c Content) BlockedIPs() []string {
+	ips := make([]string, 0, len(c.TopIPs))
+
+	for _, ip := range c.TopIPs {
+		ips = append(ips, ip.Address)
+	}
+
+	return ips
+}
+
 func init() {
 	core.RegisterContentType(ContentType, ContentTypeId, core.DefaultContentTypeDecoder(&Content{}))
 }
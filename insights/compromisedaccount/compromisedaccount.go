@@ -0,0 +1,394 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package compromisedaccount
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"net"
+	"sort"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/i18n/translator"
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/dbconn"
+	notificationCore "gitlab.com/lightmeter/controlcenter/notification/core"
+	parser "gitlab.com/lightmeter/controlcenter/pkg/postfix/logparser"
+	"gitlab.com/lightmeter/controlcenter/tracking"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+// When the password of an account leaks, it's usually used to send spam from many different places,
+// in volumes far above what the account usually sends, and to lists of addresses which often do not exist.
+// The account is the SASL username the client authenticated with, regardless of the envelope sender,
+// so that clients sharing an IP (NAT, webmail, localhost) are not mistaken for each other.
+
+const (
+	ContentType   = "compromised_account"
+	ContentTypeId = 17
+)
+
+type Options struct {
+	CheckInterval time.Duration
+
+	// The activity of each account in this time span is checked
+	TimeSpan time.Duration
+
+	// The usual activity of the accounts is computed on this time span, before the checked one
+	BaselineTimeSpan time.Duration
+
+	// Volumes below this are never a spike
+	MinVolume int64
+
+	// How many times above the usual volume the volume must be to be a spike
+	SpikeFactor float64
+
+	// Sending from this many distinct IPs in the time span is suspicious
+	MinDistinctIPs int
+
+	// Having this many messages bounced as the recipients do not exist is suspicious
+	MinUnknownRecipientBounces int64
+
+	// Minimum time between two insights for the same account
+	MinTimeToGenerateNewInsight time.Duration
+}
+
+type Signal string
+
+const (
+	VolumeSpikeSignal       Signal = "volume_spike"
+	DistinctIPsSignal       Signal = "distinct_ips"
+	UnknownRecipientsSignal Signal = "unknown_recipients"
+)
+
+type Content struct {
+	Account                 string                `json:"account"`
+	Signals                 []Signal              `json:"signals"`
+	Messages                int64                 `json:"messages"`
+	UsualMessages           float64               `json:"usual_messages"`
+	DistinctIPs             int                   `json:"distinct_ips"`
+	IPs                     []string              `json:"ips"`
+	UnknownRecipientBounces int64                 `json:"unknown_recipient_bounces"`
+	Interval                timeutil.TimeInterval `json:"interval"`
+}
+
+func (c Content) Title() notificationCore.ContentComponent {
+	return &title{c}
+}
+
+func (c Content) Description() notificationCore.ContentComponent {
+	return &description{c}
+}
+
+func (c Content) Metadata() notificationCore.ContentMetadata {
+	return nil
+}
+
+func (c Content) MuteParams() map[string]string {
+	return map[string]string{"account": c.Account}
+}
+
+type title struct {
+	c Content
+}
+
+func (t title) String() string {
+	return translator.Stringfy(t)
+}
+
+func (t title) TplString() string {
+	return translator.I18n("The account %v might be compromised")
+}
+
+func (t title) Args() []interface{} {
+	return []interface{}{t.c.Account}
+}
+
+type description struct {
+	c Content
+}
+
+func (d description) String() string {
+	return translator.Stringfy(d)
+}
+
+func (d description) TplString() string {
+	return translator.I18n("%v messages sent between %v and %v (about %v usually), from %v distinct IPs, with %v bounced as the recipients do not exist")
+}
+
+func (d description) Args() []interface{} {
+	return []interface{}{d.c.Messages, d.c.Interval.From, d.c.Interval.To, int64(math.Round(d.c.UsualMessages)), d.c.DistinctIPs, d.c.UnknownRecipientBounces}
+}
+
+func init() {
+	core.RegisterContentType(ContentType, ContentTypeId, core.DefaultContentTypeDecoder(&Content{}))
+}
+
+type detector struct {
+	pool    *dbconn.RoPool
+	creator core.Creator
+	options Options
+}
+
+func (*detector) IsHistoricalDetector() {
+	// Required by the historical import
+}
+
+func (*detector) Close() error {
+	return nil
+}
+
+func getDetectorOptions(options core.Options) Options {
+	detectorOptions, ok := options["compromisedaccount"].(Options)
+	if !ok {
+		errorutil.MustSucceed(errors.New("Invalid detector options"))
+	}
+
+	return detectorOptions
+}
+
+func NewDetector(creator core.Creator, options core.Options) core.Detector {
+	pool, ok := options["logsConnPool"].(*dbconn.RoPool)
+	if !ok {
+		errorutil.MustSucceed(errors.New("Invalid Connection Pool"))
+	}
+
+	return &detector{
+		pool:    pool,
+		creator: creator,
+		options: getDetectorOptions(options),
+	}
+}
+
+type accountStats struct {
+	account                 string
+	messages                int64
+	baselineMessages        int64
+	ips                     map[string]struct{}
+	unknownRecipientBounces int64
+}
+
+// DSNs 5.1.x are about invalid destination addresses, the most common being 5.1.1 (unknown user)
+const accountsStmt = `
+select
+	d.sasl_username,
+	d.client_ip,
+	d.delivery_ts >= ? as current,
+	count(*),
+	sum(case when d.status = ? and d.dsn like '5.1.%' then 1 else 0 end)
+from
+	deliveries d
+where
+	d.delivery_ts between ? and ? and d.direction = ? and d.sasl_username is not null
+group by
+	d.sasl_username, d.client_ip, current
+`
+
+func (d *detector) fetchAccountStats(ctx context.Context, interval timeutil.TimeInterval) (stats map[string]*accountStats, err error) {
+	baselineInterval := timeutil.TimeInterval{From: interval.From.Add(-d.options.BaselineTimeSpan), To: interval.To}
+
+	conn, release, err := d.pool.AcquireContext(ctx)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer release()
+
+	rows, err := conn.QueryContext(ctx, accountsStmt,
+		interval.From.Unix(), parser.BouncedStatus, baselineInterval.From.Unix(), interval.To.Unix(), tracking.MessageDirectionOutbound)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	stats = map[string]*accountStats{}
+
+	for rows.Next() {
+		var (
+			account        string
+			ip             net.IP
+			current        bool
+			count, bounces int64
+		)
+
+		if err := rows.Scan(&account, &ip, &current, &count, &bounces); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		s, ok := stats[account]
+		if !ok {
+			s = &accountStats{account: account, ips: map[string]struct{}{}}
+			stats[account] = s
+		}
+
+		if !current {
+			s.baselineMessages += count
+			continue
+		}
+
+		s.messages += count
+		s.unknownRecipientBounces += bounces
+
+		if ip != nil {
+			s.ips[ip.String()] = struct{}{}
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return stats, nil
+}
+
+// the maximum number of IPs listed in the insight
+const maxListedIPs = 10
+
+func (d *detector) analyse(s *accountStats, interval timeutil.TimeInterval) (Content, bool) {
+	usual := float64(s.baselineMessages) * float64(d.options.TimeSpan) / float64(d.options.BaselineTimeSpan)
+
+	signals := []Signal{}
+
+	if s.messages >= d.options.MinVolume && float64(s.messages) > d.options.SpikeFactor*usual {
+		signals = append(signals, VolumeSpikeSignal)
+	}
+
+	if len(s.ips) >= d.options.MinDistinctIPs {
+		signals = append(signals, DistinctIPsSignal)
+	}
+
+	if s.unknownRecipientBounces >= d.options.MinUnknownRecipientBounces {
+		signals = append(signals, UnknownRecipientsSignal)
+	}
+
+	if len(signals) == 0 {
+		return Content{}, false
+	}
+
+	ips := make([]string, 0, len(s.ips))
+
+	for ip := range s.ips {
+		ips = append(ips, ip)
+	}
+
+	sort.Strings(ips)
+
+	if len(ips) > maxListedIPs {
+		ips = ips[:maxListedIPs]
+	}
+
+	return Content{
+		Account:                 s.account,
+		Signals:                 signals,
+		Messages:                s.messages,
+		UsualMessages:           usual,
+		DistinctIPs:             len(s.ips),
+		IPs:                     ips,
+		UnknownRecipientBounces: s.unknownRecipientBounces,
+		Interval:                interval,
+	}, true
+}
+
+func (d *detector) detect(ctx context.Context, interval timeutil.TimeInterval) ([]Content, error) {
+	stats, err := d.fetchAccountStats(ctx, interval)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	contents := []Content{}
+
+	for _, s := range stats {
+		if content, found := d.analyse(s, interval); found {
+			contents = append(contents, content)
+		}
+	}
+
+	// stable order for the generated insights
+	sort.Slice(contents, func(i, j int) bool {
+		return contents[i].Account < contents[j].Account
+	})
+
+	return contents, nil
+}
+
+const lastExecutionKind = "compromised_account"
+
+func (d *detector) Step(c core.Clock, tx *sql.Tx) error {
+	now := c.Now()
+
+	lastExecution, err := core.RetrieveLastDetectorExecution(tx, lastExecutionKind)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if !lastExecution.IsZero() && now.Sub(lastExecution) < d.options.CheckInterval {
+		return nil
+	}
+
+	interval := timeutil.TimeInterval{From: now.Add(-d.options.TimeSpan), To: now}
+
+	contents, err := d.detect(context.Background(), interval)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	for _, content := range contents {
+		if err := d.maybeGenerateInsight(tx, c, content); err != nil {
+			return errorutil.Wrap(err)
+		}
+	}
+
+	// the accounts not reported recently can be reported again anyways
+	if err := core.ForgetLastInsightGenerations(tx, lastExecutionKind, now.Add(-d.options.MinTimeToGenerateNewInsight)); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if err := core.StoreLastDetectorExecution(tx, lastExecutionKind, now); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func (d *detector) maybeGenerateInsight(tx *sql.Tx, c core.Clock, content Content) error {
+	lastGeneration, err := core.RetrieveLastInsightGeneration(tx, lastExecutionKind, content.Account)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if !lastGeneration.IsZero() && c.Now().Sub(lastGeneration) < d.options.MinTimeToGenerateNewInsight {
+		return nil
+	}
+
+	if err := generateInsight(tx, c, d.creator, content); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if err := core.StoreLastInsightGeneration(tx, lastExecutionKind, content.Account, c.Now()); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func generateInsight(tx *sql.Tx, c core.Clock, creator core.Creator, content Content) error {
+	properties := core.InsightProperties{
+		Time:        c.Now(),
+		Category:    core.LocalCategory,
+		Rating:      core.BadRating,
+		ContentType: ContentType,
+		Content:     content,
+	}
+
+	if err := creator.GenerateInsight(context.Background(), tx, properties); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+//go:build dev || !release
+// +build dev !release
+
+package compromisedaccount
+
+import (
+	"database/sql"
+
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+// Executed only on development builds, for better developer experience
+func (d *detector) GenerateSampleInsight(tx *sql.Tx, c core.Clock) error {
+	content := Content{
+		Account:                 "alice@example.com",
+		Signals:                 []Signal{VolumeSpikeSignal, DistinctIPsSignal, UnknownRecipientsSignal},
+		Messages:                1432,
+		UsualMessages:           12.5,
+		DistinctIPs:             14,
+		IPs:                     []string{"11.22.33.44", "22.33.44.55", "33.44.55.66", "44.55.66.77", "55.66.77.88"},
+		UnknownRecipientBounces: 321,
+		Interval:                timeutil.TimeInterval{From: c.Now().Add(-d.options.TimeSpan), To: c.Now()},
+	}
+
+	if err := generateInsight(tx, c, d.creator, content); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package compromisedaccount
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gitlab.com/lightmeter/controlcenter/deliverydb"
+	"gitlab.com/lightmeter/controlcenter/domainmapping"
+	"gitlab.com/lightmeter/controlcenter/i18n/translator"
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	_ "gitlab.com/lightmeter/controlcenter/insights/migrations"
+	insighttestsutil "gitlab.com/lightmeter/controlcenter/insights/testutil"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3"
+	"gitlab.com/lightmeter/controlcenter/notification"
+	notificationCore "gitlab.com/lightmeter/controlcenter/notification/core"
+	parser "gitlab.com/lightmeter/controlcenter/pkg/postfix/logparser"
+	"gitlab.com/lightmeter/controlcenter/pkg/runner"
+	"gitlab.com/lightmeter/controlcenter/tracking"
+	"gitlab.com/lightmeter/controlcenter/util/testutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+func init() {
+	lmsqlite3.Initialize(lmsqlite3.Options{})
+}
+
+func buildResult(t time.Time, sender, username, ip string, status parser.SmtpStatus, dsn string, checksum int64) tracking.Result {
+	result := tracking.Result{}
+	result[tracking.ResultDeliveryTimeKey] = tracking.ResultEntryInt64(t.Unix())
+	result[tracking.QueueSenderLocalPartKey] = tracking.ResultEntryText(sender)
+	result[tracking.QueueSenderDomainPartKey] = tracking.ResultEntryText("example.com")
+	result[tracking.ResultRecipientLocalPartKey] = tracking.ResultEntryText(fmt.Sprintf("recipient%d", checksum))
+	result[tracking.ResultRecipientDomainPartKey] = tracking.ResultEntryText("recipient.example.com")
+	result[tracking.ResultStatusKey] = tracking.ResultEntryInt64(int64(status))
+	result[tracking.ResultMessageDirectionKey] = tracking.ResultEntryInt64(int64(tracking.MessageDirectionOutbound))
+	result[tracking.QueueMessageIDKey] = tracking.ResultEntryText(fmt.Sprintf("msgid%d", checksum))
+	result[tracking.QueueOriginalMessageSizeKey] = tracking.ResultEntryInt64(35)
+	result[tracking.QueueProcessedMessageSizeKey] = tracking.ResultEntryInt64(42)
+	result[tracking.QueueNRCPTKey] = tracking.ResultEntryInt64(1)
+	result[tracking.ResultDeliveryServerKey] = tracking.ResultEntryText("mail")
+	result[tracking.ResultDelayKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDelaySMTPDKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDelayCleanupKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDelayQmgrKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDelaySMTPKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDSNKey] = tracking.ResultEntryText(dsn)
+	result[tracking.QueueBeginKey] = tracking.ResultEntryInt64(t.Unix())
+	result[tracking.QueueDeliveryNameKey] = tracking.ResultEntryText("A1")
+	result[tracking.ConnectionClientIPKey] = tracking.ResultEntryBlob(net.ParseIP(ip))
+	result[tracking.ResultDeliveryLineChecksum] = tracking.ResultEntryInt64(checksum)
+
+	if len(username) > 0 {
+		result[tracking.QueueSASLUsernameKey] = tracking.ResultEntryText(username)
+	}
+
+	return result
+}
+
+func TestCompromisedAccountInsight(t *testing.T) {
+	Convey("Compromised accounts", t, func() {
+		conn, closeConn := testutil.TempDBConnectionMigrated(t, "logs")
+		defer closeConn()
+
+		db, err := deliverydb.New(conn, &domainmapping.DefaultMapping, deliverydb.Options{RetentionDuration: time.Hour * 24 * 30})
+		So(err, ShouldBeNil)
+
+		done, cancel := runner.Run(db)
+		pub := db.ResultsPublisher()
+
+		accessor, clear := insighttestsutil.NewFakeAccessor(t)
+		defer clear()
+
+		baseTime := testutil.MustParseTime(`2000-01-01 00:00:00 +0000`)
+
+		checksum := int64(0)
+
+		publish := func(t time.Time, sender, username, ip string, status parser.SmtpStatus, dsn string) {
+			checksum++
+			pub.Publish(buildResult(t, sender, username, ip, status, dsn, checksum))
+		}
+
+		// usual traffic, two messages per hour
+		for hour := 0; hour < 27; hour++ {
+			t := baseTime.Add(time.Hour * time.Duration(hour))
+
+			for _, sender := range []string{"alice", "bob"} {
+				publish(t.Add(time.Minute*5), sender, sender+"@example.com", "10.0.0.1", parser.SentStatus, "2.0.0")
+				publish(t.Add(time.Minute*35), sender, sender+"@example.com", "10.0.1.1", parser.SentStatus, "2.0.0")
+			}
+		}
+
+		spikeTime := baseTime.Add(time.Hour*24 + time.Minute*10)
+
+		// alice's password leaks
+		for i := 0; i < 200; i++ {
+			ip := fmt.Sprintf("10.0.0.%d", i%6+1)
+
+			// the spammer uses many different senders
+			sender := fmt.Sprintf("sender%d", i%3)
+
+			if i < 30 {
+				publish(spikeTime.Add(time.Second*time.Duration(i)), sender, "alice@example.com", ip, parser.BouncedStatus, "5.1.1")
+				continue
+			}
+
+			publish(spikeTime.Add(time.Second*time.Duration(i)), sender, "alice@example.com", ip, parser.SentStatus, "2.0.0")
+		}
+
+		// carol's newsletter does not authenticate, and is sent from the same IPs as the other accounts
+		for i := 0; i < 300; i++ {
+			publish(spikeTime.Add(time.Second*time.Duration(i)), "carol", "", fmt.Sprintf("10.0.0.%d", i%6+1), parser.SentStatus, "2.0.0")
+		}
+
+		cancel()
+		So(done(), ShouldBeNil)
+
+		options := Options{
+			CheckInterval:               time.Hour,
+			TimeSpan:                    time.Hour,
+			BaselineTimeSpan:            time.Hour * 24,
+			MinVolume:                   50,
+			SpikeFactor:                 5,
+			MinDistinctIPs:              5,
+			MinUnknownRecipientBounces:  20,
+			MinTimeToGenerateNewInsight: time.Hour * 24,
+		}
+
+		detector := NewDetector(accessor, core.Options{"logsConnPool": conn.RoConnPool, "compromisedaccount": options})
+
+		clock := &insighttestsutil.FakeClock{Time: baseTime}
+
+		insighttestsutil.ExecuteCyclesUntil(detector, accessor, clock, baseTime.Add(time.Hour*27), time.Minute*20)
+
+		So(accessor.Insights, ShouldResemble, []int64{1})
+
+		insights, err := accessor.FetchInsights(context.Background(), core.FetchOptions{
+			Interval: timeutil.TimeInterval{From: baseTime, To: baseTime.Add(time.Hour * 48)},
+			OrderBy:  core.OrderByCreationAsc,
+		}, clock)
+		So(err, ShouldBeNil)
+		So(len(insights), ShouldEqual, 1)
+
+		So(insights[0].ContentType(), ShouldEqual, ContentType)
+		So(insights[0].Rating(), ShouldEqual, core.BadRating)
+		So(insights[0].Time(), ShouldEqual, baseTime.Add(time.Hour*25))
+
+		So(insights[0].Content(), ShouldResemble, &Content{
+			Account:                 "alice@example.com",
+			Signals:                 []Signal{VolumeSpikeSignal, DistinctIPsSignal, UnknownRecipientsSignal},
+			Messages:                202,
+			UsualMessages:           2,
+			DistinctIPs:             7,
+			IPs:                     []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5", "10.0.0.6", "10.0.1.1"},
+			UnknownRecipientBounces: 30,
+			Interval:                timeutil.TimeInterval{From: baseTime.Add(time.Hour * 24), To: baseTime.Add(time.Hour * 25)},
+		})
+	})
+}
+
+func TestDescriptionFormatting(t *testing.T) {
+	Convey("Description Formatting", t, func() {
+		content := Content{
+			Account:                 "alice@example.com",
+			Signals:                 []Signal{VolumeSpikeSignal, UnknownRecipientsSignal},
+			Messages:                202,
+			UsualMessages:           2.4,
+			DistinctIPs:             2,
+			IPs:                     []string{"10.0.0.1", "10.0.0.2"},
+			UnknownRecipientBounces: 30,
+			Interval:                timeutil.TimeInterval{From: testutil.MustParseTime(`2000-01-01 00:00:00 +0000`), To: testutil.MustParseTime(`2000-01-01 01:00:00 +0000`)},
+		}
+
+		m, err := notificationCore.TranslateNotification(notification.Notification{ID: 1, Content: content}, translator.DummyTranslator{})
+		So(err, ShouldBeNil)
+		So(m, ShouldResemble, notificationCore.Message{
+			Title:       "The account alice@example.com might be compromised",
+			Description: "202 messages sent between 2000-01-01 00:00:00 +0000 UTC and 2000-01-01 01:00:00 +0000 UTC (about 2 usually), from 2 distinct IPs, with 30 bounced as the recipients do not exist",
+			Metadata:    map[string]string{},
+		})
+	})
+}
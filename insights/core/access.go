@@ -198,6 +198,7 @@ type FetchedInsight interface {
 	ContentType() string
 	UserRating() *int
 	UserRatingOld() bool
+	Acknowledged() bool
 }
 
 type FetchFilter int
@@ -267,13 +268,24 @@ func buildSelectStmt(where, order string) string {
 		left join user_ratings on insights.content_type = user_ratings.insight_type
 	)
 	select
-		id, time, iif(status_category == %d, status_category, actual_category) as computed_category, rating, content_type, content, user_rating, user_rating_ts
+		id, time, iif(status_category == %d, status_category, actual_category) as computed_category, rating, content_type, content, user_rating, user_rating_ts,
+		exists (
+			select 1
+			from insights_suppressed s join insights_suppressions r on r.id = s.suppression_id
+			where s.insight_id = insights_with_status_rating.id and r.kind = %d
+		) as acknowledged
 	from
 		insights_with_status_rating
-	where %s
+	where (%s)
+		-- muted insights are always hidden, and snoozed ones until the snooze expires
+		and not exists (
+			select 1
+			from insights_suppressed s join insights_suppressions r on r.id = s.suppression_id
+			where s.insight_id = insights_with_status_rating.id and (r.kind = %d or (r.kind = %d and r.until_ts > @now))
+		)
 	order by %s, id
 	limit @limit
-	`, int(ActiveCategory), int(ArchivedCategory), where, order)
+	`, int(ActiveCategory), int(ArchivedCategory), int(AcknowledgeSuppression), where, int(MuteSuppression), int(SnoozeSuppression), order)
 }
 
 var (
@@ -385,6 +397,7 @@ type fetchedInsight struct {
 	content       Content
 	userRating    *int
 	userRatingOld bool
+	acknowledged  bool
 }
 
 func (f *fetchedInsight) ID() int {
@@ -419,6 +432,10 @@ func (f *fetchedInsight) UserRatingOld() bool {
 	return f.userRatingOld
 }
 
+func (f *fetchedInsight) Acknowledged() bool {
+	return f.acknowledged
+}
+
 func (f *fetcher) FetchInsights(ctx context.Context, options FetchOptions, clock timeutil.Clock) (result []FetchedInsight, err error) {
 	conn, release, err := f.pool.AcquireContext(ctx)
 	if err != nil {
@@ -434,7 +451,7 @@ func (f *fetcher) FetchInsights(ctx context.Context, options FetchOptions, clock
 	query := f.queries[key]
 
 	//nolint:sqlclosecheck
-	rows, err := stmt.QueryContext(ctx, query.p(options)...)
+	rows, err := stmt.QueryContext(ctx, append(query.p(options), sql.Named("now", clock.Now().Unix()))...)
 
 	if err != nil {
 		return nil, errorutil.Wrap(err)
@@ -452,10 +469,11 @@ func (f *fetcher) FetchInsights(ctx context.Context, options FetchOptions, clock
 		userRating       *int
 		userRatingTs     int64
 		userRatingOld    bool
+		acknowledged     bool
 	)
 
 	for rows.Next() {
-		err = rows.Scan(&id, &ts, &category, &rating, &contentTypeValue, &contentBytes, &userRating, &userRatingTs)
+		err = rows.Scan(&id, &ts, &category, &rating, &contentTypeValue, &contentBytes, &userRating, &userRatingTs, &acknowledged)
 
 		if err != nil {
 			return []FetchedInsight{}, errorutil.Wrap(err)
@@ -485,6 +503,7 @@ func (f *fetcher) FetchInsights(ctx context.Context, options FetchOptions, clock
 			content:       content,
 			userRating:    userRating,
 			userRatingOld: userRatingOld,
+			acknowledged:  acknowledged,
 		})
 	}
 
@@ -72,3 +72,9 @@ type URLContainer interface {
 type RecommendationHelpLinkProvider interface {
 	HelpLink(container URLContainer) string
 }
+
+// BlockedIPsProvider is implemented by the content of insights about IPs which should be blocked,
+// being used to build the exportable blocklist
+type BlockedIPsProvider interface {
+	BlockedIPs() []string
+}
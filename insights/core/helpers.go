@@ -138,6 +138,44 @@ func RetrieveLastDetectorExecution(tx *sql.Tx, kind string) (time.Time, error) {
 	return time.Unix(ts, 0), nil
 }
 
+// StoreLastInsightGeneration stores when an insight of a kind about subject (an IP, an account...) was generated.
+// Unlike the detectors, the subjects are unbounded, so those times are kept in their own table,
+// and must be removed by ForgetLastInsightGenerations once not needed anymore.
+func StoreLastInsightGeneration(tx *sql.Tx, kind, subject string, time time.Time) error {
+	_, err := tx.Exec(`insert into last_insight_generation(kind, subject, ts) values(?, ?, ?)
+		on conflict(kind, subject) do update set ts = excluded.ts`, kind, subject, time.Unix())
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+// RetrieveLastInsightGeneration returns when an insight of a kind about subject was generated, or the zero time if never
+func RetrieveLastInsightGeneration(tx *sql.Tx, kind, subject string) (time.Time, error) {
+	var ts int64
+	err := tx.QueryRow(`select ts from last_insight_generation where kind = ? and subject = ?`, kind, subject).Scan(&ts)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+
+	if err != nil {
+		return time.Time{}, errorutil.Wrap(err)
+	}
+
+	return time.Unix(ts, 0), nil
+}
+
+// ForgetLastInsightGenerations removes the generation times of a kind before a time
+func ForgetLastInsightGenerations(tx *sql.Tx, kind string, before time.Time) error {
+	if _, err := tx.Exec(`delete from last_insight_generation where kind = ? and ts < ?`, kind, before.Unix()); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
 var (
 	ErrWrongRatingValue = errors.New("Wrong rating value")
 	ErrAlreadyRated     = errors.New("User rating was submitted in the last two weeks")
@@ -0,0 +1,50 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"context"
+
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/dbconn"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+// CountByCategory returns how many insights are stored for each category
+func CountByCategory(ctx context.Context, pool *dbconn.RoPool) (counts map[Category]int64, err error) {
+	conn, release, err := pool.AcquireContext(ctx)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer release()
+
+	rows, err := conn.QueryContext(ctx, `select category, count(*) from insights group by category`)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	counts = map[Category]int64{}
+
+	for rows.Next() {
+		var (
+			category Category
+			count    int64
+		)
+
+		if err := rows.Scan(&category, &count); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		counts[category] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return counts, nil
+}
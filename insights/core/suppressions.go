@@ -0,0 +1,394 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/dbconn"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+// Users can silence insights about problems they already know about:
+// - snoozing an insight hides it, and the similar ones generated in the meanwhile, until some time.
+// - acknowledging an insight keeps it, and the similar ones generated afterwards, visible, but without notifying about them.
+// - mute rules hide the insights of a content type matching some parameters, without notifying about them.
+// Similar insights are the ones with the same content type and mute parameters.
+// Insights without mute parameters are not similar to any other, so snoozing or acknowledging them
+// affects only themselves, whereas mute rules without parameters match the whole content type.
+// Insights are matched when they are generated, and the matches are kept in the `insights_suppressed` table.
+
+type SuppressionKind int
+
+const (
+	MuteSuppression        SuppressionKind = 1
+	SnoozeSuppression      SuppressionKind = 2
+	AcknowledgeSuppression SuppressionKind = 3
+)
+
+func (k SuppressionKind) String() string {
+	switch k {
+	case MuteSuppression:
+		return "mute"
+	case SnoozeSuppression:
+		return "snooze"
+	case AcknowledgeSuppression:
+		return "acknowledge"
+	default:
+		return "unknown"
+	}
+}
+
+func (k SuppressionKind) MarshalJSON() ([]byte, error) {
+	return json.Marshal(k.String())
+}
+
+var (
+	ErrInvalidSuppression = errors.New(`Invalid insight suppression`)
+	ErrNoSuchSuppression  = errors.New(`No such insight suppression`)
+	ErrNoSuchInsight      = errors.New(`No such insight`)
+)
+
+// MuteParamsProvider is implemented by the content of insights which can be told apart by some parameters,
+// like the RBL or the domain they are about, allowing only some of the insights of a content type to be muted
+type MuteParamsProvider interface {
+	MuteParams() map[string]string
+}
+
+func MuteParams(content Content) map[string]string {
+	if p, ok := content.(MuteParamsProvider); ok {
+		return p.MuteParams()
+	}
+
+	return map[string]string{}
+}
+
+type Suppression struct {
+	ID          int64             `json:"id"`
+	Kind        SuppressionKind   `json:"kind"`
+	ContentType string            `json:"content_type"`
+	Params      map[string]string `json:"params"`
+	CreatedAt   time.Time         `json:"created_at"`
+
+	// nil for the ones which never expire
+	Until *time.Time `json:"until"`
+
+	// the snoozed or acknowledged insight, zero for mute rules
+	InsightID int64 `json:"insight_id,omitempty"`
+}
+
+// Matches an insight when all the parameters of the suppression have the same value in the insight.
+// Mute rules without parameters match all the insights of their content type,
+// whereas snoozed and acknowledged insights without parameters match no other insight
+func (s Suppression) Matches(contentType string, params map[string]string) bool {
+	if s.ContentType != contentType {
+		return false
+	}
+
+	if s.Kind != MuteSuppression && len(s.Params) == 0 {
+		return false
+	}
+
+	for k, v := range s.Params {
+		if value, ok := params[k]; !ok || value != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SuppressionManager is implemented by the insights engine
+type SuppressionManager interface {
+	MuteInsights(ctx context.Context, contentType string, params map[string]string, until *time.Time) (int64, error)
+	SnoozeInsight(ctx context.Context, id int64, until time.Time) (int64, error)
+	AcknowledgeInsight(ctx context.Context, id int64) (int64, error)
+	DeleteInsightSuppression(ctx context.Context, id int64) error
+	InsightSuppressions(ctx context.Context) ([]Suppression, error)
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func scanSuppressions(rows *sql.Rows) ([]Suppression, error) {
+	suppressions := []Suppression{}
+
+	for rows.Next() {
+		var (
+			s                   Suppression
+			contentTypeValue    int
+			encodedParams       []byte
+			creationTs, untilTs int64
+		)
+
+		if err := rows.Scan(&s.ID, &s.Kind, &contentTypeValue, &encodedParams, &creationTs, &untilTs, &s.InsightID); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		contentType, err := ContentTypeForValue(contentTypeValue)
+		if err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		s.ContentType = contentType
+
+		if err := json.Unmarshal(encodedParams, &s.Params); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		s.CreatedAt = time.Unix(creationTs, 0).In(time.UTC)
+
+		if untilTs != 0 {
+			until := time.Unix(untilTs, 0).In(time.UTC)
+			s.Until = &until
+		}
+
+		suppressions = append(suppressions, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return suppressions, nil
+}
+
+// MatchingSuppressions returns the suppressions, active by the time of the insight, which match it
+func MatchingSuppressions(ctx context.Context, q queryer, properties InsightProperties) (suppressions []Suppression, err error) {
+	contentTypeValue, err := ValueForContentType(properties.ContentType)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		select
+			id, kind, content_type, params, creation_ts, until_ts, insight_id
+		from
+			insights_suppressions
+		where
+			content_type = ? and (until_ts = 0 or until_ts > ?)
+		order by
+			id`, contentTypeValue, properties.Time.Unix())
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	all, err := scanSuppressions(rows)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	params := MuteParams(properties.Content)
+
+	for _, s := range all {
+		if s.Matches(properties.ContentType, params) {
+			suppressions = append(suppressions, s)
+		}
+	}
+
+	return suppressions, nil
+}
+
+func linkSuppression(ctx context.Context, tx *sql.Tx, insightID, suppressionID int64) error {
+	if _, err := tx.ExecContext(ctx, `insert into insights_suppressed(insight_id, suppression_id) values(?, ?)`, insightID, suppressionID); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+// SuppressNewInsight records which suppressions match a newly generated insight.
+// It must be called, on the same transaction, after GenerateInsight
+func SuppressNewInsight(ctx context.Context, tx *sql.Tx, id int64, properties InsightProperties) error {
+	suppressions, err := MatchingSuppressions(ctx, tx, properties)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	for _, s := range suppressions {
+		if err := linkSuppression(ctx, tx, id, s.ID); err != nil {
+			return errorutil.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+func insertSuppression(ctx context.Context, tx *sql.Tx, s Suppression) (int64, error) {
+	contentTypeValue, err := ValueForContentType(s.ContentType)
+	if err != nil {
+		return 0, fmt.Errorf("%v: %w", err, ErrInvalidSuppression)
+	}
+
+	encodedParams, err := json.Marshal(s.Params)
+	if err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	untilTs := int64(0)
+	if s.Until != nil {
+		untilTs = s.Until.Unix()
+	}
+
+	result, err := tx.ExecContext(ctx, `insert into insights_suppressions(kind, content_type, params, creation_ts, until_ts, insight_id) values(?, ?, ?, ?, ?, ?)`,
+		s.Kind, contentTypeValue, encodedParams, s.CreatedAt.Unix(), untilTs, s.InsightID)
+	if err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	return id, nil
+}
+
+// Mute creates a rule to suppress the insights generated from now on which match the content type and parameters
+func Mute(ctx context.Context, tx *sql.Tx, contentType string, params map[string]string, until *time.Time, now time.Time) (int64, error) {
+	if until != nil && !until.After(now) {
+		return 0, fmt.Errorf("Mute rule expires in the past: %w", ErrInvalidSuppression)
+	}
+
+	if params == nil {
+		params = map[string]string{}
+	}
+
+	return insertSuppression(ctx, tx, Suppression{
+		Kind:        MuteSuppression,
+		ContentType: contentType,
+		Params:      params,
+		CreatedAt:   now,
+		Until:       until,
+	})
+}
+
+func suppressInsight(ctx context.Context, tx *sql.Tx, insightID int64, kind SuppressionKind, until *time.Time, now time.Time) (int64, error) {
+	var (
+		contentTypeValue int
+		contentBytes     []byte
+	)
+
+	err := tx.QueryRowContext(ctx, `select content_type, content from insights where rowid = ?`, insightID).Scan(&contentTypeValue, &contentBytes)
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNoSuchInsight
+	}
+
+	if err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	contentType, err := ContentTypeForValue(contentTypeValue)
+	if err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	content, err := decodeByContentType(contentType, contentBytes)
+	if err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	id, err := insertSuppression(ctx, tx, Suppression{
+		Kind:        kind,
+		ContentType: contentType,
+		Params:      MuteParams(content),
+		CreatedAt:   now,
+		Until:       until,
+		InsightID:   insightID,
+	})
+	if err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	if err := linkSuppression(ctx, tx, insightID, id); err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	return id, nil
+}
+
+// Snooze hides an insight, and the similar ones generated in the meanwhile, until some time
+func Snooze(ctx context.Context, tx *sql.Tx, insightID int64, until time.Time, now time.Time) (int64, error) {
+	if !until.After(now) {
+		return 0, fmt.Errorf("Snoozing until the past: %w", ErrInvalidSuppression)
+	}
+
+	return suppressInsight(ctx, tx, insightID, SnoozeSuppression, &until, now)
+}
+
+// Acknowledge stops notifications about an insight, and the similar ones generated afterwards
+func Acknowledge(ctx context.Context, tx *sql.Tx, insightID int64, now time.Time) (int64, error) {
+	var id int64
+
+	// acknowledging twice changes nothing
+	err := tx.QueryRowContext(ctx, `select id from insights_suppressions where insight_id = ? and kind = ?`, insightID, AcknowledgeSuppression).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, errorutil.Wrap(err)
+	}
+
+	return suppressInsight(ctx, tx, insightID, AcknowledgeSuppression, nil, now)
+}
+
+// DeleteSuppression removes a suppression, making the insights it hid visible again
+func DeleteSuppression(ctx context.Context, tx *sql.Tx, id int64) error {
+	result, err := tx.ExecContext(ctx, `delete from insights_suppressions where id = ?`, id)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if count == 0 {
+		return ErrNoSuchSuppression
+	}
+
+	if _, err := tx.ExecContext(ctx, `delete from insights_suppressed where suppression_id = ?`, id); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+// ListSuppressions returns the suppressions which have not expired yet
+func ListSuppressions(ctx context.Context, pool *dbconn.RoPool, now time.Time) (suppressions []Suppression, err error) {
+	conn, release, err := pool.AcquireContext(ctx)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer release()
+
+	rows, err := conn.QueryContext(ctx, `
+		select
+			id, kind, content_type, params, creation_ts, until_ts, insight_id
+		from
+			insights_suppressions
+		where
+			until_ts = 0 or until_ts > ?
+		order by
+			id`, now.Unix())
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	return scanSuppressions(rows)
+}
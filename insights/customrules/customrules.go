@@ -0,0 +1,422 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package customrules
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gitlab.com/lightmeter/controlcenter/i18n/translator"
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/dbconn"
+	notificationCore "gitlab.com/lightmeter/controlcenter/notification/core"
+	parser "gitlab.com/lightmeter/controlcenter/pkg/postfix/logparser"
+	insightsSettings "gitlab.com/lightmeter/controlcenter/settings/insights"
+	"gitlab.com/lightmeter/controlcenter/tracking"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+const (
+	ContentType   = "custom_rule"
+	ContentTypeId = 13
+)
+
+func init() {
+	core.RegisterContentType(ContentType, ContentTypeId, core.DefaultContentTypeDecoder(&Content{}))
+}
+
+type Content struct {
+	Rule     insightsSettings.CustomRule `json:"rule"`
+	Value    float64                     `json:"value"`
+	Interval timeutil.TimeInterval       `json:"interval"`
+}
+
+func (c Content) Title() notificationCore.ContentComponent {
+	return &title{c}
+}
+
+func (c Content) Description() notificationCore.ContentComponent {
+	return &description{c}
+}
+
+func (c Content) Metadata() notificationCore.ContentMetadata {
+	return nil
+}
+
+func (c Content) MuteParams() map[string]string {
+	return map[string]string{"rule": c.Rule.Name}
+}
+
+type title struct {
+	c Content
+}
+
+func (t title) String() string {
+	return translator.Stringfy(t)
+}
+
+func (t title) TplString() string {
+	return translator.I18n("Custom rule %v")
+}
+
+func (t title) Args() []interface{} {
+	return []interface{}{t.c.Rule.Name}
+}
+
+type description struct {
+	c Content
+}
+
+func (d description) String() string {
+	return translator.Stringfy(d)
+}
+
+func (d description) TplString() string {
+	return translator.I18n("The condition %v was met with %v between %v and %v")
+}
+
+func (d description) Args() []interface{} {
+	return []interface{}{Condition(d.c.Rule), formatValue(d.c.Rule, d.c.Value), d.c.Interval.From, d.c.Interval.To}
+}
+
+// Condition describes the rule, as in "deferred (domain example.com) > 50"
+func Condition(r insightsSettings.CustomRule) string {
+	metric := strings.ReplaceAll(string(r.Metric), "_", " ")
+
+	if r.Target != insightsSettings.AllTraffic {
+		metric = fmt.Sprintf("%s (%s %s)", metric, r.Target, r.Value)
+	}
+
+	return fmt.Sprintf("%s %s %s", metric, r.Operator, formatValue(r, r.Threshold))
+}
+
+func formatValue(r insightsSettings.CustomRule, v float64) string {
+	if r.Metric == insightsSettings.BounceRateMetric {
+		return fmt.Sprintf("%.1f%%", v)
+	}
+
+	return fmt.Sprintf("%v", v)
+}
+
+// how often each rule is evaluated, as the queries scan the deliveries in the window of the rule
+const checkInterval = time.Minute
+
+type Detector struct {
+	pool    *dbconn.RoPool
+	creator core.Creator
+
+	// protects rules, as they are updated when the settings change
+	mutex sync.Mutex
+	rules []insightsSettings.CustomRule
+
+	lastChecks map[string]time.Time
+}
+
+func (*Detector) IsHistoricalDetector() {
+	// Required by the historical import
+}
+
+func (*Detector) Close() error {
+	return nil
+}
+
+func (d *Detector) UpdateOptionsFromSettings(settings *insightsSettings.Settings) {
+	rules := make([]insightsSettings.CustomRule, 0, len(settings.CustomRules))
+
+	for _, r := range settings.CustomRules {
+		// they're validated before being stored, but are checked again, as it's cheap
+		if err := r.Validate(); err != nil {
+			log.Warn().Msgf("Ignoring invalid custom insight rule: %v", err)
+			continue
+		}
+
+		rules = append(rules, r)
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.rules = rules
+}
+
+func (d *Detector) Rules() []insightsSettings.CustomRule {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.rules
+}
+
+func NewDetector(settings *insightsSettings.Settings, creator core.Creator, options core.Options) core.Detector {
+	pool, ok := options["logsConnPool"].(*dbconn.RoPool)
+	if !ok {
+		errorutil.MustSucceed(errors.New("Invalid Connection Pool"))
+	}
+
+	detector := &Detector{
+		pool:       pool,
+		creator:    creator,
+		lastChecks: map[string]time.Time{},
+	}
+
+	detector.UpdateOptionsFromSettings(settings)
+
+	return detector
+}
+
+func addressCondition(localPartColumn, domainColumn, value string) (string, []interface{}) {
+	if i := strings.LastIndex(value, "@"); i >= 0 {
+		return fmt.Sprintf(`%s = ? collate nocase and %s = ? collate nocase`, localPartColumn, domainColumn), []interface{}{value[:i], value[i+1:]}
+	}
+
+	return fmt.Sprintf(`%s = ? collate nocase`, domainColumn), []interface{}{value}
+}
+
+func targetCondition(r insightsSettings.CustomRule) (string, []interface{}) {
+	const (
+		sender    = "d.sender_local_part"
+		recipient = "d.recipient_local_part"
+	)
+
+	inbound := r.Metric == insightsSettings.ReceivedMetric
+
+	switch {
+	case r.Target == insightsSettings.SenderTarget,
+		r.Target == insightsSettings.MailboxTarget && !inbound,
+		r.Target == insightsSettings.DomainTarget && inbound:
+		return addressCondition(sender, "sd.domain", r.Value)
+	case r.Target == insightsSettings.RecipientTarget,
+		r.Target == insightsSettings.MailboxTarget && inbound,
+		r.Target == insightsSettings.DomainTarget && !inbound:
+		return addressCondition(recipient, "rd.domain", r.Value)
+	}
+
+	return "1", nil
+}
+
+// metricStatus is the status of the deliveries counted by the metric.
+// Expired queues are not counted from the deliveries, but by evaluateExpired
+func metricStatus(m insightsSettings.RuleMetric) parser.SmtpStatus {
+	switch m {
+	case insightsSettings.SentMetric:
+		return parser.SentStatus
+	case insightsSettings.DeferredMetric:
+		return parser.DeferredStatus
+	case insightsSettings.ReceivedMetric:
+		// all of them are counted
+		return parser.SentStatus
+	}
+
+	return parser.BouncedStatus
+}
+
+// Evaluate computes the value of the metric of the rule in the interval
+func Evaluate(ctx context.Context, pool *dbconn.RoPool, r insightsSettings.CustomRule, interval timeutil.TimeInterval) (float64, error) {
+	conn, release, err := pool.AcquireContext(ctx)
+	if err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	defer release()
+
+	direction := tracking.MessageDirectionOutbound
+
+	if r.Metric == insightsSettings.ReceivedMetric {
+		direction = tracking.MessageDirectionIncoming
+	}
+
+	condition, conditionArgs := targetCondition(r)
+
+	if r.Metric == insightsSettings.ExpiredMetric {
+		return evaluateExpired(ctx, conn, condition, conditionArgs, interval)
+	}
+
+	query := `
+		select
+			count(*), coalesce(sum(d.status = ?), 0)
+		from
+			deliveries d
+			join remote_domains sd on sd.id = d.sender_domain_part_id
+			join remote_domains rd on rd.id = d.recipient_domain_part_id
+		where
+			d.delivery_ts between ? and ? and d.direction = ? and ` + condition
+
+	args := append([]interface{}{metricStatus(r.Metric), interval.From.Unix(), interval.To.Unix(), direction}, conditionArgs...)
+
+	var total, withStatus int64
+
+	if err := conn.QueryRowContext(ctx, query, args...).Scan(&total, &withStatus); err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	switch r.Metric {
+	case insightsSettings.ReceivedMetric:
+		return float64(total), nil
+	case insightsSettings.BounceRateMetric:
+		if total == 0 {
+			return 0, nil
+		}
+
+		return float64(withStatus) * 100 / float64(total), nil
+	}
+
+	return float64(withStatus), nil
+}
+
+// evaluateExpired counts the queues which expired in the interval. Expiring adds no delivery,
+// so the target condition is checked on the previous delivery attempts of each queue
+func evaluateExpired(ctx context.Context, conn *dbconn.RoPooledConn, condition string, conditionArgs []interface{}, interval timeutil.TimeInterval) (float64, error) {
+	query := `
+		select
+			count(*)
+		from
+			expired_queues eq
+		where
+			eq.expired_ts between ? and ? and exists(
+				select
+					*
+				from
+					delivery_queue dq
+					join deliveries d on d.id = dq.delivery_id
+					join remote_domains sd on sd.id = d.sender_domain_part_id
+					join remote_domains rd on rd.id = d.recipient_domain_part_id
+				where
+					dq.queue_id = eq.queue_id and d.direction = ? and ` + condition + `
+			)`
+
+	args := append([]interface{}{interval.From.Unix(), interval.To.Unix(), tracking.MessageDirectionOutbound}, conditionArgs...)
+
+	var count int64
+
+	if err := conn.QueryRowContext(ctx, query, args...).Scan(&count); err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	return float64(count), nil
+}
+
+func executionKind(r insightsSettings.CustomRule) string {
+	return "custom_rule_" + r.Name
+}
+
+func cooldown(r insightsSettings.CustomRule) time.Duration {
+	// an insight per window, otherwise a condition which holds for long would generate one on every check
+	if r.Cooldown == 0 {
+		return time.Minute * time.Duration(r.Window)
+	}
+
+	return time.Minute * time.Duration(r.Cooldown)
+}
+
+func (d *Detector) check(ctx context.Context, c core.Clock, tx *sql.Tx, r insightsSettings.CustomRule) error {
+	now := c.Now()
+
+	lastCheck, checked := d.lastChecks[r.Name]
+	if checked && now.Sub(lastCheck) < checkInterval {
+		return nil
+	}
+
+	d.lastChecks[r.Name] = now
+
+	lastGeneration, err := core.RetrieveLastDetectorExecution(tx, executionKind(r))
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if !lastGeneration.IsZero() && now.Sub(lastGeneration) < cooldown(r) {
+		return nil
+	}
+
+	interval := timeutil.TimeInterval{From: now.Add(-time.Minute * time.Duration(r.Window)), To: now}
+
+	value, err := Evaluate(ctx, d.pool, r, interval)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if !r.Operator.Compare(value, r.Threshold) {
+		return nil
+	}
+
+	if err := generateInsight(tx, c, d.creator, Content{Rule: r, Value: value, Interval: interval}); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if err := core.StoreLastDetectorExecution(tx, executionKind(r), now); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func (d *Detector) Step(c core.Clock, tx *sql.Tx) error {
+	ctx := context.Background()
+
+	for _, r := range d.Rules() {
+		if err := d.check(ctx, c, tx, r); err != nil {
+			return errorutil.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+func generateInsight(tx *sql.Tx, c core.Clock, creator core.Creator, content Content) error {
+	if err := creator.GenerateInsight(context.Background(), tx, BuildInsightProperties(c, content)); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func ratingFromPriority(priority string) core.Rating {
+	var rating core.Rating
+
+	b, err := json.Marshal(priority)
+	errorutil.MustSucceed(err)
+
+	if err := json.Unmarshal(b, &rating); err != nil {
+		return core.BadRating
+	}
+
+	return rating
+}
+
+func BuildInsightProperties(c core.Clock, content Content) core.InsightProperties {
+	return core.InsightProperties{
+		Time:        c.Now(),
+		Category:    core.BuildCategoryByName(content.Rule.Category),
+		Rating:      ratingFromPriority(content.Rule.Priority),
+		ContentType: ContentType,
+		Content:     content,
+	}
+}
+
+var SampleInsightContent = Content{
+	Rule: insightsSettings.CustomRule{
+		Name:      "Deferred to example.com",
+		Metric:    insightsSettings.DeferredMetric,
+		Target:    insightsSettings.DomainTarget,
+		Value:     "example.com",
+		Operator:  insightsSettings.GreaterThan,
+		Threshold: 50,
+		Window:    15,
+		Category:  "local",
+		Priority:  "bad",
+		Cooldown:  60,
+	},
+	Value: 63,
+	Interval: timeutil.TimeInterval{
+		From: timeutil.MustParseTime(`2000-01-01 00:00:00 +0000`),
+		To:   timeutil.MustParseTime(`2000-01-01 00:15:00 +0000`),
+	},
+}
@@ -0,0 +1,29 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+//go:build dev || !release
+// +build dev !release
+
+package customrules
+
+import (
+	"database/sql"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+// Executed only on development builds, for better developer experience
+func (d *Detector) GenerateSampleInsight(tx *sql.Tx, c core.Clock) error {
+	content := SampleInsightContent
+	content.Interval = timeutil.TimeInterval{From: c.Now().Add(-15 * time.Minute), To: c.Now()}
+
+	if err := generateInsight(tx, c, d.creator, content); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
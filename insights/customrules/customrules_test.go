@@ -0,0 +1,253 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package customrules
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gitlab.com/lightmeter/controlcenter/deliverydb"
+	"gitlab.com/lightmeter/controlcenter/domainmapping"
+	"gitlab.com/lightmeter/controlcenter/i18n/translator"
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	_ "gitlab.com/lightmeter/controlcenter/insights/migrations"
+	insighttestsutil "gitlab.com/lightmeter/controlcenter/insights/testutil"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3"
+	"gitlab.com/lightmeter/controlcenter/notification"
+	notificationCore "gitlab.com/lightmeter/controlcenter/notification/core"
+	parser "gitlab.com/lightmeter/controlcenter/pkg/postfix/logparser"
+	"gitlab.com/lightmeter/controlcenter/pkg/runner"
+	insightsSettings "gitlab.com/lightmeter/controlcenter/settings/insights"
+	"gitlab.com/lightmeter/controlcenter/tracking"
+	"gitlab.com/lightmeter/controlcenter/util/testutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+func init() {
+	lmsqlite3.Initialize(lmsqlite3.Options{})
+}
+
+func buildResult(t time.Time, status parser.SmtpStatus, direction tracking.MessageDirection, sender, recipient string, id int64) tracking.Result {
+	result := tracking.Result{}
+	result[tracking.ResultDeliveryTimeKey] = tracking.ResultEntryInt64(t.Unix())
+	result[tracking.QueueSenderLocalPartKey] = tracking.ResultEntryText("sender")
+	result[tracking.QueueSenderDomainPartKey] = tracking.ResultEntryText(sender)
+	result[tracking.ResultRecipientLocalPartKey] = tracking.ResultEntryText("recipient")
+	result[tracking.ResultRecipientDomainPartKey] = tracking.ResultEntryText(recipient)
+	result[tracking.ResultStatusKey] = tracking.ResultEntryInt64(int64(status))
+	result[tracking.ResultMessageDirectionKey] = tracking.ResultEntryInt64(int64(direction))
+	result[tracking.QueueMessageIDKey] = tracking.ResultEntryText("msgid")
+	result[tracking.QueueOriginalMessageSizeKey] = tracking.ResultEntryInt64(35)
+	result[tracking.QueueProcessedMessageSizeKey] = tracking.ResultEntryInt64(42)
+	result[tracking.QueueNRCPTKey] = tracking.ResultEntryInt64(1)
+	result[tracking.ResultDeliveryServerKey] = tracking.ResultEntryText("mail")
+	result[tracking.ResultDelayKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDelaySMTPDKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDelayCleanupKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDelayQmgrKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDelaySMTPKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDSNKey] = tracking.ResultEntryText("2.0.0")
+	result[tracking.QueueBeginKey] = tracking.ResultEntryInt64(0)
+	result[tracking.QueueDeliveryNameKey] = tracking.ResultEntryText("A1")
+	result[tracking.ResultDeliveryLineChecksum] = tracking.ResultEntryInt64(id)
+
+	return result
+}
+
+func TestCustomRules(t *testing.T) {
+	Convey("Custom rules", t, func() {
+		conn, closeConn := testutil.TempDBConnectionMigrated(t, "logs")
+		defer closeConn()
+
+		db, err := deliverydb.New(conn, &domainmapping.DefaultMapping, deliverydb.Options{RetentionDuration: time.Hour * 24 * 30})
+		So(err, ShouldBeNil)
+
+		done, cancel := runner.Run(db)
+		pub := db.ResultsPublisher()
+
+		accessor, clear := insighttestsutil.NewFakeAccessor(t)
+		defer clear()
+
+		baseTime := testutil.MustParseTime(`2000-01-01 00:00:00 +0000`)
+
+		bouncedRule := insightsSettings.CustomRule{
+			Name:      "Bounces to example.com",
+			Metric:    insightsSettings.BouncedMetric,
+			Target:    insightsSettings.DomainTarget,
+			Value:     "example.com",
+			Operator:  insightsSettings.GreaterThan,
+			Threshold: 2,
+			Window:    60,
+			Cooldown:  120,
+		}
+
+		bounceRateRule := insightsSettings.CustomRule{
+			Name:      "High bounce rate",
+			Metric:    insightsSettings.BounceRateMetric,
+			Operator:  insightsSettings.GreaterThanOrEqual,
+			Threshold: 50,
+			Window:    60,
+			Category:  "intel",
+			Priority:  "ok",
+		}
+
+		receivedRule := insightsSettings.CustomRule{
+			Name:      "Nothing from partner.com",
+			Metric:    insightsSettings.ReceivedMetric,
+			Target:    insightsSettings.DomainTarget,
+			Value:     "partner.com",
+			Operator:  insightsSettings.Equal,
+			Threshold: 0,
+			Window:    60,
+		}
+
+		rules := []insightsSettings.CustomRule{bouncedRule, bounceRateRule, receivedRule}
+		So(insightsSettings.ValidateCustomRules(rules), ShouldBeNil)
+
+		detector := NewDetector(&insightsSettings.Settings{CustomRules: rules}, accessor, core.Options{"logsConnPool": conn.RoConnPool})
+
+		cycle := func(c *insighttestsutil.FakeClock) {
+			tx, err := accessor.ConnPair.RwConn.Begin()
+			So(err, ShouldBeNil)
+			So(detector.Step(c, tx), ShouldBeNil)
+			So(tx.Commit(), ShouldBeNil)
+		}
+
+		outbound, inbound := tracking.MessageDirectionOutbound, tracking.MessageDirectionIncoming
+
+		pub.Publish(buildResult(baseTime.Add(10*time.Minute), parser.BouncedStatus, outbound, "local.com", "example.com", 1))
+		pub.Publish(buildResult(baseTime.Add(11*time.Minute), parser.BouncedStatus, outbound, "local.com", "EXAMPLE.com", 2))
+		pub.Publish(buildResult(baseTime.Add(12*time.Minute), parser.BouncedStatus, outbound, "local.com", "example.com", 3))
+		pub.Publish(buildResult(baseTime.Add(13*time.Minute), parser.BouncedStatus, outbound, "local.com", "other.com", 4))
+		pub.Publish(buildResult(baseTime.Add(14*time.Minute), parser.SentStatus, outbound, "local.com", "example.com", 5))
+		pub.Publish(buildResult(baseTime.Add(15*time.Minute), parser.SentStatus, inbound, "partner.com", "local.com", 6))
+
+		// deferred before the window, and expired in it
+		deferred := buildResult(baseTime.Add(-2*time.Hour), parser.DeferredStatus, outbound, "local.com", "example.com", 7)
+		deferred[tracking.QueueDeliveryNameKey] = tracking.ResultEntryText("B2")
+		pub.Publish(deferred)
+
+		expired := buildResult(baseTime.Add(20*time.Minute), parser.ExpiredStatus, outbound, "local.com", "example.com", 8)
+		expired[tracking.QueueDeliveryNameKey] = tracking.ResultEntryText("B2")
+		expired[tracking.MessageExpiredTime] = tracking.ResultEntryInt64(baseTime.Add(20 * time.Minute).Unix())
+		pub.Publish(expired)
+
+		cancel()
+		So(done(), ShouldBeNil)
+
+		Convey("Rules are evaluated against the deliveries", func() {
+			value, err := Evaluate(context.Background(), conn.RoConnPool, bouncedRule, timeutil.TimeInterval{From: baseTime, To: baseTime.Add(time.Hour)})
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, 3)
+
+			value, err = Evaluate(context.Background(), conn.RoConnPool, bounceRateRule, timeutil.TimeInterval{From: baseTime, To: baseTime.Add(time.Hour)})
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, 80)
+
+			value, err = Evaluate(context.Background(), conn.RoConnPool, receivedRule, timeutil.TimeInterval{From: baseTime, To: baseTime.Add(time.Hour)})
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, 1)
+
+			mailboxRule := insightsSettings.CustomRule{Metric: insightsSettings.SentMetric, Target: insightsSettings.MailboxTarget, Value: "sender@local.com"}
+			value, err = Evaluate(context.Background(), conn.RoConnPool, mailboxRule, timeutil.TimeInterval{From: baseTime, To: baseTime.Add(time.Hour)})
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, 1)
+		})
+
+		Convey("Insights are generated respecting the cooldown of each rule", func() {
+			clock := &insighttestsutil.FakeClock{Time: baseTime.Add(30 * time.Minute)}
+
+			// both outbound rules match, but something was received from partner.com
+			cycle(clock)
+			So(accessor.Insights, ShouldResemble, []int64{1, 2})
+
+			// in the cooldown
+			clock.Sleep(time.Minute)
+			cycle(clock)
+			So(accessor.Insights, ShouldResemble, []int64{1, 2})
+
+			// nothing was bounced in the window, but nothing was received from partner.com either
+			clock.Sleep(3 * time.Hour)
+			cycle(clock)
+			So(accessor.Insights, ShouldResemble, []int64{1, 2, 3})
+
+			insights, err := accessor.FetchInsights(context.Background(), core.FetchOptions{
+				Interval: timeutil.TimeInterval{From: baseTime, To: baseTime.Add(time.Hour * 24)},
+				OrderBy:  core.OrderByCreationAsc,
+			}, clock)
+			So(err, ShouldBeNil)
+			So(len(insights), ShouldEqual, 3)
+
+			So(insights[0].ContentType(), ShouldEqual, ContentType)
+			So(insights[0].Category(), ShouldEqual, core.LocalCategory)
+			So(insights[0].Rating(), ShouldEqual, core.BadRating)
+			So(insights[0].Content(), ShouldResemble, &Content{
+				Rule:     rules[0],
+				Value:    3,
+				Interval: timeutil.TimeInterval{From: baseTime.Add(-30 * time.Minute), To: baseTime.Add(30 * time.Minute)},
+			})
+
+			So(insights[1].Category(), ShouldEqual, core.IntelCategory)
+			So(insights[1].Rating(), ShouldEqual, core.OkRating)
+			So(insights[1].Content().(*Content).Value, ShouldEqual, 80)
+
+			So(insights[2].Content().(*Content).Rule.Name, ShouldEqual, "Nothing from partner.com")
+		})
+
+		Convey("Expired queues are counted", func() {
+			expiredRule := insightsSettings.CustomRule{
+				Name:      "Expired to example.com",
+				Metric:    insightsSettings.ExpiredMetric,
+				Target:    insightsSettings.DomainTarget,
+				Value:     "example.com",
+				Operator:  insightsSettings.GreaterThanOrEqual,
+				Threshold: 1,
+				Window:    60,
+			}
+
+			value, err := Evaluate(context.Background(), conn.RoConnPool, expiredRule, timeutil.TimeInterval{From: baseTime, To: baseTime.Add(time.Hour)})
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, 1)
+
+			otherDomainRule := expiredRule
+			otherDomainRule.Value = "other.com"
+
+			value, err = Evaluate(context.Background(), conn.RoConnPool, otherDomainRule, timeutil.TimeInterval{From: baseTime, To: baseTime.Add(time.Hour)})
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, 0)
+
+			detector.(core.DetectorWithSettings).UpdateOptionsFromSettings(&insightsSettings.Settings{CustomRules: []insightsSettings.CustomRule{expiredRule}})
+
+			cycle(&insighttestsutil.FakeClock{Time: baseTime.Add(30 * time.Minute)})
+			So(accessor.Insights, ShouldResemble, []int64{1})
+
+			cycle(&insighttestsutil.FakeClock{Time: baseTime.Add(3 * time.Hour)})
+			So(accessor.Insights, ShouldResemble, []int64{1})
+		})
+
+		Convey("Rules are updated from the settings", func() {
+			detector.(core.DetectorWithSettings).UpdateOptionsFromSettings(&insightsSettings.Settings{})
+
+			cycle(&insighttestsutil.FakeClock{Time: baseTime.Add(30 * time.Minute)})
+			So(accessor.Insights, ShouldResemble, []int64{})
+		})
+	})
+}
+
+func TestDescriptionFormatting(t *testing.T) {
+	Convey("Description Formatting", t, func() {
+		n := notification.Notification{ID: 1, Content: SampleInsightContent}
+
+		m, err := notificationCore.TranslateNotification(n, translator.DummyTranslator{})
+		So(err, ShouldBeNil)
+		So(m, ShouldResemble, notificationCore.Message{
+			Title:       "Custom rule Deferred to example.com",
+			Description: "The condition deferred (domain example.com) > 50 was met with 63 between 2000-01-01 00:00:00 +0000 UTC and 2000-01-01 00:15:00 +0000 UTC",
+			Metadata:    map[string]string{},
+		})
+	})
+}
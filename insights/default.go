@@ -8,13 +8,21 @@ package insights
 import (
 	"gitlab.com/lightmeter/controlcenter/insights/blockedips"
 	"gitlab.com/lightmeter/controlcenter/insights/blockedipssummary"
+	"gitlab.com/lightmeter/controlcenter/insights/compromisedaccount"
 	"gitlab.com/lightmeter/controlcenter/insights/core"
+	"gitlab.com/lightmeter/controlcenter/insights/customrules"
 	"gitlab.com/lightmeter/controlcenter/insights/detectiveescalation"
+	"gitlab.com/lightmeter/controlcenter/insights/directoryharvest"
+	"gitlab.com/lightmeter/controlcenter/insights/domainrbl"
 	"gitlab.com/lightmeter/controlcenter/insights/highrate"
 	"gitlab.com/lightmeter/controlcenter/insights/localrbl"
 	"gitlab.com/lightmeter/controlcenter/insights/mailinactivity"
 	"gitlab.com/lightmeter/controlcenter/insights/messagerbl"
 	"gitlab.com/lightmeter/controlcenter/insights/newsfeed"
+	"gitlab.com/lightmeter/controlcenter/insights/providerrate"
+	"gitlab.com/lightmeter/controlcenter/insights/savedsearch"
+	"gitlab.com/lightmeter/controlcenter/insights/stuckqueues"
+	"gitlab.com/lightmeter/controlcenter/insights/trafficanomaly"
 	"gitlab.com/lightmeter/controlcenter/insights/welcome"
 	"gitlab.com/lightmeter/controlcenter/lmsqlite3/dbconn"
 	"gitlab.com/lightmeter/controlcenter/metadata"
@@ -31,6 +39,7 @@ func SettingsDetectors(settings *insightsSettings.Settings, creator *creator, op
 	return []core.Detector{
 		highrate.NewDetector(settings, creator, options),
 		mailinactivity.NewDetector(settings, creator, options),
+		customrules.NewDetector(settings, creator, options),
 	}
 }
 
@@ -38,6 +47,7 @@ func defaultDetectors(settings *insightsSettings.Settings, creator *creator, opt
 	return []core.Detector{
 		highrate.NewDetector(settings, creator, options),
 		mailinactivity.NewDetector(settings, creator, options),
+		customrules.NewDetector(settings, creator, options),
 		welcome.NewDetector(creator),
 		localrblinsight.NewDetector(creator, options),
 		messagerblinsight.NewDetector(creator, options),
@@ -45,6 +55,13 @@ func defaultDetectors(settings *insightsSettings.Settings, creator *creator, opt
 		detectiveescalation.NewDetector(creator, options),
 		blockedips.NewDetector(creator, options),
 		blockedipssummary.NewDetector(creator, options),
+		savedsearch.NewDetector(creator, options),
+		trafficanomaly.NewDetector(creator, options),
+		providerrate.NewDetector(creator, options),
+		stuckqueues.NewDetector(settings, creator, options),
+		compromisedaccount.NewDetector(creator, options),
+		directoryharvest.NewDetector(creator, options),
+		domainrbl.NewDetector(settings, creator, options),
 	}
 }
 
@@ -37,8 +37,10 @@ func init() {
 type Content struct {
 	Sender    string                `json:"sender"`
 	Recipient string                `json:"recipient"`
+	SomeID    string                `json:"some_id,omitempty"`
 	Interval  timeutil.TimeInterval `json:"time_interval"`
 	Messages  detective.Messages    `json:"messages"`
+	TicketID  int64                 `json:"ticket_id,omitempty"`
 }
 
 func (c Content) Title() notificationCore.ContentComponent {
@@ -53,6 +55,10 @@ func (c Content) Metadata() notificationCore.ContentMetadata {
 	return nil
 }
 
+func (c Content) MuteParams() map[string]string {
+	return map[string]string{"sender": c.Sender, "recipient": c.Recipient}
+}
+
 type title struct {
 	c Content
 }
@@ -134,6 +140,7 @@ func maybeAddNewInsightFromMessage(d *detector, r escalator.Request, c core.Cloc
 	content := Content{
 		Sender:    r.Sender,
 		Recipient: r.Recipient,
+		SomeID:    r.SomeID,
 		Interval:  r.Interval,
 		Messages:  r.Messages,
 	}
@@ -166,6 +173,13 @@ func maybeAddNewInsightFromMessage(d *detector, r escalator.Request, c core.Cloc
 		return errorutil.Wrap(err)
 	}
 
+	ticketID, err := createTicket(tx, content, c.Now())
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	content.TicketID = ticketID
+
 	if err := generateInsight(tx, c, d.creator, content); err != nil {
 		return errorutil.Wrap(err)
 	}
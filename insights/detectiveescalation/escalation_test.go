@@ -7,9 +7,12 @@ package detectiveescalation
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	. "github.com/smartystreets/goconvey/convey"
 	"gitlab.com/lightmeter/controlcenter/detective"
 	"gitlab.com/lightmeter/controlcenter/detective/escalator"
+	"gitlab.com/lightmeter/controlcenter/erasure"
 	"gitlab.com/lightmeter/controlcenter/i18n/translator"
 	"gitlab.com/lightmeter/controlcenter/insights/core"
 	insighttestsutil "gitlab.com/lightmeter/controlcenter/insights/testutil"
@@ -189,6 +192,7 @@ func TestDetectiveEscalation(t *testing.T) {
 						},
 					},
 				},
+				TicketID: 1,
 			})
 		}
 
@@ -217,8 +221,138 @@ func TestDetectiveEscalation(t *testing.T) {
 						},
 					},
 				},
+				TicketID: 2,
 			})
 		}
+
+		Convey("Escalations are tickets", func() {
+			tx := func(f func(*sql.Tx) error) error {
+				return accessor.ConnPair.RwConn.Tx(dummyContext, func(ctx context.Context, tx *sql.Tx) error {
+					return f(tx)
+				})
+			}
+
+			tickets, err := FetchTickets(dummyContext, accessor.ConnPair.RoConnPool, nil)
+			So(err, ShouldBeNil)
+			So(len(tickets), ShouldEqual, 2)
+
+			// most recent first
+			So(tickets[0].ID, ShouldEqual, 2)
+			So(tickets[0].Status, ShouldEqual, OpenStatus)
+			So(tickets[0].Sender, ShouldEqual, "sender2@example.com")
+			So(tickets[0].CreatedAt, ShouldEqual, baseTime.Add(time.Second*10))
+			So(tickets[0].Messages, ShouldResemble, requests[baseTime.Add(time.Second*10)].Messages)
+			So(tickets[0].DetectiveLink, ShouldEqual, "#/detective?from=2000-05-04&mail_from=sender2%40example.com&mail_to=recipient2%40example.com&to=2000-05-04")
+
+			updateTime := baseTime.Add(time.Hour)
+
+			Convey("Invalid updates", func() {
+				invalid := TicketStatus("closed")
+				So(errors.Is(tx(func(tx *sql.Tx) error {
+					return UpdateTicketAndNotify(tx, accessor, 1, TicketUpdate{Status: &invalid}, updateTime)
+				}), ErrInvalidTicketUpdate), ShouldBeTrue)
+
+				So(errors.Is(tx(func(tx *sql.Tx) error {
+					return CommentTicket(tx, 1, "Alice", "   ", updateTime)
+				}), ErrInvalidTicketUpdate), ShouldBeTrue)
+
+				So(errors.Is(tx(func(tx *sql.Tx) error {
+					return CommentTicket(tx, 42, "Alice", "Some comment", updateTime)
+				}), ErrNoSuchTicket), ShouldBeTrue)
+
+				_, err := FetchTicket(dummyContext, accessor.ConnPair.RoConnPool, 42)
+				So(errors.Is(err, ErrNoSuchTicket), ShouldBeTrue)
+			})
+
+			Convey("Assign, comment and resolve", func() {
+				assignee := "Alice"
+				investigating := InvestigatingStatus
+
+				So(tx(func(tx *sql.Tx) error {
+					return UpdateTicketAndNotify(tx, accessor, 1, TicketUpdate{Assignee: &assignee}, updateTime)
+				}), ShouldBeNil)
+
+				So(accessor.Insights, ShouldResemble, []int64{1, 2, 3})
+
+				// assigning to the same person again does not notify
+				So(tx(func(tx *sql.Tx) error {
+					return UpdateTicketAndNotify(tx, accessor, 1, TicketUpdate{Assignee: &assignee}, updateTime)
+				}), ShouldBeNil)
+
+				So(accessor.Insights, ShouldResemble, []int64{1, 2, 3})
+
+				So(tx(func(tx *sql.Tx) error {
+					return CommentTicket(tx, 1, "Alice", "Recipient server is down", updateTime)
+				}), ShouldBeNil)
+
+				So(tx(func(tx *sql.Tx) error {
+					return UpdateTicketAndNotify(tx, accessor, 1, TicketUpdate{Status: &investigating}, updateTime.Add(time.Minute))
+				}), ShouldBeNil)
+
+				So(accessor.Insights, ShouldResemble, []int64{1, 2, 3, 4})
+
+				ticket, err := FetchTicket(dummyContext, accessor.ConnPair.RoConnPool, 1)
+				So(err, ShouldBeNil)
+				So(ticket.Status, ShouldEqual, InvestigatingStatus)
+				So(ticket.Assignee, ShouldEqual, "Alice")
+				So(ticket.UpdatedAt, ShouldEqual, updateTime.Add(time.Minute))
+				So(ticket.Comments, ShouldResemble, []Comment{{ID: 1, Time: updateTime, Author: "Alice", Content: "Recipient server is down"}})
+
+				status := InvestigatingStatus
+				tickets, err := FetchTickets(dummyContext, accessor.ConnPair.RoConnPool, &status)
+				So(err, ShouldBeNil)
+				So(len(tickets), ShouldEqual, 1)
+				So(tickets[0].ID, ShouldEqual, 1)
+
+				insights, err := accessor.FetchInsights(dummyContext, core.FetchOptions{Interval: timeutil.TimeInterval{
+					From: testutil.MustParseTime(`0000-01-01 00:00:00 +0000`),
+					To:   testutil.MustParseTime(`4000-01-01 00:00:00 +0000`),
+				}, OrderBy: core.OrderByCreationAsc}, clock)
+
+				So(err, ShouldBeNil)
+				So(insights[2].ContentType(), ShouldEqual, UpdateContentType)
+				So(insights[2].Content(), ShouldResemble, &UpdateContent{
+					TicketID:        1,
+					Status:          OpenStatus,
+					Assignee:        "Alice",
+					Sender:          "sender1@example.com",
+					Recipient:       "recipient1@example.com",
+					AssigneeChanged: true,
+				})
+
+				So(insights[3].ContentType(), ShouldEqual, UpdateContentType)
+				So(insights[3].Content(), ShouldResemble, &UpdateContent{
+					TicketID:      1,
+					Status:        InvestigatingStatus,
+					Assignee:      "Alice",
+					Sender:        "sender1@example.com",
+					Recipient:     "recipient1@example.com",
+					StatusChanged: true,
+				})
+
+				Convey("Erase tickets mentioning an address", func() {
+					target, err := erasure.ParseTarget("recipient1@example.com")
+					So(err, ShouldBeNil)
+
+					var entries erasure.Entries
+
+					So(tx(func(tx *sql.Tx) error {
+						entries, err = EraseTickets(tx, target)
+						return err
+					}), ShouldBeNil)
+
+					So(entries, ShouldResemble, erasure.Entries{
+						{Database: "insights", Table: "escalation_tickets", Action: erasure.DeletedAction, Count: 1},
+						{Database: "insights", Table: "escalation_comments", Action: erasure.DeletedAction, Count: 1},
+					})
+
+					tickets, err := FetchTickets(dummyContext, accessor.ConnPair.RoConnPool, nil)
+					So(err, ShouldBeNil)
+					So(len(tickets), ShouldEqual, 1)
+					So(tickets[0].ID, ShouldEqual, 2)
+				})
+			})
+		})
 	})
 }
 
@@ -255,4 +389,25 @@ func TestDescriptionFormatting(t *testing.T) {
 			Metadata:    map[string]string{},
 		})
 	})
+
+	Convey("Update Formatting", t, func() {
+		format := func(c UpdateContent) string {
+			c.TicketID, c.Sender, c.Recipient = 3, "sender2@example.com", "recipient2@example.com"
+
+			m, err := notificationCore.TranslateNotification(notification.Notification{ID: 1, Content: c}, translator.DummyTranslator{})
+			So(err, ShouldBeNil)
+			So(m.Description, ShouldEqual, "Sender: sender2@example.com, recipient: recipient2@example.com")
+
+			return m.Title
+		}
+
+		// stored before assignee changes were notified
+		So(format(UpdateContent{Status: ResolvedStatus}), ShouldEqual, "Escalation #3 is now resolved")
+
+		So(format(UpdateContent{Status: ResolvedStatus, StatusChanged: true}), ShouldEqual, "Escalation #3 is now resolved")
+		So(format(UpdateContent{Status: OpenStatus, Assignee: "Alice", AssigneeChanged: true}), ShouldEqual, "Escalation #3 is now assigned to Alice")
+		So(format(UpdateContent{Status: OpenStatus, AssigneeChanged: true}), ShouldEqual, "Escalation #3 is no longer assigned")
+		So(format(UpdateContent{Status: InvestigatingStatus, Assignee: "Alice", StatusChanged: true, AssigneeChanged: true}), ShouldEqual, "Escalation #3 is now investigating and assigned to Alice")
+		So(format(UpdateContent{Status: ResolvedStatus, StatusChanged: true, AssigneeChanged: true}), ShouldEqual, "Escalation #3 is now resolved and no longer assigned")
+	})
 }
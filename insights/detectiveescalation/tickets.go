@@ -0,0 +1,373 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package detectiveescalation
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/detective"
+	"gitlab.com/lightmeter/controlcenter/erasure"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/dbconn"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+type TicketStatus string
+
+const (
+	OpenStatus          TicketStatus = "open"
+	InvestigatingStatus TicketStatus = "investigating"
+	ResolvedStatus      TicketStatus = "resolved"
+)
+
+func ParseTicketStatus(s string) (TicketStatus, error) {
+	switch status := TicketStatus(s); status {
+	case OpenStatus, InvestigatingStatus, ResolvedStatus:
+		return status, nil
+	}
+
+	return "", fmt.Errorf("Invalid status %v: %w", s, ErrInvalidTicketUpdate)
+}
+
+var (
+	ErrNoSuchTicket        = errors.New(`No such escalation ticket`)
+	ErrInvalidTicketUpdate = errors.New(`Invalid escalation ticket update`)
+)
+
+type Comment struct {
+	ID      int64     `json:"id"`
+	Time    time.Time `json:"time"`
+	Author  string    `json:"author"`
+	Content string    `json:"content"`
+}
+
+// Ticket is an escalation followed up by the postmasters
+type Ticket struct {
+	ID        int64                 `json:"id"`
+	CreatedAt time.Time             `json:"created_at"`
+	UpdatedAt time.Time             `json:"updated_at"`
+	Status    TicketStatus          `json:"status"`
+	Assignee  string                `json:"assignee"`
+	Sender    string                `json:"sender"`
+	Recipient string                `json:"recipient"`
+	SomeID    string                `json:"some_id"`
+	Interval  timeutil.TimeInterval `json:"time_interval"`
+	Messages  detective.Messages    `json:"messages"`
+	Comments  []Comment             `json:"comments"`
+
+	// DetectiveLink is the address, relative to the public URL, of the detective query that originated the escalation
+	DetectiveLink string `json:"detective_link"`
+}
+
+func detectiveLink(sender, recipient, someID string, interval timeutil.TimeInterval) string {
+	const dateFormat = "2006-01-02"
+
+	values := url.Values{
+		"mail_from": {sender},
+		"mail_to":   {recipient},
+		"from":      {interval.From.Format(dateFormat)},
+		"to":        {interval.To.Format(dateFormat)},
+	}
+
+	if len(someID) > 0 {
+		values.Set("some_id", someID)
+	}
+
+	return "#/detective?" + values.Encode()
+}
+
+// TicketUpdate is a change on a ticket. Nil fields are not changed
+type TicketUpdate struct {
+	Status   *TicketStatus
+	Assignee *string
+}
+
+// TicketManager allows following up the escalation tickets
+type TicketManager interface {
+	EscalationTickets(ctx context.Context, status *TicketStatus) ([]Ticket, error)
+	EscalationTicket(ctx context.Context, id int64) (Ticket, error)
+	UpdateEscalationTicket(ctx context.Context, id int64, update TicketUpdate) error
+	CommentEscalationTicket(ctx context.Context, id int64, author, content string) error
+}
+
+func createTicket(tx *sql.Tx, content Content, now time.Time) (int64, error) {
+	messages, err := json.Marshal(content.Messages)
+	if err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	result, err := tx.Exec(`
+		insert into escalation_tickets(
+			creation_ts, update_ts, status, assignee, sender, recipient, some_id, interval_from_ts, interval_to_ts, messages
+		) values(?, ?, ?, '', ?, ?, ?, ?, ?, ?)`,
+		now.Unix(), now.Unix(), OpenStatus, content.Sender, content.Recipient, content.SomeID,
+		content.Interval.From.Unix(), content.Interval.To.Unix(), messages)
+	if err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	return id, nil
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+const ticketColumns = `id, creation_ts, update_ts, status, assignee, sender, recipient, some_id, interval_from_ts, interval_to_ts, messages`
+
+type scanner interface {
+	Scan(...interface{}) error
+}
+
+func scanTicket(row scanner) (Ticket, error) {
+	var (
+		t                    Ticket
+		creationTs, updateTs int64
+		fromTs, toTs         int64
+		messages             []byte
+	)
+
+	if err := row.Scan(&t.ID, &creationTs, &updateTs, &t.Status, &t.Assignee, &t.Sender, &t.Recipient, &t.SomeID, &fromTs, &toTs, &messages); err != nil {
+		return Ticket{}, errorutil.Wrap(err)
+	}
+
+	if err := json.Unmarshal(messages, &t.Messages); err != nil {
+		return Ticket{}, errorutil.Wrap(err)
+	}
+
+	t.CreatedAt = time.Unix(creationTs, 0).In(time.UTC)
+	t.UpdatedAt = time.Unix(updateTs, 0).In(time.UTC)
+	t.Interval = timeutil.TimeInterval{From: time.Unix(fromTs, 0).In(time.UTC), To: time.Unix(toTs, 0).In(time.UTC)}
+	t.DetectiveLink = detectiveLink(t.Sender, t.Recipient, t.SomeID, t.Interval)
+	t.Comments = []Comment{}
+
+	return t, nil
+}
+
+func fetchTicket(ctx context.Context, q queryRower, id int64) (Ticket, error) {
+	t, err := scanTicket(q.QueryRowContext(ctx, `select `+ticketColumns+` from escalation_tickets where id = ?`, id))
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		return Ticket{}, ErrNoSuchTicket
+	}
+
+	if err != nil {
+		return Ticket{}, errorutil.Wrap(err)
+	}
+
+	return t, nil
+}
+
+// FetchTickets returns all the tickets, the most recent first, optionally only the ones with a given status.
+// Comments are not returned.
+func FetchTickets(ctx context.Context, pool *dbconn.RoPool, status *TicketStatus) (tickets []Ticket, err error) {
+	conn, release, err := pool.AcquireContext(ctx)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer release()
+
+	query := `select ` + ticketColumns + ` from escalation_tickets`
+	args := []interface{}{}
+
+	if status != nil {
+		query += ` where status = ?`
+		args = append(args, *status)
+	}
+
+	rows, err := conn.QueryContext(ctx, query+` order by id desc`, args...)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	tickets = []Ticket{}
+
+	for rows.Next() {
+		t, err := scanTicket(rows)
+		if err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		tickets = append(tickets, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return tickets, nil
+}
+
+// FetchTicket returns a ticket with all its comments
+func FetchTicket(ctx context.Context, pool *dbconn.RoPool, id int64) (ticket Ticket, err error) {
+	conn, release, err := pool.AcquireContext(ctx)
+	if err != nil {
+		return Ticket{}, errorutil.Wrap(err)
+	}
+
+	defer release()
+
+	ticket, err = fetchTicket(ctx, conn, id)
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	rows, err := conn.QueryContext(ctx, `select id, creation_ts, author, content from escalation_comments where ticket_id = ? order by id`, id)
+	if err != nil {
+		return Ticket{}, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	for rows.Next() {
+		var (
+			c  Comment
+			ts int64
+		)
+
+		if err := rows.Scan(&c.ID, &ts, &c.Author, &c.Content); err != nil {
+			return Ticket{}, errorutil.Wrap(err)
+		}
+
+		c.Time = time.Unix(ts, 0).In(time.UTC)
+
+		ticket.Comments = append(ticket.Comments, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return Ticket{}, errorutil.Wrap(err)
+	}
+
+	return ticket, nil
+}
+
+// UpdateTicket changes a ticket, returning it as it was before the change
+func UpdateTicket(tx *sql.Tx, id int64, update TicketUpdate, now time.Time) (Ticket, error) {
+	before, err := fetchTicket(context.Background(), tx, id)
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	status, assignee := before.Status, before.Assignee
+
+	if update.Status != nil {
+		if status, err = ParseTicketStatus(string(*update.Status)); err != nil {
+			return Ticket{}, err
+		}
+	}
+
+	if update.Assignee != nil {
+		assignee = strings.TrimSpace(*update.Assignee)
+	}
+
+	if _, err := tx.Exec(`update escalation_tickets set status = ?, assignee = ?, update_ts = ? where id = ?`, status, assignee, now.Unix(), id); err != nil {
+		return Ticket{}, errorutil.Wrap(err)
+	}
+
+	return before, nil
+}
+
+func CommentTicket(tx *sql.Tx, id int64, author, content string, now time.Time) error {
+	content = strings.TrimSpace(content)
+
+	if len(content) == 0 {
+		return fmt.Errorf("Empty comment: %w", ErrInvalidTicketUpdate)
+	}
+
+	if _, err := fetchTicket(context.Background(), tx, id); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`insert into escalation_comments(ticket_id, creation_ts, author, content) values(?, ?, ?, ?)`, id, now.Unix(), author, content); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if _, err := tx.Exec(`update escalation_tickets set update_ts = ? where id = ?`, now.Unix(), id); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+// EraseTickets deletes the tickets, and their comments, mentioning target
+func EraseTickets(tx *sql.Tx, target erasure.Target) (entries erasure.Entries, err error) {
+	//nolint:sqlclosecheck
+	rows, err := tx.Query(`
+		select
+			t.id, t.sender || ' ' || t.recipient || ' ' || t.some_id || ' ' || t.messages || ' ' || coalesce(group_concat(c.content, ' '), '')
+		from
+			escalation_tickets t
+		left join
+			escalation_comments c on c.ticket_id = t.id
+		group by
+			t.id`)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	ids := []int64{}
+
+	matcher := target.Matcher()
+
+	for rows.Next() {
+		var (
+			id      int64
+			content string
+		)
+
+		if err := rows.Scan(&id, &content); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		if matcher.Match(content) {
+			ids = append(ids, id)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	var commentsCount int64
+
+	for _, id := range ids {
+		r, err := tx.Exec(`delete from escalation_comments where ticket_id = ?`, id)
+		if err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		n, err := erasure.CountAffected(r)
+		if err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		commentsCount += n
+
+		if _, err := tx.Exec(`delete from escalation_tickets where id = ?`, id); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+	}
+
+	return erasure.Entries{
+		{Database: "insights", Table: "escalation_tickets", Action: erasure.DeletedAction, Count: int64(len(ids))},
+		{Database: "insights", Table: "escalation_comments", Action: erasure.DeletedAction, Count: commentsCount},
+	}, nil
+}
@@ -0,0 +1,133 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package detectiveescalation
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/i18n/translator"
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	notificationCore "gitlab.com/lightmeter/controlcenter/notification/core"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+const (
+	UpdateContentType   = "detective_escalation_update"
+	UpdateContentTypeId = 12
+)
+
+func init() {
+	core.RegisterContentType(UpdateContentType, UpdateContentTypeId, core.DefaultContentTypeDecoder(&UpdateContent{}))
+}
+
+// UpdateContent informs that an escalation ticket changed its status or assignee
+type UpdateContent struct {
+	TicketID        int64        `json:"ticket_id"`
+	Status          TicketStatus `json:"status"`
+	Assignee        string       `json:"assignee"`
+	Sender          string       `json:"sender"`
+	Recipient       string       `json:"recipient"`
+	StatusChanged   bool         `json:"status_changed,omitempty"`
+	AssigneeChanged bool         `json:"assignee_changed,omitempty"`
+}
+
+func (c UpdateContent) Title() notificationCore.ContentComponent {
+	return &updateTitle{c}
+}
+
+func (c UpdateContent) Description() notificationCore.ContentComponent {
+	return &description{Content{Sender: c.Sender, Recipient: c.Recipient}}
+}
+
+func (c UpdateContent) Metadata() notificationCore.ContentMetadata {
+	return nil
+}
+
+func (c UpdateContent) MuteParams() map[string]string {
+	return map[string]string{"ticket_id": strconv.FormatInt(c.TicketID, 10)}
+}
+
+type updateTitle struct {
+	c UpdateContent
+}
+
+func (t updateTitle) String() string {
+	return translator.Stringfy(t)
+}
+
+func (t updateTitle) TplString() string {
+	tpl, _ := t.tplAndArgs()
+	return tpl
+}
+
+func (t updateTitle) Args() []interface{} {
+	_, args := t.tplAndArgs()
+	return args
+}
+
+func (t updateTitle) tplAndArgs() (string, []interface{}) {
+	c := t.c
+
+	switch {
+	// also the updates stored before assignee changes were notified, which were only about the status
+	case !c.AssigneeChanged:
+		return translator.I18n("Escalation #%v is now %v"), []interface{}{c.TicketID, string(c.Status)}
+	case !c.StatusChanged && len(c.Assignee) == 0:
+		return translator.I18n("Escalation #%v is no longer assigned"), []interface{}{c.TicketID}
+	case !c.StatusChanged:
+		return translator.I18n("Escalation #%v is now assigned to %v"), []interface{}{c.TicketID, c.Assignee}
+	case len(c.Assignee) == 0:
+		return translator.I18n("Escalation #%v is now %v and no longer assigned"), []interface{}{c.TicketID, string(c.Status)}
+	default:
+		return translator.I18n("Escalation #%v is now %v and assigned to %v"), []interface{}{c.TicketID, string(c.Status), c.Assignee}
+	}
+}
+
+// UpdateTicketAndNotify changes a ticket, notifying when its status or assignee changes
+func UpdateTicketAndNotify(tx *sql.Tx, creator core.Creator, id int64, update TicketUpdate, now time.Time) error {
+	before, err := UpdateTicket(tx, id, update, now)
+	if err != nil {
+		return err
+	}
+
+	after, err := fetchTicket(context.Background(), tx, id)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	statusChanged, assigneeChanged := after.Status != before.Status, after.Assignee != before.Assignee
+
+	if !statusChanged && !assigneeChanged {
+		return nil
+	}
+
+	content := UpdateContent{
+		TicketID:        id,
+		Status:          after.Status,
+		Assignee:        after.Assignee,
+		Sender:          after.Sender,
+		Recipient:       after.Recipient,
+		StatusChanged:   statusChanged,
+		AssigneeChanged: assigneeChanged,
+	}
+
+	properties := core.InsightProperties{
+		Time:           now,
+		Category:       core.LocalCategory,
+		Rating:         core.Unrated,
+		ContentType:    UpdateContentType,
+		Content:        content,
+		MustBeNotified: true,
+	}
+
+	if err := creator.GenerateInsight(context.Background(), tx, properties); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
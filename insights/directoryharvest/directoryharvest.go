@@ -0,0 +1,242 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package directoryharvest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/connectionstats"
+	"gitlab.com/lightmeter/controlcenter/i18n/translator"
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	"gitlab.com/lightmeter/controlcenter/intel/blockedips"
+	notificationCore "gitlab.com/lightmeter/controlcenter/notification/core"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+// In a directory harvest attack, a client tries to deliver messages to many guessed recipients,
+// using the rejections as non existent users to find out which addresses are valid.
+
+const (
+	ContentType   = "directory_harvest"
+	ContentTypeId = 18
+)
+
+// RecipientProbesFetcher returns the clients which tried to deliver to at least a number of non existent recipients
+type RecipientProbesFetcher interface {
+	FetchRecipientProbes(context.Context, timeutil.TimeInterval, int) ([]connectionstats.RecipientProbes, error)
+}
+
+type Options struct {
+	CheckInterval time.Duration
+
+	// The rejected recipients in this time span are checked
+	TimeSpan time.Duration
+
+	// Clients which probed fewer distinct recipients than this in the time span are ignored
+	MinProbedRecipients int
+
+	// Minimum time between two insights about the same IP
+	MinTimeToGenerateNewInsight time.Duration
+
+	Probes RecipientProbesFetcher
+}
+
+// Content shares its format with the blockedips insight, so both can be displayed the same way,
+// but the count of each IP is the number of recipients it probed
+type Content struct {
+	Interval    timeutil.TimeInterval  `json:"time_interval"`
+	TopIPs      []blockedips.BlockedIP `json:"top_ips"`
+	TotalNumber int                    `json:"total_number"`
+	TotalIPs    int                    `json:"total_ips"`
+}
+
+func (c Content) Title() notificationCore.ContentComponent {
+	return &title{c}
+}
+
+func (c Content) Description() notificationCore.ContentComponent {
+	return &description{c}
+}
+
+func (c Content) Metadata() notificationCore.ContentMetadata {
+	return nil
+}
+
+// MuteParams has the IP which probed the most recipients
+func (c Content) MuteParams() map[string]string {
+	if len(c.TopIPs) == 0 {
+		return map[string]string{}
+	}
+
+	return map[string]string{"ip": c.TopIPs[0].Address}
+}
+
+func (c Content) BlockedIPs() []string {
+	ips := make([]string, 0, len(c.TopIPs))
+
+	for _, ip := range c.TopIPs {
+		ips = append(ips, ip.Address)
+	}
+
+	return ips
+}
+
+type title struct {
+	c Content
+}
+
+func (t title) String() string {
+	return translator.Stringfy(t)
+}
+
+func (t title) TplString() string {
+	return translator.I18n("Directory harvest attack detected")
+}
+
+func (t title) Args() []interface{} {
+	return nil
+}
+
+type description struct {
+	c Content
+}
+
+func (d description) String() string {
+	return translator.Stringfy(d)
+}
+
+func (d description) TplString() string {
+	return translator.I18n("%v IPs probed %v non existent recipients between %v and %v")
+}
+
+func (d description) Args() []interface{} {
+	return []interface{}{d.c.TotalIPs, d.c.TotalNumber, d.c.Interval.From, d.c.Interval.To}
+}
+
+func init() {
+	core.RegisterContentType(ContentType, ContentTypeId, core.DefaultContentTypeDecoder(&Content{}))
+}
+
+type detector struct {
+	creator core.Creator
+	options Options
+}
+
+func (*detector) IsHistoricalDetector() {
+	// Required by the historical import
+}
+
+func (*detector) Close() error {
+	return nil
+}
+
+func getDetectorOptions(options core.Options) Options {
+	detectorOptions, ok := options["directoryharvest"].(Options)
+	if !ok || detectorOptions.Probes == nil {
+		errorutil.MustSucceed(errors.New("Invalid detector options"))
+	}
+
+	return detectorOptions
+}
+
+func NewDetector(creator core.Creator, options core.Options) core.Detector {
+	return &detector{
+		creator: creator,
+		options: getDetectorOptions(options),
+	}
+}
+
+const lastExecutionKind = "directory_harvest"
+
+func (d *detector) Step(c core.Clock, tx *sql.Tx) error {
+	now := c.Now()
+
+	lastExecution, err := core.RetrieveLastDetectorExecution(tx, lastExecutionKind)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if !lastExecution.IsZero() && now.Sub(lastExecution) < d.options.CheckInterval {
+		return nil
+	}
+
+	interval := timeutil.TimeInterval{From: now.Add(-d.options.TimeSpan), To: now}
+
+	probes, err := d.options.Probes.FetchRecipientProbes(context.Background(), interval, d.options.MinProbedRecipients)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if err := d.maybeGenerateInsight(tx, c, interval, probes); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	// the IPs not reported recently can be reported again anyways
+	if err := core.ForgetLastInsightGenerations(tx, lastExecutionKind, now.Add(-d.options.MinTimeToGenerateNewInsight)); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if err := core.StoreLastDetectorExecution(tx, lastExecutionKind, now); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+// maybeGenerateInsight reports the IPs which have not been reported recently, as the attacks
+// usually last longer than the time span, and each check would otherwise report them again
+func (d *detector) maybeGenerateInsight(tx *sql.Tx, c core.Clock, interval timeutil.TimeInterval, probes []connectionstats.RecipientProbes) error {
+	content := Content{Interval: interval, TopIPs: []blockedips.BlockedIP{}}
+
+	for _, p := range probes {
+		lastGeneration, err := core.RetrieveLastInsightGeneration(tx, lastExecutionKind, p.IP.String())
+		if err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		if !lastGeneration.IsZero() && c.Now().Sub(lastGeneration) < d.options.MinTimeToGenerateNewInsight {
+			continue
+		}
+
+		if err := core.StoreLastInsightGeneration(tx, lastExecutionKind, p.IP.String(), c.Now()); err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		// probes are ordered by the number of probed recipients, the most active first
+		content.TopIPs = append(content.TopIPs, blockedips.BlockedIP{Address: p.IP.String(), Count: p.Recipients})
+		content.TotalNumber += p.Recipients
+		content.TotalIPs++
+	}
+
+	if content.TotalIPs == 0 {
+		return nil
+	}
+
+	if err := generateInsight(tx, c, d.creator, content); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func generateInsight(tx *sql.Tx, c core.Clock, creator core.Creator, content Content) error {
+	properties := core.InsightProperties{
+		Time:        c.Now(),
+		Category:    core.LocalCategory,
+		Rating:      core.BadRating,
+		ContentType: ContentType,
+		Content:     content,
+	}
+
+	if err := creator.GenerateInsight(context.Background(), tx, properties); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+//go:build dev || !release
+// +build dev !release
+
+package directoryharvest
+
+import (
+	"database/sql"
+
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	"gitlab.com/lightmeter/controlcenter/intel/blockedips"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+// Executed only on development builds, for better developer experience
+func (d *detector) GenerateSampleInsight(tx *sql.Tx, c core.Clock) error {
+	if err := generateInsight(tx, c, d.creator, Content{
+		Interval: timeutil.TimeInterval{From: c.Now().Add(-d.options.TimeSpan), To: c.Now()},
+		TopIPs: []blockedips.BlockedIP{
+			{Address: "55.44.33.22", Count: 312},
+			{Address: "11.22.33.44", Count: 87},
+			{Address: "5.6.7.8", Count: 51},
+		},
+		TotalNumber: 450,
+		TotalIPs:    3,
+	}); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
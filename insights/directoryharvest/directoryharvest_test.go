@@ -0,0 +1,181 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package directoryharvest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gitlab.com/lightmeter/controlcenter/connectionstats"
+	"gitlab.com/lightmeter/controlcenter/i18n/translator"
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	_ "gitlab.com/lightmeter/controlcenter/insights/migrations"
+	insighttestsutil "gitlab.com/lightmeter/controlcenter/insights/testutil"
+	"gitlab.com/lightmeter/controlcenter/intel/blockedips"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3"
+	"gitlab.com/lightmeter/controlcenter/notification"
+	notificationCore "gitlab.com/lightmeter/controlcenter/notification/core"
+	"gitlab.com/lightmeter/controlcenter/util/testutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+func init() {
+	lmsqlite3.Initialize(lmsqlite3.Options{})
+}
+
+type rejection struct {
+	time      time.Time
+	ip        string
+	recipient string
+}
+
+type fakeProbes []rejection
+
+func (f fakeProbes) FetchRecipientProbes(_ context.Context, interval timeutil.TimeInterval, minRecipients int) ([]connectionstats.RecipientProbes, error) {
+	recipients := map[string]map[string]struct{}{}
+	attempts := map[string]int{}
+
+	for _, r := range f {
+		if r.time.Before(interval.From) || r.time.After(interval.To) {
+			continue
+		}
+
+		if _, ok := recipients[r.ip]; !ok {
+			recipients[r.ip] = map[string]struct{}{}
+		}
+
+		recipients[r.ip][r.recipient] = struct{}{}
+		attempts[r.ip]++
+	}
+
+	probes := []connectionstats.RecipientProbes{}
+
+	for ip, r := range recipients {
+		if len(r) >= minRecipients {
+			probes = append(probes, connectionstats.RecipientProbes{IP: net.ParseIP(ip), Recipients: len(r), Attempts: attempts[ip]})
+		}
+	}
+
+	sort.Slice(probes, func(i, j int) bool {
+		return probes[i].Recipients > probes[j].Recipients
+	})
+
+	return probes, nil
+}
+
+func TestDirectoryHarvestInsight(t *testing.T) {
+	Convey("Directory harvest", t, func() {
+		accessor, clear := insighttestsutil.NewFakeAccessor(t)
+		defer clear()
+
+		baseTime := testutil.MustParseTime(`2000-01-01 00:00:00 +0000`)
+
+		probes := fakeProbes{}
+
+		// a couple of typos, which are no attack
+		probes = append(probes,
+			rejection{time: baseTime.Add(time.Minute * 10), ip: "5.5.5.5", recipient: "alcie@example.com"},
+			rejection{time: baseTime.Add(time.Minute * 12), ip: "5.5.5.5", recipient: "bbo@example.com"},
+		)
+
+		// one attacker, guessing addresses for two hours
+		for i := 0; i < 240; i++ {
+			probes = append(probes, rejection{time: baseTime.Add(time.Minute * time.Duration(i/2)), ip: "11.22.33.44", recipient: fmt.Sprintf("user%d@example.com", i)})
+		}
+
+		// another one, joining later
+		for i := 0; i < 60; i++ {
+			probes = append(probes, rejection{time: baseTime.Add(time.Minute*90 + time.Second*time.Duration(i)), ip: "55.66.77.88", recipient: fmt.Sprintf("user%d@example.com", i)})
+		}
+
+		options := Options{
+			CheckInterval:               time.Minute * 30,
+			TimeSpan:                    time.Hour,
+			MinProbedRecipients:         20,
+			MinTimeToGenerateNewInsight: time.Hour * 24,
+			Probes:                      probes,
+		}
+
+		detector := NewDetector(accessor, core.Options{"directoryharvest": options})
+
+		clock := &insighttestsutil.FakeClock{Time: baseTime}
+
+		insighttestsutil.ExecuteCyclesUntil(detector, accessor, clock, baseTime.Add(time.Hour*4), time.Minute*10)
+
+		So(accessor.Insights, ShouldResemble, []int64{1, 2})
+
+		insights, err := accessor.FetchInsights(context.Background(), core.FetchOptions{
+			Interval: timeutil.TimeInterval{From: baseTime, To: baseTime.Add(time.Hour * 24)},
+			OrderBy:  core.OrderByCreationAsc,
+		}, clock)
+		So(err, ShouldBeNil)
+		So(len(insights), ShouldEqual, 2)
+
+		So(insights[0].ContentType(), ShouldEqual, ContentType)
+		So(insights[0].Rating(), ShouldEqual, core.BadRating)
+		So(insights[0].Time(), ShouldEqual, baseTime.Add(time.Minute*30))
+
+		// the attack is reported on the first check after enough recipients have been probed
+		So(insights[0].Content(), ShouldResemble, &Content{
+			Interval:    timeutil.TimeInterval{From: baseTime.Add(-time.Minute * 30), To: baseTime.Add(time.Minute * 30)},
+			TopIPs:      []blockedips.BlockedIP{{Address: "11.22.33.44", Count: 62}},
+			TotalNumber: 62,
+			TotalIPs:    1,
+		})
+
+		// the first attacker is not reported again
+		So(insights[1].Time(), ShouldEqual, baseTime.Add(time.Minute*120))
+		So(insights[1].Content(), ShouldResemble, &Content{
+			Interval:    timeutil.TimeInterval{From: baseTime.Add(time.Minute * 60), To: baseTime.Add(time.Minute * 120)},
+			TopIPs:      []blockedips.BlockedIP{{Address: "55.66.77.88", Count: 60}},
+			TotalNumber: 60,
+			TotalIPs:    1,
+		})
+
+		So(insights[1].Content().(*Content).BlockedIPs(), ShouldResemble, []string{"55.66.77.88"})
+
+		countGenerations := func() (count int) {
+			conn, release := accessor.ConnPair.RoConnPool.Acquire()
+			defer release()
+
+			So(conn.QueryRow(`select count(*) from last_insight_generation where kind = ?`, lastExecutionKind).Scan(&count), ShouldBeNil)
+
+			return count
+		}
+
+		So(countGenerations(), ShouldEqual, 2)
+
+		Convey("The IPs not reported recently are forgotten", func() {
+			insighttestsutil.ExecuteCyclesUntil(detector, accessor, clock, baseTime.Add(time.Hour*27), time.Minute*10)
+
+			So(countGenerations(), ShouldEqual, 0)
+			So(accessor.Insights, ShouldResemble, []int64{1, 2})
+		})
+	})
+}
+
+func TestDescriptionFormatting(t *testing.T) {
+	Convey("Description Formatting", t, func() {
+		content := Content{
+			Interval:    timeutil.TimeInterval{From: testutil.MustParseTime(`2000-01-01 00:00:00 +0000`), To: testutil.MustParseTime(`2000-01-01 01:00:00 +0000`)},
+			TopIPs:      []blockedips.BlockedIP{{Address: "11.22.33.44", Count: 100}, {Address: "55.66.77.88", Count: 60}},
+			TotalNumber: 160,
+			TotalIPs:    2,
+		}
+
+		m, err := notificationCore.TranslateNotification(notification.Notification{ID: 1, Content: content}, translator.DummyTranslator{})
+		So(err, ShouldBeNil)
+		So(m, ShouldResemble, notificationCore.Message{
+			Title:       "Directory harvest attack detected",
+			Description: "2 IPs probed 160 non existent recipients between 2000-01-01 00:00:00 +0000 UTC and 2000-01-01 01:00:00 +0000 UTC",
+			Metadata:    map[string]string{},
+		})
+	})
+}
@@ -0,0 +1,635 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package domainrbl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gitlab.com/lightmeter/controlcenter/i18n/translator"
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/dbconn"
+	notificationCore "gitlab.com/lightmeter/controlcenter/notification/core"
+	insightsSettings "gitlab.com/lightmeter/controlcenter/settings/insights"
+	"gitlab.com/lightmeter/controlcenter/tracking"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+// Domain blocklists (DBL, SURBL, URIBL...) list domains instead of IPs, and are checked by the receivers
+// against the domains of the senders and of the links in the messages.
+// The domains checked are the ones used by local senders in the outbound deliveries.
+
+const (
+	ContentType   = "domain_rbl"
+	ContentTypeId = 19
+)
+
+// Resolver is implemented by *net.Resolver, and replaced on tests
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+var DefaultBlocklists = []string{
+	"dbl.spamhaus.org",
+	"multi.surbl.org",
+	"multi.uribl.com",
+}
+
+type Options struct {
+	// Used when no check interval is set in the settings
+	CheckInterval time.Duration
+
+	// The domains used in this time span are checked
+	TimeSpan time.Duration
+
+	Blocklists []string
+
+	Resolver Resolver
+
+	NumberOfWorkers int
+
+	LookupTimeout time.Duration
+
+	// Only the most used domains are checked
+	MaxDomains int
+}
+
+type Listing struct {
+	Blocklist   string    `json:"blocklist"`
+	Code        string    `json:"code"`
+	ListedSince time.Time `json:"listed_since"`
+}
+
+type PastListing struct {
+	Blocklist string                `json:"blocklist"`
+	Code      string                `json:"code"`
+	Interval  timeutil.TimeInterval `json:"interval"`
+}
+
+type Content struct {
+	Domain string `json:"domain"`
+
+	// false when the domain is no longer listed on any blocklist
+	Listed bool `json:"listed"`
+
+	// When listed, the current listings. Otherwise, the listings just removed
+	Listings []Listing `json:"listings"`
+
+	// The previous listings of the domain, most recent first
+	History []PastListing `json:"history"`
+}
+
+func (c Content) Title() notificationCore.ContentComponent {
+	return &title{c}
+}
+
+func (c Content) Description() notificationCore.ContentComponent {
+	return &description{c}
+}
+
+func (c Content) Metadata() notificationCore.ContentMetadata {
+	return nil
+}
+
+func (c Content) MuteParams() map[string]string {
+	return map[string]string{"domain": c.Domain}
+}
+
+func (c Content) blocklists() string {
+	blocklists := make([]string, 0, len(c.Listings))
+
+	for _, l := range c.Listings {
+		blocklists = append(blocklists, l.Blocklist)
+	}
+
+	return strings.Join(blocklists, ", ")
+}
+
+type title struct {
+	c Content
+}
+
+func (t title) String() string {
+	return translator.Stringfy(t)
+}
+
+func (t title) TplString() string {
+	if !t.c.Listed {
+		return translator.I18n("The domain %v is no longer on domain blocklists")
+	}
+
+	return translator.I18n("The domain %v is on domain blocklists")
+}
+
+func (t title) Args() []interface{} {
+	return []interface{}{t.c.Domain}
+}
+
+type description struct {
+	c Content
+}
+
+func (d description) String() string {
+	return translator.Stringfy(d)
+}
+
+func (d description) TplString() string {
+	if !d.c.Listed {
+		return translator.I18n("Removed from: %v. It had been listed %v times before")
+	}
+
+	return translator.I18n("Messages using it might be rejected or marked as spam. Listed on: %v. It had been listed %v times before")
+}
+
+func (d description) Args() []interface{} {
+	return []interface{}{d.c.blocklists(), len(d.c.History)}
+}
+
+func init() {
+	core.RegisterContentType(ContentType, ContentTypeId, core.DefaultContentTypeDecoder(&Content{}))
+}
+
+type listingKey struct {
+	domain    string
+	blocklist string
+}
+
+type scanResults struct {
+	domains map[string]struct{}
+
+	// the code returned by the blocklist, for each listing
+	listed map[listingKey]string
+
+	// the lookups which could not be done, and whose state is unknown
+	failed map[listingKey]struct{}
+}
+
+type detector struct {
+	pool    *dbconn.RoPool
+	creator core.Creator
+	options Options
+
+	mutex         sync.Mutex
+	checkInterval time.Duration
+
+	// accessed only by Step
+	scanning bool
+
+	results chan scanResults
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func (d *detector) Close() error {
+	d.cancel()
+	return nil
+}
+
+func getDetectorOptions(options core.Options) Options {
+	detectorOptions, ok := options["domainrbl"].(Options)
+	if !ok || detectorOptions.Resolver == nil || detectorOptions.NumberOfWorkers < 1 {
+		errorutil.MustSucceed(errors.New("Invalid detector options"))
+	}
+
+	return detectorOptions
+}
+
+func NewDetector(settings *insightsSettings.Settings, creator core.Creator, options core.Options) core.Detector {
+	pool, ok := options["logsConnPool"].(*dbconn.RoPool)
+	if !ok {
+		errorutil.MustSucceed(errors.New("Invalid Connection Pool"))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	d := &detector{
+		pool:    pool,
+		creator: creator,
+		options: getDetectorOptions(options),
+		results: make(chan scanResults, 1),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	d.UpdateOptionsFromSettings(settings)
+
+	return d
+}
+
+func (d *detector) UpdateOptionsFromSettings(settings *insightsSettings.Settings) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.checkInterval = d.options.CheckInterval
+
+	if settings.DomainBlocklistCheckInterval > 0 {
+		d.checkInterval = time.Hour * time.Duration(settings.DomainBlocklistCheckInterval)
+	}
+}
+
+func (d *detector) currentCheckInterval() time.Duration {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.checkInterval
+}
+
+const domainsStmt = `
+select
+	lower(sd.domain) as domain
+from
+	deliveries d
+	join remote_domains sd on sd.id = d.sender_domain_part_id
+where
+	d.delivery_ts between ? and ? and d.direction = ? and sd.domain != ''
+group by
+	domain
+order by
+	count(*) desc, domain
+limit ?
+`
+
+func (d *detector) fetchSendingDomains(ctx context.Context, interval timeutil.TimeInterval) (domains []string, err error) {
+	conn, release, err := d.pool.AcquireContext(ctx)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer release()
+
+	rows, err := conn.QueryContext(ctx, domainsStmt, interval.From.Unix(), interval.To.Unix(), tracking.MessageDirectionOutbound, d.options.MaxDomains)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	domains = []string{}
+
+	for rows.Next() {
+		var domain string
+
+		if err := rows.Scan(&domain); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		domains = append(domains, domain)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return domains, nil
+}
+
+var ErrInvalidAnswer = errors.New(`Invalid blocklist answer`)
+
+// lookup returns the code of the listing, or an empty string if the domain is not listed
+func (d *detector) lookup(ctx context.Context, domain, blocklist string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.options.LookupTimeout)
+	defer cancel()
+
+	addrs, err := d.options.Resolver.LookupHost(ctx, domain+"."+blocklist)
+
+	var dnsErr *net.DNSError
+	if err != nil && errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", errorutil.Wrap(err)
+	}
+
+	for _, addr := range addrs {
+		ip := net.ParseIP(addr).To4()
+
+		// 127.0.0.1 means the query was refused, and 127.255.255.x are errors,
+		// as when using a public resolver, which the blocklists usually refuse
+		if ip == nil || ip[0] != 127 || ip.Equal(net.IPv4(127, 0, 0, 1)) || (ip[1] == 255 && ip[2] == 255) {
+			return "", ErrInvalidAnswer
+		}
+	}
+
+	if len(addrs) == 0 {
+		return "", nil
+	}
+
+	return addrs[0], nil
+}
+
+func (d *detector) scan(ctx context.Context, domains []string) scanResults {
+	results := scanResults{
+		domains: map[string]struct{}{},
+		listed:  map[listingKey]string{},
+		failed:  map[listingKey]struct{}{},
+	}
+
+	keys := make(chan listingKey)
+
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+	)
+
+	for i := 0; i < d.options.NumberOfWorkers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for k := range keys {
+				code, err := d.lookup(ctx, k.domain, k.blocklist)
+
+				mutex.Lock()
+
+				if err != nil && ctx.Err() == nil {
+					log.Warn().Err(err).Msgf("Checking domain %v on blocklist %v", k.domain, k.blocklist)
+				}
+
+				if err != nil {
+					results.failed[k] = struct{}{}
+				} else if len(code) > 0 {
+					results.listed[k] = code
+				}
+
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for _, domain := range domains {
+		results.domains[domain] = struct{}{}
+
+		for _, blocklist := range d.options.Blocklists {
+			keys <- listingKey{domain: domain, blocklist: blocklist}
+		}
+	}
+
+	close(keys)
+
+	wg.Wait()
+
+	return results
+}
+
+const lastExecutionKind = "domain_rbl"
+
+func (d *detector) Step(c core.Clock, tx *sql.Tx) error {
+	select {
+	case results := <-d.results:
+		d.scanning = false
+
+		if err := d.processResults(tx, c, results); err != nil {
+			return errorutil.Wrap(err)
+		}
+	default:
+	}
+
+	if d.scanning {
+		return nil
+	}
+
+	now := c.Now()
+
+	lastExecution, err := core.RetrieveLastDetectorExecution(tx, lastExecutionKind)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if !lastExecution.IsZero() && now.Sub(lastExecution) < d.currentCheckInterval() {
+		return nil
+	}
+
+	domains, err := d.fetchSendingDomains(d.ctx, timeutil.TimeInterval{From: now.Add(-d.options.TimeSpan), To: now})
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if err := core.StoreLastDetectorExecution(tx, lastExecutionKind, now); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if len(domains) == 0 {
+		return nil
+	}
+
+	d.scanning = true
+
+	go func() {
+		d.results <- d.scan(d.ctx, domains)
+	}()
+
+	return nil
+}
+
+type currentListing struct {
+	id int64
+	Listing
+}
+
+func currentListings(tx *sql.Tx) (listings map[listingKey]currentListing, err error) {
+	rows, err := tx.Query(`select id, domain, blocklist, code, listed_ts from domain_rbl_listings where delisted_ts = 0`)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	listings = map[listingKey]currentListing{}
+
+	for rows.Next() {
+		var (
+			l        currentListing
+			domain   string
+			listedTs int64
+		)
+
+		if err := rows.Scan(&l.id, &domain, &l.Blocklist, &l.Code, &listedTs); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		l.ListedSince = time.Unix(listedTs, 0).In(time.UTC)
+
+		listings[listingKey{domain: domain, blocklist: l.Blocklist}] = l
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return listings, nil
+}
+
+// pastListings returns the listings of the domain removed before the given time
+func pastListings(tx *sql.Tx, domain string, before time.Time) (history []PastListing, err error) {
+	rows, err := tx.Query(`
+		select blocklist, code, listed_ts, delisted_ts
+		from domain_rbl_listings
+		where domain = ? and delisted_ts != 0 and delisted_ts < ?
+		order by delisted_ts desc, id desc`, domain, before.Unix())
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	history = []PastListing{}
+
+	for rows.Next() {
+		var (
+			l                    PastListing
+			listedTs, delistedTs int64
+		)
+
+		if err := rows.Scan(&l.Blocklist, &l.Code, &listedTs, &delistedTs); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		l.Interval = timeutil.TimeInterval{From: time.Unix(listedTs, 0).In(time.UTC), To: time.Unix(delistedTs, 0).In(time.UTC)}
+
+		history = append(history, l)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return history, nil
+}
+
+// processResults updates the listings history, generating an insight for each domain
+// newly listed on any blocklist, or no longer listed on any of them
+func (d *detector) processResults(tx *sql.Tx, c core.Clock, results scanResults) error {
+	now := c.Now()
+
+	current, err := currentListings(tx)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	newlyListed := map[string]struct{}{}
+	delisted := map[string][]Listing{}
+
+	for k, l := range current {
+		if _, ok := results.domains[k.domain]; !ok {
+			// not used anymore, and not checked
+			continue
+		}
+
+		if _, ok := results.listed[k]; ok {
+			continue
+		}
+
+		if _, ok := results.failed[k]; ok {
+			continue
+		}
+
+		if _, err := tx.Exec(`update domain_rbl_listings set delisted_ts = ? where id = ?`, now.Unix(), l.id); err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		delete(current, k)
+
+		delisted[k.domain] = append(delisted[k.domain], l.Listing)
+	}
+
+	for k, code := range results.listed {
+		if _, ok := current[k]; ok {
+			continue
+		}
+
+		result, err := tx.Exec(`insert into domain_rbl_listings(domain, blocklist, code, listed_ts, delisted_ts) values(?, ?, ?, ?, 0)`,
+			k.domain, k.blocklist, code, now.Unix())
+		if err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		id, err := result.LastInsertId()
+		if err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		listedSince := time.Unix(now.Unix(), 0).In(time.UTC)
+
+		current[k] = currentListing{id: id, Listing: Listing{Blocklist: k.blocklist, Code: code, ListedSince: listedSince}}
+
+		newlyListed[k.domain] = struct{}{}
+	}
+
+	listingsByDomain := map[string][]Listing{}
+
+	for k, l := range current {
+		listingsByDomain[k.domain] = append(listingsByDomain[k.domain], l.Listing)
+	}
+
+	contents := []Content{}
+
+	for domain := range newlyListed {
+		contents = append(contents, Content{Domain: domain, Listed: true, Listings: listingsByDomain[domain]})
+	}
+
+	for domain, listings := range delisted {
+		if _, stillListed := listingsByDomain[domain]; stillListed {
+			continue
+		}
+
+		contents = append(contents, Content{Domain: domain, Listed: false, Listings: listings})
+	}
+
+	// stable order for the generated insights
+	sort.Slice(contents, func(i, j int) bool {
+		return contents[i].Domain < contents[j].Domain
+	})
+
+	for _, content := range contents {
+		sort.Slice(content.Listings, func(i, j int) bool {
+			return content.Listings[i].Blocklist < content.Listings[j].Blocklist
+		})
+
+		// the listings just removed are not part of the history
+		history, err := pastListings(tx, content.Domain, now)
+		if err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		content.History = history
+
+		if err := generateInsight(tx, c, d.creator, content); err != nil {
+			return errorutil.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+func generateInsight(tx *sql.Tx, c core.Clock, creator core.Creator, content Content) error {
+	rating := core.BadRating
+	if !content.Listed {
+		rating = core.GoodRating
+	}
+
+	properties := core.InsightProperties{
+		Time:        c.Now(),
+		Category:    core.LocalCategory,
+		Rating:      rating,
+		ContentType: ContentType,
+		Content:     content,
+	}
+
+	if err := creator.GenerateInsight(context.Background(), tx, properties); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
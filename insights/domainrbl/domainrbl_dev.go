@@ -0,0 +1,40 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+//go:build dev || !release
+// +build dev !release
+
+package domainrbl
+
+import (
+	"database/sql"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+// Executed only on development builds, for better developer experience
+func (d *detector) GenerateSampleInsight(tx *sql.Tx, c core.Clock) error {
+	now := c.Now()
+
+	content := Content{
+		Domain: "example.com",
+		Listed: true,
+		Listings: []Listing{
+			{Blocklist: "dbl.spamhaus.org", Code: "127.0.1.2", ListedSince: now.Add(-time.Hour * 3)},
+			{Blocklist: "multi.uribl.com", Code: "127.0.0.2", ListedSince: now.Add(-time.Hour)},
+		},
+		History: []PastListing{
+			{Blocklist: "dbl.spamhaus.org", Code: "127.0.1.2", Interval: timeutil.TimeInterval{From: now.Add(-time.Hour * 24 * 30), To: now.Add(-time.Hour * 24 * 28)}},
+		},
+	}
+
+	if err := generateInsight(tx, c, d.creator, content); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
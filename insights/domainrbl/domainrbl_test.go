@@ -0,0 +1,340 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package domainrbl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gitlab.com/lightmeter/controlcenter/deliverydb"
+	"gitlab.com/lightmeter/controlcenter/domainmapping"
+	"gitlab.com/lightmeter/controlcenter/i18n/translator"
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	_ "gitlab.com/lightmeter/controlcenter/insights/migrations"
+	insighttestsutil "gitlab.com/lightmeter/controlcenter/insights/testutil"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3"
+	"gitlab.com/lightmeter/controlcenter/notification"
+	notificationCore "gitlab.com/lightmeter/controlcenter/notification/core"
+	parser "gitlab.com/lightmeter/controlcenter/pkg/postfix/logparser"
+	"gitlab.com/lightmeter/controlcenter/pkg/runner"
+	insightsSettings "gitlab.com/lightmeter/controlcenter/settings/insights"
+	"gitlab.com/lightmeter/controlcenter/tracking"
+	"gitlab.com/lightmeter/controlcenter/util/testutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+func init() {
+	lmsqlite3.Initialize(lmsqlite3.Options{})
+}
+
+// fakeResolver answers the queries from a map, where a missing name does not exist
+type fakeResolver struct {
+	sync.Mutex
+	answers  map[string][]string
+	failures map[string]error
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if err, ok := r.failures[host]; ok {
+		return nil, err
+	}
+
+	if addrs, ok := r.answers[host]; ok {
+		return addrs, nil
+	}
+
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func (r *fakeResolver) set(answers map[string][]string, failures map[string]error) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.answers = answers
+	r.failures = failures
+}
+
+func buildResult(t time.Time, senderDomain string, direction tracking.MessageDirection, checksum int64) tracking.Result {
+	result := tracking.Result{}
+	result[tracking.ResultDeliveryTimeKey] = tracking.ResultEntryInt64(t.Unix())
+	result[tracking.QueueSenderLocalPartKey] = tracking.ResultEntryText("sender")
+	result[tracking.QueueSenderDomainPartKey] = tracking.ResultEntryText(senderDomain)
+	result[tracking.ResultRecipientLocalPartKey] = tracking.ResultEntryText(fmt.Sprintf("recipient%d", checksum))
+	result[tracking.ResultRecipientDomainPartKey] = tracking.ResultEntryText("recipient.example.net")
+	result[tracking.ResultStatusKey] = tracking.ResultEntryInt64(int64(parser.SentStatus))
+	result[tracking.ResultMessageDirectionKey] = tracking.ResultEntryInt64(int64(direction))
+	result[tracking.QueueMessageIDKey] = tracking.ResultEntryText(fmt.Sprintf("msgid%d", checksum))
+	result[tracking.QueueOriginalMessageSizeKey] = tracking.ResultEntryInt64(35)
+	result[tracking.QueueProcessedMessageSizeKey] = tracking.ResultEntryInt64(42)
+	result[tracking.QueueNRCPTKey] = tracking.ResultEntryInt64(1)
+	result[tracking.ResultDeliveryServerKey] = tracking.ResultEntryText("mail")
+	result[tracking.ResultDelayKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDelaySMTPDKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDelayCleanupKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDelayQmgrKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDelaySMTPKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDSNKey] = tracking.ResultEntryText("2.0.0")
+	result[tracking.QueueBeginKey] = tracking.ResultEntryInt64(t.Unix())
+	result[tracking.QueueDeliveryNameKey] = tracking.ResultEntryText("A1")
+	result[tracking.ResultDeliveryLineChecksum] = tracking.ResultEntryInt64(checksum)
+
+	return result
+}
+
+func TestDomainRBLInsight(t *testing.T) {
+	Convey("Domain RBL", t, func() {
+		conn, closeConn := testutil.TempDBConnectionMigrated(t, "logs")
+		defer closeConn()
+
+		db, err := deliverydb.New(conn, &domainmapping.DefaultMapping, deliverydb.Options{RetentionDuration: time.Hour * 24 * 30})
+		So(err, ShouldBeNil)
+
+		done, cancel := runner.Run(db)
+		pub := db.ResultsPublisher()
+
+		accessor, clear := insighttestsutil.NewFakeAccessor(t)
+		defer clear()
+
+		baseTime := testutil.MustParseTime(`2000-01-01 00:00:00 +0000`)
+
+		checksum := int64(0)
+
+		publish := func(domain string, direction tracking.MessageDirection, count int) {
+			for i := 0; i < count; i++ {
+				checksum++
+				pub.Publish(buildResult(baseTime.Add(time.Minute*time.Duration(checksum)), domain, direction, checksum))
+			}
+		}
+
+		publish("example.com", tracking.MessageDirectionOutbound, 3)
+		publish("Example.ORG", tracking.MessageDirectionOutbound, 2)
+
+		// received messages are not from local senders
+		publish("remote.example.net", tracking.MessageDirectionIncoming, 5)
+
+		cancel()
+		So(done(), ShouldBeNil)
+
+		resolver := &fakeResolver{}
+
+		options := Options{
+			CheckInterval:   time.Hour,
+			TimeSpan:        time.Hour * 24,
+			Blocklists:      []string{"dbl.example", "uribl.example"},
+			Resolver:        resolver,
+			NumberOfWorkers: 2,
+			LookupTimeout:   time.Second,
+			MaxDomains:      10,
+		}
+
+		settings := &insightsSettings.Settings{}
+
+		d := NewDetector(settings, accessor, core.Options{"logsConnPool": conn.RoConnPool, "domainrbl": options}).(*detector)
+		defer func() { So(d.Close(), ShouldBeNil) }()
+
+		clock := &insighttestsutil.FakeClock{Time: baseTime.Add(time.Hour * 3)}
+
+		step := func() {
+			So(accessor.ConnPair.RwConn.Tx(context.Background(), func(ctx context.Context, tx *sql.Tx) error {
+				return d.Step(clock, tx)
+			}), ShouldBeNil)
+		}
+
+		// executes a scan, and processes its results
+		scan := func() {
+			step()
+
+			So(d.scanning, ShouldBeTrue)
+
+			for i := 0; i < 500 && len(d.results) == 0; i++ {
+				time.Sleep(time.Millisecond * 10)
+			}
+
+			step()
+
+			So(d.scanning, ShouldBeFalse)
+
+			clock.Sleep(time.Hour)
+		}
+
+		fetchInsights := func() []core.FetchedInsight {
+			insights, err := accessor.FetchInsights(context.Background(), core.FetchOptions{
+				Interval: timeutil.TimeInterval{From: baseTime, To: baseTime.Add(time.Hour * 48)},
+				OrderBy:  core.OrderByCreationAsc,
+			}, clock)
+			So(err, ShouldBeNil)
+
+			return insights
+		}
+
+		listedTime := func(hours int) time.Time {
+			return baseTime.Add(time.Hour * time.Duration(hours))
+		}
+
+		Convey("No domain is listed", func() {
+			scan()
+			So(accessor.Insights, ShouldBeEmpty)
+
+			Convey("Checks are done only after the check interval", func() {
+				clock.Sleep(-time.Minute * 30)
+				step()
+				So(d.scanning, ShouldBeFalse)
+			})
+
+			Convey("The check interval can be changed in the settings", func() {
+				d.UpdateOptionsFromSettings(&insightsSettings.Settings{DomainBlocklistCheckInterval: 2})
+				step()
+				So(d.scanning, ShouldBeFalse)
+
+				clock.Sleep(time.Hour)
+				scan()
+			})
+		})
+
+		Convey("Invalid answers are not listings", func() {
+			resolver.set(map[string][]string{
+				// refused query
+				"example.com.dbl.example": {"127.0.0.1"},
+				// public resolver used
+				"example.com.uribl.example": {"127.255.255.254"},
+				"example.org.dbl.example":   {"10.0.0.1"},
+			}, nil)
+
+			scan()
+			So(accessor.Insights, ShouldBeEmpty)
+		})
+
+		Convey("Listings history", func() {
+			resolver.set(map[string][]string{"example.com.dbl.example": {"127.0.1.2"}}, nil)
+
+			scan()
+
+			So(accessor.Insights, ShouldResemble, []int64{1})
+
+			insights := fetchInsights()
+			So(insights[0].ContentType(), ShouldEqual, ContentType)
+			So(insights[0].Rating(), ShouldEqual, core.BadRating)
+			So(insights[0].Content(), ShouldResemble, &Content{
+				Domain:   "example.com",
+				Listed:   true,
+				Listings: []Listing{{Blocklist: "dbl.example", Code: "127.0.1.2", ListedSince: listedTime(3)}},
+				History:  []PastListing{},
+			})
+
+			// still listed, nothing new
+			scan()
+			So(accessor.Insights, ShouldResemble, []int64{1})
+
+			// listed on another blocklist
+			resolver.set(map[string][]string{
+				"example.com.dbl.example":   {"127.0.1.2"},
+				"example.com.uribl.example": {"127.0.0.2"},
+			}, nil)
+
+			scan()
+			So(accessor.Insights, ShouldResemble, []int64{1, 2})
+			So(fetchInsights()[1].Content(), ShouldResemble, &Content{
+				Domain: "example.com",
+				Listed: true,
+				Listings: []Listing{
+					{Blocklist: "dbl.example", Code: "127.0.1.2", ListedSince: listedTime(3)},
+					{Blocklist: "uribl.example", Code: "127.0.0.2", ListedSince: listedTime(5)},
+				},
+				History: []PastListing{},
+			})
+
+			// removed from one of them, and the other one cannot be checked
+			resolver.set(nil, map[string]error{"example.com.dbl.example": errors.New("timeout")})
+
+			scan()
+			So(accessor.Insights, ShouldResemble, []int64{1, 2})
+
+			// removed from all of them
+			resolver.set(nil, nil)
+
+			scan()
+			So(accessor.Insights, ShouldResemble, []int64{1, 2, 3})
+
+			insights = fetchInsights()
+			So(insights[2].Rating(), ShouldEqual, core.GoodRating)
+			So(insights[2].Content(), ShouldResemble, &Content{
+				Domain:   "example.com",
+				Listed:   false,
+				Listings: []Listing{{Blocklist: "dbl.example", Code: "127.0.1.2", ListedSince: listedTime(3)}},
+				History: []PastListing{
+					{Blocklist: "uribl.example", Code: "127.0.0.2", Interval: timeutil.TimeInterval{From: listedTime(5), To: listedTime(6)}},
+				},
+			})
+
+			// listed again
+			resolver.set(map[string][]string{"example.com.dbl.example": {"127.0.1.2"}}, nil)
+
+			scan()
+			So(accessor.Insights, ShouldResemble, []int64{1, 2, 3, 4})
+			So(fetchInsights()[3].Content(), ShouldResemble, &Content{
+				Domain:   "example.com",
+				Listed:   true,
+				Listings: []Listing{{Blocklist: "dbl.example", Code: "127.0.1.2", ListedSince: listedTime(8)}},
+				History: []PastListing{
+					{Blocklist: "dbl.example", Code: "127.0.1.2", Interval: timeutil.TimeInterval{From: listedTime(3), To: listedTime(7)}},
+					{Blocklist: "uribl.example", Code: "127.0.0.2", Interval: timeutil.TimeInterval{From: listedTime(5), To: listedTime(6)}},
+				},
+			})
+		})
+
+		Convey("Domains are case insensitive", func() {
+			resolver.set(map[string][]string{"example.org.uribl.example": {"127.0.0.4"}}, nil)
+
+			scan()
+
+			So(accessor.Insights, ShouldResemble, []int64{1})
+			So(fetchInsights()[0].Content().(*Content).Domain, ShouldEqual, "example.org")
+		})
+	})
+}
+
+func TestDescriptionFormatting(t *testing.T) {
+	Convey("Description Formatting", t, func() {
+		content := Content{
+			Domain: "example.com",
+			Listed: true,
+			Listings: []Listing{
+				{Blocklist: "dbl.spamhaus.org", Code: "127.0.1.2"},
+				{Blocklist: "multi.uribl.com", Code: "127.0.0.2"},
+			},
+			History: []PastListing{{Blocklist: "dbl.spamhaus.org", Code: "127.0.1.2"}},
+		}
+
+		m, err := notificationCore.TranslateNotification(notification.Notification{ID: 1, Content: content}, translator.DummyTranslator{})
+		So(err, ShouldBeNil)
+		So(m, ShouldResemble, notificationCore.Message{
+			Title:       "The domain example.com is on domain blocklists",
+			Description: "Messages using it might be rejected or marked as spam. Listed on: dbl.spamhaus.org, multi.uribl.com. It had been listed 1 times before",
+			Metadata:    map[string]string{},
+		})
+
+		content.Listed = false
+		content.Listings = content.Listings[:1]
+		content.History = []PastListing{}
+
+		m, err = notificationCore.TranslateNotification(notification.Notification{ID: 1, Content: content}, translator.DummyTranslator{})
+		So(err, ShouldBeNil)
+		So(m, ShouldResemble, notificationCore.Message{
+			Title:       "The domain example.com is no longer on domain blocklists",
+			Description: "Removed from: dbl.spamhaus.org. It had been listed 0 times before",
+			Metadata:    map[string]string{},
+		})
+	})
+}
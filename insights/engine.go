@@ -51,7 +51,11 @@ func NewAccessor(stateConn *dbconn.PooledPair) (*Accessor, error) {
 }
 
 func (c *Accessor) NotificationPolicy() notification.Policy {
-	return notification.Policies{&doNotGenerateNotificationsDuringImportPolicy{pool: c.conn.RoConnPool}, &DefaultNotificationPolicy{}}
+	return notification.Policies{
+		&doNotGenerateNotificationsDuringImportPolicy{pool: c.conn.RoConnPool},
+		&suppressedInsightsPolicy{pool: c.conn.RoConnPool},
+		&DefaultNotificationPolicy{},
+	}
 }
 
 type Engine struct {
@@ -61,6 +65,7 @@ type Engine struct {
 	metaReader      *metadata.Reader
 	accessor        *Accessor
 	core            *core.Core
+	creator         *creator
 	txActions       chan txAction
 	fetcher         core.Fetcher
 	importAnnouncer importAnnouncer
@@ -108,6 +113,7 @@ func NewCustomEngine(
 		metaReader:      metaReader,
 		accessor:        insightsAccessor,
 		core:            core,
+		creator:         creator,
 		txActions:       make(chan txAction, 1024),
 		fetcher:         fetcher,
 		importAnnouncer: announcer,
@@ -375,6 +381,10 @@ func importHistoricalInsights(e *Engine) (timeutil.TimeInterval, error) {
 			return errorutil.Wrap(err)
 		}
 
+		if _, err := tx.Exec(`delete from last_insight_generation`); err != nil {
+			return errorutil.Wrap(err)
+		}
+
 		return nil
 	}); err != nil {
 		return timeutil.TimeInterval{}, errorutil.Wrap(err)
@@ -442,14 +452,48 @@ func (e *Engine) ArchiveInsight(id int64) {
 	}
 }
 
+// runTxAction executes action by the database writer, waiting for it to finish
+func (e *Engine) runTxAction(ctx context.Context, action txAction) error {
+	result := make(chan error, 1)
+
+	wrapped := func(tx *sql.Tx) error {
+		err := action(tx)
+
+		result <- err
+
+		if err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		return nil
+	}
+
+	select {
+	case e.txActions <- wrapped:
+	case <-ctx.Done():
+		return errorutil.Wrap(ctx.Err())
+	}
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return errorutil.Wrap(ctx.Err())
+	}
+}
+
 func (e *Engine) GenerateInsight(ctx context.Context, properties core.InsightProperties) {
 	e.txActions <- func(tx *sql.Tx) error {
-		_, err := core.GenerateInsight(ctx, tx, properties)
+		id, err := core.GenerateInsight(ctx, tx, properties)
 
 		if err != nil {
 			return errorutil.Wrap(err)
 		}
 
+		if err := core.SuppressNewInsight(ctx, tx, id, properties); err != nil {
+			return errorutil.Wrap(err)
+		}
+
 		return nil
 	}
 }
@@ -0,0 +1,185 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package insights
+
+import (
+	"context"
+	"database/sql"
+
+	"gitlab.com/lightmeter/controlcenter/erasure"
+	"gitlab.com/lightmeter/controlcenter/insights/detectiveescalation"
+	"gitlab.com/lightmeter/controlcenter/insights/savedsearch"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+func eraseInsights(tx *sql.Tx, target erasure.Target) (entries erasure.Entries, err error) {
+	rows, err := tx.Query(`select rowid, content from insights where content like ?`, target.LikePattern())
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	ids := []int64{}
+
+	matcher := target.Matcher()
+
+	for rows.Next() {
+		var (
+			id      int64
+			content string
+		)
+
+		if err := rows.Scan(&id, &content); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		if matcher.Match(content) {
+			ids = append(ids, id)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	var statusCount int64
+
+	for _, id := range ids {
+		r, err := tx.Exec(`delete from insights_status where insight_id = ?`, id)
+		if err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		n, err := erasure.CountAffected(r)
+		if err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		statusCount += n
+
+		if _, err := tx.Exec(`delete from insights where rowid = ?`, id); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+	}
+
+	suppressionsCount, err := eraseSuppressions(tx, target, ids)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	searchesCount, err := savedsearch.Erase(tx, target)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	ticketsEntries, err := detectiveescalation.EraseTickets(tx, target)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	entries = erasure.Entries{
+		{Database: "insights", Table: "insights", Action: erasure.DeletedAction, Count: int64(len(ids))},
+		{Database: "insights", Table: "insights_status", Action: erasure.DeletedAction, Count: statusCount},
+		{Database: "insights", Table: "insights_suppressions", Action: erasure.DeletedAction, Count: suppressionsCount},
+		{Database: "insights", Table: "saved_searches", Action: erasure.DeletedAction, Count: searchesCount},
+	}
+
+	return append(entries, ticketsEntries...), nil
+}
+
+// eraseSuppressions deletes the mute rules whose parameters mention target,
+// and the snoozes and acknowledgements of the erased insights
+func eraseSuppressions(tx *sql.Tx, target erasure.Target, insightIDs []int64) (count int64, err error) {
+	rows, err := tx.Query(`select id, params from insights_suppressions where cast(params as text) like ?`, target.LikePattern())
+	if err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	ids := []int64{}
+
+	matcher := target.Matcher()
+
+	for rows.Next() {
+		var (
+			id     int64
+			params string
+		)
+
+		if err := rows.Scan(&id, &params); err != nil {
+			return 0, errorutil.Wrap(err)
+		}
+
+		if matcher.Match(params) {
+			ids = append(ids, id)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	for _, id := range ids {
+		if _, err := tx.Exec(`delete from insights_suppressed where suppression_id = ?`, id); err != nil {
+			return 0, errorutil.Wrap(err)
+		}
+
+		if _, err := tx.Exec(`delete from insights_suppressions where id = ?`, id); err != nil {
+			return 0, errorutil.Wrap(err)
+		}
+	}
+
+	count = int64(len(ids))
+
+	for _, id := range insightIDs {
+		if _, err := tx.Exec(`delete from insights_suppressed where insight_id = ?`, id); err != nil {
+			return 0, errorutil.Wrap(err)
+		}
+
+		r, err := tx.Exec(`delete from insights_suppressions where insight_id = ?`, id)
+		if err != nil {
+			return 0, errorutil.Wrap(err)
+		}
+
+		n, err := erasure.CountAffected(r)
+		if err != nil {
+			return 0, errorutil.Wrap(err)
+		}
+
+		count += n
+	}
+
+	return count, nil
+}
+
+// EraseInsights deletes all insights whose content mentions target.
+// It's executed in a savepoint, so that a failure does not affect the other changes done by the engine
+func (e *Engine) EraseInsights(ctx context.Context, target erasure.Target) (erasure.Entries, error) {
+	var (
+		entries    erasure.Entries
+		erasureErr error
+	)
+
+	err := e.runTxAction(ctx, func(tx *sql.Tx) error {
+		entries, erasureErr = erasure.InSavepoint(tx, func(tx *sql.Tx) (erasure.Entries, error) {
+			return eraseInsights(tx, target)
+		})
+
+		// a failed erasure must not stop the engine
+		return nil
+	})
+
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	if erasureErr != nil {
+		return nil, errorutil.Wrap(erasureErr)
+	}
+
+	return entries, nil
+}
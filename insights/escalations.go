@@ -0,0 +1,40 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package insights
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/insights/detectiveescalation"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+func (e *Engine) EscalationTickets(ctx context.Context, status *detectiveescalation.TicketStatus) ([]detectiveescalation.Ticket, error) {
+	tickets, err := detectiveescalation.FetchTickets(ctx, e.accessor.conn.RoConnPool, status)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return tickets, nil
+}
+
+func (e *Engine) EscalationTicket(ctx context.Context, id int64) (detectiveescalation.Ticket, error) {
+	return detectiveescalation.FetchTicket(ctx, e.accessor.conn.RoConnPool, id)
+}
+
+// UpdateEscalationTicket changes the status or assignee of a ticket, notifying about such changes
+func (e *Engine) UpdateEscalationTicket(ctx context.Context, id int64, update detectiveescalation.TicketUpdate) error {
+	return e.runTxAction(ctx, func(tx *sql.Tx) error {
+		return detectiveescalation.UpdateTicketAndNotify(tx, e.creator, id, update, time.Now())
+	})
+}
+
+func (e *Engine) CommentEscalationTicket(ctx context.Context, id int64, author, content string) error {
+	return e.runTxAction(ctx, func(tx *sql.Tx) error {
+		return detectiveescalation.CommentTicket(tx, id, author, content, time.Now())
+	})
+}
@@ -35,6 +35,10 @@ func (c *creator) GenerateInsight(ctx context.Context, tx *sql.Tx, properties co
 		return errorutil.Wrap(err)
 	}
 
+	if err := core.SuppressNewInsight(ctx, tx, id, properties); err != nil {
+		return errorutil.Wrap(err)
+	}
+
 	if err := c.notifier.Notify(notification.Notification{ID: id, Content: properties}); err != nil {
 		return errorutil.Wrap(err)
 	}
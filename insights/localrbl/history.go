@@ -0,0 +1,587 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package localrblinsight
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"sort"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/dbconn"
+	"gitlab.com/lightmeter/controlcenter/localrbl"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+// DefaultDelistingReminderInterval is how long after requesting the removal from a list
+// the user is reminded that the IP is still listed, if no other interval is given
+const DefaultDelistingReminderInterval = time.Hour * 48
+
+var (
+	ErrNoSuchListing   = errors.New(`No such RBL listing`)
+	ErrAlreadyDelisted = errors.New(`The IP is not listed anymore`)
+	ErrNotChecked      = errors.New(`The IP is not checked anymore`)
+)
+
+// Delisting is a removal request the user submitted to a RBL
+type Delisting struct {
+	RequestedAt time.Time `json:"requested_at"`
+
+	// In seconds
+	RemindInterval int64     `json:"remind_interval"`
+	NextReminderAt time.Time `json:"next_reminder_at"`
+	Notes          string    `json:"notes"`
+
+	// Seconds from the request until the IP got delisted, nil while it's still listed
+	TimeToDelist *int64 `json:"time_to_delist"`
+}
+
+// Listing is a period in which an IP has been listed by a RBL
+type Listing struct {
+	ID         int64      `json:"id"`
+	Text       string     `json:"text"`
+	ListedAt   time.Time  `json:"listed_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	DelistedAt *time.Time `json:"delisted_at"`
+
+	// Seconds from the listing until the delisting, nil while it's still listed
+	TimeToDelist *int64 `json:"time_to_delist"`
+
+	// When the IP stopped being checked, while it was still listed, as when the user changed the IP
+	// of the mail server. The listing is then not current anymore, but it's unknown when it ended
+	UncheckedAt *time.Time `json:"unchecked_at"`
+
+	// Set only if the user requested the removal from the list
+	Delisting *Delisting `json:"delisting"`
+}
+
+// Timeline has all the times an IP has been listed by a RBL
+type Timeline struct {
+	Address       net.IP     `json:"address"`
+	RBL           string     `json:"rbl"`
+	Listed        bool       `json:"listed"`
+	LastCheckedAt *time.Time `json:"last_checked_at"`
+	TimesListed   int        `json:"times_listed"`
+
+	// In seconds, only for the listings which ended, nil if none did
+	AverageTimeToDelist *int64 `json:"average_time_to_delist"`
+
+	// Oldest first
+	Listings []Listing `json:"listings"`
+}
+
+// HistoryManager is implemented by the insights engine
+type HistoryManager interface {
+	RBLListingHistory(context.Context) ([]Timeline, error)
+	RequestRBLDelisting(ctx context.Context, listingID int64, remindInterval time.Duration, notes string) error
+	CancelRBLDelisting(ctx context.Context, listingID int64) error
+}
+
+// the IPs might come either in the 4 or 16 bytes forms, but are stored in only one of them
+func normalizedIP(ip net.IP) []byte {
+	return ip.To16()
+}
+
+type openListing struct {
+	id  int64
+	rbl string
+}
+
+func openListings(tx *sql.Tx, address net.IP) (listings map[string]openListing, err error) {
+	rows, err := tx.Query(`select id, rbl from rbl_listings where address = ? and delisted_ts = 0 and unchecked_ts = 0`, normalizedIP(address))
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	listings = map[string]openListing{}
+
+	for rows.Next() {
+		var l openListing
+
+		if err := rows.Scan(&l.id, &l.rbl); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		listings[l.rbl] = l
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return listings, nil
+}
+
+// closeUncheckedListings closes the listings still open of the IPs not scanned anymore,
+// as when the user changed the IPs of the mail server
+func closeUncheckedListings(tx *sql.Tx, scanned []net.IP, now time.Time) error {
+	ids, err := func() (ids []int64, err error) {
+		rows, err := tx.Query(`select id, address from rbl_listings where delisted_ts = 0 and unchecked_ts = 0`)
+		if err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+		for rows.Next() {
+			var (
+				id      int64
+				address []byte
+			)
+
+			if err := rows.Scan(&id, &address); err != nil {
+				return nil, errorutil.Wrap(err)
+			}
+
+			if !containsIP(scanned, address) {
+				ids = append(ids, id)
+			}
+		}
+
+		if err := rows.Err(); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		return ids, nil
+	}()
+
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	for _, id := range ids {
+		if _, err := tx.Exec(`update rbl_listings set unchecked_ts = ? where id = ?`, now.Unix(), id); err != nil {
+			return errorutil.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+func containsIP(ips []net.IP, address []byte) bool {
+	for _, ip := range ips {
+		if bytes.Equal(normalizedIP(ip), address) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// recordScanResults stores the result of the scan of an IP by each RBL, updating its listings.
+// The RBLs which failed to answer don't change anything.
+// The listings still open of the IPs not scanned in the same round are closed as unchecked
+func recordScanResults(tx *sql.Tx, r localrbl.Results, now time.Time, retention time.Duration) error {
+	address := normalizedIP(r.Address)
+
+	scanned := r.Scanned
+	if len(scanned) == 0 {
+		scanned = []net.IP{r.Address}
+	}
+
+	if err := closeUncheckedListings(tx, scanned, now); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	listed := map[string]string{}
+
+	for _, e := range r.RBLs {
+		listed[e.RBL] = e.Text
+	}
+
+	checked := append([]string{}, r.Checked...)
+
+	// the listing RBLs have answered, even if the checker does not say so
+	for _, e := range r.RBLs {
+		if !containsString(checked, e.RBL) {
+			checked = append(checked, e.RBL)
+		}
+	}
+
+	current, err := openListings(tx, r.Address)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	for _, rbl := range checked {
+		text, isListed := listed[rbl]
+
+		if _, err := tx.Exec(`insert into rbl_scan_results(address, rbl, scan_ts, listed) values(?, ?, ?, ?)`,
+			address, rbl, now.Unix(), isListed); err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		l, wasListed := current[rbl]
+
+		switch {
+		case isListed && wasListed:
+			if _, err := tx.Exec(`update rbl_listings set last_seen_ts = ?, text = ? where id = ?`, now.Unix(), text, l.id); err != nil {
+				return errorutil.Wrap(err)
+			}
+		case isListed:
+			if _, err := tx.Exec(`insert into rbl_listings(
+					address, rbl, text, listed_ts, last_seen_ts, delisted_ts,
+					delisting_requested_ts, delisting_remind_interval, delisting_next_reminder_ts, delisting_notes)
+				values(?, ?, ?, ?, ?, 0, 0, 0, 0, '')`, address, rbl, text, now.Unix(), now.Unix()); err != nil {
+				return errorutil.Wrap(err)
+			}
+		case wasListed:
+			if _, err := tx.Exec(`update rbl_listings set delisted_ts = ? where id = ?`, now.Unix(), l.id); err != nil {
+				return errorutil.Wrap(err)
+			}
+		}
+	}
+
+	if retention == 0 {
+		return nil
+	}
+
+	if _, err := tx.Exec(`delete from rbl_scan_results where scan_ts < ?`, now.Add(-retention).Unix()); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func containsString(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+// remindPendingDelistings generates an insight for each listing of the address
+// still there a while after the user requested its removal
+func remindPendingDelistings(tx *sql.Tx, c core.Clock, creator core.Creator, address net.IP) error {
+	now := c.Now()
+
+	type pending struct {
+		id             int64
+		rbl            string
+		listedTs       int64
+		requestedTs    int64
+		remindInterval int64
+		notes          string
+	}
+
+	reminders, err := func() (reminders []pending, err error) {
+		rows, err := tx.Query(`
+			select
+				id, rbl, listed_ts, delisting_requested_ts, delisting_remind_interval, delisting_notes
+			from
+				rbl_listings
+			where
+				address = ? and delisted_ts = 0 and unchecked_ts = 0 and delisting_requested_ts != 0 and delisting_next_reminder_ts <= ?
+			order by
+				id`, normalizedIP(address), now.Unix())
+		if err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+		for rows.Next() {
+			var p pending
+
+			if err := rows.Scan(&p.id, &p.rbl, &p.listedTs, &p.requestedTs, &p.remindInterval, &p.notes); err != nil {
+				return nil, errorutil.Wrap(err)
+			}
+
+			reminders = append(reminders, p)
+		}
+
+		if err := rows.Err(); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		return reminders, nil
+	}()
+
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	for _, p := range reminders {
+		if err := generateDelistingReminder(tx, c, creator, DelistingReminderContent{
+			ListingID:   p.id,
+			Address:     address,
+			RBL:         p.rbl,
+			ListedAt:    timeFromTs(p.listedTs),
+			RequestedAt: timeFromTs(p.requestedTs),
+			Notes:       p.notes,
+		}); err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		if _, err := tx.Exec(`update rbl_listings set delisting_next_reminder_ts = ? where id = ?`, now.Unix()+p.remindInterval, p.id); err != nil {
+			return errorutil.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// RequestDelisting records that the user requested the removal of the IP from the RBL of the listing,
+// and wants to be reminded after the interval if it's still listed
+func RequestDelisting(ctx context.Context, tx *sql.Tx, listingID int64, remindInterval time.Duration, notes string, now time.Time) error {
+	var delistedTs, uncheckedTs int64
+
+	err := tx.QueryRowContext(ctx, `select delisted_ts, unchecked_ts from rbl_listings where id = ?`, listingID).Scan(&delistedTs, &uncheckedTs)
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		return ErrNoSuchListing
+	}
+
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if delistedTs != 0 {
+		return ErrAlreadyDelisted
+	}
+
+	if uncheckedTs != 0 {
+		return ErrNotChecked
+	}
+
+	if remindInterval == 0 {
+		remindInterval = DefaultDelistingReminderInterval
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		update rbl_listings set
+			delisting_requested_ts = ?, delisting_remind_interval = ?, delisting_next_reminder_ts = ?, delisting_notes = ?
+		where
+			id = ?`,
+		now.Unix(), int64(remindInterval.Seconds()), now.Add(remindInterval).Unix(), notes, listingID)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+// CancelDelisting forgets about the removal request of a listing, stopping the reminders
+func CancelDelisting(ctx context.Context, tx *sql.Tx, listingID int64) error {
+	result, err := tx.ExecContext(ctx, `
+		update rbl_listings set
+			delisting_requested_ts = 0, delisting_remind_interval = 0, delisting_next_reminder_ts = 0, delisting_notes = ''
+		where
+			id = ?`, listingID)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if affected == 0 {
+		return ErrNoSuchListing
+	}
+
+	return nil
+}
+
+func timeFromTs(ts int64) time.Time {
+	return time.Unix(ts, 0).In(time.UTC)
+}
+
+func lastChecks(ctx context.Context, conn *dbconn.RoPooledConn) (checks map[timelineKey]time.Time, err error) {
+	rows, err := conn.QueryContext(ctx, `select address, rbl, max(scan_ts) from rbl_scan_results group by address, rbl`)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	checks = map[timelineKey]time.Time{}
+
+	for rows.Next() {
+		var (
+			address []byte
+			rbl     string
+			ts      int64
+		)
+
+		if err := rows.Scan(&address, &rbl, &ts); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		checks[timelineKey{address: string(address), rbl: rbl}] = timeFromTs(ts)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return checks, nil
+}
+
+type timelineKey struct {
+	address string
+	rbl     string
+}
+
+func scanListing(rows *sql.Rows) (timelineKey, Listing, error) {
+	var (
+		address                                       []byte
+		rbl                                           string
+		l                                             Listing
+		listedTs, lastSeenTs, delistedTs, uncheckedTs int64
+		requestedTs, remindInterval, nextReminderTs   int64
+		notes                                         string
+	)
+
+	if err := rows.Scan(&l.ID, &address, &rbl, &l.Text, &listedTs, &lastSeenTs, &delistedTs, &uncheckedTs,
+		&requestedTs, &remindInterval, &nextReminderTs, &notes); err != nil {
+		return timelineKey{}, Listing{}, errorutil.Wrap(err)
+	}
+
+	l.ListedAt = timeFromTs(listedTs)
+	l.LastSeenAt = timeFromTs(lastSeenTs)
+
+	if delistedTs != 0 {
+		delistedAt := timeFromTs(delistedTs)
+		timeToDelist := delistedTs - listedTs
+		l.DelistedAt = &delistedAt
+		l.TimeToDelist = &timeToDelist
+	}
+
+	if uncheckedTs != 0 {
+		uncheckedAt := timeFromTs(uncheckedTs)
+		l.UncheckedAt = &uncheckedAt
+	}
+
+	if requestedTs != 0 {
+		l.Delisting = &Delisting{
+			RequestedAt:    timeFromTs(requestedTs),
+			RemindInterval: remindInterval,
+			NextReminderAt: timeFromTs(nextReminderTs),
+			Notes:          notes,
+		}
+
+		if delistedTs != 0 {
+			timeToDelist := delistedTs - requestedTs
+			l.Delisting.TimeToDelist = &timeToDelist
+		}
+	}
+
+	return timelineKey{address: string(address), rbl: rbl}, l, nil
+}
+
+// ListingHistory returns, for each IP and RBL which ever listed it, all the listings,
+// with the ones currently listed first
+func ListingHistory(ctx context.Context, pool *dbconn.RoPool) (timelines []Timeline, err error) {
+	conn, release, err := pool.AcquireContext(ctx)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer release()
+
+	checks, err := lastChecks(ctx, conn)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	rows, err := conn.QueryContext(ctx, `
+		select
+			id, address, rbl, text, listed_ts, last_seen_ts, delisted_ts, unchecked_ts,
+			delisting_requested_ts, delisting_remind_interval, delisting_next_reminder_ts, delisting_notes
+		from
+			rbl_listings
+		order by
+			listed_ts, id`)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	byKey := map[timelineKey]*Timeline{}
+	keys := []timelineKey{}
+
+	for rows.Next() {
+		key, l, err := scanListing(rows)
+		if err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		t, ok := byKey[key]
+		if !ok {
+			t = &Timeline{Address: net.IP(key.address), RBL: key.rbl, Listings: []Listing{}}
+
+			if checkedAt, ok := checks[key]; ok {
+				t.LastCheckedAt = &checkedAt
+			}
+
+			byKey[key] = t
+			keys = append(keys, key)
+		}
+
+		t.Listings = append(t.Listings, l)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	timelines = make([]Timeline, 0, len(keys))
+
+	for _, key := range keys {
+		t := byKey[key]
+
+		t.TimesListed = len(t.Listings)
+
+		var (
+			total int64
+			ended int64
+		)
+
+		for _, l := range t.Listings {
+			if l.TimeToDelist == nil && l.UncheckedAt == nil {
+				t.Listed = true
+			}
+
+			if l.TimeToDelist == nil {
+				continue
+			}
+
+			total += *l.TimeToDelist
+			ended++
+		}
+
+		if ended > 0 {
+			average := total / ended
+			t.AverageTimeToDelist = &average
+		}
+
+		timelines = append(timelines, *t)
+	}
+
+	sort.SliceStable(timelines, func(i, j int) bool {
+		if timelines[i].Listed != timelines[j].Listed {
+			return timelines[i].Listed
+		}
+
+		if c := bytes.Compare(timelines[i].Address, timelines[j].Address); c != 0 {
+			return c < 0
+		}
+
+		return timelines[i].RBL < timelines[j].RBL
+	})
+
+	return timelines, nil
+}
@@ -0,0 +1,316 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package localrblinsight
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	insighttestsutil "gitlab.com/lightmeter/controlcenter/insights/testutil"
+	"gitlab.com/lightmeter/controlcenter/localrbl"
+	"gitlab.com/lightmeter/controlcenter/util/testutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestListingHistory(t *testing.T) {
+	Convey("Listing History", t, func() {
+		accessor, clear := insighttestsutil.NewFakeAccessor(t)
+		defer clear()
+
+		clock := &insighttestsutil.FakeClock{Time: testutil.MustParseTime(`2000-01-01 00:00:00 +0000`)}
+
+		ip := net.ParseIP("11.22.33.44")
+
+		// all the IPs scanned in the round, only ip if empty
+		var scanned []net.IP
+
+		scan := func(checked []string, listed ...string) {
+			elems := []localrbl.ContentElement{}
+
+			for _, rbl := range listed {
+				elems = append(elems, localrbl.ContentElement{RBL: rbl, Text: "Listed by " + rbl})
+			}
+
+			err := accessor.ConnPair.RwConn.Tx(dummyContext, func(ctx context.Context, tx *sql.Tx) error {
+				if err := recordScanResults(tx, localrbl.Results{Address: ip, RBLs: elems, Checked: checked, Scanned: scanned}, clock.Now(), time.Hour*24*10); err != nil {
+					return err
+				}
+
+				return remindPendingDelistings(tx, clock, accessor, ip)
+			})
+
+			So(err, ShouldBeNil)
+		}
+
+		history := func() []Timeline {
+			timelines, err := ListingHistory(dummyContext, accessor.ConnPair.RoConnPool)
+			So(err, ShouldBeNil)
+			return timelines
+		}
+
+		tx := func(f func(*sql.Tx) error) error {
+			return accessor.ConnPair.RwConn.Tx(dummyContext, func(ctx context.Context, tx *sql.Tx) error {
+				return f(tx)
+			})
+		}
+
+		Convey("Nothing scanned", func() {
+			So(history(), ShouldBeEmpty)
+		})
+
+		Convey("Never listed", func() {
+			scan([]string{"rbl1", "rbl2"})
+			So(history(), ShouldBeEmpty)
+		})
+
+		Convey("Listed and delisted", func() {
+			scan([]string{"rbl1", "rbl2"}, "rbl1")
+
+			clock.Sleep(time.Hour)
+			scan([]string{"rbl1", "rbl2"}, "rbl1", "rbl2")
+
+			// rbl1 failed to answer, so it's not known whether it still lists the IP
+			clock.Sleep(time.Hour)
+			scan([]string{"rbl2"}, "rbl2")
+
+			clock.Sleep(time.Hour)
+			scan([]string{"rbl1", "rbl2"}, "rbl2")
+
+			timelines := history()
+			So(len(timelines), ShouldEqual, 2)
+
+			So(timelines[0].RBL, ShouldEqual, "rbl2")
+			So(timelines[0].Listed, ShouldBeTrue)
+			So(timelines[0].Address.Equal(ip), ShouldBeTrue)
+			So(timelines[0].AverageTimeToDelist, ShouldBeNil)
+			So(*timelines[0].LastCheckedAt, ShouldResemble, testutil.MustParseTime(`2000-01-01 03:00:00 +0000`))
+
+			So(timelines[1].RBL, ShouldEqual, "rbl1")
+			So(timelines[1].Listed, ShouldBeFalse)
+			So(timelines[1].TimesListed, ShouldEqual, 1)
+			So(*timelines[1].AverageTimeToDelist, ShouldEqual, 3*60*60)
+			So(timelines[1].Listings[0].ListedAt, ShouldResemble, testutil.MustParseTime(`2000-01-01 00:00:00 +0000`))
+			So(timelines[1].Listings[0].LastSeenAt, ShouldResemble, testutil.MustParseTime(`2000-01-01 01:00:00 +0000`))
+			So(*timelines[1].Listings[0].DelistedAt, ShouldResemble, testutil.MustParseTime(`2000-01-01 03:00:00 +0000`))
+
+			Convey("Listed again", func() {
+				clock.Sleep(time.Hour * 2)
+				scan([]string{"rbl1", "rbl2"}, "rbl1", "rbl2")
+
+				timelines := history()
+				So(len(timelines), ShouldEqual, 2)
+				So(timelines[0].RBL, ShouldEqual, "rbl1")
+				So(timelines[0].Listed, ShouldBeTrue)
+				So(timelines[0].TimesListed, ShouldEqual, 2)
+				So(*timelines[0].AverageTimeToDelist, ShouldEqual, 3*60*60)
+				So(timelines[1].RBL, ShouldEqual, "rbl2")
+			})
+
+			Convey("Delisted listings cannot be requested for delisting", func() {
+				err := tx(func(tx *sql.Tx) error {
+					return RequestDelisting(dummyContext, tx, timelines[1].Listings[0].ID, 0, "", clock.Now())
+				})
+
+				So(errors.Is(err, ErrAlreadyDelisted), ShouldBeTrue)
+			})
+		})
+
+		Convey("Unknown listings", func() {
+			err := tx(func(tx *sql.Tx) error {
+				return RequestDelisting(dummyContext, tx, 42, 0, "", clock.Now())
+			})
+
+			So(errors.Is(err, ErrNoSuchListing), ShouldBeTrue)
+
+			err = tx(func(tx *sql.Tx) error {
+				return CancelDelisting(dummyContext, tx, 42)
+			})
+
+			So(errors.Is(err, ErrNoSuchListing), ShouldBeTrue)
+		})
+
+		Convey("Delisting requested", func() {
+			scan([]string{"rbl1"}, "rbl1")
+
+			id := history()[0].Listings[0].ID
+
+			clock.Sleep(time.Hour)
+
+			So(tx(func(tx *sql.Tx) error {
+				return RequestDelisting(dummyContext, tx, id, time.Hour*3, "Filled the form", clock.Now())
+			}), ShouldBeNil)
+
+			delisting := history()[0].Listings[0].Delisting
+			So(delisting, ShouldNotBeNil)
+			So(delisting.RequestedAt, ShouldResemble, testutil.MustParseTime(`2000-01-01 01:00:00 +0000`))
+			So(delisting.NextReminderAt, ShouldResemble, testutil.MustParseTime(`2000-01-01 04:00:00 +0000`))
+			So(delisting.RemindInterval, ShouldEqual, 3*60*60)
+			So(delisting.Notes, ShouldEqual, "Filled the form")
+			So(delisting.TimeToDelist, ShouldBeNil)
+
+			// still listed, but too early for a reminder
+			clock.Sleep(time.Hour)
+			scan([]string{"rbl1"}, "rbl1")
+			So(accessor.Insights, ShouldBeEmpty)
+
+			Convey("Reminded while still listed", func() {
+				clock.Sleep(time.Hour * 2)
+				scan([]string{"rbl1"}, "rbl1")
+				So(len(accessor.Insights), ShouldEqual, 1)
+
+				// the next reminder is only after another interval
+				clock.Sleep(time.Hour)
+				scan([]string{"rbl1"}, "rbl1")
+				So(len(accessor.Insights), ShouldEqual, 1)
+
+				clock.Sleep(time.Hour * 2)
+				scan([]string{"rbl1"}, "rbl1")
+				So(len(accessor.Insights), ShouldEqual, 2)
+
+				insights, err := accessor.FetchInsights(dummyContext, core.FetchOptions{Interval: timeutil.TimeInterval{
+					From: testutil.MustParseTime(`0000-01-01 00:00:00 +0000`),
+					To:   testutil.MustParseTime(`4000-01-01 00:00:00 +0000`),
+				}, OrderBy: core.OrderByCreationAsc}, clock)
+				So(err, ShouldBeNil)
+				So(insights[0].ContentType(), ShouldEqual, DelistingReminderContentType)
+
+				content, ok := insights[0].Content().(*DelistingReminderContent)
+				So(ok, ShouldBeTrue)
+				So(content.ListingID, ShouldEqual, id)
+				So(content.RBL, ShouldEqual, "rbl1")
+				So(content.Notes, ShouldEqual, "Filled the form")
+				So(content.Address.Equal(ip), ShouldBeTrue)
+				So(content.Description().String(), ShouldEqual,
+					"The IP address 11.22.33.44 is still listed by rbl1, although its removal was requested on 2000-01-01")
+			})
+
+			Convey("No reminders after cancelling", func() {
+				So(tx(func(tx *sql.Tx) error {
+					return CancelDelisting(dummyContext, tx, id)
+				}), ShouldBeNil)
+
+				clock.Sleep(time.Hour * 2)
+				scan([]string{"rbl1"}, "rbl1")
+				So(accessor.Insights, ShouldBeEmpty)
+				So(history()[0].Listings[0].Delisting, ShouldBeNil)
+			})
+
+			Convey("No reminders after delisting", func() {
+				clock.Sleep(time.Hour)
+				scan([]string{"rbl1"})
+
+				clock.Sleep(time.Hour * 5)
+				scan([]string{"rbl1"})
+				So(accessor.Insights, ShouldBeEmpty)
+
+				timeline := history()[0]
+				So(timeline.Listed, ShouldBeFalse)
+				So(*timeline.Listings[0].Delisting.TimeToDelist, ShouldEqual, 2*60*60)
+				So(*timeline.Listings[0].TimeToDelist, ShouldEqual, 3*60*60)
+			})
+		})
+
+		Convey("Listings of IPs scanned in the same round stay open", func() {
+			first, second := net.ParseIP("11.22.33.44"), net.ParseIP("55.66.77.88")
+			scanned = []net.IP{first, second}
+
+			round := func() {
+				ip = first
+				scan([]string{"rbl1"}, "rbl1")
+
+				ip = second
+				scan([]string{"rbl1"}, "rbl1")
+			}
+
+			round()
+
+			clock.Sleep(time.Hour)
+			round()
+
+			timelines := history()
+			So(len(timelines), ShouldEqual, 2)
+
+			for _, t := range timelines {
+				So(t.Listed, ShouldBeTrue)
+				So(t.TimesListed, ShouldEqual, 1)
+				So(t.Listings[0].UncheckedAt, ShouldBeNil)
+				So(t.Listings[0].LastSeenAt, ShouldResemble, testutil.MustParseTime(`2000-01-01 01:00:00 +0000`))
+
+				id := t.Listings[0].ID
+
+				So(tx(func(tx *sql.Tx) error {
+					return RequestDelisting(dummyContext, tx, id, 0, "", clock.Now())
+				}), ShouldBeNil)
+			}
+		})
+
+		Convey("Listings of IPs not checked anymore are closed", func() {
+			scan([]string{"rbl1", "rbl2"}, "rbl1")
+
+			id := history()[0].Listings[0].ID
+
+			// the IP of the mail server has changed
+			ip = net.ParseIP("55.66.77.88")
+
+			clock.Sleep(time.Hour)
+			scan([]string{"rbl1", "rbl2"}, "rbl2")
+
+			timelines := history()
+			So(len(timelines), ShouldEqual, 2)
+
+			So(timelines[0].Address.Equal(ip), ShouldBeTrue)
+			So(timelines[0].Listed, ShouldBeTrue)
+
+			So(timelines[1].Address.Equal(net.ParseIP("11.22.33.44")), ShouldBeTrue)
+			So(timelines[1].Listed, ShouldBeFalse)
+			So(timelines[1].AverageTimeToDelist, ShouldBeNil)
+			So(timelines[1].Listings[0].DelistedAt, ShouldBeNil)
+			So(*timelines[1].Listings[0].UncheckedAt, ShouldResemble, testutil.MustParseTime(`2000-01-01 01:00:00 +0000`))
+
+			err := tx(func(tx *sql.Tx) error {
+				return RequestDelisting(dummyContext, tx, id, 0, "", clock.Now())
+			})
+
+			So(errors.Is(err, ErrNotChecked), ShouldBeTrue)
+
+			Convey("Listed again when checked again", func() {
+				ip = net.ParseIP("11.22.33.44")
+
+				clock.Sleep(time.Hour)
+				scan([]string{"rbl1", "rbl2"}, "rbl1")
+
+				timelines := history()
+				So(len(timelines), ShouldEqual, 2)
+				So(timelines[0].Address.Equal(ip), ShouldBeTrue)
+				So(timelines[0].Listed, ShouldBeTrue)
+				So(timelines[0].TimesListed, ShouldEqual, 2)
+
+				So(timelines[1].Listed, ShouldBeFalse)
+			})
+		})
+
+		Convey("Old scan results are removed", func() {
+			scan([]string{"rbl1"}, "rbl1")
+
+			clock.Sleep(time.Hour * 24 * 11)
+			scan([]string{"rbl2"})
+
+			conn, release := accessor.ConnPair.RoConnPool.Acquire()
+			defer release()
+
+			var count int
+			So(conn.QueryRow(`select count(*) from rbl_scan_results`).Scan(&count), ShouldBeNil)
+			So(count, ShouldEqual, 1)
+
+			// but not the listings
+			So(len(history()), ShouldEqual, 1)
+		})
+	})
+}
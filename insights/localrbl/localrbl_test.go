@@ -100,6 +100,7 @@ func (c *fakeChecker) Step(now time.Time, withResults func(localrbl.Results) err
 		r := withResults(localrbl.Results{
 			Interval: timeutil.TimeInterval{From: c.scanStartTime, To: now},
 			RBLs:     []localrbl.ContentElement{{RBL: url, Text: text}},
+			Address:  c.checkedIP,
 		})
 
 		return r
@@ -116,8 +117,8 @@ func (c *fakeChecker) Step(now time.Time, withResults func(localrbl.Results) err
 	return withoutResults()
 }
 
-func (c *fakeChecker) IPAddress(context.Context) net.IP {
-	return c.checkedIP
+func (c *fakeChecker) IPAddresses(context.Context) []net.IP {
+	return []net.IP{c.checkedIP}
 }
 
 func TestLocalRBL(t *testing.T) {
@@ -399,7 +400,7 @@ func TestLocalRBL(t *testing.T) {
 
 					{
 						settings := globalsettings.Settings{
-							LocalIP: globalsettings.IP{net.ParseIP("11.22.33.44")},
+							LocalIP: globalsettings.IP{IP: net.ParseIP("11.22.33.44")},
 						}
 
 						err := m.Writer.StoreJson(dummyContext, globalsettings.SettingsKey, &settings)
@@ -494,6 +495,24 @@ func TestDescriptionFormatting(t *testing.T) {
 	})
 }
 
+func TestMuteParams(t *testing.T) {
+	Convey("Listings are muted by address and RBLs", t, func() {
+		c := Content{
+			Address: net.ParseIP(`127.0.0.1`),
+			RBLs: []localrbl.ContentElement{
+				{RBL: "zen.example.com", Text: "Some error message"},
+				{RBL: "bl.example.org", Text: "Some other error message"},
+			},
+		}
+
+		So(c.MuteParams(), ShouldResemble, map[string]string{"address": "127.0.0.1", "rbl": "bl.example.org,zen.example.com"})
+
+		c.RBLs = c.RBLs[:1]
+
+		So(c.MuteParams(), ShouldResemble, map[string]string{"address": "127.0.0.1", "rbl": "zen.example.com"})
+	})
+}
+
 func fakeLookup(rblList string, targetHost string) godnsbl.RBLResults {
 	if !strings.HasSuffix(rblList, "-blocked") {
 		return godnsbl.RBLResults{}
@@ -17,6 +17,8 @@ import (
 	"gitlab.com/lightmeter/controlcenter/util/errorutil"
 	"gitlab.com/lightmeter/controlcenter/util/timeutil"
 	"net"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -25,6 +27,9 @@ type Options struct {
 	CheckInterval               time.Duration
 	RetryOnScanErrorInterval    time.Duration
 	MinTimeToGenerateNewInsight time.Duration
+
+	// For how long the result of each scan is kept. Forever if zero
+	HistoryRetention time.Duration
 }
 
 type Content struct {
@@ -45,6 +50,20 @@ func (c Content) Metadata() notificationCore.ContentMetadata {
 	return nil
 }
 
+// MuteParams has all the RBLs the address is listed on, so muting a listing
+// does not hide the ones on other RBLs
+func (c Content) MuteParams() map[string]string {
+	rbls := make([]string, 0, len(c.RBLs))
+
+	for _, r := range c.RBLs {
+		rbls = append(rbls, r.RBL)
+	}
+
+	sort.Strings(rbls)
+
+	return map[string]string{"address": c.Address.String(), "rbl": strings.Join(rbls, ",")}
+}
+
 type title struct {
 	c Content
 }
@@ -120,46 +139,69 @@ func NewDetector(creator core.Creator, options core.Options) core.Detector {
 	}
 }
 
-func shouldGenerateBasedOnHistoricalDataAndCurrentResults(ctx context.Context, d *detector, r localrbl.Results, c core.Clock, tx *sql.Tx) (bool, error) {
-	now := c.Now()
-
-	lookbackTime := now.Add(-d.options.MinTimeToGenerateNewInsight)
-
-	var lastInsightRawContent string
-
-	err := tx.QueryRowContext(ctx, `select
+// lastContentForAddress returns the content of the most recent insight about the address since the lookback time
+func lastContentForAddress(ctx context.Context, tx *sql.Tx, address net.IP, lookbackTime time.Time) (content *Content, err error) {
+	rows, err := tx.QueryContext(ctx, `select
 			content
 		from
 			insights
 		where
 			time >= ? and content_type = ?
 		order by
-			time desc
-		limit
-			1
-		`, lookbackTime.Unix(), ContentTypeId).Scan(&lastInsightRawContent)
+			time desc, rowid desc
+		`, lookbackTime.Unix(), ContentTypeId)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
 
-	if err != nil && errors.Is(err, sql.ErrNoRows) {
-		return true, nil
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	for rows.Next() {
+		var rawContent string
+
+		if err := rows.Scan(&rawContent); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		v, err := decodeContent([]byte(rawContent))
+		if err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		//nolint:forcetypeassert
+		c := v.(*Content)
+
+		// the insights created before many IPs were supported have no address
+		if c.Address == nil || c.Address.Equal(address) {
+			return c, nil
+		}
 	}
 
-	if err != nil {
-		return false, errorutil.Wrap(err)
+	if err := rows.Err(); err != nil {
+		return nil, errorutil.Wrap(err)
 	}
 
-	v, err := decodeContent([]byte(lastInsightRawContent))
+	return nil, nil
+}
+
+func shouldGenerateBasedOnHistoricalDataAndCurrentResults(ctx context.Context, d *detector, r localrbl.Results, c core.Clock, tx *sql.Tx) (bool, error) {
+	now := c.Now()
+
+	lookbackTime := now.Add(-d.options.MinTimeToGenerateNewInsight)
+
+	lastContent, err := lastContentForAddress(ctx, tx, r.Address, lookbackTime)
 	if err != nil {
 		return false, errorutil.Wrap(err)
 	}
 
-	if err != nil {
-		return false, errorutil.Wrap(err)
+	if lastContent == nil {
+		return true, nil
 	}
 
-	resultChanged := contentsHaveDifferentLists(r.RBLs, v.(*Content).RBLs)
+	resultChanged := contentsHaveDifferentLists(r.RBLs, lastContent.RBLs)
 
 	if !resultChanged {
-		log.Info().Msg("RBL Scan result will not generate a new insight as scan results have not changed since last insight")
+		log.Info().Msgf("RBL Scan result will not generate a new insight as scan results for %v have not changed since last insight", r.Address)
 	}
 
 	return resultChanged, nil
@@ -194,11 +236,28 @@ func maybeCreateInsightForResult(ctx context.Context, d *detector, r localrbl.Re
 
 	return generateInsight(tx, c, d.creator, Content{
 		ScanInterval: r.Interval,
-		Address:      d.options.Checker.IPAddress(context.Background()),
+		Address:      r.Address,
 		RBLs:         r.RBLs,
 	})
 }
 
+func handleScanResult(ctx context.Context, d *detector, r localrbl.Results, c core.Clock, tx *sql.Tx) error {
+	if err := recordScanResults(tx, r, c.Now(), d.options.HistoryRetention); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if err := remindPendingDelistings(tx, c, d.creator, r.Address); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	// the IP is not listed anywhere
+	if len(r.RBLs) == 0 {
+		return nil
+	}
+
+	return maybeCreateInsightForResult(ctx, d, r, c, tx)
+}
+
 const (
 	detectionKind = "local_rbl_scan_start"
 )
@@ -246,7 +305,7 @@ func (d *detector) Step(c core.Clock, tx *sql.Tx) error {
 
 		if r.Err == nil {
 			// a scan result is available
-			return maybeCreateInsightForResult(ctx, d, r, c, tx)
+			return handleScanResult(ctx, d, r, c, tx)
 		}
 
 		// A scan just ended with an error, schedule a new scan shortly after the current failure
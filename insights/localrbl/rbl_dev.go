@@ -15,14 +15,21 @@ import (
 	"gitlab.com/lightmeter/controlcenter/localrbl"
 	"gitlab.com/lightmeter/controlcenter/util/errorutil"
 	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+	"net"
 	"time"
 )
 
 // Executed only on development builds, for better developer experience
 func (d *detector) GenerateSampleInsight(tx *sql.Tx, c core.Clock) error {
+	address := net.ParseIP("127.0.0.2")
+
+	if addresses := d.options.Checker.IPAddresses(context.Background()); len(addresses) > 0 {
+		address = addresses[0]
+	}
+
 	if err := generateInsight(tx, c, d.creator, Content{
 		ScanInterval: timeutil.TimeInterval{From: c.Now(), To: c.Now().Add(time.Second * 30)},
-		Address:      d.options.Checker.IPAddress(context.Background()),
+		Address:      address,
 		RBLs: []localrbl.ContentElement{
 			{RBL: "rbl.com", Text: "Funny reason"},
 			{RBL: "anotherrbl.de", Text: "Another funny reason at http://example.com Website"},
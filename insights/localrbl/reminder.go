@@ -0,0 +1,106 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package localrblinsight
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/i18n/translator"
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	notificationCore "gitlab.com/lightmeter/controlcenter/notification/core"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+const (
+	DelistingReminderContentType   = "local_rbl_delisting_reminder"
+	DelistingReminderContentTypeId = 20
+)
+
+func init() {
+	core.RegisterContentType(DelistingReminderContentType, DelistingReminderContentTypeId, core.DefaultContentTypeDecoder(&DelistingReminderContent{}))
+}
+
+// DelistingReminderContent reminds the user that an IP is still listed by a RBL
+// some time after they requested its removal
+type DelistingReminderContent struct {
+	ListingID   int64     `json:"listing_id"`
+	Address     net.IP    `json:"address"`
+	RBL         string    `json:"rbl"`
+	ListedAt    time.Time `json:"listed_at"`
+	RequestedAt time.Time `json:"requested_at"`
+	Notes       string    `json:"notes"`
+}
+
+func (c DelistingReminderContent) Title() notificationCore.ContentComponent {
+	return &reminderTitle{c}
+}
+
+func (c DelistingReminderContent) Description() notificationCore.ContentComponent {
+	return &reminderDescription{c}
+}
+
+func (c DelistingReminderContent) Metadata() notificationCore.ContentMetadata {
+	return nil
+}
+
+func (c DelistingReminderContent) MuteParams() map[string]string {
+	return map[string]string{"address": c.Address.String(), "rbl": c.RBL}
+}
+
+func (c DelistingReminderContent) HelpLink(urlContainer core.URLContainer) string {
+	return urlContainer.Get(DelistingReminderContentType)
+}
+
+type reminderTitle struct {
+	c DelistingReminderContent
+}
+
+func (t reminderTitle) String() string {
+	return translator.Stringfy(t)
+}
+
+func (t reminderTitle) TplString() string {
+	return translator.I18n("IP still on %v after the removal request")
+}
+
+func (t reminderTitle) Args() []interface{} {
+	return []interface{}{t.c.RBL}
+}
+
+type reminderDescription struct {
+	c DelistingReminderContent
+}
+
+func (d reminderDescription) String() string {
+	return translator.Stringfy(d)
+}
+
+func (d reminderDescription) TplString() string {
+	return translator.I18n("The IP address %v is still listed by %v, although its removal was requested on %v")
+}
+
+func (d reminderDescription) Args() []interface{} {
+	return []interface{}{d.c.Address, d.c.RBL, d.c.RequestedAt.Format("2006-01-02")}
+}
+
+func generateDelistingReminder(tx *sql.Tx, c core.Clock, creator core.Creator, content DelistingReminderContent) error {
+	properties := core.InsightProperties{
+		Time:           c.Now(),
+		Category:       core.LocalCategory,
+		Rating:         core.BadRating,
+		ContentType:    DelistingReminderContentType,
+		Content:        content,
+		MustBeNotified: true,
+	}
+
+	if err := creator.GenerateInsight(context.Background(), tx, properties); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
@@ -55,6 +55,10 @@ func (c Content) Metadata() notificationCore.ContentMetadata {
 	return nil
 }
 
+func (c Content) MuteParams() map[string]string {
+	return map[string]string{"host": c.Host, "address": c.Address.String()}
+}
+
 type title struct {
 	c Content
 }
@@ -116,6 +120,11 @@ func NewDetector(creator core.Creator, options core.Options) core.Detector {
 func maybeAddNewInsightFromMessage(d *detector, r messagerbl.Result, c core.Clock, tx *sql.Tx) error {
 	detectionKind := fmt.Sprintf("message_rbl_%s", r.Host)
 
+	// each sending IP is blocked independently
+	if r.Address != nil {
+		detectionKind = fmt.Sprintf("message_rbl_%s_%s", r.Host, r.Address)
+	}
+
 	t, err := core.RetrieveLastDetectorExecution(tx, detectionKind)
 	if err != nil {
 		return errorutil.Wrap(err)
@@ -128,12 +137,12 @@ func maybeAddNewInsightFromMessage(d *detector, r messagerbl.Result, c core.Cloc
 
 	// MinTimeToGenerateNewInsight
 	if t.Add(d.options.MinTimeToGenerateNewInsight).After(insightTime) {
-		log.Info().Msgf("Ignoring RBL insight for host %v that has been generated %v ago", r.Host, insightTime.Sub(t))
+		log.Info().Msgf("Ignoring RBL insight for host %v and IP %v that has been generated %v ago", r.Host, r.Address, insightTime.Sub(t))
 		return nil
 	}
 
 	content := Content{
-		Address:   d.options.Detector.IPAddress(context.Background()),
+		Address:   r.Address,
 		Message:   r.Payload.ExtraMessage,
 		Recipient: r.Payload.RecipientDomainPart,
 		Status:    r.Payload.Status.String(),
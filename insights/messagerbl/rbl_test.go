@@ -61,11 +61,15 @@ func parseLogLine(line string) (parser.Header, parser.Payload) {
 }
 
 func actionFromLog(converter *parsertimeutil.TimeConverter, host string, line string) func() messagerbl.Result {
+	return actionFromLogWithAddress(converter, host, net.ParseIP("127.0.0.2"), line)
+}
+
+func actionFromLogWithAddress(converter *parsertimeutil.TimeConverter, host string, address net.IP, line string) func() messagerbl.Result {
 	return func() messagerbl.Result {
 		h, p := parseLogLine(line)
 
 		return messagerbl.Result{
-			Address: net.ParseIP("127.0.0.2"),
+			Address: address,
 			Host:    host,
 			Header:  h,
 			Payload: p.(parser.SmtpSentStatus),
@@ -178,6 +182,52 @@ func TestMessageRBLInsight(t *testing.T) {
 	})
 }
 
+func TestMessageRBLInsightManySendingIPs(t *testing.T) {
+	Convey("Each sending IP blocked by the same host generates its own insight", t, func() {
+		accessor, clearAccessor := insighttestsutil.NewFakeAccessor(t)
+		defer clearAccessor()
+
+		baseTime := testutil.MustParseTime(`2000-01-01 00:00:00 +0000`)
+		clock := &insighttestsutil.FakeClock{Time: baseTime}
+		converter := parsertimeutil.NewTimeConverter(baseTime, clock, func(int, parser.Time, parser.Time) {})
+
+		actions := map[time.Time]func() messagerbl.Result{
+			baseTime.Add(time.Second * 2): actionFromLogWithAddress(&converter, "Host 1", net.ParseIP("127.0.0.2"), `Jan  1 00:00:02 node postfix/smtp[12357]: 375593D395: to=<recipient@example.com>, relay=relay.example.com[254.112.150.90]:25, delay=0.86, delays=0.1/0/0.71/0.05, dsn=5.7.606, status=bounced (Error for 127.0.0.2)`),
+
+			// another IP, blocked by the same host
+			baseTime.Add(time.Second * 10): actionFromLogWithAddress(&converter, "Host 1", net.ParseIP("2001:db8::1"), `Jan  1 00:00:10 node postfix/smtp[12357]: 375593D395: to=<recipient@example.com>, relay=relay.example.com[254.112.150.90]:25, delay=0.86, delays=0.1/0/0.71/0.05, dsn=5.7.606, status=bounced (Error for 2001:db8::1)`),
+
+			// the first IP again, still too recent
+			baseTime.Add(time.Second * 20): actionFromLogWithAddress(&converter, "Host 1", net.ParseIP("127.0.0.2"), `Jan  1 00:00:20 node postfix/smtp[12357]: 375593D395: to=<recipient@example.com>, relay=relay.example.com[254.112.150.90]:25, delay=0.86, delays=0.1/0/0.71/0.05, dsn=5.7.606, status=bounced (Error for 127.0.0.2 again)`),
+		}
+
+		checker := &fakeChecker{clock: clock, actions: actions}
+
+		detector := NewDetector(accessor, core.Options{
+			"messagerbl": Options{
+				Detector:                    checker,
+				MinTimeToGenerateNewInsight: time.Second * 70,
+			},
+		})
+
+		insighttestsutil.ExecuteCyclesUntil(detector, accessor, clock, testutil.MustParseTime(`2000-01-01 00:30:00 +0000`), time.Second*2)
+
+		So(accessor.Insights, ShouldResemble, []int64{1, 2})
+
+		insights, err := accessor.FetchInsights(dummyContext, core.FetchOptions{Interval: timeutil.TimeInterval{
+			From: testutil.MustParseTime(`0000-01-01 00:00:00 +0000`),
+			To:   testutil.MustParseTime(`4000-01-01 00:00:00 +0000`),
+		},
+			OrderBy: core.OrderByCreationAsc,
+		}, clock)
+
+		So(err, ShouldBeNil)
+
+		So(insights[0].Content().(*Content).Address, ShouldResemble, net.ParseIP("127.0.0.2"))
+		So(insights[1].Content().(*Content).Address, ShouldResemble, net.ParseIP("2001:db8::1"))
+	})
+}
+
 func TestRegression(t *testing.T) {
 	Convey("Clock is in the past, where requests are more recent", t, func() {
 		// This happens during historical import, in case the "historical clock" starts much
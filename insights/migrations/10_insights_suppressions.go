@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package migrations
+
+import (
+	"database/sql"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/migrator"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+func init() {
+	migrator.AddMigration("insights", "10_insights_suppressions.go", upInsightsSuppressions, downInsightsSuppressions)
+}
+
+func upInsightsSuppressions(tx *sql.Tx) error {
+	sql := `
+	create table insights_suppressions(
+		id integer primary key,
+		kind integer not null,
+		content_type integer not null,
+		params blob not null,
+		creation_ts integer not null,
+		until_ts integer not null,
+		insight_id integer not null
+	);
+
+	create index insights_suppressions_content_type_index on insights_suppressions(content_type, until_ts);
+
+	create table insights_suppressed(
+		insight_id integer not null,
+		suppression_id integer not null
+	);
+
+	create index insights_suppressed_insight_index on insights_suppressed(insight_id, suppression_id);
+	create index insights_suppressed_suppression_index on insights_suppressed(suppression_id);
+	`
+
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func downInsightsSuppressions(tx *sql.Tx) error {
+	_, err := tx.Exec(`drop table insights_suppressed; drop table insights_suppressions`)
+	return err
+}
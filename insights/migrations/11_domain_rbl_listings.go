@@ -0,0 +1,43 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package migrations
+
+import (
+	"database/sql"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/migrator"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+func init() {
+	migrator.AddMigration("insights", "11_domain_rbl_listings.go", upDomainRBLListings, downDomainRBLListings)
+}
+
+func upDomainRBLListings(tx *sql.Tx) error {
+	// delisted_ts is zero while the domain is still listed
+	sql := `
+	create table domain_rbl_listings(
+		id integer primary key,
+		domain text not null,
+		blocklist text not null,
+		code text not null,
+		listed_ts integer not null,
+		delisted_ts integer not null
+	);
+
+	create index domain_rbl_listings_domain_index on domain_rbl_listings(domain, delisted_ts);
+	`
+
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func downDomainRBLListings(tx *sql.Tx) error {
+	_, err := tx.Exec(`drop table domain_rbl_listings`)
+	return err
+}
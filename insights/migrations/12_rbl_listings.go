@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package migrations
+
+import (
+	"database/sql"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/migrator"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+func init() {
+	migrator.AddMigration("insights", "12_rbl_listings.go", upRBLListings, downRBLListings)
+}
+
+func upRBLListings(tx *sql.Tx) error {
+	// delisted_ts is zero while the IP is still listed,
+	// and delisting_requested_ts is zero while the user has not requested the removal from the list
+	sql := `
+	create table rbl_scan_results(
+		id integer primary key,
+		address blob not null,
+		rbl text not null,
+		scan_ts integer not null,
+		listed integer not null
+	);
+
+	create index rbl_scan_results_time_index on rbl_scan_results(scan_ts);
+
+	create index rbl_scan_results_address_index on rbl_scan_results(address, rbl, scan_ts);
+
+	create table rbl_listings(
+		id integer primary key,
+		address blob not null,
+		rbl text not null,
+		text text not null,
+		listed_ts integer not null,
+		last_seen_ts integer not null,
+		delisted_ts integer not null,
+		delisting_requested_ts integer not null,
+		delisting_remind_interval integer not null,
+		delisting_next_reminder_ts integer not null,
+		delisting_notes text not null
+	);
+
+	create index rbl_listings_address_index on rbl_listings(address, rbl, delisted_ts);
+	`
+
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func downRBLListings(tx *sql.Tx) error {
+	_, err := tx.Exec(`drop table rbl_scan_results; drop table rbl_listings`)
+	return err
+}
@@ -0,0 +1,30 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package migrations
+
+import (
+	"database/sql"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/migrator"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+func init() {
+	migrator.AddMigration("insights", "13_saved_searches_watermark.go", upSavedSearchesWatermark, downSavedSearchesWatermark)
+}
+
+func upSavedSearchesWatermark(tx *sql.Tx) error {
+	// last_delivery_id is the greatest delivery already reported by the search, zero if none
+	_, err := tx.Exec(`alter table saved_searches add column last_delivery_id integer not null default 0`)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func downSavedSearchesWatermark(tx *sql.Tx) error {
+	_, err := tx.Exec(`alter table saved_searches drop column last_delivery_id`)
+	return err
+}
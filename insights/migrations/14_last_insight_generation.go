@@ -0,0 +1,52 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package migrations
+
+import (
+	"database/sql"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/migrator"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+func init() {
+	migrator.AddMigration("insights", "14_last_insight_generation.go", upLastInsightGeneration, downLastInsightGeneration)
+}
+
+func upLastInsightGeneration(tx *sql.Tx) error {
+	// when an insight about each subject (an IP, an account...) was last generated by a detector.
+	// Such times used to be stored in last_detector_execution, one row per subject, never removed.
+	sql := `
+	create table last_insight_generation(
+		id integer primary key,
+		kind text not null,
+		subject text not null,
+		ts integer not null
+	);
+
+	create unique index last_insight_generation_subject_index on last_insight_generation(kind, subject);
+
+	create index last_insight_generation_time_index on last_insight_generation(kind, ts);
+
+	delete from last_detector_execution
+	where
+		kind glob 'directory_harvest_*' or kind glob 'provider_rate_*' or kind glob 'compromised_account_*';
+	`
+
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func downLastInsightGeneration(tx *sql.Tx) error {
+	_, err := tx.Exec(`drop table last_insight_generation`)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
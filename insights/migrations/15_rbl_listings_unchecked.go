@@ -0,0 +1,52 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package migrations
+
+import (
+	"database/sql"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/migrator"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+func init() {
+	migrator.AddMigration("insights", "15_rbl_listings_unchecked.go", upRBLListingsUnchecked, downRBLListingsUnchecked)
+}
+
+func upRBLListingsUnchecked(tx *sql.Tx) error {
+	// unchecked_ts is zero while the address of the listing is still checked,
+	// otherwise it's when the listing was closed for the address not being checked anymore,
+	// as it's then unknown whether and when it got delisted
+	sql := `
+	alter table rbl_listings add column unchecked_ts integer not null default 0;
+
+	drop index rbl_listings_address_index;
+
+	create index rbl_listings_address_index on rbl_listings(address, rbl, delisted_ts, unchecked_ts);
+	`
+
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func downRBLListingsUnchecked(tx *sql.Tx) error {
+	sql := `
+	drop index rbl_listings_address_index;
+
+	alter table rbl_listings drop column unchecked_ts;
+
+	create index rbl_listings_address_index on rbl_listings(address, rbl, delisted_ts);
+	`
+
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package migrations
+
+import (
+	"database/sql"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/migrator"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+func init() {
+	migrator.AddMigration("insights", "8_saved_searches.go", upSavedSearches, downSavedSearches)
+}
+
+func upSavedSearches(tx *sql.Tx) error {
+	sql := `
+	create table saved_searches(
+		id integer primary key,
+		user_id integer not null,
+		name text not null,
+		query blob not null,
+		run_interval integer not null,
+		creation_ts integer not null,
+		last_run_ts integer not null
+	);
+
+	create index saved_searches_user_id_index on saved_searches(user_id);
+	create index saved_searches_next_run_index on saved_searches(last_run_ts + run_interval);
+	`
+
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func downSavedSearches(tx *sql.Tx) error {
+	_, err := tx.Exec(`drop table saved_searches`)
+	return err
+}
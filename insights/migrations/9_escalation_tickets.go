@@ -0,0 +1,57 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package migrations
+
+import (
+	"database/sql"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/migrator"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+func init() {
+	migrator.AddMigration("insights", "9_escalation_tickets.go", upEscalationTickets, downEscalationTickets)
+}
+
+func upEscalationTickets(tx *sql.Tx) error {
+	sql := `
+	create table escalation_tickets(
+		id integer primary key,
+		creation_ts integer not null,
+		update_ts integer not null,
+		status text not null,
+		assignee text not null,
+		sender text not null,
+		recipient text not null,
+		some_id text not null,
+		interval_from_ts integer not null,
+		interval_to_ts integer not null,
+		messages blob not null
+	);
+
+	create index escalation_tickets_status_index on escalation_tickets(status, id);
+
+	create table escalation_comments(
+		id integer primary key,
+		ticket_id integer not null,
+		creation_ts integer not null,
+		author text not null,
+		content text not null
+	);
+
+	create index escalation_comments_ticket_index on escalation_comments(ticket_id);
+	`
+
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func downEscalationTickets(tx *sql.Tx) error {
+	_, err := tx.Exec(`drop table escalation_comments; drop table escalation_tickets`)
+	return err
+}
@@ -192,6 +192,10 @@ func (c Content) Metadata() notificationCore.ContentMetadata {
 	return nil
 }
 
+func (c Content) MuteParams() map[string]string {
+	return map[string]string{"guid": c.GUID}
+}
+
 const kind = "newsfeed_last_exec"
 
 // FIXME: this is almost copy&paste from gofeed.ParseURLWithContext
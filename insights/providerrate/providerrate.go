@@ -0,0 +1,475 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package providerrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gitlab.com/lightmeter/controlcenter/i18n/translator"
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/dbconn"
+	notificationCore "gitlab.com/lightmeter/controlcenter/notification/core"
+	"gitlab.com/lightmeter/controlcenter/pkg/postfix"
+	parser "gitlab.com/lightmeter/controlcenter/pkg/postfix/logparser"
+	"gitlab.com/lightmeter/controlcenter/tracking"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+// A block at a single big provider gets diluted in the global bounce rate by the healthy traffic
+// to everywhere else, so the bounce and deferral rates are also computed for each recipient provider,
+// where all the domains of a provider (as outlook.com and hotmail.com) are grouped by the domain mapping.
+
+const (
+	ContentType   = "high_provider_rate"
+	ContentTypeId = 15
+)
+
+// LogLineFetcher is used to find out the reasons of the bounces and deferrals,
+// which are not stored in the deliveries database
+type LogLineFetcher interface {
+	FetchLogLine(context.Context, time.Time, postfix.Sum) (string, error)
+}
+
+type Options struct {
+	CheckInterval time.Duration
+
+	// The rates are computed on the deliveries in this time span
+	TimeSpan time.Duration
+
+	// Providers which received fewer messages in the time span are not taken into account
+	MinVolume int64
+
+	// Between 0 and 1
+	BounceRateThreshold   float64
+	DeferredRateThreshold float64
+
+	// Minimum time between two insights for the same provider and status
+	MinTimeToGenerateNewInsight time.Duration
+
+	// How many distinct DSNs are included in the insight
+	MaxSamples int
+
+	// Optional
+	LogLineFetcher LogLineFetcher
+}
+
+type Sample struct {
+	DSN    string `json:"dsn"`
+	Count  int64  `json:"count"`
+	Reason string `json:"reason"`
+}
+
+type Content struct {
+	Provider string                `json:"provider"`
+	Status   string                `json:"status"`
+	Count    int64                 `json:"count"`
+	Total    int64                 `json:"total"`
+	Rate     float64               `json:"rate"`
+	Interval timeutil.TimeInterval `json:"interval"`
+	Samples  []Sample              `json:"samples"`
+}
+
+func (c Content) Title() notificationCore.ContentComponent {
+	return &title{c}
+}
+
+func (c Content) Description() notificationCore.ContentComponent {
+	return &description{c}
+}
+
+func (c Content) Metadata() notificationCore.ContentMetadata {
+	return nil
+}
+
+func (c Content) MuteParams() map[string]string {
+	return map[string]string{"provider": c.Provider, "status": c.Status}
+}
+
+type title struct {
+	c Content
+}
+
+func (t title) String() string {
+	return translator.Stringfy(t)
+}
+
+func (t title) TplString() string {
+	if t.c.Status == parser.DeferredStatus.String() {
+		return translator.I18n("High deferral rate at %v")
+	}
+
+	return translator.I18n("High bounce rate at %v")
+}
+
+func (t title) Args() []interface{} {
+	return []interface{}{t.c.Provider}
+}
+
+type description struct {
+	c Content
+}
+
+func (d description) String() string {
+	return translator.Stringfy(d)
+}
+
+func (d description) TplString() string {
+	if len(d.c.Samples) == 0 || len(d.c.Samples[0].Reason) == 0 {
+		return translator.I18n("%v percent of the %v messages sent to %v between %v and %v were %v")
+	}
+
+	return translator.I18n("%v percent of the %v messages sent to %v between %v and %v were %v, most with status %v: %v")
+}
+
+func (d description) Args() []interface{} {
+	args := []interface{}{int(d.c.Rate * 100), d.c.Total, d.c.Provider, d.c.Interval.From, d.c.Interval.To, d.c.Status}
+
+	if len(d.c.Samples) == 0 || len(d.c.Samples[0].Reason) == 0 {
+		return args
+	}
+
+	return append(args, d.c.Samples[0].DSN, d.c.Samples[0].Reason)
+}
+
+func init() {
+	core.RegisterContentType(ContentType, ContentTypeId, core.DefaultContentTypeDecoder(&Content{}))
+}
+
+type detector struct {
+	pool    *dbconn.RoPool
+	creator core.Creator
+	options Options
+}
+
+func (*detector) IsHistoricalDetector() {
+	// Required by the historical import
+}
+
+func (*detector) Close() error {
+	return nil
+}
+
+func getDetectorOptions(options core.Options) Options {
+	detectorOptions, ok := options["providerrate"].(Options)
+	if !ok {
+		errorutil.MustSucceed(errors.New("Invalid detector options"))
+	}
+
+	return detectorOptions
+}
+
+func NewDetector(creator core.Creator, options core.Options) core.Detector {
+	pool, ok := options["logsConnPool"].(*dbconn.RoPool)
+	if !ok {
+		errorutil.MustSucceed(errors.New("Invalid Connection Pool"))
+	}
+
+	return &detector{
+		pool:    pool,
+		creator: creator,
+		options: getDetectorOptions(options),
+	}
+}
+
+// the provider of a recipient domain is the one in the domain mapping, or the domain itself
+const providersStmtPart = `
+with providers(id, delivery_ts, status, dsn, provider) as (
+	select
+		d.id, d.delivery_ts, d.status, d.dsn, coalesce(m.mapped, rd.domain)
+	from
+		deliveries d
+		join remote_domains rd on rd.id = d.recipient_domain_part_id
+		left join temp_domain_mapping m on m.orig = rd.domain collate nocase
+	where
+		d.delivery_ts between ? and ? and d.direction = ?
+)
+`
+
+type providerStats struct {
+	provider string
+	total    int64
+	bounced  int64
+	deferred int64
+}
+
+func fetchProviderStats(ctx context.Context, conn *dbconn.RoPooledConn, interval timeutil.TimeInterval, minVolume int64) (stats []providerStats, err error) {
+	rows, err := conn.QueryContext(ctx, providersStmtPart+`
+		select
+			provider, count(*), coalesce(sum(status = ?), 0), coalesce(sum(status = ?), 0)
+		from
+			providers
+		group by
+			provider collate nocase
+		having
+			count(*) >= ?
+		order by
+			provider collate nocase`,
+		interval.From.Unix(), interval.To.Unix(), tracking.MessageDirectionOutbound,
+		parser.BouncedStatus, parser.DeferredStatus, minVolume)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	for rows.Next() {
+		var s providerStats
+
+		if err := rows.Scan(&s.provider, &s.total, &s.bounced, &s.deferred); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		stats = append(stats, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return stats, nil
+}
+
+type sampleRef struct {
+	sample     Sample
+	deliveryID int64
+}
+
+// fetchSamples returns the most frequent DSNs, each with its most recent delivery
+func fetchSamples(ctx context.Context, conn *dbconn.RoPooledConn, interval timeutil.TimeInterval, provider string, status parser.SmtpStatus, maxSamples int) (refs []sampleRef, err error) {
+	rows, err := conn.QueryContext(ctx, providersStmtPart+`
+		select
+			dsn, count(*) as c, max(id)
+		from
+			providers
+		where
+			provider = ? collate nocase and status = ?
+		group by
+			dsn
+		order by
+			c desc, dsn
+		limit ?`,
+		interval.From.Unix(), interval.To.Unix(), tracking.MessageDirectionOutbound,
+		provider, status, maxSamples)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	for rows.Next() {
+		var r sampleRef
+
+		if err := rows.Scan(&r.sample.DSN, &r.sample.Count, &r.deliveryID); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		refs = append(refs, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return refs, nil
+}
+
+// reason returns the message the remote server replied with, as it's in the log line of the delivery
+func (d *detector) reason(ctx context.Context, conn *dbconn.RoPooledConn, deliveryID int64) (string, error) {
+	if d.options.LogLineFetcher == nil {
+		return "", nil
+	}
+
+	var (
+		ts  int64
+		sum postfix.Sum
+	)
+
+	err := conn.QueryRowContext(ctx, `select time, checksum from log_lines_ref where delivery_id = ? and ref_type = ?`,
+		deliveryID, tracking.ResultDeliveryLineChecksum).Scan(&ts, &sum)
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", errorutil.Wrap(err)
+	}
+
+	line, err := d.options.LogLineFetcher.FetchLogLine(ctx, time.Unix(ts, 0), sum)
+	if err != nil {
+		// the raw logs might have been deleted already, or not stored yet, and the reason is not essential
+		log.Warn().Msgf("Could not fetch the log line of delivery %d: %v", deliveryID, err)
+		return "", nil
+	}
+
+	_, payload, err := parser.Parse(line)
+	if !parser.IsRecoverableError(err) {
+		return "", nil
+	}
+
+	s, ok := payload.(parser.SmtpSentStatus)
+	if !ok {
+		return "", nil
+	}
+
+	// as in "(host mx.example.com[11.22.33.44] said: 550 5.7.1 Service unavailable (in reply to RCPT TO command))"
+	if strings.HasPrefix(s.ExtraMessage, "(") && strings.HasSuffix(s.ExtraMessage, ")") {
+		return s.ExtraMessage[1 : len(s.ExtraMessage)-1], nil
+	}
+
+	return s.ExtraMessage, nil
+}
+
+func (d *detector) buildContent(ctx context.Context, conn *dbconn.RoPooledConn, interval timeutil.TimeInterval, s providerStats, status parser.SmtpStatus, count int64) (Content, error) {
+	refs, err := fetchSamples(ctx, conn, interval, s.provider, status, d.options.MaxSamples)
+	if err != nil {
+		return Content{}, errorutil.Wrap(err)
+	}
+
+	samples := make([]Sample, 0, len(refs))
+
+	for _, r := range refs {
+		if r.sample.Reason, err = d.reason(ctx, conn, r.deliveryID); err != nil {
+			return Content{}, errorutil.Wrap(err)
+		}
+
+		samples = append(samples, r.sample)
+	}
+
+	return Content{
+		Provider: s.provider,
+		Status:   status.String(),
+		Count:    count,
+		Total:    s.total,
+		Rate:     float64(count) / float64(s.total),
+		Interval: interval,
+		Samples:  samples,
+	}, nil
+}
+
+// detect returns the content of the insights to be generated, without checking whether any of them was generated recently
+func (d *detector) detect(ctx context.Context, interval timeutil.TimeInterval) ([]Content, error) {
+	conn, release, err := d.pool.AcquireContext(ctx)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer release()
+
+	stats, err := fetchProviderStats(ctx, conn, interval, d.options.MinVolume)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	contents := []Content{}
+
+	for _, s := range stats {
+		for _, c := range []struct {
+			status    parser.SmtpStatus
+			count     int64
+			threshold float64
+		}{
+			{status: parser.BouncedStatus, count: s.bounced, threshold: d.options.BounceRateThreshold},
+			{status: parser.DeferredStatus, count: s.deferred, threshold: d.options.DeferredRateThreshold},
+		} {
+			if c.count == 0 || float64(c.count)/float64(s.total) <= c.threshold {
+				continue
+			}
+
+			content, err := d.buildContent(ctx, conn, interval, s, c.status, c.count)
+			if err != nil {
+				return nil, errorutil.Wrap(err)
+			}
+
+			contents = append(contents, content)
+		}
+	}
+
+	return contents, nil
+}
+
+const lastExecutionKind = "provider_rate"
+
+func (d *detector) Step(c core.Clock, tx *sql.Tx) error {
+	now := c.Now()
+
+	lastExecution, err := core.RetrieveLastDetectorExecution(tx, lastExecutionKind)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if !lastExecution.IsZero() && now.Sub(lastExecution) < d.options.CheckInterval {
+		return nil
+	}
+
+	interval := timeutil.TimeInterval{From: now.Add(-d.options.TimeSpan), To: now}
+
+	contents, err := d.detect(context.Background(), interval)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	for _, content := range contents {
+		if err := d.maybeGenerateInsight(tx, c, content); err != nil {
+			return errorutil.Wrap(err)
+		}
+	}
+
+	// the providers not reported recently can be reported again anyways
+	if err := core.ForgetLastInsightGenerations(tx, lastExecutionKind, now.Add(-d.options.MinTimeToGenerateNewInsight)); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if err := core.StoreLastDetectorExecution(tx, lastExecutionKind, now); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func (d *detector) maybeGenerateInsight(tx *sql.Tx, c core.Clock, content Content) error {
+	subject := fmt.Sprintf("%s_%s", content.Status, content.Provider)
+
+	lastGeneration, err := core.RetrieveLastInsightGeneration(tx, lastExecutionKind, subject)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if !lastGeneration.IsZero() && c.Now().Sub(lastGeneration) < d.options.MinTimeToGenerateNewInsight {
+		return nil
+	}
+
+	if err := generateInsight(tx, c, d.creator, content); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if err := core.StoreLastInsightGeneration(tx, lastExecutionKind, subject, c.Now()); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func generateInsight(tx *sql.Tx, c core.Clock, creator core.Creator, content Content) error {
+	properties := core.InsightProperties{
+		Time:        c.Now(),
+		Category:    core.LocalCategory,
+		Rating:      core.BadRating,
+		ContentType: ContentType,
+		Content:     content,
+	}
+
+	if err := creator.GenerateInsight(context.Background(), tx, properties); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
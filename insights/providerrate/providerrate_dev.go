@@ -0,0 +1,38 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+//go:build dev || !release
+// +build dev !release
+
+package providerrate
+
+import (
+	"database/sql"
+
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+// Executed only on development builds, for better developer experience
+func (d *detector) GenerateSampleInsight(tx *sql.Tx, c core.Clock) error {
+	content := Content{
+		Provider: "Microsoft",
+		Status:   "bounced",
+		Count:    312,
+		Total:    1024,
+		Rate:     float64(312) / 1024,
+		Interval: timeutil.TimeInterval{From: c.Now().Add(-d.options.TimeSpan), To: c.Now()},
+		Samples: []Sample{
+			{DSN: "5.7.1", Count: 290, Reason: "host outlook-com.olc.protection.outlook.com[104.47.1.33] said: 550 5.7.1 Service unavailable, Client host [11.22.33.44] blocked using Spamhaus (in reply to RCPT TO command)"},
+			{DSN: "5.1.1", Count: 22, Reason: "host outlook-com.olc.protection.outlook.com[104.47.1.33] said: 550 5.1.1 Requested action not taken: mailbox unavailable (in reply to RCPT TO command)"},
+		},
+	}
+
+	if err := generateInsight(tx, c, d.creator, content); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package providerrate
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gitlab.com/lightmeter/controlcenter/deliverydb"
+	"gitlab.com/lightmeter/controlcenter/domainmapping"
+	"gitlab.com/lightmeter/controlcenter/i18n/translator"
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	_ "gitlab.com/lightmeter/controlcenter/insights/migrations"
+	insighttestsutil "gitlab.com/lightmeter/controlcenter/insights/testutil"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3"
+	"gitlab.com/lightmeter/controlcenter/notification"
+	notificationCore "gitlab.com/lightmeter/controlcenter/notification/core"
+	"gitlab.com/lightmeter/controlcenter/pkg/postfix"
+	parser "gitlab.com/lightmeter/controlcenter/pkg/postfix/logparser"
+	"gitlab.com/lightmeter/controlcenter/pkg/runner"
+	"gitlab.com/lightmeter/controlcenter/rawlogsdb"
+	"gitlab.com/lightmeter/controlcenter/tracking"
+	"gitlab.com/lightmeter/controlcenter/util/testutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+func init() {
+	lmsqlite3.Initialize(lmsqlite3.Options{})
+}
+
+type fakeLogLineFetcher map[postfix.Sum]string
+
+func (f fakeLogLineFetcher) FetchLogLine(ctx context.Context, t time.Time, sum postfix.Sum) (string, error) {
+	line, ok := f[sum]
+	if !ok {
+		return "", rawlogsdb.ErrLogLineNotFound
+	}
+
+	return line, nil
+}
+
+func buildResult(t time.Time, status parser.SmtpStatus, recipientDomain, dsn string, checksum int64) tracking.Result {
+	result := tracking.Result{}
+	result[tracking.ResultDeliveryTimeKey] = tracking.ResultEntryInt64(t.Unix())
+	result[tracking.QueueSenderLocalPartKey] = tracking.ResultEntryText("sender")
+	result[tracking.QueueSenderDomainPartKey] = tracking.ResultEntryText("sender.example.com")
+	result[tracking.ResultRecipientLocalPartKey] = tracking.ResultEntryText("recipient")
+	result[tracking.ResultRecipientDomainPartKey] = tracking.ResultEntryText(recipientDomain)
+	result[tracking.ResultStatusKey] = tracking.ResultEntryInt64(int64(status))
+	result[tracking.ResultMessageDirectionKey] = tracking.ResultEntryInt64(int64(tracking.MessageDirectionOutbound))
+	result[tracking.QueueMessageIDKey] = tracking.ResultEntryText("msgid")
+	result[tracking.QueueOriginalMessageSizeKey] = tracking.ResultEntryInt64(35)
+	result[tracking.QueueProcessedMessageSizeKey] = tracking.ResultEntryInt64(42)
+	result[tracking.QueueNRCPTKey] = tracking.ResultEntryInt64(1)
+	result[tracking.ResultDeliveryServerKey] = tracking.ResultEntryText("mail")
+	result[tracking.ResultDelayKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDelaySMTPDKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDelayCleanupKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDelayQmgrKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDelaySMTPKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDSNKey] = tracking.ResultEntryText(dsn)
+	result[tracking.QueueBeginKey] = tracking.ResultEntryInt64(0)
+	result[tracking.QueueDeliveryNameKey] = tracking.ResultEntryText("A1")
+	result[tracking.ResultDeliveryLineChecksum] = tracking.ResultEntryInt64(checksum)
+
+	return result
+}
+
+func TestProviderRateInsight(t *testing.T) {
+	Convey("Provider bounce and deferral rates", t, func() {
+		conn, closeConn := testutil.TempDBConnectionMigrated(t, "logs")
+		defer closeConn()
+
+		mapping, err := domainmapping.Mapping(domainmapping.RawList{"Microsoft": {"outlook.com", "hotmail.com"}})
+		So(err, ShouldBeNil)
+
+		db, err := deliverydb.New(conn, &mapping, deliverydb.Options{RetentionDuration: time.Hour * 24 * 30})
+		So(err, ShouldBeNil)
+
+		done, cancel := runner.Run(db)
+		pub := db.ResultsPublisher()
+
+		accessor, clear := insighttestsutil.NewFakeAccessor(t)
+		defer clear()
+
+		baseTime := testutil.MustParseTime(`2000-01-01 00:00:00 +0000`)
+
+		fetcher := fakeLogLineFetcher{}
+
+		checksum := int64(0)
+
+		publish := func(count int, status parser.SmtpStatus, domain, dsn, reason string) {
+			for i := 0; i < count; i++ {
+				checksum++
+				ts := baseTime.Add(time.Minute * 10).Add(time.Second * time.Duration(checksum))
+				pub.Publish(buildResult(ts, status, domain, dsn, checksum))
+				fetcher[postfix.Sum(checksum)] = fmt.Sprintf(`Jan  1 00:10:00 mail postfix/smtp[1234]: 400643011B47: to=<recipient@%s>, `+
+					`relay=mx.%s[11.22.33.44]:25, delay=1, delays=0/0/0.5/0.5, dsn=%s, status=%s (%s)`, domain, domain, dsn, status, reason)
+			}
+		}
+
+		// Microsoft: 30 out of 110 messages bounced, which is not visible in the global rate
+		publish(20, parser.BouncedStatus, "outlook.com", "5.7.1", "host mx.outlook.com[11.22.33.44] said: 550 5.7.1 Service unavailable, Client host blocked (in reply to RCPT TO command)")
+		publish(10, parser.BouncedStatus, "hotmail.com", "5.1.1", "host mx.hotmail.com[11.22.33.44] said: 550 5.1.1 Recipient rejected (in reply to RCPT TO command)")
+		publish(30, parser.SentStatus, "outlook.com", "2.0.0", "250 OK")
+		publish(50, parser.SentStatus, "hotmail.com", "2.0.0", "250 OK")
+
+		// healthy
+		publish(5, parser.BouncedStatus, "gmail.com", "5.1.1", "host mx.gmail.com[11.22.33.44] said: 550 5.1.1 No such user (in reply to RCPT TO command)")
+		publish(400, parser.SentStatus, "gmail.com", "2.0.0", "250 OK")
+
+		// too few messages to matter
+		publish(10, parser.DeferredStatus, "small.com", "4.4.1", "connect to mx.small.com[11.22.33.44]:25: Connection timed out")
+
+		cancel()
+		So(done(), ShouldBeNil)
+
+		options := Options{
+			CheckInterval:               time.Hour,
+			TimeSpan:                    time.Hour * 6,
+			MinVolume:                   100,
+			BounceRateThreshold:         0.1,
+			DeferredRateThreshold:       0.3,
+			MinTimeToGenerateNewInsight: time.Hour * 24,
+			MaxSamples:                  2,
+			LogLineFetcher:              fetcher,
+		}
+
+		detector := NewDetector(accessor, core.Options{"logsConnPool": conn.RoConnPool, "providerrate": options})
+
+		clock := &insighttestsutil.FakeClock{Time: baseTime}
+
+		insighttestsutil.ExecuteCyclesUntil(detector, accessor, clock, baseTime.Add(time.Hour*5), time.Minute*20)
+
+		// only one insight, as the provider is still bouncing, but an insight was generated recently
+		So(accessor.Insights, ShouldResemble, []int64{1})
+
+		insights, err := accessor.FetchInsights(context.Background(), core.FetchOptions{
+			Interval: timeutil.TimeInterval{From: baseTime, To: baseTime.Add(time.Hour * 24)},
+		}, clock)
+		So(err, ShouldBeNil)
+		So(len(insights), ShouldEqual, 1)
+
+		So(insights[0].ContentType(), ShouldEqual, ContentType)
+		So(insights[0].Rating(), ShouldEqual, core.BadRating)
+		// on the first check, at the start, there were no deliveries yet
+		So(insights[0].Time(), ShouldEqual, baseTime.Add(time.Hour))
+
+		So(insights[0].Content(), ShouldResemble, &Content{
+			Provider: "Microsoft",
+			Status:   "bounced",
+			Count:    30,
+			Total:    110,
+			Rate:     float64(30) / 110,
+			Interval: timeutil.TimeInterval{From: baseTime.Add(-time.Hour * 5), To: baseTime.Add(time.Hour)},
+			Samples: []Sample{
+				{DSN: "5.7.1", Count: 20, Reason: "host mx.outlook.com[11.22.33.44] said: 550 5.7.1 Service unavailable, Client host blocked (in reply to RCPT TO command)"},
+				{DSN: "5.1.1", Count: 10, Reason: "host mx.hotmail.com[11.22.33.44] said: 550 5.1.1 Recipient rejected (in reply to RCPT TO command)"},
+			},
+		})
+	})
+}
+
+func TestDescriptionFormatting(t *testing.T) {
+	Convey("Description Formatting", t, func() {
+		content := Content{
+			Provider: "Microsoft",
+			Status:   "bounced",
+			Count:    30,
+			Total:    110,
+			Rate:     float64(30) / 110,
+			Interval: timeutil.TimeInterval{From: testutil.MustParseTime(`2000-01-01 00:00:00 +0000`), To: testutil.MustParseTime(`2000-01-01 06:00:00 +0000`)},
+			Samples:  []Sample{{DSN: "5.7.1", Count: 20, Reason: "550 5.7.1 Service unavailable"}},
+		}
+
+		m, err := notificationCore.TranslateNotification(notification.Notification{ID: 1, Content: content}, translator.DummyTranslator{})
+		So(err, ShouldBeNil)
+		So(m, ShouldResemble, notificationCore.Message{
+			Title:       "High bounce rate at Microsoft",
+			Description: "27 percent of the 110 messages sent to Microsoft between 2000-01-01 00:00:00 +0000 UTC and 2000-01-01 06:00:00 +0000 UTC were bounced, most with status 5.7.1: 550 5.7.1 Service unavailable",
+			Metadata:    map[string]string{},
+		})
+	})
+}
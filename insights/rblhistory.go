@@ -0,0 +1,35 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package insights
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	localrblinsight "gitlab.com/lightmeter/controlcenter/insights/localrbl"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+func (e *Engine) RBLListingHistory(ctx context.Context) ([]localrblinsight.Timeline, error) {
+	timelines, err := localrblinsight.ListingHistory(ctx, e.accessor.conn.RoConnPool)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return timelines, nil
+}
+
+func (e *Engine) RequestRBLDelisting(ctx context.Context, listingID int64, remindInterval time.Duration, notes string) error {
+	return e.runTxAction(ctx, func(tx *sql.Tx) error {
+		return localrblinsight.RequestDelisting(ctx, tx, listingID, remindInterval, notes, time.Now())
+	})
+}
+
+func (e *Engine) CancelRBLDelisting(ctx context.Context, listingID int64) error {
+	return e.runTxAction(ctx, func(tx *sql.Tx) error {
+		return localrblinsight.CancelDelisting(ctx, tx, listingID)
+	})
+}
@@ -0,0 +1,271 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package savedsearch
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gitlab.com/lightmeter/controlcenter/detective"
+	"gitlab.com/lightmeter/controlcenter/i18n/translator"
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	notificationCore "gitlab.com/lightmeter/controlcenter/notification/core"
+	parser "gitlab.com/lightmeter/controlcenter/pkg/postfix/logparser"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+type Options struct {
+	Detective detective.Detective
+
+	// MaxMessages is how many of the matching messages are kept in the insight
+	MaxMessages int
+}
+
+const (
+	ContentType   = "detective_saved_search"
+	ContentTypeId = 11
+)
+
+func init() {
+	core.RegisterContentType(ContentType, ContentTypeId, core.DefaultContentTypeDecoder(&Content{}))
+}
+
+type Content struct {
+	SearchID     int64                 `json:"search_id"`
+	Name         string                `json:"name"`
+	Query        Query                 `json:"query"`
+	Interval     timeutil.TimeInterval `json:"time_interval"`
+	TotalResults int                   `json:"total_results"`
+	Messages     detective.Messages    `json:"messages"`
+}
+
+func (c Content) Title() notificationCore.ContentComponent {
+	return &title{c}
+}
+
+func (c Content) Description() notificationCore.ContentComponent {
+	return &description{c}
+}
+
+func (c Content) Metadata() notificationCore.ContentMetadata {
+	return nil
+}
+
+func (c Content) MuteParams() map[string]string {
+	return map[string]string{"search_id": strconv.FormatInt(c.SearchID, 10)}
+}
+
+type title struct {
+	c Content
+}
+
+func (t title) String() string {
+	return translator.Stringfy(t)
+}
+
+func (t title) TplString() string {
+	return translator.I18n("New results for the saved search %v")
+}
+
+func (t title) Args() []interface{} {
+	return []interface{}{t.c.Name}
+}
+
+type description struct {
+	c Content
+}
+
+func (d description) String() string {
+	return translator.Stringfy(d)
+}
+
+func (d description) TplString() string {
+	return translator.I18n("%v new messages found between %v and %v")
+}
+
+func (d description) Args() []interface{} {
+	return []interface{}{d.c.TotalResults, d.c.Interval.From, d.c.Interval.To}
+}
+
+type detector struct {
+	options Options
+	creator core.Creator
+}
+
+func NewDetector(creator core.Creator, options core.Options) core.Detector {
+	detectorOptions, ok := options["savedsearch"].(Options)
+
+	if !ok {
+		errorutil.MustSucceed(errors.New("Invalid detector options"))
+	}
+
+	if detectorOptions.MaxMessages == 0 {
+		detectorOptions.MaxMessages = 10
+	}
+
+	return &detector{
+		options: detectorOptions,
+		creator: creator,
+	}
+}
+
+// LateDeliveriesWindow is how far back in time, before its last run, a search looks for
+// deliveries stored only after it, as when the logs are read with some delay.
+// The deliveries already searched for are skipped by their ids
+const LateDeliveriesWindow = time.Hour * 24
+
+// run executes the search on the deliveries stored since its last run
+func (d *detector) run(c core.Clock, tx *sql.Tx, search Search) error {
+	now := c.Now()
+
+	lastDeliveryID, err := d.options.Detective.LastDeliveryID(context.Background())
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if err := updateLastRun(tx, search.ID, now, lastDeliveryID); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	// nothing new stored since the last run
+	if lastDeliveryID == 0 || lastDeliveryID == search.LastDeliveryID {
+		return nil
+	}
+
+	from := search.LastRun
+
+	// without knowing which deliveries have already been searched for,
+	// as on searches saved by older versions, only the new ones are searched for
+	if search.LastDeliveryID > 0 {
+		from = from.Add(-LateDeliveriesWindow)
+	}
+
+	if from.Before(search.CreatedAt) {
+		from = search.CreatedAt
+	}
+
+	// the interval is inclusive, and the deliveries on its start have already been covered
+	interval := timeutil.TimeInterval{From: from.Add(time.Second), To: now}
+
+	filters, err := search.Query.Filters()
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	filters.AfterDeliveryID = search.LastDeliveryID
+	filters.UpToDeliveryID = lastDeliveryID
+
+	q := search.Query
+
+	messages, err := d.options.Detective.CheckMessageDelivery(context.Background(), q.Sender, q.Recipient, interval, q.Status, q.SomeID, filters, 1, d.options.MaxMessages)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if messages.TotalResults == 0 {
+		return nil
+	}
+
+	log.Info().Msgf("Saved search %v has %v new results", search.ID, messages.TotalResults)
+
+	content := Content{
+		SearchID:     search.ID,
+		Name:         search.Name,
+		Query:        search.Query,
+		Interval:     interval,
+		TotalResults: messages.TotalResults,
+		Messages:     messages.Messages,
+	}
+
+	if err := generateInsight(tx, c, d.creator, content); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func (d *detector) Step(c core.Clock, tx *sql.Tx) error {
+	searches, err := dueSearches(tx, c.Now())
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	for _, search := range searches {
+		// a broken search must not prevent the other ones, nor the other detectors, from running.
+		// It's tried again only on its next run, not missing any deliveries, as they are tracked by their ids
+		if err := d.run(c, tx, search); err != nil {
+			log.Warn().Msgf("Failed running saved search %v: %v", search.ID, err)
+
+			if err := updateLastRun(tx, search.ID, c.Now(), search.LastDeliveryID); err != nil {
+				return errorutil.Wrap(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (d *detector) Close() error {
+	return nil
+}
+
+func generateInsight(tx *sql.Tx, c core.Clock, creator core.Creator, content Content) error {
+	if err := creator.GenerateInsight(context.Background(), tx, BuildInsightProperties(c, content)); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func BuildInsightProperties(c core.Clock, content Content) core.InsightProperties {
+	return core.InsightProperties{
+		Time:        c.Now(),
+		Category:    core.LocalCategory,
+		Rating:      core.Unrated,
+		ContentType: ContentType,
+		Content:     content,
+
+		// the user asked to be informed about them
+		MustBeNotified: true,
+	}
+}
+
+var SampleInsightContent = Content{
+	SearchID: 1,
+	Name:     "Bounces from billing",
+	Query: Query{
+		Sender: "billing@example.com",
+		Status: int(parser.BouncedStatus),
+	},
+	Interval: timeutil.TimeInterval{
+		From: timeutil.MustParseTime(`2000-01-01 00:00:00 +0000`),
+		To:   timeutil.MustParseTime(`2000-01-02 00:00:00 +0000`),
+	},
+	TotalResults: 1,
+	Messages: detective.Messages{
+		detective.Message{
+			Queue:     "AAAAAAAAA",
+			MessageID: "222222@example.com",
+			Entries: []detective.MessageDelivery{
+				{
+					NumberOfAttempts: 1,
+					TimeMin:          timeutil.MustParseTime(`2000-01-01 10:00:00 +0000`),
+					TimeMax:          timeutil.MustParseTime(`2000-01-01 10:00:00 +0000`),
+					Status:           detective.Status(parser.BouncedStatus),
+					Dsn:              "5.1.1",
+					Relays:           []string{"mx.example.com"},
+					MailFrom:         "billing@example.com",
+					MailTo:           []string{"customer@example.com"},
+					RawLogMsgs:       []string{"line1", "line2"},
+				},
+			},
+		},
+	},
+}
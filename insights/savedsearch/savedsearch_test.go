@@ -0,0 +1,259 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package savedsearch
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+	"gitlab.com/lightmeter/controlcenter/detective"
+	mock_detective "gitlab.com/lightmeter/controlcenter/detective/mock"
+	"gitlab.com/lightmeter/controlcenter/erasure"
+	"gitlab.com/lightmeter/controlcenter/i18n/translator"
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	insighttestsutil "gitlab.com/lightmeter/controlcenter/insights/testutil"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3"
+	"gitlab.com/lightmeter/controlcenter/notification"
+	notificationCore "gitlab.com/lightmeter/controlcenter/notification/core"
+	parser "gitlab.com/lightmeter/controlcenter/pkg/postfix/logparser"
+	"gitlab.com/lightmeter/controlcenter/util/testutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+func init() {
+	lmsqlite3.Initialize(lmsqlite3.Options{})
+}
+
+var dummyContext = context.Background()
+
+func TestSavedSearches(t *testing.T) {
+	Convey("Test Saved Searches", t, func() {
+		accessor, clearAccessor := insighttestsutil.NewFakeAccessor(t)
+		defer clearAccessor()
+
+		baseTime := testutil.MustParseTime(`2000-01-01 00:00:00 +0000`)
+
+		tx := func(f func(*sql.Tx) error) error {
+			return accessor.ConnPair.RwConn.Tx(dummyContext, func(ctx context.Context, tx *sql.Tx) error {
+				return f(tx)
+			})
+		}
+
+		save := func(userID int, name string, query Query, runInterval time.Duration) (id int64, err error) {
+			err = tx(func(tx *sql.Tx) error {
+				id, err = Save(tx, userID, name, query, runInterval, baseTime)
+				return err
+			})
+
+			return id, err
+		}
+
+		Convey("Invalid searches", func() {
+			for _, s := range []struct {
+				name        string
+				query       Query
+				runInterval time.Duration
+			}{
+				{"", Query{Sender: "billing@example.com"}, time.Hour},
+				{"  ", Query{Sender: "billing@example.com"}, time.Hour},
+				{"Billing", Query{Sender: "billing@example.com"}, time.Minute},
+				{"Billing", Query{Sender: "billing@@example.com"}, time.Hour},
+				{"Billing", Query{Recipient: "@@"}, time.Hour},
+				{"Billing", Query{ClientIP: "300.0.0.1"}, time.Hour},
+			} {
+				_, err := save(1, s.name, s.query, s.runInterval)
+				So(errors.Is(err, ErrInvalidSearch), ShouldBeTrue)
+			}
+		})
+
+		Convey("Searches belong to users", func() {
+			id1, err := save(1, "Billing bounces", Query{Sender: "billing@example.com", Status: int(parser.BouncedStatus)}, time.Hour)
+			So(err, ShouldBeNil)
+
+			id2, err := save(2, "Deferred to customer", Query{Recipient: "*@customer.example.com", Status: int(parser.DeferredStatus)}, time.Minute*10)
+			So(err, ShouldBeNil)
+
+			searches, err := List(dummyContext, accessor.ConnPair.RoConnPool, 1)
+			So(err, ShouldBeNil)
+			So(searches, ShouldResemble, []Search{
+				{
+					ID:                 id1,
+					Name:               "Billing bounces",
+					Query:              Query{Sender: "billing@example.com", Status: int(parser.BouncedStatus)},
+					CreatedAt:          baseTime,
+					LastRun:            baseTime,
+					RunInterval:        time.Hour,
+					RunIntervalSeconds: 3600,
+				},
+			})
+
+			// users cannot delete searches from other users
+			So(errors.Is(tx(func(tx *sql.Tx) error { return Delete(tx, 1, id2) }), ErrNoSuchSearch), ShouldBeTrue)
+
+			So(tx(func(tx *sql.Tx) error { return Delete(tx, 2, id2) }), ShouldBeNil)
+
+			searches, err = List(dummyContext, accessor.ConnPair.RoConnPool, 2)
+			So(err, ShouldBeNil)
+			So(searches, ShouldResemble, []Search{})
+
+			Convey("Erase searches mentioning an address", func() {
+				target, err := erasure.ParseTarget("billing@example.com")
+				So(err, ShouldBeNil)
+
+				var count int64
+
+				So(tx(func(tx *sql.Tx) error {
+					count, err = Erase(tx, target)
+					return err
+				}), ShouldBeNil)
+
+				So(count, ShouldEqual, 1)
+
+				searches, err = List(dummyContext, accessor.ConnPair.RoConnPool, 1)
+				So(err, ShouldBeNil)
+				So(searches, ShouldResemble, []Search{})
+			})
+		})
+
+		Convey("Run searches periodically", func() {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			d := mock_detective.NewMockDetective(ctrl)
+
+			clock := &insighttestsutil.FakeClock{Time: baseTime}
+
+			detector := NewDetector(accessor, core.Options{"savedsearch": Options{Detective: d, MaxMessages: 5}})
+
+			_, err := save(1, "Billing bounces", Query{Sender: "billing@example.com", Status: int(parser.BouncedStatus)}, time.Hour)
+			So(err, ShouldBeNil)
+
+			bounced := detective.Messages{
+				detective.Message{
+					Queue: "AAAA",
+					Entries: []detective.MessageDelivery{
+						{
+							NumberOfAttempts: 1,
+							TimeMin:          timeutil.MustParseTime(`2000-01-01 01:30:00 +0000`),
+							TimeMax:          timeutil.MustParseTime(`2000-01-01 01:30:00 +0000`),
+							Status:           detective.Status(parser.BouncedStatus),
+							Dsn:              "5.1.1",
+						},
+					},
+				},
+			}
+
+			// the deliveries stored late, up to a day, are also searched for, skipping the ones already reported
+			firstInterval := timeutil.TimeInterval{From: baseTime.Add(time.Second), To: baseTime.Add(time.Hour)}
+			secondInterval := timeutil.TimeInterval{From: baseTime.Add(time.Second), To: baseTime.Add(time.Hour * 2)}
+			fourthInterval := timeutil.TimeInterval{From: baseTime.Add(time.Second), To: baseTime.Add(time.Hour * 4)}
+
+			gomock.InOrder(
+				// nothing new in the first hour
+				d.EXPECT().LastDeliveryID(gomock.Any()).Return(int64(5), nil),
+				d.EXPECT().CheckMessageDelivery(gomock.Any(), "billing@example.com", "", firstInterval, int(parser.BouncedStatus), "", detective.Filters{UpToDeliveryID: 5}, 1, 5).
+					Return(&detective.MessagesPage{}, nil),
+
+				d.EXPECT().LastDeliveryID(gomock.Any()).Return(int64(10), nil),
+				d.EXPECT().CheckMessageDelivery(gomock.Any(), "billing@example.com", "", secondInterval, int(parser.BouncedStatus), "", detective.Filters{AfterDeliveryID: 5, UpToDeliveryID: 10}, 1, 5).
+					Return(&detective.MessagesPage{PageNumber: 1, FirstPage: 1, LastPage: 1, TotalResults: 1, Messages: bounced}, nil),
+
+				// nothing stored in the third hour, so no search is needed
+				d.EXPECT().LastDeliveryID(gomock.Any()).Return(int64(10), nil),
+
+				d.EXPECT().LastDeliveryID(gomock.Any()).Return(int64(11), nil),
+				d.EXPECT().CheckMessageDelivery(gomock.Any(), "billing@example.com", "", fourthInterval, int(parser.BouncedStatus), "", detective.Filters{AfterDeliveryID: 10, UpToDeliveryID: 11}, 1, 5).
+					Return(&detective.MessagesPage{}, nil),
+			)
+
+			insighttestsutil.ExecuteCyclesUntil(detector, accessor, clock, baseTime.Add(time.Hour*4+time.Minute), time.Second*30)
+
+			So(accessor.Insights, ShouldResemble, []int64{1})
+
+			insights, err := accessor.FetchInsights(dummyContext, core.FetchOptions{Interval: timeutil.TimeInterval{
+				From: testutil.MustParseTime(`0000-01-01 00:00:00 +0000`),
+				To:   testutil.MustParseTime(`4000-01-01 00:00:00 +0000`),
+			}, OrderBy: core.OrderByCreationAsc}, clock)
+
+			So(err, ShouldBeNil)
+			So(len(insights), ShouldEqual, 1)
+			So(insights[0].ContentType(), ShouldEqual, ContentType)
+			So(insights[0].Time(), ShouldEqual, baseTime.Add(time.Hour*2))
+			So(insights[0].Content(), ShouldResemble, &Content{
+				SearchID:     1,
+				Name:         "Billing bounces",
+				Query:        Query{Sender: "billing@example.com", Status: int(parser.BouncedStatus)},
+				Interval:     secondInterval,
+				TotalResults: 1,
+				Messages:     bounced,
+			})
+
+			searches, err := List(dummyContext, accessor.ConnPair.RoConnPool, 1)
+			So(err, ShouldBeNil)
+			So(searches[0].LastRun, ShouldEqual, baseTime.Add(time.Hour*4))
+			So(searches[0].LastDeliveryID, ShouldEqual, 11)
+		})
+
+		Convey("A failing search does not prevent the other ones from running", func() {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			d := mock_detective.NewMockDetective(ctrl)
+
+			clock := &insighttestsutil.FakeClock{Time: baseTime}
+
+			detector := NewDetector(accessor, core.Options{"savedsearch": Options{Detective: d, MaxMessages: 5}})
+
+			_, err := save(1, "Broken", Query{Sender: "broken@example.com", Status: -1}, time.Hour)
+			So(err, ShouldBeNil)
+
+			_, err = save(1, "Billing", Query{Sender: "billing@example.com", Status: -1}, time.Hour)
+			So(err, ShouldBeNil)
+
+			interval := timeutil.TimeInterval{From: baseTime.Add(time.Second), To: baseTime.Add(time.Hour)}
+
+			d.EXPECT().LastDeliveryID(gomock.Any()).Return(int64(3), nil).Times(2)
+
+			d.EXPECT().CheckMessageDelivery(gomock.Any(), "broken@example.com", "", interval, -1, "", detective.Filters{UpToDeliveryID: 3}, 1, 5).
+				Return(nil, errors.New("Some error"))
+
+			d.EXPECT().CheckMessageDelivery(gomock.Any(), "billing@example.com", "", interval, -1, "", detective.Filters{UpToDeliveryID: 3}, 1, 5).
+				Return(&detective.MessagesPage{TotalResults: 1, Messages: detective.Messages{{Queue: "AAAA"}}}, nil)
+
+			// it's tried again only on its next run
+			insighttestsutil.ExecuteCyclesUntil(detector, accessor, clock, baseTime.Add(time.Hour+time.Minute), time.Second*30)
+
+			So(accessor.Insights, ShouldResemble, []int64{1})
+
+			searches, err := List(dummyContext, accessor.ConnPair.RoConnPool, 1)
+			So(err, ShouldBeNil)
+			So(searches[0].LastRun, ShouldEqual, baseTime.Add(time.Hour))
+			So(searches[0].LastDeliveryID, ShouldEqual, 0)
+			So(searches[1].LastDeliveryID, ShouldEqual, 3)
+		})
+	})
+}
+
+func TestDescriptionFormatting(t *testing.T) {
+	Convey("Description Formatting", t, func() {
+		n := notification.Notification{
+			ID:      1,
+			Content: SampleInsightContent,
+		}
+
+		m, err := notificationCore.TranslateNotification(n, translator.DummyTranslator{})
+		So(err, ShouldBeNil)
+		So(m, ShouldResemble, notificationCore.Message{
+			Title:       "New results for the saved search Bounces from billing",
+			Description: "1 new messages found between 2000-01-01 00:00:00 +0000 UTC and 2000-01-02 00:00:00 +0000 UTC",
+			Metadata:    map[string]string{},
+		})
+	})
+}
@@ -0,0 +1,307 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package savedsearch
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/detective"
+	"gitlab.com/lightmeter/controlcenter/erasure"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/dbconn"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+var (
+	ErrInvalidSearch = errors.New(`Invalid saved search`)
+	ErrNoSuchSearch  = errors.New(`No such saved search`)
+)
+
+// Manager keeps the searches each user has saved
+type Manager interface {
+	SaveSearch(ctx context.Context, userID int, name string, query Query, runInterval time.Duration) (int64, error)
+	DeleteSavedSearch(ctx context.Context, userID int, id int64) error
+	SavedSearches(ctx context.Context, userID int) ([]Search, error)
+}
+
+// MinRunInterval prevents searches from overloading the detective
+const MinRunInterval = time.Minute * 5
+
+// Query has the same parameters as a search on the message detective,
+// except the time interval, which is, on each run, the time since the previous one
+type Query struct {
+	Sender    string `json:"mail_from"`
+	Recipient string `json:"mail_to"`
+	Status    int    `json:"status"`
+	SomeID    string `json:"some_id"`
+	ClientIP  string `json:"client_ip,omitempty"`
+	Relay     string `json:"relay,omitempty"`
+	DSNPrefix string `json:"dsn,omitempty"`
+	MinSize   *int64 `json:"min_size,omitempty"`
+	MaxSize   *int64 `json:"max_size,omitempty"`
+}
+
+func (q Query) Filters() (detective.Filters, error) {
+	filters := detective.Filters{
+		Relay:     q.Relay,
+		DSNPrefix: q.DSNPrefix,
+		MinSize:   q.MinSize,
+		MaxSize:   q.MaxSize,
+	}
+
+	if len(q.ClientIP) > 0 {
+		network, err := detective.ParseClientIP(q.ClientIP)
+		if err != nil {
+			return detective.Filters{}, fmt.Errorf("Invalid client_ip: %w", detective.ErrInvalidFilter)
+		}
+
+		filters.ClientIP = network
+	}
+
+	return filters, nil
+}
+
+func (q Query) validate() error {
+	if _, err := detective.ParseAddressPattern(q.Sender); err != nil {
+		return fmt.Errorf("Invalid sender: %w", ErrInvalidSearch)
+	}
+
+	if _, err := detective.ParseAddressPattern(q.Recipient); err != nil {
+		return fmt.Errorf("Invalid recipient: %w", ErrInvalidSearch)
+	}
+
+	if _, err := q.Filters(); err != nil {
+		return fmt.Errorf("%v: %w", err, ErrInvalidSearch)
+	}
+
+	return nil
+}
+
+type Search struct {
+	ID          int64         `json:"id"`
+	Name        string        `json:"name"`
+	Query       Query         `json:"query"`
+	CreatedAt   time.Time     `json:"created_at"`
+	LastRun     time.Time     `json:"last_run"`
+	RunInterval time.Duration `json:"-"`
+
+	// LastDeliveryID is the greatest delivery already reported by the search
+	LastDeliveryID int64 `json:"-"`
+
+	// RunIntervalSeconds is the same as RunInterval, in a friendlier form for the API users
+	RunIntervalSeconds int64 `json:"run_interval"`
+}
+
+// Save stores a new search for the user. It'll first run after runInterval
+// and will look only for deliveries that happen from now on
+func Save(tx *sql.Tx, userID int, name string, query Query, runInterval time.Duration, now time.Time) (int64, error) {
+	name = strings.TrimSpace(name)
+
+	if len(name) == 0 {
+		return 0, fmt.Errorf("Empty name: %w", ErrInvalidSearch)
+	}
+
+	if runInterval < MinRunInterval {
+		return 0, fmt.Errorf("Run interval must be at least %v: %w", MinRunInterval, ErrInvalidSearch)
+	}
+
+	if err := query.validate(); err != nil {
+		return 0, err
+	}
+
+	encodedQuery, err := json.Marshal(query)
+	if err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	result, err := tx.Exec(`insert into saved_searches(user_id, name, query, run_interval, creation_ts, last_run_ts) values(?, ?, ?, ?, ?, ?)`,
+		userID, name, encodedQuery, int64(runInterval/time.Second), now.Unix(), now.Unix())
+	if err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	return id, nil
+}
+
+// Delete removes a search, as long as it belongs to the user
+func Delete(tx *sql.Tx, userID int, id int64) error {
+	result, err := tx.Exec(`delete from saved_searches where id = ? and user_id = ?`, id, userID)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if count == 0 {
+		return ErrNoSuchSearch
+	}
+
+	return nil
+}
+
+type scanner interface {
+	Scan(...interface{}) error
+}
+
+func scanSearch(row scanner) (Search, error) {
+	var (
+		s            Search
+		encodedQuery []byte
+		runInterval  int64
+		creationTs   int64
+		lastRunTs    int64
+	)
+
+	if err := row.Scan(&s.ID, &s.Name, &encodedQuery, &runInterval, &creationTs, &lastRunTs, &s.LastDeliveryID); err != nil {
+		return Search{}, errorutil.Wrap(err)
+	}
+
+	if err := json.Unmarshal(encodedQuery, &s.Query); err != nil {
+		return Search{}, errorutil.Wrap(err)
+	}
+
+	s.RunInterval = time.Duration(runInterval) * time.Second
+	s.RunIntervalSeconds = runInterval
+	s.CreatedAt = time.Unix(creationTs, 0).In(time.UTC)
+	s.LastRun = time.Unix(lastRunTs, 0).In(time.UTC)
+
+	return s, nil
+}
+
+// List returns all the searches saved by the user
+func List(ctx context.Context, pool *dbconn.RoPool, userID int) (searches []Search, err error) {
+	conn, release, err := pool.AcquireContext(ctx)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer release()
+
+	rows, err := conn.QueryContext(ctx, `
+		select
+			id, name, query, run_interval, creation_ts, last_run_ts, last_delivery_id
+		from
+			saved_searches
+		where
+			user_id = ?
+		order by
+			id`, userID)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	searches = []Search{}
+
+	for rows.Next() {
+		s, err := scanSearch(rows)
+		if err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		searches = append(searches, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return searches, nil
+}
+
+func dueSearches(tx *sql.Tx, now time.Time) (searches []Search, err error) {
+	rows, err := tx.Query(`
+		select
+			id, name, query, run_interval, creation_ts, last_run_ts, last_delivery_id
+		from
+			saved_searches
+		where
+			last_run_ts + run_interval <= ?
+		order by
+			id`, now.Unix())
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	for rows.Next() {
+		s, err := scanSearch(rows)
+		if err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		searches = append(searches, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return searches, nil
+}
+
+func updateLastRun(tx *sql.Tx, id int64, t time.Time, lastDeliveryID int64) error {
+	if _, err := tx.Exec(`update saved_searches set last_run_ts = ?, last_delivery_id = ? where id = ?`, t.Unix(), lastDeliveryID, id); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+// Erase deletes all the saved searches mentioning target
+func Erase(tx *sql.Tx, target erasure.Target) (count int64, err error) {
+	rows, err := tx.Query(`select id, query from saved_searches where query like ?`, target.LikePattern())
+	if err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	ids := []int64{}
+
+	matcher := target.Matcher()
+
+	for rows.Next() {
+		var (
+			id    int64
+			query string
+		)
+
+		if err := rows.Scan(&id, &query); err != nil {
+			return 0, errorutil.Wrap(err)
+		}
+
+		if matcher.Match(query) {
+			ids = append(ids, id)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	for _, id := range ids {
+		if _, err := tx.Exec(`delete from saved_searches where id = ?`, id); err != nil {
+			return 0, errorutil.Wrap(err)
+		}
+	}
+
+	return int64(len(ids)), nil
+}
@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package insights
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/insights/savedsearch"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+// SaveSearch stores a detective search for the user, to be periodically executed
+func (e *Engine) SaveSearch(ctx context.Context, userID int, name string, query savedsearch.Query, runInterval time.Duration) (int64, error) {
+	var id int64
+
+	err := e.runTxAction(ctx, func(tx *sql.Tx) error {
+		savedID, err := savedsearch.Save(tx, userID, name, query, runInterval, time.Now())
+		if err != nil {
+			return err
+		}
+
+		id = savedID
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (e *Engine) DeleteSavedSearch(ctx context.Context, userID int, id int64) error {
+	return e.runTxAction(ctx, func(tx *sql.Tx) error {
+		return savedsearch.Delete(tx, userID, id)
+	})
+}
+
+func (e *Engine) SavedSearches(ctx context.Context, userID int) ([]savedsearch.Search, error) {
+	searches, err := savedsearch.List(ctx, e.accessor.conn.RoConnPool, userID)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return searches, nil
+}
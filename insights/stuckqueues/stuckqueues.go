@@ -0,0 +1,435 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package stuckqueues
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/i18n/translator"
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/dbconn"
+	notificationCore "gitlab.com/lightmeter/controlcenter/notification/core"
+	parser "gitlab.com/lightmeter/controlcenter/pkg/postfix/logparser"
+	insightsSettings "gitlab.com/lightmeter/controlcenter/settings/insights"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+// Postfix keeps retrying deferred messages until they are older than maximal_queue_lifetime,
+// when they are returned to the sender as expired. Messages which have been deferred for a long time
+// are reported before that happens, when something can still be done about them.
+
+const (
+	ContentType   = "stuck_queues"
+	ContentTypeId = 16
+)
+
+// InFlightQueuesLister returns the queues postfix has not removed yet
+type InFlightQueuesLister interface {
+	InFlightQueues(context.Context) (map[string]struct{}, error)
+}
+
+type Options struct {
+	CheckInterval time.Duration
+
+	// Messages deferred for longer than this are reported.
+	// It can be changed in the settings
+	MinAge time.Duration
+
+	// The same as postfix's maximal_queue_lifetime, used to forecast when the messages expire.
+	// It can be changed in the settings
+	MaximalQueueLifetime time.Duration
+
+	// Minimum time between two insights
+	MinTimeToGenerateNewInsight time.Duration
+
+	// How many queues are listed in the insight
+	MaxQueues int
+
+	// Optional. If set, messages which are not in flight anymore are not reported,
+	// as they might have been removed from the queue by hand
+	InFlightQueues InFlightQueuesLister
+}
+
+type Queue struct {
+	Queue            string    `json:"queue"`
+	Sender           string    `json:"sender"`
+	RecipientDomains []string  `json:"recipient_domains"`
+	Recipients       int       `json:"recipients"`
+	QueuedAt         time.Time `json:"queued_at"`
+	LastAttempt      time.Time `json:"last_attempt"`
+	ExpiresAt        time.Time `json:"expires_at"`
+}
+
+type Content struct {
+	// Total number of stuck queues, which can be higher than the number of listed ones
+	Total    int                   `json:"total"`
+	Queues   []Queue               `json:"queues"`
+	Interval timeutil.TimeInterval `json:"interval"`
+}
+
+func (c Content) Title() notificationCore.ContentComponent {
+	return &title{c}
+}
+
+func (c Content) Description() notificationCore.ContentComponent {
+	return &description{c}
+}
+
+func (c Content) Metadata() notificationCore.ContentMetadata {
+	return nil
+}
+
+// MuteParams has the oldest queue, which is reported until it's delivered or expires
+func (c Content) MuteParams() map[string]string {
+	if len(c.Queues) == 0 {
+		return map[string]string{}
+	}
+
+	return map[string]string{"queue": c.Queues[0].Queue}
+}
+
+type title struct {
+	c Content
+}
+
+func (t title) String() string {
+	return translator.Stringfy(t)
+}
+
+func (t title) TplString() string {
+	return translator.I18n("Messages stuck in the queue")
+}
+
+func (t title) Args() []interface{} {
+	return nil
+}
+
+type description struct {
+	c Content
+}
+
+func (d description) String() string {
+	return translator.Stringfy(d)
+}
+
+func (d description) TplString() string {
+	return translator.I18n("%v messages have been deferred for a long time, some since %v, and the first of them will expire on %v unless delivered before")
+}
+
+func (d description) Args() []interface{} {
+	// queues are sorted by age, and therefore by expiry time
+	first := d.c.Queues[0]
+	return []interface{}{d.c.Total, first.QueuedAt, first.ExpiresAt}
+}
+
+func init() {
+	core.RegisterContentType(ContentType, ContentTypeId, core.DefaultContentTypeDecoder(&Content{}))
+}
+
+// Not a historical detector, as the messages which are in flight now are only known
+// at the end of the import, and forecasting expiry times in the past is pointless
+type detector struct {
+	pool    *dbconn.RoPool
+	creator core.Creator
+	options Options
+
+	mutex                sync.Mutex
+	minAge               time.Duration
+	maximalQueueLifetime time.Duration
+}
+
+func (*detector) Close() error {
+	return nil
+}
+
+func getDetectorOptions(options core.Options) Options {
+	detectorOptions, ok := options["stuckqueues"].(Options)
+	if !ok {
+		errorutil.MustSucceed(errors.New("Invalid detector options"))
+	}
+
+	return detectorOptions
+}
+
+func NewDetector(settings *insightsSettings.Settings, creator core.Creator, options core.Options) core.Detector {
+	pool, ok := options["logsConnPool"].(*dbconn.RoPool)
+	if !ok {
+		errorutil.MustSucceed(errors.New("Invalid Connection Pool"))
+	}
+
+	d := &detector{
+		pool:    pool,
+		creator: creator,
+		options: getDetectorOptions(options),
+	}
+
+	d.UpdateOptionsFromSettings(settings)
+
+	return d
+}
+
+func (d *detector) UpdateOptionsFromSettings(settings *insightsSettings.Settings) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.minAge = d.options.MinAge
+	d.maximalQueueLifetime = d.options.MaximalQueueLifetime
+
+	if settings.StuckQueuesMinAge > 0 {
+		d.minAge = time.Hour * time.Duration(settings.StuckQueuesMinAge)
+	}
+
+	if settings.StuckQueuesMaximalQueueLifetime > 0 {
+		d.maximalQueueLifetime = time.Hour * 24 * time.Duration(settings.StuckQueuesMaximalQueueLifetime)
+	}
+}
+
+func (d *detector) currentAges() (minAge, maximalQueueLifetime time.Duration) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.minAge, d.maximalQueueLifetime
+}
+
+// a recipient is still pending when its most recent delivery attempt in the queue was deferred
+const pendingRecipientsStmt = `
+select
+	q.name, d.queue_ts_begin, d.delivery_ts, d.sender_local_part, sd.domain, rd.domain
+from
+	deliveries d
+	join delivery_queue dq on dq.delivery_id = d.id
+	join queues q on q.id = dq.queue_id
+	join remote_domains sd on sd.id = d.sender_domain_part_id
+	join remote_domains rd on rd.id = d.recipient_domain_part_id
+where
+	d.status = ? and d.delivery_ts between ? and ? and d.queue_ts_begin between ? and ?
+	and not exists (
+		select
+			1
+		from
+			delivery_queue dq2
+			join deliveries d2 on d2.id = dq2.delivery_id
+		where
+			dq2.queue_id = dq.queue_id and d2.id > d.id
+			and d2.recipient_local_part = d.recipient_local_part
+			and d2.recipient_domain_part_id = d.recipient_domain_part_id
+	)
+	and not exists (select 1 from expired_queues e where e.queue_id = dq.queue_id)
+order by
+	d.queue_ts_begin, d.id
+`
+
+func (d *detector) fetchStuckQueues(ctx context.Context, now time.Time) (queues []Queue, err error) {
+	conn, release, err := d.pool.AcquireContext(ctx)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer release()
+
+	minAge, maximalQueueLifetime := d.currentAges()
+
+	// older messages have already expired
+	oldestQueueTime := now.Add(-maximalQueueLifetime)
+
+	rows, err := conn.QueryContext(ctx, pendingRecipientsStmt,
+		parser.DeferredStatus, oldestQueueTime.Unix(), now.Unix(), oldestQueueTime.Unix(), now.Add(-minAge).Unix())
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	byName := map[string]int{}
+
+	for rows.Next() {
+		var (
+			name, senderLocalPart, senderDomain, recipientDomain string
+			queueTs, deliveryTs                                  int64
+		)
+
+		if err := rows.Scan(&name, &queueTs, &deliveryTs, &senderLocalPart, &senderDomain, &recipientDomain); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		queuedAt := time.Unix(queueTs, 0).In(time.UTC)
+
+		index, ok := byName[name]
+		if !ok {
+			index = len(queues)
+			byName[name] = index
+
+			queues = append(queues, Queue{
+				Queue:     name,
+				Sender:    senderLocalPart + "@" + senderDomain,
+				QueuedAt:  queuedAt,
+				ExpiresAt: queuedAt.Add(maximalQueueLifetime),
+			})
+		}
+
+		q := &queues[index]
+
+		q.Recipients++
+
+		if lastAttempt := time.Unix(deliveryTs, 0).In(time.UTC); lastAttempt.After(q.LastAttempt) {
+			q.LastAttempt = lastAttempt
+		}
+
+		if !containsString(q.RecipientDomains, recipientDomain) {
+			q.RecipientDomains = append(q.RecipientDomains, recipientDomain)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return queues, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (d *detector) filterInFlight(ctx context.Context, queues []Queue) ([]Queue, error) {
+	if d.options.InFlightQueues == nil {
+		return queues, nil
+	}
+
+	inFlight, err := d.options.InFlightQueues.InFlightQueues(ctx)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	filtered := make([]Queue, 0, len(queues))
+
+	for _, q := range queues {
+		if _, ok := inFlight[q.Queue]; ok {
+			filtered = append(filtered, q)
+		}
+	}
+
+	return filtered, nil
+}
+
+func (d *detector) detect(ctx context.Context, now time.Time) (Content, bool, error) {
+	queues, err := d.fetchStuckQueues(ctx, now)
+	if err != nil {
+		return Content{}, false, errorutil.Wrap(err)
+	}
+
+	queues, err = d.filterInFlight(ctx, queues)
+	if err != nil {
+		return Content{}, false, errorutil.Wrap(err)
+	}
+
+	if len(queues) == 0 {
+		return Content{}, false, nil
+	}
+
+	// the ones which will expire first come first
+	sort.SliceStable(queues, func(i, j int) bool {
+		return queues[i].QueuedAt.Before(queues[j].QueuedAt)
+	})
+
+	content := Content{
+		Total:    len(queues),
+		Interval: timeutil.TimeInterval{From: queues[0].QueuedAt, To: now},
+	}
+
+	if d.options.MaxQueues > 0 && len(queues) > d.options.MaxQueues {
+		queues = queues[:d.options.MaxQueues]
+	}
+
+	content.Queues = queues
+
+	return content, true, nil
+}
+
+const (
+	lastExecutionKind  = "stuck_queues"
+	lastGenerationKind = "stuck_queues_insight"
+)
+
+func (d *detector) Step(c core.Clock, tx *sql.Tx) error {
+	now := c.Now()
+
+	lastExecution, err := core.RetrieveLastDetectorExecution(tx, lastExecutionKind)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if !lastExecution.IsZero() && now.Sub(lastExecution) < d.options.CheckInterval {
+		return nil
+	}
+
+	if err := d.maybeGenerateInsight(tx, c); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if err := core.StoreLastDetectorExecution(tx, lastExecutionKind, now); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func (d *detector) maybeGenerateInsight(tx *sql.Tx, c core.Clock) error {
+	lastGeneration, err := core.RetrieveLastDetectorExecution(tx, lastGenerationKind)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if !lastGeneration.IsZero() && c.Now().Sub(lastGeneration) < d.options.MinTimeToGenerateNewInsight {
+		return nil
+	}
+
+	content, found, err := d.detect(context.Background(), c.Now())
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if !found {
+		return nil
+	}
+
+	if err := generateInsight(tx, c, d.creator, content); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if err := core.StoreLastDetectorExecution(tx, lastGenerationKind, c.Now()); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func generateInsight(tx *sql.Tx, c core.Clock, creator core.Creator, content Content) error {
+	properties := core.InsightProperties{
+		Time:        c.Now(),
+		Category:    core.LocalCategory,
+		Rating:      core.BadRating,
+		ContentType: ContentType,
+		Content:     content,
+	}
+
+	if err := creator.GenerateInsight(context.Background(), tx, properties); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+//go:build dev || !release
+// +build dev !release
+
+package stuckqueues
+
+import (
+	"database/sql"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+// Executed only on development builds, for better developer experience
+func (d *detector) GenerateSampleInsight(tx *sql.Tx, c core.Clock) error {
+	queuedAt := c.Now().Add(-time.Hour * 50)
+
+	_, maximalQueueLifetime := d.currentAges()
+
+	content := Content{
+		Total: 2,
+		Queues: []Queue{
+			{
+				Queue:            "4AB3D1E0A1",
+				Sender:           "newsletter@example.com",
+				RecipientDomains: []string{"example.org", "example.net"},
+				Recipients:       12,
+				QueuedAt:         queuedAt,
+				LastAttempt:      c.Now().Add(-time.Minute * 20),
+				ExpiresAt:        queuedAt.Add(maximalQueueLifetime),
+			},
+			{
+				Queue:            "9C01F2B3D4",
+				Sender:           "alice@example.com",
+				RecipientDomains: []string{"example.org"},
+				Recipients:       1,
+				QueuedAt:         queuedAt.Add(time.Hour * 3),
+				LastAttempt:      c.Now().Add(-time.Minute * 45),
+				ExpiresAt:        queuedAt.Add(time.Hour * 3).Add(maximalQueueLifetime),
+			},
+		},
+		Interval: timeutil.TimeInterval{From: queuedAt, To: c.Now()},
+	}
+
+	if err := generateInsight(tx, c, d.creator, content); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,252 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package stuckqueues
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gitlab.com/lightmeter/controlcenter/deliverydb"
+	"gitlab.com/lightmeter/controlcenter/domainmapping"
+	"gitlab.com/lightmeter/controlcenter/i18n/translator"
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	_ "gitlab.com/lightmeter/controlcenter/insights/migrations"
+	insighttestsutil "gitlab.com/lightmeter/controlcenter/insights/testutil"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3"
+	"gitlab.com/lightmeter/controlcenter/notification"
+	notificationCore "gitlab.com/lightmeter/controlcenter/notification/core"
+	parser "gitlab.com/lightmeter/controlcenter/pkg/postfix/logparser"
+	"gitlab.com/lightmeter/controlcenter/pkg/runner"
+	insightsSettings "gitlab.com/lightmeter/controlcenter/settings/insights"
+	"gitlab.com/lightmeter/controlcenter/tracking"
+	"gitlab.com/lightmeter/controlcenter/util/testutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+func init() {
+	lmsqlite3.Initialize(lmsqlite3.Options{})
+}
+
+type fakeInFlightQueues map[string]struct{}
+
+func (f fakeInFlightQueues) InFlightQueues(context.Context) (map[string]struct{}, error) {
+	return f, nil
+}
+
+type delivery struct {
+	queue     string
+	queuedAt  time.Time
+	time      time.Time
+	status    parser.SmtpStatus
+	sender    string
+	recipient string
+	domain    string
+}
+
+func buildResult(d delivery, checksum int64) tracking.Result {
+	result := tracking.Result{}
+	result[tracking.ResultDeliveryTimeKey] = tracking.ResultEntryInt64(d.time.Unix())
+	result[tracking.QueueSenderLocalPartKey] = tracking.ResultEntryText(d.sender)
+	result[tracking.QueueSenderDomainPartKey] = tracking.ResultEntryText("example.com")
+	result[tracking.ResultRecipientLocalPartKey] = tracking.ResultEntryText(d.recipient)
+	result[tracking.ResultRecipientDomainPartKey] = tracking.ResultEntryText(d.domain)
+	result[tracking.ResultStatusKey] = tracking.ResultEntryInt64(int64(d.status))
+	result[tracking.ResultMessageDirectionKey] = tracking.ResultEntryInt64(int64(tracking.MessageDirectionOutbound))
+	result[tracking.QueueMessageIDKey] = tracking.ResultEntryText("msgid_" + d.queue)
+	result[tracking.QueueOriginalMessageSizeKey] = tracking.ResultEntryInt64(35)
+	result[tracking.QueueProcessedMessageSizeKey] = tracking.ResultEntryInt64(42)
+	result[tracking.QueueNRCPTKey] = tracking.ResultEntryInt64(1)
+	result[tracking.ResultDeliveryServerKey] = tracking.ResultEntryText("mail")
+	result[tracking.ResultDelayKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDelaySMTPDKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDelayCleanupKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDelayQmgrKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDelaySMTPKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDSNKey] = tracking.ResultEntryText("4.4.1")
+	result[tracking.QueueBeginKey] = tracking.ResultEntryInt64(d.queuedAt.Unix())
+	result[tracking.QueueDeliveryNameKey] = tracking.ResultEntryText(d.queue)
+	result[tracking.ResultDeliveryLineChecksum] = tracking.ResultEntryInt64(checksum)
+
+	return result
+}
+
+func TestStuckQueuesInsight(t *testing.T) {
+	Convey("Stuck queues", t, func() {
+		conn, closeConn := testutil.TempDBConnectionMigrated(t, "logs")
+		defer closeConn()
+
+		db, err := deliverydb.New(conn, &domainmapping.DefaultMapping, deliverydb.Options{RetentionDuration: time.Hour * 24 * 30})
+		So(err, ShouldBeNil)
+
+		done, cancel := runner.Run(db)
+		pub := db.ResultsPublisher()
+
+		accessor, clear := insighttestsutil.NewFakeAccessor(t)
+		defer clear()
+
+		baseTime := testutil.MustParseTime(`2000-01-01 00:00:00 +0000`)
+
+		checksum := int64(0)
+
+		// a delivery attempt each two hours, from the time the message was queued until the end
+		retry := func(queue string, queuedAt, end time.Time, status parser.SmtpStatus, sender, recipient, domain string) {
+			for ts := queuedAt.Add(time.Minute); ts.Before(end); ts = ts.Add(time.Hour * 2) {
+				checksum++
+				pub.Publish(buildResult(delivery{queue, queuedAt, ts, parser.DeferredStatus, sender, recipient, domain}, checksum))
+			}
+
+			if status != parser.DeferredStatus {
+				checksum++
+				pub.Publish(buildResult(delivery{queue, queuedAt, end, status, sender, recipient, domain}, checksum))
+			}
+		}
+
+		now := baseTime.Add(time.Hour * 30)
+
+		// stuck for one of the recipients only
+		retry("AAAAAAAAAA", baseTime, now, parser.DeferredStatus, "newsletter", "alice", "example.org")
+		retry("AAAAAAAAAA", baseTime, now, parser.DeferredStatus, "newsletter", "bob", "example.org")
+		retry("AAAAAAAAAA", baseTime, now, parser.DeferredStatus, "newsletter", "carol", "example.net")
+		retry("AAAAAAAAAA", baseTime, baseTime.Add(time.Hour*10), parser.SentStatus, "newsletter", "dave", "example.de")
+
+		// eventually delivered
+		retry("BBBBBBBBBB", baseTime.Add(time.Hour), baseTime.Add(time.Hour*28), parser.SentStatus, "bob", "carol", "example.net")
+
+		// bounced after a while
+		retry("CCCCCCCCCC", baseTime.Add(time.Hour), baseTime.Add(time.Hour*27), parser.BouncedStatus, "bob", "dave", "example.net")
+
+		// too recent to be a problem
+		retry("DDDDDDDDDD", baseTime.Add(time.Hour*20), now, parser.DeferredStatus, "bob", "erin", "example.net")
+
+		// stuck, but removed from the queue by hand
+		retry("EEEEEEEEEE", baseTime.Add(time.Hour*2), baseTime.Add(time.Hour*5), parser.DeferredStatus, "bob", "frank", "example.net")
+
+		// stuck too
+		retry("FFFFFFFFFF", baseTime.Add(time.Hour*3), now, parser.DeferredStatus, "alice", "grace", "example.com")
+
+		cancel()
+		So(done(), ShouldBeNil)
+
+		options := Options{
+			CheckInterval:               time.Minute * 30,
+			MinAge:                      time.Hour * 24,
+			MaximalQueueLifetime:        time.Hour * 24 * 5,
+			MinTimeToGenerateNewInsight: time.Hour * 12,
+			MaxQueues:                   10,
+			InFlightQueues: fakeInFlightQueues{
+				"AAAAAAAAAA": {},
+				"DDDDDDDDDD": {},
+				"FFFFFFFFFF": {},
+				"UNRELATED0": {},
+			},
+		}
+
+		Convey("Default ages", func() {
+			detector := NewDetector(&insightsSettings.Settings{}, accessor, core.Options{"logsConnPool": conn.RoConnPool, "stuckqueues": options})
+
+			clock := &insighttestsutil.FakeClock{Time: now}
+
+			insighttestsutil.ExecuteCyclesUntil(detector, accessor, clock, now.Add(time.Hour*6), time.Minute*20)
+
+			// a second one is generated only after some time
+			So(accessor.Insights, ShouldResemble, []int64{1})
+
+			insights, err := accessor.FetchInsights(context.Background(), core.FetchOptions{
+				Interval: timeutil.TimeInterval{From: baseTime, To: now.Add(time.Hour * 24)},
+				OrderBy:  core.OrderByCreationAsc,
+			}, clock)
+			So(err, ShouldBeNil)
+			So(len(insights), ShouldEqual, 1)
+
+			So(insights[0].ContentType(), ShouldEqual, ContentType)
+			So(insights[0].Rating(), ShouldEqual, core.BadRating)
+			So(insights[0].Time(), ShouldEqual, now)
+
+			So(insights[0].Content(), ShouldResemble, &Content{
+				Total: 2,
+				Queues: []Queue{
+					{
+						Queue:            "AAAAAAAAAA",
+						Sender:           "newsletter@example.com",
+						RecipientDomains: []string{"example.org", "example.net"},
+						Recipients:       3,
+						QueuedAt:         baseTime,
+						LastAttempt:      baseTime.Add(time.Hour*28 + time.Minute),
+						ExpiresAt:        baseTime.Add(time.Hour * 24 * 5),
+					},
+					{
+						Queue:            "FFFFFFFFFF",
+						Sender:           "alice@example.com",
+						RecipientDomains: []string{"example.com"},
+						Recipients:       1,
+						QueuedAt:         baseTime.Add(time.Hour * 3),
+						LastAttempt:      baseTime.Add(time.Hour*29 + time.Minute),
+						ExpiresAt:        baseTime.Add(time.Hour*24*5 + time.Hour*3),
+					},
+				},
+				Interval: timeutil.TimeInterval{From: baseTime, To: now},
+			})
+		})
+
+		Convey("Ages set in the settings", func() {
+			settings := &insightsSettings.Settings{StuckQueuesMinAge: 6, StuckQueuesMaximalQueueLifetime: 2}
+
+			detector := NewDetector(settings, accessor, core.Options{"logsConnPool": conn.RoConnPool, "stuckqueues": options})
+
+			clock := &insighttestsutil.FakeClock{Time: now}
+
+			insighttestsutil.ExecuteCyclesUntil(detector, accessor, clock, now.Add(time.Minute*20), time.Minute*20)
+
+			So(accessor.Insights, ShouldResemble, []int64{1})
+
+			insights, err := accessor.FetchInsights(context.Background(), core.FetchOptions{
+				Interval: timeutil.TimeInterval{From: baseTime, To: now.Add(time.Hour * 24)},
+				OrderBy:  core.OrderByCreationAsc,
+			}, clock)
+			So(err, ShouldBeNil)
+			So(len(insights), ShouldEqual, 1)
+
+			content, ok := insights[0].Content().(*Content)
+			So(ok, ShouldBeTrue)
+
+			// the too recent one is stuck as well now
+			So(content.Total, ShouldEqual, 3)
+			So(content.Queues[0].ExpiresAt, ShouldEqual, baseTime.Add(time.Hour*24*2))
+			So(content.Queues[2].Queue, ShouldEqual, "DDDDDDDDDD")
+		})
+	})
+}
+
+func TestDescriptionFormatting(t *testing.T) {
+	Convey("Description Formatting", t, func() {
+		queuedAt := testutil.MustParseTime(`2000-01-01 00:00:00 +0000`)
+
+		content := Content{
+			Total: 3,
+			Queues: []Queue{
+				{
+					Queue:            "AAAAAAAAAA",
+					Sender:           "newsletter@example.com",
+					RecipientDomains: []string{"example.org"},
+					Recipients:       1,
+					QueuedAt:         queuedAt,
+					LastAttempt:      queuedAt.Add(time.Hour * 30),
+					ExpiresAt:        queuedAt.Add(time.Hour * 24 * 5),
+				},
+			},
+			Interval: timeutil.TimeInterval{From: queuedAt, To: queuedAt.Add(time.Hour * 30)},
+		}
+
+		m, err := notificationCore.TranslateNotification(notification.Notification{ID: 1, Content: content}, translator.DummyTranslator{})
+		So(err, ShouldBeNil)
+		So(m, ShouldResemble, notificationCore.Message{
+			Title:       "Messages stuck in the queue",
+			Description: "3 messages have been deferred for a long time, some since 2000-01-01 00:00:00 +0000 UTC, and the first of them will expire on 2000-01-06 00:00:00 +0000 UTC unless delivered before",
+			Metadata:    map[string]string{},
+		})
+	})
+}
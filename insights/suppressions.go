@@ -0,0 +1,121 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package insights
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/dbconn"
+	"gitlab.com/lightmeter/controlcenter/notification"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+// MuteInsights creates a rule which suppresses the insights generated from now on matching the content type and parameters
+func (e *Engine) MuteInsights(ctx context.Context, contentType string, params map[string]string, until *time.Time) (int64, error) {
+	var id int64
+
+	err := e.runTxAction(ctx, func(tx *sql.Tx) error {
+		muteID, err := core.Mute(ctx, tx, contentType, params, until, time.Now())
+		if err != nil {
+			return err
+		}
+
+		id = muteID
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (e *Engine) SnoozeInsight(ctx context.Context, insightID int64, until time.Time) (int64, error) {
+	var id int64
+
+	err := e.runTxAction(ctx, func(tx *sql.Tx) error {
+		snoozeID, err := core.Snooze(ctx, tx, insightID, until, time.Now())
+		if err != nil {
+			return err
+		}
+
+		id = snoozeID
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (e *Engine) AcknowledgeInsight(ctx context.Context, insightID int64) (int64, error) {
+	var id int64
+
+	err := e.runTxAction(ctx, func(tx *sql.Tx) error {
+		ackID, err := core.Acknowledge(ctx, tx, insightID, time.Now())
+		if err != nil {
+			return err
+		}
+
+		id = ackID
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (e *Engine) DeleteInsightSuppression(ctx context.Context, id int64) error {
+	return e.runTxAction(ctx, func(tx *sql.Tx) error {
+		return core.DeleteSuppression(ctx, tx, id)
+	})
+}
+
+func (e *Engine) InsightSuppressions(ctx context.Context) ([]core.Suppression, error) {
+	suppressions, err := core.ListSuppressions(ctx, e.accessor.conn.RoConnPool, time.Now())
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return suppressions, nil
+}
+
+// suppressedInsightsPolicy prevents notifications about insights which are muted, snoozed or acknowledged
+type suppressedInsightsPolicy struct {
+	pool *dbconn.RoPool
+}
+
+func (p *suppressedInsightsPolicy) Reject(n notification.Notification) (bool, error) {
+	properties, ok := n.Content.(core.InsightProperties)
+	if !ok {
+		return false, nil
+	}
+
+	conn, release, err := p.pool.AcquireContext(context.Background())
+	if err != nil {
+		return false, errorutil.Wrap(err)
+	}
+
+	defer release()
+
+	suppressions, err := core.MatchingSuppressions(context.Background(), conn, properties)
+	if err != nil {
+		return false, errorutil.Wrap(err)
+	}
+
+	return len(suppressions) > 0, nil
+}
@@ -0,0 +1,277 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package insights
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	insighttestsutil "gitlab.com/lightmeter/controlcenter/insights/testutil"
+	notificationCore "gitlab.com/lightmeter/controlcenter/notification/core"
+	"gitlab.com/lightmeter/controlcenter/util/testutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+type fakeHostContent struct {
+	Host string `json:"host"`
+}
+
+func (c fakeHostContent) Title() notificationCore.ContentComponent {
+	return fakeContentComponent(c.Host)
+}
+
+func (c fakeHostContent) Description() notificationCore.ContentComponent {
+	return fakeContentComponent(c.Host)
+}
+
+func (c fakeHostContent) Metadata() notificationCore.ContentMetadata {
+	return nil
+}
+
+func (c fakeHostContent) MuteParams() map[string]string {
+	return map[string]string{"host": c.Host}
+}
+
+func init() {
+	core.RegisterContentType("fake_host_insight_type", 201, core.DefaultContentTypeDecoder(&fakeHostContent{}))
+}
+
+func TestInsightSuppressions(t *testing.T) {
+	Convey("Insight suppressions", t, func() {
+		accessor, clear := insighttestsutil.NewFakeAccessor(t)
+		defer clear()
+
+		baseTime := testutil.MustParseTime(`2000-01-01 00:00:00 +0000`)
+
+		tx := func(f func(*sql.Tx) error) {
+			So(accessor.ConnPair.RwConn.Tx(context.Background(), func(_ context.Context, tx *sql.Tx) error {
+				return f(tx)
+			}), ShouldBeNil)
+		}
+
+		properties := func(t time.Time, host string) core.InsightProperties {
+			return core.InsightProperties{
+				Time:        t,
+				Category:    core.LocalCategory,
+				Rating:      core.BadRating,
+				ContentType: "fake_host_insight_type",
+				Content:     fakeHostContent{Host: host},
+			}
+		}
+
+		generate := func(t time.Time, host string) {
+			tx(func(tx *sql.Tx) error {
+				return accessor.GenerateInsight(context.Background(), tx, properties(t, host))
+			})
+		}
+
+		fetchHosts := func(now time.Time) []string {
+			insights, err := accessor.FetchInsights(context.Background(), core.FetchOptions{
+				Interval: timeutil.TimeInterval{From: baseTime, To: baseTime.Add(time.Hour * 24 * 30)},
+				OrderBy:  core.OrderByCreationAsc,
+			}, &timeutil.FakeClock{Time: now})
+			So(err, ShouldBeNil)
+
+			hosts := []string{}
+
+			for _, i := range insights {
+				host := i.Content().(*fakeHostContent).Host
+
+				if i.Acknowledged() {
+					host += " (acknowledged)"
+				}
+
+				hosts = append(hosts, host)
+			}
+
+			return hosts
+		}
+
+		policy := suppressedInsightsPolicy{pool: accessor.ConnPair.RoConnPool}
+
+		rejected := func(t time.Time, host string) bool {
+			r, err := policy.Reject(notificationCore.Notification{Content: properties(t, host)})
+			So(err, ShouldBeNil)
+			return r
+		}
+
+		generate(baseTime, "yahoo")
+		generate(baseTime, "gmail")
+
+		Convey("Nothing suppressed", func() {
+			So(fetchHosts(baseTime), ShouldResemble, []string{"yahoo", "gmail"})
+			So(rejected(baseTime, "yahoo"), ShouldBeFalse)
+
+			suppressions, err := core.ListSuppressions(context.Background(), accessor.ConnPair.RoConnPool, baseTime)
+			So(err, ShouldBeNil)
+			So(suppressions, ShouldBeEmpty)
+		})
+
+		Convey("Mute rules hide only the matching insights generated afterwards", func() {
+			var id int64
+
+			tx(func(tx *sql.Tx) (err error) {
+				id, err = core.Mute(context.Background(), tx, "fake_host_insight_type", map[string]string{"host": "yahoo"}, nil, baseTime)
+				return err
+			})
+
+			So(rejected(baseTime.Add(time.Hour), "yahoo"), ShouldBeTrue)
+			So(rejected(baseTime.Add(time.Hour), "gmail"), ShouldBeFalse)
+
+			generate(baseTime.Add(time.Hour), "yahoo")
+			generate(baseTime.Add(time.Hour), "gmail")
+
+			So(fetchHosts(baseTime.Add(time.Hour)), ShouldResemble, []string{"yahoo", "gmail", "gmail"})
+
+			suppressions, err := core.ListSuppressions(context.Background(), accessor.ConnPair.RoConnPool, baseTime)
+			So(err, ShouldBeNil)
+			So(suppressions, ShouldResemble, []core.Suppression{{
+				ID:          id,
+				Kind:        core.MuteSuppression,
+				ContentType: "fake_host_insight_type",
+				Params:      map[string]string{"host": "yahoo"},
+				CreatedAt:   baseTime,
+			}})
+
+			Convey("Deleting the rule shows the insights again", func() {
+				tx(func(tx *sql.Tx) error {
+					return core.DeleteSuppression(context.Background(), tx, id)
+				})
+
+				So(fetchHosts(baseTime.Add(time.Hour)), ShouldResemble, []string{"yahoo", "gmail", "yahoo", "gmail"})
+				So(rejected(baseTime.Add(time.Hour), "yahoo"), ShouldBeFalse)
+			})
+		})
+
+		Convey("Rules without parameters mute the whole content type, until they expire", func() {
+			until := baseTime.Add(time.Hour * 2)
+
+			tx(func(tx *sql.Tx) error {
+				_, err := core.Mute(context.Background(), tx, "fake_host_insight_type", nil, &until, baseTime)
+				return err
+			})
+
+			generate(baseTime.Add(time.Hour), "gmail")
+			generate(baseTime.Add(time.Hour*3), "outlook")
+
+			So(fetchHosts(baseTime.Add(time.Hour*3)), ShouldResemble, []string{"yahoo", "gmail", "outlook"})
+			So(rejected(baseTime.Add(time.Hour), "outlook"), ShouldBeTrue)
+			So(rejected(baseTime.Add(time.Hour*3), "outlook"), ShouldBeFalse)
+		})
+
+		Convey("Invalid mute rules", func() {
+			past := baseTime.Add(-time.Hour)
+
+			So(accessor.ConnPair.RwConn.Tx(context.Background(), func(_ context.Context, tx *sql.Tx) error {
+				_, err := core.Mute(context.Background(), tx, "unknown_insight_type", nil, nil, baseTime)
+				return err
+			}), ShouldWrap, core.ErrInvalidSuppression)
+
+			So(accessor.ConnPair.RwConn.Tx(context.Background(), func(_ context.Context, tx *sql.Tx) error {
+				_, err := core.Mute(context.Background(), tx, "fake_host_insight_type", nil, &past, baseTime)
+				return err
+			}), ShouldWrap, core.ErrInvalidSuppression)
+		})
+
+		Convey("Snoozed insights, and similar ones, are hidden until the snooze expires", func() {
+			until := baseTime.Add(time.Hour * 2)
+
+			tx(func(tx *sql.Tx) error {
+				_, err := core.Snooze(context.Background(), tx, int64(accessor.Insights[0]), until, baseTime)
+				return err
+			})
+
+			generate(baseTime.Add(time.Hour), "yahoo")
+			generate(baseTime.Add(time.Hour), "gmail")
+
+			So(rejected(baseTime.Add(time.Hour), "yahoo"), ShouldBeTrue)
+			So(rejected(baseTime.Add(time.Hour), "gmail"), ShouldBeFalse)
+
+			So(fetchHosts(baseTime.Add(time.Hour)), ShouldResemble, []string{"gmail", "gmail"})
+			So(fetchHosts(baseTime.Add(time.Hour*3)), ShouldResemble, []string{"yahoo", "gmail", "yahoo", "gmail"})
+
+			So(rejected(baseTime.Add(time.Hour*3), "yahoo"), ShouldBeFalse)
+
+			So(accessor.ConnPair.RwConn.Tx(context.Background(), func(_ context.Context, tx *sql.Tx) error {
+				_, err := core.Snooze(context.Background(), tx, 42, until, baseTime)
+				return err
+			}), ShouldWrap, core.ErrNoSuchInsight)
+		})
+
+		Convey("Snoozing or acknowledging insights without mute parameters affects only themselves", func() {
+			generateWithoutParams := func(t time.Time, title string) {
+				tx(func(tx *sql.Tx) error {
+					return accessor.GenerateInsight(context.Background(), tx, core.InsightProperties{
+						Time:        t,
+						Category:    core.LocalCategory,
+						Rating:      core.BadRating,
+						ContentType: "fake_insight_type",
+						Content:     fakeContent{T: title},
+					})
+				})
+			}
+
+			generateWithoutParams(baseTime, "first")
+			generateWithoutParams(baseTime, "second")
+
+			tx(func(tx *sql.Tx) error {
+				_, err := core.Snooze(context.Background(), tx, int64(accessor.Insights[2]), baseTime.Add(time.Hour*2), baseTime)
+				return err
+			})
+
+			tx(func(tx *sql.Tx) error {
+				_, err := core.Acknowledge(context.Background(), tx, int64(accessor.Insights[3]), baseTime)
+				return err
+			})
+
+			generateWithoutParams(baseTime.Add(time.Hour), "third")
+
+			insights, err := accessor.FetchInsights(context.Background(), core.FetchOptions{
+				Interval: timeutil.TimeInterval{From: baseTime, To: baseTime.Add(time.Hour * 24)},
+				OrderBy:  core.OrderByCreationAsc,
+			}, &timeutil.FakeClock{Time: baseTime.Add(time.Hour)})
+			So(err, ShouldBeNil)
+
+			titles := []string{}
+
+			for _, i := range insights {
+				if c, ok := i.Content().(*fakeContent); ok {
+					titles = append(titles, c.T)
+					So(i.Acknowledged(), ShouldEqual, c.T == "second")
+				}
+			}
+
+			So(titles, ShouldResemble, []string{"second", "third"})
+		})
+
+		Convey("Acknowledged insights, and similar ones, are visible, but not notified", func() {
+			var id1, id2 int64
+
+			tx(func(tx *sql.Tx) (err error) {
+				id1, err = core.Acknowledge(context.Background(), tx, int64(accessor.Insights[1]), baseTime)
+				return err
+			})
+
+			// acknowledging again changes nothing
+			tx(func(tx *sql.Tx) (err error) {
+				id2, err = core.Acknowledge(context.Background(), tx, int64(accessor.Insights[1]), baseTime)
+				return err
+			})
+
+			So(id2, ShouldEqual, id1)
+
+			generate(baseTime.Add(time.Hour), "gmail")
+
+			So(rejected(baseTime.Add(time.Hour*24*365), "gmail"), ShouldBeTrue)
+			So(rejected(baseTime.Add(time.Hour), "yahoo"), ShouldBeFalse)
+
+			So(fetchHosts(baseTime.Add(time.Hour)), ShouldResemble, []string{"yahoo", "gmail (acknowledged)", "gmail (acknowledged)"})
+		})
+	})
+}
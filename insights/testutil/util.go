@@ -36,6 +36,10 @@ func (c *FakeAccessor) GenerateInsight(ctx context.Context, tx *sql.Tx, properti
 		return errorutil.Wrap(err)
 	}
 
+	if err := core.SuppressNewInsight(ctx, tx, id, properties); err != nil {
+		return errorutil.Wrap(err)
+	}
+
 	c.Insights = append(c.Insights, id)
 
 	return nil
@@ -0,0 +1,406 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package trafficanomaly
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/i18n/translator"
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/dbconn"
+	notificationCore "gitlab.com/lightmeter/controlcenter/notification/core"
+	parser "gitlab.com/lightmeter/controlcenter/pkg/postfix/logparser"
+	"gitlab.com/lightmeter/controlcenter/tracking"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+// The traffic of most mail servers is heavily weekly-seasonal, so instead of fixed thresholds
+// the volume of each hour is compared with the same hour of the week in the previous weeks.
+
+const (
+	ContentType   = "traffic_anomaly"
+	ContentTypeId = 14
+)
+
+type Options struct {
+	// How many past weeks are used to build the baseline of each hour of the week
+	BaselineWeeks int
+
+	// The baseline is used only when there are logs for at least this many past weeks
+	MinBaselineWeeks int
+
+	// How many standard deviations away from the baseline a volume must be to be an anomaly
+	Sensitivity float64
+
+	// Spikes below this volume, and drops from baselines below it, are not significant
+	MinVolume float64
+
+	// Minimum time between two insights for the same metric and kind of anomaly
+	MinTimeToGenerateNewInsight time.Duration
+}
+
+type Metric string
+
+const (
+	SentMetric     Metric = "sent"
+	ReceivedMetric Metric = "received"
+	BouncedMetric  Metric = "bounced"
+	DeferredMetric Metric = "deferred"
+)
+
+var metrics = []Metric{SentMetric, ReceivedMetric, BouncedMetric, DeferredMetric}
+
+type Kind string
+
+const (
+	SpikeKind Kind = "spike"
+	DropKind  Kind = "drop"
+)
+
+type Content struct {
+	Metric   Metric                `json:"metric"`
+	Kind     Kind                  `json:"kind"`
+	Value    int64                 `json:"value"`
+	Baseline float64               `json:"baseline"`
+	Interval timeutil.TimeInterval `json:"interval"`
+}
+
+func (c Content) Title() notificationCore.ContentComponent {
+	return &title{c}
+}
+
+func (c Content) Description() notificationCore.ContentComponent {
+	return &description{c}
+}
+
+func (c Content) Metadata() notificationCore.ContentMetadata {
+	return nil
+}
+
+func (c Content) MuteParams() map[string]string {
+	return map[string]string{"metric": string(c.Metric), "kind": string(c.Kind)}
+}
+
+type title struct {
+	c Content
+}
+
+func (t title) String() string {
+	return translator.Stringfy(t)
+}
+
+func (t title) TplString() string {
+	if t.c.Kind == DropKind {
+		return translator.I18n("Unusual drop in %v messages")
+	}
+
+	return translator.I18n("Unusual spike in %v messages")
+}
+
+func (t title) Args() []interface{} {
+	return []interface{}{t.c.Metric}
+}
+
+type description struct {
+	c Content
+}
+
+func (d description) String() string {
+	return translator.Stringfy(d)
+}
+
+func (d description) TplString() string {
+	return translator.I18n("%v messages %v between %v and %v, whereas about %v are usual at this time of the week")
+}
+
+func (d description) Args() []interface{} {
+	return []interface{}{d.c.Value, d.c.Metric, d.c.Interval.From, d.c.Interval.To, int64(math.Round(d.c.Baseline))}
+}
+
+func init() {
+	core.RegisterContentType(ContentType, ContentTypeId, core.DefaultContentTypeDecoder(&Content{}))
+}
+
+type detector struct {
+	pool    *dbconn.RoPool
+	creator core.Creator
+	options Options
+}
+
+func (*detector) IsHistoricalDetector() {
+	// Required by the historical import
+}
+
+func (*detector) Close() error {
+	return nil
+}
+
+func getDetectorOptions(options core.Options) Options {
+	detectorOptions, ok := options["trafficanomaly"].(Options)
+	if !ok {
+		errorutil.MustSucceed(errors.New("Invalid detector options"))
+	}
+
+	return detectorOptions
+}
+
+func NewDetector(creator core.Creator, options core.Options) core.Detector {
+	pool, ok := options["logsConnPool"].(*dbconn.RoPool)
+	if !ok {
+		errorutil.MustSucceed(errors.New("Invalid Connection Pool"))
+	}
+
+	return &detector{
+		pool:    pool,
+		creator: creator,
+		options: getDetectorOptions(options),
+	}
+}
+
+type volumes map[Metric]float64
+
+func countVolumes(ctx context.Context, conn *dbconn.RoPooledConn, interval timeutil.TimeInterval) (vs volumes, err error) {
+	rows, err := conn.QueryContext(ctx, `
+		select
+			direction, status, count(*)
+		from
+			deliveries
+		where
+			delivery_ts >= ? and delivery_ts < ?
+		group by
+			direction, status`, interval.From.Unix(), interval.To.Unix())
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	vs = volumes{}
+
+	for rows.Next() {
+		var (
+			direction tracking.MessageDirection
+			status    parser.SmtpStatus
+			count     int64
+		)
+
+		if err := rows.Scan(&direction, &status, &count); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		if direction == tracking.MessageDirectionIncoming {
+			vs[ReceivedMetric] += float64(count)
+			continue
+		}
+
+		switch status {
+		case parser.SentStatus:
+			vs[SentMetric] += float64(count)
+		case parser.BouncedStatus:
+			vs[BouncedMetric] += float64(count)
+		case parser.DeferredStatus:
+			vs[DeferredMetric] += float64(count)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return vs, nil
+}
+
+const oneWeek = time.Hour * 24 * 7
+
+// fetchVolumes returns the volumes in the interval and in the same interval in each one of the past weeks
+// for which there are logs, most recent first
+func fetchVolumes(ctx context.Context, pool *dbconn.RoPool, interval timeutil.TimeInterval, weeks int) (volumes, []volumes, error) {
+	conn, release, err := pool.AcquireContext(ctx)
+	if err != nil {
+		return nil, nil, errorutil.Wrap(err)
+	}
+
+	defer release()
+
+	var oldest int64
+
+	if err := conn.QueryRowContext(ctx, `select coalesce(min(delivery_ts), 0) from deliveries`).Scan(&oldest); err != nil {
+		return nil, nil, errorutil.Wrap(err)
+	}
+
+	current, err := countVolumes(ctx, conn, interval)
+	if err != nil {
+		return nil, nil, errorutil.Wrap(err)
+	}
+
+	past := []volumes{}
+
+	for week := 1; week <= weeks; week++ {
+		offset := oneWeek * time.Duration(week)
+
+		weekInterval := timeutil.TimeInterval{From: interval.From.Add(-offset), To: interval.To.Add(-offset)}
+
+		// a week before the logs start is not known to have had no traffic
+		if weekInterval.From.Unix() < oldest {
+			break
+		}
+
+		vs, err := countVolumes(ctx, conn, weekInterval)
+		if err != nil {
+			return nil, nil, errorutil.Wrap(err)
+		}
+
+		past = append(past, vs)
+	}
+
+	return current, past, nil
+}
+
+// detect checks whether the value deviates from the baseline, returning its mean
+func detect(value float64, baseline []float64, options Options) (Kind, float64, bool) {
+	if len(baseline) == 0 || len(baseline) < options.MinBaselineWeeks {
+		return "", 0, false
+	}
+
+	var mean, variance float64
+
+	for _, v := range baseline {
+		mean += v
+	}
+
+	mean /= float64(len(baseline))
+
+	for _, v := range baseline {
+		variance += (v - mean) * (v - mean)
+	}
+
+	variance /= float64(len(baseline))
+
+	// very regular traffic has a tiny variance, so counts are assumed to vary at least as much
+	// as a Poisson process would, otherwise any small deviation would be an anomaly
+	stddev := math.Sqrt(math.Max(variance, mean))
+
+	if value >= options.MinVolume && value > mean+options.Sensitivity*stddev {
+		return SpikeKind, mean, true
+	}
+
+	if mean >= options.MinVolume && value < mean-options.Sensitivity*stddev {
+		return DropKind, mean, true
+	}
+
+	return "", mean, false
+}
+
+// the logs of the last minutes of an hour might still be on their way
+const evaluationDelay = time.Minute * 5
+
+const lastEvaluationKind = "traffic_anomaly"
+
+func (d *detector) Step(c core.Clock, tx *sql.Tx) error {
+	now := c.Now()
+
+	hourEnd := now.Add(-evaluationDelay).Truncate(time.Hour)
+
+	lastEvaluation, err := core.RetrieveLastDetectorExecution(tx, lastEvaluationKind)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if !lastEvaluation.Before(hourEnd) {
+		return nil
+	}
+
+	interval := timeutil.TimeInterval{From: hourEnd.Add(-time.Hour), To: hourEnd}
+
+	current, past, err := fetchVolumes(context.Background(), d.pool, interval, d.options.BaselineWeeks)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	for _, m := range metrics {
+		baseline := make([]float64, 0, len(past))
+
+		for _, vs := range past {
+			baseline = append(baseline, vs[m])
+		}
+
+		kind, mean, found := detect(current[m], baseline, d.options)
+		if !found {
+			continue
+		}
+
+		content := Content{Metric: m, Kind: kind, Value: int64(current[m]), Baseline: mean, Interval: interval}
+
+		if err := d.maybeGenerateInsight(tx, c, content); err != nil {
+			return errorutil.Wrap(err)
+		}
+	}
+
+	if err := core.StoreLastDetectorExecution(tx, lastEvaluationKind, hourEnd); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func (d *detector) maybeGenerateInsight(tx *sql.Tx, c core.Clock, content Content) error {
+	kind := lastEvaluationKind + "_" + string(content.Metric) + "_" + string(content.Kind)
+
+	lastGeneration, err := core.RetrieveLastDetectorExecution(tx, kind)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if !lastGeneration.IsZero() && c.Now().Sub(lastGeneration) < d.options.MinTimeToGenerateNewInsight {
+		return nil
+	}
+
+	if err := generateInsight(tx, c, d.creator, content); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if err := core.StoreLastDetectorExecution(tx, kind, c.Now()); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func rating(content Content) core.Rating {
+	switch {
+	// possibly a compromised account or a misbehaving application
+	case content.Kind == SpikeKind && content.Metric != ReceivedMetric:
+		return core.BadRating
+	case content.Kind == SpikeKind:
+		return core.OkRating
+	// fewer problems than usual
+	case content.Metric == BouncedMetric || content.Metric == DeferredMetric:
+		return core.GoodRating
+	}
+
+	// the server, or something before or after it, might have stopped working
+	return core.BadRating
+}
+
+func generateInsight(tx *sql.Tx, c core.Clock, creator core.Creator, content Content) error {
+	properties := core.InsightProperties{
+		Time:        c.Now(),
+		Category:    core.LocalCategory,
+		Rating:      rating(content),
+		ContentType: ContentType,
+		Content:     content,
+	}
+
+	if err := creator.GenerateInsight(context.Background(), tx, properties); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+//go:build dev || !release
+// +build dev !release
+
+package trafficanomaly
+
+import (
+	"database/sql"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+// Executed only on development builds, for better developer experience
+func (d *detector) GenerateSampleInsight(tx *sql.Tx, c core.Clock) error {
+	hourEnd := c.Now().Truncate(time.Hour)
+
+	content := Content{
+		Metric:   SentMetric,
+		Kind:     SpikeKind,
+		Value:    1432,
+		Baseline: 118.5,
+		Interval: timeutil.TimeInterval{From: hourEnd.Add(-time.Hour), To: hourEnd},
+	}
+
+	if err := generateInsight(tx, c, d.creator, content); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
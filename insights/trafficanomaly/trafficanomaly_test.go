@@ -0,0 +1,199 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package trafficanomaly
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gitlab.com/lightmeter/controlcenter/deliverydb"
+	"gitlab.com/lightmeter/controlcenter/domainmapping"
+	"gitlab.com/lightmeter/controlcenter/i18n/translator"
+	"gitlab.com/lightmeter/controlcenter/insights/core"
+	_ "gitlab.com/lightmeter/controlcenter/insights/migrations"
+	insighttestsutil "gitlab.com/lightmeter/controlcenter/insights/testutil"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3"
+	"gitlab.com/lightmeter/controlcenter/notification"
+	notificationCore "gitlab.com/lightmeter/controlcenter/notification/core"
+	parser "gitlab.com/lightmeter/controlcenter/pkg/postfix/logparser"
+	"gitlab.com/lightmeter/controlcenter/pkg/runner"
+	"gitlab.com/lightmeter/controlcenter/tracking"
+	"gitlab.com/lightmeter/controlcenter/util/testutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+func init() {
+	lmsqlite3.Initialize(lmsqlite3.Options{})
+}
+
+var testOptions = Options{
+	BaselineWeeks:               4,
+	MinBaselineWeeks:            3,
+	Sensitivity:                 4,
+	MinVolume:                   20,
+	MinTimeToGenerateNewInsight: time.Hour * 6,
+}
+
+func buildResult(t time.Time, status parser.SmtpStatus, direction tracking.MessageDirection, checksum int64) tracking.Result {
+	result := tracking.Result{}
+	result[tracking.ResultDeliveryTimeKey] = tracking.ResultEntryInt64(t.Unix())
+	result[tracking.QueueSenderLocalPartKey] = tracking.ResultEntryText("sender")
+	result[tracking.QueueSenderDomainPartKey] = tracking.ResultEntryText("sender.example.com")
+	result[tracking.ResultRecipientLocalPartKey] = tracking.ResultEntryText("recipient")
+	result[tracking.ResultRecipientDomainPartKey] = tracking.ResultEntryText("recipient.example.com")
+	result[tracking.ResultStatusKey] = tracking.ResultEntryInt64(int64(status))
+	result[tracking.ResultMessageDirectionKey] = tracking.ResultEntryInt64(int64(direction))
+	result[tracking.QueueMessageIDKey] = tracking.ResultEntryText("msgid")
+	result[tracking.QueueOriginalMessageSizeKey] = tracking.ResultEntryInt64(35)
+	result[tracking.QueueProcessedMessageSizeKey] = tracking.ResultEntryInt64(42)
+	result[tracking.QueueNRCPTKey] = tracking.ResultEntryInt64(1)
+	result[tracking.ResultDeliveryServerKey] = tracking.ResultEntryText("mail")
+	result[tracking.ResultDelayKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDelaySMTPDKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDelayCleanupKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDelayQmgrKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDelaySMTPKey] = tracking.ResultEntryFloat64(0.0)
+	result[tracking.ResultDSNKey] = tracking.ResultEntryText("2.0.0")
+	result[tracking.QueueBeginKey] = tracking.ResultEntryInt64(0)
+	result[tracking.QueueDeliveryNameKey] = tracking.ResultEntryText("A1")
+	result[tracking.ResultDeliveryLineChecksum] = tracking.ResultEntryInt64(checksum)
+
+	return result
+}
+
+func TestDetection(t *testing.T) {
+	Convey("Detection", t, func() {
+		Convey("Not enough history", func() {
+			_, _, found := detect(1000, []float64{10, 10}, testOptions)
+			So(found, ShouldBeFalse)
+		})
+
+		Convey("Usual volume", func() {
+			_, mean, found := detect(110, []float64{100, 90, 110, 100}, testOptions)
+			So(found, ShouldBeFalse)
+			So(mean, ShouldEqual, 100)
+		})
+
+		Convey("Spike", func() {
+			kind, mean, found := detect(200, []float64{100, 90, 110, 100}, testOptions)
+			So(found, ShouldBeTrue)
+			So(kind, ShouldEqual, SpikeKind)
+			So(mean, ShouldEqual, 100)
+		})
+
+		Convey("Drop", func() {
+			kind, _, found := detect(40, []float64{100, 90, 110, 100}, testOptions)
+			So(found, ShouldBeTrue)
+			So(kind, ShouldEqual, DropKind)
+		})
+
+		Convey("Small volumes are not significant", func() {
+			_, _, found := detect(15, []float64{0, 1, 0, 0}, testOptions)
+			So(found, ShouldBeFalse)
+
+			_, _, found = detect(0, []float64{10, 12, 9, 11}, testOptions)
+			So(found, ShouldBeFalse)
+		})
+
+		Convey("Regular traffic still allows for some variation", func() {
+			_, _, found := detect(115, []float64{100, 100, 100}, testOptions)
+			So(found, ShouldBeFalse)
+		})
+	})
+}
+
+func TestTrafficAnomalyInsight(t *testing.T) {
+	Convey("Traffic anomaly insights", t, func() {
+		conn, closeConn := testutil.TempDBConnectionMigrated(t, "logs")
+		defer closeConn()
+
+		db, err := deliverydb.New(conn, &domainmapping.DefaultMapping, deliverydb.Options{RetentionDuration: time.Hour * 24 * 30 * 3})
+		So(err, ShouldBeNil)
+
+		done, cancel := runner.Run(db)
+		pub := db.ResultsPublisher()
+
+		accessor, clear := insighttestsutil.NewFakeAccessor(t)
+		defer clear()
+
+		// a Monday
+		baseTime := testutil.MustParseTime(`2000-01-03 10:00:00 +0000`)
+
+		checksum := int64(0)
+
+		publish := func(week int, count int, status parser.SmtpStatus, direction tracking.MessageDirection) {
+			for i := 0; i < count; i++ {
+				checksum++
+				pub.Publish(buildResult(baseTime.Add(oneWeek*time.Duration(week)).Add(time.Second*time.Duration(i*10)), status, direction, checksum))
+			}
+		}
+
+		// the logs start a day before the first week
+		checksum++
+		pub.Publish(buildResult(baseTime.Add(-time.Hour*24), parser.DeferredStatus, tracking.MessageDirectionOutbound, checksum))
+
+		for week, sent := range []int{30, 32, 28, 30} {
+			publish(week, sent, parser.SentStatus, tracking.MessageDirectionOutbound)
+			publish(week, 40, parser.SentStatus, tracking.MessageDirectionIncoming)
+		}
+
+		// on the fifth Monday, many more messages are sent, and none is received
+		publish(4, 120, parser.SentStatus, tracking.MessageDirectionOutbound)
+
+		cancel()
+		So(done(), ShouldBeNil)
+
+		detector := NewDetector(accessor, core.Options{"logsConnPool": conn.RoConnPool, "trafficanomaly": testOptions})
+
+		clock := &insighttestsutil.FakeClock{Time: baseTime}
+
+		// as on the historical import
+		insighttestsutil.ExecuteCyclesUntil(detector, accessor, clock, baseTime.Add(oneWeek*4).Add(time.Hour*3), time.Minute*20)
+
+		So(accessor.Insights, ShouldResemble, []int64{1, 2})
+
+		insights, err := accessor.FetchInsights(context.Background(), core.FetchOptions{
+			Interval: timeutil.TimeInterval{From: baseTime, To: baseTime.Add(oneWeek * 5)},
+			OrderBy:  core.OrderByCreationAsc,
+		}, clock)
+		So(err, ShouldBeNil)
+		So(len(insights), ShouldEqual, 2)
+
+		interval := timeutil.TimeInterval{From: baseTime.Add(oneWeek * 4), To: baseTime.Add(oneWeek * 4).Add(time.Hour)}
+
+		// the first step after the delay given to the logs of the last minutes of the hour
+		So(insights[0].Time(), ShouldEqual, interval.To.Add(time.Minute*20))
+		So(insights[0].Rating(), ShouldEqual, core.BadRating)
+		So(insights[0].Content(), ShouldResemble, &Content{Metric: SentMetric, Kind: SpikeKind, Value: 120, Baseline: 30, Interval: interval})
+
+		So(insights[1].Rating(), ShouldEqual, core.BadRating)
+		So(insights[1].Content(), ShouldResemble, &Content{Metric: ReceivedMetric, Kind: DropKind, Value: 0, Baseline: 40, Interval: interval})
+	})
+}
+
+func TestDescriptionFormatting(t *testing.T) {
+	Convey("Description Formatting", t, func() {
+		n := notification.Notification{
+			ID: 1,
+			Content: Content{
+				Metric:   SentMetric,
+				Kind:     SpikeKind,
+				Value:    1432,
+				Baseline: 118.5,
+				Interval: timeutil.TimeInterval{From: testutil.MustParseTime(`2000-01-01 10:00:00 +0000`), To: testutil.MustParseTime(`2000-01-01 11:00:00 +0000`)},
+			},
+		}
+
+		m, err := notificationCore.TranslateNotification(n, translator.DummyTranslator{})
+		So(err, ShouldBeNil)
+		So(m, ShouldResemble, notificationCore.Message{
+			Title:       "Unusual spike in sent messages",
+			Description: "1432 messages sent between 2000-01-01 10:00:00 +0000 UTC and 2000-01-01 11:00:00 +0000 UTC, whereas about 119 are usual at this time of the week",
+			Metadata:    map[string]string{},
+		})
+	})
+}
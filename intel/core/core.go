@@ -27,7 +27,7 @@ type Options struct {
 
 func NewRunner(conn dbconn.RwConn, options Options) *dbrunner.Runner {
 	stmts := dbconn.PreparedStmts{}
-	return dbrunner.New(options.CycleInterval, 10, conn, stmts, time.Hour*12, MakeCleanAction(options.RetentionDuration))
+	return dbrunner.New("intel-collector", options.CycleInterval, 10, conn, stmts, time.Hour*12, MakeCleanAction(options.RetentionDuration))
 }
 
 func MakeCleanAction(maxAge time.Duration) dbrunner.Action {
@@ -40,8 +40,21 @@ func TestDnsRBL(t *testing.T) {
 			// that is not instantaneous
 			time.Sleep(200 * time.Millisecond)
 
+			if strings.HasSuffix(rblList, "-failing") {
+				return godnsbl.RBLResults{
+					Host:    targetHost,
+					List:    rblList,
+					Results: []godnsbl.Result{{Address: targetHost, Rbl: rblList, Error: true, ErrorType: &net.DNSError{IsTimeout: true}}},
+				}
+			}
+
 			if !strings.HasSuffix(rblList, "-blocked") {
-				return godnsbl.RBLResults{}
+				// that's how godnsbl reports an IP not being listed
+				return godnsbl.RBLResults{
+					Host:    targetHost,
+					List:    rblList,
+					Results: []godnsbl.Result{{Address: targetHost, Rbl: rblList, Error: true, ErrorType: &net.DNSError{IsNotFound: true}}},
+				}
 			}
 
 			return godnsbl.RBLResults{
@@ -97,9 +110,12 @@ func TestDnsRBL(t *testing.T) {
 				time.Sleep(700 * time.Millisecond)
 
 				select {
-				case <-checker.checkerResultsChan:
-					So(false, ShouldBeTrue)
+				case r := <-checker.checkerResultsChan:
+					So(r.Err, ShouldBeNil)
+					So(r.RBLs, ShouldBeEmpty)
+					So(r.Checked, ShouldResemble, []string{"rbl1", "rbl2", "rbl3", "rbl4", "rbl5"})
 				default:
+					So(false, ShouldBeTrue)
 				}
 			})
 
@@ -107,7 +123,7 @@ func TestDnsRBL(t *testing.T) {
 				checker := newDnsChecker(meta.Reader, Options{
 					Lookup:           lookup,
 					NumberOfWorkers:  2,
-					RBLProvidersURLs: []string{"rbl1-blocked", "rbl2", "rbl3-blocked", "rbl4-blocked", "rbl5"},
+					RBLProvidersURLs: []string{"rbl1-blocked", "rbl2", "rbl3-blocked", "rbl4-blocked", "rbl5-failing"},
 				})
 
 				defer checker.Close()
@@ -128,6 +144,9 @@ func TestDnsRBL(t *testing.T) {
 						{RBL: "rbl4-blocked", Text: "Some Error"},
 					})
 
+					// the failing RBL is not known to list the IP or not
+					So(r.Checked, ShouldResemble, []string{"rbl1-blocked", "rbl2", "rbl3-blocked", "rbl4-blocked"})
+
 					So(r.Interval.From, ShouldResemble, baseTime)
 					So(r.Interval.To.After(r.Interval.From), ShouldBeTrue)
 				default:
@@ -136,6 +155,48 @@ func TestDnsRBL(t *testing.T) {
 			})
 		})
 
+		Convey("Many IP addresses are defined", func() {
+			{
+				settings := globalsettings.Settings{
+					LocalIP:  globalsettings.IP{net.ParseIP("11.22.33.44")},
+					LocalIPs: []globalsettings.IP{{net.ParseIP("11.22.33.45")}, {net.ParseIP("11.22.33.44")}},
+				}
+
+				meta.Writer.StoreJson(dummyContext, globalsettings.SettingsKey, &settings)
+			}
+
+			checker := newDnsChecker(meta.Reader, Options{
+				Lookup:           lookup,
+				NumberOfWorkers:  2,
+				RBLProvidersURLs: []string{"rbl1-blocked", "rbl2"},
+			})
+
+			defer checker.Close()
+
+			checker.StartListening()
+
+			baseTime := testutil.MustParseTime(`2000-01-01 00:00:00 +0000`)
+
+			checker.NotifyNewScan(baseTime)
+
+			// each IP is checked, and listed on its own results
+			for _, ip := range []string{"11.22.33.44", "11.22.33.45"} {
+				r := <-checker.checkerResultsChan
+				So(r.Err, ShouldBeNil)
+				So(r.Address, ShouldResemble, net.ParseIP(ip))
+				So(r.RBLs, ShouldResemble, []ContentElement{{RBL: "rbl1-blocked", Text: "Some Error"}})
+				So(r.Scanned, ShouldResemble, []net.IP{net.ParseIP("11.22.33.44"), net.ParseIP("11.22.33.45")})
+			}
+
+			time.Sleep(300 * time.Millisecond)
+
+			select {
+			case <-checker.checkerResultsChan:
+				So(false, ShouldBeTrue)
+			default:
+			}
+		})
+
 		Convey("Do not scan if IP address is not defined", func() {
 			checker := newDnsChecker(meta.Reader, Options{
 				Lookup:           lookup,
@@ -159,3 +220,10 @@ func TestDnsRBL(t *testing.T) {
 		})
 	})
 }
+
+func TestReverseIPv6(t *testing.T) {
+	Convey("IPv6 addresses are queried by their reversed nibbles", t, func() {
+		So(reverseIPv6(net.ParseIP("2001:db8:1:2:3:4:567:89ab")), ShouldEqual, "b.a.9.8.7.6.5.0.4.0.0.0.3.0.0.0.2.0.0.0.1.0.0.0.8.b.d.0.1.0.0.2")
+		So(reverseIPv6(net.ParseIP("::1")), ShouldEqual, "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0")
+	})
+}
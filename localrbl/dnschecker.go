@@ -13,6 +13,8 @@ import (
 	"gitlab.com/lightmeter/controlcenter/settings/globalsettings"
 	"gitlab.com/lightmeter/controlcenter/util/errorutil"
 	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+	"net"
+	"strings"
 	"sync"
 	"time"
 )
@@ -20,11 +22,67 @@ import (
 type DNSLookupFunction func(string, string) godnsbl.RBLResults
 
 var (
-	RealLookup DNSLookupFunction = godnsbl.Lookup
+	RealLookup DNSLookupFunction = lookup
 )
 
+// reverseIPv6 returns the nibbles of an IPv6 address in reverse order, separated by dots,
+// as used to query DNS blocklists (RFC 5782)
+func reverseIPv6(ip net.IP) string {
+	const hexDigits = "0123456789abcdef"
+
+	ip = ip.To16()
+
+	var b strings.Builder
+
+	for i := len(ip) - 1; i >= 0; i-- {
+		if i < len(ip)-1 {
+			b.WriteByte('.')
+		}
+
+		b.WriteByte(hexDigits[ip[i]&0xf])
+		b.WriteByte('.')
+		b.WriteByte(hexDigits[ip[i]>>4])
+	}
+
+	return b.String()
+}
+
+// lookup extends godnsbl.Lookup, which supports IPv4 addresses only, to IPv6 addresses.
+// An address is listed if the blocklist resolves it into an address in 127.0.0.0/8.
+func lookup(rblList string, targetHost string) godnsbl.RBLResults {
+	ip := net.ParseIP(targetHost)
+	if ip == nil || ip.To4() != nil {
+		return godnsbl.Lookup(rblList, targetHost)
+	}
+
+	result := godnsbl.Result{Rbl: rblList, Address: ip.String()}
+
+	name := reverseIPv6(ip) + "." + rblList
+
+	addrs, err := net.LookupHost(name)
+
+	for _, addr := range addrs {
+		if strings.HasPrefix(addr, "127.") {
+			result.Listed = true
+		}
+	}
+
+	if len(addrs) > 0 {
+		if txt, _ := net.LookupTXT(name); len(txt) > 0 {
+			result.Text = txt[0]
+		}
+	}
+
+	if err != nil {
+		result.Error = true
+		result.ErrorType = err
+	}
+
+	return godnsbl.RBLResults{List: rblList, Host: targetHost, Results: []godnsbl.Result{result}}
+}
+
 type dnsChecker struct {
-	*globalsettings.MetaReaderGetter
+	globalsettings.IPAddressesGetter
 
 	checkerStartChan   chan time.Time
 	checkerResultsChan chan Results
@@ -41,8 +99,13 @@ func newDnsChecker(meta metadata.Reader, options Options) *dnsChecker {
 		log.Panic().Msg("Lookup function not defined!")
 	}
 
+	ips := options.IPs
+	if ips == nil {
+		ips = globalsettings.New(meta)
+	}
+
 	return &dnsChecker{
-		MetaReaderGetter:   globalsettings.New(meta),
+		IPAddressesGetter:  ips,
 		checkerStartChan:   make(chan time.Time, 32),
 		checkerResultsChan: make(chan Results),
 		options:            options,
@@ -92,24 +155,21 @@ var (
 	ErrIPNotConfigured = errors.New(`IP not configured`)
 )
 
-// TODO: refactor this function into smaller pieces, as it's quite trivial
 func startNewScan(checker *dnsChecker, t time.Time) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
 
 	defer cancel()
 
-	results := make([]godnsbl.Result, len(checker.options.RBLProvidersURLs))
-
-	ip := checker.IPAddress(ctx)
+	ips := checker.IPAddresses(ctx)
 
 	if err := ctx.Err(); err != nil {
-		errorutil.LogErrorf(err, "obtaining IP address from settings on RBL Check")
+		errorutil.LogErrorf(err, "obtaining IP addresses from settings on RBL Check")
 		checker.checkerResultsChan <- Results{Err: errorutil.Wrap(err)}
 
 		return
 	}
 
-	if ip == nil {
+	if len(ips) == 0 {
 		// Do not perform a scan if the user has not configured an IP
 		log.Warn().Msg("Ignoring RBL scan as IP is not configured")
 		checker.checkerResultsChan <- Results{Err: ErrIPNotConfigured}
@@ -117,6 +177,16 @@ func startNewScan(checker *dnsChecker, t time.Time) {
 		return
 	}
 
+	// each IP is scanned in turn, and its results are sent once available
+	for _, ip := range ips {
+		scanIP(checker, t, ip, ips)
+	}
+}
+
+// TODO: refactor this function into smaller pieces, as it's quite trivial
+func scanIP(checker *dnsChecker, t time.Time, ip net.IP, scanned []net.IP) {
+	results := make([]godnsbl.Result, len(checker.options.RBLProvidersURLs))
+
 	log.Info().Msgf("Starting a new RBL scan on IP %v", ip)
 
 	scanStartTime := time.Now()
@@ -155,22 +225,46 @@ func startNewScan(checker *dnsChecker, t time.Time) {
 	log.Info().Msgf("RBL scan finished in %v", scanElapsedTime)
 
 	rbls := make([]ContentElement, 0, numberOfURLs)
+	checked := make([]string, 0, numberOfURLs)
 
-	for _, r := range results {
+	for i, r := range results {
 		if r.Listed {
 			rbls = append(rbls, ContentElement{RBL: r.Rbl, Text: r.Text})
 		}
+
+		if lookupSucceeded(r) {
+			checked = append(checked, checker.options.RBLProvidersURLs[i])
+		}
 	}
 
 	if len(rbls) == 0 {
 		log.Info().Msg("RBL scan finished with no results")
-		return
+	} else {
+		log.Info().Msgf("RBL scan finished with list blockings %d", len(rbls))
 	}
 
-	log.Info().Msgf("RBL scan finished with list blockings %d", len(rbls))
-
 	checker.checkerResultsChan <- Results{
+		Address:  ip,
 		Interval: timeutil.TimeInterval{From: t, To: t.Add(scanElapsedTime)},
 		RBLs:     rbls,
+		Checked:  checked,
+		Scanned:  scanned,
 	}
 }
+
+// lookupSucceeded tells whether the RBL answered, as an IP not being listed
+// is reported by godnsbl as a "not found" error
+func lookupSucceeded(r godnsbl.Result) bool {
+	if len(r.Rbl) == 0 {
+		// no lookup was done, as the address could not be resolved
+		return false
+	}
+
+	if r.Listed || !r.Error {
+		return true
+	}
+
+	var dnsErr *net.DNSError
+
+	return errors.As(r.ErrorType, &dnsErr) && dnsErr.IsNotFound
+}
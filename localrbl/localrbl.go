@@ -8,6 +8,7 @@ import (
 	"gitlab.com/lightmeter/controlcenter/settings/globalsettings"
 	"gitlab.com/lightmeter/controlcenter/util/timeutil"
 	"io"
+	"net"
 	"time"
 )
 
@@ -15,6 +16,9 @@ type Options struct {
 	NumberOfWorkers  int
 	RBLProvidersURLs []string
 	Lookup           DNSLookupFunction
+
+	// The IPs to be checked. If not set, the ones in the settings are used
+	IPs globalsettings.IPAddressesGetter
 }
 
 type ContentElement struct {
@@ -24,13 +28,20 @@ type ContentElement struct {
 
 type Results struct {
 	Err      error
+	Address  net.IP
 	Interval timeutil.TimeInterval
 	RBLs     []ContentElement
+
+	// All the RBLs which answered, listing the IP or not. The ones which failed are not here
+	Checked []string
+
+	// All the IPs scanned in the same round, this one included, each one with its own results
+	Scanned []net.IP
 }
 
 type Checker interface {
 	io.Closer
-	globalsettings.IPAddressGetter
+	globalsettings.IPAddressesGetter
 	StartListening()
 	NotifyNewScan(time.Time)
 	Step(time.Time, func(Results) error, func() error) error
@@ -91,13 +91,15 @@ type localFileWatcher struct {
 	format   parsertimeutil.TimeFormat
 }
 
-func (w *localFileWatcher) run(onNewRecord func(parser.Header, string, int)) {
+func (w *localFileWatcher) run(onNewRecord func(parser.Header, string, int), onInvalidLine func(string)) {
 	for line := range w.t.Lines {
 		lineContent := line.Text
 		h, payloadOffset, err := parser.ParseHeaderWithCustomTimeFormat(lineContent, w.format)
 
 		if !parser.IsRecoverableError(err) {
 			log.Error().Msgf("parsing line on file: %v", w.filename)
+			onInvalidLine(lineContent)
+
 			continue
 		}
 
@@ -443,7 +443,7 @@ func FindInitialLogTime(content DirectoryContent, patterns LogPatterns, format p
 }
 
 type fileWatcher interface {
-	run(onNewRecord func(h parser.Header, line string, payloadOffset int))
+	run(onNewRecord func(h parser.Header, line string, payloadOffset int), onInvalidLine func(line string))
 }
 
 type DirectoryContent interface {
@@ -533,6 +533,9 @@ type parsedHeaderRecord struct {
 	location      postfix.RecordLocation
 	payloadOffset int
 	line          string
+
+	// the header could not be parsed, so time is the one of the previous line
+	invalid bool
 }
 
 type queueProcessor struct {
@@ -786,7 +789,16 @@ func updateQueueProcessor(clock timeutil.Clock, p *queueProcessor, content Direc
 
 		if !parser.IsRecoverableError(err) {
 			log.Warn().Msgf("Could not parse log line in %v", loc)
-			continue
+
+			// the time of the line is unknown, so the one of the line before it is used
+			p.record = parsedHeaderRecord{
+				time:     p.record.time,
+				location: loc,
+				line:     line,
+				invalid:  true,
+			}
+
+			return true, nil
 		}
 
 		if p.converter == nil {
@@ -874,6 +886,30 @@ func chooseIndexForOldestElement(queueProcessors []*queueProcessor) int {
 	return chosenIndex
 }
 
+// publishes a line which could not be parsed, unless it has been already imported before
+func publishInvalidLineIfNeeded(clock timeutil.Clock, pub postfix.Publisher, r parsedHeaderRecord, sum postfix.SumPair, checkSumHasAlreadyMatched bool) {
+	if r.time.IsZero() && !sum.Time.IsZero() {
+		// no line before it in the file, so it cannot be known whether it has been imported before
+		return
+	}
+
+	// ignore any old logs
+	if r.time.Before(sum.Time) {
+		return
+	}
+
+	if r.time.Equal(sum.Time) && sum.Sum != nil && !checkSumHasAlreadyMatched {
+		return
+	}
+
+	t := r.time
+	if t.IsZero() {
+		t = clock.Now()
+	}
+
+	postfix.PublishInvalidLine(pub, postfix.InvalidLine{Time: t, Line: r.line})
+}
+
 func countNumberOfFilesInQueues(queues fileQueues) int {
 	numberOfFiles := 0
 
@@ -950,6 +986,11 @@ func importExistingLogs(
 
 		t := queueProcessors[toBeUpdated].record
 
+		if t.invalid {
+			publishInvalidLineIfNeeded(clock, pub, t, sum, checkSumHasAlreadyMatched)
+			continue
+		}
+
 		// ignore any old logs
 		if t.time.Before(sum.Time) {
 			continue
@@ -1071,6 +1112,8 @@ type parsedRecord struct {
 	sequence   uint64
 
 	loc postfix.RecordLocation
+
+	invalid bool
 }
 
 // responsible for watching for new logs added to a file
@@ -1100,6 +1143,10 @@ func startWatchingOnQueue(
 
 		outChan <- record
 
+		sequence++
+	}, func(line string) {
+		outChan <- parsedRecord{line: line, queueIndex: queueIndex, sequence: sequence, invalid: true}
+
 		sequence++
 	})
 
@@ -1122,8 +1169,34 @@ func startTimestampingParsedLogs(
 	done chan<- struct{}) {
 	converter := <-converterChan
 
+	var (
+		lastTime time.Time
+
+		// invalid lines before any valid one, waiting for a time
+		pendingInvalid []parsedRecord
+	)
+
 	for p := range parsedRecordsChan {
+		// the time of an invalid line is unknown, so the one of the line before it is used
+		if p.invalid && lastTime.IsZero() {
+			pendingInvalid = append(pendingInvalid, p)
+			continue
+		}
+
+		if p.invalid {
+			sortableRecordsChan <- sortableRecord{record: p, time: lastTime}
+			continue
+		}
+
 		t := converter.Convert(p.header.Time)
+		lastTime = t
+
+		for _, invalid := range pendingInvalid {
+			sortableRecordsChan <- sortableRecord{record: invalid, time: t}
+		}
+
+		pendingInvalid = nil
+
 		r := sortableRecord{record: p, time: t}
 		sortableRecordsChan <- r
 	}
@@ -1204,6 +1277,7 @@ func publishNewLogsSorted(sortableRecordsChan <-chan sortableRecord, pub partial
 				time:          s.time,
 				location:      s.record.loc,
 				line:          s.record.line,
+				invalid:       s.record.invalid,
 			}
 		}
 	}
@@ -1377,6 +1451,11 @@ func (importer *DirectoryImporter) run(watch bool) error {
 
 	// Start really publishing the buffered records here, indefinitely
 	for r := range partiallyParsedLogsPublisher.records {
+		if r.invalid {
+			postfix.PublishInvalidLine(ignoringPublisher, postfix.InvalidLine{Time: r.time, Line: r.line})
+			continue
+		}
+
 		p, err := parser.ParsePayload(r.header, r.line[r.payloadOffset:])
 		if !parser.IsRecoverableError(err) {
 			log.Warn().Msgf("Failed to parse log payload at %v with error: %v", r.location, err)
@@ -1413,6 +1492,11 @@ type ignoringPublisher struct {
 	lastPublishedSumPair postfix.SumPair
 }
 
+// PublishInvalidLine does not ignore any line, as the old ones are ignored already when importing them
+func (pub *ignoringPublisher) PublishInvalidLine(l postfix.InvalidLine) {
+	postfix.PublishInvalidLine(pub.pub, l)
+}
+
 func (pub *ignoringPublisher) Publish(r postfix.Record) {
 	// Do not let old logs be published
 	shouldIgnore := !pub.lastPublishedSumPair.Time.IsZero() &&
@@ -121,8 +121,8 @@ type fakeFileWatcher struct {
 	reader   io.Reader
 }
 
-func (f fakeFileWatcher) run(onNewRecord func(parser.Header, string, int)) {
-	readFromReader(f.reader, f.filename, onNewRecord)
+func (f fakeFileWatcher) run(onNewRecord func(parser.Header, string, int), onInvalidLine func(string)) {
+	readFromReader(f.reader, f.filename, onNewRecord, onInvalidLine)
 }
 
 func (f FakeDirectoryContent) watcherForEntry(filename string, offset int64) (fileWatcher, error) {
@@ -982,6 +982,35 @@ func TestImportDirectoryAndWatchNewLines(t *testing.T) {
 			So(pub.logs[2].Header.Time, ShouldResemble, parser.Time{Month: time.August, Day: 10, Hour: 0, Minute: 0, Second: 40})
 		})
 
+		Convey("Lines which cannot be parsed are published as invalid, with the time of the line before them", func() {
+			dirContent := FakeDirectoryContent{
+				entries: fileEntryList{
+					fileEntry{filename: "log/mail.log.1", modificationTime: testutil.MustParseTime(`2020-07-14 06:01:53 +0000`)},
+					fileEntry{filename: "log/mail.log", modificationTime: testutil.MustParseTime(`2020-08-19 12:00:00 +0000`)},
+				},
+				contents: map[string]fakeFileData{
+					"log/mail.log.1": plainDataFile(`Jun 18 08:29:33 mail dovecot: Useless Payload
+some garbage in the middle of the file
+Jun 18 08:29:35 mail dovecot: Useless Payload`),
+					"log/mail.log": plainCurrentDataFile(`Jul 18 00:00:00 mail dovecot: Useless Payload
+`, // NOTE: the breakline is important here to mimic the real content of a file
+						`Aug 10 00:00:40 mail postfix/postscreen[17274]: Useless Payload
+more garbage`),
+				},
+			}
+
+			pub := fakePublisher{}
+			importer := NewDirectoryImporter(dirContent, &pub, announcer, postfix.SumPair{Time: testutil.MustParseTime(`1970-01-01 00:00:00 +0000`)}, timeFormat, patterns, clock)
+			err := importer.Run()
+			So(err, ShouldBeNil)
+			So(len(pub.logs), ShouldEqual, 4)
+
+			So(pub.invalidLines, ShouldResemble, []postfix.InvalidLine{
+				{Time: testutil.MustParseTime(`2020-06-18 08:29:33 +0000`), Line: `some garbage in the middle of the file`},
+				{Time: testutil.MustParseTime(`2020-08-10 00:00:40 +0000`), Line: `more garbage`},
+			})
+		})
+
 		Convey("Multiple files in multiple queues", func() {
 			dirContent := FakeDirectoryContent{
 				entries: fileEntryList{
@@ -22,7 +22,7 @@ type rsyncedFileWatcher struct {
 
 type rsyncedFileWatcherRunner = runner.CancellableRunner
 
-func newRsyncedFileWatcherRunner(watcher *rsyncedFileWatcher, onRecord func(parser.Header, string, int)) rsyncedFileWatcherRunner {
+func newRsyncedFileWatcherRunner(watcher *rsyncedFileWatcher, onRecord func(parser.Header, string, int), onInvalidLine func(string)) rsyncedFileWatcherRunner {
 	rw := rsyncwatcher.ReadWriter()
 
 	w, err := rsyncwatcher.New(watcher.filename, watcher.offset, rw)
@@ -45,6 +45,8 @@ func newRsyncedFileWatcherRunner(watcher *rsyncedFileWatcher, onRecord func(pars
 
 				if !parser.IsRecoverableError(err) {
 					log.Error().Msgf("parsing line on file: %v", watcher.filename)
+					onInvalidLine(line)
+
 					continue
 				}
 
@@ -61,8 +63,8 @@ func newRsyncedFileWatcherRunner(watcher *rsyncedFileWatcher, onRecord func(pars
 	})
 }
 
-func (watcher *rsyncedFileWatcher) run(onRecord func(parser.Header, string, int)) {
-	done, _ := runner.Run(newRsyncedFileWatcherRunner(watcher, onRecord))
+func (watcher *rsyncedFileWatcher) run(onRecord func(parser.Header, string, int), onInvalidLine func(string)) {
+	done, _ := runner.Run(newRsyncedFileWatcherRunner(watcher, onRecord, onInvalidLine))
 
 	// never cancel, wait forever, no error handling
 	errorutil.MustSucceed(done())
@@ -63,7 +63,7 @@ func TestWatchingDirectoryManagedByRsync(t *testing.T) {
 		newRunner := func(filename string, offset int64) rsyncedFileWatcherRunner {
 			return newRsyncedFileWatcherRunner(&rsyncedFileWatcher{filename: path.Join(dstDir, filename), offset: offset, format: timeFormat}, func(h parser.Header, _ string, _ int) {
 				logs = append(logs, parsedLog{h: h})
-			})
+			}, func(string) {})
 		}
 
 		syncDir := func() {
@@ -16,7 +16,8 @@ import (
 // nolint:unused,deadcode
 func readFromReader(reader io.Reader,
 	filename string,
-	onNewRecord func(parser.Header, string, int)) {
+	onNewRecord func(parser.Header, string, int),
+	onInvalidLine func(string)) {
 	scanner := bufio.NewScanner(reader)
 
 	for scanner.Scan() {
@@ -26,13 +27,17 @@ func readFromReader(reader io.Reader,
 
 		if parser.IsRecoverableError(err) {
 			onNewRecord(h, line, p)
+			continue
 		}
+
+		onInvalidLine(line)
 	}
 }
 
 // nolint:unused,deadcode
 type fakePublisher struct {
-	logs []postfix.Record
+	logs         []postfix.Record
+	invalidLines []postfix.InvalidLine
 }
 
 // nolint:unused,deadcode
@@ -40,6 +45,11 @@ func (pub *fakePublisher) Publish(r postfix.Record) {
 	pub.logs = append(pub.logs, r)
 }
 
+// nolint:unused,deadcode
+func (pub *fakePublisher) PublishInvalidLine(l postfix.InvalidLine) {
+	pub.invalidLines = append(pub.invalidLines, l)
+}
+
 // nolint:unused,deadcode
 type fakeFileReader struct {
 	io.Reader
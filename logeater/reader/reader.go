@@ -96,14 +96,23 @@ func ReadFromReader(reader io.Reader, pub postfix.Publisher, builder transform.B
 	}
 
 	handleNewLogLine := func(line string) {
-		var err error
-
-		currentRecord, err = t.Transform(line)
+		r, err := t.Transform(line)
 		if err != nil {
 			log.Err(err).Msgf("Error reading from reader: %v", err)
+
+			// the time of the line is unknown, but it's right after the previous one
+			invalidLineTime := currentRecord.Time
+			if invalidLineTime.IsZero() {
+				invalidLineTime = clock.Now()
+			}
+
+			postfix.PublishInvalidLine(pub, postfix.InvalidLine{Time: invalidLineTime, Line: line})
+
 			return
 		}
 
+		currentRecord = r
+
 		currentRecord.Sum = postfix.ComputeChecksum(hasher, line)
 
 		setupAnnouncerIfNeeded(currentRecord)
@@ -22,13 +22,18 @@ import (
 )
 
 type pub struct {
-	logs []postfix.Record
+	logs         []postfix.Record
+	invalidLines []postfix.InvalidLine
 }
 
 func (pub *pub) Publish(r postfix.Record) {
 	pub.logs = append(pub.logs, r)
 }
 
+func (pub *pub) PublishInvalidLine(l postfix.InvalidLine) {
+	pub.invalidLines = append(pub.invalidLines, l)
+}
+
 type fakeDelayedLine struct {
 	content string
 	delay   time.Duration
@@ -152,6 +157,26 @@ Aug 24 05:03:04 mail apple: Useless Payload
 			})
 		})
 
+		Convey("Lines which cannot be parsed are published as invalid, with the time of the line before them", func() {
+			clock := timeutil.FakeClock{Time: testutil.MustParseTime(`2000-08-24 10:00:00 +0000`)}
+
+			reader := strings.NewReader(`some garbage at the start
+Aug 20 02:03:04 mail banana: Useless Payload
+more garbage
+Aug 21 03:03:04 mail dog: Useless Payload
+`)
+
+			ReadFromReader(reader, &pub, transformer, fakeAnnouncer, &clock, time.Millisecond*500)
+
+			So(len(pub.logs), ShouldEqual, 2)
+			So(pub.logs[1].Time, ShouldEqual, testutil.MustParseTime(`2000-08-21 03:03:04 +0000`))
+
+			So(pub.invalidLines, ShouldResemble, []postfix.InvalidLine{
+				{Time: testutil.MustParseTime(`2000-08-24 10:00:00 +0000`), Line: `some garbage at the start`},
+				{Time: testutil.MustParseTime(`2000-08-20 02:03:04 +0000`), Line: `more garbage`},
+			})
+		})
+
 		Convey("Empty input timeouts immediately", func() {
 			clock := timeutil.FakeClock{Time: testutil.MustParseTime(`2000-08-24 10:00:00 +0000`)}
 			reader := &fakeDelayedReader{}
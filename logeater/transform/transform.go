@@ -57,13 +57,24 @@ type defaultTransformer struct {
 	lineNo    uint64
 }
 
+// ParseLine fails only if the header of the line cannot be parsed.
+// As when importing log files, lines whose payload cannot be parsed are still returned, without payload,
+// so they are known to be unsupported
 func ParseLine(line string, timeBuilder func(parser.Header) time.Time, loc postfix.RecordLocation, format parsertimeutil.TimeFormat) (postfix.Record, error) {
-	h, p, err := parser.ParseWithCustomTimeFormat(line, format)
-
+	h, payloadOffset, err := parser.ParseHeaderWithCustomTimeFormat(line, format)
 	if !parser.IsRecoverableError(err) {
 		return postfix.Record{}, errorutil.Wrap(err, loc)
 	}
 
+	p, err := parser.ParsePayload(h, line[payloadOffset:])
+	if !parser.IsRecoverableError(err) {
+		log.Warn().Msgf("Failed to parse log payload at %v with error: %v", loc, err)
+	}
+
+	if err != nil {
+		p = nil
+	}
+
 	time := timeBuilder(h)
 
 	return postfix.Record{
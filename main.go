@@ -10,6 +10,7 @@ import (
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"gitlab.com/lightmeter/controlcenter/api"
 	"gitlab.com/lightmeter/controlcenter/auth"
 	"gitlab.com/lightmeter/controlcenter/config"
 	"gitlab.com/lightmeter/controlcenter/lmsqlite3"
@@ -42,6 +43,15 @@ func changeUserInfo(conf config.Config) {
 	)
 }
 
+func eraseData(conf config.Config) {
+	options, err := buildWorkspaceOptions(conf)
+	if err != nil {
+		errorutil.Dief(errorutil.Wrap(err), "Could not build workspace options")
+	}
+
+	subcommand.PerformDataErasure(conf.WorkspaceDirectory, options, conf.DataToErase, os.Stdout)
+}
+
 func main() {
 	conf, err := config.Parse(os.Args[1:], os.LookupEnv)
 	if err != nil {
@@ -73,6 +83,16 @@ func main() {
 		return
 	}
 
+	if len(conf.DataToErase) > 0 {
+		eraseData(conf)
+		return
+	}
+
+	if conf.ShowUnsupportedLogLines {
+		subcommand.PrintUnsupportedLogLinesReport(conf.WorkspaceDirectory, os.Stdout)
+		return
+	}
+
 	ws, logReader, err := buildWorkspaceAndLogReader(conf)
 	if err != nil {
 		errorutil.Dief(errorutil.Wrap(err), "Error creating / opening workspace directory for storing application files: %s. Try specifying a different directory (using -workspace), or check you have permission to write to the specified location.", conf.WorkspaceDirectory)
@@ -120,6 +140,7 @@ func main() {
 		Timezone:             conf.Timezone,
 		Address:              conf.Address,
 		IsBehindReverseProxy: !conf.IKnowWhatIAmDoingNotUsingAReverseProxy,
+		Metrics:              api.MetricsOptions{Token: conf.MetricsToken, AllowedNetworks: conf.MetricsAllowedNetworks},
 	}
 
 	errorutil.MustSucceed(httpServer.Start(), "server died")
@@ -143,18 +164,25 @@ func buildAuthOptions(conf config.Config) auth.Options {
 	}
 }
 
-func buildWorkspaceAndLogReader(conf config.Config) (*workspace.Workspace, logsource.Reader, error) {
+func buildWorkspaceOptions(conf config.Config) (*workspace.Options, error) {
 	nodeTypeHandler, err := tracking.BuildNodeTypeHandler(conf.MultiNodeType)
 	if err != nil {
-		return nil, logsource.Reader{}, errorutil.Wrap(err)
+		return nil, errorutil.Wrap(err)
 	}
 
-	options := &workspace.Options{
+	return &workspace.Options{
 		IsUsingRsyncedLogs:    conf.RsyncedDir,
 		DefaultSettings:       conf.DefaultSettings,
 		AuthOptions:           buildAuthOptions(conf),
 		NodeTypeHandler:       nodeTypeHandler,
 		DataRetentionDuration: conf.DataRetentionDuration,
+	}, nil
+}
+
+func buildWorkspaceAndLogReader(conf config.Config) (*workspace.Workspace, logsource.Reader, error) {
+	options, err := buildWorkspaceOptions(conf)
+	if err != nil {
+		return nil, logsource.Reader{}, errorutil.Wrap(err)
 	}
 
 	ws, err := workspace.NewWorkspace(conf.WorkspaceDirectory, options)
@@ -0,0 +1,186 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package messagerbl
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	parser "gitlab.com/lightmeter/controlcenter/pkg/postfix/logparser"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+// The built-in matchers are shipped as data, and a newer version of them
+// can be set at runtime, via UpdateBuiltInMatchers, without a new release.
+//
+//go:embed matchers.json
+var builtInMatchersData []byte
+
+var ErrInvalidMatcher = errors.New(`Invalid message RBL matcher`)
+
+// MatcherDefinition describes how to find out, from the message of a failed delivery,
+// that a host has blocked the sender IP
+type MatcherDefinition struct {
+	ID   string `json:"id"`
+	Host string `json:"host"`
+
+	// If empty, any DSN is matched
+	DSN string `json:"dsn"`
+
+	// Regular expression matched against the message returned by the remote host
+	Pattern string `json:"pattern"`
+
+	// Optional log lines the pattern must match, used to validate it
+	Samples []string `json:"samples,omitempty"`
+
+	Notes string `json:"notes,omitempty"`
+}
+
+type BuiltInMatchers struct {
+	Version  int                 `json:"version"`
+	Matchers []MatcherDefinition `json:"matchers"`
+}
+
+func invalidMatcher(d MatcherDefinition, format string, args ...interface{}) error {
+	return fmt.Errorf("%w %q: %s", ErrInvalidMatcher, d.ID, fmt.Sprintf(format, args...))
+}
+
+func (d MatcherDefinition) compile() (matcher, error) {
+	if len(strings.TrimSpace(d.ID)) == 0 {
+		return matcher{}, fmt.Errorf("%w: the id is missing", ErrInvalidMatcher)
+	}
+
+	if len(strings.TrimSpace(d.Host)) == 0 {
+		return matcher{}, invalidMatcher(d, "the host is missing")
+	}
+
+	if len(d.Pattern) == 0 {
+		return matcher{}, invalidMatcher(d, "the pattern is missing")
+	}
+
+	pattern, err := regexp.Compile(d.Pattern)
+	if err != nil {
+		return matcher{}, invalidMatcher(d, "%v", err)
+	}
+
+	m := matcher{id: d.ID, host: d.Host, dsn: d.DSN, pattern: pattern}
+
+	for _, s := range d.Samples {
+		matches, err := m.matchLine(s)
+		if err != nil {
+			return matcher{}, invalidMatcher(d, "%v", err)
+		}
+
+		if !matches {
+			return matcher{}, invalidMatcher(d, "sample not matched: %v", s)
+		}
+	}
+
+	return m, nil
+}
+
+// Validate checks that the pattern is a valid regular expression, matching all the samples
+func (d MatcherDefinition) Validate() error {
+	_, err := d.compile()
+	return err
+}
+
+var ErrSampleNotFailedDelivery = errors.New(`The sample is not a failed delivery log line`)
+
+func (m matcher) matchLine(line string) (bool, error) {
+	_, p, err := parser.Parse(line)
+	if err != nil && !parser.IsRecoverableError(err) {
+		return false, errorutil.Wrap(err)
+	}
+
+	s, ok := p.(parser.SmtpSentStatus)
+	if !ok || s.Status == parser.SentStatus {
+		return false, ErrSampleNotFailedDelivery
+	}
+
+	return m.match(record{payload: s}), nil
+}
+
+// TestMatcher tells which of the log lines are matched by the definition,
+// without requiring them to be matched
+func TestMatcher(d MatcherDefinition, lines []string) ([]bool, error) {
+	d.Samples = nil
+
+	m, err := d.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]bool, 0, len(lines))
+
+	for _, l := range lines {
+		matches, err := m.matchLine(l)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v: %s", ErrInvalidMatcher, err, l)
+		}
+
+		results = append(results, matches)
+	}
+
+	return results, nil
+}
+
+// Validate checks all the matchers, which must have distinct ids
+func (b BuiltInMatchers) Validate() error {
+	_, err := compileMatchers(b.Matchers, nil)
+	return err
+}
+
+func compileMatchers(definitions []MatcherDefinition, disabled map[string]struct{}) (matchers, error) {
+	ids := map[string]struct{}{}
+
+	result := make(matchers, 0, len(definitions))
+
+	for _, d := range definitions {
+		m, err := d.compile()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := ids[d.ID]; ok {
+			return nil, invalidMatcher(d, "the id is already used")
+		}
+
+		ids[d.ID] = struct{}{}
+
+		if _, ok := disabled[d.ID]; ok {
+			continue
+		}
+
+		result = append(result, m)
+	}
+
+	return result, nil
+}
+
+func mustParseBuiltInMatchers() BuiltInMatchers {
+	var b BuiltInMatchers
+
+	errorutil.MustSucceed(json.Unmarshal(builtInMatchersData, &b), "Invalid built-in message RBL matchers")
+	errorutil.MustSucceed(b.Validate(), "Invalid built-in message RBL matchers")
+
+	return b
+}
+
+var (
+	DefaultBuiltInMatchers = mustParseBuiltInMatchers()
+	defaultMatchers        = mustCompileMatchers(DefaultBuiltInMatchers.Matchers)
+)
+
+func mustCompileMatchers(definitions []MatcherDefinition) matchers {
+	m, err := compileMatchers(definitions, nil)
+	errorutil.MustSucceed(err)
+
+	return m
+}
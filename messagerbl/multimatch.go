@@ -0,0 +1,210 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package messagerbl
+
+import (
+	"regexp/syntax"
+)
+
+// multiMatcher finds the first matcher matching a message scanning it only once
+// in the common case where no matcher matches it, which is most of the failed deliveries.
+//
+// Each pattern has usually some literal text that any message it matches must contain,
+// like `support.google.com`. All those literals are looked up in a single pass,
+// by an Aho-Corasick automaton, and only the patterns whose literals are found,
+// or that have no such literal, are then checked, in the same order as the matchers.
+type multiMatcher struct {
+	matchers matchers
+
+	// dense transition table, indexed by state and byte
+	delta [][256]int32
+
+	// for each state, which matchers have their literal ending there
+	outputs [][]int
+
+	// matchers without a required literal, always checked
+	always []int
+}
+
+// Literals shorter than this don't filter out messages effectively
+const minRequiredLiteralLen = 3
+
+// requiredLiteral returns the longest case sensitive literal any text matched by the pattern contains,
+// or an empty string if there's no such a literal
+func requiredLiteral(pattern string) string {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return ""
+	}
+
+	l := longestLiteral(re.Simplify())
+
+	if len(l) < minRequiredLiteralLen {
+		return ""
+	}
+
+	return l
+}
+
+func literalString(re *syntax.Regexp) string {
+	if re.Op != syntax.OpLiteral || re.Flags&syntax.FoldCase != 0 {
+		return ""
+	}
+
+	return string(re.Rune)
+}
+
+func longestLiteral(re *syntax.Regexp) string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return literalString(re)
+	case syntax.OpCapture:
+		return longestLiteral(re.Sub[0])
+	case syntax.OpPlus:
+		// at least one repetition
+		return longestLiteral(re.Sub[0])
+	case syntax.OpConcat:
+		longest := ""
+
+		for _, sub := range re.Sub {
+			if l := longestLiteral(sub); len(l) > len(longest) {
+				longest = l
+			}
+		}
+
+		return longest
+	default:
+		// alternations, optional parts, etc. don't have a required literal
+		return ""
+	}
+}
+
+func newMultiMatcher(matchers matchers) *multiMatcher {
+	m := &multiMatcher{
+		matchers: matchers,
+		delta:    [][256]int32{{}},
+		outputs:  [][]int{nil},
+		always:   []int{},
+	}
+
+	// build the trie, where -1 means there's no transition yet
+	for i := range m.delta[0] {
+		m.delta[0][i] = -1
+	}
+
+	for i, matcher := range matchers {
+		literal := requiredLiteral(matcher.pattern.String())
+		if len(literal) == 0 {
+			m.always = append(m.always, i)
+			continue
+		}
+
+		state := int32(0)
+
+		for j := 0; j < len(literal); j++ {
+			c := literal[j]
+
+			if m.delta[state][c] == -1 {
+				next := [256]int32{}
+				for k := range next {
+					next[k] = -1
+				}
+
+				m.delta = append(m.delta, next)
+				m.outputs = append(m.outputs, nil)
+				m.delta[state][c] = int32(len(m.delta) - 1)
+			}
+
+			state = m.delta[state][c]
+		}
+
+		m.outputs[state] = append(m.outputs[state], i)
+	}
+
+	// turn the trie into a complete automaton, visiting the states in breadth first order
+	fail := make([]int32, len(m.delta))
+	queue := []int32{}
+
+	for c := 0; c < 256; c++ {
+		if next := m.delta[0][c]; next == -1 {
+			m.delta[0][c] = 0
+		} else {
+			fail[next] = 0
+			queue = append(queue, next)
+		}
+	}
+
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+
+		m.outputs[state] = append(m.outputs[state], m.outputs[fail[state]]...)
+
+		for c := 0; c < 256; c++ {
+			next := m.delta[state][c]
+
+			if next == -1 {
+				m.delta[state][c] = m.delta[fail[state]][c]
+				continue
+			}
+
+			fail[next] = m.delta[fail[state]][c]
+			queue = append(queue, next)
+		}
+	}
+
+	return m
+}
+
+func (m *multiMatcher) match(r record) (matcher, bool) {
+	if m == nil || len(m.matchers) == 0 {
+		return matcher{}, false
+	}
+
+	// usually there are few matchers, and the candidates fit in this array, avoiding allocations
+	var small [4]uint64
+
+	words := (len(m.matchers) + 63) / 64
+
+	candidates := small[:]
+	if words > len(small) {
+		candidates = make([]uint64, words)
+	}
+
+	found := false
+
+	for _, i := range m.always {
+		candidates[i/64] |= 1 << (i % 64)
+		found = true
+	}
+
+	text := r.payload.ExtraMessage
+	state := int32(0)
+
+	for i := 0; i < len(text); i++ {
+		state = m.delta[state][text[i]]
+
+		for _, j := range m.outputs[state] {
+			candidates[j/64] |= 1 << (j % 64)
+			found = true
+		}
+	}
+
+	if !found {
+		return matcher{}, false
+	}
+
+	for i, matcher := range m.matchers {
+		if candidates[i/64]&(1<<(i%64)) == 0 {
+			continue
+		}
+
+		if matcher.match(r) {
+			return matcher, true
+		}
+	}
+
+	return matcher{}, false
+}
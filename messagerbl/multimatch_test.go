@@ -0,0 +1,185 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package messagerbl
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	parser "gitlab.com/lightmeter/controlcenter/pkg/postfix/logparser"
+)
+
+// the failed deliveries in the log files used on tests and benchmarks,
+// plus the ones the built-in matchers are known to match
+func failedDeliveriesCorpus(t testing.TB) []record {
+	files, err := filepath.Glob("../test_files/postfix_logs/individual_files/*.log")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("Could not find the log files: %v", err)
+	}
+
+	records := []record{}
+
+	add := func(line string) {
+		_, p, err := parser.Parse(line)
+		if !parser.IsRecoverableError(err) {
+			return
+		}
+
+		if s, ok := p.(parser.SmtpSentStatus); ok && s.Status != parser.SentStatus {
+			records = append(records, record{payload: s})
+		}
+	}
+
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		scanner := bufio.NewScanner(f)
+
+		for scanner.Scan() {
+			add(scanner.Text())
+		}
+
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, l := range []string{microsoftLine, proofpointLikeLine} {
+		add(l)
+	}
+
+	return records
+}
+
+func sequentialMatch(matchers matchers, r record) (matcher, bool) {
+	for _, m := range matchers {
+		if m.match(r) {
+			return m, true
+		}
+	}
+
+	return matcher{}, false
+}
+
+// matchers which look like the ones admins add for providers not supported by default
+func customMatchers(n int) matchers {
+	m := make(matchers, 0, n)
+
+	for i := 0; i < n; i++ {
+		m = append(m, matcher{
+			id:      fmt.Sprintf("custom_%d", i),
+			host:    fmt.Sprintf("Provider %d", i),
+			pattern: regexp.MustCompile(fmt.Sprintf(`Blocked by provider %d.*https:\/\/provider-%d\.example\.com\/delist`, i, i)),
+		})
+	}
+
+	return m
+}
+
+func TestRequiredLiteral(t *testing.T) {
+	Convey("Test Required Literal", t, func() {
+		So(requiredLiteral(`http:\/\/go\.microsoft\.com\/fwlink\/\?LinkID=526655`), ShouldEqual, `http://go.microsoft.com/fwlink/?LinkID=526655`)
+		So(requiredLiteral(`421.*https:\/\/support\.google\.com\/mail`), ShouldEqual, `https://support.google.com/mail`)
+		So(requiredLiteral(`refused to talk to me: 5.. .*\bBlocked - see https:\/\/(support|ipcheck)\.proofpoint\.com\/`), ShouldEqual, `refused to talk to me: 5`)
+		So(requiredLiteral(`(blocked)+ by`), ShouldEqual, `blocked`)
+		So(requiredLiteral(`\[TS03\]`), ShouldEqual, `[TS03]`)
+		So(requiredLiteral(`(blocked)? by`), ShouldEqual, ` by`)
+
+		// no literal required
+		So(requiredLiteral(`(?i)blocked using`), ShouldEqual, ``)
+		So(requiredLiteral(`blocked|banned`), ShouldEqual, ``)
+		So(requiredLiteral(`(blocked)?`), ShouldEqual, ``)
+		So(requiredLiteral(`a.*b`), ShouldEqual, ``)
+		So(requiredLiteral(`(unclosed`), ShouldEqual, ``)
+	})
+}
+
+func TestMultiMatcher(t *testing.T) {
+	Convey("Test Multi Matcher", t, func() {
+		corpus := failedDeliveriesCorpus(t)
+
+		So(len(corpus), ShouldBeGreaterThan, 2)
+
+		r := func(dsn, message string) record {
+			return record{payload: parser.SmtpSentStatus{Dsn: dsn, ExtraMessage: message}}
+		}
+
+		host := func(m *multiMatcher, r record) string {
+			matcher, ok := m.match(r)
+			if !ok {
+				return ""
+			}
+
+			return matcher.host
+		}
+
+		Convey("No matchers", func() {
+			So(host(newMultiMatcher(matchers{}), r("5.7.1", "Blocked using Trend Micro")), ShouldEqual, "")
+		})
+
+		Convey("The first matcher, in order, is used", func() {
+			m := newMultiMatcher(matchers{
+				{host: "First", dsn: "5.7.1", pattern: regexp.MustCompile(`some text`)},
+				{host: "Second", pattern: regexp.MustCompile(`text`)},
+				{host: "Third", pattern: regexp.MustCompile(`(?i)SOME`)},
+			})
+
+			So(host(m, r("5.7.1", "this is some text")), ShouldEqual, "First")
+			So(host(m, r("5.7.2", "this is some text")), ShouldEqual, "Second")
+			So(host(m, r("5.7.2", "this is SOME")), ShouldEqual, "Third")
+			So(host(m, r("5.7.2", "nothing here")), ShouldEqual, "")
+		})
+
+		Convey("Overlapping literals are all found", func() {
+			m := newMultiMatcher(matchers{
+				{host: "Long", pattern: regexp.MustCompile(`abcde`)},
+				{host: "Middle", pattern: regexp.MustCompile(`bcd`)},
+				{host: "Suffix", pattern: regexp.MustCompile(`cdx`)},
+			})
+
+			So(host(m, r("", "xabcdx")), ShouldEqual, "Middle")
+			So(host(m, r("", "xxcdxx")), ShouldEqual, "Suffix")
+			So(host(m, r("", "abcde")), ShouldEqual, "Long")
+		})
+
+		Convey("Many matchers", func() {
+			custom := customMatchers(300)
+			m := newMultiMatcher(custom)
+
+			So(host(m, r("5.0.0", "Blocked by provider 299, see https://provider-299.example.com/delist")), ShouldEqual, "Provider 299")
+			So(host(m, r("5.0.0", "Blocked by provider 0, see https://provider-0.example.com/delist")), ShouldEqual, "Provider 0")
+			So(host(m, r("5.0.0", "Blocked by provider 299, see https://provider-298.example.com/delist")), ShouldEqual, "")
+		})
+
+		Convey("Same results as checking the matchers one by one, on the corpus", func() {
+			all := append(append(matchers{}, defaultMatchers...), customMatchers(100)...)
+			m := newMultiMatcher(all)
+
+			matched := 0
+
+			for _, r := range corpus {
+				expected, expectedOk := sequentialMatch(all, r)
+				actual, ok := m.match(r)
+
+				So(ok, ShouldEqual, expectedOk)
+				So(actual.id, ShouldEqual, expected.id)
+
+				if ok {
+					matched++
+				}
+			}
+
+			So(matched, ShouldBeGreaterThan, 0)
+		})
+	})
+}
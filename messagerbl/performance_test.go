@@ -0,0 +1,58 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package messagerbl
+
+import (
+	"testing"
+)
+
+func benchmarkSequential(b *testing.B, matchers matchers) {
+	corpus := failedDeliveriesCorpus(b)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, r := range corpus {
+			_, _ = sequentialMatch(matchers, r)
+		}
+	}
+}
+
+func benchmarkMulti(b *testing.B, matchers matchers) {
+	corpus := failedDeliveriesCorpus(b)
+	m := newMultiMatcher(matchers)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, r := range corpus {
+			_, _ = m.match(r)
+		}
+	}
+}
+
+func BenchmarkSequentialDefaultMatchers(b *testing.B) {
+	benchmarkSequential(b, defaultMatchers)
+}
+
+func BenchmarkMultiDefaultMatchers(b *testing.B) {
+	benchmarkMulti(b, defaultMatchers)
+}
+
+func BenchmarkSequentialManyCustomMatchers(b *testing.B) {
+	benchmarkSequential(b, append(append(matchers{}, defaultMatchers...), customMatchers(100)...))
+}
+
+func BenchmarkMultiManyCustomMatchers(b *testing.B) {
+	benchmarkMulti(b, append(append(matchers{}, defaultMatchers...), customMatchers(100)...))
+}
+
+func BenchmarkBuildMultiMatcher(b *testing.B) {
+	all := append(append(matchers{}, defaultMatchers...), customMatchers(100)...)
+
+	for i := 0; i < b.N; i++ {
+		_ = newMultiMatcher(all)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"gitlab.com/lightmeter/controlcenter/settings/globalsettings"
 	"net"
 	"regexp"
+	"sync"
 	"time"
 )
 
@@ -27,6 +28,7 @@ type Publisher struct {
 }
 
 type matcher struct {
+	id      string
 	host    string
 	dsn     string
 	pattern *regexp.Regexp
@@ -64,8 +66,11 @@ type Detector struct {
 
 	nonDeliveredChan chan record
 	resultsChan      chan Results
-	matchers         matchers
 	runner.CancellableRunner
+
+	// the matchers can be changed at runtime, via the settings
+	matchersMutex sync.RWMutex
+	matchers      *multiMatcher
 }
 
 const (
@@ -81,7 +86,7 @@ func New(settings globalsettings.IPAddressGetter) *Detector {
 		IPAddressGetter:  settings,
 		nonDeliveredChan: make(chan record, MsgBufferSize),
 		resultsChan:      make(chan Results, MsgBufferSize),
-		matchers:         defaultMatchers,
+		matchers:         newMultiMatcher(defaultMatchers),
 	}
 
 	execute := func(done runner.DoneChan, cancel runner.CancelChan) {
@@ -118,7 +123,7 @@ func New(settings globalsettings.IPAddressGetter) *Detector {
 						return
 					}
 
-					result, matched := messageMatchesAnyHosts(d.IPAddressGetter, d.matchers, r)
+					result, matched := messageMatchesAnyHosts(d.IPAddressGetter, d.currentMatchers(), r)
 
 					if !matched {
 						break
@@ -142,35 +147,48 @@ func New(settings globalsettings.IPAddressGetter) *Detector {
 	return d
 }
 
+func (d *Detector) currentMatchers() *multiMatcher {
+	d.matchersMutex.RLock()
+	defer d.matchersMutex.RUnlock()
+
+	return d.matchers
+}
+
+func (d *Detector) updateMatchers(m matchers) {
+	// building the automaton can take a while, and is done before blocking the detector
+	multi := newMultiMatcher(m)
+
+	d.matchersMutex.Lock()
+	defer d.matchersMutex.Unlock()
+
+	d.matchers = multi
+}
+
 func (d *Detector) NewPublisher() *Publisher {
 	return &Publisher{nonDeliveredChan: d.nonDeliveredChan}
 }
 
-// TODO: this function should be optimized to lookup all patterns in a single shot.
-// One way to implement it is to convert all individual regexes into a single huge
-// automata. Ragel is a good candidate for it.
-func messageMatchesAnyHosts(settings globalsettings.IPAddressGetter, matchers matchers, r record) (Result, bool) {
-	for _, m := range matchers {
-		if m.match(r) {
-			ip := func() net.IP {
-				if r.header.ProcessIP != nil {
-					return r.header.ProcessIP
-				}
-
-				return settings.IPAddress(context.Background())
-			}()
+func messageMatchesAnyHosts(settings globalsettings.IPAddressGetter, matchers *multiMatcher, r record) (Result, bool) {
+	m, ok := matchers.match(r)
+	if !ok {
+		return Result{}, false
+	}
 
-			return Result{
-				Address: ip,
-				Host:    m.host,
-				Header:  r.header,
-				Payload: r.payload,
-				Time:    r.time,
-			}, true
+	ip := func() net.IP {
+		if r.header.ProcessIP != nil {
+			return r.header.ProcessIP
 		}
-	}
 
-	return Result{}, false
+		return settings.IPAddress(context.Background())
+	}()
+
+	return Result{
+		Address: ip,
+		Host:    m.host,
+		Header:  r.header,
+		Payload: r.payload,
+		Time:    r.time,
+	}, true
 }
 
 const ResultsCapacity = 128
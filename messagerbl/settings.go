@@ -0,0 +1,270 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package messagerbl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gitlab.com/lightmeter/controlcenter/metadata"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/settingsutil"
+)
+
+const SettingsKey = "message_rbl"
+
+const MaxCustomMatchers = 100
+
+var ErrNoSuchMatcher = errors.New(`No such message RBL matcher`)
+
+type Settings struct {
+	// Matchers created by the admin, checked after the built-in ones
+	CustomMatchers []MatcherDefinition `json:"custom_matchers"`
+
+	// ids of the built-in or custom matchers which must not be used
+	DisabledMatchers []string `json:"disabled_matchers"`
+
+	// A newer version of the built-in matchers than the shipped one, if any
+	BuiltInMatchers *BuiltInMatchers `json:"built_in_matchers,omitempty"`
+}
+
+func SetSettings(ctx context.Context, writer *metadata.AsyncWriter, settings Settings) error {
+	return settingsutil.Set[Settings](ctx, writer, settings, SettingsKey)
+}
+
+func GetSettings(ctx context.Context, reader metadata.Reader) (*Settings, error) {
+	settings, err := settingsutil.Get[Settings](ctx, reader, SettingsKey)
+	if err != nil && errors.Is(err, metadata.ErrNoSuchKey) {
+		return &Settings{}, nil
+	}
+
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return settings, nil
+}
+
+// builtIn returns the most recent version of the built-in matchers,
+// as the shipped one might have been updated by a new release
+func (s Settings) builtIn() BuiltInMatchers {
+	if s.BuiltInMatchers != nil && s.BuiltInMatchers.Version > DefaultBuiltInMatchers.Version {
+		return *s.BuiltInMatchers
+	}
+
+	return DefaultBuiltInMatchers
+}
+
+func (s Settings) disabled() map[string]struct{} {
+	disabled := map[string]struct{}{}
+
+	for _, id := range s.DisabledMatchers {
+		disabled[id] = struct{}{}
+	}
+
+	return disabled
+}
+
+func (s Settings) definitions() []MatcherDefinition {
+	return append(append([]MatcherDefinition{}, s.builtIn().Matchers...), s.CustomMatchers...)
+}
+
+func (s Settings) matchers() (matchers, error) {
+	return compileMatchers(s.definitions(), s.disabled())
+}
+
+type MatcherStatus struct {
+	MatcherDefinition
+	BuiltIn bool `json:"built_in"`
+	Enabled bool `json:"enabled"`
+}
+
+type MatchersList struct {
+	BuiltInVersion int             `json:"built_in_version"`
+	Matchers       []MatcherStatus `json:"matchers"`
+}
+
+func (s Settings) list() MatchersList {
+	disabled := s.disabled()
+	builtIn := s.builtIn()
+
+	list := MatchersList{BuiltInVersion: builtIn.Version, Matchers: []MatcherStatus{}}
+
+	add := func(definitions []MatcherDefinition, isBuiltIn bool) {
+		for _, d := range definitions {
+			_, isDisabled := disabled[d.ID]
+			list.Matchers = append(list.Matchers, MatcherStatus{MatcherDefinition: d, BuiltIn: isBuiltIn, Enabled: !isDisabled})
+		}
+	}
+
+	add(builtIn.Matchers, true)
+	add(s.CustomMatchers, false)
+
+	return list
+}
+
+type Manager interface {
+	Matchers(ctx context.Context) (MatchersList, error)
+	AddMatcher(ctx context.Context, d MatcherDefinition) error
+	DeleteMatcher(ctx context.Context, id string) error
+	EnableMatcher(ctx context.Context, id string, enabled bool) error
+	UpdateBuiltInMatchers(ctx context.Context, b BuiltInMatchers) error
+}
+
+// MatchersManager changes the matchers in the settings, applying them to the detector
+type MatchersManager struct {
+	detector *Detector
+	writer   *metadata.AsyncWriter
+	reader   metadata.Reader
+}
+
+func NewMatchersManager(detector *Detector, writer *metadata.AsyncWriter, reader metadata.Reader) *MatchersManager {
+	return &MatchersManager{detector: detector, writer: writer, reader: reader}
+}
+
+// UpdateDetectorFromSettings makes the detector use the matchers stored in the settings
+func (m *MatchersManager) UpdateDetectorFromSettings(ctx context.Context) error {
+	settings, err := GetSettings(ctx, m.reader)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	matchers, err := settings.matchers()
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	m.detector.updateMatchers(matchers)
+
+	return nil
+}
+
+func (m *MatchersManager) Matchers(ctx context.Context) (MatchersList, error) {
+	settings, err := GetSettings(ctx, m.reader)
+	if err != nil {
+		return MatchersList{}, errorutil.Wrap(err)
+	}
+
+	return settings.list(), nil
+}
+
+func (m *MatchersManager) update(ctx context.Context, change func(*Settings) error) error {
+	settings, err := GetSettings(ctx, m.reader)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if err := change(settings); err != nil {
+		return err
+	}
+
+	matchers, err := settings.matchers()
+	if err != nil {
+		return err
+	}
+
+	if err := SetSettings(ctx, m.writer, *settings); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	m.detector.updateMatchers(matchers)
+
+	return nil
+}
+
+// AddMatcher adds a custom matcher, or replaces the custom matcher with the same id
+func (m *MatchersManager) AddMatcher(ctx context.Context, d MatcherDefinition) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+
+	return m.update(ctx, func(s *Settings) error {
+		for i, c := range s.CustomMatchers {
+			if c.ID == d.ID {
+				s.CustomMatchers[i] = d
+				return nil
+			}
+		}
+
+		if len(s.CustomMatchers) >= MaxCustomMatchers {
+			return fmt.Errorf("%w: at most %d custom matchers are supported", ErrInvalidMatcher, MaxCustomMatchers)
+		}
+
+		s.CustomMatchers = append(s.CustomMatchers, d)
+
+		return nil
+	})
+}
+
+// DeleteMatcher deletes a custom matcher. Built-in ones can only be disabled
+func (m *MatchersManager) DeleteMatcher(ctx context.Context, id string) error {
+	return m.update(ctx, func(s *Settings) error {
+		for i, c := range s.CustomMatchers {
+			if c.ID == id {
+				s.CustomMatchers = append(s.CustomMatchers[:i], s.CustomMatchers[i+1:]...)
+				s.DisabledMatchers = removeString(s.DisabledMatchers, id)
+
+				return nil
+			}
+		}
+
+		return ErrNoSuchMatcher
+	})
+}
+
+func (m *MatchersManager) EnableMatcher(ctx context.Context, id string, enabled bool) error {
+	return m.update(ctx, func(s *Settings) error {
+		found := false
+
+		for _, d := range s.definitions() {
+			if d.ID == id {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return ErrNoSuchMatcher
+		}
+
+		s.DisabledMatchers = removeString(s.DisabledMatchers, id)
+
+		if !enabled {
+			s.DisabledMatchers = append(s.DisabledMatchers, id)
+		}
+
+		return nil
+	})
+}
+
+// UpdateBuiltInMatchers replaces the built-in matchers by a newer version of them
+func (m *MatchersManager) UpdateBuiltInMatchers(ctx context.Context, b BuiltInMatchers) error {
+	if err := b.Validate(); err != nil {
+		return err
+	}
+
+	return m.update(ctx, func(s *Settings) error {
+		if current := s.builtIn(); b.Version <= current.Version {
+			return fmt.Errorf("%w: version %d is not newer than the current one, %d", ErrInvalidMatcher, b.Version, current.Version)
+		}
+
+		s.BuiltInMatchers = &b
+
+		return nil
+	})
+}
+
+func removeString(l []string, s string) []string {
+	result := []string{}
+
+	for _, v := range l {
+		if v != s {
+			result = append(result, v)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,200 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package messagerbl
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3"
+	"gitlab.com/lightmeter/controlcenter/metadata"
+	parser "gitlab.com/lightmeter/controlcenter/pkg/postfix/logparser"
+	"gitlab.com/lightmeter/controlcenter/pkg/runner"
+	"gitlab.com/lightmeter/controlcenter/util/testutil"
+)
+
+func init() {
+	lmsqlite3.Initialize(lmsqlite3.Options{})
+}
+
+const (
+	proofpointLikeLine = `Jan 22 06:01:00 smtpnode51 postfix-135.97.192.135/smtp[5743]: 4B0D5410DE: to=<h-d66579f3@h-83e81c.com>, relay=h-50f3c4ccac5be32ede8[38.170.83.23]:25, delay=2000, delays=1999/0.13/1.1/0, dsn=4.7.0, status=deferred (host h-50f3c4ccac5be32ede8[38.170.83.23] refused to talk to me: 550 5.7.0 Blocked - see https://h-da21bcc5400cebb4aa45f2/h-fe4ec82fb61b5c4c?ip=135.97.192.135)`
+	microsoftLine      = `Jan 10 00:00:56 node postfix/smtp[12357]: 375593D395: to=<recipient@example.com>, relay=relay.example.com[254.112.150.90]:25, delay=0.86, delays=0.1/0/0.71/0.05, dsn=5.7.606, status=bounced (host [254.112.150.90] said: 550 5.7.606 Access denied, banned sending IP [239.58.50.50]. To request removal from this list please visit https://sender.office.com/ and follow the directions. For more information please go to  http://go.microsoft.com/fwlink/?LinkID=526655 (AS16012609) (in reply to RCPT TO command))`
+	sentLine           = `Jan 20 11:03:18 server postfix/smtp[30639]: 2847EE008E: to=<info@test2.com>, relay=mail.domain.test[11.22.33.44]:25, delay=6.3, delays=0.04/0.01/6/0.19, dsn=2.1.5, status=sent (250 2.1.5 Ok)`
+)
+
+func TestMatchers(t *testing.T) {
+	Convey("Test Matchers", t, func() {
+		conn, closeConn := testutil.TempDBConnectionMigrated(t, "master")
+		defer closeConn()
+
+		m, err := metadata.NewHandler(conn)
+		So(err, ShouldBeNil)
+
+		writeRunner := metadata.NewSerialWriteRunner(m)
+		done, cancel := runner.Run(writeRunner)
+
+		defer func() { cancel(); So(done(), ShouldBeNil) }()
+
+		settings := &fakeSettings{ip: net.ParseIP("127.0.0.2")}
+
+		detector := New(settings)
+
+		manager := NewMatchersManager(detector, writeRunner.Writer(), m.Reader)
+
+		ctx := context.Background()
+
+		matchHost := func(d *Detector, line string) string {
+			_, p, err := parser.Parse(line)
+			So(err, ShouldBeNil)
+
+			result, matched := messageMatchesAnyHosts(settings, d.currentMatchers(), record{payload: p.(parser.SmtpSentStatus)})
+			if !matched {
+				return ""
+			}
+
+			return result.Host
+		}
+
+		customMatcher := MatcherDefinition{
+			ID:      "custom",
+			Host:    "Some Provider",
+			DSN:     "4.7.0",
+			Pattern: `refused to talk to me: 550 5\.7\.0 Blocked - see https://h-da21bcc5400cebb4aa45f2/`,
+			Samples: []string{proofpointLikeLine},
+		}
+
+		Convey("The built-in matchers are used by default", func() {
+			list, err := manager.Matchers(ctx)
+			So(err, ShouldBeNil)
+			So(list.BuiltInVersion, ShouldEqual, 1)
+			So(len(list.Matchers), ShouldEqual, 16)
+			So(list.Matchers[0], ShouldResemble, MatcherStatus{
+				MatcherDefinition: MatcherDefinition{
+					ID:      "microsoft_1",
+					Host:    "Microsoft",
+					DSN:     "5.7.606",
+					Pattern: `http:\/\/go\.microsoft\.com\/fwlink\/\?LinkID=526655`,
+				},
+				BuiltIn: true,
+				Enabled: true,
+			})
+
+			So(manager.UpdateDetectorFromSettings(ctx), ShouldBeNil)
+			So(matchHost(detector, microsoftLine), ShouldEqual, "Microsoft")
+			So(matchHost(detector, proofpointLikeLine), ShouldEqual, "")
+		})
+
+		Convey("Invalid matchers", func() {
+			invalid := func(change func(*MatcherDefinition)) error {
+				d := customMatcher
+				change(&d)
+				return manager.AddMatcher(ctx, d)
+			}
+
+			So(invalid(func(d *MatcherDefinition) { d.ID = "" }), ShouldWrap, ErrInvalidMatcher)
+			So(invalid(func(d *MatcherDefinition) { d.Host = " " }), ShouldWrap, ErrInvalidMatcher)
+			So(invalid(func(d *MatcherDefinition) { d.Pattern = `(unclosed` }), ShouldWrap, ErrInvalidMatcher)
+			So(invalid(func(d *MatcherDefinition) { d.DSN = "5.7.1" }), ShouldWrap, ErrInvalidMatcher)
+			So(invalid(func(d *MatcherDefinition) { d.Samples = []string{sentLine} }), ShouldWrap, ErrInvalidMatcher)
+
+			// same id as a built-in matcher
+			So(invalid(func(d *MatcherDefinition) { d.ID = "microsoft_1" }), ShouldWrap, ErrInvalidMatcher)
+
+			list, err := manager.Matchers(ctx)
+			So(err, ShouldBeNil)
+			So(len(list.Matchers), ShouldEqual, 16)
+		})
+
+		Convey("Add a custom matcher", func() {
+			So(manager.AddMatcher(ctx, customMatcher), ShouldBeNil)
+
+			So(matchHost(detector, proofpointLikeLine), ShouldEqual, "Some Provider")
+
+			list, err := manager.Matchers(ctx)
+			So(err, ShouldBeNil)
+			So(len(list.Matchers), ShouldEqual, 17)
+			So(list.Matchers[16], ShouldResemble, MatcherStatus{MatcherDefinition: customMatcher, Enabled: true})
+
+			Convey("The matchers are kept in the settings", func() {
+				otherDetector := New(settings)
+				So(NewMatchersManager(otherDetector, writeRunner.Writer(), m.Reader).UpdateDetectorFromSettings(ctx), ShouldBeNil)
+				So(matchHost(otherDetector, proofpointLikeLine), ShouldEqual, "Some Provider")
+			})
+
+			Convey("Replace it", func() {
+				changed := customMatcher
+				changed.Host = "Another Provider"
+
+				So(manager.AddMatcher(ctx, changed), ShouldBeNil)
+				So(matchHost(detector, proofpointLikeLine), ShouldEqual, "Another Provider")
+
+				list, err := manager.Matchers(ctx)
+				So(err, ShouldBeNil)
+				So(len(list.Matchers), ShouldEqual, 17)
+			})
+
+			Convey("Disable and enable it", func() {
+				So(manager.EnableMatcher(ctx, "custom", false), ShouldBeNil)
+				So(matchHost(detector, proofpointLikeLine), ShouldEqual, "")
+
+				So(manager.EnableMatcher(ctx, "custom", true), ShouldBeNil)
+				So(matchHost(detector, proofpointLikeLine), ShouldEqual, "Some Provider")
+			})
+
+			Convey("Delete it", func() {
+				So(manager.DeleteMatcher(ctx, "custom"), ShouldBeNil)
+				So(matchHost(detector, proofpointLikeLine), ShouldEqual, "")
+				So(manager.DeleteMatcher(ctx, "custom"), ShouldEqual, ErrNoSuchMatcher)
+			})
+		})
+
+		Convey("Built-in matchers can be disabled, but not deleted", func() {
+			So(manager.EnableMatcher(ctx, "microsoft_1", false), ShouldBeNil)
+			So(matchHost(detector, microsoftLine), ShouldEqual, "")
+
+			list, err := manager.Matchers(ctx)
+			So(err, ShouldBeNil)
+			So(list.Matchers[0].Enabled, ShouldBeFalse)
+
+			So(manager.DeleteMatcher(ctx, "microsoft_1"), ShouldEqual, ErrNoSuchMatcher)
+			So(manager.EnableMatcher(ctx, "unknown", false), ShouldEqual, ErrNoSuchMatcher)
+		})
+
+		Convey("Update the built-in matchers", func() {
+			newer := BuiltInMatchers{Version: 2, Matchers: []MatcherDefinition{
+				{ID: "new_provider", Host: "New Provider", Pattern: `Blocked - see https://h-da21bcc5400cebb4aa45f2/`, Samples: []string{proofpointLikeLine}},
+			}}
+
+			So(manager.UpdateBuiltInMatchers(ctx, newer), ShouldBeNil)
+
+			So(matchHost(detector, proofpointLikeLine), ShouldEqual, "New Provider")
+			So(matchHost(detector, microsoftLine), ShouldEqual, "")
+
+			list, err := manager.Matchers(ctx)
+			So(err, ShouldBeNil)
+			So(list.BuiltInVersion, ShouldEqual, 2)
+			So(len(list.Matchers), ShouldEqual, 1)
+
+			// older or same versions are refused
+			So(manager.UpdateBuiltInMatchers(ctx, DefaultBuiltInMatchers), ShouldWrap, ErrInvalidMatcher)
+			So(manager.UpdateBuiltInMatchers(ctx, newer), ShouldWrap, ErrInvalidMatcher)
+		})
+
+		Convey("Test a matcher against some log lines", func() {
+			results, err := TestMatcher(customMatcher, []string{microsoftLine, proofpointLikeLine})
+			So(err, ShouldBeNil)
+			So(results, ShouldResemble, []bool{false, true})
+
+			_, err = TestMatcher(customMatcher, []string{sentLine})
+			So(err, ShouldWrap, ErrInvalidMatcher)
+
+			_, err = TestMatcher(MatcherDefinition{ID: "test", Host: "Host", Pattern: `(`}, []string{microsoftLine})
+			So(err, ShouldWrap, ErrInvalidMatcher)
+		})
+	})
+}
@@ -0,0 +1,23 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"gitlab.com/lightmeter/controlcenter/pkg/metrics"
+)
+
+var sentNotifications = metrics.NewCounter("lightmeter_notifications_total",
+	"Notifications sent, by notifier and result (success or failure)", "notifier", "result")
+
+// CountSendResult is called by the notifiers whenever they try to send a notification,
+// ignoring the ones not sent because the notifier is disabled
+func CountSendResult(notifier string, err error) {
+	if err != nil {
+		sentNotifications.Inc(notifier, "failure")
+		return
+	}
+
+	sentNotifications.Inc(notifier, "success")
+}
@@ -526,13 +526,65 @@ func (m *Notifier) Notify(n core.Notification, translator translator.Translator)
 		return nil
 	}
 
-	if err := sendOnClient(*settings, onClient); err != nil {
+	err = sendOnClient(*settings, onClient)
+
+	core.CountSendResult(SettingsKey, err)
+
+	if err != nil {
 		return errorutil.Wrap(err)
 	}
 
 	return nil
 }
 
+// SendMessage sends a plain text message to a single recipient, using the same server and sender as the notifications,
+// but ignoring whether they are enabled
+func SendMessage(settings Settings, recipient, subject, body string, now time.Time) error {
+	for _, v := range []string{settings.Sender, recipient, subject} {
+		if err := validateEmail(v); err != nil {
+			return errorutil.Wrap(err)
+		}
+	}
+
+	headers := []string{
+		"To: " + recipient,
+		"From: " + settings.Sender,
+		"Date: " + now.Format(time.RFC1123Z),
+		"Subject: " + subject,
+		fmt.Sprintf("User-Agent: Lightmeter ControlCenter %v (%v)", version.Version, version.Commit),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"Content-Transfer-Encoding: 8bit",
+	}
+
+	payload := strings.Join(headers, "\r\n") + "\r\n\r\n" + strings.ReplaceAll(body, "\n", "\r\n") + "\r\n"
+
+	return sendOnClient(settings, func(c *smtp.Client) error {
+		if err := c.Mail(settings.Sender, nil); err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		if err := c.Rcpt(recipient); err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		w, err := c.Data()
+		if err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		if _, err := io.Copy(w, strings.NewReader(payload)); err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		if err := w.Close(); err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		return nil
+	})
+}
+
 func (*Notifier) ValidateSettings(s core.Settings) error {
 	settings, ok := s.(Settings)
 
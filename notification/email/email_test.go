@@ -180,6 +180,23 @@ Version: 1.0.0
 
 				So(strings.ReplaceAll(string(content), "\r\n", "\n"), ShouldEqual, expectedContent)
 			})
+
+			Convey("Send a message to a single recipient", func() {
+				err := SendMessage(settings, "someone@example.com", "Some subject", "First line\nSecond line", clock.Now())
+				So(err, ShouldBeNil)
+				So(len(backend.Messages), ShouldEqual, 1)
+
+				msg := backend.Messages[0]
+
+				So(msg.Header.Get("From"), ShouldEqual, "sender@example.com")
+				So(msg.Header.Get("To"), ShouldEqual, "someone@example.com")
+				So(msg.Header.Get("Subject"), ShouldEqual, "Some subject")
+				So(msg.Header.Get("Content-Type"), ShouldEqual, "text/plain; charset=UTF-8")
+
+				content, err := ioutil.ReadAll(msg.Body)
+				So(err, ShouldBeNil)
+				So(string(content), ShouldEqual, "First line\r\nSecond line\r\n")
+			})
 		})
 	})
 }
@@ -143,6 +143,9 @@ func tryToNotifyMessage(m *Notifier, message core.Message) error {
 	poster := m.MessagePosterBuilder(client)
 
 	_, _, err = poster.PostMessage(settings.Channel, slack.MsgOptionText(message.Description, false), slack.MsgOptionAsUser(true))
+
+	core.CountSendResult(SettingsKey, err)
+
 	if err != nil {
 		return errorutil.Wrap(err)
 	}
@@ -0,0 +1,444 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gitlab.com/lightmeter/controlcenter/i18n/translator"
+	"gitlab.com/lightmeter/controlcenter/metadata"
+	"gitlab.com/lightmeter/controlcenter/notification/core"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/settingsutil"
+	"gitlab.com/lightmeter/controlcenter/util/stringutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+	"gitlab.com/lightmeter/controlcenter/version"
+)
+
+const SettingsKey = "messenger_webhook"
+
+const (
+	// SignatureHeader has the hex encoded HMAC-SHA256 of the timestamp, a dot and the request body,
+	// using the configured secret as key, prefixed by "sha256="
+	SignatureHeader = "X-Lightmeter-Signature"
+
+	// TimestampHeader has the unix time when the request was signed,
+	// allowing the receiver to reject old (replayed) requests
+	TimestampHeader = "X-Lightmeter-Timestamp"
+)
+
+var (
+	ErrInvalidURL    = errors.New(`Invalid webhook URL`)
+	ErrNoURLs        = errors.New(`No webhook URL`)
+	ErrInvalidHeader = errors.New(`Invalid webhook header`)
+	ErrRejected      = errors.New(`Webhook request rejected`)
+)
+
+type Settings struct {
+	Enabled bool     `json:"enabled"`
+	URLs    []string `json:"urls"`
+
+	// Used to sign the requests. They are not signed if empty
+	Secret stringutil.Sensitive `json:"secret,omitempty"`
+
+	// Sent in every request, for instance for authentication
+	Headers map[string]string `json:"headers"`
+}
+
+// Payload is the body of the requests, a notification already translated
+type Payload struct {
+	InsightID   int64             `json:"insight_id"`
+	Title       string            `json:"title"`
+	Description string            `json:"description"`
+	Category    string            `json:"category"`
+	Priority    string            `json:"priority"`
+	Metadata    map[string]string `json:"metadata"`
+	Time        time.Time         `json:"time"`
+	Test        bool              `json:"test"`
+}
+
+// RetryPolicy controls how failed requests are retried, waiting
+// twice as long as the previous time on each new attempt
+type RetryPolicy struct {
+	Attempts       int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:       5,
+	InitialBackoff: time.Second * 2,
+	MaxBackoff:     time.Minute,
+}
+
+const requestTimeout = time.Second * 10
+
+// MaxDeliveriesInFlight is how many notifications can be being delivered at the same time.
+// Further notifications wait until one of them finishes
+const MaxDeliveriesInFlight = 16
+
+type SettingsFetcher func() (*Settings, error)
+
+type Notifier struct {
+	fetchSettings SettingsFetcher
+	policy        core.Policy
+	client        *http.Client
+	clock         timeutil.Clock
+	retry         RetryPolicy
+
+	// the notifications are delivered in the background, as retrying can take long
+	deliveries sync.WaitGroup
+
+	// a semaphore bounding the deliveries in the background
+	inFlight chan struct{}
+
+	// cancelled when the notifier is closed, stopping the requests and the retries
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type disabledFromSettingsPolicy struct {
+	settingsFetcher SettingsFetcher
+}
+
+func (p *disabledFromSettingsPolicy) Reject(core.Notification) (bool, error) {
+	s, err := p.settingsFetcher()
+	if err != nil && errors.Is(err, metadata.ErrNoSuchKey) {
+		return true, nil
+	}
+
+	if err != nil {
+		return true, errorutil.Wrap(err)
+	}
+
+	return !s.Enabled, nil
+}
+
+func New(policy core.Policy, reader metadata.Reader) *Notifier {
+	fetchSettings := func() (*Settings, error) {
+		return GetSettings(context.Background(), reader)
+	}
+
+	policies := core.Policies{policy, &disabledFromSettingsPolicy{settingsFetcher: fetchSettings}}
+
+	return NewWithCustomSettingsFetcher(policies, fetchSettings)
+}
+
+func NewWithCustomSettingsFetcher(policy core.Policy, settingsFetcher SettingsFetcher) *Notifier {
+	return newWithCustomOptions(policy, settingsFetcher, &timeutil.RealClock{}, DefaultRetryPolicy)
+}
+
+func newWithCustomOptions(policy core.Policy, settingsFetcher SettingsFetcher, clock timeutil.Clock, retry RetryPolicy) *Notifier {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Notifier{
+		fetchSettings: settingsFetcher,
+		policy:        policy,
+		client:        &http.Client{Timeout: requestTimeout},
+		clock:         clock,
+		retry:         retry,
+		inFlight:      make(chan struct{}, MaxDeliveriesInFlight),
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+}
+
+// Close interrupts the notifications being delivered, without retrying them, and waits for them to finish
+func (m *Notifier) Close() error {
+	m.cancel()
+
+	m.deliveries.Wait()
+
+	return nil
+}
+
+func isTokenChar(r rune) bool {
+	return r > ' ' && r < 127 && !strings.ContainsRune(`()<>@,;:\"/[]?={}`, r)
+}
+
+func checkHeader(name, value string) error {
+	if len(name) == 0 || strings.IndexFunc(name, func(r rune) bool { return !isTokenChar(r) }) != -1 {
+		return fmt.Errorf("%w: name %q", ErrInvalidHeader, name)
+	}
+
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("%w: value of %q", ErrInvalidHeader, name)
+	}
+
+	return nil
+}
+
+// CheckSettings validates the settings without sending anything
+func CheckSettings(s Settings) error {
+	if s.Enabled && len(s.URLs) == 0 {
+		return ErrNoURLs
+	}
+
+	for _, rawURL := range s.URLs {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidURL, err)
+		}
+
+		if (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return fmt.Errorf("%w: %v", ErrInvalidURL, rawURL)
+		}
+	}
+
+	for k, v := range s.Headers {
+		if err := checkHeader(k, v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Sign returns the value of the SignatureHeader for a request body signed at the given timestamp
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+
+	// writing to a hash never fails
+	_, _ = mac.Write([]byte(timestamp))
+	_, _ = mac.Write([]byte("."))
+	_, _ = mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// errTemporary is returned by a failed request worth retrying
+type errTemporary struct {
+	err error
+}
+
+func (e *errTemporary) Error() string {
+	return e.err.Error()
+}
+
+func (e *errTemporary) Unwrap() error {
+	return e.err
+}
+
+func (m *Notifier) post(settings Settings, rawURL string, body []byte) error {
+	ctx, cancel := context.WithTimeout(m.ctx, requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	for k, v := range settings.Headers {
+		req.Header.Set(k, v)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", fmt.Sprintf("Lightmeter ControlCenter %v (%v)", version.Version, version.Commit))
+
+	if settings.Secret != nil && len(*settings.Secret) > 0 {
+		timestamp := strconv.FormatInt(m.clock.Now().Unix(), 10)
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(SignatureHeader, Sign(*settings.Secret, timestamp, body))
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return &errTemporary{err: errorutil.Wrap(err)}
+	}
+
+	// the response is ignored, but reading it allows the connection to be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if err := resp.Body.Close(); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	err = fmt.Errorf("%w by %v with status %v", ErrRejected, rawURL, resp.StatusCode)
+
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout {
+		return &errTemporary{err: err}
+	}
+
+	return err
+}
+
+// deliver posts the body, retrying on temporary errors as the retry policy allows
+func (m *Notifier) deliver(settings Settings, rawURL string, body []byte, retry RetryPolicy) error {
+	backoff := retry.InitialBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := m.post(settings, rawURL, body)
+
+		var temporary *errTemporary
+
+		if err == nil || !errors.As(err, &temporary) || attempt >= retry.Attempts || m.ctx.Err() != nil {
+			return err
+		}
+
+		log.Warn().Msgf("Webhook request to %v failed on attempt %v, retrying in %v: %v", rawURL, attempt, backoff, err)
+
+		if !m.wait(backoff) {
+			return err
+		}
+
+		backoff *= 2
+
+		if backoff > retry.MaxBackoff {
+			backoff = retry.MaxBackoff
+		}
+	}
+}
+
+// wait sleeps for the given duration, returning false if the notifier is closed meanwhile
+func (m *Notifier) wait(d time.Duration) bool {
+	slept := make(chan struct{})
+
+	go func() {
+		m.clock.Sleep(d)
+		close(slept)
+	}()
+
+	select {
+	case <-slept:
+		return true
+	case <-m.ctx.Done():
+		return false
+	}
+}
+
+func buildPayload(id int64, message core.Message, now time.Time, test bool) Payload {
+	return Payload{
+		InsightID:   id,
+		Title:       message.Title,
+		Description: message.Description,
+		Category:    message.Metadata["category"],
+		Priority:    message.Metadata["priority"],
+		Metadata:    message.Metadata,
+		Time:        now,
+		Test:        test,
+	}
+}
+
+// implement Notifier
+func (m *Notifier) Notify(n core.Notification, translator translator.Translator) error {
+	reject, err := m.policy.Reject(n)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if reject {
+		return nil
+	}
+
+	settings, err := m.fetchSettings()
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	message, err := core.TranslateNotification(n, translator)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	body, err := json.Marshal(buildPayload(n.ID, message, m.clock.Now(), false))
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	for _, u := range settings.URLs {
+		m.inFlight <- struct{}{}
+
+		m.deliveries.Add(1)
+
+		go func(u string) {
+			defer func() {
+				<-m.inFlight
+				m.deliveries.Done()
+			}()
+
+			err := m.deliver(*settings, u, body, m.retry)
+
+			core.CountSendResult(SettingsKey, err)
+
+			if err != nil {
+				log.Warn().Msgf("Error notifying insight %v via webhook %v: %v", n.ID, u, err)
+			}
+		}(u)
+	}
+
+	return nil
+}
+
+// SendTestNotification posts a test notification to all the URLs in the settings,
+// without retrying, even if they are disabled
+func (m *Notifier) SendTestNotification(settings Settings) error {
+	if len(settings.URLs) == 0 {
+		return ErrNoURLs
+	}
+
+	message := core.Message{
+		Title:       "Test notification",
+		Description: "Lightmeter ControlCenter successfully connected to the webhook!",
+		Metadata:    map[string]string{},
+	}
+
+	body, err := json.Marshal(buildPayload(0, message, m.clock.Now(), true))
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	for _, u := range settings.URLs {
+		if err := m.deliver(settings, u, body, RetryPolicy{Attempts: 1}); err != nil {
+			return errorutil.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+func (m *Notifier) ValidateSettings(s core.Settings) error {
+	settings, ok := s.(Settings)
+
+	if !ok {
+		return core.ErrInvalidSettings
+	}
+
+	if err := CheckSettings(settings); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if err := m.SendTestNotification(settings); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func SetSettings(ctx context.Context, writer *metadata.AsyncWriter, settings Settings) error {
+	return settingsutil.Set[Settings](ctx, writer, settings, SettingsKey)
+}
+
+func GetSettings(ctx context.Context, reader metadata.Reader) (*Settings, error) {
+	return settingsutil.Get[Settings](ctx, reader, SettingsKey)
+}
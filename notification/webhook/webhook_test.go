@@ -0,0 +1,325 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gitlab.com/lightmeter/controlcenter/i18n/translator"
+	"gitlab.com/lightmeter/controlcenter/metadata"
+	"gitlab.com/lightmeter/controlcenter/notification/core"
+	"gitlab.com/lightmeter/controlcenter/util/stringutil"
+	"gitlab.com/lightmeter/controlcenter/util/testutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message/catalog"
+)
+
+type fakeContentComponent string
+
+func (c fakeContentComponent) String() string {
+	return string(c)
+}
+
+func (c fakeContentComponent) Args() []interface{} {
+	return nil
+}
+
+func (c fakeContentComponent) TplString() string {
+	return c.String()
+}
+
+type fakeContent struct{}
+
+func (c fakeContent) Title() core.ContentComponent {
+	return fakeContentComponent("some fake content")
+}
+
+func (c fakeContent) Description() core.ContentComponent {
+	return fakeContentComponent("some fake description")
+}
+
+func (c fakeContent) Metadata() core.ContentMetadata {
+	return core.ContentMetadata{
+		"category": fakeContentComponent("local"),
+		"priority": fakeContentComponent("bad"),
+	}
+}
+
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+type fakeReceiver struct {
+	sync.Mutex
+	requests []receivedRequest
+
+	// status codes to answer, in order, 200 when there are no more
+	statuses []int
+}
+
+func (f *fakeReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+
+	f.requests = append(f.requests, receivedRequest{header: r.Header, body: body})
+
+	status := http.StatusOK
+
+	if len(f.statuses) > 0 {
+		status = f.statuses[0]
+		f.statuses = f.statuses[1:]
+	}
+
+	w.WriteHeader(status)
+}
+
+func (f *fakeReceiver) countRequests() int {
+	f.Lock()
+	defer f.Unlock()
+
+	return len(f.requests)
+}
+
+// blockingClock never wakes up from sleeping until released
+type blockingClock struct {
+	*timeutil.FakeClock
+	release chan struct{}
+}
+
+func (c *blockingClock) Sleep(time.Duration) {
+	<-c.release
+}
+
+func waitFor(cond func() bool) {
+	for !cond() {
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	Convey("Webhook notifier", t, func() {
+		clock := &timeutil.FakeClock{Time: testutil.MustParseTime(`2000-01-01 00:00:00 +0000`)}
+
+		translators := translator.New(catalog.NewBuilder())
+		translator := translators.Translator(language.MustParse(`en`))
+
+		receiver := &fakeReceiver{}
+
+		s := httptest.NewServer(receiver)
+		defer s.Close()
+
+		settings := &Settings{
+			Enabled: true,
+			URLs:    []string{s.URL + "/hook"},
+			Secret:  stringutil.MakeSensitive("super_secret"),
+			Headers: map[string]string{"Authorization": "Bearer some_token"},
+		}
+
+		settingsFetcher := func() (*Settings, error) {
+			if settings == nil {
+				return nil, metadata.ErrNoSuchKey
+			}
+
+			return settings, nil
+		}
+
+		retry := RetryPolicy{Attempts: 3, InitialBackoff: time.Second, MaxBackoff: time.Second * 5}
+
+		policies := core.Policies{core.PassPolicy, &disabledFromSettingsPolicy{settingsFetcher: settingsFetcher}}
+
+		notifier := newWithCustomOptions(policies, settingsFetcher, clock, retry)
+
+		notify := func() {
+			So(notifier.Notify(core.Notification{ID: 42, Content: fakeContent{}}, translator), ShouldBeNil)
+			notifier.deliveries.Wait()
+		}
+
+		Convey("Notify", func() {
+			notify()
+
+			So(len(receiver.requests), ShouldEqual, 1)
+
+			r := receiver.requests[0]
+
+			So(r.header.Get("Content-Type"), ShouldEqual, "application/json")
+			So(r.header.Get("Authorization"), ShouldEqual, "Bearer some_token")
+			So(r.header.Get(TimestampHeader), ShouldEqual, "946684800")
+			So(r.header.Get(SignatureHeader), ShouldEqual, Sign("super_secret", "946684800", r.body))
+
+			var payload Payload
+			So(json.Unmarshal(r.body, &payload), ShouldBeNil)
+
+			So(payload, ShouldResemble, Payload{
+				InsightID:   42,
+				Title:       "some fake content",
+				Description: "some fake description",
+				Category:    "local",
+				Priority:    "bad",
+				Metadata:    map[string]string{"category": "local", "priority": "bad"},
+				Time:        testutil.MustParseTime(`2000-01-01 00:00:00 +0000`),
+				Test:        false,
+			})
+		})
+
+		Convey("Signature", func() {
+			// as computed by: printf '1234.{}' | openssl dgst -sha256 -hmac secret
+			So(Sign("secret", "1234", []byte("{}")), ShouldEqual, "sha256=3cd06c4748af5a8a8794bc7299eaec7826bb24c266c9b020469c97d6b477f788")
+		})
+
+		Convey("Unsigned if there's no secret", func() {
+			settings.Secret = nil
+
+			notify()
+
+			So(len(receiver.requests), ShouldEqual, 1)
+			So(receiver.requests[0].header.Get(SignatureHeader), ShouldEqual, "")
+			So(receiver.requests[0].header.Get(TimestampHeader), ShouldEqual, "")
+		})
+
+		Convey("Disabled", func() {
+			settings.Enabled = false
+			notify()
+			So(receiver.requests, ShouldBeEmpty)
+		})
+
+		Convey("No settings", func() {
+			settings = nil
+			notify()
+			So(receiver.requests, ShouldBeEmpty)
+		})
+
+		Convey("Many URLs", func() {
+			settings.URLs = []string{s.URL + "/hook1", s.URL + "/hook2"}
+			notify()
+			So(len(receiver.requests), ShouldEqual, 2)
+		})
+
+		Convey("Retry with exponential backoff on temporary failures", func() {
+			receiver.statuses = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+
+			notify()
+
+			So(len(receiver.requests), ShouldEqual, 3)
+
+			// waited one, then two seconds
+			So(clock.Now(), ShouldResemble, testutil.MustParseTime(`2000-01-01 00:00:03 +0000`))
+
+			Convey("The retries are signed with the time they're sent", func() {
+				So(receiver.requests[2].header.Get(TimestampHeader), ShouldEqual, "946684803")
+			})
+		})
+
+		Convey("Give up after all the attempts", func() {
+			receiver.statuses = []int{500, 500, 500, 500}
+			notify()
+			So(len(receiver.requests), ShouldEqual, 3)
+		})
+
+		Convey("Backoff is limited", func() {
+			notifier.retry = RetryPolicy{Attempts: 5, InitialBackoff: time.Second * 2, MaxBackoff: time.Second * 5}
+			receiver.statuses = []int{500, 500, 500, 500}
+			notify()
+			So(len(receiver.requests), ShouldEqual, 5)
+
+			// 2 + 4 + 5 + 5
+			So(clock.Now(), ShouldResemble, testutil.MustParseTime(`2000-01-01 00:00:16 +0000`))
+		})
+
+		Convey("Close interrupts the backoff", func() {
+			blocking := &blockingClock{FakeClock: clock, release: make(chan struct{})}
+			defer close(blocking.release)
+
+			notifier := newWithCustomOptions(policies, settingsFetcher, blocking, retry)
+
+			receiver.statuses = []int{500, 500, 500, 500}
+
+			So(notifier.Notify(core.Notification{ID: 42, Content: fakeContent{}}, translator), ShouldBeNil)
+
+			// the backoff starts after the first attempt fails
+			waitFor(func() bool { return receiver.countRequests() == 1 })
+
+			So(notifier.Close(), ShouldBeNil)
+			So(receiver.countRequests(), ShouldEqual, 1)
+		})
+
+		Convey("Close interrupts the requests", func() {
+			started := make(chan struct{})
+
+			hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// the server notices the client went away only after the body is read
+				_, _ = io.ReadAll(r.Body)
+				close(started)
+				<-r.Context().Done()
+			}))
+
+			defer hanging.Close()
+
+			settings.URLs = []string{hanging.URL + "/hook"}
+
+			So(notifier.Notify(core.Notification{ID: 42, Content: fakeContent{}}, translator), ShouldBeNil)
+
+			<-started
+
+			So(notifier.Close(), ShouldBeNil)
+		})
+
+		Convey("Nothing is sent once closed", func() {
+			So(notifier.Close(), ShouldBeNil)
+			notify()
+			So(receiver.requests, ShouldBeEmpty)
+		})
+
+		Convey("Do not retry when the request is rejected", func() {
+			receiver.statuses = []int{http.StatusBadRequest}
+			notify()
+			So(len(receiver.requests), ShouldEqual, 1)
+		})
+
+		Convey("Test notification", func() {
+			// even if disabled
+			settings.Enabled = false
+
+			So(notifier.ValidateSettings(*settings), ShouldBeNil)
+			So(len(receiver.requests), ShouldEqual, 1)
+
+			var payload Payload
+			So(json.Unmarshal(receiver.requests[0].body, &payload), ShouldBeNil)
+			So(payload.Test, ShouldBeTrue)
+
+			Convey("Fails without retrying", func() {
+				receiver.statuses = []int{500}
+				err := notifier.ValidateSettings(*settings)
+				So(errors.Is(err, ErrRejected), ShouldBeTrue)
+				So(len(receiver.requests), ShouldEqual, 2)
+			})
+		})
+	})
+}
+
+func TestCheckSettings(t *testing.T) {
+	Convey("Check settings", t, func() {
+		So(CheckSettings(Settings{}), ShouldBeNil)
+		So(CheckSettings(Settings{Enabled: true, URLs: []string{"https://example.com/hook"}}), ShouldBeNil)
+		So(errors.Is(CheckSettings(Settings{Enabled: true}), ErrNoURLs), ShouldBeTrue)
+		So(errors.Is(CheckSettings(Settings{URLs: []string{"ftp://example.com/hook"}}), ErrInvalidURL), ShouldBeTrue)
+		So(errors.Is(CheckSettings(Settings{URLs: []string{"https:///hook"}}), ErrInvalidURL), ShouldBeTrue)
+		So(errors.Is(CheckSettings(Settings{URLs: []string{"not a url"}}), ErrInvalidURL), ShouldBeTrue)
+		So(CheckSettings(Settings{Headers: map[string]string{"X-Api-Key": "1234"}}), ShouldBeNil)
+		So(errors.Is(CheckSettings(Settings{Headers: map[string]string{"X Api Key": "1234"}}), ErrInvalidHeader), ShouldBeTrue)
+		So(errors.Is(CheckSettings(Settings{Headers: map[string]string{"X-Api-Key": "12\r\n34"}}), ErrInvalidHeader), ShouldBeTrue)
+	})
+}
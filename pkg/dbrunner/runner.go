@@ -8,11 +8,15 @@ import (
 	"database/sql"
 	"github.com/rs/zerolog/log"
 	"gitlab.com/lightmeter/controlcenter/lmsqlite3/dbconn"
+	"gitlab.com/lightmeter/controlcenter/pkg/metrics"
 	"gitlab.com/lightmeter/controlcenter/pkg/runner"
 	"gitlab.com/lightmeter/controlcenter/util/errorutil"
 	"time"
 )
 
+var actionDuration = metrics.NewHistogram("lightmeter_dbrunner_action_duration_seconds",
+	"Time spent executing each action on a database", metrics.DefaultLatencyBuckets, "database")
+
 type Action func(*sql.Tx, dbconn.TxPreparedStmts) error
 
 type Actions chan<- Action
@@ -21,6 +25,11 @@ type Runner struct {
 	runner.CancellableRunner
 	stmts   dbconn.PreparedStmts
 	Actions chan Action
+
+	// BeforeCommit, if set, is executed before every transaction is committed,
+	// allowing actions to accumulate what they write into a single batch.
+	// It must be set before the runner starts.
+	BeforeCommit Action
 }
 
 func newCleaner(cleanInterval time.Duration, cleaner Action, actions chan<- Action) runner.CancellableRunner {
@@ -42,39 +51,43 @@ func newCleaner(cleanInterval time.Duration, cleaner Action, actions chan<- Acti
 	})
 }
 
-func New(timeout time.Duration, actionSize uint, conn dbconn.RwConn, stmts dbconn.PreparedStmts, cleanInterval time.Duration, cleaner Action) *Runner {
+// New creates a runner executing actions on the database called name
+func New(name string, timeout time.Duration, actionSize uint, conn dbconn.RwConn, stmts dbconn.PreparedStmts, cleanInterval time.Duration, cleaner Action) *Runner {
 	actions := make(chan Action, actionSize)
 
-	return &Runner{
+	r := &Runner{
 		stmts:   stmts,
 		Actions: actions,
-		CancellableRunner: runner.NewCancellableRunner(func(done runner.DoneChan, cancel runner.CancelChan) {
-			cleanerDone, cleanerCancel := runner.Run(newCleaner(cleanInterval, cleaner, actions))
-
-			go func() {
-				<-cancel
-				cleanerCancel()
-				_ = cleanerDone()
-				close(actions)
-			}()
+	}
 
-			go func() {
-				done <- func() error {
-					if err := fillDatabase(timeout, conn, stmts, actions); err != nil {
-						return errorutil.Wrap(err)
-					}
+	r.CancellableRunner = runner.NewCancellableRunner(func(done runner.DoneChan, cancel runner.CancelChan) {
+		cleanerDone, cleanerCancel := runner.Run(newCleaner(cleanInterval, cleaner, actions))
 
-					return nil
-				}()
+		go func() {
+			<-cancel
+			cleanerCancel()
+			_ = cleanerDone()
+			close(actions)
+		}()
+
+		go func() {
+			done <- func() error {
+				if err := fillDatabase(name, timeout, conn, stmts, actions, r.BeforeCommit); err != nil {
+					return errorutil.Wrap(err)
+				}
+
+				return nil
 			}()
-		}),
-	}
+		}()
+	})
+
+	return r
 }
 
 // TODO: I could not find a way to simplify this function,
 // so I'll silence the linter complaining about complexity...
 //nolint:gocognit
-func fillDatabase(timeout time.Duration, conn dbconn.RwConn, stmts dbconn.PreparedStmts, dbActions <-chan Action) error {
+func fillDatabase(name string, timeout time.Duration, conn dbconn.RwConn, stmts dbconn.PreparedStmts, dbActions <-chan Action, beforeCommit Action) error {
 	var (
 		tx                  *sql.Tx = nil
 		countPerTransaction int64
@@ -98,6 +111,12 @@ func fillDatabase(timeout time.Duration, conn dbconn.RwConn, stmts dbconn.Prepar
 			return nil
 		}
 
+		if beforeCommit != nil {
+			if err := beforeCommit(tx, preparedTxStmts); err != nil {
+				return errorutil.Wrap(err)
+			}
+		}
+
 		if err := preparedTxStmts.Close(); err != nil {
 			return errorutil.Wrap(err)
 		}
@@ -122,10 +141,14 @@ func fillDatabase(timeout time.Duration, conn dbconn.RwConn, stmts dbconn.Prepar
 			}
 		}
 
+		start := time.Now()
+
 		if err := action(tx, preparedTxStmts); err != nil {
 			return errorutil.Wrap(err)
 		}
 
+		actionDuration.Observe(time.Since(start).Seconds(), name)
+
 		countPerTransaction++
 
 		return nil
@@ -0,0 +1,324 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+// Package metrics keeps counters, gauges and histograms about the application,
+// exposing them in the Prometheus text format
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+type metric interface {
+	name() string
+	write(w io.Writer) error
+}
+
+type Registry struct {
+	mutex   sync.Mutex
+	metrics []metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Default is where the metrics of the whole application are registered
+var Default = NewRegistry()
+
+func (r *Registry) register(m metric) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, v := range r.metrics {
+		if v.name() == m.name() {
+			panic("metric registered twice: " + m.name())
+		}
+	}
+
+	r.metrics = append(r.metrics, m)
+}
+
+// Write writes all metrics to w, sorted by name
+func (r *Registry) Write(w io.Writer) error {
+	r.mutex.Lock()
+	metrics := append([]metric{}, r.metrics...)
+	r.mutex.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name() < metrics[j].name() })
+
+	for _, m := range metrics {
+		if err := m.write(w); err != nil {
+			return errorutil.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+type family struct {
+	metricName string
+	help       string
+	typ        string
+	labelNames []string
+}
+
+func (f *family) name() string {
+	return f.metricName
+}
+
+func (f *family) writeHeader(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.metricName, escapeHelp(f.help), f.metricName, f.typ); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+// key joins the label values, which are split back when the metric is written
+func (f *family) key(labelValues []string) string {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", f.metricName, len(f.labelNames), len(labelValues)))
+	}
+
+	return strings.Join(labelValues, "\x00")
+}
+
+func (f *family) labels(key string, extra ...string) string {
+	pairs := []string{}
+
+	if len(f.labelNames) > 0 {
+		for i, v := range strings.Split(key, "\x00") {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, f.labelNames[i], escapeLabelValue(v)))
+		}
+	}
+
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], escapeLabelValue(extra[i+1])))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// valueSet is used by counters and gauges
+type valueSet struct {
+	family
+	mutex  sync.Mutex
+	values map[string]float64
+}
+
+func (v *valueSet) write(w io.Writer) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if err := v.writeHeader(w); err != nil {
+		return err
+	}
+
+	for _, k := range sortedKeys(v.values) {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", v.metricName, v.labels(k), formatValue(v.values[k])); err != nil {
+			return errorutil.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+func (v *valueSet) add(delta float64, labelValues []string) {
+	k := v.key(labelValues)
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.values[k] += delta
+}
+
+// Counter is a value that only goes up, as the number of processed log lines
+type Counter struct {
+	valueSet
+}
+
+func (r *Registry) NewCounter(name, help string, labelNames ...string) *Counter {
+	c := &Counter{valueSet{family: family{metricName: name, help: help, typ: "counter", labelNames: labelNames}, values: map[string]float64{}}}
+	r.register(c)
+
+	return c
+}
+
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	return Default.NewCounter(name, help, labelNames...)
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.add(1, labelValues)
+}
+
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("counters cannot decrease")
+	}
+
+	c.add(delta, labelValues)
+}
+
+// Gauge is a value that can go up and down, as the size of a database
+type Gauge struct {
+	valueSet
+}
+
+func (r *Registry) NewGauge(name, help string, labelNames ...string) *Gauge {
+	g := &Gauge{valueSet{family: family{metricName: name, help: help, typ: "gauge", labelNames: labelNames}, values: map[string]float64{}}}
+	r.register(g)
+
+	return g
+}
+
+func NewGauge(name, help string, labelNames ...string) *Gauge {
+	return Default.NewGauge(name, help, labelNames...)
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	k := g.key(labelValues)
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.values[k] = value
+}
+
+// Reset removes all values, used when the set of label values changes, as the busiest domains
+func (g *Gauge) Reset() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.values = map[string]float64{}
+}
+
+// DefaultLatencyBuckets are in seconds, from 1ms to 10s
+var DefaultLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+type histogramValue struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Histogram counts observations, as durations, in buckets
+type Histogram struct {
+	family
+	buckets []float64
+	mutex   sync.Mutex
+	values  map[string]*histogramValue
+}
+
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	h := &Histogram{
+		family:  family{metricName: name, help: help, typ: "histogram", labelNames: labelNames},
+		buckets: buckets,
+		values:  map[string]*histogramValue{},
+	}
+
+	r.register(h)
+
+	return h
+}
+
+func NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labelNames...)
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	k := h.key(labelValues)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	v, ok := h.values[k]
+	if !ok {
+		v = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[k] = v
+	}
+
+	for i, b := range h.buckets {
+		if value <= b {
+			v.counts[i]++
+		}
+	}
+
+	v.count++
+	v.sum += value
+}
+
+func (h *Histogram) write(w io.Writer) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if err := h.writeHeader(w); err != nil {
+		return err
+	}
+
+	for _, k := range sortedKeys(h.values) {
+		v := h.values[k]
+
+		for i, b := range h.buckets {
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.labels(k, "le", formatValue(b)), v.counts[i]); err != nil {
+				return errorutil.Wrap(err)
+			}
+		}
+
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n%s_sum%s %s\n%s_count%s %d\n",
+			h.metricName, h.labels(k, "le", "+Inf"), v.count,
+			h.metricName, h.labels(k), formatValue(v.sum),
+			h.metricName, h.labels(k), v.count); err != nil {
+			return errorutil.Wrap(err)
+		}
+	}
+
+	return nil
+}
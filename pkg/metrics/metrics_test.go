@@ -0,0 +1,93 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package metrics
+
+import (
+	"bytes"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	Convey("Test Metrics", t, func() {
+		r := NewRegistry()
+
+		write := func() string {
+			var b bytes.Buffer
+			So(r.Write(&b), ShouldBeNil)
+			return b.String()
+		}
+
+		Convey("Metrics with the same name cannot be registered twice", func() {
+			r.NewCounter("some_counter", "Some counter")
+			So(func() { r.NewGauge("some_counter", "Some gauge") }, ShouldPanic)
+		})
+
+		Convey("Label values must match the label names", func() {
+			c := r.NewCounter("some_counter", "Some counter", "a", "b")
+			So(func() { c.Inc("only_one") }, ShouldPanic)
+		})
+
+		Convey("Counters and gauges, sorted by name and label values", func() {
+			c := r.NewCounter("b_counter", "Some counter", "status")
+			g := r.NewGauge("a_gauge", "Some \"gauge\"\nwith two lines")
+
+			c.Inc("sent")
+			c.Add(2, "bounced")
+			c.Inc("sent")
+			g.Set(3.5)
+
+			So(func() { c.Add(-1, "sent") }, ShouldPanic)
+
+			So(write(), ShouldEqual, `# HELP a_gauge Some "gauge"\nwith two lines
+# TYPE a_gauge gauge
+a_gauge 3.5
+# HELP b_counter Some counter
+# TYPE b_counter counter
+b_counter{status="bounced"} 2
+b_counter{status="sent"} 2
+`)
+
+			Convey("Gauges can be reset", func() {
+				g.Reset()
+
+				So(write(), ShouldEqual, `# HELP a_gauge Some "gauge"\nwith two lines
+# TYPE a_gauge gauge
+# HELP b_counter Some counter
+# TYPE b_counter counter
+b_counter{status="bounced"} 2
+b_counter{status="sent"} 2
+`)
+			})
+		})
+
+		Convey("Label values are escaped", func() {
+			g := r.NewGauge("some_gauge", "Some gauge", "domain")
+			g.Set(1, `we"ird\domain`)
+
+			So(write(), ShouldEqual, `# HELP some_gauge Some gauge
+# TYPE some_gauge gauge
+some_gauge{domain="we\"ird\\domain"} 1
+`)
+		})
+
+		Convey("Histograms", func() {
+			h := r.NewHistogram("some_duration_seconds", "Some duration", []float64{0.1, 1}, "database")
+
+			h.Observe(0.05, "logs")
+			h.Observe(0.5, "logs")
+			h.Observe(2, "logs")
+
+			So(write(), ShouldEqual, `# HELP some_duration_seconds Some duration
+# TYPE some_duration_seconds histogram
+some_duration_seconds_bucket{database="logs",le="0.1"} 1
+some_duration_seconds_bucket{database="logs",le="1"} 2
+some_duration_seconds_bucket{database="logs",le="+Inf"} 3
+some_duration_seconds_sum{database="logs"} 2.55
+some_duration_seconds_count{database="logs"} 3
+`)
+		})
+	})
+}
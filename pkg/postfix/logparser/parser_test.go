@@ -664,6 +664,37 @@ func TestSmtpdMailAccepted(t *testing.T) {
 			So(cast, ShouldBeTrue)
 			So(p.IP, ShouldEqual, net.ParseIP(`::1`))
 		})
+
+		Convey("No authentication", func() {
+			_, payload, err := Parse(string(`Jan 25 06:32:43 mx postfix/smtpd[26382]: 477832E0134: client=h-a4984b7e1cf68ab295d77c5df3[224.93.112.97]`))
+			So(err, ShouldBeNil)
+			p, cast := payload.(SmtpdMailAccepted)
+			So(cast, ShouldBeTrue)
+			So(p.SASLMethod, ShouldEqual, "")
+			So(p.SASLUsername, ShouldEqual, "")
+		})
+
+		Convey("Authenticated client", func() {
+			_, payload, err := Parse(string(`Jun  3 10:40:57 mail postfix/submission/smtpd[9708]: 4AA091855DA0: client=some.domain.name[1.2.3.4], sasl_method=PLAIN, sasl_username=user@sender.com`))
+			So(err, ShouldBeNil)
+			p, cast := payload.(SmtpdMailAccepted)
+			So(cast, ShouldBeTrue)
+			So(p.Queue, ShouldEqual, "4AA091855DA0")
+			So(p.Host, ShouldEqual, "some.domain.name")
+			So(p.IP, ShouldEqual, net.ParseIP(`1.2.3.4`))
+			So(p.SASLMethod, ShouldEqual, "PLAIN")
+			So(p.SASLUsername, ShouldEqual, "user@sender.com")
+		})
+
+		Convey("Authenticated client with sender", func() {
+			_, payload, err := Parse(string(`Jun  3 10:40:57 mail postfix/smtpd[9708]: 4AA091855DA0: client=unknown[2aff:d7f:d:a::aaa], sasl_method=LOGIN, sasl_username=someone, sasl_sender=other@example.com`))
+			So(err, ShouldBeNil)
+			p, cast := payload.(SmtpdMailAccepted)
+			So(cast, ShouldBeTrue)
+			So(p.IP, ShouldEqual, net.ParseIP(`2aff:d7f:d:a::aaa`))
+			So(p.SASLMethod, ShouldEqual, "LOGIN")
+			So(p.SASLUsername, ShouldEqual, "someone")
+		})
 	})
 }
 
@@ -696,6 +727,32 @@ func TestSmtpdReject(t *testing.T) {
 		So(cast, ShouldBeTrue)
 		So(p.Queue, ShouldEqual, "DE81A2E2DAA")
 		So(p.ExtraMessage, ShouldEqual, `RCPT from unknown[2a02:168:636a::15e2]: 550 5.1.1 <h-c715634009216@h-14dc4a6d.com>: Recipient address rejected: User unknown in virtual mailbox table; from=<h-d2315d@h-24e89d.com> to=<h-c715634009216@h-14dc4a6d.com> proto=ESMTP helo=<[IPv6:2a02:168:636a::15e2]>`)
+		So(p.Host, ShouldEqual, "unknown")
+		So(p.IP, ShouldEqual, net.ParseIP("2a02:168:636a::15e2"))
+		So(p.DSN, ShouldEqual, "5.1.1")
+		So(p.Recipient, ShouldEqual, "h-c715634009216@h-14dc4a6d.com")
+	})
+
+	Convey("Smtpd reject before queueing", t, func() {
+		_, payload, err := Parse(string(`Feb  8 21:28:47 mx postfix/smtpd[1036]: NOQUEUE: reject: RCPT from h-5ab1[11.22.33.44]: 550 5.1.1 <h-c7156@h-14dc4a6d.com>: Recipient address rejected: User unknown in virtual mailbox table; from=<h-d2315d@h-24e89d.com> to=<h-c7156@h-14dc4a6d.com> proto=ESMTP helo=<h-5ab1>`))
+		So(err, ShouldBeNil)
+		p, cast := payload.(SmtpdReject)
+		So(cast, ShouldBeTrue)
+		So(p.Queue, ShouldEqual, "")
+		So(p.Host, ShouldEqual, "h-5ab1")
+		So(p.IP, ShouldEqual, net.ParseIP("11.22.33.44"))
+		So(p.DSN, ShouldEqual, "5.1.1")
+		So(p.Recipient, ShouldEqual, "h-c7156@h-14dc4a6d.com")
+	})
+
+	Convey("Smtpd reject without enhanced status code", t, func() {
+		_, payload, err := Parse(string(`Feb  8 21:28:47 mx postfix/smtpd[1036]: NOQUEUE: reject: CONNECT from unknown[11.22.33.44]: 554 Service unavailable; proto=SMTP`))
+		So(err, ShouldBeNil)
+		p, cast := payload.(SmtpdReject)
+		So(cast, ShouldBeTrue)
+		So(p.IP, ShouldEqual, net.ParseIP("11.22.33.44"))
+		So(p.DSN, ShouldEqual, "")
+		So(p.Recipient, ShouldEqual, "")
 	})
 }
 
@@ -852,7 +852,7 @@ const smtpdMailAccepted_en_main int = 1
 
 //line smtpd.rl:129
 
-// TODO: accept additional metadata (sasl_method and sasl_username)
+// NOTE: the metadata after the client address (sasl_method and sasl_username) is read by parseSmtpdMailAcceptedMetadata
 func parseSmtpdMailAccepted(data string) (SmtpdMailAccepted, bool) {
 	cs, p, pe, eof := 0, 0, len(data), len(data)
 	tokBeg := 0
@@ -6,6 +6,8 @@
 
 package rawparser
 
+import "strings"
+
 func init() {
 	// from the standard postfix setup
 	registerHandler("postfix", "submission/smtpd", parseSmtpdPayload) // for STARTTLS submission connection (port 587)
@@ -43,6 +45,10 @@ type SmtpdMailAccepted struct {
 	Host  string
 	IP    string
 	Queue string
+
+	// Empty when the client has not authenticated
+	SASLMethod   string
+	SASLUsername string
 }
 
 func parseSmtpdPayload(payloadLine string) (RawPayload, error) {
@@ -61,6 +67,8 @@ func parseSmtpdPayload(payloadLine string) (RawPayload, error) {
 	}
 
 	if s, parsed := parseSmtpdMailAccepted(payloadLine); parsed {
+		parseSmtpdMailAcceptedMetadata(payloadLine, &s)
+
 		return RawPayload{
 			PayloadType:       PayloadTypeSmtpdMailAccepted,
 			SmtpdMailAccepted: s,
@@ -74,10 +82,51 @@ func parseSmtpdPayload(payloadLine string) (RawPayload, error) {
 		}, nil
 	}
 
+	if s, parsed := parseSmtpdNoQueueReject(payloadLine); parsed {
+		return RawPayload{
+			PayloadType: PayloadTypeSmtpdReject,
+			SmtpdReject: s,
+		}, nil
+	}
+
 	return RawPayload{PayloadType: PayloadTypeUnsupported}, ErrUnsupportedLogLine
 }
 
+// parseSmtpdMailAcceptedMetadata reads the optional metadata following the client address, in the form:
+// 4AA091855DA0: client=host[1.2.3.4], sasl_method=PLAIN, sasl_username=user@example.com
+func parseSmtpdMailAcceptedMetadata(data string, s *SmtpdMailAccepted) {
+	index := strings.Index(data, "], ")
+	if index < 0 {
+		return
+	}
+
+	for _, field := range strings.Split(data[index+3:], ", ") {
+		key, value, found := strings.Cut(field, "=")
+		if !found {
+			continue
+		}
+
+		switch key {
+		case "sasl_method":
+			s.SASLMethod = value
+		case "sasl_username":
+			s.SASLUsername = value
+		}
+	}
+}
+
 type SmtpdReject struct {
 	Queue        string
 	ExtraMessage string
 }
+
+const noQueueRejectPrefix = `NOQUEUE: reject: `
+
+// Most rejections, as the ones for unknown recipients, happen before any queue is created
+func parseSmtpdNoQueueReject(data string) (SmtpdReject, bool) {
+	if !strings.HasPrefix(data, noQueueRejectPrefix) {
+		return SmtpdReject{}, false
+	}
+
+	return SmtpdReject{ExtraMessage: data[len(noQueueRejectPrefix):]}, true
+}
@@ -127,7 +127,7 @@ func parseSmtpdDisconnect(data string) (SmtpdDisconnect, bool) {
 %% machine smtpdMailAccepted;
 %% write data;
 
-// TODO: accept additional metadata (sasl_method and sasl_username)
+// NOTE: the metadata after the client address (sasl_method and sasl_username) is read by parseSmtpdMailAcceptedMetadata
 func parseSmtpdMailAccepted(data string) (SmtpdMailAccepted, bool) {
 	cs, p, pe, eof := 0, 0, len(data), len(data)
 	tokBeg := 0
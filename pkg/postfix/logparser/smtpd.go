@@ -7,6 +7,7 @@ package parser
 import (
 	"gitlab.com/lightmeter/controlcenter/pkg/postfix/logparser/rawparser"
 	"net"
+	"strings"
 )
 
 func init() {
@@ -70,6 +71,10 @@ type SmtpdMailAccepted struct {
 	Queue string
 	Host  string
 	IP    net.IP
+
+	// Empty when the client has not authenticated
+	SASLMethod   string
+	SASLUsername string
 }
 
 func (SmtpdMailAccepted) isPayload() {
@@ -85,15 +90,24 @@ func convertSmtpdMailAccepted(r rawparser.RawPayload) (Payload, error) {
 	}
 
 	return SmtpdMailAccepted{
-		Host:  p.Host,
-		IP:    ip,
-		Queue: p.Queue,
+		Host:         p.Host,
+		IP:           ip,
+		Queue:        p.Queue,
+		SASLMethod:   p.SASLMethod,
+		SASLUsername: p.SASLUsername,
 	}, nil
 }
 
 type SmtpdReject struct {
+	// Empty if the message was rejected before a queue was created (NOQUEUE)
 	Queue        string
 	ExtraMessage string
+
+	// The following are obtained from ExtraMessage, and are empty if not present there
+	Host      string
+	IP        net.IP
+	DSN       string
+	Recipient string
 }
 
 func (SmtpdReject) isPayload() {
@@ -103,8 +117,56 @@ func (SmtpdReject) isPayload() {
 func convertSmtpdReject(r rawparser.RawPayload) (Payload, error) {
 	p := r.SmtpdReject
 
-	return SmtpdReject{
+	reject := SmtpdReject{
 		Queue:        p.Queue,
 		ExtraMessage: p.ExtraMessage,
-	}, nil
+	}
+
+	parseSmtpdRejectDetails(&reject)
+
+	return reject, nil
+}
+
+// parseSmtpdRejectDetails understands messages in the form:
+// RCPT from host[1.2.3.4]: 550 5.1.1 <a@b.c>: Recipient address rejected: User unknown; from=<d@e.f> to=<a@b.c> proto=ESMTP helo=<host>
+func parseSmtpdRejectDetails(reject *SmtpdReject) {
+	m := reject.ExtraMessage
+
+	if index := strings.LastIndex(m, " to=<"); index >= 0 {
+		if end := strings.IndexByte(m[index+5:], '>'); end >= 0 {
+			reject.Recipient = m[index+5 : index+5+end]
+		}
+	}
+
+	index := strings.Index(m, " from ")
+	if index < 0 {
+		return
+	}
+
+	m = m[index+6:]
+
+	end := strings.Index(m, "]: ")
+	if end < 0 {
+		return
+	}
+
+	open := strings.IndexByte(m[:end], '[')
+	if open < 0 {
+		return
+	}
+
+	ip, err := parseIP(m[open+1 : end])
+	if err != nil {
+		return
+	}
+
+	reject.Host = m[:open]
+	reject.IP = ip
+
+	// the smtp code and, optionally, the enhanced status code
+	fields := strings.SplitN(m[end+3:], " ", 3)
+
+	if len(fields) > 1 && strings.Count(fields[1], ".") == 2 {
+		reject.DSN = fields[1]
+	}
 }
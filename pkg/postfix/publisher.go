@@ -32,6 +32,26 @@ type Publisher interface {
 	Publish(Record)
 }
 
+// InvalidLine is a log line which could not be parsed at all, not even its header,
+// so it cannot be published as a record
+type InvalidLine struct {
+	// Time is only an approximation, as the time of the line is not known
+	Time time.Time
+	Line string
+}
+
+// InvalidLinePublisher is implemented by the publishers interested in the lines which could not be parsed
+type InvalidLinePublisher interface {
+	PublishInvalidLine(InvalidLine)
+}
+
+// PublishInvalidLine forwards l to pub, if it's interested in it
+func PublishInvalidLine(pub Publisher, l InvalidLine) {
+	if p, ok := pub.(InvalidLinePublisher); ok {
+		p.PublishInvalidLine(l)
+	}
+}
+
 type ComposedPublisher []Publisher
 
 func (c ComposedPublisher) Publish(r Record) {
@@ -39,3 +59,9 @@ func (c ComposedPublisher) Publish(r Record) {
 		p.Publish(r)
 	}
 }
+
+func (c ComposedPublisher) PublishInvalidLine(l InvalidLine) {
+	for _, p := range c {
+		PublishInvalidLine(p, l)
+	}
+}
@@ -32,6 +32,8 @@ type Accessor interface {
 	FetchLogsInIntervalToWriter(context.Context, timeutil.TimeInterval, io.Writer) error
 	CountLogLinesInInterval(context.Context, timeutil.TimeInterval) (int64, error)
 	FetchLogLine(context.Context, time.Time, postfix.Sum) (string, error)
+	SearchLogsInInterval(context.Context, timeutil.TimeInterval, SearchOptions) (SearchResult, error)
+	UnsupportedLinesReport(context.Context) (UnsupportedLinesReport, error)
 }
 
 var ErrLogLineNotFound = errors.New(`Log line not found`)
@@ -60,6 +62,14 @@ func (a *accessor) FetchLogLine(ctx context.Context, time time.Time, sum postfix
 	return FetchLogLine(ctx, a.pool, time, sum)
 }
 
+func (a *accessor) SearchLogsInInterval(ctx context.Context, interval timeutil.TimeInterval, options SearchOptions) (SearchResult, error) {
+	return SearchLogsInInterval(ctx, a.pool, interval, options)
+}
+
+func (a *accessor) UnsupportedLinesReport(ctx context.Context) (UnsupportedLinesReport, error) {
+	return FetchUnsupportedLinesReport(ctx, a.pool)
+}
+
 func FetchLogsInIntervalToWriter(ctx context.Context, pool *dbconn.RoPool, interval timeutil.TimeInterval, w io.Writer) error {
 	conn, release, err := pool.AcquireContext(ctx)
 	if err != nil {
@@ -0,0 +1,143 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package rawlogsdb
+
+import (
+	"context"
+	"database/sql"
+
+	"gitlab.com/lightmeter/controlcenter/erasure"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+// Erase anonymises all the log lines mentioning target.
+// The lines are not deleted, as they are referenced by time and checksum by other subsystems
+// and also used to know from where to resume reading the logs.
+func (db *DB) Erase(ctx context.Context, target erasure.Target) (erasure.Entries, error) {
+	// the samples not yet stored must be anonymised as well,
+	// so they are stored before anonymising them
+	select {
+	case db.Actions <- db.lineStats.flush:
+	case <-ctx.Done():
+		return nil, errorutil.Wrap(ctx.Err())
+	}
+
+	return erasure.Dispatch(ctx, db.Actions, func(tx *sql.Tx) (erasure.Entries, error) {
+		count, err := anonymiseLogLines(tx, target, db.fullTextIndex)
+		if err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		samplesCount, err := anonymiseUnsupportedLineSamples(tx, target)
+		if err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		return erasure.Entries{
+			{Database: "rawlogs", Table: "logs", Action: erasure.AnonymisedAction, Count: count},
+			{Database: "rawlogs", Table: "unsupported_line_samples", Action: erasure.AnonymisedAction, Count: samplesCount},
+		}, nil
+	})
+}
+
+func anonymiseLogLines(tx *sql.Tx, target erasure.Target, fullTextIndex bool) (count int64, err error) {
+	// NOTE: `like` is case insensitive, and used only to prevent checking every line in Go
+	rows, err := tx.Query(`select id, content from logs where content like ?`, target.LikePattern())
+	if err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	type line struct {
+		id         int64
+		content    string
+		anonymised string
+	}
+
+	lines := []line{}
+
+	matcher := target.Matcher()
+
+	for rows.Next() {
+		var l line
+
+		if err := rows.Scan(&l.id, &l.content); err != nil {
+			return 0, errorutil.Wrap(err)
+		}
+
+		anonymised := matcher.Anonymise(l.content)
+
+		if anonymised == l.content {
+			continue
+		}
+
+		lines = append(lines, line{id: l.id, content: l.content, anonymised: anonymised})
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	for _, l := range lines {
+		if _, err := tx.Exec(`update logs set content = ? where id = ?`, l.anonymised, l.id); err != nil {
+			return 0, errorutil.Wrap(err)
+		}
+
+		if !fullTextIndex {
+			continue
+		}
+
+		if err := unindexLogLine(tx, l.id, l.content); err != nil {
+			return 0, errorutil.Wrap(err)
+		}
+
+		if err := indexLogLine(tx, l.id, l.anonymised); err != nil {
+			return 0, errorutil.Wrap(err)
+		}
+	}
+
+	return int64(len(lines)), nil
+}
+
+func anonymiseUnsupportedLineSamples(tx *sql.Tx, target erasure.Target) (count int64, err error) {
+	rows, err := tx.Query(`select id, content from unsupported_line_samples where content like ?`, target.LikePattern())
+	if err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	anonymised := map[int64]string{}
+
+	matcher := target.Matcher()
+
+	for rows.Next() {
+		var (
+			id      int64
+			content string
+		)
+
+		if err := rows.Scan(&id, &content); err != nil {
+			return 0, errorutil.Wrap(err)
+		}
+
+		if a := matcher.Anonymise(content); a != content {
+			anonymised[id] = a
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	for id, content := range anonymised {
+		if _, err := tx.Exec(`update unsupported_line_samples set content = ? where id = ?`, content, id); err != nil {
+			return 0, errorutil.Wrap(err)
+		}
+	}
+
+	return int64(len(anonymised)), nil
+}
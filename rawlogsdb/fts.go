@@ -0,0 +1,158 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package rawlogsdb
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/rs/zerolog/log"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/dbconn"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+// The full-text search index is an external content FTS5 table on the logs table,
+// using the trigram tokenizer, so it's also used for substring searches.
+//
+// It's only available if SQLite is built with FTS5 support (the `sqlite_fts5` build tag),
+// and it's kept up to date by the publisher and cleaner, instead of by triggers,
+// so the database can still be written by builds without such support,
+// which mark the index as stale, so it's rebuilt once a build with support is used again.
+// Searches fall back to scanning the log lines when the index is not available.
+
+const (
+	insertFullTextIndexLine = `insert into logs_fts(rowid, content) values(?, ?)`
+	deleteFullTextIndexLine = `insert into logs_fts(logs_fts, rowid, content) values('delete', ?, ?)`
+)
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func fullTextSearchSupported(ctx context.Context, q queryRower) (bool, error) {
+	var supported bool
+
+	if err := q.QueryRowContext(ctx, `select sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&supported); err != nil {
+		return false, errorutil.Wrap(err)
+	}
+
+	return supported, nil
+}
+
+func fullTextIndexExists(ctx context.Context, q queryRower) (bool, error) {
+	var count int
+
+	if err := q.QueryRowContext(ctx, `select count(*) from sqlite_master where type = 'table' and name = 'logs_fts'`).Scan(&count); err != nil {
+		return false, errorutil.Wrap(err)
+	}
+
+	return count > 0, nil
+}
+
+// hasFullTextIndex returns whether the index can be used for searching
+func hasFullTextIndex(ctx context.Context, q queryRower) (bool, error) {
+	supported, err := fullTextSearchSupported(ctx, q)
+	if err != nil {
+		return false, errorutil.Wrap(err)
+	}
+
+	if !supported {
+		return false, nil
+	}
+
+	return fullTextIndexExists(ctx, q)
+}
+
+// setupFullTextIndex creates the index, if supported, indexing any already existing log lines.
+// As builds without FTS5 support write log lines without indexing them, they mark the index as stale,
+// and the index is then rebuilt by the next build supporting it.
+func setupFullTextIndex(conn dbconn.RwConn) (bool, error) {
+	ctx := context.Background()
+
+	supported, err := fullTextSearchSupported(ctx, conn)
+	if err != nil {
+		return false, errorutil.Wrap(err)
+	}
+
+	exists, err := fullTextIndexExists(ctx, conn)
+	if err != nil {
+		return false, errorutil.Wrap(err)
+	}
+
+	if !supported {
+		log.Warn().Msg("SQLite has been built without FTS5 support. Searching on raw logs will be slower")
+
+		if exists {
+			if err := setFullTextIndexStale(conn, true); err != nil {
+				return false, errorutil.Wrap(err)
+			}
+		}
+
+		return false, nil
+	}
+
+	stale, err := fullTextIndexStale(ctx, conn)
+	if err != nil {
+		return false, errorutil.Wrap(err)
+	}
+
+	if exists && !stale {
+		return true, nil
+	}
+
+	if !exists {
+		log.Info().Msg("Building full-text search index for the raw logs. This might take a while")
+
+		if _, err := conn.Exec(`create virtual table logs_fts using fts5(content, content = 'logs', content_rowid = 'id', tokenize = 'trigram')`); err != nil {
+			return false, errorutil.Wrap(err)
+		}
+	} else {
+		log.Info().Msg("Rebuilding full-text search index for the raw logs, as it's out of date. This might take a while")
+	}
+
+	if _, err := conn.Exec(`insert into logs_fts(logs_fts) values('rebuild')`); err != nil {
+		return false, errorutil.Wrap(err)
+	}
+
+	if err := setFullTextIndexStale(conn, false); err != nil {
+		return false, errorutil.Wrap(err)
+	}
+
+	return true, nil
+}
+
+func fullTextIndexStale(ctx context.Context, q queryRower) (bool, error) {
+	var stale bool
+
+	if err := q.QueryRowContext(ctx, `select stale from full_text_index_state where id = 1`).Scan(&stale); err != nil {
+		return false, errorutil.Wrap(err)
+	}
+
+	return stale, nil
+}
+
+func setFullTextIndexStale(conn dbconn.RwConn, stale bool) error {
+	if _, err := conn.Exec(`update full_text_index_state set stale = ? where id = 1`, stale); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func indexLogLine(tx *sql.Tx, id int64, content string) error {
+	if _, err := tx.Exec(insertFullTextIndexLine, id, content); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func unindexLogLine(tx *sql.Tx, id int64, content string) error {
+	if _, err := tx.Exec(deleteFullTextIndexLine, id, content); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package rawlogsdb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gitlab.com/lightmeter/controlcenter/util/testutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+func TestStaleFullTextIndex(t *testing.T) {
+	Convey("Test Stale Full-Text Index", t, func() {
+		db, closeConn := testutil.TempDBConnectionMigrated(t, "rawlogs")
+		defer closeConn()
+
+		ctx := context.Background()
+
+		supported, err := fullTextSearchSupported(ctx, db.RwConn)
+		So(err, ShouldBeNil)
+
+		open := func() *DB {
+			r, err := New(db.RwConn, Options{RetentionDuration: time.Hour * 24})
+			So(err, ShouldBeNil)
+			So(r.Close(), ShouldBeNil)
+			So(r.fullTextIndex, ShouldEqual, supported)
+			return r
+		}
+
+		open()
+
+		// the rest can only be checked when built with the sqlite_fts5 tag
+		if !supported {
+			return
+		}
+
+		stale, err := fullTextIndexStale(ctx, db.RwConn)
+		So(err, ShouldBeNil)
+		So(stale, ShouldBeFalse)
+
+		// as done by a build without the index
+		baseTime := timeutil.MustParseTime(`2020-02-04 09:00:00 +0000`)
+		_, err = db.RwConn.Exec(`insert into logs(time, checksum, content) values(?, 0, ?)`, baseTime.Unix(), `Feb  4 09:00:00 mail postfix/qmgr[964]: 027BD2C77B20: removed`)
+		So(err, ShouldBeNil)
+		So(setFullTextIndexStale(db.RwConn, true), ShouldBeNil)
+
+		search := func() int {
+			r, err := SearchLogsInInterval(ctx, db.RoConnPool, timeutil.TimeInterval{From: baseTime, To: baseTime.Add(time.Hour)}, SearchOptions{Mode: SubstringSearch, Query: "removed", PageSize: 10})
+			So(err, ShouldBeNil)
+			return len(r.Matches)
+		}
+
+		So(search(), ShouldEqual, 0)
+
+		open()
+
+		stale, err = fullTextIndexStale(ctx, db.RwConn)
+		So(err, ShouldBeNil)
+		So(stale, ShouldBeFalse)
+
+		So(search(), ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package migrations
+
+import (
+	"database/sql"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/migrator"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+func init() {
+	migrator.AddMigration("rawlogs", "2_unsupported_lines.go", upUnsupportedLines, downUnsupportedLines)
+}
+
+func upUnsupportedLines(tx *sql.Tx) error {
+	sql := `
+	create table log_line_stats(
+		process text not null,
+		daemon text not null,
+		since integer not null,
+		total integer not null,
+		unsupported integer not null,
+		primary key(process, daemon)
+	);
+
+	create table unsupported_lines(
+		id integer primary key,
+		process text not null,
+		daemon text not null,
+		signature text not null,
+		count integer not null,
+		first_seen integer not null,
+		last_seen integer not null
+	);
+
+	create unique index unsupported_lines_signature_index on unsupported_lines(process, daemon, signature);
+	create index unsupported_lines_last_seen_index on unsupported_lines(last_seen);
+
+	create table unsupported_line_samples(
+		id integer primary key,
+		signature_id integer not null,
+		time integer not null,
+		content text not null
+	);
+
+	create index unsupported_line_samples_signature_index on unsupported_line_samples(signature_id);
+	`
+
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func downUnsupportedLines(tx *sql.Tx) error {
+	_, err := tx.Exec(`drop table unsupported_line_samples; drop table unsupported_lines; drop table log_line_stats`)
+	return err
+}
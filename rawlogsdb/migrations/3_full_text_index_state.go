@@ -0,0 +1,40 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package migrations
+
+import (
+	"database/sql"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/migrator"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+func init() {
+	migrator.AddMigration("rawlogs", "3_full_text_index_state.go", upFullTextIndexState, downFullTextIndexState)
+}
+
+func upFullTextIndexState(tx *sql.Tx) error {
+	// The full-text index is not created by a migration, as it depends on how SQLite is built.
+	// An existing index is considered stale, as it might have missed lines written by builds without it.
+	sql := `
+	create table full_text_index_state(
+		id integer primary key check (id = 1),
+		stale boolean not null
+	);
+
+	insert into full_text_index_state(id, stale) values(1, true);
+	`
+
+	_, err := tx.Exec(sql)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
+
+func downFullTextIndexState(tx *sql.Tx) error {
+	_, err := tx.Exec(`drop table full_text_index_state`)
+	return err
+}
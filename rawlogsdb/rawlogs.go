@@ -21,6 +21,11 @@ import (
 type DB struct {
 	*dbrunner.Runner
 	closers.Closers
+
+	fullTextIndex bool
+
+	// owned by the runner, written on every commit
+	lineStats *lineStats
 }
 
 const (
@@ -28,14 +33,23 @@ const (
 	selectMostRecentLogTimeKey
 	selectOldestLogEntriesKey
 	deleteLogEntryKey
+	upsertLogLineStatsKey
+	upsertUnsupportedLineKey
+	insertUnsupportedLineSampleKey
 	lastStmtKey
 )
 
 var stmtsText = dbconn.StmtsText{
 	insertLogLineKey:           `insert into logs(time, checksum, content) values(?, ?, ?)`,
 	selectMostRecentLogTimeKey: `select time from logs order by time desc limit 1`,
-	selectOldestLogEntriesKey:  `select id from logs where time < ? order by time, id asc limit ?`,
+	selectOldestLogEntriesKey:  `select id, content from logs where time < ? order by time, id asc limit ?`,
 	deleteLogEntryKey:          `delete from logs where id = ?`,
+	upsertLogLineStatsKey: `insert into log_line_stats(process, daemon, since, total, unsupported) values(?, ?, ?, ?, ?)
+		on conflict(process, daemon) do update set total = total + excluded.total, unsupported = unsupported + excluded.unsupported`,
+	upsertUnsupportedLineKey: `insert into unsupported_lines(process, daemon, signature, count, first_seen, last_seen) values(?, ?, ?, ?, ?, ?)
+		on conflict(process, daemon, signature) do update set count = count + excluded.count, last_seen = max(last_seen, excluded.last_seen)
+		returning id, count`,
+	insertUnsupportedLineSampleKey: `insert into unsupported_line_samples(signature_id, time, content) values(?, ?, ?)`,
 }
 
 type Options struct {
@@ -49,29 +63,56 @@ func New(conn dbconn.RwConn, options Options) (*DB, error) {
 		return nil, errorutil.Wrap(err)
 	}
 
+	fullTextIndex, err := setupFullTextIndex(conn)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
 	const (
 		cleaningBatchSize = 10000
 		cleaningFrequency = time.Second * 30
 	)
 
+	stats := newLineStats()
+
+	runner := dbrunner.New("rawlogs", 500*time.Millisecond, 1024*1000, conn, stmts, cleaningFrequency, makeCleanAction(options.RetentionDuration, cleaningBatchSize, fullTextIndex, stats))
+	runner.BeforeCommit = stats.flush
+
 	return &DB{
-		Runner:  dbrunner.New(500*time.Millisecond, 1024*1000, conn, stmts, cleaningFrequency, makeCleanAction(options.RetentionDuration, cleaningBatchSize)),
-		Closers: closers.New(stmts),
+		Runner:        runner,
+		Closers:       closers.New(stmts),
+		fullTextIndex: fullTextIndex,
+		lineStats:     stats,
 	}, nil
 }
 
 func (db *DB) Publisher() postfix.Publisher {
-	return &publisher{actions: db.Actions}
+	return &publisher{actions: db.Actions, fullTextIndex: db.fullTextIndex}
 }
 
 type publisher struct {
-	actions chan<- dbrunner.Action
+	actions       chan<- dbrunner.Action
+	fullTextIndex bool
 }
 
 func (pub *publisher) Publish(r postfix.Record) {
 	pub.actions <- func(tx *sql.Tx, stmts dbconn.TxPreparedStmts) (err error) {
 		//nolint:sqlclosecheck
-		if _, err := stmts.Get(insertLogLineKey).Exec(r.Time.Unix(), r.Sum, r.Line); err != nil {
+		result, err := stmts.Get(insertLogLineKey).Exec(r.Time.Unix(), r.Sum, r.Line)
+		if err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		if !pub.fullTextIndex {
+			return nil
+		}
+
+		id, err := result.LastInsertId()
+		if err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		if err := indexLogLine(tx, id, r.Line); err != nil {
 			return errorutil.Wrap(err)
 		}
 
@@ -79,7 +120,7 @@ func (pub *publisher) Publish(r postfix.Record) {
 	}
 }
 
-func makeCleanAction(maxAge time.Duration, batchSize int) dbrunner.Action {
+func makeCleanAction(maxAge time.Duration, batchSize int, fullTextIndex bool, stats *lineStats) dbrunner.Action {
 	return func(tx *sql.Tx, stmts dbconn.TxPreparedStmts) error {
 		var mostRecentLogTime int64
 
@@ -105,6 +146,15 @@ func makeCleanAction(maxAge time.Duration, batchSize int) dbrunner.Action {
 
 		oldestTimeToKeep := time.Unix(mostRecentLogTime, 0).Add(-maxAge).Unix()
 
+		// so that the expired signatures not yet stored are deleted as well
+		if err := stats.flush(tx, stmts); err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		if err := deleteExpiredUnsupportedLines(tx, oldestTimeToKeep); err != nil {
+			return errorutil.Wrap(err)
+		}
+
 		//nolint:sqlclosecheck
 		rows, err := stmts.Get(selectOldestLogEntriesKey).Query(oldestTimeToKeep, batchSize)
 		if err != nil {
@@ -116,9 +166,12 @@ func makeCleanAction(maxAge time.Duration, batchSize int) dbrunner.Action {
 		n := 0
 
 		for rows.Next() {
-			var id int64
+			var (
+				id      int64
+				content string
+			)
 
-			if err := rows.Scan(&id); err != nil {
+			if err := rows.Scan(&id, &content); err != nil {
 				return errorutil.Wrap(err)
 			}
 
@@ -127,6 +180,12 @@ func makeCleanAction(maxAge time.Duration, batchSize int) dbrunner.Action {
 				return errorutil.Wrap(err)
 			}
 
+			if fullTextIndex {
+				if err := unindexLogLine(tx, id, content); err != nil {
+					return errorutil.Wrap(err)
+				}
+			}
+
 			n++
 		}
 
@@ -118,7 +118,7 @@ func TestDeleteLogs(t *testing.T) {
 
 		Convey("Cleaning should not remove any lines if no lines are available", func() {
 			// nothing is removed
-			rawLogs.Actions <- makeCleanAction(5*time.Second, 40)
+			rawLogs.Actions <- makeCleanAction(5*time.Second, 40, rawLogs.fullTextIndex, rawLogs.lineStats)
 			cancel()
 			So(done(), ShouldBeNil)
 
@@ -136,9 +136,9 @@ func TestDeleteLogs(t *testing.T) {
 			postfixutil.ReadFromTestFile("../test_files/postfix_logs/individual_files/4_lost_queue.log", pub, 2020, &timeutil.FakeClock{Time: timeutil.MustParseTime(`2020-12-31 00:00:00 +0000`)})
 
 			// remove the first items
-			rawLogs.Actions <- makeCleanAction(5*time.Second, 40)
-			rawLogs.Actions <- makeCleanAction(5*time.Second, 20)
-			rawLogs.Actions <- makeCleanAction(5*time.Second, 10)
+			rawLogs.Actions <- makeCleanAction(5*time.Second, 40, rawLogs.fullTextIndex, rawLogs.lineStats)
+			rawLogs.Actions <- makeCleanAction(5*time.Second, 20, rawLogs.fullTextIndex, rawLogs.lineStats)
+			rawLogs.Actions <- makeCleanAction(5*time.Second, 10, rawLogs.fullTextIndex, rawLogs.lineStats)
 
 			cancel()
 			So(done(), ShouldBeNil)
@@ -0,0 +1,290 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package rawlogsdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/dbconn"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+type SearchMode string
+
+const (
+	// SubstringSearch matches lines containing the query, case-insensitively
+	SubstringSearch SearchMode = "substring"
+
+	// TokenSearch matches lines containing all the whitespace separated words in the query, case-insensitively
+	TokenSearch SearchMode = "token"
+
+	// RegexSearch matches lines matching the query as a regular expression (Go syntax)
+	RegexSearch SearchMode = "regex"
+)
+
+const (
+	MaxSearchContextLines = 10
+	MaxSearchPageSize     = 1000
+)
+
+var ErrInvalidSearch = errors.New(`Invalid search`)
+
+type SearchOptions struct {
+	Mode         SearchMode
+	Query        string
+	ContextLines int
+	PageSize     int
+	Cursor       int64
+}
+
+type SearchMatch struct {
+	ContentRow
+	Before []ContentRow `json:"before"`
+	After  []ContentRow `json:"after"`
+}
+
+type SearchResult struct {
+	Cursor  int64         `json:"cursor"`
+	Matches []SearchMatch `json:"matches"`
+}
+
+// the trigram tokenizer cannot match anything shorter than it
+const minFullTextIndexQueryLen = 3
+
+func ftsPhrase(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+func likeSubstring(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + r.Replace(s) + "%"
+}
+
+// searchPlan is how a search is executed: a condition that selects candidate lines,
+// possibly using the full-text index, and an optional check on each candidate line
+type searchPlan struct {
+	conditions []string
+	args       []interface{}
+	matches    func(string) bool
+}
+
+func (p *searchPlan) containing(s string, useIndex bool) {
+	if useIndex && utf8.RuneCountInString(s) >= minFullTextIndexQueryLen {
+		p.conditions = append(p.conditions, `id in (select rowid from logs_fts where logs_fts match ?)`)
+		p.args = append(p.args, ftsPhrase(s))
+
+		return
+	}
+
+	p.conditions = append(p.conditions, `content like ? escape '\'`)
+	p.args = append(p.args, likeSubstring(s))
+}
+
+func buildSearchPlan(options SearchOptions, useIndex bool) (searchPlan, error) {
+	plan := searchPlan{}
+
+	if len(strings.TrimSpace(options.Query)) == 0 {
+		return searchPlan{}, fmt.Errorf("Empty query: %w", ErrInvalidSearch)
+	}
+
+	switch options.Mode {
+	case SubstringSearch:
+		plan.containing(options.Query, useIndex)
+	case TokenSearch:
+		words := strings.Fields(options.Query)
+		wordsRegexps := make([]*regexp.Regexp, 0, len(words))
+
+		for _, w := range words {
+			plan.containing(w, useIndex)
+			wordsRegexps = append(wordsRegexps, regexp.MustCompile(`(?i)(^|\W)`+regexp.QuoteMeta(w)+`($|\W)`))
+		}
+
+		plan.matches = func(line string) bool {
+			for _, re := range wordsRegexps {
+				if !re.MatchString(line) {
+					return false
+				}
+			}
+
+			return true
+		}
+	case RegexSearch:
+		re, err := regexp.Compile(options.Query)
+		if err != nil {
+			return searchPlan{}, fmt.Errorf("%v: %w", err, ErrInvalidSearch)
+		}
+
+		// any line matching the expression must contain its literal prefix, if any
+		if prefix, _ := re.LiteralPrefix(); len(prefix) > 0 {
+			plan.containing(prefix, useIndex)
+		}
+
+		plan.matches = re.MatchString
+	default:
+		return searchPlan{}, fmt.Errorf("Unknown search mode %v: %w", options.Mode, ErrInvalidSearch)
+	}
+
+	return plan, nil
+}
+
+func SearchLogsInInterval(ctx context.Context, pool *dbconn.RoPool, interval timeutil.TimeInterval, options SearchOptions) (SearchResult, error) {
+	if options.PageSize <= 0 || options.PageSize > MaxSearchPageSize || options.ContextLines < 0 || options.ContextLines > MaxSearchContextLines {
+		return SearchResult{}, ErrInvalidSearch
+	}
+
+	conn, release, err := pool.AcquireContext(ctx)
+	if err != nil {
+		return SearchResult{}, errorutil.Wrap(err)
+	}
+
+	defer release()
+
+	useIndex, err := hasFullTextIndex(ctx, conn)
+	if err != nil {
+		return SearchResult{}, errorutil.Wrap(err)
+	}
+
+	plan, err := buildSearchPlan(options, useIndex)
+	if err != nil {
+		return SearchResult{}, err
+	}
+
+	conditions := []string{`time between ? and ?`}
+	args := []interface{}{interval.From.Unix(), interval.To.Unix()}
+
+	// lines are not necessarily stored in time order, so the cursor is the position in such order
+	if options.Cursor != 0 {
+		cursorTime, err := cursorTime(ctx, conn, options.Cursor)
+		if err != nil {
+			return SearchResult{}, err
+		}
+
+		conditions = append(conditions, `(time, id) > (?, ?)`)
+		args = append(args, cursorTime, options.Cursor)
+	}
+
+	conditions = append(conditions, plan.conditions...)
+	args = append(args, plan.args...)
+
+	query := `select id, time, content from logs where ` + strings.Join(conditions, " and ") + ` order by time, id asc`
+
+	// without checking each line, the database can do the limiting by itself
+	if plan.matches == nil {
+		query += ` limit ?`
+
+		args = append(args, options.PageSize)
+	}
+
+	matches, ids, err := findMatches(ctx, conn, query, args, plan.matches, options.PageSize)
+	if err != nil {
+		return SearchResult{}, errorutil.Wrap(err)
+	}
+
+	if len(ids) == 0 {
+		return SearchResult{Cursor: 0, Matches: matches}, nil
+	}
+
+	for i, id := range ids {
+		if matches[i].Before, err = contextLines(ctx, conn, `select time, content from (select id, time, content from logs where id < ? order by id desc limit ?) order by id asc`, id, options.ContextLines); err != nil {
+			return SearchResult{}, errorutil.Wrap(err)
+		}
+
+		if matches[i].After, err = contextLines(ctx, conn, `select time, content from logs where id > ? order by id asc limit ?`, id, options.ContextLines); err != nil {
+			return SearchResult{}, errorutil.Wrap(err)
+		}
+	}
+
+	return SearchResult{Cursor: ids[len(ids)-1], Matches: matches}, nil
+}
+
+func cursorTime(ctx context.Context, conn *dbconn.RoPooledConn, cursor int64) (int64, error) {
+	var t int64
+
+	err := conn.QueryRowContext(ctx, `select time from logs where id = ?`, cursor).Scan(&t)
+
+	// the line might have been removed meanwhile, by the cleaner
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("Unknown cursor %v: %w", cursor, ErrInvalidSearch)
+	}
+
+	if err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	return t, nil
+}
+
+func findMatches(ctx context.Context, conn *dbconn.RoPooledConn, query string, args []interface{}, check func(string) bool, pageSize int) (matches []SearchMatch, ids []int64, err error) {
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	matches = []SearchMatch{}
+
+	for len(matches) < pageSize && rows.Next() {
+		var (
+			id  int64
+			row ContentRow
+		)
+
+		if err := rows.Scan(&id, &row.Timestamp, &row.Content); err != nil {
+			return nil, nil, errorutil.Wrap(err)
+		}
+
+		if check != nil && !check(row.Content) {
+			continue
+		}
+
+		matches = append(matches, SearchMatch{ContentRow: row, Before: []ContentRow{}, After: []ContentRow{}})
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, errorutil.Wrap(err)
+	}
+
+	return matches, ids, nil
+}
+
+func contextLines(ctx context.Context, conn *dbconn.RoPooledConn, query string, id int64, count int) (lines []ContentRow, err error) {
+	lines = []ContentRow{}
+
+	if count == 0 {
+		return lines, nil
+	}
+
+	rows, err := conn.QueryContext(ctx, query, id, count)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	for rows.Next() {
+		var row ContentRow
+
+		if err := rows.Scan(&row.Timestamp, &row.Content); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		lines = append(lines, row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return lines, nil
+}
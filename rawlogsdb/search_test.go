@@ -0,0 +1,199 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package rawlogsdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gitlab.com/lightmeter/controlcenter/pkg/postfix"
+	"gitlab.com/lightmeter/controlcenter/pkg/runner"
+	"gitlab.com/lightmeter/controlcenter/util/postfixutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+func TestSearchingLogLines(t *testing.T) {
+	Convey("Test Searching Log Lines", t, func() {
+		rawLogs, pub, pool, closeConn := buildContext(t)
+		defer closeConn()
+
+		done, cancel := runner.Run(rawLogs)
+
+		postfixutil.ReadFromTestFile("../test_files/postfix_logs/individual_files/4_lost_queue.log", pub, 2020, &timeutil.FakeClock{Time: timeutil.MustParseTime(`2020-12-31 00:00:00 +0000`)})
+
+		cancel()
+		So(done(), ShouldBeNil)
+
+		interval := timeutil.TimeInterval{
+			From: timeutil.MustParseTime(`2020-01-01 00:00:00 +0000`),
+			To:   timeutil.MustParseTime(`2020-12-31 00:00:00 +0000`),
+		}
+
+		search := func(mode SearchMode, query string, contextLines, pageSize int, cursor int64) SearchResult {
+			r, err := SearchLogsInInterval(context.Background(), pool, interval, SearchOptions{
+				Mode:         mode,
+				Query:        query,
+				ContextLines: contextLines,
+				PageSize:     pageSize,
+				Cursor:       cursor,
+			})
+
+			So(err, ShouldBeNil)
+
+			return r
+		}
+
+		Convey("Invalid searches", func() {
+			for _, options := range []SearchOptions{
+				{Mode: SubstringSearch, Query: "", PageSize: 10},
+				{Mode: SubstringSearch, Query: "  ", PageSize: 10},
+				{Mode: "unknown", Query: "smtp", PageSize: 10},
+				{Mode: RegexSearch, Query: "smtp(", PageSize: 10},
+				{Mode: SubstringSearch, Query: "smtp", PageSize: 0},
+				{Mode: SubstringSearch, Query: "smtp", PageSize: MaxSearchPageSize + 1},
+				{Mode: SubstringSearch, Query: "smtp", PageSize: 10, Cursor: 1000},
+				{Mode: SubstringSearch, Query: "smtp", PageSize: 10, ContextLines: MaxSearchContextLines + 1},
+			} {
+				_, err := SearchLogsInInterval(context.Background(), pool, interval, options)
+				So(errors.Is(err, ErrInvalidSearch), ShouldBeTrue)
+			}
+		})
+
+		Convey("Substring search, case insensitive", func() {
+			r := search(SubstringSearch, "SPOOL.mail.gandi.net[217.70.178.1]:25, delay", 0, 10, 0)
+			So(len(r.Matches), ShouldEqual, 3)
+			So(r.Matches[0].Content, ShouldStartWith, `Feb  4 09:29:27 mail postfix/smtp[6653]: D37AE2C77B1E: to=<mail@sender2.example.com>, relay=spool.mail.gandi.net`)
+			So(r.Matches[2].Content, ShouldStartWith, `Feb  4 09:29:27 mail postfix/smtp[6660]: 49C582C77B1F: to=<mail@sender2.example.com>`)
+			So(r.Matches[0].Before, ShouldResemble, []ContentRow{})
+			So(r.Matches[0].After, ShouldResemble, []ContentRow{})
+
+			// short queries, which cannot use the full-text index
+			So(len(search(SubstringSearch, "=6", 0, 100, 0).Matches), ShouldEqual, 1)
+
+			// like special characters are not wildcards
+			So(len(search(SubstringSearch, "spool%gandi", 0, 100, 0).Matches), ShouldEqual, 0)
+
+			So(len(search(SubstringSearch, "not in the logs", 0, 100, 0).Matches), ShouldEqual, 0)
+		})
+
+		Convey("Pagination", func() {
+			page1 := search(SubstringSearch, "removed", 0, 4, 0)
+			So(len(page1.Matches), ShouldEqual, 4)
+			So(page1.Matches[0].Content, ShouldContainSubstring, "99B222C0039E: removed")
+
+			page2 := search(SubstringSearch, "removed", 0, 4, page1.Cursor)
+			So(len(page2.Matches), ShouldEqual, 4)
+			So(page2.Matches[0].Content, ShouldContainSubstring, "D37AE2C77B1E: removed")
+
+			page3 := search(SubstringSearch, "removed", 0, 4, page2.Cursor)
+			So(len(page3.Matches), ShouldEqual, 0)
+			So(page3.Cursor, ShouldEqual, 0)
+		})
+
+		Convey("Context lines", func() {
+			r := search(SubstringSearch, "027BD2C77B20: removed", 2, 10, 0)
+			So(len(r.Matches), ShouldEqual, 1)
+			So(r.Matches[0].Content, ShouldEqual, `Feb  4 09:29:33 mail postfix/qmgr[964]: 027BD2C77B20: removed`)
+
+			// the last line has no lines after it
+			So(len(r.Matches[0].Before), ShouldEqual, 2)
+			So(r.Matches[0].Before[0].Content, ShouldStartWith, `Feb  4 09:29:29 mail postfix/smtp[6655]: Anonymous TLS connection established to balzers.recipient.example.com`)
+			So(r.Matches[0].Before[1].Content, ShouldStartWith, `Feb  4 09:29:33 mail postfix/smtp[6655]: 027BD2C77B20: to=<recipient1@recipient.example.com>`)
+			So(r.Matches[0].After, ShouldResemble, []ContentRow{})
+
+			r = search(SubstringSearch, "connect from unknown", 1, 1, 0)
+			So(len(r.Matches), ShouldEqual, 1)
+			So(r.Matches[0].Before, ShouldResemble, []ContentRow{})
+			So(len(r.Matches[0].After), ShouldEqual, 1)
+			So(r.Matches[0].After[0].Content, ShouldContainSubstring, `Anonymous TLS connection established from unknown[68.183.218.8]`)
+		})
+
+		Convey("Token search", func() {
+			// all the words must be in the line, as whole words
+			So(len(search(TokenSearch, "CCA402C003AA removed", 0, 100, 0).Matches), ShouldEqual, 1)
+			So(len(search(TokenSearch, "qmgr SIZE=818", 0, 100, 0).Matches), ShouldEqual, 1)
+			So(len(search(TokenSearch, "CCA402C003A removed", 0, 100, 0).Matches), ShouldEqual, 0)
+			So(len(search(TokenSearch, "gandi", 0, 100, 0).Matches), ShouldEqual, 6)
+		})
+
+		Convey("Regex search", func() {
+			So(len(search(RegexSearch, `size=\d{5},`, 0, 100, 0).Matches), ShouldEqual, 2)
+			So(len(search(RegexSearch, `relay=spool\.mail\.gandi\.net.*delay=0\.5\d`, 0, 100, 0).Matches), ShouldEqual, 2)
+
+			// regexes are case sensitive, unless asked otherwise
+			So(len(search(RegexSearch, `RELAY=SPOOL`, 0, 100, 0).Matches), ShouldEqual, 0)
+			So(len(search(RegexSearch, `(?i)RELAY=SPOOL`, 0, 100, 0).Matches), ShouldEqual, 3)
+
+			r := search(RegexSearch, `status=sent`, 0, 2, 0)
+			So(len(r.Matches), ShouldEqual, 2)
+			So(len(search(RegexSearch, `status=sent`, 0, 100, r.Cursor).Matches), ShouldEqual, 6)
+		})
+
+		Convey("Out of interval", func() {
+			r, err := SearchLogsInInterval(context.Background(), pool, timeutil.TimeInterval{
+				From: timeutil.MustParseTime(`2021-01-01 00:00:00 +0000`),
+				To:   timeutil.MustParseTime(`2021-12-31 00:00:00 +0000`),
+			}, SearchOptions{Mode: SubstringSearch, Query: "removed", PageSize: 10})
+
+			So(err, ShouldBeNil)
+			So(len(r.Matches), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestSearchingLogLinesOutOfTimeOrder(t *testing.T) {
+	Convey("Pagination follows the time of the lines, not the order they were stored", t, func() {
+		rawLogs, pub, pool, closeConn := buildContext(t)
+		defer closeConn()
+
+		done, cancel := runner.Run(rawLogs)
+
+		baseTime := timeutil.MustParseTime(`2020-02-04 09:00:00 +0000`)
+
+		// the lines from the second minute are stored first
+		for i, minute := range []int{1, 1, 0, 0, 2} {
+			pub.Publish(postfix.Record{
+				Time: baseTime.Add(time.Duration(minute) * time.Minute),
+				Line: fmt.Sprintf(`Feb  4 09:0%d:00 mail postfix/qmgr[964]: line %d removed`, minute, i),
+			})
+		}
+
+		cancel()
+		So(done(), ShouldBeNil)
+
+		interval := timeutil.TimeInterval{From: baseTime, To: baseTime.Add(time.Hour)}
+
+		contents := []string{}
+
+		var cursor int64
+
+		for {
+			r, err := SearchLogsInInterval(context.Background(), pool, interval, SearchOptions{Mode: SubstringSearch, Query: "removed", PageSize: 2, Cursor: cursor})
+			So(err, ShouldBeNil)
+
+			for _, m := range r.Matches {
+				contents = append(contents, m.Content)
+			}
+
+			if r.Cursor == 0 {
+				break
+			}
+
+			cursor = r.Cursor
+		}
+
+		So(contents, ShouldResemble, []string{
+			`Feb  4 09:00:00 mail postfix/qmgr[964]: line 2 removed`,
+			`Feb  4 09:00:00 mail postfix/qmgr[964]: line 3 removed`,
+			`Feb  4 09:01:00 mail postfix/qmgr[964]: line 0 removed`,
+			`Feb  4 09:01:00 mail postfix/qmgr[964]: line 1 removed`,
+			`Feb  4 09:02:00 mail postfix/qmgr[964]: line 4 removed`,
+		})
+	})
+}
@@ -0,0 +1,408 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package rawlogsdb
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/dbconn"
+	"gitlab.com/lightmeter/controlcenter/pkg/dbrunner"
+	"gitlab.com/lightmeter/controlcenter/pkg/postfix"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+// Lines the parser does not understand (or fails to parse) are published without payload.
+// They are grouped by process and daemon, and by a signature where the parts which change
+// from line to line (addresses, IPs, queue IDs, numbers...) are masked, keeping a few samples
+// of each, so it's easy to notice when a new Postfix version changes the format of some lines.
+
+const (
+	// MaxUnsupportedLineSamples is how many lines are kept for each signature
+	MaxUnsupportedLineSamples = 3
+
+	// MaxUnsupportedLineSignatures is how many signatures are returned in the report
+	MaxUnsupportedLineSignatures = 200
+
+	maxSignatureLen = 256
+)
+
+type signatureMask struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// NOTE: the order matters, as, for instance, the numbers in IP addresses must not be masked
+// before the IP addresses themselves
+var signatureMasks = []signatureMask{
+	{regexp.MustCompile(`[^\s<>()\[\]=,;:"']+@[^\s<>()\[\]=,;:"']+`), `<email>`},
+	{regexp.MustCompile(`[^\s<>()\[\]=,;"']*\[(?:ipv6:|IPv6:)?[0-9a-fA-F:.]*[:.][0-9a-fA-F:.]*\]`), `<host>[<ip>]`},
+	{regexp.MustCompile(`\b\d{1,3}(?:\.\d{1,3}){3}\b`), `<ip>`},
+	{regexp.MustCompile(`\b(?:[a-zA-Z0-9-]+\.)+[a-zA-Z]{2,}\b`), `<domain>`},
+	// long queue ids, as in 4Fx2Lq6jDzz4Yn9
+	{regexp.MustCompile(`\b[0-9B-DF-HJ-NP-TV-Zb-df-hj-np-tv-z]{10,15}z[0-9B-DF-HJ-NP-TV-Zb-df-hj-np-tv-z]{3,}\b`), `<id>`},
+	// short queue ids, as in 400643011B47
+	{regexp.MustCompile(`\b[0-9A-F]{6,}\b`), `<id>`},
+	{regexp.MustCompile(`\b[0-9a-f]{8,}\b`), `<id>`},
+	// numbers which are not part of words, as in base64 strings
+	{regexp.MustCompile(`(^|[^a-zA-Z0-9])\d+`), `${1}<n>`},
+}
+
+// LineSignature returns the template of the payload of a log line, masking the parts which
+// change from line to line
+func LineSignature(line string) string {
+	// the header ends on the first ": ", after the process name and id
+	if i := strings.Index(line, ": "); i >= 0 {
+		line = line[i+2:]
+	}
+
+	return maskLine(line)
+}
+
+// invalidLineSignature is the signature of a line whose header could not be parsed,
+// so it's masked as a whole
+func invalidLineSignature(line string) string {
+	return maskLine(line)
+}
+
+func maskLine(line string) string {
+	for _, m := range signatureMasks {
+		line = m.pattern.ReplaceAllString(line, m.replacement)
+	}
+
+	line = strings.TrimSpace(line)
+
+	if len(line) > maxSignatureLen {
+		line = line[:maxSignatureLen]
+	}
+
+	return line
+}
+
+// The stats are accumulated in memory by the actions of the runner, and written
+// only once per transaction, instead of upserting them on every log line.
+type lineStats struct {
+	groups map[groupKey]*groupStats
+
+	// in the order they are first seen, so they are stored in such order
+	signatures     []*signatureStats
+	signatureIndex map[signatureKey]int
+}
+
+type groupStats struct {
+	since       int64
+	total       int64
+	unsupported int64
+}
+
+type signatureKey struct {
+	groupKey
+	signature string
+}
+
+type lineSample struct {
+	time    int64
+	content string
+}
+
+type signatureStats struct {
+	key       signatureKey
+	count     int64
+	firstSeen int64
+	lastSeen  int64
+	samples   []lineSample
+}
+
+func newLineStats() *lineStats {
+	return &lineStats{
+		groups:         map[groupKey]*groupStats{},
+		signatureIndex: map[signatureKey]int{},
+	}
+}
+
+func (s *lineStats) add(key groupKey, t int64, unsupported bool, signature func() string, line string) {
+	g, ok := s.groups[key]
+	if !ok {
+		g = &groupStats{since: t}
+		s.groups[key] = g
+	}
+
+	g.total++
+
+	if !unsupported {
+		return
+	}
+
+	g.unsupported++
+
+	sigKey := signatureKey{groupKey: key, signature: signature()}
+
+	index, ok := s.signatureIndex[sigKey]
+	if !ok {
+		index = len(s.signatures)
+		s.signatureIndex[sigKey] = index
+		s.signatures = append(s.signatures, &signatureStats{key: sigKey, firstSeen: t, lastSeen: t})
+	}
+
+	sig := s.signatures[index]
+
+	sig.count++
+
+	if t > sig.lastSeen {
+		sig.lastSeen = t
+	}
+
+	if len(sig.samples) < MaxUnsupportedLineSamples {
+		sig.samples = append(sig.samples, lineSample{time: t, content: line})
+	}
+}
+
+func (s *lineStats) flush(tx *sql.Tx, stmts dbconn.TxPreparedStmts) error {
+	for key, g := range s.groups {
+		//nolint:sqlclosecheck
+		if _, err := stmts.Get(upsertLogLineStatsKey).Exec(key.process, key.daemon, g.since, g.total, g.unsupported); err != nil {
+			return errorutil.Wrap(err)
+		}
+	}
+
+	for _, sig := range s.signatures {
+		var (
+			id    int64
+			count int64
+		)
+
+		//nolint:sqlclosecheck
+		err := stmts.Get(upsertUnsupportedLineKey).QueryRow(sig.key.process, sig.key.daemon, sig.key.signature, sig.count, sig.firstSeen, sig.lastSeen).Scan(&id, &count)
+		if err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		// how many lines had been seen before this batch
+		previousCount := count - sig.count
+
+		for i, sample := range sig.samples {
+			if previousCount+int64(i) >= MaxUnsupportedLineSamples {
+				break
+			}
+
+			//nolint:sqlclosecheck
+			if _, err := stmts.Get(insertUnsupportedLineSampleKey).Exec(id, sample.time, sample.content); err != nil {
+				return errorutil.Wrap(err)
+			}
+		}
+	}
+
+	s.groups = map[groupKey]*groupStats{}
+	s.signatures = nil
+	s.signatureIndex = map[signatureKey]int{}
+
+	return nil
+}
+
+func (db *DB) LineStatsPublisher() postfix.Publisher {
+	return &lineStatsPublisher{actions: db.Actions, stats: db.lineStats}
+}
+
+type lineStatsPublisher struct {
+	actions chan<- dbrunner.Action
+	stats   *lineStats
+}
+
+func (pub *lineStatsPublisher) Publish(r postfix.Record) {
+	pub.actions <- func(*sql.Tx, dbconn.TxPreparedStmts) error {
+		key := groupKey{process: r.Header.Process, daemon: r.Header.Daemon}
+		pub.stats.add(key, r.Time.Unix(), r.Payload == nil, func() string { return LineSignature(r.Line) }, r.Line)
+		return nil
+	}
+}
+
+// PublishInvalidLine counts the lines whose header could not be parsed as unsupported,
+// in a group with no process and daemon
+func (pub *lineStatsPublisher) PublishInvalidLine(l postfix.InvalidLine) {
+	pub.actions <- func(*sql.Tx, dbconn.TxPreparedStmts) error {
+		pub.stats.add(groupKey{}, l.Time.Unix(), true, func() string { return invalidLineSignature(l.Line) }, l.Line)
+		return nil
+	}
+}
+
+type UnsupportedLineSignature struct {
+	Signature string    `json:"signature"`
+	Count     int64     `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Samples   []string  `json:"samples"`
+}
+
+type UnsupportedLinesGroup struct {
+	Process     string                     `json:"process"`
+	Daemon      string                     `json:"daemon"`
+	Since       time.Time                  `json:"since"`
+	Total       int64                      `json:"total"`
+	Unsupported int64                      `json:"unsupported"`
+	Signatures  []UnsupportedLineSignature `json:"signatures"`
+}
+
+// UnsupportedLinesReport has all the processes and daemons which have logged unsupported lines,
+// most frequent first, with the busiest signatures of each one of them.
+// The lines whose header could not be parsed are in a group with empty process and daemon.
+// Total and Unsupported are counted since the first line received, whereas the signatures
+// not seen for longer than the retention time of the logs are forgotten.
+type UnsupportedLinesReport struct {
+	Total       int64                   `json:"total"`
+	Unsupported int64                   `json:"unsupported"`
+	Groups      []UnsupportedLinesGroup `json:"groups"`
+}
+
+type groupKey struct {
+	process string
+	daemon  string
+}
+
+func FetchUnsupportedLinesReport(ctx context.Context, pool *dbconn.RoPool) (UnsupportedLinesReport, error) {
+	conn, release, err := pool.AcquireContext(ctx)
+	if err != nil {
+		return UnsupportedLinesReport{}, errorutil.Wrap(err)
+	}
+
+	defer release()
+
+	report := UnsupportedLinesReport{Groups: []UnsupportedLinesGroup{}}
+
+	groups := map[groupKey]int{}
+
+	err = func() (err error) {
+		rows, err := conn.QueryContext(ctx, `select process, daemon, since, total, unsupported from log_line_stats order by unsupported desc, process, daemon`)
+		if err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+		for rows.Next() {
+			var (
+				g     UnsupportedLinesGroup
+				since int64
+			)
+
+			if err := rows.Scan(&g.Process, &g.Daemon, &since, &g.Total, &g.Unsupported); err != nil {
+				return errorutil.Wrap(err)
+			}
+
+			report.Total += g.Total
+			report.Unsupported += g.Unsupported
+
+			if g.Unsupported == 0 {
+				continue
+			}
+
+			g.Since = time.Unix(since, 0).In(time.UTC)
+			g.Signatures = []UnsupportedLineSignature{}
+
+			groups[groupKey{process: g.Process, daemon: g.Daemon}] = len(report.Groups)
+			report.Groups = append(report.Groups, g)
+		}
+
+		if err := rows.Err(); err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		return nil
+	}()
+
+	if err != nil {
+		return UnsupportedLinesReport{}, errorutil.Wrap(err)
+	}
+
+	rows, err := conn.QueryContext(ctx, `
+		select
+			id, process, daemon, signature, count, first_seen, last_seen
+		from
+			unsupported_lines
+		order by
+			count desc, id asc
+		limit ?`, MaxUnsupportedLineSignatures)
+	if err != nil {
+		return UnsupportedLinesReport{}, errorutil.Wrap(err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id        int64
+			key       groupKey
+			s         UnsupportedLineSignature
+			firstSeen int64
+			lastSeen  int64
+		)
+
+		if err := rows.Scan(&id, &key.process, &key.daemon, &s.Signature, &s.Count, &firstSeen, &lastSeen); err != nil {
+			return UnsupportedLinesReport{}, errorutil.Wrap(err)
+		}
+
+		index, ok := groups[key]
+		if !ok {
+			continue
+		}
+
+		s.FirstSeen = time.Unix(firstSeen, 0).In(time.UTC)
+		s.LastSeen = time.Unix(lastSeen, 0).In(time.UTC)
+
+		s.Samples, err = fetchUnsupportedLineSamples(ctx, conn, id)
+		if err != nil {
+			return UnsupportedLinesReport{}, errorutil.Wrap(err)
+		}
+
+		report.Groups[index].Signatures = append(report.Groups[index].Signatures, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return UnsupportedLinesReport{}, errorutil.Wrap(err)
+	}
+
+	return report, nil
+}
+
+func fetchUnsupportedLineSamples(ctx context.Context, conn *dbconn.RoPooledConn, id int64) (samples []string, err error) {
+	rows, err := conn.QueryContext(ctx, `select content from unsupported_line_samples where signature_id = ? order by id`, id)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	samples = []string{}
+
+	for rows.Next() {
+		var sample string
+
+		if err := rows.Scan(&sample); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		samples = append(samples, sample)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return samples, nil
+}
+
+func deleteExpiredUnsupportedLines(tx *sql.Tx, oldestTimeToKeep int64) error {
+	if _, err := tx.Exec(`delete from unsupported_line_samples where signature_id in (select id from unsupported_lines where last_seen < ?)`, oldestTimeToKeep); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if _, err := tx.Exec(`delete from unsupported_lines where last_seen < ?`, oldestTimeToKeep); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,208 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package rawlogsdb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gitlab.com/lightmeter/controlcenter/pkg/postfix"
+	parser "gitlab.com/lightmeter/controlcenter/pkg/postfix/logparser"
+	"gitlab.com/lightmeter/controlcenter/pkg/runner"
+	"gitlab.com/lightmeter/controlcenter/util/testutil"
+)
+
+func TestLineSignature(t *testing.T) {
+	Convey("Line signatures mask what changes from line to line", t, func() {
+		for _, c := range []struct {
+			line      string
+			signature string
+		}{
+			{
+				`Feb  4 09:29:33 mail postfix/smtpd[964]: warning: unknown[11.22.33.44]: SASL LOGIN authentication failed: UGFzc3dvcmQ6`,
+				`warning: <host>[<ip>]: SASL LOGIN authentication failed: UGFzc3dvcmQ6`,
+			},
+			{
+				`Feb  4 09:29:33 mail postfix/smtpd[964]: warning: mail.example.com[2001:db8::1]: SASL LOGIN authentication failed`,
+				`warning: <host>[<ip>]: SASL LOGIN authentication failed`,
+			},
+			{
+				`Feb  4 09:29:33 mail postfix/cleanup[1234]: 027BD2C77B20: milter-reject: END-OF-MESSAGE from sender@example.com; 5.7.1 Spam; size=1234`,
+				`<id>: milter-reject: END-OF-MESSAGE from <email>; <n>.<n>.<n> Spam; size=<n>`,
+			},
+			{
+				`Feb  4 09:29:33 mail postfix/qmgr[964]: 4Fx2Lq6jDzz4Yn9: some new format, nrcpt=3`,
+				`<id>: some new format, nrcpt=<n>`,
+			},
+			{
+				`Feb  4 09:29:33 mail postfix/anvil[964]: statistics: max connection rate 1/60s for (smtp:1.2.3.4) at Feb  4 09:26:01`,
+				`statistics: max connection rate <n>/<n>s for (smtp:<ip>) at Feb  <n> <n>:<n>:<n>`,
+			},
+			{
+				`Feb  4 09:29:33 mail postfix/postscreen[964]: warning: hostname mx.example.com does not resolve to address 1.2.3.4`,
+				`warning: hostname <domain> does not resolve to address <ip>`,
+			},
+		} {
+			So(LineSignature(c.line), ShouldEqual, c.signature)
+		}
+	})
+}
+
+func TestUnsupportedLines(t *testing.T) {
+	Convey("Unsupported lines are counted by signature", t, func() {
+		rawLogs, _, pool, closeConn := buildContext(t)
+		defer closeConn()
+
+		pub := rawLogs.LineStatsPublisher()
+
+		done, cancel := runner.Run(rawLogs)
+
+		baseTime := testutil.MustParseTime(`2020-02-04 09:00:00 +0000`)
+
+		publish := func(line string, offset time.Duration) {
+			h, p, err := parser.Parse(line)
+			So(parser.IsRecoverableError(err), ShouldBeTrue)
+			pub.Publish(postfix.Record{Time: baseTime.Add(offset), Header: h, Payload: p, Line: line})
+		}
+
+		publish(`Feb  4 09:00:00 mail postfix/qmgr[964]: 027BD2C77B20: removed`, 0)
+
+		for i := 0; i < 5; i++ {
+			publish(fmt.Sprintf(`Feb  4 09:00:00 mail postfix/smtpd[964]: warning: unknown[11.22.33.%d]: SASL LOGIN authentication failed`, i), time.Duration(i)*time.Minute)
+		}
+
+		publish(`Feb  4 09:00:00 mail postfix/smtpd[964]: some new line with 42 parts`, 0)
+		publish(`Feb  4 09:00:00 mail dovecot: imap(user@example.com): Disconnected: Logged out in=10 out=20`, 0)
+
+		Convey("Lines are grouped by process and daemon, most frequent first", func() {
+			cancel()
+			So(done(), ShouldBeNil)
+
+			report, err := FetchUnsupportedLinesReport(context.Background(), pool)
+			So(err, ShouldBeNil)
+
+			So(report.Total, ShouldEqual, 8)
+			So(report.Unsupported, ShouldEqual, 7)
+			So(len(report.Groups), ShouldEqual, 2)
+
+			smtpd := report.Groups[0]
+			So(smtpd.Process, ShouldEqual, "postfix")
+			So(smtpd.Daemon, ShouldEqual, "smtpd")
+			So(smtpd.Total, ShouldEqual, 6)
+			So(smtpd.Unsupported, ShouldEqual, 6)
+			So(smtpd.Since, ShouldEqual, baseTime)
+
+			So(smtpd.Signatures, ShouldResemble, []UnsupportedLineSignature{
+				{
+					Signature: `warning: <host>[<ip>]: SASL LOGIN authentication failed`,
+					Count:     5,
+					FirstSeen: baseTime,
+					LastSeen:  baseTime.Add(4 * time.Minute),
+					Samples: []string{
+						`Feb  4 09:00:00 mail postfix/smtpd[964]: warning: unknown[11.22.33.0]: SASL LOGIN authentication failed`,
+						`Feb  4 09:00:00 mail postfix/smtpd[964]: warning: unknown[11.22.33.1]: SASL LOGIN authentication failed`,
+						`Feb  4 09:00:00 mail postfix/smtpd[964]: warning: unknown[11.22.33.2]: SASL LOGIN authentication failed`,
+					},
+				},
+				{
+					Signature: `some new line with <n> parts`,
+					Count:     1,
+					FirstSeen: baseTime,
+					LastSeen:  baseTime,
+					Samples:   []string{`Feb  4 09:00:00 mail postfix/smtpd[964]: some new line with 42 parts`},
+				},
+			})
+
+			dovecot := report.Groups[1]
+			So(dovecot.Process, ShouldEqual, "dovecot")
+			So(dovecot.Daemon, ShouldEqual, "")
+			So(dovecot.Unsupported, ShouldEqual, 1)
+			So(dovecot.Signatures[0].Signature, ShouldEqual, `imap(<email>): Disconnected: Logged out in=<n> out=<n>`)
+		})
+
+		Convey("Lines whose header cannot be parsed are counted in a group with no process", func() {
+			postfix.PublishInvalidLine(pub, postfix.InvalidLine{Time: baseTime.Add(time.Minute), Line: `some garbage 42`})
+			postfix.PublishInvalidLine(pub, postfix.InvalidLine{Time: baseTime.Add(2 * time.Minute), Line: `some garbage 43`})
+
+			cancel()
+			So(done(), ShouldBeNil)
+
+			report, err := FetchUnsupportedLinesReport(context.Background(), pool)
+			So(err, ShouldBeNil)
+
+			So(report.Total, ShouldEqual, 10)
+			So(report.Unsupported, ShouldEqual, 9)
+			So(len(report.Groups), ShouldEqual, 3)
+
+			invalid := report.Groups[1]
+			So(invalid.Process, ShouldEqual, "")
+			So(invalid.Daemon, ShouldEqual, "")
+			So(invalid.Total, ShouldEqual, 2)
+			So(invalid.Unsupported, ShouldEqual, 2)
+			So(invalid.Since, ShouldEqual, baseTime.Add(time.Minute))
+
+			So(invalid.Signatures, ShouldResemble, []UnsupportedLineSignature{
+				{
+					Signature: `some garbage <n>`,
+					Count:     2,
+					FirstSeen: baseTime.Add(time.Minute),
+					LastSeen:  baseTime.Add(2 * time.Minute),
+					Samples:   []string{`some garbage 42`, `some garbage 43`},
+				},
+			})
+		})
+
+		Convey("Lines are counted and sampled across many transactions", func() {
+			line := func(i int) string {
+				return fmt.Sprintf(`Feb  4 09:00:00 mail postfix/smtpd[964]: some new line with 42 parts, number %d`, i)
+			}
+
+			publish(line(1), time.Minute)
+			publish(line(2), 2*time.Minute)
+			rawLogs.Actions <- rawLogs.lineStats.flush
+			publish(line(3), 3*time.Minute)
+			publish(line(4), 4*time.Minute)
+
+			cancel()
+			So(done(), ShouldBeNil)
+
+			report, err := FetchUnsupportedLinesReport(context.Background(), pool)
+			So(err, ShouldBeNil)
+
+			So(report.Total, ShouldEqual, 12)
+			So(report.Groups[0].Total, ShouldEqual, 10)
+			So(report.Groups[0].Signatures[0].Count, ShouldEqual, 5)
+
+			s := report.Groups[0].Signatures[1]
+			So(s.Signature, ShouldEqual, `some new line with <n> parts, number <n>`)
+			So(s.Count, ShouldEqual, 4)
+			So(s.FirstSeen, ShouldEqual, baseTime.Add(time.Minute))
+			So(s.LastSeen, ShouldEqual, baseTime.Add(4*time.Minute))
+			So(s.Samples, ShouldResemble, []string{line(1), line(2), line(3)})
+		})
+
+		Convey("Signatures not seen for longer than the retention time are forgotten", func() {
+			// the cleaning action is based on the time of the most recent log line
+			rawLogs.Publisher().Publish(postfix.Record{Time: baseTime.Add(time.Hour), Line: `Feb  4 10:00:00 mail postfix/qmgr[964]: 027BD2C77B20: removed`})
+			rawLogs.Actions <- makeCleanAction(time.Hour-2*time.Minute, 10, rawLogs.fullTextIndex, rawLogs.lineStats)
+
+			cancel()
+			So(done(), ShouldBeNil)
+
+			report, err := FetchUnsupportedLinesReport(context.Background(), pool)
+			So(err, ShouldBeNil)
+
+			// the totals are kept
+			So(report.Unsupported, ShouldEqual, 7)
+			So(len(report.Groups), ShouldEqual, 2)
+			So(len(report.Groups[0].Signatures), ShouldEqual, 1)
+			So(report.Groups[0].Signatures[0].Count, ShouldEqual, 5)
+			So(len(report.Groups[1].Signatures), ShouldEqual, 0)
+		})
+	})
+}
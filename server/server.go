@@ -14,6 +14,7 @@ import (
 
 	"github.com/rs/zerolog/log"
 	"gitlab.com/lightmeter/controlcenter/api"
+	"gitlab.com/lightmeter/controlcenter/detective/enduser"
 	httpauth "gitlab.com/lightmeter/controlcenter/httpauth"
 	auth "gitlab.com/lightmeter/controlcenter/httpauth/auth"
 	"gitlab.com/lightmeter/controlcenter/httpmiddleware"
@@ -36,6 +37,7 @@ type HttpServer struct {
 	Address              string
 	FrontendDev          bool
 	IsBehindReverseProxy bool
+	Metrics              api.MetricsOptions
 }
 
 func (s *HttpServer) Start() error {
@@ -59,7 +61,7 @@ func (s *HttpServer) Start() error {
 
 	writer, reader := s.Workspace.SettingsAcessors()
 
-	setup := httpsettings.NewSettings(writer, reader, initialSetupSettings, s.Workspace.NotificationCenter, s.Workspace.InsightsEngine())
+	setup := httpsettings.NewSettings(writer, reader, initialSetupSettings, s.Workspace.NotificationCenter, s.Workspace.InsightsEngine(), s.Workspace.LocalIPs())
 
 	publicURL, err := getPublicURL(context.Background(), reader)
 
@@ -85,11 +87,19 @@ func (s *HttpServer) Start() error {
 	api.HttpDashboard(auth, mux, s.Timezone, s.Workspace.Dashboard())
 	api.HttpInsights(auth, mux, s.Timezone, s.Workspace.InsightsFetcher(), s.Workspace.InsightsEngine())
 	api.HttpInsightsProgress(auth, mux, s.Workspace.InsightsProgressFetcher())
-	api.HttpDetective(auth, mux, s.Timezone, s.Workspace.Detective(), s.Workspace.DetectiveEscalationRequester(), reader, s.IsBehindReverseProxy)
+	api.HttpBlocklist(auth, mux, s.Timezone, s.Workspace.InsightsFetcher())
+	api.HttpInsightSuppressions(auth, mux, s.Workspace.InsightsEngine())
+	api.HttpRBLHistory(auth, mux, s.Workspace.InsightsEngine())
+	api.HttpMessageRBLMatchers(auth, mux, s.Workspace.MessageRBLMatchers())
+	api.HttpDetective(auth, mux, s.Timezone, s.Workspace.Detective(), s.Workspace.DetectiveEscalationRequester(), enduser.New(enduser.NewEmailSender(reader)), reader, s.IsBehindReverseProxy)
 	api.HttpConnectionsDashboard(auth, mux, s.Timezone, s.Workspace.ConnectionStatsAccessor())
 	api.HttpReports(auth, mux, s.Timezone, s.Workspace.IntelAccessor())
 	api.HttpRawLogs(auth, mux, s.Timezone, s.Workspace.RawLogsAccessor())
 	api.HttpStatusMessage(auth, mux, s.Workspace.IntelAccessor())
+	api.HttpDataErasure(auth, mux, s.Workspace)
+	api.HttpSavedSearches(auth, mux, s.Workspace.InsightsEngine())
+	api.HttpEscalations(auth, mux, s.Workspace.InsightsEngine())
+	api.HttpMetrics(mux, s.Metrics, s.Workspace.CollectMetrics)
 
 	setup.HttpSetup(mux, auth)
 
@@ -34,15 +34,53 @@ func (t *IP) MergoFromString(s string) error {
 }
 
 type Settings struct {
-	LocalIP     IP     `json:"postfix_public_ip"`
+	LocalIP IP `json:"postfix_public_ip"`
+
+	// Other IPs used to send messages, as when each transport has its own smtp_bind_address
+	LocalIPs []IP `json:"postfix_public_ips,omitempty"`
+
 	AppLanguage string `json:"app_language"`
 	PublicURL   string `json:"public_url"`
 }
 
+// LocalIPAddresses returns all the configured IPs, the main one first
+func (s Settings) LocalIPAddresses() []net.IP {
+	ips := []net.IP{}
+
+	if s.LocalIP.IP != nil {
+		ips = append(ips, s.LocalIP.IP)
+	}
+
+	for _, ip := range s.LocalIPs {
+		ips = appendIPIfMissing(ips, ip.IP)
+	}
+
+	return ips
+}
+
+func appendIPIfMissing(ips []net.IP, ip net.IP) []net.IP {
+	if ip == nil {
+		return ips
+	}
+
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return ips
+		}
+	}
+
+	return append(ips, ip)
+}
+
 type IPAddressGetter interface {
 	IPAddress(context.Context) net.IP
 }
 
+// IPAddressesGetter returns all the local IPs used to send messages
+type IPAddressesGetter interface {
+	IPAddresses(context.Context) []net.IP
+}
+
 type MetaReaderGetter struct {
 	meta metadata.Reader
 }
@@ -65,6 +103,69 @@ func (r *MetaReaderGetter) IPAddress(ctx context.Context) net.IP {
 	return settings.LocalIP.IP
 }
 
+func (r *MetaReaderGetter) IPAddresses(ctx context.Context) []net.IP {
+	settings, err := GetSettings(ctx, r.meta)
+
+	if err != nil {
+		if !errors.Is(err, metadata.ErrNoSuchKey) {
+			errorutil.LogErrorf(errorutil.Wrap(err), "obtaining IP addresses from global settings")
+		}
+
+		return nil
+	}
+
+	return settings.LocalIPAddresses()
+}
+
+// SendingIPsDiscoverer finds the local IPs used to send messages in the logs
+type SendingIPsDiscoverer interface {
+	SendingIPs(context.Context) ([]net.IP, error)
+}
+
+// LocalIPsGetter returns the IPs set in the settings, plus the ones found in the logs
+type LocalIPsGetter struct {
+	*MetaReaderGetter
+	discoverer SendingIPsDiscoverer
+}
+
+func NewLocalIPsGetter(m metadata.Reader, discoverer SendingIPsDiscoverer) *LocalIPsGetter {
+	return &LocalIPsGetter{MetaReaderGetter: New(m), discoverer: discoverer}
+}
+
+// DiscoveredIPAddresses returns the IPs found in the logs, which might not be in the settings
+func (g *LocalIPsGetter) DiscoveredIPAddresses(ctx context.Context) []net.IP {
+	ips, err := g.discoverer.SendingIPs(ctx)
+	if err != nil {
+		errorutil.LogErrorf(errorutil.Wrap(err), "discovering sending IP addresses")
+		return nil
+	}
+
+	return ips
+}
+
+func (g *LocalIPsGetter) IPAddresses(ctx context.Context) []net.IP {
+	ips := g.MetaReaderGetter.IPAddresses(ctx)
+
+	for _, ip := range g.DiscoveredIPAddresses(ctx) {
+		ips = appendIPIfMissing(ips, ip)
+	}
+
+	return ips
+}
+
+// IPAddress returns the main IP in the settings or, if it's not set, the only IP found in the logs
+func (g *LocalIPsGetter) IPAddress(ctx context.Context) net.IP {
+	if ip := g.MetaReaderGetter.IPAddress(ctx); ip != nil {
+		return ip
+	}
+
+	if ips := g.IPAddresses(ctx); len(ips) == 1 {
+		return ips[0]
+	}
+
+	return nil
+}
+
 func checkPublicURL(u string) error {
 	if len(u) == 0 {
 		return nil
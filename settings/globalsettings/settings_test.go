@@ -50,9 +50,63 @@ func TestInitialSetup(t *testing.T) {
 				PublicURL:   "http://example.com",
 			})
 		})
+
+		Convey("Many local IPs, configured and discovered", func() {
+			m, err := metadata.NewHandler(conn)
+			So(err, ShouldBeNil)
+
+			discoverer := &fakeDiscoverer{}
+			getter := NewLocalIPsGetter(m.Reader, discoverer)
+
+			Convey("Nothing configured or discovered", func() {
+				So(getter.IPAddresses(dummyContext), ShouldBeEmpty)
+				So(getter.IPAddress(dummyContext), ShouldBeNil)
+			})
+
+			Convey("Only one IP discovered is the main one", func() {
+				discoverer.ips = []net.IP{net.ParseIP(`127.0.0.2`)}
+				So(getter.IPAddress(dummyContext), ShouldResemble, net.ParseIP(`127.0.0.2`))
+			})
+
+			Convey("Many IPs discovered, none configured", func() {
+				discoverer.ips = []net.IP{net.ParseIP(`127.0.0.2`), net.ParseIP(`2001:db8::1`)}
+				So(getter.IPAddress(dummyContext), ShouldBeNil)
+				So(getter.IPAddresses(dummyContext), ShouldResemble, discoverer.ips)
+			})
+
+			Convey("Configured and discovered IPs are merged", func() {
+				err = m.Writer.StoreJson(context.Background(), SettingsKey, Settings{
+					LocalIP:  IP{net.ParseIP(`127.0.0.1`)},
+					LocalIPs: []IP{{net.ParseIP(`127.0.0.2`)}, {net.ParseIP(`127.0.0.1`)}},
+				})
+
+				So(err, ShouldBeNil)
+
+				discoverer.ips = []net.IP{net.ParseIP(`2001:db8::1`), net.ParseIP(`127.0.0.2`)}
+
+				So(getter.IPAddress(dummyContext), ShouldResemble, net.ParseIP(`127.0.0.1`))
+				So(getter.IPAddresses(dummyContext), ShouldResemble, []net.IP{
+					net.ParseIP(`127.0.0.1`), net.ParseIP(`127.0.0.2`), net.ParseIP(`2001:db8::1`),
+				})
+			})
+
+			Convey("Discovery fails", func() {
+				discoverer.err = errors.New("some error")
+				So(getter.IPAddresses(dummyContext), ShouldBeEmpty)
+			})
+		})
 	})
 }
 
+type fakeDiscoverer struct {
+	ips []net.IP
+	err error
+}
+
+func (d *fakeDiscoverer) SendingIPs(context.Context) ([]net.IP, error) {
+	return d.ips, d.err
+}
+
 func TestSettingsFromDefaultValues(t *testing.T) {
 	Convey("Retrieve from default values", t, func() {
 		conn, closeConn := testutil.TempDBConnectionMigrated(t, "master")
@@ -0,0 +1,212 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package insights
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A CustomRule generates an insight when the value of a metric, computed over the deliveries
+// in the last Window minutes, satisfies the condition, for instance:
+// "deferred to domain X > 50 in 15 minutes", "received from sender Y == 0 for 2 hours"
+// or "bounce rate for mailbox Z > 10%".
+type CustomRule struct {
+	Name string `json:"name"`
+
+	Metric    RuleMetric   `json:"metric"`
+	Target    RuleTarget   `json:"target"`
+	Value     string       `json:"value"`
+	Operator  RuleOperator `json:"operator"`
+	Threshold float64      `json:"threshold"`
+
+	// in minutes
+	Window int `json:"window"`
+
+	Category string `json:"category"`
+	Priority string `json:"priority"`
+
+	// minimum time, in minutes, between two insights generated by the rule
+	Cooldown int `json:"cooldown"`
+}
+
+type RuleMetric string
+
+const (
+	// outbound messages, by status
+	SentMetric     RuleMetric = "sent"
+	BouncedMetric  RuleMetric = "bounced"
+	DeferredMetric RuleMetric = "deferred"
+	ExpiredMetric  RuleMetric = "expired"
+
+	// inbound messages
+	ReceivedMetric RuleMetric = "received"
+
+	// percentage of the outbound messages which bounced
+	BounceRateMetric RuleMetric = "bounce_rate"
+)
+
+// RuleTarget restricts which deliveries are taken into account.
+// It's compared with Value, which is either an e-mail address or a domain
+type RuleTarget string
+
+const (
+	AllTraffic RuleTarget = ""
+
+	// the remote domain: the recipient one for outbound messages, and the sender one for inbound ones
+	DomainTarget RuleTarget = "domain"
+
+	// the local mailbox: the sender of outbound messages, and the recipient of inbound ones
+	MailboxTarget RuleTarget = "mailbox"
+
+	SenderTarget    RuleTarget = "sender"
+	RecipientTarget RuleTarget = "recipient"
+)
+
+type RuleOperator string
+
+const (
+	GreaterThan        RuleOperator = ">"
+	GreaterThanOrEqual RuleOperator = ">="
+	LessThan           RuleOperator = "<"
+	LessThanOrEqual    RuleOperator = "<="
+	Equal              RuleOperator = "=="
+)
+
+func (o RuleOperator) Compare(value, threshold float64) bool {
+	switch o {
+	case GreaterThan:
+		return value > threshold
+	case GreaterThanOrEqual:
+		return value >= threshold
+	case LessThan:
+		return value < threshold
+	case LessThanOrEqual:
+		return value <= threshold
+	case Equal:
+		return value == threshold
+	}
+
+	return false
+}
+
+const (
+	MaxCustomRules      = 50
+	MaxRuleWindow       = 60 * 24 * 7
+	MaxRuleCooldown     = 60 * 24 * 30
+	DefaultRuleCategory = "local"
+	DefaultRulePriority = "bad"
+)
+
+var ErrInvalidCustomRule = errors.New(`Invalid custom insight rule`)
+
+func invalidRule(r CustomRule, format string, args ...interface{}) error {
+	return fmt.Errorf("%w %q: %s", ErrInvalidCustomRule, r.Name, fmt.Sprintf(format, args...))
+}
+
+// Validate checks the rule, filling in the default category and priority if they're missing
+func (r *CustomRule) Validate() error {
+	r.Name = strings.TrimSpace(r.Name)
+
+	if len(r.Name) == 0 {
+		return fmt.Errorf("%w: the name is missing", ErrInvalidCustomRule)
+	}
+
+	switch r.Metric {
+	case SentMetric, BouncedMetric, DeferredMetric, ExpiredMetric, ReceivedMetric:
+	case BounceRateMetric:
+		if r.Threshold > 100 {
+			return invalidRule(*r, "bounce rate is a percentage")
+		}
+	default:
+		return invalidRule(*r, "unknown metric %q", r.Metric)
+	}
+
+	switch r.Target {
+	case AllTraffic:
+		r.Value = ""
+	case DomainTarget, MailboxTarget, SenderTarget, RecipientTarget:
+		r.Value = strings.ToLower(strings.TrimSpace(r.Value))
+
+		if len(r.Value) == 0 {
+			return invalidRule(*r, "the target value is missing")
+		}
+
+		if r.Target == DomainTarget && strings.Contains(r.Value, "@") {
+			return invalidRule(*r, "%q is not a domain", r.Value)
+		}
+
+		if r.Target == MailboxTarget && !strings.Contains(r.Value, "@") {
+			return invalidRule(*r, "%q is not an e-mail address", r.Value)
+		}
+	default:
+		return invalidRule(*r, "unknown target %q", r.Target)
+	}
+
+	switch r.Operator {
+	case GreaterThan, GreaterThanOrEqual, LessThan, LessThanOrEqual, Equal:
+	default:
+		return invalidRule(*r, "unknown operator %q", r.Operator)
+	}
+
+	if r.Threshold < 0 {
+		return invalidRule(*r, "the threshold must not be negative")
+	}
+
+	if r.Window < 1 || r.Window > MaxRuleWindow {
+		return invalidRule(*r, "the window must be between 1 and %d minutes", MaxRuleWindow)
+	}
+
+	if r.Cooldown < 0 || r.Cooldown > MaxRuleCooldown {
+		return invalidRule(*r, "the cooldown must be between 0 and %d minutes", MaxRuleCooldown)
+	}
+
+	if len(r.Category) == 0 {
+		r.Category = DefaultRuleCategory
+	}
+
+	switch r.Category {
+	case "local", "comparative", "news", "intel":
+	default:
+		return invalidRule(*r, "unknown category %q", r.Category)
+	}
+
+	if len(r.Priority) == 0 {
+		r.Priority = DefaultRulePriority
+	}
+
+	switch r.Priority {
+	case "bad", "ok", "good":
+	default:
+		return invalidRule(*r, "unknown priority %q", r.Priority)
+	}
+
+	return nil
+}
+
+// ValidateCustomRules validates all the rules, which must have distinct names,
+// as they're used to know when each one of them last generated an insight
+func ValidateCustomRules(rules []CustomRule) error {
+	if len(rules) > MaxCustomRules {
+		return fmt.Errorf("%w: at most %d rules are supported", ErrInvalidCustomRule, MaxCustomRules)
+	}
+
+	names := map[string]struct{}{}
+
+	for i := range rules {
+		if err := rules[i].Validate(); err != nil {
+			return err
+		}
+
+		if _, ok := names[rules[i].Name]; ok {
+			return invalidRule(rules[i], "the name is already used")
+		}
+
+		names[rules[i].Name] = struct{}{}
+	}
+
+	return nil
+}
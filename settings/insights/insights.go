@@ -20,6 +20,18 @@ type Settings struct {
 	// Mail inactivity settings
 	MailInactivityLookupRange int `json:"mail_inactivity_lookup_range"`
 	MailInactivityMinInterval int `json:"mail_inactivity_min_interval"`
+
+	CustomRules []CustomRule `json:"custom_rules,omitempty"`
+
+	// Interval, in hours, between checks of the sending domains on domain blocklists.
+	// If zero, the default interval is used
+	DomainBlocklistCheckInterval int `json:"domain_blocklist_check_interval,omitempty"`
+
+	// Messages stuck in the queue insight: how long, in hours, messages must have been deferred for
+	// to be reported, and, in days, the maximal_queue_lifetime configured in postfix.
+	// If zero, the defaults are used
+	StuckQueuesMinAge               int `json:"stuck_queues_min_age,omitempty"`
+	StuckQueuesMaximalQueueLifetime int `json:"stuck_queues_maximal_queue_lifetime,omitempty"`
 }
 
 const SettingsKey = "insights"
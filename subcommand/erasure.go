@@ -0,0 +1,80 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package subcommand
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gitlab.com/lightmeter/controlcenter/erasure"
+	"gitlab.com/lightmeter/controlcenter/logeater/announcer"
+	"gitlab.com/lightmeter/controlcenter/pkg/runner"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/workspace"
+)
+
+func eraseData(ws *workspace.Workspace, target erasure.Target) (erasure.Report, error) {
+	// needed to prevent the insights execution of blocking, as no logs will be imported
+	importAnnouncer, err := ws.ImportAnnouncer()
+	if err != nil {
+		return erasure.Report{}, errorutil.Wrap(err)
+	}
+
+	announcer.Skip(importAnnouncer)
+
+	done, cancel := runner.Run(ws)
+
+	ctx, cancelCtx := context.WithTimeout(context.Background(), time.Minute*10)
+	defer cancelCtx()
+
+	report, eraseErr := ws.EraseData(ctx, target)
+
+	cancel()
+
+	if err := done(); err != nil {
+		return erasure.Report{}, errorutil.Wrap(err)
+	}
+
+	if eraseErr != nil {
+		return erasure.Report{}, errorutil.Wrap(eraseErr)
+	}
+
+	return report, nil
+}
+
+// PerformDataErasure removes all data about an e-mail address or domain from the workspace,
+// writing the audit report to w. It must not be used while the application is running.
+func PerformDataErasure(workspaceDirectory string, options *workspace.Options, rawTarget string, w io.Writer) {
+	target, err := erasure.ParseTarget(rawTarget)
+	if err != nil {
+		errorutil.Dief(errorutil.Wrap(err), "Invalid data erasure target")
+	}
+
+	ws, err := workspace.NewWorkspace(workspaceDirectory, options)
+	if err != nil {
+		errorutil.Dief(errorutil.Wrap(err), "Error opening workspace")
+	}
+
+	defer func() {
+		errorutil.MustSucceed(ws.Close())
+	}()
+
+	report, err := eraseData(ws, target)
+	if err != nil {
+		errorutil.Dief(errorutil.Wrap(err), "Error erasing data")
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(report); err != nil {
+		errorutil.Dief(errorutil.Wrap(err), "Error writing erasure report")
+	}
+
+	log.Info().Msgf("Data erasure of %s successfully performed", target)
+}
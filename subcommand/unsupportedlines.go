@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package subcommand
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/dbconn"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/migrator"
+	"gitlab.com/lightmeter/controlcenter/rawlogsdb"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+func percentage(part, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+
+	return float64(part) * 100 / float64(total)
+}
+
+func processName(g rawlogsdb.UnsupportedLinesGroup) string {
+	if len(g.Process) == 0 && len(g.Daemon) == 0 {
+		return "lines with no valid header"
+	}
+
+	if len(g.Daemon) == 0 {
+		return g.Process
+	}
+
+	return g.Process + "/" + g.Daemon
+}
+
+func writeUnsupportedLinesReport(w io.Writer, report rawlogsdb.UnsupportedLinesReport) error {
+	if _, err := fmt.Fprintf(w, "%d of %d log lines (%.2f%%) could not be parsed\n",
+		report.Unsupported, report.Total, percentage(report.Unsupported, report.Total)); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	for _, g := range report.Groups {
+		if _, err := fmt.Fprintf(w, "\n%s: %d of %d lines (%.2f%%) since %s\n",
+			processName(g), g.Unsupported, g.Total, percentage(g.Unsupported, g.Total), g.Since.Format(time.RFC3339)); err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		for _, s := range g.Signatures {
+			if _, err := fmt.Fprintf(w, "  %d\t%s\n  \tfirst seen: %s, last seen: %s\n",
+				s.Count, s.Signature, s.FirstSeen.Format(time.RFC3339), s.LastSeen.Format(time.RFC3339)); err != nil {
+				return errorutil.Wrap(err)
+			}
+
+			for _, sample := range s.Samples {
+				if _, err := fmt.Fprintf(w, "  \tsample: %s\n", sample); err != nil {
+					return errorutil.Wrap(err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// PrintUnsupportedLogLinesReport writes to w which log lines could not be parsed,
+// so it's possible to notice when Postfix changes the format of its logs.
+func PrintUnsupportedLogLinesReport(workspaceDirectory string, w io.Writer) {
+	connPair, err := dbconn.Open(path.Join(workspaceDirectory, "rawlogs.db"), 1)
+	if err != nil {
+		errorutil.Dief(errorutil.Wrap(err), "Error opening raw logs database")
+	}
+
+	defer connPair.Close()
+
+	if err := migrator.Run(connPair.RwConn.DB, "rawlogs"); err != nil {
+		errorutil.Dief(errorutil.Wrap(err), "Error migrating raw logs database")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	report, err := rawlogsdb.FetchUnsupportedLinesReport(ctx, connPair.RoConnPool)
+	if err != nil {
+		errorutil.Dief(errorutil.Wrap(err), "Error fetching unsupported log lines")
+	}
+
+	if err := writeUnsupportedLinesReport(w, report); err != nil {
+		errorutil.Dief(errorutil.Wrap(err), "Error writing unsupported log lines report")
+	}
+}
@@ -283,7 +283,16 @@ func cloneAction(tx *sql.Tx, r postfix.Record, handler NodeTypeHandler, trackerS
 		return errorutil.Wrap(err)
 	}
 
-	if _, err := handler.CreateQueue(r.Time, connectionId, p.Queue, r.Location, trackerStmts); err != nil {
+	queueId, err := handler.CreateQueue(r.Time, connectionId, p.Queue, r.Location, trackerStmts)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	if len(p.SASLUsername) == 0 {
+		return nil
+	}
+
+	if err := insertQueueDataValues(trackerStmts, queueId, kvData{key: QueueSASLUsernameKey, value: p.SASLUsername}); err != nil {
 		return errorutil.Wrap(err)
 	}
 
@@ -562,6 +571,13 @@ func addResultData(trackerStmts dbconn.TxPreparedStmts, time time.Time, loc post
 		return errorutil.Wrap(err)
 	}
 
+	// as in postfix-1.2.3.4/smtp, usually set per transport, with different smtp_bind_address
+	if h.ProcessIP != nil {
+		if err := insertResultDataValues(trackerStmts, resultId, kvData{key: ResultSendingIPKey, value: h.ProcessIP}); err != nil {
+			return errorutil.Wrap(err)
+		}
+	}
+
 	// The relay info might be missing, and that's fine
 	if p.RelayIP == nil {
 		return nil
@@ -736,6 +752,11 @@ func rejectAction(tx *sql.Tx, r postfix.Record, handler NodeTypeHandler, tracker
 	//nolint:forcetypeassert
 	p := r.Payload.(parser.SmtpdReject)
 
+	// rejected before being queued, so nothing is being tracked
+	if len(p.Queue) == 0 {
+		return nil
+	}
+
 	queueId, err := findQueueIdFromQueueValue(p.Queue, trackerStmts)
 
 	if err != nil && errors.Is(err, sql.ErrNoRows) {
@@ -0,0 +1,189 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package tracking
+
+import (
+	"context"
+	"database/sql"
+
+	"gitlab.com/lightmeter/controlcenter/erasure"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/dbconn"
+	"gitlab.com/lightmeter/controlcenter/pkg/postfix"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+// The tracker stores only the messages still "in flight", and we cannot simply delete them,
+// otherwise the following log lines about them would be considered inconsistent.
+// Instead we anonymise the addresses, so that also the final results are anonymised once delivered.
+
+type addressKeys struct {
+	table     string
+	idColumn  string
+	localKey  int
+	domainKey int
+}
+
+var addressKeysToErase = []addressKeys{
+	{table: "queue_data", idColumn: "queue_id", localKey: QueueSenderLocalPartKey, domainKey: QueueSenderDomainPartKey},
+	{table: "result_data", idColumn: "result_id", localKey: ResultRecipientLocalPartKey, domainKey: ResultRecipientDomainPartKey},
+	{table: "result_data", idColumn: "result_id", localKey: ResultOrigRecipientLocalPartKey, domainKey: ResultOrigRecipientDomainPartKey},
+}
+
+func anonymiseAddressKeys(tx *sql.Tx, keys addressKeys, target erasure.Target) (int64, error) {
+	// NOTE: table and column names come from the constant list above, so it's safe to build the query with them
+	ownersQuery := `select d.` + keys.idColumn + ` from ` + keys.table + ` d where d.key = @domainKey and d.value = @domain collate nocase`
+
+	if !target.IsDomain() {
+		ownersQuery = `select d.` + keys.idColumn + ` from ` + keys.table + ` d join ` + keys.table + ` l on l.` + keys.idColumn + ` = d.` + keys.idColumn + `
+			where d.key = @domainKey and d.value = @domain collate nocase and l.key = @localKey and l.value = @local collate nocase`
+	}
+
+	query := `update ` + keys.table + ` set value = @placeholder where key in (@localKey, @domainKey) and ` + keys.idColumn + ` in (` + ownersQuery + `)`
+
+	r, err := tx.Exec(query,
+		sql.Named("placeholder", erasure.Placeholder),
+		sql.Named("localKey", keys.localKey),
+		sql.Named("domainKey", keys.domainKey),
+		sql.Named("local", target.LocalPart),
+		sql.Named("domain", target.Domain),
+	)
+
+	if err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	return erasure.CountAffected(r)
+}
+
+func anonymiseRelayedBounces(tx *sql.Tx, target erasure.Target) (count int64, err error) {
+	rows, err := tx.Query(`select id, value from queue_data where key = ? and value like ?`, QueueRelayedBounceJsonKey, target.LikePattern())
+	if err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	type entry struct {
+		id    int64
+		value string
+	}
+
+	entries := []entry{}
+
+	matcher := target.Matcher()
+
+	for rows.Next() {
+		var e entry
+
+		if err := rows.Scan(&e.id, &e.value); err != nil {
+			return 0, errorutil.Wrap(err)
+		}
+
+		if anonymised := matcher.Anonymise(e.value); anonymised != e.value {
+			entries = append(entries, entry{id: e.id, value: anonymised})
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	for _, e := range entries {
+		// the value is stored as a blob, and must be kept like that
+		if _, err := tx.Exec(`update queue_data set value = ? where id = ?`, []byte(e.value), e.id); err != nil {
+			return 0, errorutil.Wrap(err)
+		}
+	}
+
+	return int64(len(entries)), nil
+}
+
+// anonymiseSASLUsernames anonymises the usernames which are addresses of target,
+// as it's common to use the e-mail address as the username
+func anonymiseSASLUsernames(tx *sql.Tx, target erasure.Target) (int64, error) {
+	r, err := func() (sql.Result, error) {
+		if target.IsDomain() {
+			return tx.Exec(`update queue_data set value = ? where key = ? and value like ?`, erasure.Placeholder, QueueSASLUsernameKey, "%@"+target.Domain)
+		}
+
+		return tx.Exec(`update queue_data set value = ? where key = ? and value = ? collate nocase`, erasure.Placeholder, QueueSASLUsernameKey, target.String())
+	}()
+	if err != nil {
+		return 0, errorutil.Wrap(err)
+	}
+
+	return erasure.CountAffected(r)
+}
+
+func eraseTarget(tx *sql.Tx, target erasure.Target) (erasure.Entries, error) {
+	counts := map[string]int64{}
+
+	for _, keys := range addressKeysToErase {
+		n, err := anonymiseAddressKeys(tx, keys, target)
+		if err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		counts[keys.table] += n
+	}
+
+	n, err := anonymiseRelayedBounces(tx, target)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	counts["queue_data"] += n
+
+	n, err = anonymiseSASLUsernames(tx, target)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	counts["queue_data"] += n
+
+	return erasure.Entries{
+		{Database: "logtracker", Table: "queue_data", Action: erasure.AnonymisedAction, Count: counts["queue_data"]},
+		{Database: "logtracker", Table: "result_data", Action: erasure.AnonymisedAction, Count: counts["result_data"]},
+	}, nil
+}
+
+type erasureResult struct {
+	entries erasure.Entries
+	err     error
+}
+
+// Erase anonymises the addresses of target in the messages currently being tracked.
+// It's executed by the tracker itself, as it's the only one allowed to write to its database.
+func (t *Tracker) Erase(ctx context.Context, target erasure.Target) (erasure.Entries, error) {
+	resultChan := make(chan erasureResult, 1)
+
+	action := func(tx *sql.Tx, _ postfix.Record, _ NodeTypeHandler, _ dbconn.TxPreparedStmts) error {
+		entries, err := erasure.InSavepoint(tx, func(tx *sql.Tx) (erasure.Entries, error) {
+			return eraseTarget(tx, target)
+		})
+
+		resultChan <- erasureResult{entries: entries, err: err}
+
+		// a failed erasure must not stop the tracker
+		return nil
+	}
+
+	select {
+	case t.actions <- actionTuple{action: action}:
+	case <-ctx.Done():
+		return nil, errorutil.Wrap(ctx.Err())
+	}
+
+	select {
+	case r := <-resultChan:
+		if r.err != nil {
+			return nil, errorutil.Wrap(r.err)
+		}
+
+		return r.entries, nil
+	case <-ctx.Done():
+		return nil, errorutil.Wrap(ctx.Err())
+	}
+}
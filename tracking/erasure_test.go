@@ -0,0 +1,109 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package tracking
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gitlab.com/lightmeter/controlcenter/erasure"
+	"gitlab.com/lightmeter/controlcenter/pkg/runner"
+)
+
+func TestErasure(t *testing.T) {
+	Convey("Erase addresses of messages being tracked", t, func() {
+		pub, tracker, clear := buildSingleNodePublisherAndTempTracker(t)
+		defer clear()
+
+		content, err := ioutil.ReadFile("../test_files/postfix_logs/individual_files/1_bounce_simple.log")
+		So(err, ShouldBeNil)
+
+		lines := strings.SplitAfter(string(content), "\n")
+
+		// the message and its re-injection by the content filter are queued, but not yet delivered
+		before, after := strings.Join(lines[:12], ""), strings.Join(lines[12:], "")
+
+		done, cancel := runner.Run(tracker)
+
+		erase := func(s string) erasure.Entries {
+			target, err := erasure.ParseTarget(s)
+			So(err, ShouldBeNil)
+
+			entries, err := tracker.Erase(context.Background(), target)
+			So(err, ShouldBeNil)
+
+			return entries
+		}
+
+		countEntry := func(entries erasure.Entries, table string) int64 {
+			for _, e := range entries {
+				if e.Table == table {
+					return e.Count
+				}
+			}
+
+			return -1
+		}
+
+		Convey("The sender address is anonymised in the delivery", func() {
+			readFromTestContent(before, tracker.Publisher())
+
+			entries := erase("User@Sender.com")
+
+			readFromTestContent(after, tracker.Publisher())
+
+			cancel()
+			So(done(), ShouldBeNil)
+
+			So(countEntry(entries, "queue_data"), ShouldEqual, 5)
+			So(countEntry(entries, "result_data"), ShouldEqual, 0)
+
+			So(len(pub.results), ShouldEqual, 2)
+			So(pub.results[0][QueueSenderLocalPartKey].Text(), ShouldEqual, erasure.Placeholder)
+			So(pub.results[0][QueueSenderDomainPartKey].Text(), ShouldEqual, erasure.Placeholder)
+			So(pub.results[0][QueueSASLUsernameKey].Text(), ShouldEqual, erasure.Placeholder)
+			So(pub.results[0][ResultRecipientLocalPartKey].Text(), ShouldEqual, "invalid.email")
+			So(pub.results[0][ResultRecipientDomainPartKey].Text(), ShouldEqual, "example.com")
+		})
+
+		Convey("Erasing a domain anonymises all its addresses", func() {
+			readFromTestContent(before, tracker.Publisher())
+
+			entries := erase("sender.com")
+
+			readFromTestContent(after, tracker.Publisher())
+
+			cancel()
+			So(done(), ShouldBeNil)
+
+			So(countEntry(entries, "queue_data"), ShouldEqual, 5)
+
+			So(len(pub.results), ShouldEqual, 2)
+			So(pub.results[0][QueueSenderLocalPartKey].Text(), ShouldEqual, erasure.Placeholder)
+			So(pub.results[0][QueueSenderDomainPartKey].Text(), ShouldEqual, erasure.Placeholder)
+		})
+
+		Convey("Other addresses are kept", func() {
+			readFromTestContent(before, tracker.Publisher())
+
+			entries := erase("someone@sender.com")
+
+			readFromTestContent(after, tracker.Publisher())
+
+			cancel()
+			So(done(), ShouldBeNil)
+
+			So(countEntry(entries, "queue_data"), ShouldEqual, 0)
+
+			So(len(pub.results), ShouldEqual, 2)
+			So(pub.results[0][QueueSenderLocalPartKey].Text(), ShouldEqual, "user")
+			So(pub.results[0][QueueSenderDomainPartKey].Text(), ShouldEqual, "sender.com")
+			So(pub.results[0][QueueSASLUsernameKey].Text(), ShouldEqual, "user@sender.com")
+		})
+	})
+}
@@ -76,6 +76,12 @@ const (
 
 	ResultSentRemoteID
 
+	// the local IP used to send the message, when postfix logs it in its process name
+	ResultSendingIPKey
+
+	// the username the client authenticated with, when it did
+	QueueSASLUsernameKey
+
 	lastResultKey
 )
 
@@ -141,5 +147,7 @@ var (
 		QueueRelayedBounceJsonKey:       "queue_relayed_bounce_json_key",
 		QueueReferencesHeaderKey:        "queue_references_header",
 		ResultSentRemoteID:              "result_sent_remote_id",
+		ResultSendingIPKey:              "result_sending_ip",
+		QueueSASLUsernameKey:            "queue_sasl_username",
 	}
 )
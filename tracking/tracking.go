@@ -5,6 +5,7 @@
 package tracking
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"reflect"
@@ -117,6 +118,76 @@ func (t *Tracker) MostRecentLogTime() (time.Time, error) {
 	return time.Unix(v, 0).In(time.UTC), nil
 }
 
+// InFlight describes the state still being tracked, which has not yet been turned into results
+type InFlight struct {
+	Connections    int64
+	Queues         int64
+	Results        int64
+	PendingActions int64
+}
+
+func (t *Tracker) InFlight(ctx context.Context) (InFlight, error) {
+	conn, release, err := t.dbconn.RoConnPool.AcquireContext(ctx)
+	if err != nil {
+		return InFlight{}, errorutil.Wrap(err)
+	}
+
+	defer release()
+
+	inFlight := InFlight{PendingActions: int64(len(t.actions))}
+
+	for _, c := range []struct {
+		table string
+		value *int64
+	}{
+		{"connections", &inFlight.Connections},
+		{"queues", &inFlight.Queues},
+		{"results", &inFlight.Results},
+	} {
+		//nolint:gosec
+		if err := conn.QueryRowContext(ctx, `select count(*) from `+c.table).Scan(c.value); err != nil {
+			return InFlight{}, errorutil.Wrap(err)
+		}
+	}
+
+	return inFlight, nil
+}
+
+// InFlightQueues returns the names of the queues still being tracked, as postfix has not removed them yet
+func (t *Tracker) InFlightQueues(ctx context.Context) (queues map[string]struct{}, err error) {
+	conn, release, err := t.dbconn.RoConnPool.AcquireContext(ctx)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer release()
+
+	rows, err := conn.QueryContext(ctx, `select distinct queue from queues`)
+	if err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	defer errorutil.UpdateErrorFromCloser(rows, &err)
+
+	queues = map[string]struct{}{}
+
+	for rows.Next() {
+		var queue string
+
+		if err := rows.Scan(&queue); err != nil {
+			return nil, errorutil.Wrap(err)
+		}
+
+		queues[queue] = struct{}{}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errorutil.Wrap(err)
+	}
+
+	return queues, nil
+}
+
 func (t *Tracker) Publisher() *Publisher {
 	return &Publisher{actions: t.actions}
 }
@@ -276,6 +276,7 @@ func TestSingleNodeTrackingFromFiles(t *testing.T) {
 					So(pub.results[0][ConnectionClientHostnameKey].Text(), ShouldEqual, "some.domain.name")
 					So(pub.results[0][QueueSenderLocalPartKey].Text(), ShouldEqual, "user")
 					So(pub.results[0][QueueSenderDomainPartKey].Text(), ShouldEqual, "sender.com")
+					So(pub.results[0][QueueSASLUsernameKey].Text(), ShouldEqual, "user@sender.com")
 					So(pub.results[0][QueueMessageIDKey].Text(), ShouldEqual, "ca10035e-2951-bfd5-ec7e-1a5773fce1cd@mail.sender.com")
 					So(pub.results[0][QueueOriginalMessageSizeKey].Int64(), ShouldEqual, 391)
 					So(pub.results[0][QueueProcessedMessageSizeKey].Int64(), ShouldEqual, 1111)
@@ -824,6 +825,7 @@ func TestMultiNodeTrackingFromFiles(t *testing.T) {
 					So(pub.results[0][ConnectionClientHostnameKey].Text(), ShouldEqual, "some.domain.name")
 					So(pub.results[0][QueueSenderLocalPartKey].Text(), ShouldEqual, "user")
 					So(pub.results[0][QueueSenderDomainPartKey].Text(), ShouldEqual, "sender.com")
+					So(pub.results[0][QueueSASLUsernameKey].Text(), ShouldEqual, "user@sender.com")
 					So(pub.results[0][QueueMessageIDKey].Text(), ShouldEqual, "ca10035e-2951-bfd5-ec7e-1a5773fce1cd@mail.sender.com")
 					So(pub.results[0][QueueOriginalMessageSizeKey].Int64(), ShouldEqual, 391)
 					So(pub.results[0][QueueProcessedMessageSizeKey].Int64(), ShouldEqual, 1111)
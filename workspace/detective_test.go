@@ -10,6 +10,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"testing"
 	"time"
@@ -115,30 +116,46 @@ func TestDetective(t *testing.T) {
 			defer clear()
 
 			Convey("Message found", func() {
-				messagesLowerCase, err := d.CheckMessageDelivery(bg, "sender@example.com", "recipient@example.com", correctInterval, -1, "", 1, limit)
+				messagesLowerCase, err := d.CheckMessageDelivery(bg, "sender@example.com", "recipient@example.com", correctInterval, -1, "", detective.Filters{}, 1, limit)
 				So(err, ShouldBeNil)
 
-				messagesMixedCase, err := d.CheckMessageDelivery(bg, "Sender@eXamplE.com", "ReciPient@Example.COM", correctInterval, -1, "", 1, limit)
+				messagesMixedCase, err := d.CheckMessageDelivery(bg, "Sender@eXamplE.com", "ReciPient@Example.COM", correctInterval, -1, "", detective.Filters{}, 1, limit)
 				So(err, ShouldBeNil)
 
 				// working partial searches
-				messagesPartialSearch1, err := d.CheckMessageDelivery(bg, "example.com", "recipient@example.com", correctInterval, -1, "", 1, limit)
+				messagesPartialSearch1, err := d.CheckMessageDelivery(bg, "example.com", "recipient@example.com", correctInterval, -1, "", detective.Filters{}, 1, limit)
 				So(err, ShouldBeNil)
 
-				messagesPartialSearch2, err := d.CheckMessageDelivery(bg, "@example.com", "example.com", correctInterval, -1, "", 1, limit)
+				messagesPartialSearch2, err := d.CheckMessageDelivery(bg, "@example.com", "example.com", correctInterval, -1, "", detective.Filters{}, 1, limit)
 				So(err, ShouldBeNil)
 
-				messagesPartialSearch3, err := d.CheckMessageDelivery(bg, "", "@example.com", correctInterval, -1, "", 1, limit)
+				messagesPartialSearch3, err := d.CheckMessageDelivery(bg, "", "@example.com", correctInterval, -1, "", detective.Filters{}, 1, limit)
 				So(err, ShouldBeNil)
 
-				messagesPartialSearch4, err := d.CheckMessageDelivery(bg, "", "", correctInterval, -1, "", 1, limit)
+				messagesPartialSearch4, err := d.CheckMessageDelivery(bg, "", "", correctInterval, -1, "", detective.Filters{}, 1, limit)
 				So(err, ShouldBeNil)
 
 				// partial searches with no results
-				messagesPartialSearch5, err := d.CheckMessageDelivery(bg, "@test.org", "", correctInterval, -1, "", 1, limit)
+				messagesPartialSearch5, err := d.CheckMessageDelivery(bg, "@test.org", "", correctInterval, -1, "", detective.Filters{}, 1, limit)
 				So(err, ShouldBeNil)
 
-				messagesPartialSearch6, err := d.CheckMessageDelivery(bg, "", "@domain.org", correctInterval, -1, "", 1, limit)
+				messagesPartialSearch6, err := d.CheckMessageDelivery(bg, "", "@domain.org", correctInterval, -1, "", detective.Filters{}, 1, limit)
+				So(err, ShouldBeNil)
+
+				// pattern searches
+				messagesPatternSearch1, err := d.CheckMessageDelivery(bg, "*@example.com", "rec*@EXAMPLE.*", correctInterval, -1, "", detective.Filters{}, 1, limit)
+				So(err, ShouldBeNil)
+
+				messagesPatternSearch2, err := d.CheckMessageDelivery(bg, "s?nder@.com", "recipient@.example.com", correctInterval, -1, "", detective.Filters{}, 1, limit)
+				So(err, ShouldBeNil)
+
+				messagesPatternSearch3, err := d.CheckMessageDelivery(bg, "*@*.example.com", "", correctInterval, -1, "", detective.Filters{}, 1, limit)
+				So(err, ShouldBeNil)
+
+				messagesPatternSearch4, err := d.CheckMessageDelivery(bg, "send_r@example.com", "", correctInterval, -1, "", detective.Filters{}, 1, limit)
+				So(err, ShouldBeNil)
+
+				messagesPatternSearch5, err := d.CheckMessageDelivery(bg, "newsletter-*@*", "", correctInterval, -1, "", detective.Filters{}, 1, limit)
 				So(err, ShouldBeNil)
 
 				queueID := "400643011B47"
@@ -148,19 +165,19 @@ func TestDetective(t *testing.T) {
 				wrongMessageID := "1234-abcd@example.com"
 
 				// someID searches
-				messagesMailFromToAndQueueID, err := d.CheckMessageDelivery(bg, "example.com", "recipient@example.com", correctInterval, -1, queueID, 1, limit)
+				messagesMailFromToAndQueueID, err := d.CheckMessageDelivery(bg, "example.com", "recipient@example.com", correctInterval, -1, queueID, detective.Filters{}, 1, limit)
 				So(err, ShouldBeNil)
 
-				messagesQueueID, err := d.CheckMessageDelivery(bg, "", "", correctInterval, -1, queueID, 1, limit)
+				messagesQueueID, err := d.CheckMessageDelivery(bg, "", "", correctInterval, -1, queueID, detective.Filters{}, 1, limit)
 				So(err, ShouldBeNil)
 
-				messagesWrongQueueID, err := d.CheckMessageDelivery(bg, "", "", correctInterval, -1, wrongQueueID, 1, limit)
+				messagesWrongQueueID, err := d.CheckMessageDelivery(bg, "", "", correctInterval, -1, wrongQueueID, detective.Filters{}, 1, limit)
 				So(err, ShouldBeNil)
 
-				messagesMessageID, err := d.CheckMessageDelivery(bg, "", "", correctInterval, -1, messageID, 1, limit)
+				messagesMessageID, err := d.CheckMessageDelivery(bg, "", "", correctInterval, -1, messageID, detective.Filters{}, 1, limit)
 				So(err, ShouldBeNil)
 
-				messagesWrongMessageID, err := d.CheckMessageDelivery(bg, "", "", correctInterval, -1, wrongMessageID, 1, limit)
+				messagesWrongMessageID, err := d.CheckMessageDelivery(bg, "", "", correctInterval, -1, wrongMessageID, detective.Filters{}, 1, limit)
 				So(err, ShouldBeNil)
 
 				expectedTime := time.Date(year, time.January, 10, 16, 15, 30, 0, time.UTC)
@@ -200,6 +217,15 @@ func TestDetective(t *testing.T) {
 				So(messagesPartialSearch5, ShouldResemble, noDeliveriesPage1)
 				So(messagesPartialSearch6, ShouldResemble, noDeliveriesPage1)
 
+				So(messagesPatternSearch1, ShouldResemble, messagesLowerCase)
+				// a leading dot matches the domain and its subdomains, but `*.` only the subdomains
+				So(messagesPatternSearch2, ShouldResemble, messagesLowerCase)
+				So(messagesPatternSearch3, ShouldResemble, noDeliveriesPage1)
+
+				// LIKE special characters are matched literally
+				So(messagesPatternSearch4, ShouldResemble, noDeliveriesPage1)
+				So(messagesPatternSearch5, ShouldResemble, noDeliveriesPage1)
+
 				// Gitlab issue #572
 				So(messagesMailFromToAndQueueID, ShouldResemble, expectedResult)
 				So(messagesQueueID, ShouldResemble, expectedResult)
@@ -209,8 +235,76 @@ func TestDetective(t *testing.T) {
 				So(messagesWrongMessageID, ShouldResemble, noDeliveriesPage1)
 			})
 
+			Convey("Search filters", func() {
+				search := func(filters detective.Filters) *detective.MessagesPage {
+					messages, err := d.CheckMessageDelivery(bg, "sender@example.com", "", correctInterval, -1, "", filters, 1, limit)
+					So(err, ShouldBeNil)
+
+					return messages
+				}
+
+				expected := search(detective.Filters{})
+				So(expected.TotalResults, ShouldEqual, 1)
+
+				lastDeliveryID, err := d.LastDeliveryID(bg)
+				So(err, ShouldBeNil)
+				So(lastDeliveryID, ShouldBeGreaterThan, 0)
+
+				mustParseClientIP := func(s string) *net.IPNet {
+					network, err := detective.ParseClientIP(s)
+					So(err, ShouldBeNil)
+
+					return network
+				}
+
+				size := func(v int64) *int64 {
+					return &v
+				}
+
+				Convey("Matching", func() {
+					for _, filters := range []detective.Filters{
+						{ClientIP: mustParseClientIP("89.247.252.229")},
+						{ClientIP: mustParseClientIP("89.247.0.0/16")},
+						{Relay: "outlook.com"},
+						{Relay: "EXAMPLE-COM.mail.protection.outlook.com"},
+						{Relay: "example-*.mail.*"},
+						{DSNPrefix: "2"},
+						{DSNPrefix: "2.0.0"},
+						{MinSize: size(445), MaxSize: size(445)},
+						{MinSize: size(100)},
+						{ClientIP: mustParseClientIP("89.247.252.229"), Relay: "outlook.com", DSNPrefix: "2.0", MaxSize: size(1000)},
+						{AfterDeliveryID: lastDeliveryID - 1, UpToDeliveryID: lastDeliveryID},
+					} {
+						So(search(filters), ShouldResemble, expected)
+					}
+				})
+
+				Convey("Not matching", func() {
+					for _, filters := range []detective.Filters{
+						{ClientIP: mustParseClientIP("89.247.252.230")},
+						{ClientIP: mustParseClientIP("2001:db8::/32")},
+						{ClientIP: mustParseClientIP("10.0.0.0/8")},
+						{Relay: "look.com"},
+						{Relay: "gmail.com"},
+						{DSNPrefix: "5."},
+						{DSNPrefix: "2._"},
+						{MinSize: size(446)},
+						{MaxSize: size(444)},
+						{ClientIP: mustParseClientIP("89.247.252.229"), Relay: "gmail.com"},
+						{AfterDeliveryID: lastDeliveryID},
+					} {
+						So(search(filters), ShouldResemble, noDeliveriesPage1)
+					}
+				})
+
+				Convey("Invalid size range", func() {
+					_, err := d.CheckMessageDelivery(bg, "sender@example.com", "", correctInterval, -1, "", detective.Filters{MinSize: size(10), MaxSize: size(1)}, 1, limit)
+					So(errors.Is(err, detective.ErrInvalidFilter), ShouldBeTrue)
+				})
+			})
+
 			Convey("Page number too big", func() {
-				messages, err := d.CheckMessageDelivery(bg, "sender@example.com", "recipient@example.com", correctInterval, -1, "", 2, limit)
+				messages, err := d.CheckMessageDelivery(bg, "sender@example.com", "recipient@example.com", correctInterval, -1, "", detective.Filters{}, 2, limit)
 				So(err, ShouldBeNil)
 				So(messages, ShouldResemble, &detective.MessagesPage{2, 1, 1, 0, noDeliveries})
 			})
@@ -221,7 +315,7 @@ func TestDetective(t *testing.T) {
 					time.Date(year+1, time.December, 31, 0, 0, 0, 0, time.Local),
 				}
 
-				messages, err := d.CheckMessageDelivery(bg, "sender@example.com", "recipient@example.com", wrongInterval, -1, "", 1, limit)
+				messages, err := d.CheckMessageDelivery(bg, "sender@example.com", "recipient@example.com", wrongInterval, -1, "", detective.Filters{}, 1, limit)
 				So(err, ShouldBeNil)
 				So(messages, ShouldResemble, noDeliveriesPage1)
 			})
@@ -270,19 +364,19 @@ func TestDetective(t *testing.T) {
 			}
 
 			Convey("Multi-recipient someID search should yield correct number of delivery attempts, and recipients", func() {
-				messages, err := d.CheckMessageDelivery(bg, "", "", correctInterval, -1, queueID, 1, limit)
+				messages, err := d.CheckMessageDelivery(bg, "", "", correctInterval, -1, queueID, detective.Filters{}, 1, limit)
 				So(err, ShouldBeNil)
 				So(messages, ShouldResemble, expectedResult)
 			})
 
 			Convey("Searching for relay name should find delivery as well", func() {
-				messages, err := d.CheckMessageDelivery(bg, "", "outlook.com", correctInterval, -1, "", 1, limit)
+				messages, err := d.CheckMessageDelivery(bg, "", "outlook.com", correctInterval, -1, "", detective.Filters{}, 1, limit)
 				So(err, ShouldBeNil)
 				So(messages, ShouldResemble, expectedResult)
 			})
 
 			Convey("Searching for wrong relay should yield empty result", func() {
-				messages, err := d.CheckMessageDelivery(bg, "", "wrong.relay", correctInterval, -1, "", 1, limit)
+				messages, err := d.CheckMessageDelivery(bg, "", "wrong.relay", correctInterval, -1, "", detective.Filters{}, 1, limit)
 				So(err, ShouldBeNil)
 				So(messages, ShouldResemble, noDeliveriesPage1)
 			})
@@ -339,13 +433,13 @@ func TestDetective(t *testing.T) {
 			}
 
 			Convey("Message found", func() {
-				messages, err := d.CheckMessageDelivery(bg, "h-498b874f2bf0cf639807ad80e1@h-5e67b9b4406.com", "h-664d01@h-695da2287.com", correctInterval, -1, "", 1, limit)
+				messages, err := d.CheckMessageDelivery(bg, "h-498b874f2bf0cf639807ad80e1@h-5e67b9b4406.com", "h-664d01@h-695da2287.com", correctInterval, -1, "", detective.Filters{}, 1, limit)
 				So(err, ShouldBeNil)
 				So(messages, ShouldResemble, expectedResult)
 			})
 
 			Convey("Search for expired messages. Gitlab issue #616", func() {
-				messages, err := d.CheckMessageDelivery(bg, "", "", correctInterval, int(parser.ExpiredStatus), "", 1, limit)
+				messages, err := d.CheckMessageDelivery(bg, "", "", correctInterval, int(parser.ExpiredStatus), "", detective.Filters{}, 1, limit)
 				So(err, ShouldBeNil)
 				So(messages, ShouldResemble, expectedResult)
 			})
@@ -360,7 +454,7 @@ func TestDetective(t *testing.T) {
 			defer clear()
 
 			Convey("Message found", func() {
-				messages, err := d.CheckMessageDelivery(bg, "h-195704c@h-b7bed8eb24c5049d9.com", "h-493fac8f3@h-ea3f4afa.com", correctInterval, -1, "", 1, limit)
+				messages, err := d.CheckMessageDelivery(bg, "h-195704c@h-b7bed8eb24c5049d9.com", "h-493fac8f3@h-ea3f4afa.com", correctInterval, -1, "", detective.Filters{}, 1, limit)
 				So(err, ShouldBeNil)
 
 				So(messages, ShouldResemble, &detective.MessagesPage{
@@ -415,13 +509,13 @@ func TestDetective(t *testing.T) {
 			defer clear()
 
 			Convey("No status: return sent and received messages", func() {
-				messages, err := d.CheckMessageDelivery(bg, "", "", correctInterval, -1, "", 1, limit)
+				messages, err := d.CheckMessageDelivery(bg, "", "", correctInterval, -1, "", detective.Filters{}, 1, limit)
 				So(err, ShouldBeNil)
 				So(messages.TotalResults, ShouldEqual, 2)
 			})
 
 			Convey("Sent: return only sent messages", func() {
-				messages, err := d.CheckMessageDelivery(bg, "", "", correctInterval, int(parser.SentStatus), "", 1, limit)
+				messages, err := d.CheckMessageDelivery(bg, "", "", correctInterval, int(parser.SentStatus), "", detective.Filters{}, 1, limit)
 				So(err, ShouldBeNil)
 				So(messages.TotalResults, ShouldEqual, 1)
 				So(messages.Messages[0].Queue, ShouldEqual, "4FA51DFCAD")
@@ -429,7 +523,7 @@ func TestDetective(t *testing.T) {
 			})
 
 			Convey("Received: return only received messages", func() {
-				messages, err := d.CheckMessageDelivery(bg, "", "", correctInterval, int(parser.ReceivedStatus), "", 1, limit)
+				messages, err := d.CheckMessageDelivery(bg, "", "", correctInterval, int(parser.ReceivedStatus), "", detective.Filters{}, 1, limit)
 				So(err, ShouldBeNil)
 				So(messages.TotalResults, ShouldEqual, 1)
 				So(messages.Messages[0].Queue, ShouldEqual, "DF1C3EB916")
@@ -442,7 +536,7 @@ func TestDetective(t *testing.T) {
 			defer clear()
 
 			Convey("One reply is returned", func() {
-				messages, err := d.CheckMessageDelivery(bg, "", "", correctInterval, int(parser.RepliedStatus), "", 1, limit)
+				messages, err := d.CheckMessageDelivery(bg, "", "", correctInterval, int(parser.RepliedStatus), "", detective.Filters{}, 1, limit)
 				So(err, ShouldBeNil)
 				So(messages.TotalResults, ShouldEqual, 1)
 				So(messages.Messages[0].Queue, ShouldEqual, "ABD4E13D6B0")
@@ -450,6 +544,35 @@ func TestDetective(t *testing.T) {
 				So(messages.Messages[0].Entries[0].MailFrom, ShouldEqual, "original_recipient@example2.com")
 				So(messages.Messages[0].Entries[0].MailTo, ShouldResemble, []string{"original_sender@example1.com"})
 			})
+
+			Convey("The conversation is reachable from either message", func() {
+				for _, someID := range []string{"ABD4E13D6B0", "97B24177716", "dk17hoksdj1w1lwc3b7m2yhno-0@mailer.nylas.com"} {
+					thread, err := d.Thread(bg, someID)
+					So(err, ShouldBeNil)
+					So(thread.Size, ShouldEqual, 2)
+					So(thread.Truncated, ShouldBeFalse)
+					So(len(thread.Roots), ShouldEqual, 1)
+
+					root := thread.Roots[0]
+					So(root.MessageID, ShouldEqual, "dk17hoksdj1w1lwc3b7m2yhno-0@mailer.nylas.com")
+					So(len(root.Messages), ShouldEqual, 1)
+					So(root.Messages[0].Queue, ShouldEqual, "97B24177716")
+					So(root.Messages[0].Entries[0].Status, ShouldEqual, parser.SentStatus)
+
+					So(len(root.Replies), ShouldEqual, 1)
+
+					reply := root.Replies[0]
+					So(reply.MessageID, ShouldEqual, "CAJcL8KcWqtPC9aBbAViCcDpTCMGQFZ=vBPjhsqBiNOKR0hfU2w@mail.gmail.com")
+					So(reply.Messages[0].Queue, ShouldEqual, "ABD4E13D6B0")
+					So(reply.Messages[0].Entries[0].Status, ShouldEqual, parser.ReceivedStatus)
+					So(reply.Replies, ShouldBeEmpty)
+				}
+			})
+
+			Convey("Unknown message", func() {
+				_, err := d.Thread(bg, "unknown@example.com")
+				So(errors.Is(err, detective.ErrNoSuchMessage), ShouldBeTrue)
+			})
 		})
 	})
 
@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package workspace
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gitlab.com/lightmeter/controlcenter/erasure"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+)
+
+// EraseData removes or anonymises, in all databases, every piece of information about target.
+// The workspace must be running, as each database is changed by its own writer.
+func (ws *Workspace) EraseData(ctx context.Context, target erasure.Target) (erasure.Report, error) {
+	report := erasure.Report{Target: target.String(), Time: time.Now().In(time.UTC)}
+
+	// The tracker goes first, so that no new deliveries with the target are generated after the deliveries are erased
+	trackerEntries, err := ws.tracker.Erase(ctx, target)
+	if err != nil {
+		return erasure.Report{}, errorutil.Wrap(err)
+	}
+
+	deliveriesEntries, senderConnections, err := ws.deliveries.Erase(ctx, target)
+	if err != nil {
+		return erasure.Report{}, errorutil.Wrap(err)
+	}
+
+	rawLogsEntries, err := ws.rawLogs.Erase(ctx, target)
+	if err != nil {
+		return erasure.Report{}, errorutil.Wrap(err)
+	}
+
+	connStatsEntries, err := ws.connStats.EraseConnections(ctx, target, senderConnections)
+	if err != nil {
+		return erasure.Report{}, errorutil.Wrap(err)
+	}
+
+	rejectedRecipientsEntries, err := ws.connStats.EraseRejectedRecipients(ctx, target)
+	if err != nil {
+		return erasure.Report{}, errorutil.Wrap(err)
+	}
+
+	insightsEntries, err := ws.insightsEngine.EraseInsights(ctx, target)
+	if err != nil {
+		return erasure.Report{}, errorutil.Wrap(err)
+	}
+
+	for _, entries := range []erasure.Entries{trackerEntries, deliveriesEntries, rawLogsEntries, connStatsEntries, rejectedRecipientsEntries, insightsEntries} {
+		report.Entries = append(report.Entries, entries...)
+	}
+
+	log.Info().Msgf("Erased all data about %s, changing %d database rows", report.Target, report.TotalCount())
+
+	return report, nil
+}
@@ -0,0 +1,130 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package workspace
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gitlab.com/lightmeter/controlcenter/erasure"
+	"gitlab.com/lightmeter/controlcenter/insights/compromisedaccount"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/dbconn"
+	"gitlab.com/lightmeter/controlcenter/logeater/announcer"
+	"gitlab.com/lightmeter/controlcenter/pkg/runner"
+	"gitlab.com/lightmeter/controlcenter/util/postfixutil"
+	"gitlab.com/lightmeter/controlcenter/util/testutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+func countRows(pool *dbconn.RoPool, query string, args ...interface{}) int {
+	conn, release := pool.Acquire()
+	defer release()
+
+	var count int
+	So(conn.QueryRow(query, args...).Scan(&count), ShouldBeNil)
+
+	return count
+}
+
+func TestDataErasure(t *testing.T) {
+	Convey("Erase all data about an address", t, func() {
+		dir, clearDir := testutil.TempDir(t)
+		defer clearDir()
+
+		ws, err := NewWorkspace(dir, nil)
+		So(err, ShouldBeNil)
+
+		defer ws.Close()
+
+		// needed to prevent the insights execution of blocking
+		importAnnouncer, err := ws.ImportAnnouncer()
+		So(err, ShouldBeNil)
+		announcer.Skip(importAnnouncer)
+
+		done, cancel := runner.Run(ws)
+
+		stopped := false
+
+		stop := func() {
+			if !stopped {
+				stopped = true
+				cancel()
+				So(done(), ShouldBeNil)
+			}
+		}
+
+		defer stop()
+
+		postfixutil.ReadFromTestFile("../test_files/postfix_logs/individual_files/1_bounce_simple.log", ws.NewPublisher(), 2020, &timeutil.FakeClock{Time: timeutil.MustParseTime(`2020-12-31 00:00:00 +0000`)})
+
+		logsPool := ws.databases.Logs.RoConnPool
+		rawLogsPool := ws.databases.RawLogs.RoConnPool
+
+		// wait until all the deliveries are stored
+		for i := 0; i < 100 && countRows(logsPool, `select count(*) from deliveries`) < 2; i++ {
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		So(countRows(logsPool, `select count(*) from deliveries`), ShouldEqual, 2)
+		So(countRows(logsPool, `select count(*) from deliveries where sasl_username = 'user@sender.com'`), ShouldEqual, 2)
+
+		Convey("Erase address", func() {
+			_, err := ws.insightsEngine.MuteInsights(context.Background(), compromisedaccount.ContentType, map[string]string{"account": "user@sender.com"}, nil)
+			So(err, ShouldBeNil)
+
+			_, err = ws.insightsEngine.MuteInsights(context.Background(), compromisedaccount.ContentType, map[string]string{"account": "other@sender.com"}, nil)
+			So(err, ShouldBeNil)
+
+			target, err := erasure.ParseTarget("User@Sender.com")
+			So(err, ShouldBeNil)
+
+			report, err := ws.EraseData(context.Background(), target)
+			So(err, ShouldBeNil)
+
+			So(report.Target, ShouldEqual, "user@sender.com")
+
+			counts := map[string]int64{}
+
+			for _, e := range report.Entries {
+				counts[e.Database+"."+e.Table] = e.Count
+			}
+
+			So(counts["logs.deliveries"], ShouldEqual, 2)
+			So(counts["logs.log_lines_ref"], ShouldEqual, 2)
+			So(counts["logs.messageids"], ShouldEqual, 2)
+			So(counts["rawlogs.logs"], ShouldEqual, 8)
+			So(counts["connections.connections"], ShouldEqual, 1)
+			So(counts["insights.insights_suppressions"], ShouldEqual, 1)
+
+			stop()
+
+			So(countRows(logsPool, `select count(*) from deliveries`), ShouldEqual, 0)
+			So(countRows(logsPool, `select count(*) from log_lines_ref`), ShouldEqual, 0)
+			So(countRows(rawLogsPool, `select count(*) from logs where content like '%user@sender.com%'`), ShouldEqual, 0)
+			So(countRows(rawLogsPool, `select count(*) from logs where content like '%invalid.email@example.com%'`), ShouldEqual, 4)
+
+			// the lines are anonymised, but not removed
+			So(countRows(rawLogsPool, `select count(*) from logs`), ShouldEqual, 28)
+
+			// only the mute rule about the erased address is removed
+			So(countRows(ws.databases.Insights.RoConnPool, `select count(*) from insights_suppressions`), ShouldEqual, 1)
+		})
+
+		Convey("Erase domain not matching anything", func() {
+			target, err := erasure.ParseTarget("example.org")
+			So(err, ShouldBeNil)
+
+			report, err := ws.EraseData(context.Background(), target)
+			So(err, ShouldBeNil)
+			So(report.TotalCount(), ShouldEqual, 0)
+
+			stop()
+
+			So(countRows(logsPool, `select count(*) from deliveries`), ShouldEqual, 2)
+		})
+	})
+}
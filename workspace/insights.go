@@ -6,18 +6,28 @@ package workspace
 
 import (
 	"gitlab.com/lightmeter/controlcenter/dashboard"
+	"gitlab.com/lightmeter/controlcenter/detective"
 	"gitlab.com/lightmeter/controlcenter/detective/escalator"
 	blockedipsinsight "gitlab.com/lightmeter/controlcenter/insights/blockedips"
 	"gitlab.com/lightmeter/controlcenter/insights/blockedipssummary"
+	"gitlab.com/lightmeter/controlcenter/insights/compromisedaccount"
 	insightscore "gitlab.com/lightmeter/controlcenter/insights/core"
 	"gitlab.com/lightmeter/controlcenter/insights/detectiveescalation"
+	"gitlab.com/lightmeter/controlcenter/insights/directoryharvest"
+	"gitlab.com/lightmeter/controlcenter/insights/domainrbl"
 	localrblinsight "gitlab.com/lightmeter/controlcenter/insights/localrbl"
 	messagerblinsight "gitlab.com/lightmeter/controlcenter/insights/messagerbl"
 	newsfeedinsight "gitlab.com/lightmeter/controlcenter/insights/newsfeed"
+	"gitlab.com/lightmeter/controlcenter/insights/providerrate"
+	"gitlab.com/lightmeter/controlcenter/insights/savedsearch"
+	"gitlab.com/lightmeter/controlcenter/insights/stuckqueues"
+	"gitlab.com/lightmeter/controlcenter/insights/trafficanomaly"
 	"gitlab.com/lightmeter/controlcenter/intel/blockedips"
 	"gitlab.com/lightmeter/controlcenter/lmsqlite3/dbconn"
 	"gitlab.com/lightmeter/controlcenter/localrbl"
 	"gitlab.com/lightmeter/controlcenter/messagerbl"
+	"gitlab.com/lightmeter/controlcenter/rawlogsdb"
+	"net"
 	"time"
 )
 
@@ -32,9 +42,13 @@ func insightsOptions(
 	rblChecker localrbl.Checker,
 	rblDetector messagerbl.Stepper,
 	detectiveEscalator escalator.Stepper,
+	messageDetective detective.Detective,
 	deliverydbConnPool *dbconn.RoPool,
 	blockedipsChecker blockedips.Checker,
 	insightsFetcher insightscore.Fetcher,
+	rawLogsAccessor rawlogsdb.Accessor,
+	inFlightQueues stuckqueues.InFlightQueuesLister,
+	recipientProbes directoryharvest.RecipientProbesFetcher,
 ) insightscore.Options {
 	return insightscore.Options{
 		"logsConnPool": deliverydbConnPool,
@@ -45,6 +59,7 @@ func insightsOptions(
 			Checker:                     rblChecker,
 			RetryOnScanErrorInterval:    time.Second * 30,
 			MinTimeToGenerateNewInsight: oneWeek,
+			HistoryRetention:            oneDay * 365,
 		},
 
 		"messagerbl": messagerblinsight.Options{
@@ -63,6 +78,11 @@ func insightsOptions(
 			Escalator: detectiveEscalator,
 		},
 
+		"savedsearch": savedsearch.Options{
+			Detective:   messageDetective,
+			MaxMessages: 10,
+		},
+
 		"blockedips": blockedipsinsight.Options{
 			Checker:        blockedipsChecker,
 			PollInterval:   time.Minute * 2,
@@ -73,5 +93,62 @@ func insightsOptions(
 			TimeSpan:        oneWeek,
 			InsightsFetcher: insightsFetcher,
 		},
+
+		"trafficanomaly": trafficanomaly.Options{
+			BaselineWeeks:               4,
+			MinBaselineWeeks:            3,
+			Sensitivity:                 4,
+			MinVolume:                   20,
+			MinTimeToGenerateNewInsight: time.Hour * 6,
+		},
+
+		"providerrate": providerrate.Options{
+			CheckInterval:               time.Hour,
+			TimeSpan:                    time.Hour * 6,
+			MinVolume:                   100,
+			BounceRateThreshold:         0.1,
+			DeferredRateThreshold:       0.3,
+			MinTimeToGenerateNewInsight: oneDay,
+			MaxSamples:                  3,
+			LogLineFetcher:              rawLogsAccessor,
+		},
+
+		"stuckqueues": stuckqueues.Options{
+			CheckInterval:               time.Minute * 30,
+			MinAge:                      oneDay,
+			MaximalQueueLifetime:        oneDay * 5,
+			MinTimeToGenerateNewInsight: time.Hour * 12,
+			MaxQueues:                   10,
+			InFlightQueues:              inFlightQueues,
+		},
+
+		"compromisedaccount": compromisedaccount.Options{
+			CheckInterval:               time.Minute * 10,
+			TimeSpan:                    time.Hour,
+			BaselineTimeSpan:            oneWeek,
+			MinVolume:                   100,
+			SpikeFactor:                 5,
+			MinDistinctIPs:              5,
+			MinUnknownRecipientBounces:  20,
+			MinTimeToGenerateNewInsight: oneDay,
+		},
+
+		"directoryharvest": directoryharvest.Options{
+			CheckInterval:               time.Minute * 10,
+			TimeSpan:                    time.Hour,
+			MinProbedRecipients:         20,
+			MinTimeToGenerateNewInsight: oneDay,
+			Probes:                      recipientProbes,
+		},
+
+		"domainrbl": domainrbl.Options{
+			CheckInterval:   time.Hour * 6,
+			TimeSpan:        oneWeek,
+			Blocklists:      domainrbl.DefaultBlocklists,
+			Resolver:        net.DefaultResolver,
+			NumberOfWorkers: 5,
+			LookupTimeout:   time.Second * 5,
+			MaxDomains:      100,
+		},
 	}
 }
@@ -0,0 +1,180 @@
+// SPDX-FileCopyrightText: 2021 Lightmeter <hello@lightmeter.io>
+//
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package workspace
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+
+	"gitlab.com/lightmeter/controlcenter/dashboard"
+	insightsCore "gitlab.com/lightmeter/controlcenter/insights/core"
+	"gitlab.com/lightmeter/controlcenter/lmsqlite3/dbconn"
+	"gitlab.com/lightmeter/controlcenter/pkg/metrics"
+	"gitlab.com/lightmeter/controlcenter/pkg/postfix"
+	parser "gitlab.com/lightmeter/controlcenter/pkg/postfix/logparser"
+	"gitlab.com/lightmeter/controlcenter/tracking"
+	"gitlab.com/lightmeter/controlcenter/util/errorutil"
+	"gitlab.com/lightmeter/controlcenter/util/timeutil"
+)
+
+var (
+	deliveriesCounter = metrics.NewCounter("lightmeter_deliveries_total",
+		"Delivery attempts, by status and direction", "status", "direction")
+
+	logLinesCounter = metrics.NewCounter("lightmeter_log_lines_total",
+		"Log lines ingested, by payload type", "payload_type")
+
+	unsupportedLogLinesCounter = metrics.NewCounter("lightmeter_log_lines_unsupported_total",
+		"Log lines which could not be parsed, by process and daemon", "process", "daemon")
+
+	trackerInFlightGauge = metrics.NewGauge("lightmeter_tracker_in_flight",
+		"Connections, queues and results still being tracked, and actions waiting to be executed", "kind")
+
+	insightsGauge = metrics.NewGauge("lightmeter_insights",
+		"Insights stored, by category", "category")
+
+	databaseSizeGauge = metrics.NewGauge("lightmeter_database_size_bytes",
+		"Size of each SQLite database, including its write-ahead log", "database")
+
+	domainDeliveriesGauge = metrics.NewGauge("lightmeter_domain_deliveries_last_day",
+		"Delivery attempts to the busiest recipient domains in the last 24 hours, in total and by status", "domain", "status")
+)
+
+type metricsResultPublisher struct {
+	tracking.ResultPublisher
+}
+
+func directionLabel(d tracking.MessageDirection) string {
+	if d == tracking.MessageDirectionIncoming {
+		return "inbound"
+	}
+
+	return "outbound"
+}
+
+func (p metricsResultPublisher) Publish(r tracking.Result) {
+	if r[tracking.ResultStatusKey].IsNone() || r[tracking.ResultMessageDirectionKey].IsNone() {
+		p.ResultPublisher.Publish(r)
+		return
+	}
+
+	status := parser.SmtpStatus(r[tracking.ResultStatusKey].Int64())
+	direction := tracking.MessageDirection(r[tracking.ResultMessageDirectionKey].Int64())
+
+	deliveriesCounter.Inc(status.String(), directionLabel(direction))
+
+	p.ResultPublisher.Publish(r)
+}
+
+type metricsLogPublisher struct{}
+
+func (metricsLogPublisher) Publish(r postfix.Record) {
+	if r.Payload == nil {
+		unsupportedLogLinesCounter.Inc(r.Header.Process, r.Header.Daemon)
+		return
+	}
+
+	logLinesCounter.Inc(reflect.TypeOf(r.Payload).Name())
+}
+
+func databaseFileSize(filename string) (int64, error) {
+	var size int64
+
+	for _, f := range []string{filename, filename + "-wal"} {
+		info, err := os.Stat(f)
+		if err != nil && errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return 0, errorutil.Wrap(err)
+		}
+
+		size += info.Size()
+	}
+
+	return size, nil
+}
+
+func collectBusiestDomains(ctx context.Context, d dashboard.Dashboard, now time.Time) error {
+	interval := timeutil.TimeInterval{From: now.Add(-24 * time.Hour), To: now}
+
+	domainDeliveriesGauge.Reset()
+
+	for _, q := range []struct {
+		status string
+		f      func(context.Context, timeutil.TimeInterval) (dashboard.Pairs, error)
+	}{
+		{"all", d.TopBusiestDomains},
+		{"bounced", d.TopBouncedDomains},
+		{"deferred", d.TopDeferredDomains},
+	} {
+		pairs, err := q.f(ctx, interval)
+		if err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		for _, p := range pairs {
+			domain, _ := p.Key.(string)
+			count, _ := p.Value.(int)
+			domainDeliveriesGauge.Set(float64(count), domain, q.status)
+		}
+	}
+
+	return nil
+}
+
+// CollectMetrics updates the metrics which are computed only when requested, as they query the databases
+func (ws *Workspace) CollectMetrics(ctx context.Context) error {
+	inFlight, err := ws.tracker.InFlight(ctx)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	trackerInFlightGauge.Set(float64(inFlight.Connections), "connections")
+	trackerInFlightGauge.Set(float64(inFlight.Queues), "queues")
+	trackerInFlightGauge.Set(float64(inFlight.Results), "results")
+	trackerInFlightGauge.Set(float64(inFlight.PendingActions), "pending_actions")
+
+	insightsByCategory, err := insightsCore.CountByCategory(ctx, ws.databases.Insights.RoConnPool)
+	if err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	insightsGauge.Reset()
+
+	for c, count := range insightsByCategory {
+		insightsGauge.Set(float64(count), c.String())
+	}
+
+	for _, db := range []*dbconn.PooledPair{
+		ws.databases.Auth,
+		ws.databases.Connections,
+		ws.databases.Insights,
+		ws.databases.IntelCollector,
+		ws.databases.Logs,
+		ws.databases.LogTracker,
+		ws.databases.Master,
+		ws.databases.RawLogs,
+	} {
+		size, err := databaseFileSize(db.Filename)
+		if err != nil {
+			return errorutil.Wrap(err)
+		}
+
+		databaseSizeGauge.Set(float64(size), strings.TrimSuffix(path.Base(db.Filename), ".db"))
+	}
+
+	if err := collectBusiestDomains(ctx, ws.dashboard, time.Now()); err != nil {
+		return errorutil.Wrap(err)
+	}
+
+	return nil
+}
@@ -6,6 +6,7 @@ package workspace
 
 import (
 	. "github.com/smartystreets/goconvey/convey"
+	"gitlab.com/lightmeter/controlcenter/detective"
 	parser "gitlab.com/lightmeter/controlcenter/pkg/postfix/logparser"
 	"gitlab.com/lightmeter/controlcenter/util/timeutil"
 	"os"
@@ -30,7 +31,7 @@ func TestRelayedBounce(t *testing.T) {
 		d, clear := buildDetectiveFromReader(t, f, year)
 		defer clear()
 
-		messages, err := d.CheckMessageDelivery(bg, "", "", correctInterval, -1, "", 1, limit)
+		messages, err := d.CheckMessageDelivery(bg, "", "", correctInterval, -1, "", detective.Filters{}, 1, limit)
 		So(err, ShouldBeNil)
 
 		So(messages.TotalResults, ShouldEqual, 2)
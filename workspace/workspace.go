@@ -35,6 +35,7 @@ import (
 	"gitlab.com/lightmeter/controlcenter/notification"
 	"gitlab.com/lightmeter/controlcenter/notification/email"
 	"gitlab.com/lightmeter/controlcenter/notification/slack"
+	"gitlab.com/lightmeter/controlcenter/notification/webhook"
 	"gitlab.com/lightmeter/controlcenter/pkg/closers"
 	"gitlab.com/lightmeter/controlcenter/pkg/postfix"
 	"gitlab.com/lightmeter/controlcenter/pkg/runner"
@@ -59,7 +60,9 @@ type Workspace struct {
 	insightsFetcher         insightsCore.Fetcher
 	auth                    auth.RegistrarWithSessionKeys
 	rblDetector             *messagerbl.Detector
+	rblMatchers             *messagerbl.MatchersManager
 	rblChecker              localrbl.Checker
+	localIPs                *globalsettings.LocalIPsGetter
 	intelRunner             *intel.Runner
 	logsLineCountPublisher  postfix.Publisher
 	postfixVersionPublisher postfixversion.Publisher
@@ -207,7 +210,7 @@ func NewWorkspace(workspaceDirectory string, options *Options) (*Workspace, erro
 		return nil, errorutil.Wrap(err)
 	}
 
-	tracker, err := tracking.New(allDatabases.LogTracker, &tracking.FilteredPublisher{Publisher: deliveries.ResultsPublisher(), Filters: filters}, options.NodeTypeHandler)
+	tracker, err := tracking.New(allDatabases.LogTracker, &tracking.FilteredPublisher{Publisher: metricsResultPublisher{deliveries.ResultsPublisher()}, Filters: filters}, options.NodeTypeHandler)
 	if err != nil {
 		return nil, errorutil.Wrap(err)
 	}
@@ -261,22 +264,35 @@ func NewWorkspace(workspaceDirectory string, options *Options) (*Workspace, erro
 
 	notificationPolicies := notification.Policies{insights.DefaultNotificationPolicy{}}
 
+	webhookNotifier := webhook.New(notificationPolicies, m.Reader)
+
 	notifiers := map[string]notification.Notifier{
-		slack.SettingsKey: slack.New(notificationPolicies, m.Reader),
-		email.SettingsKey: email.New(notificationPolicies, m.Reader),
+		slack.SettingsKey:   slack.New(notificationPolicies, m.Reader),
+		email.SettingsKey:   email.New(notificationPolicies, m.Reader),
+		webhook.SettingsKey: webhookNotifier,
 	}
 
 	policy := &insights.DefaultNotificationPolicy{}
 
 	notificationCenter := notification.New(m.Reader, translators, policy, notifiers)
 
+	localIPs := globalsettings.NewLocalIPsGetter(m.Reader, deliveries)
+
 	rblChecker := localrbl.NewChecker(m.Reader, localrbl.Options{
+		IPs:              localIPs,
 		NumberOfWorkers:  10,
 		Lookup:           localrbl.RealLookup,
 		RBLProvidersURLs: localrbl.DefaultRBLs,
 	})
 
-	rblDetector := messagerbl.New(globalsettings.New(m.Reader))
+	rblDetector := messagerbl.New(localIPs)
+
+	rblMatchers := messagerbl.NewMatchersManager(rblDetector, settingsRunner.Writer(), m.Reader)
+
+	// the built-in matchers are still used if the ones in the settings are somehow invalid
+	if err := rblMatchers.UpdateDetectorFromSettings(context.Background()); err != nil {
+		errorutil.LogErrorf(err, "loading message RBL matchers from settings")
+	}
 
 	insightsAccessor, err := insights.NewAccessor(allDatabases.Insights)
 	if err != nil {
@@ -309,22 +325,22 @@ func NewWorkspace(workspaceDirectory string, options *Options) (*Workspace, erro
 		return nil, errorutil.Wrap(err)
 	}
 
-	insightsEngine, err := insights.NewEngine(
-		&m.Reader,
-		insightsAccessor,
-		insightsFetcher,
-		notificationCenter,
-		insightsOptions(dashboard, rblChecker, rblDetector, detectiveEscalator, allDatabases.Logs.RoConnPool, blockedipsChecker, insightsFetcher))
+	connStats, err := connectionstats.New(allDatabases.Connections, connectionstats.Options{RetentionDuration: options.DataRetentionDuration})
 	if err != nil {
 		return nil, errorutil.Wrap(err)
 	}
 
-	connStats, err := connectionstats.New(allDatabases.Connections, connectionstats.Options{RetentionDuration: options.DataRetentionDuration})
+	connectionStatsAccessor, err := connectionstats.NewAccessor(allDatabases.Connections.RoConnPool)
 	if err != nil {
 		return nil, errorutil.Wrap(err)
 	}
 
-	connectionStatsAccessor, err := connectionstats.NewAccessor(allDatabases.Connections.RoConnPool)
+	insightsEngine, err := insights.NewEngine(
+		&m.Reader,
+		insightsAccessor,
+		insightsFetcher,
+		notificationCenter,
+		insightsOptions(dashboard, rblChecker, rblDetector, detectiveEscalator, messageDetective, allDatabases.Logs.RoConnPool, blockedipsChecker, insightsFetcher, rawLogsAccessor, tracker, connectionStatsAccessor))
 	if err != nil {
 		return nil, errorutil.Wrap(err)
 	}
@@ -352,7 +368,9 @@ func NewWorkspace(workspaceDirectory string, options *Options) (*Workspace, erro
 		connStats:               connStats,
 		auth:                    auth,
 		rblDetector:             rblDetector,
+		rblMatchers:             rblMatchers,
 		rblChecker:              rblChecker,
+		localIPs:                localIPs,
 		dashboard:               dashboard,
 		detective:               messageDetective,
 		escalator:               detectiveEscalator,
@@ -372,6 +390,7 @@ func NewWorkspace(workspaceDirectory string, options *Options) (*Workspace, erro
 			tracker,
 			insightsEngine,
 			intelRunner,
+			webhookNotifier,
 			allDatabases,
 		),
 		NotificationCenter: notificationCenter,
@@ -390,6 +409,14 @@ func (ws *Workspace) InsightsEngine() *insights.Engine {
 	return ws.insightsEngine
 }
 
+func (ws *Workspace) LocalIPs() *globalsettings.LocalIPsGetter {
+	return ws.localIPs
+}
+
+func (ws *Workspace) MessageRBLMatchers() *messagerbl.MatchersManager {
+	return ws.rblMatchers
+}
+
 func (ws *Workspace) InsightsFetcher() insightsCore.Fetcher {
 	return ws.insightsFetcher
 }
@@ -486,6 +513,8 @@ func (ws *Workspace) NewPublisher() postfix.Publisher {
 		ws.logsLineCountPublisher,
 		ws.postfixVersionPublisher,
 		ws.connStats.Publisher(),
+		ws.rawLogs.LineStatsPublisher(),
+		metricsLogPublisher{},
 	}
 
 	flags, err := featureflags.GetSettings(context.Background(), ws.settingsMetaHandler.Reader)